@@ -1,7 +1,6 @@
 package adminapi
 
 import (
-	"github.com/go-oidfed/lib/cache"
 	"github.com/gofiber/fiber/v2"
 
 	"github.com/go-oidfed/lighthouse/internal"
@@ -17,7 +16,7 @@ func entityConfigurationCacheInvalidationMiddleware(c *fiber.Ctx) error {
 	}
 	status := c.Response().StatusCode()
 	if status >= 200 && status < 400 {
-		_ = cache.Delete(internal.CacheKeyEntityConfiguration)
+		_ = internal.DeleteCache(internal.CacheKeyEntityConfiguration)
 	}
 	return nil
 }
@@ -43,12 +42,12 @@ func subordinateStatementsCacheInvalidationMiddleware(subordinates model.Subordi
 			if id := c.Params("subordinateID"); id != "" && subordinates != nil {
 				info, err := subordinates.GetByDBID(id)
 				if err != nil || info == nil {
-					_ = cache.Clear(internal.CacheKeySubordinateStatement)
+					_ = internal.ClearCache(internal.CacheKeySubordinateStatement)
 				} else {
-					_ = cache.Delete(internal.SubordinateStatementCacheKey(info.EntityID))
+					_ = internal.DeleteCache(internal.SubordinateStatementCacheKey(info.EntityID))
 				}
 			} else {
-				_ = cache.Clear(internal.CacheKeySubordinateStatement)
+				_ = internal.ClearCache(internal.CacheKeySubordinateStatement)
 			}
 		}
 		return nil
@@ -0,0 +1,106 @@
+package adminapi
+
+import (
+	"errors"
+
+	oidfed "github.com/go-oidfed/lib"
+	"github.com/gofiber/fiber/v2"
+)
+
+// ErrInvalidCeremonyBundle is returned by
+// LighthouseController.ImportCeremonyBundle if a transfer bundle or one of its
+// statements cannot be parsed or verified
+var ErrInvalidCeremonyBundle = errors.New("invalid ceremony bundle")
+
+// CeremonyPayloads holds the unsigned statements that are pre-signed in an
+// offline key ceremony.
+type CeremonyPayloads struct {
+	EntityConfiguration   *oidfed.EntityStatementPayload  `json:"entity_configuration"`
+	SubordinateStatements []oidfed.EntityStatementPayload `json:"subordinate_statements"`
+}
+
+// CeremonyBundleImportRequest is the request body of the ceremony bundle
+// import endpoint.
+type CeremonyBundleImportRequest struct {
+	// Bundle is the signed transfer bundle produced by `lhcli ceremony sign`.
+	Bundle string `json:"bundle"`
+}
+
+// PresignedStatement is an entity configuration or subordinate statement
+// that was signed in an offline key ceremony.
+type PresignedStatement struct {
+	Subject   string `json:"sub"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+	JWT       string `json:"jwt,omitempty"`
+}
+
+// CeremonyImportResult summarizes an imported transfer bundle.
+type CeremonyImportResult struct {
+	Imported int `json:"imported"`
+	// Subjects lists the entities statements were imported for.
+	Subjects []string `json:"subjects"`
+	// ValidUntil is the latest expiration of the imported statements.
+	ValidUntil int64 `json:"valid_until"`
+}
+
+// ceremonyHandlers groups handlers for the key ceremony endpoints.
+type ceremonyHandlers struct {
+	controller LighthouseController
+}
+
+func (h *ceremonyHandlers) payloads(c *fiber.Ctx) error {
+	payloads, err := h.controller.CeremonyPayloads()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(oidfed.ErrorServerError(err.Error()))
+	}
+	return c.JSON(payloads)
+}
+
+func (h *ceremonyHandlers) importBundle(c *fiber.Ctx) error {
+	var req CeremonyBundleImportRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(oidfed.ErrorInvalidRequest("invalid body"))
+	}
+	if req.Bundle == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(oidfed.ErrorInvalidRequest("bundle is required"))
+	}
+	res, err := h.controller.ImportCeremonyBundle([]byte(req.Bundle))
+	if err != nil {
+		if errors.Is(err, ErrInvalidCeremonyBundle) {
+			return c.Status(fiber.StatusBadRequest).JSON(oidfed.ErrorInvalidRequest(err.Error()))
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(oidfed.ErrorServerError(err.Error()))
+	}
+	return c.Status(fiber.StatusCreated).JSON(res)
+}
+
+func (h *ceremonyHandlers) listStatements(c *fiber.Ctx) error {
+	statements, err := h.controller.PresignedStatements()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(oidfed.ErrorServerError(err.Error()))
+	}
+	for i := range statements {
+		statements[i].JWT = ""
+	}
+	return c.JSON(statements)
+}
+
+func (h *ceremonyHandlers) deleteStatements(c *fiber.Ctx) error {
+	if err := h.controller.DeletePresignedStatements(); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(oidfed.ErrorServerError(err.Error()))
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// registerCeremony wires the key ceremony endpoints.
+func registerCeremony(r fiber.Router, ctrl LighthouseController) {
+	if ctrl == nil {
+		return
+	}
+	h := &ceremonyHandlers{controller: ctrl}
+	r.Get("/ceremony/payloads", h.payloads)
+	r.Post("/ceremony/bundles", h.importBundle)
+	r.Get("/ceremony/statements", h.listStatements)
+	r.Delete("/ceremony/statements", h.deleteStatements)
+}
@@ -10,6 +10,7 @@ import (
 	oidfed "github.com/go-oidfed/lib"
 	"github.com/gofiber/fiber/v2"
 
+	"github.com/go-oidfed/lighthouse/internal/metadataschema"
 	"github.com/go-oidfed/lighthouse/storage"
 	smodel "github.com/go-oidfed/lighthouse/storage/model"
 )
@@ -193,9 +194,12 @@ func (h *lifetimeHandlers) put(c *fiber.Ctx) error {
 }
 
 // metadataHandlers groups handlers for metadata endpoints.
+// If validator is set, written metadata is validated against the metadata
+// schemas.
 type metadataHandlers struct {
-	store *metadataStore
-	kv    smodel.KeyValueStore
+	store     *metadataStore
+	kv        smodel.KeyValueStore
+	validator *metadataschema.Validator
 }
 
 func (h *metadataHandlers) getAll(c *fiber.Ctx) error {
@@ -218,6 +222,9 @@ func (h *metadataHandlers) putAll(c *fiber.Ctx) error {
 	if err := c.BodyParser(&meta); err != nil {
 		return badRequest(c, "invalid body")
 	}
+	if err := validateAllMetadata(h.validator, meta); err != nil {
+		return writeMetadataValidationError(c, err)
+	}
 	buf, _ := json.Marshal(meta)
 	if err := h.kv.Set(smodel.KeyValueScopeEntityConfiguration, smodel.KeyValueKeyMetadata, buf); err != nil {
 		return serverError(c, err.Error())
@@ -258,6 +265,9 @@ func (h *metadataHandlers) putClaim(c *fiber.Ctx) error {
 		meta[entityType] = make(map[string]json.RawMessage)
 	}
 	meta[entityType][claim] = c.Body()
+	if err = validateMetadata(h.validator, entityType, meta[entityType]); err != nil {
+		return writeMetadataValidationError(c, err)
+	}
 
 	if err := h.store.save(meta); err != nil {
 		return serverError(c, err.Error())
@@ -278,6 +288,8 @@ func (h *metadataHandlers) deleteClaim(c *fiber.Ctx) error {
 		delete(m, claim)
 		if len(m) == 0 {
 			delete(meta, entityType)
+		} else if err = validateMetadata(h.validator, entityType, m); err != nil {
+			return writeMetadataValidationError(c, err)
 		}
 		if err := h.store.save(meta); err != nil {
 			return serverError(c, err.Error())
@@ -312,6 +324,9 @@ func (h *metadataHandlers) putEntityType(c *fiber.Ctx) error {
 		return serverError(c, err.Error())
 	}
 	meta[entityType] = body
+	if err = validateMetadata(h.validator, entityType, body); err != nil {
+		return writeMetadataValidationError(c, err)
+	}
 
 	if err := h.store.save(meta); err != nil {
 		return serverError(c, err.Error())
@@ -334,6 +349,9 @@ func (h *metadataHandlers) postEntityType(c *fiber.Ctx) error {
 		meta[entityType] = make(map[string]json.RawMessage)
 	}
 	maps.Copy(meta[entityType], body)
+	if err = validateMetadata(h.validator, entityType, meta[entityType]); err != nil {
+		return writeMetadataValidationError(c, err)
+	}
 
 	if err := h.store.save(meta); err != nil {
 		return serverError(c, err.Error())
@@ -361,7 +379,7 @@ func (h *metadataHandlers) deleteEntityType(c *fiber.Ctx) error {
 
 func registerEntityConfiguration(
 	r fiber.Router, addClaimsStore smodel.AdditionalClaimsStore, kv smodel.KeyValueStore,
-	fedEntity oidfed.FederationEntity,
+	fedEntity oidfed.FederationEntity, metadataValidator *metadataschema.Validator,
 ) {
 	g := r.Group("/entity-configuration")
 	withCacheWipe := g.Use(entityConfigurationCacheInvalidationMiddleware)
@@ -372,8 +390,9 @@ func registerEntityConfiguration(
 	ltHandlers := &lifetimeHandlers{kv: kv}
 	metaStore := &metadataStore{kv: kv}
 	metaHandlers := &metadataHandlers{
-		store: metaStore,
-		kv:    kv,
+		store:     metaStore,
+		kv:        kv,
+		validator: metadataValidator,
 	}
 
 	// Entity configuration
@@ -131,7 +131,7 @@ func setupEntityConfigTestApp(
 	kv smodel.KeyValueStore,
 ) *fiber.App {
 	app := fiber.New()
-	registerEntityConfiguration(app, claims, kv, fedEntity)
+	registerEntityConfiguration(app, claims, kv, fedEntity, nil)
 	return app
 }
 
@@ -0,0 +1,98 @@
+package adminapi
+
+import (
+	"errors"
+
+	oidfed "github.com/go-oidfed/lib"
+	"github.com/gofiber/fiber/v2"
+
+	smodel "github.com/go-oidfed/lighthouse/storage/model"
+)
+
+var (
+	// ErrKeyNotFound is returned by LighthouseController.CompromiseKeys if a
+	// passed kid is not a key of the key set
+	ErrKeyNotFound = errors.New("key not found")
+	// ErrRotationNotSupported is returned by
+	// LighthouseController.CompromiseKeys if the KMS of the key set cannot
+	// generate replacement keys
+	ErrRotationNotSupported = errors.New("kms does not support rotation")
+)
+
+// KeyCompromiseRequest is the request body of the key compromise endpoint.
+type KeyCompromiseRequest struct {
+	// KIDs are the compromised keys; if empty, all active keys of the key set
+	// are treated as compromised.
+	KIDs []string `json:"kids"`
+	// Reason is recorded as revocation reason in the historical keys
+	// (default: "compromised").
+	Reason string `json:"reason"`
+}
+
+// KeyCompromiseResult summarizes an executed key compromise procedure.
+type KeyCompromiseResult struct {
+	Purpose     string                 `json:"purpose"`
+	Reason      string                 `json:"reason"`
+	RevokedKIDs []string               `json:"revoked_kids"`
+	NewKIDs     []string               `json:"new_kids"`
+	Superiors   []SuperiorNotification `json:"superiors"`
+}
+
+// SuperiorNotification is the result of notifying a superior about new keys
+// through its federation_jwks_update_trigger_endpoint.
+type SuperiorNotification struct {
+	EntityID string `json:"entity_id"`
+	Endpoint string `json:"endpoint,omitempty"`
+	Notified bool   `json:"notified"`
+	Error    string `json:"error,omitempty"`
+}
+
+// keyCompromiseHandlers groups handlers for the key compromise endpoints.
+type keyCompromiseHandlers struct {
+	controller LighthouseController
+	purpose    string
+}
+
+func (h *keyCompromiseHandlers) compromise(c *fiber.Ctx) error {
+	var req KeyCompromiseRequest
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(oidfed.ErrorInvalidRequest("invalid body"))
+		}
+	}
+	res, err := h.controller.CompromiseKeys(h.purpose, req)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrKeyNotFound):
+			return c.Status(fiber.StatusNotFound).JSON(oidfed.ErrorNotFound(err.Error()))
+		case errors.Is(err, ErrRotationNotSupported):
+			return c.Status(fiber.StatusBadRequest).JSON(oidfed.ErrorInvalidRequest(err.Error()))
+		default:
+			return c.Status(fiber.StatusInternalServerError).JSON(oidfed.ErrorServerError(err.Error()))
+		}
+	}
+	return c.JSON(res)
+}
+
+// registerKeyCompromise wires the key compromise endpoints of the federation
+// key set and of all key purposes with an own key set.
+func registerKeyCompromise(r fiber.Router, keyManagement KeyManagement, ctrl LighthouseController) {
+	if ctrl == nil {
+		return
+	}
+	h := &keyCompromiseHandlers{
+		controller: ctrl,
+		purpose:    smodel.SigningPurposeFederation,
+	}
+	r.Post("/kms/compromise", h.compromise)
+	for _, purpose := range smodel.SigningPurposes {
+		if _, ok := keyManagement.Purposes[purpose]; !ok {
+			continue
+		}
+		ph := &keyCompromiseHandlers{
+			controller: ctrl,
+			purpose:    purpose,
+		}
+		r.Post("/kms/purposes/"+purpose+"/compromise", ph.compromise)
+	}
+}
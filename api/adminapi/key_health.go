@@ -0,0 +1,84 @@
+package adminapi
+
+import (
+	oidfed "github.com/go-oidfed/lib"
+	"github.com/gofiber/fiber/v2"
+)
+
+// Kinds of keys evaluated by the key health monitoring.
+const (
+	// KeyHealthKindSigningKey is a KMS-managed signing key of LightHouse.
+	KeyHealthKindSigningKey = "signing_key"
+	// KeyHealthKindAPIKey is a public key added through the admin API.
+	KeyHealthKindAPIKey = "api_key"
+	// KeyHealthKindSubordinateKey is a key of an active subordinate's JWKS.
+	KeyHealthKindSubordinateKey = "subordinate_key"
+	// KeyHealthKindTrustAnchorKey is a key of a trust anchor's JWKS.
+	KeyHealthKindTrustAnchorKey = "trust_anchor_key"
+	// KeyHealthKindTrustMarkDelegation is a delegation JWT, either issued by
+	// LightHouse as trust mark owner or used by LightHouse as trust mark
+	// issuer.
+	KeyHealthKindTrustMarkDelegation = "trust_mark_delegation"
+)
+
+// Key health states.
+const (
+	KeyHealthStatusOK       = "ok"
+	KeyHealthStatusExpiring = "expiring"
+	KeyHealthStatusExpired  = "expired"
+)
+
+// KeyHealthEntry is the health of a single key.
+type KeyHealthEntry struct {
+	Kind string `json:"kind"`
+	// EntityID is the subordinate or trust anchor the key belongs to. For
+	// trust mark delegations it is the other party of the delegation, i.e.
+	// the trust mark issuer or the trust mark owner.
+	EntityID string `json:"entity_id,omitempty"`
+	// TrustMarkType is the trust mark type of a trust mark delegation.
+	TrustMarkType string `json:"trust_mark_type,omitempty"`
+	// Purpose is the key purpose of a signing key.
+	Purpose   string `json:"purpose,omitempty"`
+	KID       string `json:"kid,omitempty"`
+	ExpiresAt int64  `json:"expires_at,omitempty"`
+	Status    string `json:"status"`
+	Severity  string `json:"severity,omitempty"`
+	Message   string `json:"message,omitempty"`
+}
+
+// KeyHealthReport is the result of a key health check.
+type KeyHealthReport struct {
+	CheckedAt int64 `json:"checked_at"`
+	// Status is the highest severity of all issues, or "ok".
+	Status string `json:"status"`
+	// WarningThreshold and CriticalThreshold are the thresholds (in seconds)
+	// the expiries were evaluated against.
+	WarningThreshold  int64 `json:"warning_threshold"`
+	CriticalThreshold int64 `json:"critical_threshold"`
+	// SigningKeys lists all valid signing keys of LightHouse.
+	SigningKeys []KeyHealthEntry `json:"signing_keys"`
+	// Issues lists all keys that expire soon or are expired.
+	Issues []KeyHealthEntry `json:"issues"`
+}
+
+// keyHealthHandlers groups handlers for the key health endpoint.
+type keyHealthHandlers struct {
+	controller LighthouseController
+}
+
+func (h *keyHealthHandlers) get(c *fiber.Ctx) error {
+	report, err := h.controller.KeyHealth(c.QueryBool("refresh"))
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(oidfed.ErrorServerError(err.Error()))
+	}
+	return c.JSON(report)
+}
+
+// registerKeyHealth wires the key health endpoint.
+func registerKeyHealth(r fiber.Router, ctrl LighthouseController) {
+	if ctrl == nil {
+		return
+	}
+	h := &keyHealthHandlers{controller: ctrl}
+	r.Get("/health/keys", h.get)
+}
@@ -1,11 +1,13 @@
 package adminapi
 
 import (
+	"encoding/json"
 	"errors"
 	"slices"
 	"strconv"
 	"strings"
 	"time"
+	"unicode"
 
 	oidfed "github.com/go-oidfed/lib"
 	"github.com/go-oidfed/lib/jwx"
@@ -17,6 +19,7 @@ import (
 	"github.com/lestrrat-go/jwx/v4/jwk"
 	"github.com/zachmann/go-utils/duration"
 
+	"github.com/go-oidfed/lighthouse/internal/keyrollover"
 	"github.com/go-oidfed/lighthouse/storage"
 	smodel "github.com/go-oidfed/lighthouse/storage/model"
 )
@@ -28,12 +31,18 @@ type KeyManagement struct {
 	KMSManagedPKs public.PublicKeyStorage
 	BasicKeys     kms.BasicKeyManagementSystem
 	Keys          kms.KeyManagementSystem
+	// Purposes holds the key sets of key purposes that do not use the
+	// federation keys, e.g. smodel.SigningPurposeTrustMarks
+	Purposes map[string]KeyManagement
 }
 
 type kmsInfo struct {
+	Purpose     string                `json:"purpose"`
 	KMS         string                `json:"kms"`
 	Alg         string                `json:"alg"`
+	Algs        []string              `json:"algs"`
 	PendingAlg  string                `json:"pending_alg,omitempty"`
+	PendingAlgs []string              `json:"pending_algs,omitempty"`
 	AlgChangeAt *unixtime.Unixtime    `json:"alg_change_at,omitempty"`
 	RSAKeyLen   int                   `json:"rsa_key_len"`
 	Rotation    kms.KeyRotationConfig `json:"rotation"`
@@ -53,23 +62,37 @@ func addKeysToSet(set jwx.JWKS, keys public.PublicKeyEntryList) error {
 // jwksHandlers groups handlers for JWKS endpoints.
 type jwksHandlers struct {
 	keyManagement KeyManagement
+	kvStorage     smodel.KeyValueStore
 }
 
 func (h *jwksHandlers) getJWKS(c *fiber.Ctx) error {
 	set := jwx.NewJWKS()
-	addValidKeys := func(pkStorage public.PublicKeyStorage) error {
+	addValidKeys := func(purpose string, pkStorage public.PublicKeyStorage) error {
 		list, err := pkStorage.GetValid()
 		if err != nil {
 			return err
 		}
+		if purpose != "" {
+			// Keys of a staged rollover are not published before the
+			// publish stage
+			list, err = keyrollover.PublishedKeys(h.kvStorage, purpose, list)
+			if err != nil {
+				return err
+			}
+		}
 		return addKeysToSet(set, list)
 	}
-	if err := addValidKeys(h.keyManagement.KMSManagedPKs); err != nil {
+	if err := addValidKeys(smodel.SigningPurposeFederation, h.keyManagement.KMSManagedPKs); err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(oidfed.ErrorServerError(err.Error()))
 	}
-	if err := addValidKeys(h.keyManagement.APIManagedPKs); err != nil {
+	if err := addValidKeys("", h.keyManagement.APIManagedPKs); err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(oidfed.ErrorServerError(err.Error()))
 	}
+	for purpose, purposeKeys := range h.keyManagement.Purposes {
+		if err := addValidKeys(purpose, purposeKeys.KMSManagedPKs); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(oidfed.ErrorServerError(err.Error()))
+		}
+	}
 	return c.JSON(set)
 }
 
@@ -227,10 +250,11 @@ func (h *publicKeyHandlers) delete(c *fiber.Ctx) error {
 	return c.SendStatus(fiber.StatusNoContent)
 }
 
-// kmsHandlers groups handlers for KMS endpoints.
+// kmsHandlers groups handlers for KMS endpoints of a key purpose.
 type kmsHandlers struct {
 	keyManagement KeyManagement
 	kvStorage     smodel.KeyValueStore
+	purpose       string
 }
 
 func (h *kmsHandlers) getInfo(c *fiber.Ctx) error {
@@ -241,42 +265,103 @@ func (h *kmsHandlers) getInfo(c *fiber.Ctx) error {
 	return c.JSON(info)
 }
 
-func (h *kmsHandlers) putAlg(c *fiber.Ctx) error {
-	if h.keyManagement.Keys == nil {
-		return c.Status(fiber.StatusBadRequest).JSON(oidfed.ErrorInvalidRequest("kms does not support changing signing alg dynamically"))
+// kmsAlgsReq is the request to change the signing algorithms of a KMS
+type kmsAlgsReq struct {
+	Algs       []string `json:"algs"`
+	DefaultAlg string   `json:"default_alg"`
+}
+
+// parseKMSAlgsReq parses the body of a request to change the signing
+// algorithms. The body is either a JSON object (kmsAlgsReq), a JSON array of
+// algorithms, or a plain text list of algorithms separated by commas or
+// whitespace. If no default algorithm is given, the first algorithm is the
+// default.
+func parseKMSAlgsReq(body []byte) (req kmsAlgsReq, err error) {
+	body = []byte(strings.TrimSpace(string(body)))
+	switch {
+	case len(body) == 0:
+		return req, errors.New("empty body")
+	case body[0] == '{':
+		err = json.Unmarshal(body, &req)
+	case body[0] == '[':
+		err = json.Unmarshal(body, &req.Algs)
+	default:
+		req.Algs = strings.FieldsFunc(
+			string(body), func(r rune) bool {
+				return r == ',' || unicode.IsSpace(r)
+			},
+		)
+	}
+	if err != nil {
+		return req, errors.New("invalid body")
+	}
+	if req.DefaultAlg == "" && len(req.Algs) > 0 {
+		req.DefaultAlg = req.Algs[0]
 	}
-	alg := strings.TrimSpace(string(c.Body()))
-	if alg == "" {
-		return c.Status(fiber.StatusBadRequest).JSON(oidfed.ErrorInvalidRequest("empty body"))
+	if req.DefaultAlg == "" {
+		return req, errors.New("no algorithm given")
 	}
+	return req, nil
+}
+
+// lookupSigningAlg returns the supported jwa.SignatureAlgorithm for the
+// passed name
+func lookupSigningAlg(alg string) (jwa.SignatureAlgorithm, error) {
 	jwaAlg, ok := jwa.LookupSignatureAlgorithm(alg)
 	if !ok {
-		return c.Status(fiber.StatusBadRequest).JSON(oidfed.ErrorInvalidRequest("invalid algorithm"))
+		return jwaAlg, errors.New("invalid algorithm: " + alg)
 	}
 	if !slices.Contains(jwx.SupportedAlgsStrings(), alg) {
-		return c.Status(fiber.StatusBadRequest).JSON(oidfed.ErrorInvalidRequest("unsupported algorithm"))
+		return jwaAlg, errors.New("unsupported algorithm: " + alg)
+	}
+	return jwaAlg, nil
+}
+
+func (h *kmsHandlers) putAlg(c *fiber.Ctx) error {
+	if h.keyManagement.Keys == nil {
+		return c.Status(fiber.StatusBadRequest).JSON(oidfed.ErrorInvalidRequest("kms does not support changing signing alg dynamically"))
+	}
+	req, err := parseKMSAlgsReq(c.Body())
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(oidfed.ErrorInvalidRequest(err.Error()))
+	}
+	defaultAlg, err := lookupSigningAlg(req.DefaultAlg)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(oidfed.ErrorInvalidRequest(err.Error()))
+	}
+	jwaAlgs := []jwa.SignatureAlgorithm{defaultAlg}
+	var additionalAlgs []string
+	for _, alg := range req.Algs {
+		jwaAlg, err := lookupSigningAlg(alg)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(oidfed.ErrorInvalidRequest(err.Error()))
+		}
+		if slices.Contains(jwaAlgs, jwaAlg) {
+			continue
+		}
+		jwaAlgs = append(jwaAlgs, jwaAlg)
+		additionalAlgs = append(additionalAlgs, jwaAlg.String())
 	}
 
 	ecLifetime, err := storage.GetEntityConfigurationLifetime(h.kvStorage)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(oidfed.ErrorServerError(err.Error()))
 	}
-	rot, err := storage.GetKeyRotation(h.kvStorage)
+	rot, err := storage.GetKeyRotationForPurpose(h.kvStorage, h.purpose)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(oidfed.ErrorServerError(err.Error()))
 	}
 	switchTime := unixtime.Unixtime{Time: time.Now().Add(ecLifetime).Add(10 * time.Second)}
-	if err = h.keyManagement.Keys.ChangeAlgsAt(
-		[]jwa.SignatureAlgorithm{jwaAlg}, switchTime, rot.Overlap.Duration(),
-	); err != nil {
+	if err = h.keyManagement.Keys.ChangeAlgsAt(jwaAlgs, switchTime, rot.Overlap.Duration()); err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(oidfed.ErrorServerError(err.Error()))
 	}
-	if err = h.keyManagement.Keys.ChangeDefaultAlgorithmAt(jwaAlg, switchTime); err != nil {
+	if err = h.keyManagement.Keys.ChangeDefaultAlgorithmAt(defaultAlg, switchTime); err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(oidfed.ErrorServerError(err.Error()))
 	}
-	if err = storage.SetSigningAlg(
-		h.kvStorage, storage.SigningAlgWithNbf{
-			SigningAlg: alg,
+	if err = storage.SetSigningAlgForPurpose(
+		h.kvStorage, h.purpose, storage.SigningAlgWithNbf{
+			SigningAlg: defaultAlg.String(),
+			Algs:       additionalAlgs,
 			Nbf:        &switchTime,
 		},
 	); err != nil {
@@ -297,7 +382,7 @@ func (h *kmsHandlers) putRSAKeyLen(c *fiber.Ctx) error {
 	if err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(oidfed.ErrorInvalidRequest("invalid body: expected integer"))
 	}
-	if err := storage.SetRSAKeyLen(h.kvStorage, rsaKeyLen); err != nil {
+	if err := storage.SetRSAKeyLenForPurpose(h.kvStorage, h.purpose, rsaKeyLen); err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(oidfed.ErrorServerError(err.Error()))
 	}
 	if err := h.keyManagement.Keys.ChangeRSAKeyLength(rsaKeyLen); err != nil {
@@ -314,11 +399,118 @@ func (h *kmsHandlers) getRotation(c *fiber.Ctx) error {
 	if h.keyManagement.Keys == nil {
 		return c.Status(fiber.StatusBadRequest).JSON(oidfed.ErrorInvalidRequest("kms does not support rotation"))
 	}
-	rot, err := storage.GetKeyRotation(h.kvStorage)
+	rot, err := storage.GetKeyRotationForPurpose(h.kvStorage, h.purpose)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(oidfed.ErrorServerError(err.Error()))
+	}
+	rollover, err := keyrollover.Get(h.kvStorage, h.purpose)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(oidfed.ErrorServerError(err.Error()))
+	}
+	return c.JSON(
+		kmsRotationInfo{
+			KeyRotationConfig: rot,
+			Rollover:          rollover,
+		},
+	)
+}
+
+// kmsRotationInfo is the key rotation config of a key set together with its
+// latest staged key rollover
+type kmsRotationInfo struct {
+	kms.KeyRotationConfig
+	Rollover *keyrollover.Rollover `json:"rollover,omitempty"`
+}
+
+// kmsRolloverReq is the request body for scheduling a staged key rollover;
+// all stage times are optional
+type kmsRolloverReq struct {
+	GenerateAt *unixtime.Unixtime `json:"generate_at"`
+	PublishAt  *unixtime.Unixtime `json:"publish_at"`
+	ActivateAt *unixtime.Unixtime `json:"activate_at"`
+	RetireAt   *unixtime.Unixtime `json:"retire_at"`
+}
+
+func timeOrDefault(t *unixtime.Unixtime, def time.Time) time.Time {
+	if t == nil || t.IsZero() {
+		return def
+	}
+	return t.Time
+}
+
+// scheduleRollover schedules a staged key rollover. Unset stage times
+// default to: generate now, publish right after generation, activate after
+// the key announcement lead time, and retire after the rotation overlap.
+func (h *kmsHandlers) scheduleRollover(c *fiber.Ctx) error {
+	if h.keyManagement.Keys == nil {
+		return c.Status(fiber.StatusBadRequest).JSON(oidfed.ErrorInvalidRequest("kms does not support rotation"))
+	}
+	var req kmsRolloverReq
+	if len(c.Body()) > 0 {
+		if err := json.Unmarshal(c.Body(), &req); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(oidfed.ErrorInvalidRequest("invalid body"))
+		}
+	}
+	rotation, err := storage.GetKeyRotationForPurpose(h.kvStorage, h.purpose)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(oidfed.ErrorServerError(err.Error()))
+	}
+	leadTime, err := rotation.KeyAnnouncementLeadTimeDuration()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(oidfed.ErrorServerError(err.Error()))
+	}
+	ecLifetime, err := storage.GetEntityConfigurationLifetime(h.kvStorage)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(oidfed.ErrorServerError(err.Error()))
+	}
+	generateAt := timeOrDefault(req.GenerateAt, time.Now())
+	publishAt := timeOrDefault(req.PublishAt, generateAt)
+	activateAt := timeOrDefault(req.ActivateAt, publishAt.Add(leadTime))
+	retireAt := timeOrDefault(req.RetireAt, activateAt.Add(rotation.Overlap.Duration()))
+	rollover, err := keyrollover.New(generateAt, publishAt, activateAt, retireAt)
 	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(oidfed.ErrorInvalidRequest(err.Error()))
+	}
+	if activateAt.Sub(publishAt) < ecLifetime {
+		return c.Status(fiber.StatusBadRequest).JSON(
+			oidfed.ErrorInvalidRequest(
+				"new keys must be published for at least the entity configuration lifetime (" +
+					ecLifetime.String() + ") before they are activated",
+			),
+		)
+	}
+	if err = keyrollover.Schedule(h.kvStorage, h.purpose, rollover); err != nil {
+		if errors.Is(err, keyrollover.ErrRolloverPending) {
+			return c.Status(fiber.StatusConflict).JSON(oidfed.ErrorInvalidRequest(err.Error()))
+		}
 		return c.Status(fiber.StatusInternalServerError).JSON(oidfed.ErrorServerError(err.Error()))
 	}
-	return c.JSON(rot)
+	return c.Status(fiber.StatusCreated).JSON(rollover)
+}
+
+// cancelRollover cancels the pending staged key rollover
+func (h *kmsHandlers) cancelRollover(c *fiber.Ctx) error {
+	if h.keyManagement.Keys == nil {
+		return c.Status(fiber.StatusBadRequest).JSON(oidfed.ErrorInvalidRequest("kms does not support rotation"))
+	}
+	rollover, err := keyrollover.Cancel(
+		h.kvStorage, keyrollover.KeySet{
+			Purpose: h.purpose,
+			Keys:    h.keyManagement.Keys,
+			PKs:     h.keyManagement.KMSManagedPKs,
+		}, time.Now(),
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, keyrollover.ErrNoRollover):
+			return c.Status(fiber.StatusNotFound).JSON(oidfed.ErrorNotFound(err.Error()))
+		case errors.Is(err, keyrollover.ErrNotCancellable):
+			return c.Status(fiber.StatusConflict).JSON(oidfed.ErrorInvalidRequest(err.Error()))
+		default:
+			return c.Status(fiber.StatusInternalServerError).JSON(oidfed.ErrorServerError(err.Error()))
+		}
+	}
+	return c.JSON(rollover)
 }
 
 func (h *kmsHandlers) putRotation(c *fiber.Ctx) error {
@@ -329,7 +521,7 @@ func (h *kmsHandlers) putRotation(c *fiber.Ctx) error {
 	if err := c.BodyParser(&cfg); err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(oidfed.ErrorInvalidRequest("invalid body"))
 	}
-	if err := storage.SetKeyRotation(h.kvStorage, cfg); err != nil {
+	if err := storage.SetKeyRotationForPurpose(h.kvStorage, h.purpose, cfg); err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(oidfed.ErrorServerError(err.Error()))
 	}
 	if err := h.keyManagement.Keys.ChangeKeyRotationConfig(cfg); err != nil {
@@ -342,7 +534,7 @@ func (h *kmsHandlers) patchRotation(c *fiber.Ctx) error {
 	if h.keyManagement.Keys == nil {
 		return c.Status(fiber.StatusBadRequest).JSON(oidfed.ErrorInvalidRequest("kms does not support rotation"))
 	}
-	current, err := storage.GetKeyRotation(h.kvStorage)
+	current, err := storage.GetKeyRotationForPurpose(h.kvStorage, h.purpose)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(oidfed.ErrorServerError(err.Error()))
 	}
@@ -365,7 +557,7 @@ func (h *kmsHandlers) patchRotation(c *fiber.Ctx) error {
 	if v, ok := patch["key_announcement_lead_time_ec_multiplier"].(float64); ok {
 		current.KeyAnnouncementLeadTimeECMultiplier = v
 	}
-	if err = storage.SetKeyRotation(h.kvStorage, current); err != nil {
+	if err = storage.SetKeyRotationForPurpose(h.kvStorage, h.purpose, current); err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(oidfed.ErrorServerError(err.Error()))
 	}
 	if err = h.keyManagement.Keys.ChangeKeyRotationConfig(current); err != nil {
@@ -386,40 +578,72 @@ func (h *kmsHandlers) triggerRotate(c *fiber.Ctx) error {
 	return c.SendStatus(fiber.StatusAccepted)
 }
 
+func (h *kmsHandlers) getJWKS(c *fiber.Ctx) error {
+	list, err := h.keyManagement.KMSManagedPKs.GetValid()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(oidfed.ErrorServerError(err.Error()))
+	}
+	set := jwx.NewJWKS()
+	if err = addKeysToSet(set, list); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(oidfed.ErrorServerError(err.Error()))
+	}
+	return c.JSON(set)
+}
+
 func (h *kmsHandlers) buildKMSInfo() (*kmsInfo, error) {
 	alg := h.keyManagement.BasicKeys.GetDefaultAlg()
-	rotation, err := storage.GetKeyRotation(h.kvStorage)
+	rotation, err := storage.GetKeyRotationForPurpose(h.kvStorage, h.purpose)
 	if err != nil {
 		return nil, err
 	}
-	rsaKeyLen, err := storage.GetRSAKeyLen(h.kvStorage)
+	rsaKeyLen, err := storage.GetRSAKeyLenForPurpose(h.kvStorage, h.purpose)
 	if err != nil {
 		return nil, err
 	}
+	algs := algStrings(h.keyManagement.BasicKeys.GetAlgs())
 	var pendingAlg string
+	var pendingAlgs []string
 	var pendingEffective *unixtime.Unixtime
 	if h.keyManagement.Keys != nil {
-		_, pending := h.keyManagement.Keys.GetPendingChanges()
+		pendingAlgChange, pending := h.keyManagement.Keys.GetPendingChanges()
+		if pendingAlgChange != nil {
+			pendingAlgs = algStrings(pendingAlgChange.Algs)
+			pendingEffective = &pendingAlgChange.EffectiveAt
+		}
 		if pending != nil {
 			pendingAlg = pending.Alg.String()
 			pendingEffective = &pending.EffectiveAt
 		}
 	}
 	return &kmsInfo{
+		Purpose:     h.purpose,
 		KMS:         h.keyManagement.KMS,
 		Alg:         alg.String(),
+		Algs:        algs,
 		PendingAlg:  pendingAlg,
+		PendingAlgs: pendingAlgs,
 		AlgChangeAt: pendingEffective,
 		RSAKeyLen:   rsaKeyLen,
 		Rotation:    rotation,
 	}, nil
 }
 
+func algStrings(algs []jwa.SignatureAlgorithm) []string {
+	s := make([]string, len(algs))
+	for i, alg := range algs {
+		s[i] = alg.String()
+	}
+	return s
+}
+
 // registerKeys wires routes for managing public keys and KMS-related endpoints.
 func registerKeys(
 	r fiber.Router, keyManagement KeyManagement, kvStorage smodel.KeyValueStore, storages smodel.Backends,
 ) {
-	jwksH := &jwksHandlers{keyManagement: keyManagement}
+	jwksH := &jwksHandlers{
+		keyManagement: keyManagement,
+		kvStorage:     kvStorage,
+	}
 	pkH := &publicKeyHandlers{
 		apiManagedPKs: keyManagement.APIManagedPKs,
 		storages:      storages,
@@ -428,6 +652,7 @@ func registerKeys(
 	kmsH := &kmsHandlers{
 		keyManagement: keyManagement,
 		kvStorage:     kvStorage,
+		purpose:       smodel.SigningPurposeFederation,
 	}
 
 	// Published JWKS
@@ -444,12 +669,49 @@ func registerKeys(
 	withCacheWipe.Delete("/:kid", pkH.delete)
 
 	// KMS routes
-	kmsWithCacheWipe := r.Use(entityConfigurationCacheInvalidationMiddleware)
-	r.Get("/kms", kmsH.getInfo)
-	kmsWithCacheWipe.Put("/kms/alg", kmsH.putAlg)
-	kmsWithCacheWipe.Put("/kms/rsa-key-len", kmsH.putRSAKeyLen)
-	r.Get("/kms/rotation", kmsH.getRotation)
-	kmsWithCacheWipe.Put("/kms/rotation", kmsH.putRotation)
-	kmsWithCacheWipe.Patch("/kms/rotation", kmsH.patchRotation)
-	kmsWithCacheWipe.Post("/kms/rotate", kmsH.triggerRotate)
+	registerKMSRoutes(r.Group("/kms"), kmsH)
+
+	// KMS routes of key purposes with an own key set
+	purposeHandlers := make([]*kmsHandlers, 0, len(keyManagement.Purposes))
+	for _, purpose := range smodel.SigningPurposes {
+		purposeKeys, ok := keyManagement.Purposes[purpose]
+		if !ok {
+			continue
+		}
+		h := &kmsHandlers{
+			keyManagement: purposeKeys,
+			kvStorage:     kvStorage,
+			purpose:       purpose,
+		}
+		purposeHandlers = append(purposeHandlers, h)
+		registerKMSRoutes(r.Group("/kms/purposes/"+purpose), h)
+	}
+	r.Get(
+		"/kms/purposes", func(c *fiber.Ctx) error {
+			infos := make([]*kmsInfo, 0, len(purposeHandlers))
+			for _, h := range purposeHandlers {
+				info, err := h.buildKMSInfo()
+				if err != nil {
+					return c.Status(fiber.StatusInternalServerError).JSON(oidfed.ErrorServerError(err.Error()))
+				}
+				infos = append(infos, info)
+			}
+			return c.JSON(infos)
+		},
+	)
+}
+
+// registerKMSRoutes wires the KMS routes of a key purpose to r.
+func registerKMSRoutes(r fiber.Router, h *kmsHandlers) {
+	withCacheWipe := r.Use(entityConfigurationCacheInvalidationMiddleware)
+	r.Get("/", h.getInfo)
+	r.Get("/jwks", h.getJWKS)
+	withCacheWipe.Put("/alg", h.putAlg)
+	withCacheWipe.Put("/rsa-key-len", h.putRSAKeyLen)
+	r.Get("/rotation", h.getRotation)
+	withCacheWipe.Put("/rotation", h.putRotation)
+	withCacheWipe.Patch("/rotation", h.patchRotation)
+	withCacheWipe.Post("/rotate", h.triggerRotate)
+	r.Post("/rollover", h.scheduleRollover)
+	withCacheWipe.Delete("/rollover", h.cancelRollover)
 }
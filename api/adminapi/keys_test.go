@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"slices"
 	"strings"
 	"testing"
 	"time"
@@ -30,6 +31,10 @@ func (*mockBasicKMS) GetDefaultAlg() jwa.SignatureAlgorithm {
 	return jwa.ES256()
 }
 
+func (*mockBasicKMS) GetAlgs() []jwa.SignatureAlgorithm {
+	return []jwa.SignatureAlgorithm{jwa.ES256()}
+}
+
 // mockFullKMS implements kms.KeyManagementSystem for testing endpoints that require
 // the full KMS interface (rotation, algorithm changes, etc.).
 type mockFullKMS struct {
@@ -871,6 +876,109 @@ func TestPutKMSAlg(t *testing.T) {
 	})
 }
 
+// mockFullKMSRecordingAlgs records the algorithms passed to ChangeAlgsAt and
+// ChangeDefaultAlgorithmAt.
+type mockFullKMSRecordingAlgs struct {
+	mockFullKMS
+	algs       []jwa.SignatureAlgorithm
+	defaultAlg jwa.SignatureAlgorithm
+}
+
+func (m *mockFullKMSRecordingAlgs) ChangeAlgsAt(
+	algs []jwa.SignatureAlgorithm, _ unixtime.Unixtime, _ time.Duration,
+) error {
+	m.algs = algs
+	return nil
+}
+
+func (m *mockFullKMSRecordingAlgs) ChangeDefaultAlgorithmAt(alg jwa.SignatureAlgorithm, _ unixtime.Unixtime) error {
+	m.defaultAlg = alg
+	return nil
+}
+
+func TestPutKMSAlgs(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name        string
+		body        string
+		wantStatus  int
+		wantDefault jwa.SignatureAlgorithm
+		wantAlgs    []jwa.SignatureAlgorithm
+	}{
+		{
+			name:        "TextList",
+			body:        "ES256, RS256 PS256",
+			wantStatus:  http.StatusOK,
+			wantDefault: jwa.ES256(),
+			wantAlgs:    []jwa.SignatureAlgorithm{jwa.ES256(), jwa.RS256(), jwa.PS256()},
+		},
+		{
+			name:        "JSONArray",
+			body:        `["PS256","ES256","PS256"]`,
+			wantStatus:  http.StatusOK,
+			wantDefault: jwa.PS256(),
+			wantAlgs:    []jwa.SignatureAlgorithm{jwa.PS256(), jwa.ES256()},
+		},
+		{
+			name:        "JSONObjectWithDefault",
+			body:        `{"algs":["ES256","RS256"],"default_alg":"RS256"}`,
+			wantStatus:  http.StatusOK,
+			wantDefault: jwa.RS256(),
+			wantAlgs:    []jwa.SignatureAlgorithm{jwa.RS256(), jwa.ES256()},
+		},
+		{
+			name:       "InvalidAlgInList",
+			body:       `["ES256","INVALID-ALG"]`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "EmptyList",
+			body:       `[]`,
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				t.Parallel()
+				store := newTestStorage(t)
+				keys := &mockFullKMSRecordingAlgs{}
+				km := KeyManagement{
+					KMS:       "mock-kms",
+					BasicKeys: &mockBasicKMS{},
+					Keys:      keys,
+				}
+				app := fiber.New()
+				registerKeys(app, km, store.KeyValue(), model.Backends{KV: store.KeyValue()})
+
+				req := httptest.NewRequest("PUT", "/kms/alg", strings.NewReader(tt.body))
+				resp, respBody := doRequest(t, app, req)
+				requireStatus(t, resp, respBody, tt.wantStatus)
+				if tt.wantStatus != http.StatusOK {
+					return
+				}
+
+				if keys.defaultAlg != tt.wantDefault {
+					t.Errorf("Expected default alg %s, got %s", tt.wantDefault, keys.defaultAlg)
+				}
+				if !slices.Equal(keys.algs, tt.wantAlgs) {
+					t.Errorf("Expected algs %v, got %v", tt.wantAlgs, keys.algs)
+				}
+				var stored []storage.SigningAlgWithNbf
+				if _, err := store.KeyValue().GetAs(
+					model.KeyValueScopeSigning, model.KeyValueKeyAlg, &stored,
+				); err != nil {
+					t.Fatalf("Failed to read stored algs: %v", err)
+				}
+				if len(stored) != 1 || stored[0].SigningAlg != tt.wantDefault.String() ||
+					len(stored[0].Algs) != len(tt.wantAlgs)-1 {
+					t.Errorf("Unexpected stored algs: %+v", stored)
+				}
+			},
+		)
+	}
+}
+
 func TestPutKMSRSAKeyLen(t *testing.T) {
 	t.Parallel()
 	t.Run("Success", func(t *testing.T) {
@@ -1305,3 +1413,197 @@ func TestPostKMSRotateAll(t *testing.T) {
 		assertStatus(t, resp, bodyBytes, http.StatusBadRequest)
 	})
 }
+
+func TestKMSRollover(t *testing.T) {
+	t.Parallel()
+	setup := func(t *testing.T) *fiber.App {
+		t.Helper()
+		store := newTestStorage(t)
+		km := KeyManagement{
+			KMS:  "mock-kms",
+			Keys: &mockFullKMS{},
+		}
+		app := fiber.New()
+		backends := model.Backends{KV: store.KeyValue()}
+		registerKeys(app, km, store.KeyValue(), backends)
+		return app
+	}
+
+	t.Run("ScheduleAndCancel", func(t *testing.T) {
+		t.Parallel()
+		app := setup(t)
+
+		req := httptest.NewRequest("POST", "/kms/rollover", http.NoBody)
+		resp, respBody := doRequest(t, app, req)
+		requireStatus(t, resp, respBody, http.StatusCreated)
+		var rollover struct {
+			Status string `json:"status"`
+			Steps  []struct {
+				Stage       string  `json:"stage"`
+				ScheduledAt float64 `json:"scheduled_at"`
+			} `json:"steps"`
+		}
+		if err := json.Unmarshal(respBody, &rollover); err != nil {
+			t.Fatalf("Failed to parse response: %v", err)
+		}
+		if rollover.Status != "scheduled" {
+			t.Errorf("Expected status scheduled, got %q", rollover.Status)
+		}
+		if len(rollover.Steps) != 4 {
+			t.Fatalf("Expected 4 steps, got %d", len(rollover.Steps))
+		}
+		if rollover.Steps[2].Stage != "activate" || rollover.Steps[2].ScheduledAt <= rollover.Steps[1].ScheduledAt {
+			t.Errorf("Expected activation after publication, got %+v", rollover.Steps)
+		}
+
+		req = httptest.NewRequest("POST", "/kms/rollover", http.NoBody)
+		resp, respBody = doRequest(t, app, req)
+		assertStatus(t, resp, respBody, http.StatusConflict)
+
+		req = httptest.NewRequest("GET", "/kms/rotation", http.NoBody)
+		resp, respBody = doRequest(t, app, req)
+		requireStatus(t, resp, respBody, http.StatusOK)
+		var info map[string]any
+		if err := json.Unmarshal(respBody, &info); err != nil {
+			t.Fatalf("Failed to parse response: %v", err)
+		}
+		if _, ok := info["rollover"]; !ok {
+			t.Errorf("Expected rollover in rotation info, got %v", info)
+		}
+
+		req = httptest.NewRequest("DELETE", "/kms/rollover", http.NoBody)
+		resp, respBody = doRequest(t, app, req)
+		requireStatus(t, resp, respBody, http.StatusOK)
+		if !strings.Contains(string(respBody), `"cancelled"`) {
+			t.Errorf("Expected cancelled rollover, got %s", respBody)
+		}
+
+		req = httptest.NewRequest("DELETE", "/kms/rollover", http.NoBody)
+		resp, respBody = doRequest(t, app, req)
+		assertStatus(t, resp, respBody, http.StatusConflict)
+	})
+
+	t.Run("ActivationTooEarly", func(t *testing.T) {
+		t.Parallel()
+		app := setup(t)
+		now := time.Now().Unix()
+		body := fmt.Sprintf(`{"publish_at": %d, "activate_at": %d}`, now, now+60)
+		req := httptest.NewRequest("POST", "/kms/rollover", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		resp, respBody := doRequest(t, app, req)
+		assertStatus(t, resp, respBody, http.StatusBadRequest)
+	})
+
+	t.Run("StagesOutOfOrder", func(t *testing.T) {
+		t.Parallel()
+		app := setup(t)
+		now := time.Now().Unix()
+		body := fmt.Sprintf(`{"activate_at": %d, "retire_at": %d}`, now+7*86400, now+86400)
+		req := httptest.NewRequest("POST", "/kms/rollover", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		resp, respBody := doRequest(t, app, req)
+		assertStatus(t, resp, respBody, http.StatusBadRequest)
+	})
+
+	t.Run("CancelWithoutRollover", func(t *testing.T) {
+		t.Parallel()
+		app := setup(t)
+		req := httptest.NewRequest("DELETE", "/kms/rollover", http.NoBody)
+		resp, respBody := doRequest(t, app, req)
+		assertStatus(t, resp, respBody, http.StatusNotFound)
+	})
+}
+
+// --- KEY PURPOSE TESTS ---
+
+func TestPurposeKMSRoutes(t *testing.T) {
+	t.Parallel()
+	store := newTestStorage(t)
+	trustMarkPKs := store.DBPublicKeyStorage(model.SigningPurposeTrustMarks)
+	if err := trustMarkPKs.Load(); err != nil {
+		t.Fatalf("Failed to create public key table: %v", err)
+	}
+	km := KeyManagement{
+		KMS:       "mock-kms",
+		BasicKeys: &mockBasicKMS{},
+		Keys:      &mockFullKMS{},
+		Purposes: map[string]KeyManagement{
+			model.SigningPurposeTrustMarks: {
+				KMS:           "tm-kms",
+				BasicKeys:     &mockBasicKMS{},
+				Keys:          &mockFullKMS{},
+				KMSManagedPKs: trustMarkPKs,
+			},
+		},
+	}
+	app := fiber.New()
+	backends := model.Backends{
+		KV:         store.KeyValue(),
+		PKStorages: func(tid string) public.PublicKeyStorage { return store.DBPublicKeyStorage(tid) },
+	}
+	registerKeys(app, km, store.KeyValue(), backends)
+
+	t.Run("ListPurposes", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/kms/purposes", http.NoBody)
+		resp, respBody := doRequest(t, app, req)
+		requireStatus(t, resp, respBody, 200)
+
+		var infos []map[string]any
+		if err := json.Unmarshal(respBody, &infos); err != nil {
+			t.Fatalf("Failed to parse KMS infos: %v", err)
+		}
+		if len(infos) != 1 {
+			t.Fatalf("Expected 1 purpose, got %d", len(infos))
+		}
+		if infos[0]["purpose"] != model.SigningPurposeTrustMarks || infos[0]["kms"] != "tm-kms" {
+			t.Errorf("Unexpected purpose info: %v", infos[0])
+		}
+	})
+
+	t.Run("PurposeRSAKeyLenIsSeparate", func(t *testing.T) {
+		req := httptest.NewRequest("PUT", "/kms/purposes/trust_marks/rsa-key-len", strings.NewReader("4096"))
+		resp, respBody := doRequest(t, app, req)
+		requireStatus(t, resp, respBody, 200)
+
+		l, err := storage.GetRSAKeyLenForPurpose(store.KeyValue(), model.SigningPurposeTrustMarks)
+		if err != nil {
+			t.Fatalf("Failed to read rsa key len: %v", err)
+		}
+		if l != 4096 {
+			t.Errorf("Expected trust mark rsa key len 4096, got %d", l)
+		}
+		l, err = storage.GetRSAKeyLen(store.KeyValue())
+		if err != nil {
+			t.Fatalf("Failed to read rsa key len: %v", err)
+		}
+		if l != 2048 {
+			t.Errorf("Expected federation rsa key len 2048, got %d", l)
+		}
+	})
+
+	t.Run("PurposeJWKS", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/kms/purposes/trust_marks/jwks", http.NoBody)
+		resp, respBody := doRequest(t, app, req)
+		requireStatus(t, resp, respBody, 200)
+	})
+
+	t.Run("UnconfiguredPurpose", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/kms/purposes/resolve", http.NoBody)
+		resp, respBody := doRequest(t, app, req)
+		requireStatus(t, resp, respBody, 404)
+	})
+
+	t.Run("FederationKMSInfo", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/kms", http.NoBody)
+		resp, respBody := doRequest(t, app, req)
+		requireStatus(t, resp, respBody, 200)
+
+		var info map[string]any
+		if err := json.Unmarshal(respBody, &info); err != nil {
+			t.Fatalf("Failed to parse KMS info: %v", err)
+		}
+		if info["purpose"] != model.SigningPurposeFederation {
+			t.Errorf("Expected purpose %q, got %v", model.SigningPurposeFederation, info["purpose"])
+		}
+	})
+}
@@ -0,0 +1,179 @@
+package adminapi
+
+import (
+	"errors"
+	"io"
+	"strings"
+
+	oidfed "github.com/go-oidfed/lib"
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/go-oidfed/lighthouse/storage/model"
+)
+
+// HostedLogo is a logo hosted by LightHouse together with the public URI it
+// is served at.
+type HostedLogo struct {
+	model.Logo
+	URI string `json:"uri"`
+}
+
+// LogoCheck is the result of fetching and validating a logo URI.
+type LogoCheck struct {
+	URI string `json:"uri"`
+	// UsedBy lists where the logo URI is used, e.g. "entity_configuration"
+	// or "trust_mark_spec:<trust_mark_type>".
+	UsedBy      []string `json:"used_by,omitempty"`
+	Valid       bool     `json:"valid"`
+	Error       string   `json:"error,omitempty"`
+	ContentType string   `json:"content_type,omitempty"`
+	Size        int      `json:"size,omitempty"`
+	Width       int      `json:"width,omitempty"`
+	Height      int      `json:"height,omitempty"`
+	// LogoID is the id of the hosted copy of the logo, if any.
+	LogoID uint `json:"logo_id,omitempty"`
+}
+
+// LogoCheckReport is the result of checking all external logo URIs.
+type LogoCheckReport struct {
+	CheckedAt int64       `json:"checked_at"`
+	Logos     []LogoCheck `json:"logos"`
+}
+
+// importLogoRequest is the request body for importing an external logo.
+type importLogoRequest struct {
+	SourceURI string `json:"source_uri"`
+}
+
+// logosHandlers groups handlers for the logo hosting endpoints.
+type logosHandlers struct {
+	controller LighthouseController
+}
+
+func (h *logosHandlers) handleError(c *fiber.Ctx, err error) error {
+	if notFound, ok := errors.AsType[model.NotFoundError](err); ok {
+		return c.Status(fiber.StatusNotFound).JSON(oidfed.ErrorNotFound(string(notFound)))
+	}
+	if exists, ok := errors.AsType[model.AlreadyExistsError](err); ok {
+		return c.Status(fiber.StatusConflict).JSON(oidfed.ErrorInvalidRequest(string(exists)))
+	}
+	if invalid, ok := errors.AsType[model.ValidationError](err); ok {
+		return c.Status(fiber.StatusBadRequest).JSON(oidfed.ErrorInvalidRequest(string(invalid)))
+	}
+	return c.Status(fiber.StatusInternalServerError).JSON(oidfed.ErrorServerError(err.Error()))
+}
+
+// image returns the uploaded image, either from the "file" field of a
+// multipart form or the raw request body.
+func (*logosHandlers) image(c *fiber.Ctx) ([]byte, error) {
+	if !strings.HasPrefix(c.Get(fiber.HeaderContentType), fiber.MIMEMultipartForm) {
+		return c.Body(), nil
+	}
+	fh, err := c.FormFile("file")
+	if err != nil {
+		return nil, model.ValidationError("multipart form field 'file' is required")
+	}
+	f, err := fh.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
+
+func (h *logosHandlers) list(c *fiber.Ctx) error {
+	logos, err := h.controller.HostedLogos()
+	if err != nil {
+		return h.handleError(c, err)
+	}
+	return c.JSON(logos)
+}
+
+func (h *logosHandlers) get(c *fiber.Ctx) error {
+	logo, err := h.controller.HostedLogo(c.Params("logoID"))
+	if err != nil {
+		return h.handleError(c, err)
+	}
+	return c.JSON(logo)
+}
+
+func (h *logosHandlers) upload(c *fiber.Ctx) error {
+	data, err := h.image(c)
+	if err != nil {
+		return h.handleError(c, err)
+	}
+	logo, err := h.controller.AddLogo(data, c.Query("source_uri"))
+	if err != nil {
+		return h.handleError(c, err)
+	}
+	return c.Status(fiber.StatusCreated).JSON(logo)
+}
+
+func (h *logosHandlers) replace(c *fiber.Ctx) error {
+	data, err := h.image(c)
+	if err != nil {
+		return h.handleError(c, err)
+	}
+	logo, err := h.controller.ReplaceLogo(c.Params("logoID"), data)
+	if err != nil {
+		return h.handleError(c, err)
+	}
+	return c.JSON(logo)
+}
+
+func (h *logosHandlers) importLogo(c *fiber.Ctx) error {
+	var req importLogoRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(oidfed.ErrorInvalidRequest("invalid body"))
+	}
+	if req.SourceURI == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(oidfed.ErrorInvalidRequest("source_uri is required"))
+	}
+	logo, err := h.controller.ImportLogo(req.SourceURI)
+	if err != nil {
+		return h.handleError(c, err)
+	}
+	return c.JSON(logo)
+}
+
+func (h *logosHandlers) delete(c *fiber.Ctx) error {
+	if err := h.controller.DeleteLogo(c.Params("logoID")); err != nil {
+		return h.handleError(c, err)
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+func (h *logosHandlers) report(c *fiber.Ctx) error {
+	report, err := h.controller.LogoCheckReport()
+	if err != nil {
+		return h.handleError(c, err)
+	}
+	return c.JSON(report)
+}
+
+func (h *logosHandlers) check(c *fiber.Ctx) error {
+	report, err := h.controller.CheckLogos()
+	if err != nil {
+		return h.handleError(c, err)
+	}
+	return c.JSON(report)
+}
+
+// registerLogos wires the endpoints for the management of hosted logos. The
+// entity configuration is invalidated on changes, since its logo URIs are
+// rewritten to the hosted logos.
+func registerLogos(r fiber.Router, ctrl LighthouseController) {
+	if ctrl == nil {
+		return
+	}
+	h := &logosHandlers{controller: ctrl}
+	g := r.Group("/logos")
+	g.Get("/", h.list)
+	g.Post("/", entityConfigurationCacheInvalidationMiddleware, h.upload)
+	g.Post("/import", entityConfigurationCacheInvalidationMiddleware, h.importLogo)
+	g.Get("/check", h.report)
+	g.Post("/check", entityConfigurationCacheInvalidationMiddleware, h.check)
+	g.Get("/:logoID", h.get)
+	g.Put("/:logoID", entityConfigurationCacheInvalidationMiddleware, h.replace)
+	g.Delete("/:logoID", entityConfigurationCacheInvalidationMiddleware, h.delete)
+}
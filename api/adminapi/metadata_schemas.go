@@ -0,0 +1,198 @@
+package adminapi
+
+import (
+	"encoding/json"
+	"errors"
+	"slices"
+	"strings"
+
+	oidfed "github.com/go-oidfed/lib"
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/go-oidfed/lighthouse/internal/metadataschema"
+	"github.com/go-oidfed/lighthouse/storage/model"
+)
+
+// metadataSchemaResponse is the API representation of an effective metadata
+// schema
+type metadataSchemaResponse struct {
+	EntityType  string          `json:"entity_type"`
+	Description string          `json:"description,omitempty"`
+	Source      string          `json:"source"`
+	Schema      json.RawMessage `json:"schema"`
+	UpdatedAt   int             `json:"updated_at,omitempty"`
+}
+
+// metadataValidationResponse is the response of the validate endpoint
+type metadataValidationResponse struct {
+	Valid  bool     `json:"valid"`
+	Errors []string `json:"errors,omitempty"`
+}
+
+// metadataSchemasHandlers groups handlers for metadata schema endpoints.
+type metadataSchemasHandlers struct {
+	store     model.MetadataSchemaStore
+	validator *metadataschema.Validator
+}
+
+func (h *metadataSchemasHandlers) list(c *fiber.Ctx) error {
+	stored, err := h.store.List()
+	if err != nil {
+		return writeServerError(c, err)
+	}
+	result := make([]metadataSchemaResponse, 0, len(stored))
+	custom := make(map[string]bool, len(stored))
+	for _, s := range stored {
+		custom[s.EntityType] = true
+		result = append(result, customSchemaResponse(s))
+	}
+	for _, et := range metadataschema.BuiltinEntityTypes() {
+		if custom[et] {
+			continue
+		}
+		raw, _ := metadataschema.BuiltinSchema(et)
+		result = append(
+			result, metadataSchemaResponse{
+				EntityType: et,
+				Source:     metadataschema.SourceBuiltin,
+				Schema:     raw,
+			},
+		)
+	}
+	slices.SortFunc(
+		result, func(a, b metadataSchemaResponse) int {
+			return strings.Compare(a.EntityType, b.EntityType)
+		},
+	)
+	return c.JSON(result)
+}
+
+func (h *metadataSchemasHandlers) get(c *fiber.Ctx) error {
+	et := c.Params("entityType")
+	stored, err := h.store.Get(et)
+	if err == nil {
+		return c.JSON(customSchemaResponse(*stored))
+	}
+	if _, ok := errors.AsType[model.NotFoundError](err); !ok {
+		return writeServerError(c, err)
+	}
+	raw, ok := metadataschema.BuiltinSchema(et)
+	if !ok {
+		return writeNotFound(c, "metadata schema not found")
+	}
+	return c.JSON(
+		metadataSchemaResponse{
+			EntityType: et,
+			Source:     metadataschema.SourceBuiltin,
+			Schema:     raw,
+		},
+	)
+}
+
+func (h *metadataSchemasHandlers) put(c *fiber.Ctx) error {
+	et := c.Params("entityType")
+	var req model.AddMetadataSchema
+	if err := json.Unmarshal(c.Body(), &req); err != nil {
+		return writeBadBody(c)
+	}
+	if len(req.Schema) == 0 {
+		return writeBadRequest(c, "schema is required")
+	}
+	if _, err := metadataschema.Compile(req.Schema); err != nil {
+		return writeBadRequest(c, "invalid schema: "+err.Error())
+	}
+	stored, err := h.store.Put(et, req)
+	if err != nil {
+		if _, ok := errors.AsType[model.ValidationError](err); ok {
+			return writeBadRequest(c, err.Error())
+		}
+		return writeServerError(c, err)
+	}
+	return c.JSON(customSchemaResponse(*stored))
+}
+
+func (h *metadataSchemasHandlers) delete(c *fiber.Ctx) error {
+	if err := h.store.Delete(c.Params("entityType")); err != nil {
+		if _, ok := errors.AsType[model.NotFoundError](err); ok {
+			return writeNotFound(c, err.Error())
+		}
+		return writeServerError(c, err)
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+func (h *metadataSchemasHandlers) validate(c *fiber.Ctx) error {
+	et := c.Params("entityType")
+	var body map[string]any
+	if err := json.Unmarshal(c.Body(), &body); err != nil {
+		return writeBadBody(c)
+	}
+	err := h.validator.ValidateEntityType(et, body)
+	if err == nil {
+		return c.JSON(metadataValidationResponse{Valid: true})
+	}
+	if verr, ok := errors.AsType[*metadataschema.ValidationError](err); ok {
+		return c.JSON(
+			metadataValidationResponse{
+				Valid:  false,
+				Errors: verr.Errors,
+			},
+		)
+	}
+	return writeServerError(c, err)
+}
+
+func customSchemaResponse(s model.MetadataSchema) metadataSchemaResponse {
+	return metadataSchemaResponse{
+		EntityType:  s.EntityType,
+		Description: s.Description,
+		Source:      metadataschema.SourceCustom,
+		Schema:      json.RawMessage(s.Schema),
+		UpdatedAt:   s.UpdatedAt,
+	}
+}
+
+// validateMetadata validates the metadata of a single entity type if metadata
+// validation is enabled, i.e. if validator is not nil.
+func validateMetadata(validator *metadataschema.Validator, entityType string, metadata any) error {
+	if validator == nil {
+		return nil
+	}
+	return validator.ValidateEntityType(entityType, metadata)
+}
+
+// validateAllMetadata validates the metadata of all contained entity types if
+// metadata validation is enabled, i.e. if validator is not nil.
+func validateAllMetadata(validator *metadataschema.Validator, metadata any) error {
+	if validator == nil {
+		return nil
+	}
+	return validator.Validate(metadata)
+}
+
+// writeMetadataValidationError writes a 400 response if err is a metadata
+// validation error and a 500 response otherwise.
+func writeMetadataValidationError(c *fiber.Ctx, err error) error {
+	if verr, ok := errors.AsType[*metadataschema.ValidationError](err); ok {
+		return c.Status(fiber.StatusBadRequest).JSON(oidfed.ErrorInvalidMetadata(verr.Error()))
+	}
+	return writeServerError(c, err)
+}
+
+// registerMetadataSchemas registers metadata schema management endpoints.
+func registerMetadataSchemas(r fiber.Router, store model.MetadataSchemaStore) {
+	if store == nil {
+		return
+	}
+	g := r.Group("/metadata-schemas")
+	h := &metadataSchemasHandlers{
+		store:     store,
+		validator: metadataschema.NewValidator(store),
+	}
+
+	g.Get("/", h.list)
+	g.Get("/:entityType", h.get)
+	g.Put("/:entityType", h.put)
+	g.Delete("/:entityType", h.delete)
+	g.Post("/:entityType/validate", h.validate)
+}
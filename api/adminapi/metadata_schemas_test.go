@@ -0,0 +1,218 @@
+package adminapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/go-oidfed/lighthouse/internal/metadataschema"
+	"github.com/go-oidfed/lighthouse/storage/model"
+)
+
+func setupMetadataSchemasApp(t *testing.T) (*fiber.App, model.MetadataSchemaStore) {
+	t.Helper()
+	store := newSubordinateTestStorage(t)
+	schemas := store.MetadataSchemasStorage()
+	app := fiber.New()
+	registerMetadataSchemas(app, schemas)
+	return app, schemas
+}
+
+func TestMetadataSchemas_ListAndGetBuiltin(t *testing.T) {
+	t.Parallel()
+	app, _ := setupMetadataSchemasApp(t)
+
+	req := httptest.NewRequest("GET", "/metadata-schemas", http.NoBody)
+	resp, body := doRequest(t, app, req)
+	requireStatus(t, resp, body, http.StatusOK)
+	var list []metadataSchemaResponse
+	if err := json.Unmarshal(body, &list); err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+	if len(list) != len(metadataschema.BuiltinEntityTypes()) {
+		t.Fatalf("Expected %d schemas, got %d", len(metadataschema.BuiltinEntityTypes()), len(list))
+	}
+	for _, s := range list {
+		if s.Source != metadataschema.SourceBuiltin {
+			t.Errorf("Expected source %q for %s, got %q", metadataschema.SourceBuiltin, s.EntityType, s.Source)
+		}
+	}
+
+	req = httptest.NewRequest("GET", "/metadata-schemas/openid_provider", http.NoBody)
+	resp, body = doRequest(t, app, req)
+	requireStatus(t, resp, body, http.StatusOK)
+
+	req = httptest.NewRequest("GET", "/metadata-schemas/unknown_type", http.NoBody)
+	resp, body = doRequest(t, app, req)
+	assertErrorResponse(t, resp, body, http.StatusNotFound, "not_found")
+}
+
+func TestMetadataSchemas_PutGetDelete(t *testing.T) {
+	t.Parallel()
+	app, _ := setupMetadataSchemasApp(t)
+
+	payload := `{"description":"custom rp","schema":{"type":"object","required":["client_name"]}}`
+	req := httptest.NewRequest("PUT", "/metadata-schemas/openid_relying_party", strings.NewReader(payload))
+	req.Header.Set("Content-Type", "application/json")
+	resp, body := doRequest(t, app, req)
+	requireStatus(t, resp, body, http.StatusOK)
+
+	req = httptest.NewRequest("GET", "/metadata-schemas/openid_relying_party", http.NoBody)
+	resp, body = doRequest(t, app, req)
+	requireStatus(t, resp, body, http.StatusOK)
+	var got metadataSchemaResponse
+	if err := json.Unmarshal(body, &got); err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+	if got.Source != metadataschema.SourceCustom || got.Description != "custom rp" {
+		t.Errorf("Unexpected schema: %+v", got)
+	}
+
+	req = httptest.NewRequest(
+		"POST", "/metadata-schemas/openid_relying_party/validate", strings.NewReader(`{"client_uri":"https://rp.example.org"}`),
+	)
+	resp, body = doRequest(t, app, req)
+	requireStatus(t, resp, body, http.StatusOK)
+	var result metadataValidationResponse
+	if err := json.Unmarshal(body, &result); err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+	if result.Valid || len(result.Errors) == 0 {
+		t.Errorf("Expected validation errors, got %+v", result)
+	}
+
+	req = httptest.NewRequest("DELETE", "/metadata-schemas/openid_relying_party", http.NoBody)
+	resp, body = doRequest(t, app, req)
+	requireStatus(t, resp, body, http.StatusNoContent)
+
+	// Falls back to the built-in schema
+	req = httptest.NewRequest("GET", "/metadata-schemas/openid_relying_party", http.NoBody)
+	resp, body = doRequest(t, app, req)
+	requireStatus(t, resp, body, http.StatusOK)
+	if err := json.Unmarshal(body, &got); err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+	if got.Source != metadataschema.SourceBuiltin {
+		t.Errorf("Expected built-in schema after delete, got %q", got.Source)
+	}
+
+	req = httptest.NewRequest("DELETE", "/metadata-schemas/openid_relying_party", http.NoBody)
+	resp, body = doRequest(t, app, req)
+	assertErrorResponse(t, resp, body, http.StatusNotFound, "not_found")
+}
+
+func TestMetadataSchemas_PutInvalidSchema(t *testing.T) {
+	t.Parallel()
+	app, _ := setupMetadataSchemasApp(t)
+
+	for _, payload := range []string{
+		`not json`,
+		`{"description":"no schema"}`,
+		`{"schema":{"type":"no-such-type"}}`,
+	} {
+		req := httptest.NewRequest("PUT", "/metadata-schemas/openid_provider", strings.NewReader(payload))
+		resp, body := doRequest(t, app, req)
+		assertErrorResponse(t, resp, body, http.StatusBadRequest, "invalid_request")
+	}
+}
+
+func TestEntityConfigurationMetadata_Validation(t *testing.T) {
+	t.Parallel()
+	store := newSubordinateTestStorage(t)
+	app := fiber.New()
+	registerEntityConfiguration(
+		app, store.AdditionalClaimsStorage(), store.KeyValue(), newStubFedEntity(),
+		metadataschema.NewValidator(store.MetadataSchemasStorage()),
+	)
+
+	req := httptest.NewRequest(
+		"PUT", "/entity-configuration/metadata/openid_provider/issuer", strings.NewReader(`"not a uri"`),
+	)
+	resp, body := doRequest(t, app, req)
+	assertErrorResponse(t, resp, body, http.StatusBadRequest, "invalid_metadata")
+
+	req = httptest.NewRequest(
+		"PUT", "/entity-configuration/metadata/openid_provider/issuer", strings.NewReader(`"https://op.example.org"`),
+	)
+	resp, body = doRequest(t, app, req)
+	assertStatusOneOf(t, resp, http.StatusOK, http.StatusCreated)
+}
+
+func TestSubordinateMetadata_Validation(t *testing.T) {
+	t.Parallel()
+	store := newSubordinateTestStorage(t)
+	backends := model.Backends{
+		Subordinates:      store.SubordinateStorage(),
+		SubordinateEvents: store.SubordinateEventsStorage(),
+		KV:                store.KeyValue(),
+		Transaction: func(fn model.TransactionFunc) error {
+			return fn(
+				&model.Backends{
+					Subordinates:      store.SubordinateStorage(),
+					SubordinateEvents: store.SubordinateEventsStorage(),
+					KV:                store.KeyValue(),
+				},
+			)
+		},
+	}
+	schemas := store.MetadataSchemasStorage()
+	app := fiber.New()
+	registerSubordinateMetadata(app, backends, metadataschema.NewValidator(schemas))
+
+	if err := backends.Subordinates.Add(
+		model.ExtendedSubordinateInfo{
+			BasicSubordinateInfo: model.BasicSubordinateInfo{
+				EntityID: "https://rp-validate.example.org",
+			},
+		},
+	); err != nil {
+		t.Fatalf("Failed to add subordinate: %v", err)
+	}
+	saved, err := backends.Subordinates.Get("https://rp-validate.example.org")
+	if err != nil {
+		t.Fatalf("Failed to get subordinate: %v", err)
+	}
+	base := fmt.Sprintf("/subordinates/%d/metadata", saved.ID)
+
+	req := httptest.NewRequest(
+		"PUT", base+"/openid_relying_party/redirect_uris", strings.NewReader(`"https://rp.example.org/cb"`),
+	)
+	resp, body := doRequest(t, app, req)
+	assertErrorResponse(t, resp, body, http.StatusBadRequest, "invalid_metadata")
+
+	// A custom schema requiring client_name forbids removing it
+	if _, err = schemas.Put(
+		"custom_type", model.AddMetadataSchema{
+			Schema: []byte(`{"type":"object","required":["client_name"]}`),
+		},
+	); err != nil {
+		t.Fatalf("Failed to store schema: %v", err)
+	}
+	req = httptest.NewRequest("PUT", base+"/custom_type", strings.NewReader(`{"client_uri":"https://rp.example.org"}`))
+	resp, body = doRequest(t, app, req)
+	assertErrorResponse(t, resp, body, http.StatusBadRequest, "invalid_metadata")
+
+	req = httptest.NewRequest("PUT", base+"/custom_type", strings.NewReader(`{"client_name":"RP"}`))
+	resp, body = doRequest(t, app, req)
+	requireStatus(t, resp, body, http.StatusOK)
+
+	req = httptest.NewRequest("PUT", base+"/custom_type/client_uri", strings.NewReader(`"https://rp.example.org"`))
+	resp, body = doRequest(t, app, req)
+	requireStatus(t, resp, body, http.StatusCreated)
+
+	req = httptest.NewRequest("DELETE", base+"/custom_type/client_name", http.NoBody)
+	resp, body = doRequest(t, app, req)
+	assertErrorResponse(t, resp, body, http.StatusBadRequest, "invalid_metadata")
+
+	req = httptest.NewRequest("GET", base+"/custom_type/client_name", http.NoBody)
+	resp, body = doRequest(t, app, req)
+	requireStatus(t, resp, body, http.StatusOK)
+	if string(body) != `"RP"` {
+		t.Errorf("Expected client_name to be unchanged, got %s", body)
+	}
+}
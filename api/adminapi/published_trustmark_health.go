@@ -0,0 +1,79 @@
+package adminapi
+
+import (
+	oidfed "github.com/go-oidfed/lib"
+	"github.com/gofiber/fiber/v2"
+)
+
+// Health states of a trust mark published in the entity configuration.
+const (
+	// PublishedTrustMarkStatusValid is a trust mark that passed all checks.
+	PublishedTrustMarkStatusValid = "valid"
+	// PublishedTrustMarkStatusExpiring is a valid trust mark that expires
+	// within the configured threshold and is not refreshed automatically.
+	PublishedTrustMarkStatusExpiring = "expiring"
+	// PublishedTrustMarkStatusUnknown is a trust mark that could not be
+	// checked completely, e.g. because its issuer was not reachable.
+	PublishedTrustMarkStatusUnknown = "unknown"
+	// PublishedTrustMarkStatusInvalid is a trust mark that is expired, has an
+	// invalid signature or delegation, or is not active according to its
+	// issuer.
+	PublishedTrustMarkStatusInvalid = "invalid"
+)
+
+// PublishedTrustMarkHealth is the health of a single trust mark published in
+// the entity configuration.
+type PublishedTrustMarkHealth struct {
+	TrustMarkType   string `json:"trust_mark_type"`
+	TrustMarkIssuer string `json:"trust_mark_issuer,omitempty"`
+	// SelfIssued is set for trust marks issued by LightHouse itself; only
+	// their expiration is checked.
+	SelfIssued bool  `json:"self_issued,omitempty"`
+	ExpiresAt  int64 `json:"expires_at,omitempty"`
+	// IssuerStatus is the status returned by the issuer's trust mark status
+	// endpoint; empty if it was not queried.
+	IssuerStatus string `json:"issuer_status,omitempty"`
+	Status       string `json:"status"`
+	// Problems lists the reasons for a status other than valid.
+	Problems []string `json:"problems,omitempty"`
+	// Dropped is set if the trust mark is not published in the entity
+	// configuration because it is invalid.
+	Dropped bool `json:"dropped"`
+}
+
+// PublishedTrustMarkHealthReport is the result of a check of the trust marks
+// published in the entity configuration.
+type PublishedTrustMarkHealthReport struct {
+	CheckedAt int64 `json:"checked_at"`
+	// Status is "critical" if a trust mark is invalid, "warning" if a trust
+	// mark is expiring or could not be checked, and "ok" otherwise.
+	Status string `json:"status"`
+	// DropInvalid indicates if invalid trust marks are removed from the
+	// entity configuration.
+	DropInvalid bool                       `json:"drop_invalid"`
+	TrustMarks  []PublishedTrustMarkHealth `json:"trust_marks"`
+}
+
+// publishedTrustMarkHealthHandlers groups handlers for the published trust
+// mark health endpoint.
+type publishedTrustMarkHealthHandlers struct {
+	controller LighthouseController
+}
+
+func (h *publishedTrustMarkHealthHandlers) get(c *fiber.Ctx) error {
+	report, err := h.controller.PublishedTrustMarkHealth(c.QueryBool("refresh"))
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(oidfed.ErrorServerError(err.Error()))
+	}
+	return c.JSON(report)
+}
+
+// registerPublishedTrustMarkHealth wires the published trust mark health
+// endpoint.
+func registerPublishedTrustMarkHealth(r fiber.Router, ctrl LighthouseController) {
+	if ctrl == nil {
+		return
+	}
+	h := &publishedTrustMarkHealthHandlers{controller: ctrl}
+	r.Get("/health/trust-marks", h.get)
+}
@@ -12,6 +12,7 @@ import (
 	"github.com/pkg/errors"
 	"gopkg.in/yaml.v3"
 
+	"github.com/go-oidfed/lighthouse/internal/metadataschema"
 	"github.com/go-oidfed/lighthouse/storage/model"
 )
 
@@ -31,6 +32,9 @@ type Options struct {
 	// Actor holds configuration for actor extraction from requests.
 	// The actor is recorded in subordinate event history.
 	Actor ActorConfig
+	// ValidateMetadata enables validation of entity configuration and
+	// subordinate metadata against the metadata schemas on write.
+	ValidateMetadata bool
 }
 
 // Register mounts all admin API routes under the provided group.
@@ -130,26 +134,45 @@ func Register(
 	}
 	r.Use(actorMiddleware(actorCfg))
 
+	// Metadata validation
+	var metadataValidator *metadataschema.Validator
+	if opts != nil && opts.ValidateMetadata {
+		metadataValidator = metadataschema.NewValidator(storages.MetadataSchemas)
+	}
+
 	// Entity Configuration
-	registerEntityConfiguration(r, storages.AdditionalClaims, storages.KV, fedEntity)
+	registerEntityConfiguration(r, storages.AdditionalClaims, storages.KV, fedEntity, metadataValidator)
+	// Metadata Schemas
+	registerMetadataSchemas(r, storages.MetadataSchemas)
 	// Authority Hints
 	registerAuthorityHints(r, storages.AuthorityHints)
 	// Keys (with transaction support for key rotation)
 	registerKeys(r, keyManagement, storages.KV, storages)
+	registerKeyCompromise(r, keyManagement, ctrl)
+	registerKeyHealth(r, ctrl)
+	registerCeremony(r, ctrl)
+	registerStaticExport(r, ctrl)
 	// Entity Configuration Trust Marks
 	var trustMarkInvalidator TrustMarkConfigInvalidator
 	if opts != nil {
 		trustMarkInvalidator = opts.TrustMarkConfigInvalidator
 	}
 	registerEntityTrustMarks(r, storages.PublishedTrustMarks, trustMarkInvalidator)
+	registerPublishedTrustMarkHealth(r, ctrl)
 	// Subordinates - all handlers registered via single entry point (with transaction support)
-	RegisterSubordinateHandlers(r, storages, fedEntity, ctrl)
+	RegisterSubordinateHandlers(r, storages, fedEntity, ctrl, metadataValidator)
 	// Trust Mark Types and Issuance (with transaction support)
 	registerTrustMarkTypes(r, storages)
 	// Global Owners and Issuers
 	registerTrustMarkOwners(r, storages.TrustMarkOwners, storages.TrustMarkTypes)
 	registerTrustMarkIssuers(r, storages.TrustMarkIssuers, storages.TrustMarkTypes)
-	registerTrustMarkIssuance(r, storages.TrustMarkSpecs)
+	registerTrustMarkDelegations(r, storages.TrustMarkTypes, ctrl)
+	registerTrustMarkPush(r, ctrl)
+	registerTrustMarkPreIssuance(r, ctrl)
+	registerLogos(r, ctrl)
+	registerTrustMarkIssuance(r, storages.TrustMarkSpecs, storages.TrustMarkSubjectEvents)
+	registerTrustMarkRequests(r, storages.TrustMarkRequests, storages.TrustMarkSpecs, storages.TrustMarkSubjectEvents)
+	registerTrustMarkReports(r, storages.TrustMarkReports)
 	// Trust Anchors (TA repository management)
 	registerTrustAnchors(r, storages.TrustAnchors, ctrl)
 	// Federation Endpoints (dynamic endpoint management)
@@ -0,0 +1,70 @@
+package adminapi
+
+import (
+	"errors"
+
+	oidfed "github.com/go-oidfed/lib"
+	"github.com/gofiber/fiber/v2"
+)
+
+// ErrStaticExportDisabled is returned by LighthouseController.StaticExport if
+// the static export is not enabled
+var ErrStaticExportDisabled = errors.New("static export is not enabled")
+
+// StaticExportFile is a federation response rendered into a static file.
+type StaticExportFile struct {
+	// URL is the URL the response is published at, including the query.
+	URL string `json:"url"`
+	// Path is the path of the file relative to the export directory.
+	Path        string `json:"path"`
+	ContentType string `json:"content_type"`
+}
+
+// StaticExportResult is the state of the static export after a rendering.
+type StaticExportResult struct {
+	Dir        string `json:"dir"`
+	RenderedAt int64  `json:"rendered_at"`
+	// Files lists all files of the export.
+	Files []StaticExportFile `json:"files"`
+	// Rendered and Removed count the files written and removed by the
+	// rendering.
+	Rendered int `json:"rendered"`
+	Removed  int `json:"removed"`
+	// Errors lists the responses that could not be rendered.
+	Errors []string `json:"errors,omitempty"`
+}
+
+// staticExportHandlers groups handlers for the static export endpoints.
+type staticExportHandlers struct {
+	controller LighthouseController
+}
+
+func (h *staticExportHandlers) respond(c *fiber.Ctx, res *StaticExportResult, err error) error {
+	if err != nil {
+		if errors.Is(err, ErrStaticExportDisabled) {
+			return c.Status(fiber.StatusConflict).JSON(oidfed.ErrorInvalidRequest(err.Error()))
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(oidfed.ErrorServerError(err.Error()))
+	}
+	return c.JSON(res)
+}
+
+func (h *staticExportHandlers) get(c *fiber.Ctx) error {
+	res, err := h.controller.StaticExport(false)
+	return h.respond(c, res, err)
+}
+
+func (h *staticExportHandlers) render(c *fiber.Ctx) error {
+	res, err := h.controller.StaticExport(true)
+	return h.respond(c, res, err)
+}
+
+// registerStaticExport wires the static export endpoints.
+func registerStaticExport(r fiber.Router, ctrl LighthouseController) {
+	if ctrl == nil {
+		return
+	}
+	h := &staticExportHandlers{controller: ctrl}
+	r.Get("/static-export", h.get)
+	r.Post("/static-export", h.render)
+}
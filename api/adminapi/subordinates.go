@@ -17,6 +17,7 @@ import (
 	oidfed "github.com/go-oidfed/lib"
 	"github.com/gofiber/fiber/v2"
 
+	"github.com/go-oidfed/lighthouse/internal/metadataschema"
 	"github.com/go-oidfed/lighthouse/storage/model"
 )
 
@@ -34,6 +35,7 @@ func RegisterSubordinateHandlers(
 	storages model.Backends,
 	fedEntity oidfed.FederationEntity,
 	ctrl LighthouseController,
+	metadataValidator *metadataschema.Validator,
 ) {
 	// Register general endpoints first (routes without :subordinateID in the path)
 	// These must be registered before subordinate-specific routes to avoid conflicts
@@ -60,7 +62,7 @@ func RegisterSubordinateHandlers(
 	registerSubordinateStatement(r, storages.Subordinates, storages.KV, fedEntity)
 
 	// Subordinate-specific metadata: /subordinates/:subordinateID/metadata/*
-	registerSubordinateMetadata(r, storages)
+	registerSubordinateMetadata(r, storages, metadataValidator)
 
 	// Subordinate-specific metadata policies: /subordinates/:subordinateID/metadata-policies/*
 	registerSubordinateMetadataPolicies(r, storages)
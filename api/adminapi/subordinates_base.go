@@ -8,6 +8,7 @@ import (
 
 	oidfed "github.com/go-oidfed/lib"
 	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
 
 	"github.com/go-oidfed/lighthouse/storage/model"
 )
@@ -221,6 +222,7 @@ func (h *subordinatesBaseHandlers) delete(c *fiber.Ctx) error {
 		if r := h.controller.SubordinateJWKSRefresher(); r != nil {
 			r.Remove(entityID)
 		}
+		h.dropPresignedStatements(entityID)
 	}
 	return c.SendStatus(fiber.StatusNoContent)
 }
@@ -286,6 +288,9 @@ func (h *subordinatesBaseHandlers) updateStatus(c *fiber.Ctx) error {
 		return writeServerError(c, err)
 	}
 	h.notifySubordinateJWKSRefresher(result.EntityID)
+	if status != model.StatusActive {
+		h.dropPresignedStatements(result.EntityID)
+	}
 	return c.JSON(result)
 }
 
@@ -424,6 +429,18 @@ func (h *subordinatesBaseHandlers) notifySubordinateJWKSRefresher(entityID strin
 	}
 }
 
+// dropPresignedStatements removes the pre-signed statements about a
+// subordinate that is no longer active, so they are not served until they
+// expire.
+func (h *subordinatesBaseHandlers) dropPresignedStatements(entityID string) {
+	if h.controller == nil {
+		return
+	}
+	if err := h.controller.DeleteSubjectPresignedStatements(entityID); err != nil {
+		log.Warn().Err(err).Str("subordinate", entityID).Msg("failed to delete pre-signed statements")
+	}
+}
+
 // registerSubordinatesBase registers basic CRUD endpoints for subordinates.
 func registerSubordinatesBase(r fiber.Router, storages model.Backends, ctrl LighthouseController) {
 	g := r.Group("/subordinates")
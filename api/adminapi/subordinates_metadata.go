@@ -8,13 +8,17 @@ import (
 	oidfed "github.com/go-oidfed/lib"
 	"github.com/gofiber/fiber/v2"
 
+	"github.com/go-oidfed/lighthouse/internal/metadataschema"
 	"github.com/go-oidfed/lighthouse/storage/model"
 )
 
 // registerSubordinateMetadata registers metadata endpoints for subordinates.
+// If validator is not nil, written metadata is validated against the metadata
+// schemas.
 func registerSubordinateMetadata(
 	r fiber.Router,
 	storages model.Backends,
+	validator *metadataschema.Validator,
 ) {
 	g := r.Group("/subordinates/:subordinateID/metadata")
 	withCacheWipe := g.Use(subordinateStatementsCacheInvalidationMiddleware(storages.Subordinates))
@@ -23,18 +27,18 @@ func registerSubordinateMetadata(
 	g.Get("/", handleGetSubordinateMetadata(storages.Subordinates))
 
 	// PUT / - Replace full subordinate-specific metadata (transactional)
-	withCacheWipe.Put("/", handlePutSubordinateMetadata(storages))
+	withCacheWipe.Put("/", handlePutSubordinateMetadata(storages, validator))
 
 	// Entity type endpoints
 	g.Get("/:entityType", handleGetSubordinateMetadataEntityType(storages.Subordinates))
-	withCacheWipe.Put("/:entityType", handlePutSubordinateMetadataEntityType(storages))
-	withCacheWipe.Post("/:entityType", handlePostSubordinateMetadataEntityType(storages))
+	withCacheWipe.Put("/:entityType", handlePutSubordinateMetadataEntityType(storages, validator))
+	withCacheWipe.Post("/:entityType", handlePostSubordinateMetadataEntityType(storages, validator))
 	withCacheWipe.Delete("/:entityType", handleDeleteSubordinateMetadataEntityType(storages))
 
 	// Claim endpoints
 	g.Get("/:entityType/:claim", handleGetSubordinateMetadataClaim(storages.Subordinates))
-	withCacheWipe.Put("/:entityType/:claim", handlePutSubordinateMetadataClaim(storages))
-	withCacheWipe.Delete("/:entityType/:claim", handleDeleteSubordinateMetadataClaim(storages))
+	withCacheWipe.Put("/:entityType/:claim", handlePutSubordinateMetadataClaim(storages, validator))
+	withCacheWipe.Delete("/:entityType/:claim", handleDeleteSubordinateMetadataClaim(storages, validator))
 }
 
 func handleGetSubordinateMetadata(subordinates model.SubordinateStorageBackend) fiber.Handler {
@@ -50,7 +54,7 @@ func handleGetSubordinateMetadata(subordinates model.SubordinateStorageBackend)
 	}
 }
 
-func handlePutSubordinateMetadata(storages model.Backends) fiber.Handler {
+func handlePutSubordinateMetadata(storages model.Backends, validator *metadataschema.Validator) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		id := c.Params("subordinateID")
 		var body oidfed.Metadata
@@ -69,6 +73,9 @@ func handlePutSubordinateMetadata(storages model.Backends) fiber.Handler {
 					return model.NotFoundError("subordinate not found")
 				}
 
+				if err := validateAllMetadata(validator, body); err != nil {
+					return err
+				}
 				info.Metadata = &body
 				if err := tx.Subordinates.Update(info.EntityID, *info); err != nil {
 					return err
@@ -85,6 +92,9 @@ func handlePutSubordinateMetadata(storages model.Backends) fiber.Handler {
 		)
 
 		if err != nil {
+			if _, ok := errors.AsType[*metadataschema.ValidationError](err); ok {
+				return writeMetadataValidationError(c, err)
+			}
 			if _, ok := errors.AsType[model.NotFoundError](err); ok {
 				return writeNotFound(c, err.Error())
 			}
@@ -109,7 +119,7 @@ func handleGetSubordinateMetadataEntityType(subordinates model.SubordinateStorag
 	}
 }
 
-func handlePutSubordinateMetadataEntityType(storages model.Backends) fiber.Handler {
+func handlePutSubordinateMetadataEntityType(storages model.Backends, validator *metadataschema.Validator) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		id := c.Params("subordinateID")
 		et := c.Params("entityType")
@@ -132,6 +142,9 @@ func handlePutSubordinateMetadataEntityType(storages model.Backends) fiber.Handl
 				if info.Metadata == nil {
 					info.Metadata = &oidfed.Metadata{}
 				}
+				if err := validateMetadata(validator, et, body); err != nil {
+					return err
+				}
 				setEntityMetadata(info.Metadata, et, body)
 				if err := tx.Subordinates.Update(info.EntityID, *info); err != nil {
 					return err
@@ -149,6 +162,9 @@ func handlePutSubordinateMetadataEntityType(storages model.Backends) fiber.Handl
 		)
 
 		if err != nil {
+			if _, ok := errors.AsType[*metadataschema.ValidationError](err); ok {
+				return writeMetadataValidationError(c, err)
+			}
 			if _, ok := errors.AsType[model.NotFoundError](err); ok {
 				return writeNotFound(c, err.Error())
 			}
@@ -158,7 +174,7 @@ func handlePutSubordinateMetadataEntityType(storages model.Backends) fiber.Handl
 	}
 }
 
-func handlePostSubordinateMetadataEntityType(storages model.Backends) fiber.Handler {
+func handlePostSubordinateMetadataEntityType(storages model.Backends, validator *metadataschema.Validator) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		id := c.Params("subordinateID")
 		et := c.Params("entityType")
@@ -186,6 +202,9 @@ func handlePostSubordinateMetadataEntityType(storages model.Backends) fiber.Hand
 					existing = map[string]any{}
 				}
 				maps.Copy(existing, body)
+				if err := validateMetadata(validator, et, existing); err != nil {
+					return err
+				}
 				setEntityMetadata(info.Metadata, et, existing)
 				if err := tx.Subordinates.Update(info.EntityID, *info); err != nil {
 					return err
@@ -203,6 +222,9 @@ func handlePostSubordinateMetadataEntityType(storages model.Backends) fiber.Hand
 		)
 
 		if err != nil {
+			if _, ok := errors.AsType[*metadataschema.ValidationError](err); ok {
+				return writeMetadataValidationError(c, err)
+			}
 			if _, ok := errors.AsType[model.NotFoundError](err); ok {
 				return writeNotFound(c, err.Error())
 			}
@@ -275,7 +297,7 @@ func handleGetSubordinateMetadataClaim(subordinates model.SubordinateStorageBack
 	}
 }
 
-func handlePutSubordinateMetadataClaim(storages model.Backends) fiber.Handler {
+func handlePutSubordinateMetadataClaim(storages model.Backends, validator *metadataschema.Validator) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		id := c.Params("subordinateID")
 		et := c.Params("entityType")
@@ -310,6 +332,9 @@ func handlePutSubordinateMetadataClaim(storages model.Backends) fiber.Handler {
 					created = true
 				}
 				m[claim] = body
+				if err := validateMetadata(validator, et, m); err != nil {
+					return err
+				}
 				setEntityMetadata(info.Metadata, et, m)
 				if err := tx.Subordinates.Update(info.EntityID, *info); err != nil {
 					return err
@@ -327,6 +352,9 @@ func handlePutSubordinateMetadataClaim(storages model.Backends) fiber.Handler {
 		)
 
 		if err != nil {
+			if _, ok := errors.AsType[*metadataschema.ValidationError](err); ok {
+				return writeMetadataValidationError(c, err)
+			}
 			if _, ok := errors.AsType[model.NotFoundError](err); ok {
 				return writeNotFound(c, err.Error())
 			}
@@ -339,7 +367,7 @@ func handlePutSubordinateMetadataClaim(storages model.Backends) fiber.Handler {
 	}
 }
 
-func handleDeleteSubordinateMetadataClaim(storages model.Backends) fiber.Handler {
+func handleDeleteSubordinateMetadataClaim(storages model.Backends, validator *metadataschema.Validator) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		id := c.Params("subordinateID")
 		et := c.Params("entityType")
@@ -363,6 +391,11 @@ func handleDeleteSubordinateMetadataClaim(storages model.Backends) fiber.Handler
 					return model.NotFoundError("metadata not found")
 				}
 				delete(m, claim)
+				if len(m) > 0 {
+					if err := validateMetadata(validator, et, m); err != nil {
+						return err
+					}
+				}
 				if info.Metadata == nil {
 					info.Metadata = &oidfed.Metadata{}
 				}
@@ -379,6 +412,9 @@ func handleDeleteSubordinateMetadataClaim(storages model.Backends) fiber.Handler
 		)
 
 		if err != nil {
+			if _, ok := errors.AsType[*metadataschema.ValidationError](err); ok {
+				return writeMetadataValidationError(c, err)
+			}
 			if _, ok := errors.AsType[model.NotFoundError](err); ok {
 				return writeNotFound(c, err.Error())
 			}
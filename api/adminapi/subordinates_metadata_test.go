@@ -35,7 +35,7 @@ func setupSubordinateMetadataApp(t *testing.T) (*fiber.App, model.Backends) {
 	}
 
 	app := fiber.New()
-	registerSubordinateMetadata(app, backends)
+	registerSubordinateMetadata(app, backends, nil)
 	return app, backends
 }
 
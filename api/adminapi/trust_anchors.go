@@ -13,7 +13,8 @@ import (
 )
 
 // LighthouseController is the interface the admin API uses to interact with
-// the running LightHouse instance for trust anchor and endpoint management.
+// the running LightHouse instance for trust anchor, endpoint and key
+// management.
 // The lighthouse.LightHouse type implements this interface.
 type LighthouseController interface {
 	// TAJWKSRefresher returns the TA JWKS refresher (may be nil).
@@ -28,6 +29,93 @@ type LighthouseController interface {
 	// ReloadEndpointsFromDB reloads all federation endpoints from the database
 	// into the in-memory endpoint registry.
 	ReloadEndpointsFromDB() error
+	// CompromiseKeys runs the emergency key compromise procedure for the key
+	// set of the passed key purpose: it revokes the compromised keys,
+	// activates replacement keys, purges cached signed statements, and
+	// notifies superiors and notification subscribers.
+	CompromiseKeys(purpose string, req KeyCompromiseRequest) (*KeyCompromiseResult, error)
+	// KeyHealth returns the latest key health report; if refresh is set or
+	// no report exists yet, the keys are checked right away.
+	KeyHealth(refresh bool) (*KeyHealthReport, error)
+	// CeremonyPayloads returns the unsigned entity configuration and
+	// subordinate statements to be pre-signed in an offline key ceremony.
+	CeremonyPayloads() (*CeremonyPayloads, error)
+	// ImportCeremonyBundle verifies a signed transfer bundle from an offline
+	// key ceremony and stores its pre-signed statements for serving.
+	ImportCeremonyBundle(bundle []byte) (*CeremonyImportResult, error)
+	// PresignedStatements returns the stored pre-signed statements.
+	PresignedStatements() ([]PresignedStatement, error)
+	// DeletePresignedStatements removes all pre-signed statements.
+	DeletePresignedStatements() error
+	// DeleteSubjectPresignedStatements removes the pre-signed statements
+	// about a subject.
+	DeleteSubjectPresignedStatements(subject string) error
+	// StaticExport returns the state of the static export; if render is set,
+	// all federation responses are rendered into the export directory first.
+	StaticExport(render bool) (*StaticExportResult, error)
+	// TrustMarkDelegations returns the delegation JWTs LightHouse issued as
+	// trust mark owner.
+	TrustMarkDelegations() ([]TrustMarkDelegation, error)
+	// RenewTrustMarkDelegations issues missing and expiring delegation JWTs
+	// for all trust mark types owned by LightHouse; if force is set, all
+	// delegations are renewed.
+	RenewTrustMarkDelegations(force bool) ([]TrustMarkDelegation, error)
+	// TrustMarkDelegationConfig returns the delegation configuration of a
+	// trust mark type.
+	TrustMarkDelegationConfig(trustMarkType string) (*TrustMarkDelegationConfig, error)
+	// SetTrustMarkDelegationConfig stores the delegation configuration of a
+	// trust mark type owned by LightHouse and renews its delegations.
+	SetTrustMarkDelegationConfig(trustMarkType string, conf TrustMarkDelegationConfig) (
+		*TrustMarkDelegationConfig, error,
+	)
+	// ExternalTrustMarkDelegations returns the state of the delegation JWTs
+	// LightHouse uses for trust mark types owned by other entities.
+	ExternalTrustMarkDelegations() ([]ExternalTrustMarkDelegation, error)
+	// RefreshExternalTrustMarkDelegations fetches renewed delegation JWTs for
+	// the trust mark type, or all trust mark types if empty, whose
+	// delegations are due for renewal; if force is set, all delegations are
+	// fetched.
+	RefreshExternalTrustMarkDelegations(trustMarkType string, force bool) ([]ExternalTrustMarkDelegation, error)
+	// PublishedTrustMarkHealth returns the latest health report of the trust
+	// marks published in the entity configuration; if refresh is set or no
+	// report exists yet, the trust marks are checked right away.
+	PublishedTrustMarkHealth(refresh bool) (*PublishedTrustMarkHealthReport, error)
+	// PendingTrustMarkPushes returns the trust mark pushes whose delivery
+	// failed and is retried.
+	PendingTrustMarkPushes() ([]TrustMarkPush, error)
+	// PushTrustMarks issues and pushes trust marks of the trust mark type, or
+	// all trust mark types with push delivery if empty, to the subjects
+	// whose trust marks are missing or expire soon; if force is set, trust
+	// marks are pushed to all active subjects.
+	PushTrustMarks(trustMarkType string, force bool) ([]TrustMarkPush, error)
+	// PreIssueTrustMarks issues trust marks of the trust mark type, or all
+	// trust mark types with pre-issuance if empty, to the active subjects
+	// whose pre-issued trust marks are missing or expire soon; if force is
+	// set, trust marks are issued to all active subjects.
+	PreIssueTrustMarks(trustMarkType string, force bool) ([]TrustMarkPreIssuance, error)
+	// TrustMarkBundle returns the current pre-issued trust marks of the trust
+	// mark type.
+	TrustMarkBundle(trustMarkType string) (*TrustMarkBundle, error)
+	// HostedLogos returns all hosted logos.
+	HostedLogos() ([]HostedLogo, error)
+	// HostedLogo returns the hosted logo with the passed id.
+	HostedLogo(logoID string) (*HostedLogo, error)
+	// AddLogo validates and hosts the passed image; if sourceURI is set, the
+	// hosted logo is published in place of that external logo URI.
+	AddLogo(data []byte, sourceURI string) (*HostedLogo, error)
+	// ReplaceLogo validates the passed image and replaces the image of the
+	// hosted logo with it.
+	ReplaceLogo(logoID string, data []byte) (*HostedLogo, error)
+	// ImportLogo fetches and validates the external logo at sourceURI and
+	// hosts it in its place, or updates the hosted copy.
+	ImportLogo(sourceURI string) (*HostedLogo, error)
+	// DeleteLogo removes a hosted logo.
+	DeleteLogo(logoID string) error
+	// LogoCheckReport returns the result of the last check of the external
+	// logo URIs.
+	LogoCheckReport() (*LogoCheckReport, error)
+	// CheckLogos fetches and validates all external logo URIs right away.
+	CheckLogos() (*LogoCheckReport, error)
 }
 
 // trustAnchorsHandlers groups handlers for trust anchor endpoints.
@@ -0,0 +1,186 @@
+package adminapi
+
+import (
+	"errors"
+
+	oidfed "github.com/go-oidfed/lib"
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/go-oidfed/lighthouse/storage/model"
+)
+
+// ErrNotTrustMarkOwner is returned by
+// LighthouseController.SetTrustMarkDelegationConfig if LightHouse is not the
+// owner of the trust mark type
+var ErrNotTrustMarkOwner = errors.New("lighthouse is not the owner of the trust mark type")
+
+// TrustMarkDelegationConfig configures the delegation JWTs LightHouse issues
+// as owner of a trust mark type.
+type TrustMarkDelegationConfig struct {
+	// Lifetime is the lifetime of issued delegations in seconds.
+	Lifetime int64 `json:"lifetime"`
+	// RenewBefore is the remaining lifetime in seconds below which a
+	// delegation is renewed.
+	RenewBefore int64 `json:"renew_before"`
+	// Ref is the optional ref claim of the delegations.
+	Ref string `json:"ref,omitempty"`
+	// PushURLs maps trust mark issuers to URLs each new delegation for the
+	// issuer is posted to.
+	PushURLs map[string]string `json:"push_urls,omitempty"`
+}
+
+// TrustMarkDelegation is a delegation JWT issued by LightHouse as owner of a
+// trust mark type.
+type TrustMarkDelegation struct {
+	TrustMarkType string `json:"trust_mark_type"`
+	// Issuer is the trust mark issuer the delegation was issued for.
+	Issuer    string `json:"issuer"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+	JWT       string `json:"jwt"`
+	// PushedAt is the time the delegation was pushed to the issuer.
+	PushedAt int64 `json:"pushed_at,omitempty"`
+	// PushError is the error of the last failed push.
+	PushError string `json:"push_error,omitempty"`
+}
+
+// ExternalTrustMarkDelegation is the state of the delegation JWT LightHouse
+// uses as trust mark issuer for a trust mark type owned by another entity.
+type ExternalTrustMarkDelegation struct {
+	TrustMarkType string `json:"trust_mark_type"`
+	// Owner is the trust mark owner that issues the delegation.
+	Owner     string `json:"owner"`
+	IssuedAt  int64  `json:"iat,omitempty"`
+	ExpiresAt int64  `json:"exp,omitempty"`
+	// URL is the URL renewed delegations were last fetched from.
+	URL string `json:"url,omitempty"`
+	// CheckedAt is the time a renewed delegation was last fetched.
+	CheckedAt int64 `json:"checked_at,omitempty"`
+	// RefreshedAt is the time the delegation was last replaced by a fetched
+	// one.
+	RefreshedAt int64 `json:"refreshed_at,omitempty"`
+	// Error is the error of the last failed fetch.
+	Error string `json:"error,omitempty"`
+}
+
+// trustMarkDelegationHandlers groups handlers for the trust mark delegation
+// endpoints.
+type trustMarkDelegationHandlers struct {
+	types      model.TrustMarkTypesStore
+	controller LighthouseController
+}
+
+func (h *trustMarkDelegationHandlers) list(c *fiber.Ctx) error {
+	delegations, err := h.controller.TrustMarkDelegations()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(oidfed.ErrorServerError(err.Error()))
+	}
+	if trustMarkType := c.Query("trust_mark_type"); trustMarkType != "" {
+		filtered := make([]TrustMarkDelegation, 0, len(delegations))
+		for _, d := range delegations {
+			if d.TrustMarkType == trustMarkType {
+				filtered = append(filtered, d)
+			}
+		}
+		delegations = filtered
+	}
+	return c.JSON(delegations)
+}
+
+func (h *trustMarkDelegationHandlers) renew(c *fiber.Ctx) error {
+	delegations, err := h.controller.RenewTrustMarkDelegations(c.QueryBool("force"))
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(oidfed.ErrorServerError(err.Error()))
+	}
+	return c.JSON(delegations)
+}
+
+func (h *trustMarkDelegationHandlers) listExternal(c *fiber.Ctx) error {
+	delegations, err := h.controller.ExternalTrustMarkDelegations()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(oidfed.ErrorServerError(err.Error()))
+	}
+	return c.JSON(delegations)
+}
+
+func (h *trustMarkDelegationHandlers) refreshExternal(c *fiber.Ctx) error {
+	delegations, err := h.controller.RefreshExternalTrustMarkDelegations(
+		c.Query("trust_mark_type"), c.QueryBool("force"),
+	)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(oidfed.ErrorServerError(err.Error()))
+	}
+	return c.JSON(delegations)
+}
+
+// trustMarkType resolves the :trustMarkTypeID route param to the trust mark
+// type; it writes the error response if the type cannot be resolved.
+func (h *trustMarkDelegationHandlers) trustMarkType(c *fiber.Ctx) (string, error) {
+	item, err := h.types.Get(c.Params("trustMarkTypeID"))
+	if err != nil {
+		if _, ok := errors.AsType[model.NotFoundError](err); ok {
+			return "", c.Status(fiber.StatusNotFound).JSON(oidfed.ErrorNotFound("trust mark type not found"))
+		}
+		return "", c.Status(fiber.StatusInternalServerError).JSON(oidfed.ErrorServerError(err.Error()))
+	}
+	return item.TrustMarkType, nil
+}
+
+func (h *trustMarkDelegationHandlers) getConfig(c *fiber.Ctx) error {
+	trustMarkType, err := h.trustMarkType(c)
+	if trustMarkType == "" {
+		return err
+	}
+	conf, err := h.controller.TrustMarkDelegationConfig(trustMarkType)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(oidfed.ErrorServerError(err.Error()))
+	}
+	return c.JSON(conf)
+}
+
+func (h *trustMarkDelegationHandlers) putConfig(c *fiber.Ctx) error {
+	trustMarkType, err := h.trustMarkType(c)
+	if trustMarkType == "" {
+		return err
+	}
+	var req TrustMarkDelegationConfig
+	if err = c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(oidfed.ErrorInvalidRequest("invalid body"))
+	}
+	if req.Lifetime < 0 || req.RenewBefore < 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(
+			oidfed.ErrorInvalidRequest("lifetime and renew_before must not be negative"),
+		)
+	}
+	if req.Lifetime != 0 && req.RenewBefore >= req.Lifetime {
+		return c.Status(fiber.StatusBadRequest).JSON(
+			oidfed.ErrorInvalidRequest("renew_before must be shorter than the lifetime"),
+		)
+	}
+	conf, err := h.controller.SetTrustMarkDelegationConfig(trustMarkType, req)
+	if err != nil {
+		if errors.Is(err, ErrNotTrustMarkOwner) {
+			return c.Status(fiber.StatusConflict).JSON(oidfed.ErrorInvalidRequest(err.Error()))
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(oidfed.ErrorServerError(err.Error()))
+	}
+	return c.JSON(conf)
+}
+
+// registerTrustMarkDelegations wires the endpoints for the delegation JWTs
+// LightHouse issues as trust mark owner.
+func registerTrustMarkDelegations(r fiber.Router, types model.TrustMarkTypesStore, ctrl LighthouseController) {
+	if ctrl == nil || types == nil {
+		return
+	}
+	h := &trustMarkDelegationHandlers{
+		types:      types,
+		controller: ctrl,
+	}
+	r.Get("/trust-marks/delegations", h.list)
+	r.Post("/trust-marks/delegations", h.renew)
+	r.Get("/trust-marks/delegations/external", h.listExternal)
+	r.Post("/trust-marks/delegations/external", h.refreshExternal)
+	r.Get("/trust-marks/types/:trustMarkTypeID/delegation", h.getConfig)
+	r.Put("/trust-marks/types/:trustMarkTypeID/delegation", h.putConfig)
+}
@@ -4,13 +4,28 @@ import (
 	"errors"
 	"maps"
 	"strings"
+	"time"
 
 	oidfed "github.com/go-oidfed/lib"
 	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
 
+	"github.com/go-oidfed/lighthouse/internal/claimtemplate"
 	"github.com/go-oidfed/lighthouse/storage/model"
 )
 
+// validateTrustMarkSubject checks the validity window and the additional
+// claim templates of a TrustMarkSubject payload.
+func validateTrustMarkSubject(subject *model.AddTrustMarkSubject) error {
+	if subject.NotBefore < 0 || subject.NotAfter < 0 {
+		return errors.New("not_before and not_after must not be negative")
+	}
+	if subject.NotAfter != 0 && subject.NotAfter <= subject.NotBefore {
+		return errors.New("not_after must be after not_before")
+	}
+	return claimtemplate.Validate(subject.AdditionalClaims)
+}
+
 // trustMarkSpecHandlers groups handlers for TrustMarkSpec CRUD endpoints.
 type trustMarkSpecHandlers struct {
 	store model.TrustMarkSpecStore
@@ -32,6 +47,9 @@ func (h *trustMarkSpecHandlers) create(c *fiber.Ctx) error {
 	if spec.TrustMarkType == "" {
 		return c.Status(fiber.StatusBadRequest).JSON(oidfed.ErrorInvalidRequest("trust_mark_type is required"))
 	}
+	if err := claimtemplate.Validate(spec.AdditionalClaims); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(oidfed.ErrorInvalidRequest(err.Error()))
+	}
 	created, err := h.store.Create(&spec)
 	if err != nil {
 		return h.handleError(c, err)
@@ -55,6 +73,9 @@ func (h *trustMarkSpecHandlers) update(c *fiber.Ctx) error {
 	if spec.TrustMarkType == "" {
 		return c.Status(fiber.StatusBadRequest).JSON(oidfed.ErrorInvalidRequest("trust_mark_type is required"))
 	}
+	if err := claimtemplate.Validate(spec.AdditionalClaims); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(oidfed.ErrorInvalidRequest(err.Error()))
+	}
 	updated, err := h.store.Update(c.Params("trustMarkSpecID"), &spec)
 	if err != nil {
 		return h.handleError(c, err)
@@ -67,6 +88,11 @@ func (h *trustMarkSpecHandlers) patch(c *fiber.Ctx) error {
 	if err := c.BodyParser(&updates); err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(oidfed.ErrorInvalidRequest(err.Error()))
 	}
+	if claims, ok := updates["additional_claims"].(map[string]any); ok {
+		if err := claimtemplate.Validate(claims); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(oidfed.ErrorInvalidRequest(err.Error()))
+		}
+	}
 	patched, err := h.store.Patch(c.Params("trustMarkSpecID"), updates)
 	if err != nil {
 		return h.handleError(c, err)
@@ -81,6 +107,14 @@ func (h *trustMarkSpecHandlers) delete(c *fiber.Ctx) error {
 	return c.SendStatus(fiber.StatusNoContent)
 }
 
+func (h *trustMarkSpecHandlers) migrateSubjects(c *fiber.Ctx) error {
+	migration, err := h.store.MigrateSubjects(c.Params("trustMarkSpecID"))
+	if err != nil {
+		return h.handleError(c, err)
+	}
+	return c.JSON(migration)
+}
+
 func (*trustMarkSpecHandlers) handleError(c *fiber.Ctx, err error) error {
 	if notFound, ok := errors.AsType[model.NotFoundError](err); ok {
 		return c.Status(fiber.StatusNotFound).JSON(oidfed.ErrorNotFound(string(notFound)))
@@ -88,12 +122,16 @@ func (*trustMarkSpecHandlers) handleError(c *fiber.Ctx, err error) error {
 	if alreadyExists, ok := errors.AsType[model.AlreadyExistsError](err); ok {
 		return c.Status(fiber.StatusConflict).JSON(oidfed.ErrorInvalidRequest(string(alreadyExists)))
 	}
+	if validationErr, ok := errors.AsType[model.ValidationError](err); ok {
+		return c.Status(fiber.StatusBadRequest).JSON(oidfed.ErrorInvalidRequest(string(validationErr)))
+	}
 	return c.Status(fiber.StatusInternalServerError).JSON(oidfed.ErrorServerError(err.Error()))
 }
 
 // trustMarkSubjectHandlers groups handlers for TrustMarkSubject CRUD endpoints.
 type trustMarkSubjectHandlers struct {
-	store model.TrustMarkSpecStore
+	store  model.TrustMarkSpecStore
+	events model.TrustMarkSubjectEventStore
 }
 
 func (h *trustMarkSubjectHandlers) list(c *fiber.Ctx) error {
@@ -122,6 +160,9 @@ func (h *trustMarkSubjectHandlers) create(c *fiber.Ctx) error {
 	if subject.EntityID == "" {
 		return c.Status(fiber.StatusBadRequest).JSON(oidfed.ErrorInvalidRequest("entity_id is required"))
 	}
+	if err := validateTrustMarkSubject(&subject); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(oidfed.ErrorInvalidRequest(err.Error()))
+	}
 	if !subject.Status.Valid() {
 		subject.Status = model.StatusActive
 	}
@@ -152,6 +193,9 @@ func (h *trustMarkSubjectHandlers) update(c *fiber.Ctx) error {
 	if subject.EntityID == "" {
 		return c.Status(fiber.StatusBadRequest).JSON(oidfed.ErrorInvalidRequest("entity_id is required"))
 	}
+	if err := validateTrustMarkSubject(&subject); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(oidfed.ErrorInvalidRequest(err.Error()))
+	}
 	updated, err := h.store.UpdateSubject(specID, subjectID, &subject)
 	if err != nil {
 		return h.handleError(c, err)
@@ -184,9 +228,62 @@ func (h *trustMarkSubjectHandlers) updateStatus(c *fiber.Ctx) error {
 	if err != nil {
 		return h.handleError(c, err)
 	}
+	if h.events != nil {
+		if err = h.events.Add(
+			model.TrustMarkSubjectEvent{
+				TrustMarkSubjectID: updated.ID,
+				Timestamp:          time.Now().Unix(),
+				Type:               model.EventTypeStatusUpdated,
+				Status:             new(status.String()),
+				Actor:              new(GetActor(c)),
+			},
+		); err != nil {
+			log.Warn().Err(err).Uint("trust_mark_subject_id", updated.ID).Msg("failed to record status_updated event")
+		}
+	}
 	return c.JSON(updated)
 }
 
+func (h *trustMarkSubjectHandlers) getHistory(c *fiber.Ctx) error {
+	subject, err := h.store.GetSubject(c.Params("trustMarkSpecID"), c.Params("trustMarkSubjectID"))
+	if err != nil {
+		return h.handleError(c, err)
+	}
+
+	hh := &historyHandlers{}
+	opts, ok := hh.parseQueryOpts(c)
+	if !ok {
+		return nil
+	}
+
+	eventsList, total, err := h.events.GetBySubjectID(subject.ID, opts)
+	if err != nil {
+		return writeServerError(c, err)
+	}
+
+	eventsResp := make([]eventResponse, len(eventsList))
+	for i, e := range eventsList {
+		eventsResp[i] = eventResponse{
+			Timestamp: e.Timestamp,
+			Type:      e.Type,
+			Status:    e.Status,
+			Message:   e.Message,
+			Actor:     e.Actor,
+		}
+	}
+
+	return c.JSON(
+		fiber.Map{
+			"events": eventsResp,
+			"pagination": fiber.Map{
+				"total":  total,
+				"limit":  hh.normalizeLimit(opts.Limit),
+				"offset": opts.Offset,
+			},
+		},
+	)
+}
+
 func (h *trustMarkSubjectHandlers) getAdditionalClaims(c *fiber.Ctx) error {
 	specID := c.Params("trustMarkSpecID")
 	subjectID := c.Params("trustMarkSubjectID")
@@ -207,6 +304,9 @@ func (h *trustMarkSubjectHandlers) putAdditionalClaims(c *fiber.Ctx) error {
 	if err := c.BodyParser(&claims); err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(oidfed.ErrorInvalidRequest(err.Error()))
 	}
+	if err := claimtemplate.Validate(claims); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(oidfed.ErrorInvalidRequest(err.Error()))
+	}
 	subject, err := h.store.GetSubject(specID, subjectID)
 	if err != nil {
 		return h.handleError(c, err)
@@ -216,6 +316,10 @@ func (h *trustMarkSubjectHandlers) putAdditionalClaims(c *fiber.Ctx) error {
 		Status:           subject.Status,
 		Description:      subject.Description,
 		AdditionalClaims: claims,
+		PushURL:          subject.PushURL,
+		Lifetime:         subject.Lifetime,
+		NotBefore:        subject.NotBefore,
+		NotAfter:         subject.NotAfter,
 	}
 	updated, err := h.store.UpdateSubject(specID, subjectID, updatePayload)
 	if err != nil {
@@ -250,6 +354,10 @@ func (h *trustMarkSubjectHandlers) copyAdditionalClaims(c *fiber.Ctx) error {
 		Status:           subject.Status,
 		Description:      subject.Description,
 		AdditionalClaims: mergedClaims,
+		PushURL:          subject.PushURL,
+		Lifetime:         subject.Lifetime,
+		NotBefore:        subject.NotBefore,
+		NotAfter:         subject.NotAfter,
 	}
 	updated, err := h.store.UpdateSubject(specID, subjectID, updatePayload)
 	if err != nil {
@@ -269,12 +377,18 @@ func (*trustMarkSubjectHandlers) handleError(c *fiber.Ctx, err error) error {
 }
 
 // registerTrustMarkIssuance registers TrustMarkSpec and TrustMarkSubject endpoints.
-func registerTrustMarkIssuance(r fiber.Router, store model.TrustMarkSpecStore) {
+// The subject history endpoint is only registered if events is not nil.
+func registerTrustMarkIssuance(
+	r fiber.Router, store model.TrustMarkSpecStore, events model.TrustMarkSubjectEventStore,
+) {
 	specBase := "/trust-marks/issuance-spec"
 	subjectBase := specBase + "/:trustMarkSpecID/subjects"
 
 	specH := &trustMarkSpecHandlers{store: store}
-	subjectH := &trustMarkSubjectHandlers{store: store}
+	subjectH := &trustMarkSubjectHandlers{
+		store:  store,
+		events: events,
+	}
 
 	// TrustMarkSpec CRUD
 	r.Get(specBase, specH.list)
@@ -283,6 +397,7 @@ func registerTrustMarkIssuance(r fiber.Router, store model.TrustMarkSpecStore) {
 	r.Put(specBase+"/:trustMarkSpecID", specH.update)
 	r.Patch(specBase+"/:trustMarkSpecID", specH.patch)
 	r.Delete(specBase+"/:trustMarkSpecID", specH.delete)
+	r.Post(specBase+"/:trustMarkSpecID/migrate", specH.migrateSubjects)
 
 	// TrustMarkSubject CRUD
 	r.Get(subjectBase, subjectH.list)
@@ -291,6 +406,9 @@ func registerTrustMarkIssuance(r fiber.Router, store model.TrustMarkSpecStore) {
 	r.Put(subjectBase+"/:trustMarkSubjectID", subjectH.update)
 	r.Delete(subjectBase+"/:trustMarkSubjectID", subjectH.delete)
 	r.Put(subjectBase+"/:trustMarkSubjectID/status", subjectH.updateStatus)
+	if events != nil {
+		r.Get(subjectBase+"/:trustMarkSubjectID/history", subjectH.getHistory)
+	}
 
 	// Subject additional claims
 	r.Get(subjectBase+"/:trustMarkSubjectID/additional-claims", subjectH.getAdditionalClaims)
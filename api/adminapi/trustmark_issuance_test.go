@@ -119,7 +119,7 @@ func (m *mockTrustMarkSpecStore) ChangeSubjectStatus(specID, subjectID string, s
 func setupTrustMarkIssuanceApp(t *testing.T, store model.TrustMarkSpecStore) *fiber.App {
 	t.Helper()
 	app := fiber.New()
-	registerTrustMarkIssuance(app, store)
+	registerTrustMarkIssuance(app, store, nil)
 	return app
 }
 
@@ -229,6 +229,18 @@ func TestTrustMarkSpecHandlers_Create(t *testing.T) {
 		assertErrorResponse(t, resp, body, http.StatusBadRequest, "invalid_request")
 	})
 
+	t.Run("InvalidClaimTemplate", func(t *testing.T) {
+		t.Parallel()
+		app := setupTrustMarkIssuanceApp(t, &mockTrustMarkSpecStore{})
+
+		body := `{"trust_mark_type": "type1", "additional_claims": {"org": "{{ exec \"ls\" }}"}}`
+		req := httptest.NewRequest("POST", "/trust-marks/issuance-spec", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		resp, respBody := doRequest(t, app, req)
+
+		assertErrorResponse(t, resp, respBody, http.StatusBadRequest, "invalid_request")
+	})
+
 	t.Run("AlreadyExists", func(t *testing.T) {
 		t.Parallel()
 		mockStore := &mockTrustMarkSpecStore{
@@ -603,6 +615,30 @@ func TestTrustMarkSubjectHandlers_Create(t *testing.T) {
 		assertErrorResponse(t, resp, body, http.StatusBadRequest, "invalid_request")
 	})
 
+	t.Run("InvalidValidityWindow", func(t *testing.T) {
+		t.Parallel()
+		app := setupTrustMarkIssuanceApp(t, &mockTrustMarkSpecStore{})
+
+		body := `{"entity_id": "sub1", "not_before": 1800000000, "not_after": 1700000000}`
+		req := httptest.NewRequest("POST", "/trust-marks/issuance-spec/1/subjects", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		resp, respBody := doRequest(t, app, req)
+
+		assertErrorResponse(t, resp, respBody, http.StatusBadRequest, "invalid_request")
+	})
+
+	t.Run("InvalidClaimTemplate", func(t *testing.T) {
+		t.Parallel()
+		app := setupTrustMarkIssuanceApp(t, &mockTrustMarkSpecStore{})
+
+		body := `{"entity_id": "sub1", "additional_claims": {"org": "{{ .metadata"}}`
+		req := httptest.NewRequest("POST", "/trust-marks/issuance-spec/1/subjects", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		resp, respBody := doRequest(t, app, req)
+
+		assertErrorResponse(t, resp, respBody, http.StatusBadRequest, "invalid_request")
+	})
+
 	t.Run("MissingEntityID", func(t *testing.T) {
 		t.Parallel()
 		app := setupTrustMarkIssuanceApp(t, &mockTrustMarkSpecStore{})
@@ -1518,3 +1554,205 @@ func TestTrustMarkSubjectHandlers_RealStoragePersistence(t *testing.T) {
 		}
 	})
 }
+
+func TestTrustMarkSubjectHandlers_History(t *testing.T) {
+	t.Parallel()
+
+	store := newTestStorage(t)
+	specStore := store.TrustMarkSpecStorage()
+	eventStore := store.TrustMarkSubjectEventsStorage()
+	app := fiber.New()
+	registerTrustMarkIssuance(app, specStore, eventStore)
+
+	if _, err := specStore.Create(&model.AddTrustMarkSpec{TrustMarkType: "type-history"}); err != nil {
+		t.Fatalf("failed to seed spec: %v", err)
+	}
+	subject, err := specStore.CreateSubject(
+		"type-history", &model.AddTrustMarkSubject{
+			EntityID: "subject-history",
+			Status:   model.StatusActive,
+		},
+	)
+	if err != nil {
+		t.Fatalf("failed to seed subject: %v", err)
+	}
+	msg := "entity failed re-validation"
+	if err = eventStore.Add(
+		model.TrustMarkSubjectEvent{
+			TrustMarkSubjectID: subject.ID,
+			Timestamp:          1,
+			Type:               model.EventTypeRevalidationFailed,
+			Message:            &msg,
+		},
+	); err != nil {
+		t.Fatalf("failed to seed event: %v", err)
+	}
+
+	req := httptest.NewRequest(
+		http.MethodPut, "/trust-marks/issuance-spec/type-history/subjects/subject-history/status",
+		strings.NewReader("inactive"),
+	)
+	resp, respBody := doRequest(t, app, req)
+	requireStatus(t, resp, respBody, http.StatusOK)
+
+	t.Run("ListsEventsNewestFirst", func(t *testing.T) {
+		req := httptest.NewRequest(
+			http.MethodGet, "/trust-marks/issuance-spec/type-history/subjects/subject-history/history", nil,
+		)
+		resp, respBody := doRequest(t, app, req)
+		requireStatus(t, resp, respBody, http.StatusOK)
+
+		var result struct {
+			Events []struct {
+				Type    string  `json:"type"`
+				Status  *string `json:"status"`
+				Message *string `json:"message"`
+			} `json:"events"`
+			Pagination struct {
+				Total int64 `json:"total"`
+				Limit int   `json:"limit"`
+			} `json:"pagination"`
+		}
+		if err := json.Unmarshal(respBody, &result); err != nil {
+			t.Fatalf("failed to unmarshal history response: %v", err)
+		}
+		if result.Pagination.Total != 2 || len(result.Events) != 2 {
+			t.Fatalf("expected 2 events, got %+v", result)
+		}
+		if result.Events[0].Type != model.EventTypeStatusUpdated ||
+			result.Events[0].Status == nil || *result.Events[0].Status != "inactive" {
+			t.Fatalf("unexpected first event: %+v", result.Events[0])
+		}
+		if result.Events[1].Type != model.EventTypeRevalidationFailed {
+			t.Fatalf("unexpected second event: %+v", result.Events[1])
+		}
+	})
+
+	t.Run("FiltersByType", func(t *testing.T) {
+		req := httptest.NewRequest(
+			http.MethodGet,
+			"/trust-marks/issuance-spec/type-history/subjects/subject-history/history?type=revalidation_failed", nil,
+		)
+		resp, respBody := doRequest(t, app, req)
+		requireStatus(t, resp, respBody, http.StatusOK)
+		if !strings.Contains(string(respBody), `"total":1`) {
+			t.Fatalf("expected one filtered event, got %s", respBody)
+		}
+	})
+
+	t.Run("UnknownSubject", func(t *testing.T) {
+		req := httptest.NewRequest(
+			http.MethodGet, "/trust-marks/issuance-spec/type-history/subjects/unknown/history", nil,
+		)
+		resp, respBody := doRequest(t, app, req)
+		requireStatus(t, resp, respBody, http.StatusNotFound)
+	})
+
+	t.Run("InvalidLimit", func(t *testing.T) {
+		req := httptest.NewRequest(
+			http.MethodGet, "/trust-marks/issuance-spec/type-history/subjects/subject-history/history?limit=x", nil,
+		)
+		resp, respBody := doRequest(t, app, req)
+		requireStatus(t, resp, respBody, http.StatusBadRequest)
+	})
+}
+
+func TestTrustMarkSpecHandlers_Lifecycle(t *testing.T) {
+	t.Parallel()
+	app, specStore := setupRealTrustMarkIssuanceApp(t)
+
+	body := `{
+		"trust_mark_type": "type-v1",
+		"lifetime": 3600,
+		"additional_claims": {"level": "gold"},
+		"lifecycle_state": "deprecated",
+		"successor_type": "type-v2"
+	}`
+	req := httptest.NewRequest(http.MethodPost, "/trust-marks/issuance-spec", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	resp, respBody := doRequest(t, app, req)
+	requireStatus(t, resp, respBody, http.StatusCreated)
+	var created model.TrustMarkSpec
+	if err := json.Unmarshal(respBody, &created); err != nil {
+		t.Fatalf("failed to unmarshal create response: %v", err)
+	}
+	if created.LifecycleState != model.TrustMarkLifecycleDeprecated || created.DeprecatedAt == 0 {
+		t.Fatalf("unexpected lifecycle: %+v", created)
+	}
+
+	for _, s := range []struct {
+		entityID string
+		status   model.Status
+	}{
+		{"https://active.example.org", model.StatusActive},
+		{"https://pending.example.org", model.StatusPending},
+		{"https://blocked.example.org", model.StatusBlocked},
+	} {
+		if _, err := specStore.CreateSubject(
+			"type-v1", &model.AddTrustMarkSubject{
+				EntityID:         s.entityID,
+				Status:           s.status,
+				AdditionalClaims: map[string]any{"ref": s.entityID},
+			},
+		); err != nil {
+			t.Fatalf("failed to create subject: %v", err)
+		}
+	}
+
+	t.Run("InvalidLifecycleState", func(t *testing.T) {
+		req := httptest.NewRequest(
+			http.MethodPatch, "/trust-marks/issuance-spec/type-v1", strings.NewReader(`{"lifecycle_state":"gone"}`),
+		)
+		req.Header.Set("Content-Type", "application/json")
+		resp, respBody := doRequest(t, app, req)
+		requireStatus(t, resp, respBody, http.StatusBadRequest)
+	})
+
+	t.Run("Migrate", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/trust-marks/issuance-spec/type-v1/migrate", nil)
+		resp, respBody := doRequest(t, app, req)
+		requireStatus(t, resp, respBody, http.StatusOK)
+		var migration model.TrustMarkSubjectMigration
+		if err := json.Unmarshal(respBody, &migration); err != nil {
+			t.Fatalf("failed to unmarshal migration response: %v", err)
+		}
+		if !migration.SpecCreated || len(migration.Migrated) != 2 || len(migration.Skipped) != 0 {
+			t.Fatalf("unexpected migration: %+v", migration)
+		}
+		successor, err := specStore.Get("type-v2")
+		if err != nil {
+			t.Fatalf("failed to load successor spec: %v", err)
+		}
+		if successor.Lifetime != 3600 || successor.AdditionalClaims["level"] != "gold" ||
+			successor.LifecycleState.Deprecated() {
+			t.Fatalf("unexpected successor spec: %+v", successor)
+		}
+		subject, err := specStore.GetSubject("type-v2", "https://pending.example.org")
+		if err != nil {
+			t.Fatalf("failed to load migrated subject: %v", err)
+		}
+		if subject.Status != model.StatusPending || subject.AdditionalClaims["ref"] != "https://pending.example.org" {
+			t.Fatalf("unexpected migrated subject: %+v", subject)
+		}
+		if _, err = specStore.GetSubject("type-v2", "https://blocked.example.org"); err == nil {
+			t.Fatal("expected blocked subject not to be migrated")
+		}
+
+		// A second migration skips the existing subjects
+		req = httptest.NewRequest(http.MethodPost, "/trust-marks/issuance-spec/type-v1/migrate", nil)
+		resp, respBody = doRequest(t, app, req)
+		requireStatus(t, resp, respBody, http.StatusOK)
+		if err := json.Unmarshal(respBody, &migration); err != nil {
+			t.Fatalf("failed to unmarshal migration response: %v", err)
+		}
+		if migration.SpecCreated || len(migration.Migrated) != 0 || len(migration.Skipped) != 2 {
+			t.Fatalf("unexpected second migration: %+v", migration)
+		}
+	})
+
+	t.Run("MigrateWithoutSuccessor", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/trust-marks/issuance-spec/type-v2/migrate", nil)
+		resp, respBody := doRequest(t, app, req)
+		requireStatus(t, resp, respBody, http.StatusBadRequest)
+	})
+}
@@ -0,0 +1,84 @@
+package adminapi
+
+import (
+	"errors"
+
+	oidfed "github.com/go-oidfed/lib"
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/go-oidfed/lighthouse/storage/model"
+)
+
+// PreIssuedTrustMark is a trust mark issued in advance to a trust mark
+// subject.
+type PreIssuedTrustMark struct {
+	Subject   string `json:"sub"`
+	TrustMark string `json:"trust_mark"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp,omitempty"`
+}
+
+// TrustMarkBundle holds the current pre-issued trust marks of a trust mark
+// type for the distribution to the subjects in bulk.
+type TrustMarkBundle struct {
+	TrustMarkType string `json:"trust_mark_type"`
+	// UpdatedAt is the time of the last pre-issuance run for the type.
+	UpdatedAt  int64                `json:"updated_at"`
+	TrustMarks []PreIssuedTrustMark `json:"trust_marks"`
+}
+
+// TrustMarkPreIssuance is the result of a pre-issuance run for a trust mark
+// type.
+type TrustMarkPreIssuance struct {
+	TrustMarkType string `json:"trust_mark_type"`
+	// Issued is the number of trust marks issued in the run.
+	Issued int `json:"issued"`
+	// Current is the number of still current trust marks that were kept.
+	Current int `json:"current"`
+	// Failed is the number of subjects no trust mark could be issued for.
+	Failed int `json:"failed"`
+	// Ineligible is the number of subjects that failed the eligibility check
+	// of the trust mark type.
+	Ineligible int `json:"ineligible"`
+}
+
+// trustMarkPreIssuanceHandlers groups handlers for the trust mark
+// pre-issuance endpoints.
+type trustMarkPreIssuanceHandlers struct {
+	controller LighthouseController
+}
+
+func (h *trustMarkPreIssuanceHandlers) run(c *fiber.Ctx) error {
+	results, err := h.controller.PreIssueTrustMarks(c.Query("trust_mark_type"), c.QueryBool("force"))
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(oidfed.ErrorServerError(err.Error()))
+	}
+	return c.JSON(results)
+}
+
+func (h *trustMarkPreIssuanceHandlers) bundle(c *fiber.Ctx) error {
+	trustMarkType := c.Query("trust_mark_type")
+	if trustMarkType == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(oidfed.ErrorInvalidRequest("trust_mark_type is required"))
+	}
+	bundle, err := h.controller.TrustMarkBundle(trustMarkType)
+	if err != nil {
+		if notFound, ok := errors.AsType[model.NotFoundError](err); ok {
+			return c.Status(fiber.StatusNotFound).JSON(oidfed.ErrorNotFound(string(notFound)))
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(oidfed.ErrorServerError(err.Error()))
+	}
+	c.Set(fiber.HeaderContentDisposition, "attachment; filename=trust-mark-bundle.json")
+	return c.JSON(bundle)
+}
+
+// registerTrustMarkPreIssuance wires the endpoints for the pre-issuance of
+// trust marks and the download of trust mark bundles.
+func registerTrustMarkPreIssuance(r fiber.Router, ctrl LighthouseController) {
+	if ctrl == nil {
+		return
+	}
+	h := &trustMarkPreIssuanceHandlers{controller: ctrl}
+	r.Post("/trust-marks/pre-issuance", h.run)
+	r.Get("/trust-marks/pre-issuance/bundle", h.bundle)
+}
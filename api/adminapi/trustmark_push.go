@@ -0,0 +1,57 @@
+package adminapi
+
+import (
+	oidfed "github.com/go-oidfed/lib"
+	"github.com/gofiber/fiber/v2"
+)
+
+// TrustMarkPush is the state of pushing a trust mark to a trust mark subject.
+type TrustMarkPush struct {
+	TrustMarkType string `json:"trust_mark_type"`
+	Subject       string `json:"subject"`
+	// URL is the URL the trust mark is pushed to.
+	URL string `json:"url,omitempty"`
+	// ExpiresAt is the expiration of the pushed trust mark.
+	ExpiresAt int64 `json:"exp,omitempty"`
+	// Attempts is the number of failed delivery attempts.
+	Attempts int `json:"attempts"`
+	// DeliveredAt is the time the trust mark was delivered.
+	DeliveredAt int64 `json:"delivered_at,omitempty"`
+	// NextAttemptAt is the time of the next delivery attempt of a pending
+	// push.
+	NextAttemptAt int64 `json:"next_attempt_at,omitempty"`
+	// Error is the error of the last failed delivery attempt.
+	Error string `json:"error,omitempty"`
+}
+
+// trustMarkPushHandlers groups handlers for the trust mark push endpoints.
+type trustMarkPushHandlers struct {
+	controller LighthouseController
+}
+
+func (h *trustMarkPushHandlers) pending(c *fiber.Ctx) error {
+	pushes, err := h.controller.PendingTrustMarkPushes()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(oidfed.ErrorServerError(err.Error()))
+	}
+	return c.JSON(pushes)
+}
+
+func (h *trustMarkPushHandlers) push(c *fiber.Ctx) error {
+	pushes, err := h.controller.PushTrustMarks(c.Query("trust_mark_type"), c.QueryBool("force"))
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(oidfed.ErrorServerError(err.Error()))
+	}
+	return c.JSON(pushes)
+}
+
+// registerTrustMarkPush wires the endpoints for the push delivery of trust
+// marks to trust mark subjects.
+func registerTrustMarkPush(r fiber.Router, ctrl LighthouseController) {
+	if ctrl == nil {
+		return
+	}
+	h := &trustMarkPushHandlers{controller: ctrl}
+	r.Get("/trust-marks/push", h.pending)
+	r.Post("/trust-marks/push", h.push)
+}
@@ -0,0 +1,136 @@
+package adminapi
+
+import (
+	"errors"
+	"time"
+
+	oidfed "github.com/go-oidfed/lib"
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/go-oidfed/lighthouse/internal/stats"
+	"github.com/go-oidfed/lighthouse/storage/model"
+)
+
+// defaultTrustMarkReportRange is the report range used if no 'from' is given.
+const defaultTrustMarkReportRange = 30 * 24 * time.Hour
+
+// trustMarkReportHandlers groups handlers for the trust mark issuance report
+// endpoints.
+type trustMarkReportHandlers struct {
+	reports model.TrustMarkReportStore
+}
+
+// parseTrustMarkReportFilter parses the report filter from the query
+// parameters from, to, trust_mark_type and interval. If it is invalid, a 400
+// response is written and false is returned.
+func parseTrustMarkReportFilter(c *fiber.Ctx) (model.TrustMarkReportFilter, bool) {
+	from, to := parseTimeRange(c)
+	if c.Query("from") == "" {
+		from = to.Add(-defaultTrustMarkReportRange)
+	}
+	filter := model.TrustMarkReportFilter{
+		From:          from,
+		To:            to,
+		TrustMarkType: c.Query("trust_mark_type"),
+		Interval:      stats.Interval(c.Query("interval", string(stats.IntervalDay))),
+	}
+	switch filter.Interval {
+	case stats.IntervalDay, stats.IntervalWeek, stats.IntervalMonth:
+	default:
+		_ = c.Status(fiber.StatusBadRequest).JSON(
+			oidfed.ErrorInvalidRequest("invalid interval; supported are day, week and month"),
+		)
+		return filter, false
+	}
+	if to.Before(from) {
+		_ = c.Status(fiber.StatusBadRequest).JSON(oidfed.ErrorInvalidRequest("'to' must not be before 'from'"))
+		return filter, false
+	}
+	return filter, true
+}
+
+// report returns the issuance report per trust mark type and period.
+// GET /trust-marks/reports?from=&to=&trust_mark_type=&interval=day|week|month
+func (h *trustMarkReportHandlers) report(c *fiber.Ctx) error {
+	filter, ok := parseTrustMarkReportFilter(c)
+	if !ok {
+		return nil
+	}
+	entries, err := h.reports.Report(filter)
+	if err != nil {
+		return h.handleError(c, err)
+	}
+	if entries == nil {
+		entries = []model.TrustMarkReportEntry{}
+	}
+	return c.JSON(
+		fiber.Map{
+			"from":     filter.From,
+			"to":       filter.To,
+			"interval": filter.Interval,
+			"entries":  entries,
+		},
+	)
+}
+
+// holders returns the subjects that were issued or had revoked trust marks.
+// GET /trust-marks/reports/holders?from=&to=&trust_mark_type=
+func (h *trustMarkReportHandlers) holders(c *fiber.Ctx) error {
+	filter, ok := parseTrustMarkReportFilter(c)
+	if !ok {
+		return nil
+	}
+	holders, err := h.reports.Holders(filter)
+	if err != nil {
+		return h.handleError(c, err)
+	}
+	return c.JSON(
+		fiber.Map{
+			"from":    filter.From,
+			"to":      filter.To,
+			"holders": holders,
+		},
+	)
+}
+
+// export exports the issuance report.
+// GET /trust-marks/reports/export?from=&to=&trust_mark_type=&interval=&format=csv|json
+func (h *trustMarkReportHandlers) export(c *fiber.Ctx) error {
+	filter, ok := parseTrustMarkReportFilter(c)
+	if !ok {
+		return nil
+	}
+	switch c.Query("format", "csv") {
+	case "json":
+		c.Set(fiber.HeaderContentType, "application/json")
+		c.Set(fiber.HeaderContentDisposition, "attachment; filename=trust-mark-report.json")
+		return h.reports.ExportJSON(filter, c.Response().BodyWriter())
+
+	case "csv":
+		fallthrough
+	default:
+		c.Set(fiber.HeaderContentType, "text/csv")
+		c.Set(fiber.HeaderContentDisposition, "attachment; filename=trust-mark-report.csv")
+		return h.reports.ExportCSV(filter, c.Response().BodyWriter())
+	}
+}
+
+func (*trustMarkReportHandlers) handleError(c *fiber.Ctx, err error) error {
+	if invalid, ok := errors.AsType[model.ValidationError](err); ok {
+		return c.Status(fiber.StatusBadRequest).JSON(oidfed.ErrorInvalidRequest(string(invalid)))
+	}
+	return c.Status(fiber.StatusInternalServerError).JSON(oidfed.ErrorServerError(err.Error()))
+}
+
+// registerTrustMarkReports registers the trust mark issuance report
+// endpoints.
+func registerTrustMarkReports(r fiber.Router, reports model.TrustMarkReportStore) {
+	if reports == nil {
+		return
+	}
+	h := &trustMarkReportHandlers{reports: reports}
+	base := "/trust-marks/reports"
+	r.Get(base, h.report)
+	r.Get(base+"/holders", h.holders)
+	r.Get(base+"/export", h.export)
+}
@@ -0,0 +1,146 @@
+package adminapi
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/go-oidfed/lighthouse/storage"
+	"github.com/go-oidfed/lighthouse/storage/model"
+)
+
+const testReportTrustMarkType = "https://tm.example.org/reported"
+
+func setupTrustMarkReportsApp(t *testing.T) *fiber.App {
+	t.Helper()
+	store := newTestStorage(t)
+	specs := store.TrustMarkSpecStorage()
+	if _, err := specs.Create(&model.AddTrustMarkSpec{TrustMarkType: testReportTrustMarkType}); err != nil {
+		t.Fatalf("failed to create spec: %v", err)
+	}
+	subject, err := specs.CreateSubject(
+		testReportTrustMarkType, &model.AddTrustMarkSubject{
+			EntityID: "https://rp.example.org",
+			Status:   model.StatusActive,
+		},
+	)
+	if err != nil {
+		t.Fatalf("failed to create subject: %v", err)
+	}
+	issued := time.Date(2025, 3, 3, 10, 0, 0, 0, time.UTC).Unix()
+	for _, instance := range []model.IssuedTrustMarkInstance{
+		{
+			JTI:                "1",
+			CreatedAt:          int(issued),
+			TrustMarkType:      testReportTrustMarkType,
+			Subject:            "https://rp.example.org",
+			TrustMarkSubjectID: subject.ID,
+		},
+		{
+			JTI:                "2",
+			CreatedAt:          int(issued + 3600),
+			TrustMarkType:      testReportTrustMarkType,
+			Subject:            "https://rp.example.org",
+			TrustMarkSubjectID: subject.ID,
+		},
+	} {
+		if err := store.DB().Create(&instance).Error; err != nil {
+			t.Fatalf("failed to create instance: %v", err)
+		}
+	}
+	app := fiber.New()
+	registerTrustMarkReports(app, storage.NewTrustMarkReportsStorage(store.DB()))
+	return app
+}
+
+func TestTrustMarkReports_Report(t *testing.T) {
+	app := setupTrustMarkReportsApp(t)
+
+	resp, body := doRequest(
+		t, app, httptest.NewRequest(
+			http.MethodGet, "/trust-marks/reports?from=2025-03-01&to=2025-03-31&interval=month", nil,
+		),
+	)
+	requireStatus(t, resp, body, fiber.StatusOK)
+	var report struct {
+		Entries []model.TrustMarkReportEntry `json:"entries"`
+	}
+	if err := json.Unmarshal(body, &report); err != nil {
+		t.Fatalf("failed to parse response: %v", err)
+	}
+	if len(report.Entries) != 1 {
+		t.Fatalf("expected 1 entry, got %+v", report.Entries)
+	}
+	if e := report.Entries[0]; e.Issued != 2 || e.Reissued != 1 || e.ActiveHolders != 1 {
+		t.Errorf("unexpected entry: %+v", e)
+	}
+
+	resp, body = doRequest(
+		t, app, httptest.NewRequest(http.MethodGet, "/trust-marks/reports/holders?from=2025-03-01&to=2025-03-31", nil),
+	)
+	requireStatus(t, resp, body, fiber.StatusOK)
+	var holders struct {
+		Holders []model.TrustMarkHolderReport `json:"holders"`
+	}
+	if err := json.Unmarshal(body, &holders); err != nil {
+		t.Fatalf("failed to parse response: %v", err)
+	}
+	if len(holders.Holders) != 1 || holders.Holders[0].Subject != "https://rp.example.org" ||
+		holders.Holders[0].Issued != 2 {
+		t.Errorf("unexpected holders: %+v", holders.Holders)
+	}
+}
+
+func TestTrustMarkReports_Export(t *testing.T) {
+	app := setupTrustMarkReportsApp(t)
+
+	resp, body := doRequest(
+		t, app, httptest.NewRequest(
+			http.MethodGet, "/trust-marks/reports/export?from=2025-03-03&to=2025-03-04&format=csv", nil,
+		),
+	)
+	requireStatus(t, resp, body, fiber.StatusOK)
+	if ct := resp.Header.Get(fiber.HeaderContentType); ct != "text/csv" {
+		t.Errorf("unexpected content type %q", ct)
+	}
+	records, err := csv.NewReader(strings.NewReader(string(body))).ReadAll()
+	if err != nil {
+		t.Fatalf("failed to parse CSV: %v", err)
+	}
+	// header and one row per day
+	if len(records) != 3 || records[1][2] != "2" || records[2][2] != "0" {
+		t.Errorf("unexpected CSV: %v", records)
+	}
+
+	resp, body = doRequest(
+		t, app, httptest.NewRequest(
+			http.MethodGet, "/trust-marks/reports/export?from=2025-03-03&to=2025-03-03&format=json", nil,
+		),
+	)
+	requireStatus(t, resp, body, fiber.StatusOK)
+	var entry model.TrustMarkReportEntry
+	if err = json.Unmarshal(body, &entry); err != nil {
+		t.Fatalf("failed to parse JSON: %v", err)
+	}
+	if entry.TrustMarkType != testReportTrustMarkType || entry.Issued != 2 {
+		t.Errorf("unexpected entry: %+v", entry)
+	}
+}
+
+func TestTrustMarkReports_InvalidInput(t *testing.T) {
+	app := setupTrustMarkReportsApp(t)
+
+	resp, body := doRequest(t, app, httptest.NewRequest(http.MethodGet, "/trust-marks/reports?interval=hour", nil))
+	assertStatus(t, resp, body, fiber.StatusBadRequest)
+
+	resp, body = doRequest(
+		t, app, httptest.NewRequest(http.MethodGet, "/trust-marks/reports?from=2025-03-04&to=2025-03-01", nil),
+	)
+	assertStatus(t, resp, body, fiber.StatusBadRequest)
+}
@@ -0,0 +1,203 @@
+package adminapi
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	oidfed "github.com/go-oidfed/lib"
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
+
+	"github.com/go-oidfed/lighthouse/storage/model"
+)
+
+// trustMarkRequestDecision is the payload for approving or rejecting a trust
+// mark request.
+type trustMarkRequestDecision struct {
+	Reason string `json:"reason"`
+	// Block blocks the subject from the trust mark on rejection; otherwise
+	// the subject can submit a new request.
+	Block bool `json:"block"`
+}
+
+// trustMarkRequestHandlers groups handlers for the trust mark request review
+// endpoints.
+type trustMarkRequestHandlers struct {
+	requests model.TrustMarkRequestStore
+	specs    model.TrustMarkSpecStore
+	events   model.TrustMarkSubjectEventStore
+}
+
+func (h *trustMarkRequestHandlers) list(c *fiber.Ctx) error {
+	filter := model.TrustMarkRequestFilter{
+		TrustMarkType: c.Query("trust_mark_type"),
+		EntityID:      c.Query("entity_id"),
+		Status:        model.TrustMarkRequestStatus(c.Query("status")),
+	}
+	if filter.Status != "" && !filter.Status.Valid() {
+		return c.Status(fiber.StatusBadRequest).JSON(oidfed.ErrorInvalidRequest("invalid status"))
+	}
+	requests, err := h.requests.List(filter)
+	if err != nil {
+		return h.handleError(c, err)
+	}
+	return c.JSON(requests)
+}
+
+func (h *trustMarkRequestHandlers) get(c *fiber.Ctx) error {
+	id, ok := requestID(c)
+	if !ok {
+		return nil
+	}
+	request, err := h.requests.Get(id)
+	if err != nil {
+		return h.handleError(c, err)
+	}
+	return c.JSON(request)
+}
+
+func (h *trustMarkRequestHandlers) comment(c *fiber.Ctx) error {
+	id, ok := requestID(c)
+	if !ok {
+		return nil
+	}
+	var body struct {
+		Comment string `json:"comment"`
+	}
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(oidfed.ErrorInvalidRequest(err.Error()))
+	}
+	if strings.TrimSpace(body.Comment) == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(oidfed.ErrorInvalidRequest("comment is required"))
+	}
+	comment, err := h.requests.AddComment(id, GetActor(c), body.Comment)
+	if err != nil {
+		return h.handleError(c, err)
+	}
+	return c.Status(fiber.StatusCreated).JSON(comment)
+}
+
+func (h *trustMarkRequestHandlers) approve(c *fiber.Ctx) error {
+	return h.decide(c, model.TrustMarkRequestStatusApproved)
+}
+
+func (h *trustMarkRequestHandlers) reject(c *fiber.Ctx) error {
+	return h.decide(c, model.TrustMarkRequestStatusRejected)
+}
+
+// decide approves or rejects a pending request and updates the status of the
+// trust mark subject accordingly: approval activates the subject, rejection
+// deactivates or blocks it.
+func (h *trustMarkRequestHandlers) decide(c *fiber.Ctx, decision model.TrustMarkRequestStatus) error {
+	id, ok := requestID(c)
+	if !ok {
+		return nil
+	}
+	var body trustMarkRequestDecision
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&body); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(oidfed.ErrorInvalidRequest(err.Error()))
+		}
+	}
+	request, err := h.requests.Get(id)
+	if err != nil {
+		return h.handleError(c, err)
+	}
+	if request.Status != model.TrustMarkRequestStatusPending {
+		return c.Status(fiber.StatusConflict).JSON(
+			oidfed.ErrorInvalidRequest("trust mark request is already " + string(request.Status)),
+		)
+	}
+
+	status := model.StatusActive
+	if decision == model.TrustMarkRequestStatusRejected {
+		status = model.StatusInactive
+		if body.Block {
+			status = model.StatusBlocked
+		}
+	}
+	subject, err := h.specs.ChangeSubjectStatus(request.TrustMarkType, request.EntityID, status)
+	if _, notFound := errors.AsType[model.NotFoundError](err); notFound && status == model.StatusActive {
+		subject, err = h.specs.CreateSubject(
+			request.TrustMarkType, &model.AddTrustMarkSubject{
+				EntityID: request.EntityID,
+				Status:   status,
+			},
+		)
+	}
+	if err != nil {
+		return h.handleError(c, err)
+	}
+
+	actor := GetActor(c)
+	request, err = h.requests.Decide(id, decision, body.Reason, actor)
+	if err != nil {
+		return h.handleError(c, err)
+	}
+	if h.events != nil {
+		message := "trust mark request " + strconv.FormatUint(uint64(request.ID), 10) + " " + string(decision)
+		if body.Reason != "" {
+			message += ": " + body.Reason
+		}
+		if err = h.events.Add(
+			model.TrustMarkSubjectEvent{
+				TrustMarkSubjectID: subject.ID,
+				Timestamp:          time.Now().Unix(),
+				Type:               model.EventTypeStatusUpdated,
+				Status:             new(status.String()),
+				Message:            &message,
+				Actor:              &actor,
+			},
+		); err != nil {
+			log.Warn().Err(err).Uint("trust_mark_subject_id", subject.ID).Msg("failed to record status_updated event")
+		}
+	}
+	return c.JSON(request)
+}
+
+func (*trustMarkRequestHandlers) handleError(c *fiber.Ctx, err error) error {
+	if notFound, ok := errors.AsType[model.NotFoundError](err); ok {
+		return c.Status(fiber.StatusNotFound).JSON(oidfed.ErrorNotFound(string(notFound)))
+	}
+	if invalid, ok := errors.AsType[model.ValidationError](err); ok {
+		return c.Status(fiber.StatusConflict).JSON(oidfed.ErrorInvalidRequest(string(invalid)))
+	}
+	return c.Status(fiber.StatusInternalServerError).JSON(oidfed.ErrorServerError(err.Error()))
+}
+
+// requestID parses the requestID path parameter. If it is invalid, a 400
+// response is written and false is returned.
+func requestID(c *fiber.Ctx) (uint, bool) {
+	id, err := strconv.ParseUint(c.Params("requestID"), 10, 64)
+	if err != nil {
+		_ = c.Status(fiber.StatusBadRequest).JSON(oidfed.ErrorInvalidRequest("invalid request id"))
+		return 0, false
+	}
+	return uint(id), true
+}
+
+// registerTrustMarkRequests registers the endpoints to review trust mark
+// requests submitted through the trust mark request endpoint.
+func registerTrustMarkRequests(
+	r fiber.Router,
+	requests model.TrustMarkRequestStore,
+	specs model.TrustMarkSpecStore,
+	events model.TrustMarkSubjectEventStore,
+) {
+	if requests == nil {
+		return
+	}
+	h := &trustMarkRequestHandlers{
+		requests: requests,
+		specs:    specs,
+		events:   events,
+	}
+	base := "/trust-marks/requests"
+	r.Get(base, h.list)
+	r.Get(base+"/:requestID", h.get)
+	r.Post(base+"/:requestID/comments", h.comment)
+	r.Post(base+"/:requestID/approve", h.approve)
+	r.Post(base+"/:requestID/reject", h.reject)
+}
@@ -0,0 +1,170 @@
+package adminapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/go-oidfed/lighthouse/storage"
+	"github.com/go-oidfed/lighthouse/storage/model"
+)
+
+const testRequestTrustMarkType = "https://tm.example.org/requested"
+
+func setupTrustMarkRequestsApp(t *testing.T) (*fiber.App, model.TrustMarkRequestStore, model.TrustMarkSpecStore) {
+	t.Helper()
+	store := newTestStorage(t)
+	specs := store.TrustMarkSpecStorage()
+	requests := storage.NewTrustMarkRequestsStorage(store.DB())
+	if _, err := specs.Create(&model.AddTrustMarkSpec{TrustMarkType: testRequestTrustMarkType}); err != nil {
+		t.Fatalf("failed to create spec: %v", err)
+	}
+	app := fiber.New()
+	app.Use(actorMiddleware(ActorConfig{Source: ActorSourceHeader}))
+	registerTrustMarkRequests(app, requests, specs, store.TrustMarkSubjectEventsStorage())
+	return app, requests, specs
+}
+
+// addPendingTrustMarkRequest creates a pending request and subject as the
+// trust mark request endpoint does.
+func addPendingTrustMarkRequest(
+	t *testing.T, requests model.TrustMarkRequestStore, specs model.TrustMarkSpecStore, entityID string,
+) *model.TrustMarkRequest {
+	t.Helper()
+	if _, err := specs.CreateSubject(
+		testRequestTrustMarkType, &model.AddTrustMarkSubject{
+			EntityID: entityID,
+			Status:   model.StatusPending,
+		},
+	); err != nil {
+		t.Fatalf("failed to create subject: %v", err)
+	}
+	request := &model.TrustMarkRequest{
+		TrustMarkType: testRequestTrustMarkType,
+		EntityID:      entityID,
+		Evidence:      map[string]any{"contact": "admin@example.org"},
+	}
+	if err := requests.Create(request); err != nil {
+		t.Fatalf("failed to create request: %v", err)
+	}
+	return request
+}
+
+func postTrustMarkRequestAction(t *testing.T, app *fiber.App, path, body string) (*http.Response, []byte) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Actor", "reviewer")
+	return doRequest(t, app, req)
+}
+
+func TestTrustMarkRequests_Approve(t *testing.T) {
+	app, requests, specs := setupTrustMarkRequestsApp(t)
+	request := addPendingTrustMarkRequest(t, requests, specs, "https://rp.example.org")
+
+	resp, body := postTrustMarkRequestAction(
+		t, app, "/trust-marks/requests/1/comments", `{"comment":"contact verified"}`,
+	)
+	requireStatus(t, resp, body, fiber.StatusCreated)
+
+	resp, body = postTrustMarkRequestAction(
+		t, app, "/trust-marks/requests/1/approve", `{"reason":"all checks passed"}`,
+	)
+	requireStatus(t, resp, body, fiber.StatusOK)
+	var decided model.TrustMarkRequest
+	if err := json.Unmarshal(body, &decided); err != nil {
+		t.Fatalf("failed to parse response: %v", err)
+	}
+	if decided.Status != model.TrustMarkRequestStatusApproved ||
+		decided.DecisionReason != "all checks passed" || decided.DecidedBy != "reviewer" || decided.DecidedAt == 0 {
+		t.Errorf("unexpected decision: %+v", decided)
+	}
+
+	subject, err := specs.GetSubject(testRequestTrustMarkType, request.EntityID)
+	if err != nil {
+		t.Fatalf("failed to get subject: %v", err)
+	}
+	if subject.Status != model.StatusActive {
+		t.Errorf("expected subject to be active, got %s", subject.Status)
+	}
+
+	resp, body = doRequest(t, app, httptest.NewRequest(http.MethodGet, "/trust-marks/requests/1", nil))
+	requireStatus(t, resp, body, fiber.StatusOK)
+	var got model.TrustMarkRequest
+	if err = json.Unmarshal(body, &got); err != nil {
+		t.Fatalf("failed to parse response: %v", err)
+	}
+	if len(got.Comments) != 1 || got.Comments[0].Actor != "reviewer" || got.Comments[0].Comment != "contact verified" {
+		t.Errorf("unexpected comments: %+v", got.Comments)
+	}
+	if got.Evidence["contact"] != "admin@example.org" {
+		t.Errorf("unexpected evidence: %+v", got.Evidence)
+	}
+
+	// A decided request cannot be decided again
+	resp, body = postTrustMarkRequestAction(t, app, "/trust-marks/requests/1/reject", `{}`)
+	assertStatus(t, resp, body, fiber.StatusConflict)
+}
+
+func TestTrustMarkRequests_Reject(t *testing.T) {
+	app, requests, specs := setupTrustMarkRequestsApp(t)
+	addPendingTrustMarkRequest(t, requests, specs, "https://rp1.example.org")
+	addPendingTrustMarkRequest(t, requests, specs, "https://rp2.example.org")
+
+	resp, body := postTrustMarkRequestAction(
+		t, app, "/trust-marks/requests/1/reject", `{"reason":"missing documents"}`,
+	)
+	requireStatus(t, resp, body, fiber.StatusOK)
+	resp, body = postTrustMarkRequestAction(
+		t, app, "/trust-marks/requests/2/reject", `{"reason":"fraudulent","block":true}`,
+	)
+	requireStatus(t, resp, body, fiber.StatusOK)
+
+	for entityID, expected := range map[string]model.Status{
+		"https://rp1.example.org": model.StatusInactive,
+		"https://rp2.example.org": model.StatusBlocked,
+	} {
+		subject, err := specs.GetSubject(testRequestTrustMarkType, entityID)
+		if err != nil {
+			t.Fatalf("failed to get subject: %v", err)
+		}
+		if subject.Status != expected {
+			t.Errorf("expected subject %s to be %s, got %s", entityID, expected, subject.Status)
+		}
+	}
+
+	resp, body = doRequest(
+		t, app, httptest.NewRequest(http.MethodGet, "/trust-marks/requests?status=rejected", nil),
+	)
+	requireStatus(t, resp, body, fiber.StatusOK)
+	var list []model.TrustMarkRequest
+	if err := json.Unmarshal(body, &list); err != nil {
+		t.Fatalf("failed to parse response: %v", err)
+	}
+	if len(list) != 2 || list[0].EntityID != "https://rp2.example.org" {
+		t.Errorf("unexpected requests: %+v", list)
+	}
+}
+
+func TestTrustMarkRequests_InvalidInput(t *testing.T) {
+	app, _, _ := setupTrustMarkRequestsApp(t)
+
+	resp, body := doRequest(t, app, httptest.NewRequest(http.MethodGet, "/trust-marks/requests?status=unknown", nil))
+	assertStatus(t, resp, body, fiber.StatusBadRequest)
+
+	resp, body = doRequest(t, app, httptest.NewRequest(http.MethodGet, "/trust-marks/requests/abc", nil))
+	assertStatus(t, resp, body, fiber.StatusBadRequest)
+
+	resp, body = doRequest(t, app, httptest.NewRequest(http.MethodGet, "/trust-marks/requests/42", nil))
+	assertStatus(t, resp, body, fiber.StatusNotFound)
+
+	resp, body = postTrustMarkRequestAction(t, app, "/trust-marks/requests/42/approve", `{}`)
+	assertStatus(t, resp, body, fiber.StatusNotFound)
+
+	resp, body = postTrustMarkRequestAction(t, app, "/trust-marks/requests/42/comments", `{"comment":""}`)
+	assertStatus(t, resp, body, fiber.StatusBadRequest)
+}
@@ -39,6 +39,9 @@ func (h *trustMarkTypesHandlers) create(c *fiber.Ctx) error {
 		if _, ok := errors.AsType[model.AlreadyExistsError](err); ok {
 			return c.Status(fiber.StatusConflict).JSON(oidfed.ErrorInvalidRequest("trust mark type already exists"))
 		}
+		if validationErr, ok := errors.AsType[model.ValidationError](err); ok {
+			return c.Status(fiber.StatusBadRequest).JSON(oidfed.ErrorInvalidRequest(string(validationErr)))
+		}
 		return c.Status(fiber.StatusInternalServerError).JSON(oidfed.ErrorServerError(err.Error()))
 	}
 	return c.Status(fiber.StatusCreated).JSON(item)
@@ -128,6 +131,9 @@ func (*trustMarkTypesHandlers) handleTxError(c *fiber.Ctx, err error) error {
 	if _, ok := errors.AsType[model.AlreadyExistsError](err); ok {
 		return c.Status(fiber.StatusConflict).JSON(oidfed.ErrorInvalidRequest("trust mark type already exists"))
 	}
+	if validationErr, ok := errors.AsType[model.ValidationError](err); ok {
+		return c.Status(fiber.StatusBadRequest).JSON(oidfed.ErrorInvalidRequest(string(validationErr)))
+	}
 	return c.Status(fiber.StatusInternalServerError).JSON(oidfed.ErrorServerError(err.Error()))
 }
 
@@ -0,0 +1,197 @@
+package lighthouse
+
+import (
+	"time"
+
+	oidfed "github.com/go-oidfed/lib"
+	"github.com/go-oidfed/lib/jwx"
+	"github.com/go-oidfed/lib/jwx/keymanagement/kms"
+	"github.com/go-oidfed/lib/jwx/keymanagement/public"
+	"github.com/go-oidfed/lib/unixtime"
+	"github.com/lestrrat-go/jwx/v4/jwa"
+	"github.com/pkg/errors"
+
+	"github.com/go-oidfed/lighthouse/api/adminapi"
+	"github.com/go-oidfed/lighthouse/storage"
+	"github.com/go-oidfed/lighthouse/storage/model"
+)
+
+// JWTTypeCeremonyBundle is the JWT type of a signed transfer bundle from an
+// offline key ceremony.
+const JWTTypeCeremonyBundle = "ceremony-bundle+jwt"
+
+// maxPresignedStatementsPerPayload limits the number of statements that are
+// pre-signed for a single payload, to catch a mistyped window or lifetime.
+const maxPresignedStatementsPerPayload = 10000
+
+// CeremonyConf configures the keys of an offline key ceremony. The keys are
+// generated with the filesystem or PKCS#11 KMS into the same layout initKey
+// loads them from.
+type CeremonyConf struct {
+	// EntityID is the entity identifier of the trust anchor.
+	EntityID string `yaml:"entity_id"`
+	// Purpose is the key purpose of the keys; it is used as type id of the
+	// stored public keys (default: federation).
+	Purpose string `yaml:"purpose"`
+	// Keys configures the KMS. Only the filesystem KMS with a key_dir and the
+	// pkcs11 KMS are supported; the public keys are stored in
+	// keys.filesystem.key_dir.
+	Keys KeySetConf `yaml:"keys"`
+	// Algs are the signing algorithms keys are generated for; the first one
+	// is the default algorithm.
+	Algs []string `yaml:"algs"`
+	// RSAKeyLen is the length of generated RSA keys.
+	RSAKeyLen int `yaml:"rsa_key_len"`
+}
+
+// PresignWindow is the validity window statements are pre-signed for.
+type PresignWindow struct {
+	// From and Until limit the issuance times of the statements.
+	From  time.Time
+	Until time.Time
+	// Lifetime is the lifetime of each statement.
+	Lifetime time.Duration
+	// Interval is the time between the issuance of two consecutive
+	// statements; it defaults to half the lifetime, so that consecutive
+	// statements overlap.
+	Interval time.Duration
+}
+
+// ceremonyBundle is the payload of a signed transfer bundle.
+type ceremonyBundle struct {
+	Issuer     string            `json:"iss"`
+	IssuedAt   unixtime.Unixtime `json:"iat"`
+	Statements []string          `json:"statements"`
+}
+
+// LoadCeremonyKeys loads the keys of an offline key ceremony. If generate is
+// set, missing keys are generated.
+func LoadCeremonyKeys(c CeremonyConf, generate bool) (adminapi.KeyManagement, error) {
+	if c.EntityID == "" {
+		return adminapi.KeyManagement{}, errors.New("entity_id is required")
+	}
+	switch c.Keys.KMS {
+	case KMSFilesystem:
+		if c.Keys.FileSystemBackend.KeyFile != "" {
+			return adminapi.KeyManagement{}, errors.New("ceremony keys must be stored in a key_dir, not a key_file")
+		}
+	case KMSPKCS11:
+	default:
+		return adminapi.KeyManagement{}, errors.Errorf(
+			"unsupported ceremony kms '%s'; use '%s' or '%s'", c.Keys.KMS, KMSFilesystem, KMSPKCS11,
+		)
+	}
+	if c.Keys.FileSystemBackend.KeyDir == "" {
+		return adminapi.KeyManagement{}, errors.New("keys.filesystem.key_dir is required")
+	}
+	purpose := c.Purpose
+	if purpose == "" {
+		purpose = model.SigningPurposeFederation
+	}
+
+	// Without a key value store the database defaults are returned
+	var p keySetParams
+	p.alg, p.algs, _ = storage.GetSigningAlgsForPurpose(nil, purpose)
+	p.rsaKeyLen, _ = storage.GetRSAKeyLenForPurpose(nil, purpose)
+	if len(c.Algs) > 0 {
+		p.algs = make([]jwa.SignatureAlgorithm, len(c.Algs))
+		for i, a := range c.Algs {
+			alg, ok := jwa.LookupSignatureAlgorithm(a)
+			if !ok {
+				return adminapi.KeyManagement{}, errors.Errorf("unknown signing algorithm '%s'", a)
+			}
+			p.algs[i] = alg
+		}
+		p.alg = p.algs[0]
+	}
+	if c.RSAKeyLen != 0 {
+		p.rsaKeyLen = c.RSAKeyLen
+	}
+
+	keys := c.Keys
+	keys.AutoGenerateKeys = generate
+	return newKeySet(c.EntityID, purpose, keys, SigningConf{PKBackend: PKBackendFilesystem}, model.Backends{}, p)
+}
+
+// CeremonyPublicKeys returns the valid public keys of an offline key
+// ceremony. Each entry can be imported through the admin API's
+// POST /entity-configuration/keys endpoint.
+func CeremonyPublicKeys(keyManagement adminapi.KeyManagement) (public.PublicKeyEntryList, error) {
+	return keyManagement.KMSManagedPKs.GetValid()
+}
+
+// ceremonySigner returns the jwx.GeneralJWTSigner for the keys of an offline
+// key ceremony.
+func ceremonySigner(keyManagement adminapi.KeyManagement) *jwx.GeneralJWTSigner {
+	return jwx.NewGeneralJWTSigner(
+		kms.KMSToVersatileSignerWithPKStorage(keyManagement.BasicKeys, keyManagement.KMSManagedPKs),
+		keyManagement.BasicKeys.GetAlgs(),
+	)
+}
+
+// issuanceTimes returns the issuance times of the statements pre-signed for
+// the window.
+func (w PresignWindow) issuanceTimes() ([]time.Time, error) {
+	if w.Lifetime <= 0 {
+		return nil, errors.New("lifetime must be positive")
+	}
+	if !w.Until.After(w.From) {
+		return nil, errors.New("end of the validity window must be after its start")
+	}
+	interval := w.Interval
+	if interval <= 0 {
+		interval = w.Lifetime / 2
+	}
+	if interval > w.Lifetime {
+		return nil, errors.New("interval must not be longer than the lifetime")
+	}
+	var times []time.Time
+	for t := w.From; t.Before(w.Until); t = t.Add(interval) {
+		if len(times) == maxPresignedStatementsPerPayload {
+			return nil, errors.Errorf(
+				"validity window needs more than %d statements per entity; use a longer interval",
+				maxPresignedStatementsPerPayload,
+			)
+		}
+		times = append(times, t)
+	}
+	return times, nil
+}
+
+// PresignStatements signs each of the passed entity statement payloads once
+// per issuance time of the window with the keys of an offline key ceremony.
+func PresignStatements(
+	keyManagement adminapi.KeyManagement, payloads []oidfed.EntityStatementPayload, window PresignWindow,
+) ([]string, error) {
+	times, err := window.issuanceTimes()
+	if err != nil {
+		return nil, err
+	}
+	signer := ceremonySigner(keyManagement).EntityStatementSigner()
+	statements := make([]string, 0, len(payloads)*len(times))
+	for _, payload := range payloads {
+		for _, t := range times {
+			payload.IssuedAt = unixtime.Unixtime{Time: t}
+			payload.ExpiresAt = unixtime.Unixtime{Time: t.Add(window.Lifetime)}
+			jwt, err := signer.JWT(payload)
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to sign statement about '%s'", payload.Subject)
+			}
+			statements = append(statements, string(jwt))
+		}
+	}
+	return statements, nil
+}
+
+// SignCeremonyBundle returns a signed transfer bundle holding the passed
+// pre-signed statements. An online LightHouse imports it with
+// LightHouse.ImportCeremonyBundle.
+func SignCeremonyBundle(keyManagement adminapi.KeyManagement, issuer string, statements []string) ([]byte, error) {
+	return ceremonySigner(keyManagement).Typed(JWTTypeCeremonyBundle).JWT(
+		ceremonyBundle{
+			Issuer:     issuer,
+			IssuedAt:   unixtime.Now(),
+			Statements: statements,
+		},
+	)
+}
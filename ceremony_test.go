@@ -0,0 +1,113 @@
+package lighthouse
+
+import (
+	"testing"
+	"time"
+
+	oidfed "github.com/go-oidfed/lib"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/go-oidfed/lighthouse/api/adminapi"
+)
+
+func ceremonyTestKeys(t *testing.T, entityID string) adminapi.KeyManagement {
+	t.Helper()
+	conf := CeremonyConf{
+		EntityID: entityID,
+		Algs:     []string{"ES256"},
+	}
+	conf.Keys.KMS = KMSFilesystem
+	conf.Keys.FileSystemBackend.KeyDir = t.TempDir()
+	_, err := LoadCeremonyKeys(conf, false)
+	require.Error(t, err, "keys must not be generated implicitly")
+	keys, err := LoadCeremonyKeys(conf, true)
+	require.NoError(t, err)
+	return keys
+}
+
+func TestCeremonyBundle(t *testing.T) {
+	fed, store := newTestLightHouse(t)
+	entityID := fed.FederationEntity.EntityID()
+	apiPKs := store.DBPublicKeyStorage("api")
+	require.NoError(t, apiPKs.Load())
+	fed.keyManagement = adminapi.KeyManagement{APIManagedPKs: apiPKs}
+
+	keys := ceremonyTestKeys(t, entityID)
+	pks, err := CeremonyPublicKeys(keys)
+	require.NoError(t, err)
+	require.Len(t, pks, 1)
+
+	now := time.Now()
+	statements, err := PresignStatements(
+		keys, []oidfed.EntityStatementPayload{
+			{Issuer: entityID, Subject: entityID},
+			{Issuer: entityID, Subject: "https://rp.example.org"},
+		}, PresignWindow{
+			From:     now.Add(-time.Hour),
+			Until:    now.Add(2 * time.Hour),
+			Lifetime: time.Hour,
+			Interval: 30 * time.Minute,
+		},
+	)
+	require.NoError(t, err)
+	assert.Len(t, statements, 12)
+	bundle, err := SignCeremonyBundle(keys, entityID, statements)
+	require.NoError(t, err)
+
+	// The ceremony keys must be imported before bundles are accepted
+	_, err = fed.ImportCeremonyBundle(bundle)
+	assert.ErrorIs(t, err, adminapi.ErrInvalidCeremonyBundle)
+	for _, pk := range pks {
+		require.NoError(t, apiPKs.Add(pk))
+	}
+
+	res, err := fed.ImportCeremonyBundle(bundle)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{entityID, "https://rp.example.org"}, res.Subjects)
+	assert.Equal(t, now.Add(2*time.Hour+30*time.Minute).Unix(), res.ValidUntil)
+
+	jwt, exp, found := fed.presignedStatement("https://rp.example.org")
+	require.True(t, found)
+	assert.Contains(t, statements, string(jwt))
+	assert.True(t, exp.After(now))
+	assert.False(t, exp.After(now.Add(time.Hour)))
+	_, _, found = fed.presignedStatement("https://unknown.example.org")
+	assert.False(t, found)
+
+	// Importing the same bundle again does not duplicate statements
+	stored, err := fed.PresignedStatements()
+	require.NoError(t, err)
+	_, err = fed.ImportCeremonyBundle(bundle)
+	require.NoError(t, err)
+	again, err := fed.PresignedStatements()
+	require.NoError(t, err)
+	assert.Len(t, again, len(stored))
+
+	// Bundles signed with other keys are rejected
+	otherBundle, err := SignCeremonyBundle(ceremonyTestKeys(t, entityID), entityID, statements)
+	require.NoError(t, err)
+	_, err = fed.ImportCeremonyBundle(otherBundle)
+	assert.ErrorIs(t, err, adminapi.ErrInvalidCeremonyBundle)
+
+	// Statements must be typed as entity statements
+	untyped, err := ceremonySigner(keys).Typed("jwt").JWT(
+		oidfed.EntityStatementPayload{Issuer: entityID, Subject: "https://rp.example.org"},
+	)
+	require.NoError(t, err)
+	untypedBundle, err := SignCeremonyBundle(keys, entityID, []string{string(untyped)})
+	require.NoError(t, err)
+	_, err = fed.ImportCeremonyBundle(untypedBundle)
+	assert.ErrorIs(t, err, adminapi.ErrInvalidCeremonyBundle)
+
+	// Statements about a subject are dropped, e.g. when it is blocked
+	require.NoError(t, fed.DeleteSubjectPresignedStatements("https://rp.example.org"))
+	_, _, found = fed.presignedStatement("https://rp.example.org")
+	assert.False(t, found)
+	_, _, found = fed.presignedStatement(entityID)
+	assert.True(t, found)
+
+	require.NoError(t, fed.DeletePresignedStatements())
+	_, _, found = fed.presignedStatement(entityID)
+	assert.False(t, found)
+}
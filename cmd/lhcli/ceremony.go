@@ -0,0 +1,286 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	oidfed "github.com/go-oidfed/lib"
+	"github.com/go-oidfed/lib/jwx/keymanagement/public"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+
+	"github.com/go-oidfed/lighthouse"
+	"github.com/go-oidfed/lighthouse/api/adminapi"
+)
+
+var ceremonyCmd = &cobra.Command{
+	Use:   "ceremony",
+	Short: "Offline key ceremony for trust anchor keys",
+	Long: `Generate and use trust anchor keys on an air-gapped machine.
+
+The generate, export-keys and sign commands work offline on the keys
+configured in a ceremony configuration file. The payloads, import-keys and
+import commands transfer the data to and from a running LightHouse through its
+admin API.`,
+}
+
+var ceremonyGenerateCmd = &cobra.Command{
+	Use:   "generate <ceremony.yaml>",
+	Short: "Generate the ceremony keys",
+	Long: `Generate the ceremony keys into the filesystem or PKCS#11 KMS layout
+configured in the ceremony configuration file. Existing keys are kept.`,
+	Args: cobra.ExactArgs(1),
+	RunE: ceremonyGenerate,
+}
+
+var ceremonyExportKeysCmd = &cobra.Command{
+	Use:   "export-keys <ceremony.yaml>",
+	Short: "Export the public ceremony keys",
+	Long: `Export the public ceremony keys as a JSON list. Each entry can be
+imported through POST /entity-configuration/keys, e.g. with 'lhcli ceremony
+import-keys'.`,
+	Args: cobra.ExactArgs(1),
+	RunE: ceremonyExportKeys,
+}
+
+var ceremonySignCmd = &cobra.Command{
+	Use:   "sign <ceremony.yaml> <payloads.json>",
+	Short: "Pre-sign statements into a transfer bundle",
+	Long: `Pre-sign the entity configuration and subordinate statements from a
+payloads file (see 'lhcli ceremony payloads') for a validity window and write
+them into a signed transfer bundle.
+
+Each statement is signed once per interval within the window; every signed
+statement is valid for the passed lifetime.`,
+	Args: cobra.ExactArgs(2),
+	RunE: ceremonySign,
+}
+
+var ceremonyPayloadsCmd = &cobra.Command{
+	Use:   "payloads",
+	Short: "Download the statement payloads to pre-sign",
+	Long: `Download the unsigned entity configuration and subordinate statements
+of a running LightHouse, to be pre-signed with 'lhcli ceremony sign'.`,
+	Args: cobra.NoArgs,
+	RunE: ceremonyPayloads,
+}
+
+var ceremonyImportKeysCmd = &cobra.Command{
+	Use:   "import-keys <keys.json>",
+	Short: "Import exported public ceremony keys",
+	Long: `Import the public ceremony keys exported with 'lhcli ceremony
+export-keys' into a running LightHouse.`,
+	Args: cobra.ExactArgs(1),
+	RunE: ceremonyImportKeys,
+}
+
+var ceremonyImportCmd = &cobra.Command{
+	Use:   "import <bundle.jwt>",
+	Short: "Import a transfer bundle",
+	Long: `Import a transfer bundle created with 'lhcli ceremony sign' into a
+running LightHouse, which then serves the pre-signed statements.`,
+	Args: cobra.ExactArgs(1),
+	RunE: ceremonyImport,
+}
+
+var (
+	ceremonyOutput   string
+	ceremonyFrom     string
+	ceremonyUntil    string
+	ceremonyLifetime time.Duration
+	ceremonyInterval time.Duration
+)
+
+func init() {
+	addAdminAPIFlags(ceremonyCmd)
+	for _, cmd := range []*cobra.Command{ceremonyExportKeysCmd, ceremonySignCmd, ceremonyPayloadsCmd} {
+		cmd.Flags().StringVarP(&ceremonyOutput, "output", "o", "", "the output file (default: stdout)")
+	}
+	ceremonySignCmd.Flags().StringVar(
+		&ceremonyFrom, "from", "", "start of the validity window as RFC 3339 timestamp (default: now)",
+	)
+	ceremonySignCmd.Flags().StringVar(
+		&ceremonyUntil, "until", "", "end of the validity window as RFC 3339 timestamp (required)",
+	)
+	ceremonySignCmd.Flags().DurationVar(
+		&ceremonyLifetime, "lifetime", 24*time.Hour, "the lifetime of each signed statement",
+	)
+	ceremonySignCmd.Flags().DurationVar(
+		&ceremonyInterval, "interval", 0, "the time between two signed statements (default: half the lifetime)",
+	)
+	_ = ceremonySignCmd.MarkFlagRequired("until")
+	ceremonyCmd.AddCommand(ceremonyGenerateCmd)
+	ceremonyCmd.AddCommand(ceremonyExportKeysCmd)
+	ceremonyCmd.AddCommand(ceremonySignCmd)
+	ceremonyCmd.AddCommand(ceremonyPayloadsCmd)
+	ceremonyCmd.AddCommand(ceremonyImportKeysCmd)
+	ceremonyCmd.AddCommand(ceremonyImportCmd)
+	rootCmd.AddCommand(ceremonyCmd)
+}
+
+func loadCeremonyConf(path string) (lighthouse.CeremonyConf, error) {
+	var conf lighthouse.CeremonyConf
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return conf, errors.Wrap(err, "failed to read ceremony configuration")
+	}
+	err = yaml.Unmarshal(content, &conf)
+	return conf, errors.Wrap(err, "failed to parse ceremony configuration")
+}
+
+func loadCeremonyKeys(path string, generate bool) (lighthouse.CeremonyConf, adminapi.KeyManagement, error) {
+	conf, err := loadCeremonyConf(path)
+	if err != nil {
+		return conf, adminapi.KeyManagement{}, err
+	}
+	keys, err := lighthouse.LoadCeremonyKeys(conf, generate)
+	return conf, keys, errors.Wrap(err, "failed to load ceremony keys")
+}
+
+// writeCeremonyOutput writes data to the output file or to stdout.
+func writeCeremonyOutput(data []byte) error {
+	if ceremonyOutput == "" {
+		_, err := os.Stdout.Write(append(data, '\n'))
+		return err
+	}
+	return errors.Wrap(os.WriteFile(ceremonyOutput, data, 0644), "failed to write output")
+}
+
+func ceremonyGenerate(_ *cobra.Command, args []string) error {
+	_, keys, err := loadCeremonyKeys(args[0], true)
+	if err != nil {
+		return err
+	}
+	pks, err := lighthouse.CeremonyPublicKeys(keys)
+	if err != nil {
+		return err
+	}
+	for _, pk := range pks {
+		alg, _ := pk.Key.Algorithm()
+		fmt.Printf("%s\t%s\n", pk.KID, alg)
+	}
+	return nil
+}
+
+func ceremonyExportKeys(_ *cobra.Command, args []string) error {
+	_, keys, err := loadCeremonyKeys(args[0], false)
+	if err != nil {
+		return err
+	}
+	pks, err := lighthouse.CeremonyPublicKeys(keys)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(pks, "", "  ")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return writeCeremonyOutput(data)
+}
+
+func ceremonySign(_ *cobra.Command, args []string) error {
+	conf, keys, err := loadCeremonyKeys(args[0], false)
+	if err != nil {
+		return err
+	}
+	content, err := os.ReadFile(args[1])
+	if err != nil {
+		return errors.Wrap(err, "failed to read payloads")
+	}
+	var payloads adminapi.CeremonyPayloads
+	if err = json.Unmarshal(content, &payloads); err != nil {
+		return errors.Wrap(err, "failed to parse payloads")
+	}
+	statements := make([]oidfed.EntityStatementPayload, 0, len(payloads.SubordinateStatements)+1)
+	if payloads.EntityConfiguration != nil {
+		statements = append(statements, *payloads.EntityConfiguration)
+	}
+	statements = append(statements, payloads.SubordinateStatements...)
+	for _, s := range statements {
+		if s.Issuer != conf.EntityID {
+			return errors.Errorf("payload about '%s' is not issued by '%s'", s.Subject, conf.EntityID)
+		}
+	}
+
+	window := lighthouse.PresignWindow{
+		From:     time.Now(),
+		Lifetime: ceremonyLifetime,
+		Interval: ceremonyInterval,
+	}
+	if ceremonyFrom != "" {
+		if window.From, err = time.Parse(time.RFC3339, ceremonyFrom); err != nil {
+			return errors.Wrap(err, "invalid --from")
+		}
+	}
+	if window.Until, err = time.Parse(time.RFC3339, ceremonyUntil); err != nil {
+		return errors.Wrap(err, "invalid --until")
+	}
+
+	signed, err := lighthouse.PresignStatements(keys, statements, window)
+	if err != nil {
+		return err
+	}
+	bundle, err := lighthouse.SignCeremonyBundle(keys, conf.EntityID, signed)
+	if err != nil {
+		return errors.Wrap(err, "failed to sign bundle")
+	}
+	_, _ = fmt.Fprintf(
+		os.Stderr, "Signed %d statements about %d entities\n", len(signed), len(statements),
+	)
+	return writeCeremonyOutput(bundle)
+}
+
+func ceremonyPayloads(_ *cobra.Command, _ []string) error {
+	var payloads adminapi.CeremonyPayloads
+	if err := adminAPIRequest(http.MethodGet, "/ceremony/payloads", nil, &payloads); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(payloads, "", "  ")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return writeCeremonyOutput(data)
+}
+
+func ceremonyImportKeys(_ *cobra.Command, args []string) error {
+	content, err := os.ReadFile(args[0])
+	if err != nil {
+		return errors.Wrap(err, "failed to read keys")
+	}
+	var pks public.PublicKeyEntryList
+	if err = json.Unmarshal(content, &pks); err != nil {
+		return errors.Wrap(err, "failed to parse keys")
+	}
+	for _, pk := range pks {
+		if err = adminAPIRequest(http.MethodPost, "/entity-configuration/keys", pk, nil); err != nil {
+			return errors.Wrapf(err, "failed to import key '%s'", pk.KID)
+		}
+		fmt.Printf("Imported key %s\n", pk.KID)
+	}
+	return nil
+}
+
+func ceremonyImport(_ *cobra.Command, args []string) error {
+	bundle, err := os.ReadFile(args[0])
+	if err != nil {
+		return errors.Wrap(err, "failed to read bundle")
+	}
+	var res adminapi.CeremonyImportResult
+	err = adminAPIRequest(
+		http.MethodPost, "/ceremony/bundles",
+		adminapi.CeremonyBundleImportRequest{Bundle: strings.TrimSpace(string(bundle))}, &res,
+	)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Imported %d statements about: %s\n", res.Imported, strings.Join(res.Subjects, ", "))
+	if res.ValidUntil != 0 {
+		fmt.Printf("Valid until: %s\n", time.Unix(res.ValidUntil, 0).Format(time.RFC3339))
+	}
+	return nil
+}
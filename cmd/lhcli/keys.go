@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+
+	"github.com/go-oidfed/lighthouse/api/adminapi"
+	"github.com/go-oidfed/lighthouse/storage/model"
+)
+
+var keysCmd = &cobra.Command{
+	Use:   "keys",
+	Short: "Manage signing keys",
+	Long:  `Manage the signing keys of a running LightHouse through its admin API`,
+}
+
+var keysCompromiseCmd = &cobra.Command{
+	Use:   "compromise [kid...]",
+	Short: "Run the emergency key compromise procedure",
+	Long: `Run the emergency key compromise procedure on a running LightHouse.
+
+The passed keys (or all active keys of the key set if no kid is given) are
+revoked, replacement keys are generated and used for signing right away, all
+cached entity configurations and subordinate statements are purged, and
+superiors and notification subscribers are notified.`,
+	RunE: compromiseKeys,
+}
+
+var keysStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show the key health summary",
+	Long: `Show the key health summary of a running LightHouse: the expiration of
+the signing keys and all keys that expire soon or are expired, including
+API-managed keys, subordinate keys and trust anchor keys.`,
+	Args: cobra.NoArgs,
+	RunE: keysStatus,
+}
+
+var (
+	adminURL        string
+	adminUser       string
+	adminPassword   string
+	keyPurpose      string
+	revokeReason    string
+	skipConfirmFlag bool
+	refreshFlag     bool
+)
+
+// addAdminAPIFlags adds the flags to reach the admin API of the running
+// LightHouse to cmd and its subcommands.
+func addAdminAPIFlags(cmd *cobra.Command) {
+	cmd.PersistentFlags().StringVar(
+		&adminURL, "admin-url", "http://localhost:7672/api/v1/admin", "the base URL of the admin API",
+	)
+	cmd.PersistentFlags().StringVarP(
+		&adminUser, "user", "u", os.Getenv("LH_ADMIN_USER"),
+		"the admin API user (default: $LH_ADMIN_USER)",
+	)
+	cmd.PersistentFlags().StringVarP(
+		&adminPassword, "password", "p", "",
+		"the admin API password (default: $LH_ADMIN_PASSWORD)",
+	)
+}
+
+func init() {
+	addAdminAPIFlags(keysCmd)
+	keysCompromiseCmd.Flags().StringVar(
+		&keyPurpose, "purpose", model.SigningPurposeFederation,
+		"the key purpose of the key set, e.g. trust_marks",
+	)
+	keysCompromiseCmd.Flags().StringVarP(
+		&revokeReason, "reason", "r", "", "the revocation reason recorded in the historical keys (default: compromised)",
+	)
+	keysCompromiseCmd.Flags().BoolVarP(&skipConfirmFlag, "yes", "y", false, "do not ask for confirmation")
+	keysStatusCmd.Flags().BoolVar(&refreshFlag, "refresh", false, "check the keys now instead of returning the latest check")
+	keysCmd.AddCommand(keysCompromiseCmd)
+	keysCmd.AddCommand(keysStatusCmd)
+	rootCmd.AddCommand(keysCmd)
+}
+
+// adminAPIRequest sends a request to the admin API of the running LightHouse
+// and unmarshals the JSON response into out, if given.
+func adminAPIRequest(method, path string, body, out any) error {
+	if adminPassword == "" {
+		adminPassword = os.Getenv("LH_ADMIN_PASSWORD")
+	}
+	var reqBody io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		reqBody = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, strings.TrimSuffix(adminURL, "/")+path, reqBody)
+	if err != nil {
+		return errors.Wrap(err, "failed to create request")
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if adminUser != "" {
+		req.SetBasicAuth(adminUser, adminPassword)
+	}
+	resp, err := (&http.Client{Timeout: 2 * time.Minute}).Do(req)
+	if err != nil {
+		return errors.Wrap(err, "admin api request failed")
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return errors.Wrap(err, "failed to read response")
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return errors.Errorf("admin api returned HTTP %d: %s", resp.StatusCode, respBody)
+	}
+	if out == nil || len(respBody) == 0 {
+		return nil
+	}
+	return errors.Wrap(json.Unmarshal(respBody, out), "failed to parse response")
+}
+
+func compromiseKeys(_ *cobra.Command, args []string) error {
+	if !skipConfirmFlag {
+		target := "all active keys"
+		if len(args) > 0 {
+			target = strings.Join(args, ", ")
+		}
+		fmt.Printf(
+			"This revokes %s of the '%s' key set immediately. Type 'yes' to continue: ", target, keyPurpose,
+		)
+		var answer string
+		_, _ = fmt.Scanln(&answer)
+		if answer != "yes" {
+			return errors.New("aborted")
+		}
+	}
+
+	path := "/kms/compromise"
+	if keyPurpose != model.SigningPurposeFederation {
+		path = "/kms/purposes/" + keyPurpose + "/compromise"
+	}
+	var res adminapi.KeyCompromiseResult
+	err := adminAPIRequest(
+		http.MethodPost, path, adminapi.KeyCompromiseRequest{
+			KIDs:   args,
+			Reason: revokeReason,
+		}, &res,
+	)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Revoked keys (%s): %s\n", res.Reason, strings.Join(res.RevokedKIDs, ", "))
+	fmt.Printf("New keys: %s\n", strings.Join(res.NewKIDs, ", "))
+	for _, s := range res.Superiors {
+		if s.Notified {
+			fmt.Printf("Notified superior %s\n", s.EntityID)
+		} else {
+			fmt.Printf("Could not notify superior %s: %s\n", s.EntityID, s.Error)
+		}
+	}
+	return nil
+}
+
+func keysStatus(_ *cobra.Command, _ []string) error {
+	path := "/health/keys"
+	if refreshFlag {
+		path += "?refresh=true"
+	}
+	var report adminapi.KeyHealthReport
+	if err := adminAPIRequest(http.MethodGet, path, nil, &report); err != nil {
+		return err
+	}
+
+	fmt.Printf(
+		"Key health: %s (checked %s)\n", strings.ToUpper(report.Status),
+		time.Unix(report.CheckedAt, 0).Format(time.RFC3339),
+	)
+	fmt.Println()
+	fmt.Println("Signing keys:")
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "  PURPOSE\tKID\tEXPIRES\tSTATUS")
+	for _, k := range report.SigningKeys {
+		_, _ = fmt.Fprintf(w, "  %s\t%s\t%s\t%s\n", k.Purpose, k.KID, formatExpiry(k.ExpiresAt), k.Status)
+	}
+	_ = w.Flush()
+	fmt.Println()
+	if len(report.Issues) == 0 {
+		fmt.Println("No keys expire soon.")
+		return nil
+	}
+	fmt.Println("Issues:")
+	w = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "  SEVERITY\tKIND\tENTITY / PURPOSE\tKID\tEXPIRES")
+	for _, k := range report.Issues {
+		owner := k.EntityID
+		if owner == "" {
+			owner = k.Purpose
+		}
+		_, _ = fmt.Fprintf(
+			w, "  %s\t%s\t%s\t%s\t%s\n", k.Severity, k.Kind, owner, k.KID, formatExpiry(k.ExpiresAt),
+		)
+	}
+	return w.Flush()
+}
+
+func formatExpiry(exp int64) string {
+	if exp == 0 {
+		return "-"
+	}
+	return time.Unix(exp, 0).Format(time.RFC3339)
+}
@@ -20,6 +20,8 @@ var configFile string
 var subordinateStorage model.SubordinateStorageBackend
 var trustMarkedEntitiesStorage model.TrustMarkedEntitiesStorageBackend
 var trustMarkSpecsStorage model.TrustMarkSpecStore
+var trustMarkRequestsStorage model.TrustMarkRequestStore
+var trustMarkReportsStorage model.TrustMarkReportStore
 
 func loadConfig() error {
 	if err := config.Load(configFile); err != nil {
@@ -35,6 +37,8 @@ func loadConfig() error {
 	subordinateStorage = backs.Subordinates
 	trustMarkedEntitiesStorage = backs.TrustMarks
 	trustMarkSpecsStorage = backs.TrustMarkSpecs
+	trustMarkRequestsStorage = backs.TrustMarkRequests
+	trustMarkReportsStorage = backs.TrustMarkReports
 	return nil
 }
 
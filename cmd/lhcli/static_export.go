@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+
+	"github.com/go-oidfed/lighthouse/api/adminapi"
+)
+
+var staticExportCmd = &cobra.Command{
+	Use:   "static-export",
+	Short: "Render the federation responses into static files",
+	Long: `Render all federation responses of a running LightHouse into its static
+export directory and show the exported files. The static export must be
+enabled in the LightHouse configuration.`,
+	Args: cobra.NoArgs,
+	RunE: staticExport,
+}
+
+var staticExportStatusFlag bool
+
+func init() {
+	addAdminAPIFlags(staticExportCmd)
+	staticExportCmd.Flags().BoolVar(
+		&staticExportStatusFlag, "status", false, "show the latest rendering instead of rendering now",
+	)
+	rootCmd.AddCommand(staticExportCmd)
+}
+
+func staticExport(_ *cobra.Command, _ []string) error {
+	method := http.MethodPost
+	if staticExportStatusFlag {
+		method = http.MethodGet
+	}
+	var res adminapi.StaticExportResult
+	if err := adminAPIRequest(method, "/static-export", nil, &res); err != nil {
+		return err
+	}
+
+	fmt.Printf(
+		"Static export in %s (rendered %s): %d rendered, %d removed\n", res.Dir,
+		time.Unix(res.RenderedAt, 0).Format(time.RFC3339), res.Rendered, res.Removed,
+	)
+	fmt.Println()
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "  FILE\tCONTENT TYPE\tURL")
+	for _, f := range res.Files {
+		_, _ = fmt.Fprintf(w, "  %s\t%s\t%s\n", f.Path, f.ContentType, f.URL)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if len(res.Errors) == 0 {
+		return nil
+	}
+	fmt.Println()
+	fmt.Println("Errors:")
+	for _, e := range res.Errors {
+		fmt.Printf("  %s\n", e)
+	}
+	return errors.Errorf("%d responses could not be rendered", len(res.Errors))
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+
+	"github.com/go-oidfed/lighthouse/internal/stats"
+	"github.com/go-oidfed/lighthouse/storage/model"
+)
+
+var trustmarkReportCmd = &cobra.Command{
+	Use:   "report",
+	Short: "Show trust mark issuance reports",
+	Long: `Show issuances, re-issuances, cache hits, eligibility denials,
+revocations and active holders per trust mark type over time, or export them
+to CSV or JSON`,
+	RunE: showTrustMarkReport,
+}
+
+// Flags
+var (
+	tmReportFromDate string
+	tmReportToDate   string
+	tmReportInterval string
+	tmReportHolders  bool
+	tmReportFormat   string
+	tmReportOutput   string
+)
+
+func init() {
+	trustmarkReportCmd.Flags().StringVarP(&configFile, "config", "c", "config.yaml", "the config file to use")
+	trustmarkReportCmd.Flags().StringVar(&tmReportFromDate, "from", "", "start date (YYYY-MM-DD or RFC3339, default: 30 days ago)")
+	trustmarkReportCmd.Flags().StringVar(&tmReportToDate, "to", "", "end date (YYYY-MM-DD or RFC3339, default: now)")
+	trustmarkReportCmd.Flags().StringVar(&trustMarkType, "id", "", "if set only this trust mark type is reported")
+	trustmarkReportCmd.Flags().StringVar(&tmReportInterval, "interval", "day", "report period (day, week, month)")
+	trustmarkReportCmd.Flags().BoolVar(&tmReportHolders, "holders", false, "list the subjects that were issued or had revoked trust marks")
+	trustmarkReportCmd.Flags().StringVar(&tmReportFormat, "format", "table", "output format of the report (table, csv or json)")
+	trustmarkReportCmd.Flags().StringVarP(&tmReportOutput, "output", "o", "", "output file for csv and json (default: stdout)")
+	tmCmd.AddCommand(trustmarkReportCmd)
+}
+
+func parseTrustMarkReportFilter() (filter model.TrustMarkReportFilter, err error) {
+	filter.To = time.Now().UTC()
+	if tmReportToDate != "" {
+		filter.To, err = parseDate(tmReportToDate)
+		if err != nil {
+			return filter, errors.Wrap(err, "invalid --to date")
+		}
+		// If only date (no time), set to end of day
+		if len(tmReportToDate) == 10 {
+			filter.To = filter.To.Add(24*time.Hour - time.Second)
+		}
+	}
+	filter.From = filter.To.AddDate(0, 0, -30)
+	if tmReportFromDate != "" {
+		filter.From, err = parseDate(tmReportFromDate)
+		if err != nil {
+			return filter, errors.Wrap(err, "invalid --from date")
+		}
+	}
+	filter.TrustMarkType = trustMarkType
+	filter.Interval = stats.Interval(tmReportInterval)
+	switch filter.Interval {
+	case stats.IntervalDay, stats.IntervalWeek, stats.IntervalMonth:
+	default:
+		return filter, errors.Errorf("invalid --interval '%s'; supported are day, week and month", tmReportInterval)
+	}
+	return filter, nil
+}
+
+func showTrustMarkReport(_ *cobra.Command, _ []string) error {
+	if err := loadConfig(); err != nil {
+		return err
+	}
+	filter, err := parseTrustMarkReportFilter()
+	if err != nil {
+		return err
+	}
+
+	if tmReportHolders {
+		return showTrustMarkHolders(filter)
+	}
+	if tmReportFormat != "table" {
+		return exportTrustMarkReport(filter)
+	}
+
+	entries, err := trustMarkReportsStorage.Report(filter)
+	if err != nil {
+		return errors.Wrap(err, "failed to get trust mark report")
+	}
+	fmt.Printf(
+		"Trust Mark Report (%s to %s, interval: %s)\n",
+		filter.From.Format("2006-01-02"), filter.To.Format("2006-01-02"), filter.Interval,
+	)
+	if len(entries) == 0 {
+		fmt.Println("No data available")
+		return nil
+	}
+	fmt.Printf(
+		"%-12s %8s %9s %8s %11s %8s %8s  %s\n",
+		"Period", "Issued", "Reissued", "Revoked", "Cache Hits", "Denials", "Holders", "Trust Mark Type",
+	)
+	fmt.Println(strings.Repeat("-", 100))
+	for _, e := range entries {
+		fmt.Printf(
+			"%-12s %8d %9d %8d %11d %8d %8d  %s\n",
+			e.Period.Format("2006-01-02"), e.Issued, e.Reissued, e.Revoked, e.CacheHits, e.EligibilityDenials,
+			e.ActiveHolders, e.TrustMarkType,
+		)
+	}
+	return nil
+}
+
+func showTrustMarkHolders(filter model.TrustMarkReportFilter) error {
+	holders, err := trustMarkReportsStorage.Holders(filter)
+	if err != nil {
+		return errors.Wrap(err, "failed to get trust mark holders")
+	}
+	fmt.Printf(
+		"Trust Mark Holders (%s to %s)\n", filter.From.Format("2006-01-02"), filter.To.Format("2006-01-02"),
+	)
+	if len(holders) == 0 {
+		fmt.Println("No data available")
+		return nil
+	}
+	lastType := ""
+	for _, h := range holders {
+		if h.TrustMarkType != lastType {
+			lastType = h.TrustMarkType
+			fmt.Printf("\n%s\n", lastType)
+			fmt.Printf("  %-50s %8s %8s %-12s %6s\n", "Subject", "Issued", "Revoked", "Last Issued", "Active")
+		}
+		lastIssued := "-"
+		if h.LastIssuedAt > 0 {
+			lastIssued = time.Unix(h.LastIssuedAt, 0).UTC().Format("2006-01-02")
+		}
+		fmt.Printf("  %-50s %8d %8d %-12s %6t\n", h.Subject, h.Issued, h.Revoked, lastIssued, h.Active)
+	}
+	return nil
+}
+
+func exportTrustMarkReport(filter model.TrustMarkReportFilter) error {
+	var w = os.Stdout
+	if tmReportOutput != "" {
+		f, err := os.Create(tmReportOutput)
+		if err != nil {
+			return errors.Wrap(err, "failed to create output file")
+		}
+		defer f.Close()
+		w = f
+	}
+
+	switch tmReportFormat {
+	case "json":
+		if err := trustMarkReportsStorage.ExportJSON(filter, w); err != nil {
+			return errors.Wrap(err, "failed to export JSON")
+		}
+	case "csv":
+		if err := trustMarkReportsStorage.ExportCSV(filter, w); err != nil {
+			return errors.Wrap(err, "failed to export CSV")
+		}
+	default:
+		return errors.Errorf("invalid --format '%s'; supported are table, csv and json", tmReportFormat)
+	}
+
+	if tmReportOutput != "" {
+		fmt.Printf("Exported to %s\n", tmReportOutput)
+	}
+	return nil
+}
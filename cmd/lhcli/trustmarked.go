@@ -2,12 +2,16 @@ package main
 
 import (
 	"bufio"
+	"encoding/json"
 	"fmt"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
+
+	"github.com/go-oidfed/lighthouse/storage/model"
 )
 
 var tmCmd = &cobra.Command{
@@ -175,11 +179,61 @@ func manageTrustMarkRequestsForID(id string) error {
 }
 
 func promptInTrustMarkRequest(trustMarkType, entityID string) error {
+	request, err := pendingTrustMarkRequest(trustMarkType, entityID)
+	if err != nil {
+		return err
+	}
+	if request != nil {
+		printTrustMarkRequest(request)
+	}
 	approved := promptApproval("Do you approve entity '%s'", entityID)
+	decision := model.TrustMarkRequestStatusRejected
 	if approved {
-		return trustMarkedEntitiesStorage.Approve(trustMarkType, entityID)
+		err = trustMarkedEntitiesStorage.Approve(trustMarkType, entityID)
+		decision = model.TrustMarkRequestStatusApproved
+	} else {
+		err = trustMarkedEntitiesStorage.Block(trustMarkType, entityID)
+	}
+	if err != nil || request == nil {
+		return err
+	}
+	reason := promptText("Reason for the decision (optional): ")
+	_, err = trustMarkRequestsStorage.Decide(request.ID, decision, reason, "lhcli")
+	return err
+}
+
+// pendingTrustMarkRequest returns the pending trust mark request with the
+// submitted evidence for the entity, or nil if there is none.
+func pendingTrustMarkRequest(trustMarkType, entityID string) (*model.TrustMarkRequest, error) {
+	if trustMarkRequestsStorage == nil {
+		return nil, nil
 	}
-	return trustMarkedEntitiesStorage.Block(trustMarkType, entityID)
+	request, err := trustMarkRequestsStorage.Latest(trustMarkType, entityID)
+	if err != nil || request == nil || request.Status != model.TrustMarkRequestStatusPending {
+		return nil, err
+	}
+	return trustMarkRequestsStorage.Get(request.ID)
+}
+
+func printTrustMarkRequest(request *model.TrustMarkRequest) {
+	fmt.Printf("Request %d submitted at %s\n", request.ID, time.Unix(int64(request.CreatedAt), 0).Format(time.RFC3339))
+	if len(request.Evidence) > 0 {
+		evidence, _ := json.MarshalIndent(request.Evidence, "", "  ")
+		fmt.Printf("Evidence:\n%s\n", evidence)
+	}
+	for _, a := range request.Attachments {
+		fmt.Printf("Attachment: %s\n", a)
+	}
+	for _, c := range request.Comments {
+		fmt.Printf("Comment by %s: %s\n", c.Actor, c.Comment)
+	}
+}
+
+func promptText(prompt string) string {
+	reader := bufio.NewReader(os.Stdin)
+	fmt.Print(prompt)
+	input, _ := reader.ReadString('\n')
+	return strings.TrimSpace(input)
 }
 
 func promptApproval(f string, args ...any) bool {
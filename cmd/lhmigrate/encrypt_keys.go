@@ -0,0 +1,171 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/zachmann/go-utils/fileutils"
+	"gopkg.in/yaml.v3"
+
+	"github.com/go-oidfed/lighthouse"
+	"github.com/go-oidfed/lighthouse/storage"
+)
+
+// encryptKeysCmd encrypts the private keys of the database KMS in place and
+// re-wraps keys encrypted with a previous master key with the current one.
+func encryptKeysCmd(args []string) int {
+	fs := flag.NewFlagSet("encrypt-keys", flag.ExitOnError)
+	var (
+		configFile string
+		dbType     string
+		dbDSN      string
+		dbDir      string
+		dbDebug    bool
+		typeID     string
+		dryRun     bool
+		verbose    bool
+	)
+	// --config / -c
+	fs.StringVar(&configFile, "config", "", "Path to config file with signing.db_encryption (required)")
+	fs.StringVar(&configFile, "c", "", "Path to config file (shorthand)")
+	// --db-type
+	fs.StringVar(&dbType, "db-type", "sqlite", "Database type: sqlite|mysql|postgres")
+	// --db-dsn
+	fs.StringVar(&dbDSN, "db-dsn", "", "Database DSN (for mysql/postgres)")
+	// --db-dir
+	fs.StringVar(&dbDir, "db-dir", "", "Data directory (for sqlite)")
+	// --db-debug
+	fs.BoolVar(&dbDebug, "db-debug", false, "Enable GORM debug logging")
+	// --type / -t
+	fs.StringVar(&typeID, "type", "federation", "Key type identifier (e.g., 'federation')")
+	fs.StringVar(&typeID, "t", "federation", "Key type identifier (shorthand)")
+	// --dry-run / -n
+	fs.BoolVar(&dryRun, "dry-run", false, "Only report how many keys would be encrypted")
+	fs.BoolVar(&dryRun, "n", false, "Dry run mode (shorthand)")
+	// --verbose / -v
+	fs.BoolVar(&verbose, "verbose", false, "Verbose logging")
+	fs.BoolVar(&verbose, "v", false, "Verbose logging (shorthand)")
+
+	fs.Usage = func() {
+		_, _ = fmt.Fprintf(os.Stderr, "Usage: lhmigrate encrypt-keys --config=<config.yaml> [options]\n\n")
+		_, _ = fmt.Fprintf(
+			os.Stderr,
+			"Encrypt the private keys of the database KMS with the master key configured in\n"+
+				"signing.db_encryption. Unencrypted keys are encrypted; keys encrypted with one of\n"+
+				"signing.db_encryption.previous_master_keys are re-wrapped with the current master key.\n\n",
+		)
+		_, _ = fmt.Fprintf(os.Stderr, "Options:\n")
+		fs.PrintDefaults()
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	if verbose {
+		zerolog.SetGlobalLevel(zerolog.DebugLevel)
+	}
+
+	if configFile == "" {
+		_, _ = fmt.Fprintln(os.Stderr, "--config is required")
+		fs.Usage()
+		return 2
+	}
+
+	encConf, err := loadDBKeyEncryptionConf(configFile)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to load config file")
+		return 1
+	}
+	sealer, err := encConf.NewSealer()
+	if err != nil {
+		log.Error().Err(err).Msg("failed to load master keys")
+		return 1
+	}
+	defer func() {
+		if err := sealer.Close(); err != nil {
+			log.Warn().Err(err).Msg("failed to release master keys")
+		}
+	}()
+
+	driver, err := storage.ParseDriverType(strings.ToLower(dbType))
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "invalid --db-type: %s\n", err)
+		return 2
+	}
+	if err = validateDBFlags(driver, dbDir, dbDSN); err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	db, err := storage.Connect(
+		storage.Config{
+			Driver:  driver,
+			DSN:     dbDSN,
+			DataDir: dbDir,
+			Debug:   dbDebug,
+		},
+	)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to connect to database")
+		return 1
+	}
+
+	pemStorer := storage.NewEncryptedDBPEMStorer(db, typeID, sealer)
+	if dryRun {
+		n, err := pemStorer.CountUnsealed()
+		if err != nil {
+			log.Error().Err(err).Msg("failed to read private keys")
+			return 1
+		}
+		log.Info().
+			Int("keys", n).
+			Str("master_key_id", sealer.PrimaryKeyID()).
+			Msg("DRY RUN - private keys that would be encrypted")
+		return 0
+	}
+
+	n, err := pemStorer.Reseal()
+	if err != nil {
+		log.Error().Err(err).Msg("failed to encrypt private keys; no private keys were changed")
+		return 1
+	}
+	log.Info().
+		Int("encrypted", n).
+		Str("master_key_id", sealer.PrimaryKeyID()).
+		Msg("private keys encrypted")
+	if len(encConf.PreviousMasterKeys) > 0 {
+		log.Info().Msg("all private keys use the current master key; previous_master_keys can be removed")
+	}
+	return 0
+}
+
+// loadDBKeyEncryptionConf reads signing.db_encryption from a LightHouse
+// config file
+func loadDBKeyEncryptionConf(filename string) (*lighthouse.DBKeyEncryptionConf, error) {
+	content, err := fileutils.ReadFile(filename)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read config file")
+	}
+	var conf struct {
+		Signing struct {
+			DBEncryption lighthouse.DBKeyEncryptionConf `yaml:"db_encryption"`
+		} `yaml:"signing"`
+	}
+	if err = yaml.Unmarshal(content, &conf); err != nil {
+		return nil, errors.Wrap(err, "failed to parse config file")
+	}
+	encConf := conf.Signing.DBEncryption
+	if !encConf.Enabled() {
+		return nil, errors.New("signing.db_encryption.master_key is not configured")
+	}
+	if err = encConf.Validate(); err != nil {
+		return nil, errors.Wrap(err, "invalid signing.db_encryption")
+	}
+	return &encConf, nil
+}
@@ -35,6 +35,7 @@ type apiConf struct {
 //   - LH_API_ADMIN_TLS_ENABLED: Enable TLS for admin API
 //   - LH_API_ADMIN_TLS_CERT: Path to TLS certificate for admin API
 //   - LH_API_ADMIN_TLS_KEY: Path to TLS private key for admin API
+//   - LH_API_ADMIN_VALIDATE_METADATA: Validate metadata writes against JSON Schemas
 type adminAPIConf struct {
 	// Enabled enables the admin API.
 	// Env: LH_API_ADMIN_ENABLED
@@ -63,6 +64,10 @@ type adminAPIConf struct {
 	// When enabled with a custom port, the admin API will serve HTTPS instead of HTTP.
 	// Env prefix: LH_API_ADMIN_TLS_
 	TLS lighthouse.TLSConf `yaml:"tls" envconfig:"TLS"`
+	// ValidateMetadata enables validation of metadata written through the
+	// admin API against the metadata schema of the entity type.
+	// Env: LH_API_ADMIN_VALIDATE_METADATA
+	ValidateMetadata bool `yaml:"validate_metadata" envconfig:"VALIDATE_METADATA"`
 }
 
 var defaultAPIConf = apiConf{
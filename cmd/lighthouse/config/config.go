@@ -31,6 +31,15 @@ import (
 //   - LH_SIGNING_*: Signing configuration (see SigningConf)
 //   - LH_API_*: API configuration (see apiConf)
 //   - LH_STATS_*: Statistics configuration (see StatsConf)
+//   - LH_REVALIDATION_*: Re-validation configuration (see RevalidationConf)
+//   - LH_JWKS_POLICY_*: Subordinate JWKS policy configuration (see JWKSPolicyConf)
+//   - LH_KEY_HEALTH_*: Key health monitoring configuration (see KeyHealthConf)
+//   - LH_STATIC_EXPORT_*: Static export configuration (see StaticExportConf)
+//   - LH_TRUST_MARK_DELEGATIONS_*: Trust mark delegation renewal configuration (see TrustMarkDelegationsConf)
+//   - LH_TRUST_MARK_PUSH_*: Trust mark push delivery configuration (see TrustMarkPushConf)
+//   - LH_TRUST_MARK_PRE_ISSUANCE_*: Trust mark pre-issuance configuration (see TrustMarkPreIssuanceConf)
+//   - LH_PUBLISHED_TRUST_MARKS_*: Published trust mark monitoring configuration (see PublishedTrustMarksConf)
+//   - LH_LOGOS_*: Logo hosting configuration (see LogosConf)
 type Config struct {
 	// EntityID is the entity identifier URL.
 	// Env: LH_ENTITY_ID
@@ -56,6 +65,43 @@ type Config struct {
 	// Stats holds statistics configuration.
 	// Env prefix: LH_STATS_
 	Stats StatsConf `yaml:"stats" envconfig:"STATS"`
+	// Revalidation holds configuration for the periodic re-validation of
+	// subordinates and trust mark subjects.
+	// Env prefix: LH_REVALIDATION_
+	Revalidation RevalidationConf `yaml:"revalidation" envconfig:"REVALIDATION"`
+	// JWKSPolicy holds the key policy for JWKS updates of subordinates.
+	// Env prefix: LH_JWKS_POLICY_
+	JWKSPolicy JWKSPolicyConf `yaml:"jwks_policy" envconfig:"JWKS_POLICY"`
+	// KeyHealth holds configuration for the monitoring of key expirations.
+	// Env prefix: LH_KEY_HEALTH_
+	KeyHealth KeyHealthConf `yaml:"key_health" envconfig:"KEY_HEALTH"`
+	// StaticExport holds configuration for the export of the federation
+	// responses into static files.
+	// Env prefix: LH_STATIC_EXPORT_
+	StaticExport StaticExportConf `yaml:"static_export" envconfig:"STATIC_EXPORT"`
+	// TrustMarkDelegations holds configuration for the renewal of the
+	// delegation JWTs issued as trust mark owner.
+	// Env prefix: LH_TRUST_MARK_DELEGATIONS_
+	TrustMarkDelegations TrustMarkDelegationsConf `yaml:"trust_mark_delegations" envconfig:"TRUST_MARK_DELEGATIONS"`
+	// TrustMarkPush holds configuration for the push delivery of trust marks
+	// to trust mark subjects.
+	// Env prefix: LH_TRUST_MARK_PUSH_
+	TrustMarkPush TrustMarkPushConf `yaml:"trust_mark_push" envconfig:"TRUST_MARK_PUSH"`
+	// TrustMarkPreIssuance holds configuration for the scheduled
+	// pre-issuance of trust marks.
+	// Env prefix: LH_TRUST_MARK_PRE_ISSUANCE_
+	TrustMarkPreIssuance TrustMarkPreIssuanceConf `yaml:"trust_mark_pre_issuance" envconfig:"TRUST_MARK_PRE_ISSUANCE"`
+	// PublishedTrustMarks holds configuration for the monitoring of the
+	// trust marks published in the entity configuration.
+	// Env prefix: LH_PUBLISHED_TRUST_MARKS_
+	PublishedTrustMarks PublishedTrustMarksConf `yaml:"published_trust_marks" envconfig:"PUBLISHED_TRUST_MARKS"`
+	// Logos holds configuration for the hosting of trust mark and entity
+	// logos.
+	// Env prefix: LH_LOGOS_
+	Logos LogosConf `yaml:"logos" envconfig:"LOGOS"`
+	// Notifications configures where operator notifications are delivered
+	// to. Only configurable in the config file.
+	Notifications lighthouse.NotificationConf `yaml:"notifications" ignored:"true"`
 }
 
 type configValidator interface {
@@ -94,12 +140,21 @@ func (c *Config) Validate() error {
 }
 
 var c = Config{
-	Server:  defaultServerConf,
-	Logging: defaultLoggingConf,
-	Storage: defaultStorageConf,
-	Signing: defaultSigningConf,
-	API:     defaultAPIConf,
-	Stats:   defaultStatsConf,
+	Server:               defaultServerConf,
+	Logging:              defaultLoggingConf,
+	Storage:              defaultStorageConf,
+	Signing:              defaultSigningConf,
+	API:                  defaultAPIConf,
+	Stats:                defaultStatsConf,
+	Revalidation:         defaultRevalidationConf,
+	JWKSPolicy:           defaultJWKSPolicyConf,
+	KeyHealth:            defaultKeyHealthConf,
+	StaticExport:         defaultStaticExportConf,
+	TrustMarkDelegations: defaultTrustMarkDelegationsConf,
+	TrustMarkPush:        defaultTrustMarkPushConf,
+	TrustMarkPreIssuance: defaultTrustMarkPreIssuanceConf,
+	PublishedTrustMarks:  defaultPublishedTrustMarksConf,
+	Logos:                defaultLogosConf,
 }
 
 // Get returns the Config
@@ -0,0 +1,58 @@
+package config
+
+import (
+	"github.com/pkg/errors"
+
+	"github.com/go-oidfed/lighthouse"
+)
+
+// JWKSPolicyConf configures the key policy that new federation JWKS of
+// subordinates must satisfy when they are updated via the jwks_update or
+// jwks_update_trigger endpoints or by the subordinate JWKS refresher.
+//
+// Environment variables (with prefix LH_JWKS_POLICY_):
+//   - LH_JWKS_POLICY_ENABLED: Enable the JWKS policy
+//   - LH_JWKS_POLICY_MIN_RSA_KEY_SIZE: Minimum RSA key size in bits
+//   - LH_JWKS_POLICY_ALLOWED_KEY_TYPES: Comma-separated list of allowed key types
+//   - LH_JWKS_POLICY_ALLOWED_CURVES: Comma-separated list of allowed curves
+//   - LH_JWKS_POLICY_ALLOWED_ALGS: Comma-separated list of allowed algorithms
+//   - LH_JWKS_POLICY_REQUIRE_KID: Require a kid for every key
+//   - LH_JWKS_POLICY_ALLOW_DUPLICATE_KIDS: Allow multiple keys with the same kid
+//
+// YAML example:
+//
+//	jwks_policy:
+//	  enabled: true
+//	  min_rsa_key_size: 3072
+//	  allowed_curves:
+//	    - P-256
+//	    - Ed25519
+type JWKSPolicyConf struct {
+	// Enabled turns on the JWKS policy.
+	// Env: LH_JWKS_POLICY_ENABLED
+	Enabled bool `yaml:"enabled" envconfig:"ENABLED"`
+
+	lighthouse.JWKSPolicy `yaml:",inline"`
+}
+
+// validate checks the JWKS policy configuration for errors.
+func (p *JWKSPolicyConf) validate() error {
+	if p.MinRSAKeySize < 0 {
+		return errors.New("min_rsa_key_size must not be negative")
+	}
+	return nil
+}
+
+// ToJWKSPolicy returns the configured lighthouse.JWKSPolicy or nil if the
+// policy is not enabled.
+func (p *JWKSPolicyConf) ToJWKSPolicy() *lighthouse.JWKSPolicy {
+	if !p.Enabled {
+		return nil
+	}
+	policy := p.JWKSPolicy
+	return &policy
+}
+
+var defaultJWKSPolicyConf = JWKSPolicyConf{
+	JWKSPolicy: lighthouse.DefaultJWKSPolicy,
+}
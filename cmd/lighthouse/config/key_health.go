@@ -0,0 +1,79 @@
+package config
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/zachmann/go-utils/duration"
+
+	"github.com/go-oidfed/lighthouse"
+)
+
+// KeyHealthConf configures the periodic monitoring of key expirations.
+//
+// Environment variables (with prefix LH_KEY_HEALTH_):
+//   - LH_KEY_HEALTH_ENABLED: Enable key health monitoring
+//   - LH_KEY_HEALTH_INTERVAL: Time between two checks (e.g., "1h")
+//   - LH_KEY_HEALTH_WARNING_THRESHOLD: Remaining lifetime below which a key is reported as warning
+//   - LH_KEY_HEALTH_CRITICAL_THRESHOLD: Remaining lifetime below which a key is reported as critical
+//
+// YAML example:
+//
+//	key_health:
+//	  enabled: true
+//	  interval: 1h
+//	  warning_threshold: 336h
+//	  critical_threshold: 72h
+type KeyHealthConf struct {
+	// Enabled turns on key health monitoring.
+	// Env: LH_KEY_HEALTH_ENABLED
+	Enabled bool `yaml:"enabled" envconfig:"ENABLED"`
+
+	// Interval is the time between two checks.
+	// Default: 1h
+	// Env: LH_KEY_HEALTH_INTERVAL
+	Interval duration.DurationOption `yaml:"interval" envconfig:"INTERVAL"`
+
+	// WarningThreshold is the remaining lifetime below which a key is
+	// reported with severity warning.
+	// Default: 336h (14 days)
+	// Env: LH_KEY_HEALTH_WARNING_THRESHOLD
+	WarningThreshold duration.DurationOption `yaml:"warning_threshold" envconfig:"WARNING_THRESHOLD"`
+
+	// CriticalThreshold is the remaining lifetime below which a key is
+	// reported with severity critical.
+	// Default: 72h
+	// Env: LH_KEY_HEALTH_CRITICAL_THRESHOLD
+	CriticalThreshold duration.DurationOption `yaml:"critical_threshold" envconfig:"CRITICAL_THRESHOLD"`
+}
+
+// validate checks the key health configuration for errors.
+func (k *KeyHealthConf) validate() error {
+	if !k.Enabled {
+		return nil
+	}
+	if k.Interval.Duration() <= 0 {
+		k.Interval = duration.DurationOption(time.Hour)
+	}
+	if k.CriticalThreshold.Duration() > k.WarningThreshold.Duration() {
+		return errors.New("critical_threshold must not be greater than warning_threshold")
+	}
+	return nil
+}
+
+// ToKeyHealthConfig converts config.KeyHealthConf to
+// lighthouse.KeyHealthConfig.
+func (k *KeyHealthConf) ToKeyHealthConfig() lighthouse.KeyHealthConfig {
+	return lighthouse.KeyHealthConfig{
+		Interval:          k.Interval.Duration(),
+		WarningThreshold:  k.WarningThreshold.Duration(),
+		CriticalThreshold: k.CriticalThreshold.Duration(),
+	}
+}
+
+var defaultKeyHealthConf = KeyHealthConf{
+	Enabled:           false,
+	Interval:          duration.DurationOption(time.Hour),
+	WarningThreshold:  duration.DurationOption(14 * 24 * time.Hour),
+	CriticalThreshold: duration.DurationOption(72 * time.Hour),
+}
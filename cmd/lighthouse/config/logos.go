@@ -0,0 +1,126 @@
+package config
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/zachmann/go-utils/duration"
+
+	"github.com/go-oidfed/lighthouse"
+)
+
+// LogosConf configures the hosting of trust mark and entity logos. Logos are
+// uploaded through the admin API and served under a public path; external
+// logo URIs with a hosted copy are rewritten to the hosted logo.
+//
+// Environment variables (with prefix LH_LOGOS_):
+//   - LH_LOGOS_ENABLED: Enable the logo hosting
+//   - LH_LOGOS_PATH: Path the logos are served under (e.g., "/logos")
+//   - LH_LOGOS_MAX_SIZE: Maximum size of a logo in bytes
+//   - LH_LOGOS_MAX_WIDTH: Maximum width of a logo in pixels
+//   - LH_LOGOS_MAX_HEIGHT: Maximum height of a logo in pixels
+//   - LH_LOGOS_CACHE_MAX_AGE: Max-age of the Cache-Control header of served logos
+//   - LH_LOGOS_CHECK_EXTERNAL: Periodically fetch and validate the external logo URIs
+//   - LH_LOGOS_CHECK_INTERVAL: Time between two checks (e.g., "24h")
+//   - LH_LOGOS_MIRROR_EXTERNAL: Host copies of valid external logos
+//
+// YAML example:
+//
+//	logos:
+//	  enabled: true
+//	  path: /logos
+//	  max_size: 262144
+//	  max_width: 1024
+//	  max_height: 1024
+//	  cache_max_age: 24h
+//	  check_external: true
+//	  check_interval: 24h
+//	  mirror_external: false
+type LogosConf struct {
+	// Enabled turns on the logo hosting.
+	// Env: LH_LOGOS_ENABLED
+	Enabled bool `yaml:"enabled" envconfig:"ENABLED"`
+
+	// Path is the path the hosted logos are served under.
+	// Default: /logos
+	// Env: LH_LOGOS_PATH
+	Path string `yaml:"path" envconfig:"PATH"`
+
+	// MaxSize is the maximum size of a logo in bytes.
+	// Default: 262144
+	// Env: LH_LOGOS_MAX_SIZE
+	MaxSize int `yaml:"max_size" envconfig:"MAX_SIZE"`
+
+	// MaxWidth is the maximum width of a logo in pixels.
+	// Default: 1024
+	// Env: LH_LOGOS_MAX_WIDTH
+	MaxWidth int `yaml:"max_width" envconfig:"MAX_WIDTH"`
+
+	// MaxHeight is the maximum height of a logo in pixels.
+	// Default: 1024
+	// Env: LH_LOGOS_MAX_HEIGHT
+	MaxHeight int `yaml:"max_height" envconfig:"MAX_HEIGHT"`
+
+	// CacheMaxAge is the max-age of the Cache-Control header of served logos.
+	// Default: 24h
+	// Env: LH_LOGOS_CACHE_MAX_AGE
+	CacheMaxAge duration.DurationOption `yaml:"cache_max_age" envconfig:"CACHE_MAX_AGE"`
+
+	// CheckExternal enables the periodic check of the external logo URIs.
+	// Env: LH_LOGOS_CHECK_EXTERNAL
+	CheckExternal bool `yaml:"check_external" envconfig:"CHECK_EXTERNAL"`
+
+	// CheckInterval is the time between two checks of the external logo URIs.
+	// Default: 24h
+	// Env: LH_LOGOS_CHECK_INTERVAL
+	CheckInterval duration.DurationOption `yaml:"check_interval" envconfig:"CHECK_INTERVAL"`
+
+	// MirrorExternal makes the check host copies of valid external logos.
+	// Env: LH_LOGOS_MIRROR_EXTERNAL
+	MirrorExternal bool `yaml:"mirror_external" envconfig:"MIRROR_EXTERNAL"`
+}
+
+// validate checks the logos configuration for errors.
+func (l *LogosConf) validate() error {
+	if !l.Enabled {
+		return nil
+	}
+	if l.Path == "" {
+		l.Path = "/logos"
+	}
+	if l.MaxSize < 0 || l.MaxWidth < 0 || l.MaxHeight < 0 {
+		return errors.New("logos.max_size, max_width and max_height must not be negative")
+	}
+	if l.CacheMaxAge.Duration() <= 0 {
+		l.CacheMaxAge = duration.DurationOption(24 * time.Hour)
+	}
+	if l.CheckInterval.Duration() <= 0 {
+		l.CheckInterval = duration.DurationOption(24 * time.Hour)
+	}
+	return nil
+}
+
+// ToLogoHostingConfig converts config.LogosConf to
+// lighthouse.LogoHostingConfig.
+func (l *LogosConf) ToLogoHostingConfig() lighthouse.LogoHostingConfig {
+	return lighthouse.LogoHostingConfig{
+		Path:           l.Path,
+		MaxSize:        l.MaxSize,
+		MaxWidth:       l.MaxWidth,
+		MaxHeight:      l.MaxHeight,
+		CacheMaxAge:    l.CacheMaxAge.Duration(),
+		CheckExternal:  l.CheckExternal,
+		CheckInterval:  l.CheckInterval.Duration(),
+		MirrorExternal: l.MirrorExternal,
+	}
+}
+
+var defaultLogosConf = LogosConf{
+	Enabled:       false,
+	Path:          "/logos",
+	MaxSize:       256 * 1024,
+	MaxWidth:      1024,
+	MaxHeight:     1024,
+	CacheMaxAge:   duration.DurationOption(24 * time.Hour),
+	CheckInterval: duration.DurationOption(24 * time.Hour),
+}
@@ -0,0 +1,80 @@
+package config
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/zachmann/go-utils/duration"
+
+	"github.com/go-oidfed/lighthouse"
+)
+
+// PublishedTrustMarksConf configures the periodic verification of the trust
+// marks published in the entity configuration.
+//
+// Environment variables (with prefix LH_PUBLISHED_TRUST_MARKS_):
+//   - LH_PUBLISHED_TRUST_MARKS_ENABLED: Enable the monitoring of published trust marks
+//   - LH_PUBLISHED_TRUST_MARKS_INTERVAL: Time between two checks (e.g., "1h")
+//   - LH_PUBLISHED_TRUST_MARKS_EXPIRY_WARNING: Remaining lifetime below which a trust mark is reported as expiring
+//   - LH_PUBLISHED_TRUST_MARKS_DROP_INVALID: Remove invalid trust marks from the entity configuration
+//
+// YAML example:
+//
+//	published_trust_marks:
+//	  enabled: true
+//	  interval: 1h
+//	  expiry_warning: 168h
+//	  drop_invalid: true
+type PublishedTrustMarksConf struct {
+	// Enabled turns on the monitoring of published trust marks.
+	// Env: LH_PUBLISHED_TRUST_MARKS_ENABLED
+	Enabled bool `yaml:"enabled" envconfig:"ENABLED"`
+
+	// Interval is the time between two checks.
+	// Default: 1h
+	// Env: LH_PUBLISHED_TRUST_MARKS_INTERVAL
+	Interval duration.DurationOption `yaml:"interval" envconfig:"INTERVAL"`
+
+	// ExpiryWarning is the remaining lifetime below which a trust mark that
+	// is not refreshed automatically is reported as expiring.
+	// Default: 168h (7 days)
+	// Env: LH_PUBLISHED_TRUST_MARKS_EXPIRY_WARNING
+	ExpiryWarning duration.DurationOption `yaml:"expiry_warning" envconfig:"EXPIRY_WARNING"`
+
+	// DropInvalid removes invalid trust marks from the entity configuration
+	// until they are valid again; otherwise they are only reported.
+	// Default: true
+	// Env: LH_PUBLISHED_TRUST_MARKS_DROP_INVALID
+	DropInvalid bool `yaml:"drop_invalid" envconfig:"DROP_INVALID"`
+}
+
+// validate checks the published trust marks configuration for errors.
+func (p *PublishedTrustMarksConf) validate() error {
+	if !p.Enabled {
+		return nil
+	}
+	if p.Interval.Duration() <= 0 {
+		p.Interval = duration.DurationOption(time.Hour)
+	}
+	if p.ExpiryWarning.Duration() < 0 {
+		return errors.New("expiry_warning must not be negative")
+	}
+	return nil
+}
+
+// ToPublishedTrustMarkHealthConfig converts config.PublishedTrustMarksConf to
+// lighthouse.PublishedTrustMarkHealthConfig.
+func (p *PublishedTrustMarksConf) ToPublishedTrustMarkHealthConfig() lighthouse.PublishedTrustMarkHealthConfig {
+	return lighthouse.PublishedTrustMarkHealthConfig{
+		Interval:      p.Interval.Duration(),
+		ExpiryWarning: p.ExpiryWarning.Duration(),
+		DropInvalid:   p.DropInvalid,
+	}
+}
+
+var defaultPublishedTrustMarksConf = PublishedTrustMarksConf{
+	Enabled:       false,
+	Interval:      duration.DurationOption(time.Hour),
+	ExpiryWarning: duration.DurationOption(7 * 24 * time.Hour),
+	DropInvalid:   true,
+}
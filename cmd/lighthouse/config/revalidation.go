@@ -0,0 +1,169 @@
+package config
+
+import (
+	"slices"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/zachmann/go-utils/duration"
+
+	"github.com/go-oidfed/lighthouse"
+)
+
+// RevalidationConf configures the periodic re-validation of subordinates and
+// trust mark subjects against their configured entity checks.
+//
+// Environment variables (with prefix LH_REVALIDATION_):
+//   - LH_REVALIDATION_ENABLED: Enable periodic re-validation
+//   - LH_REVALIDATION_INTERVAL: Time between re-validation runs (e.g., "24h")
+//   - LH_REVALIDATION_SUBORDINATES_*: Policy for subordinates
+//   - LH_REVALIDATION_TRUST_MARK_SUBJECTS_*: Policy for trust mark subjects
+//
+// YAML example:
+//
+//	revalidation:
+//	  enabled: true
+//	  interval: 24h
+//	  subordinates:
+//	    enabled: true
+//	    action: pending
+//	    grace_period: 72h
+//	    require_authority_hint: true
+//	  trust_mark_subjects:
+//	    enabled: true
+//	    action: revoke
+//	    grace_period: 72h
+type RevalidationConf struct {
+	// Enabled turns on periodic re-validation.
+	// Env: LH_REVALIDATION_ENABLED
+	Enabled bool `yaml:"enabled" envconfig:"ENABLED"`
+
+	// Interval is the time between two re-validation runs.
+	// Default: 24h
+	// Env: LH_REVALIDATION_INTERVAL
+	Interval duration.DurationOption `yaml:"interval" envconfig:"INTERVAL"`
+
+	// Subordinates configures re-validation of active subordinates against
+	// the checker configured for the enroll endpoint.
+	// Env prefix: LH_REVALIDATION_SUBORDINATES_
+	Subordinates SubordinateRevalidationConf `yaml:"subordinates" envconfig:"SUBORDINATES"`
+
+	// TrustMarkSubjects configures re-validation of active trust mark
+	// subjects against the eligibility checker of their trust mark spec.
+	// Env prefix: LH_REVALIDATION_TRUST_MARK_SUBJECTS_
+	TrustMarkSubjects RevalidationPolicyConf `yaml:"trust_mark_subjects" envconfig:"TRUST_MARK_SUBJECTS"`
+}
+
+// RevalidationPolicyConf defines what happens when an entity fails
+// re-validation.
+//
+// Environment variables (with prefix LH_REVALIDATION_<KIND>_):
+//   - LH_REVALIDATION_<KIND>_ENABLED: Enable re-validation for this kind
+//   - LH_REVALIDATION_<KIND>_ACTION: alert, pending, inactive, or revoke
+//   - LH_REVALIDATION_<KIND>_GRACE_PERIOD: Grace period before the action is applied
+type RevalidationPolicyConf struct {
+	// Enabled turns on re-validation for this kind of entity.
+	// Default: true
+	// Env: LH_REVALIDATION_<KIND>_ENABLED
+	Enabled bool `yaml:"enabled" envconfig:"ENABLED"`
+
+	// Action is applied once an entity has been failing for longer than the
+	// grace period. One of alert, pending, inactive, revoke (revoke is only
+	// supported for trust mark subjects).
+	// Default: alert
+	// Env: LH_REVALIDATION_<KIND>_ACTION
+	Action string `yaml:"action" envconfig:"ACTION"`
+
+	// GracePeriod is how long an entity may keep failing before the action
+	// is applied.
+	// Default: 72h
+	// Env: LH_REVALIDATION_<KIND>_GRACE_PERIOD
+	GracePeriod duration.DurationOption `yaml:"grace_period" envconfig:"GRACE_PERIOD"`
+}
+
+// SubordinateRevalidationConf is the RevalidationPolicyConf for subordinates.
+//
+// Environment variables (with prefix LH_REVALIDATION_SUBORDINATES_):
+//   - LH_REVALIDATION_SUBORDINATES_REQUIRE_AUTHORITY_HINT: Require this entity in authority_hints
+type SubordinateRevalidationConf struct {
+	RevalidationPolicyConf `yaml:",inline"`
+
+	// RequireAuthorityHint additionally requires that a subordinate's Entity
+	// Configuration still lists this entity in its authority_hints.
+	// Default: true
+	// Env: LH_REVALIDATION_SUBORDINATES_REQUIRE_AUTHORITY_HINT
+	RequireAuthorityHint bool `yaml:"require_authority_hint" envconfig:"REQUIRE_AUTHORITY_HINT"`
+}
+
+var validSubordinateRevalidationActions = []string{
+	lighthouse.RevalidationActionAlert,
+	lighthouse.RevalidationActionPending,
+	lighthouse.RevalidationActionInactive,
+}
+
+var validTrustMarkSubjectRevalidationActions = []string{
+	lighthouse.RevalidationActionAlert,
+	lighthouse.RevalidationActionPending,
+	lighthouse.RevalidationActionInactive,
+	lighthouse.RevalidationActionRevoke,
+}
+
+// validate checks the revalidation configuration for errors.
+func (r *RevalidationConf) validate() error {
+	if !r.Enabled {
+		return nil
+	}
+	if r.Interval.Duration() <= 0 {
+		r.Interval = duration.DurationOption(24 * time.Hour)
+	}
+	if r.Subordinates.Action == "" {
+		r.Subordinates.Action = lighthouse.RevalidationActionAlert
+	}
+	if !slices.Contains(validSubordinateRevalidationActions, r.Subordinates.Action) {
+		return errors.Errorf("invalid subordinates action '%s'", r.Subordinates.Action)
+	}
+	if r.TrustMarkSubjects.Action == "" {
+		r.TrustMarkSubjects.Action = lighthouse.RevalidationActionAlert
+	}
+	if !slices.Contains(validTrustMarkSubjectRevalidationActions, r.TrustMarkSubjects.Action) {
+		return errors.Errorf("invalid trust_mark_subjects action '%s'", r.TrustMarkSubjects.Action)
+	}
+	return nil
+}
+
+// ToRevalidationConfig converts config.RevalidationConf to
+// lighthouse.RevalidationConfig.
+func (r *RevalidationConf) ToRevalidationConfig() lighthouse.RevalidationConfig {
+	return lighthouse.RevalidationConfig{
+		Interval: r.Interval.Duration(),
+		Subordinates: lighthouse.RevalidationPolicy{
+			Enabled:     r.Subordinates.Enabled,
+			Action:      r.Subordinates.Action,
+			GracePeriod: r.Subordinates.GracePeriod.Duration(),
+		},
+		RequireAuthorityHint: r.Subordinates.RequireAuthorityHint,
+		TrustMarkSubjects: lighthouse.RevalidationPolicy{
+			Enabled:     r.TrustMarkSubjects.Enabled,
+			Action:      r.TrustMarkSubjects.Action,
+			GracePeriod: r.TrustMarkSubjects.GracePeriod.Duration(),
+		},
+	}
+}
+
+var defaultRevalidationConf = RevalidationConf{
+	Enabled:  false,
+	Interval: duration.DurationOption(24 * time.Hour),
+	Subordinates: SubordinateRevalidationConf{
+		RevalidationPolicyConf: RevalidationPolicyConf{
+			Enabled:     true,
+			Action:      lighthouse.RevalidationActionAlert,
+			GracePeriod: duration.DurationOption(72 * time.Hour),
+		},
+		RequireAuthorityHint: true,
+	},
+	TrustMarkSubjects: RevalidationPolicyConf{
+		Enabled:     true,
+		Action:      lighthouse.RevalidationActionAlert,
+		GracePeriod: duration.DurationOption(72 * time.Hour),
+	},
+}
@@ -12,7 +12,7 @@ import (
 // or use the Admin API to manage them at runtime.
 //
 // Environment variables (with prefix LH_SIGNING_):
-//   - LH_SIGNING_KMS: Key management system ("filesystem", "pkcs11", or "db")
+//   - LH_SIGNING_KMS: Key management system ("filesystem", "pkcs11", "db", or "remote")
 //   - LH_SIGNING_PK_BACKEND: Public key storage backend ("filesystem" or "db")
 //   - LH_SIGNING_AUTO_GENERATE_KEYS: Auto-generate keys if missing
 //   - LH_SIGNING_FILESYSTEM_KEY_FILE: Path to single key file
@@ -28,45 +28,109 @@ import (
 //   - LH_SIGNING_PKCS11_NO_LOGIN: Token doesn't support login
 //   - LH_SIGNING_PKCS11_LABEL_PREFIX: Prefix for object labels
 //   - LH_SIGNING_PKCS11_LOAD_LABELS: Extra labels to load (comma-separated)
+//   - LH_SIGNING_DB_ENCRYPTION_MASTER_KEY_KEY_ID: ID of the master key for kms=db
+//   - LH_SIGNING_DB_ENCRYPTION_MASTER_KEY_FILE: Path to the master key file
+//   - LH_SIGNING_DB_ENCRYPTION_MASTER_KEY_ENV: Env var holding the master key
+//   - LH_SIGNING_DB_ENCRYPTION_MASTER_KEY_PKCS11_*: PKCS#11 wrapping key (module_path, token_label,
+//     token_serial, token_slot, pin, no_login, key_label)
+//   - LH_SIGNING_REMOTE_TYPE: Remote signing service type ("transit" or "rest")
+//   - LH_SIGNING_REMOTE_URL: Base URL of the remote signing service
+//   - LH_SIGNING_REMOTE_TOKEN: Token for the remote signing service
+//   - LH_SIGNING_REMOTE_NAMESPACE: Vault namespace (transit only)
+//   - LH_SIGNING_REMOTE_MOUNT: Transit secrets engine mount path
+//   - LH_SIGNING_REMOTE_KEY_NAME: Transit key name
+//   - LH_SIGNING_REMOTE_TIMEOUT: Timeout for requests to the signing service
+//   - LH_SIGNING_REMOTE_SYNC_INTERVAL: Interval for syncing keys from the signing service
+//   - LH_SIGNING_PURPOSES_TRUST_MARKS_*: Key set for trust marks (same options as above, e.g.
+//     LH_SIGNING_PURPOSES_TRUST_MARKS_KMS)
+//   - LH_SIGNING_PURPOSES_RESOLVE_*: Key set for resolve responses (same options as above)
 type SigningConf struct {
 	lighthouse.SigningConf `yaml:",inline"`
 }
 
-var defaultSigningConf = SigningConf{
-	SigningConf: lighthouse.SigningConf{
-		PKBackend:        lighthouse.PKBackendDatabase,
-		AutoGenerateKeys: true,
-	},
-}
+var defaultSigningConf = func() SigningConf {
+	c := SigningConf{
+		SigningConf: lighthouse.SigningConf{
+			PKBackend: lighthouse.PKBackendDatabase,
+			KeySetConf: lighthouse.KeySetConf{
+				AutoGenerateKeys: true,
+			},
+		},
+	}
+	c.Purposes.TrustMarks.AutoGenerateKeys = true
+	c.Purposes.Resolve.AutoGenerateKeys = true
+	return c
+}()
 
 func (c *SigningConf) validate() error {
 	if c.KMS == "" {
-		return errors.New("error in signing conf: kms must be specified ('filesystem', 'pkcs11', or 'db')")
+		return errors.New("error in signing conf: kms must be specified ('filesystem', 'pkcs11', 'db', or 'remote')")
+	}
+	if err := c.validateKeySet("", c.KeySetConf); err != nil {
+		return err
+	}
+	if c.PKBackend == lighthouse.PKBackendFilesystem && c.FileSystemBackend.KeyDir == "" {
+		return errors.New("error in signing conf: filesystem.key_dir must be specified")
+	}
+	for purpose, keySet := range c.PurposeKeySets() {
+		if err := c.validateKeySet("purposes."+purpose+".", keySet); err != nil {
+			return err
+		}
 	}
-	switch c.KMS {
+	return nil
+}
+
+// validateKeySet validates a key set; prefix is the path of the key set
+// within the signing conf and is used in error messages
+func (c *SigningConf) validateKeySet(prefix string, k lighthouse.KeySetConf) error {
+	switch k.KMS {
 	case lighthouse.KMSFilesystem:
-		if c.FileSystemBackend.KeyDir == "" && c.FileSystemBackend.KeyFile == "" {
-			return errors.New("error in signing conf: filesystem.key_dir or filesystem.key_file must be specified")
+		if k.FileSystemBackend.KeyDir == "" && k.FileSystemBackend.KeyFile == "" {
+			return errors.Errorf(
+				"error in signing conf: %sfilesystem.key_dir or %sfilesystem.key_file must be specified", prefix, prefix,
+			)
 		}
 	case lighthouse.KMSPKCS11:
-		if c.PKCS11Backend.ModulePath == "" {
-			return errors.New("error in signing conf: pkcs11.module_path must be specified")
+		if k.PKCS11Backend.ModulePath == "" {
+			return errors.Errorf("error in signing conf: %spkcs11.module_path must be specified", prefix)
 		}
-		if c.PKCS11Backend.TokenLabel == "" && c.PKCS11Backend.TokenSerial == "" && c.PKCS11Backend.SlotNumber == nil {
-			return errors.New("error in signing conf: pkcs11.token_label, pkcs11.token_serial or pkcs11.slot_number must be specified")
+		if k.PKCS11Backend.TokenLabel == "" && k.PKCS11Backend.TokenSerial == "" && k.PKCS11Backend.SlotNumber == nil {
+			return errors.Errorf(
+				"error in signing conf: %[1]spkcs11.token_label, %[1]spkcs11.token_serial or %[1]spkcs11.slot_number must be specified",
+				prefix,
+			)
 		}
-		if c.PKCS11Backend.Pin == "" && !c.PKCS11Backend.LoginNotSupported {
-			return errors.New("error in signing conf: pkcs11.pin must be specified")
+		if k.PKCS11Backend.Pin == "" && !k.PKCS11Backend.LoginNotSupported {
+			return errors.Errorf("error in signing conf: %spkcs11.pin must be specified", prefix)
 		}
 	case lighthouse.KMSDatabase:
 		if c.PKBackend != lighthouse.PKBackendDatabase {
-			return errors.New("error in signing conf: kms=db requires pk_backend=db")
+			return errors.Errorf("error in signing conf: %skms=db requires pk_backend=db", prefix)
+		}
+	case lighthouse.KMSRemote:
+		if k.RemoteBackend.URL == "" {
+			return errors.Errorf("error in signing conf: %sremote.url must be specified", prefix)
+		}
+		switch k.RemoteBackend.Type {
+		case lighthouse.RemoteKMSTypeTransit:
+			if k.RemoteBackend.KeyName == "" {
+				return errors.Errorf("error in signing conf: %sremote.key_name must be specified for type 'transit'", prefix)
+			}
+		case lighthouse.RemoteKMSTypeREST:
+		default:
+			return errors.Errorf(
+				"error in signing conf: %sremote.type must be '%s' or '%s'",
+				prefix, lighthouse.RemoteKMSTypeTransit, lighthouse.RemoteKMSTypeREST,
+			)
 		}
 	default:
-		return errors.Errorf("error in signing conf: unknown kms '%s'", c.KMS)
+		return errors.Errorf("error in signing conf: unknown %skms '%s'", prefix, k.KMS)
 	}
-	if c.PKBackend == lighthouse.PKBackendFilesystem && c.FileSystemBackend.KeyDir == "" {
-		return errors.New("error in signing conf: filesystem.key_dir must be specified")
+	if k.DBEncryption.Enabled() && k.KMS != lighthouse.KMSDatabase {
+		return errors.Errorf("error in signing conf: %sdb_encryption requires kms=db", prefix)
+	}
+	if err := k.DBEncryption.Validate(); err != nil {
+		return errors.Wrapf(err, "error in signing conf: %sdb_encryption", prefix)
 	}
 	return nil
 }
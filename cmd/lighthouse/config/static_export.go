@@ -0,0 +1,69 @@
+package config
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/zachmann/go-utils/duration"
+
+	"github.com/go-oidfed/lighthouse"
+)
+
+// StaticExportConf configures the export of the federation responses into a
+// directory tree of static files, e.g. to publish them from a CDN.
+//
+// Environment variables (with prefix LH_STATIC_EXPORT_):
+//   - LH_STATIC_EXPORT_ENABLED: Enable the static export
+//   - LH_STATIC_EXPORT_DIR: Directory the responses are rendered into
+//   - LH_STATIC_EXPORT_INTERVAL: Time between two full renderings (e.g., "1h")
+//
+// YAML example:
+//
+//	static_export:
+//	  enabled: true
+//	  dir: /var/www/federation
+//	  interval: 1h
+type StaticExportConf struct {
+	// Enabled turns on the static export.
+	// Env: LH_STATIC_EXPORT_ENABLED
+	Enabled bool `yaml:"enabled" envconfig:"ENABLED"`
+
+	// Dir is the directory the federation responses are rendered into.
+	// Env: LH_STATIC_EXPORT_DIR
+	Dir string `yaml:"dir" envconfig:"DIR"`
+
+	// Interval is the time between two full renderings. It must be shorter
+	// than the lifetime of the entity configuration and subordinate
+	// statements, so that exported statements are renewed before they expire.
+	// Default: 1h
+	// Env: LH_STATIC_EXPORT_INTERVAL
+	Interval duration.DurationOption `yaml:"interval" envconfig:"INTERVAL"`
+}
+
+// validate checks the static export configuration for errors.
+func (s *StaticExportConf) validate() error {
+	if !s.Enabled {
+		return nil
+	}
+	if s.Dir == "" {
+		return errors.New("dir must be set")
+	}
+	if s.Interval.Duration() <= 0 {
+		s.Interval = duration.DurationOption(time.Hour)
+	}
+	return nil
+}
+
+// ToStaticExportConfig converts config.StaticExportConf to
+// lighthouse.StaticExportConfig.
+func (s *StaticExportConf) ToStaticExportConfig() lighthouse.StaticExportConfig {
+	return lighthouse.StaticExportConfig{
+		Dir:      s.Dir,
+		Interval: s.Interval.Duration(),
+	}
+}
+
+var defaultStaticExportConf = StaticExportConf{
+	Enabled:  false,
+	Interval: duration.DurationOption(time.Hour),
+}
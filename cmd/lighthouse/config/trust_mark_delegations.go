@@ -0,0 +1,57 @@
+package config
+
+import (
+	"time"
+
+	"github.com/zachmann/go-utils/duration"
+
+	"github.com/go-oidfed/lighthouse"
+)
+
+// TrustMarkDelegationsConf configures the automatic renewal of the
+// delegation JWTs LightHouse issues as owner of trust mark types, and of the
+// delegation JWTs it fetches from the owners of the trust mark types it
+// issues. Lifetime, ref and push URLs of the issued delegations are
+// configured per trust mark type through the admin API.
+//
+// Environment variables (with prefix LH_TRUST_MARK_DELEGATIONS_):
+//   - LH_TRUST_MARK_DELEGATIONS_ENABLED: Enable the automatic renewal
+//   - LH_TRUST_MARK_DELEGATIONS_INTERVAL: Time between two renewal runs (e.g., "1h")
+//
+// YAML example:
+//
+//	trust_mark_delegations:
+//	  enabled: true
+//	  interval: 1h
+type TrustMarkDelegationsConf struct {
+	// Enabled turns on the automatic renewal.
+	// Env: LH_TRUST_MARK_DELEGATIONS_ENABLED
+	Enabled bool `yaml:"enabled" envconfig:"ENABLED"`
+
+	// Interval is the time between two renewal runs.
+	// Default: 1h
+	// Env: LH_TRUST_MARK_DELEGATIONS_INTERVAL
+	Interval duration.DurationOption `yaml:"interval" envconfig:"INTERVAL"`
+}
+
+// validate checks the trust mark delegations configuration for errors.
+func (t *TrustMarkDelegationsConf) validate() error {
+	if t.Interval.Duration() <= 0 {
+		t.Interval = duration.DurationOption(time.Hour)
+	}
+	return nil
+}
+
+// ToTrustMarkDelegationRenewalConfig converts
+// config.TrustMarkDelegationsConf to
+// lighthouse.TrustMarkDelegationRenewalConfig.
+func (t *TrustMarkDelegationsConf) ToTrustMarkDelegationRenewalConfig() lighthouse.TrustMarkDelegationRenewalConfig {
+	return lighthouse.TrustMarkDelegationRenewalConfig{
+		Interval: t.Interval.Duration(),
+	}
+}
+
+var defaultTrustMarkDelegationsConf = TrustMarkDelegationsConf{
+	Enabled:  false,
+	Interval: duration.DurationOption(time.Hour),
+}
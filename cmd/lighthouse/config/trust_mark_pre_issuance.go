@@ -0,0 +1,54 @@
+package config
+
+import (
+	"time"
+
+	"github.com/zachmann/go-utils/duration"
+
+	"github.com/go-oidfed/lighthouse"
+)
+
+// TrustMarkPreIssuanceConf configures the scheduled pre-issuance of trust
+// marks for the active subjects of trust mark specs with pre-issuance enabled.
+// Pre-issuance is enabled per trust mark spec through the admin API.
+//
+// Environment variables (with prefix LH_TRUST_MARK_PRE_ISSUANCE_):
+//   - LH_TRUST_MARK_PRE_ISSUANCE_ENABLED: Enable the scheduled pre-issuance
+//   - LH_TRUST_MARK_PRE_ISSUANCE_INTERVAL: Time between two pre-issuance runs (e.g., "1h")
+//
+// YAML example:
+//
+//	trust_mark_pre_issuance:
+//	  enabled: true
+//	  interval: 1h
+type TrustMarkPreIssuanceConf struct {
+	// Enabled turns on the scheduled pre-issuance.
+	// Env: LH_TRUST_MARK_PRE_ISSUANCE_ENABLED
+	Enabled bool `yaml:"enabled" envconfig:"ENABLED"`
+
+	// Interval is the time between two pre-issuance runs.
+	// Default: 1h
+	// Env: LH_TRUST_MARK_PRE_ISSUANCE_INTERVAL
+	Interval duration.DurationOption `yaml:"interval" envconfig:"INTERVAL"`
+}
+
+// validate checks the trust mark pre-issuance configuration for errors.
+func (t *TrustMarkPreIssuanceConf) validate() error {
+	if t.Interval.Duration() <= 0 {
+		t.Interval = duration.DurationOption(time.Hour)
+	}
+	return nil
+}
+
+// ToTrustMarkPreIssuanceConfig converts config.TrustMarkPreIssuanceConf to
+// lighthouse.TrustMarkPreIssuanceConfig.
+func (t *TrustMarkPreIssuanceConf) ToTrustMarkPreIssuanceConfig() lighthouse.TrustMarkPreIssuanceConfig {
+	return lighthouse.TrustMarkPreIssuanceConfig{
+		Interval: t.Interval.Duration(),
+	}
+}
+
+var defaultTrustMarkPreIssuanceConf = TrustMarkPreIssuanceConf{
+	Enabled:  false,
+	Interval: duration.DurationOption(time.Hour),
+}
@@ -0,0 +1,97 @@
+package config
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/zachmann/go-utils/duration"
+
+	"github.com/go-oidfed/lighthouse"
+)
+
+// TrustMarkPushConf configures the push delivery of trust marks to the
+// subjects of trust mark specs with push delivery enabled. Push delivery is
+// enabled per trust mark spec, the push URL can be configured per trust mark
+// subject through the admin API.
+//
+// Environment variables (with prefix LH_TRUST_MARK_PUSH_):
+//   - LH_TRUST_MARK_PUSH_ENABLED: Enable the push delivery
+//   - LH_TRUST_MARK_PUSH_INTERVAL: Time between two delivery runs (e.g., "5m")
+//   - LH_TRUST_MARK_PUSH_MAX_ATTEMPTS: Number of delivery attempts before a push is given up
+//   - LH_TRUST_MARK_PUSH_RETRY_BACKOFF: Delay before the first retry, doubled for each further retry
+//   - LH_TRUST_MARK_PUSH_MAX_BACKOFF: Maximum delay between two attempts
+//
+// YAML example:
+//
+//	trust_mark_push:
+//	  enabled: true
+//	  interval: 5m
+//	  max_attempts: 8
+//	  retry_backoff: 1m
+//	  max_backoff: 6h
+type TrustMarkPushConf struct {
+	// Enabled turns on the push delivery.
+	// Env: LH_TRUST_MARK_PUSH_ENABLED
+	Enabled bool `yaml:"enabled" envconfig:"ENABLED"`
+
+	// Interval is the time between two delivery runs.
+	// Default: 5m
+	// Env: LH_TRUST_MARK_PUSH_INTERVAL
+	Interval duration.DurationOption `yaml:"interval" envconfig:"INTERVAL"`
+
+	// MaxAttempts is the number of delivery attempts before a push is given
+	// up.
+	// Default: 8
+	// Env: LH_TRUST_MARK_PUSH_MAX_ATTEMPTS
+	MaxAttempts int `yaml:"max_attempts" envconfig:"MAX_ATTEMPTS"`
+
+	// RetryBackoff is the delay before the first retry; it is doubled for
+	// each further retry.
+	// Default: 1m
+	// Env: LH_TRUST_MARK_PUSH_RETRY_BACKOFF
+	RetryBackoff duration.DurationOption `yaml:"retry_backoff" envconfig:"RETRY_BACKOFF"`
+
+	// MaxBackoff is the maximum delay between two attempts.
+	// Default: 6h
+	// Env: LH_TRUST_MARK_PUSH_MAX_BACKOFF
+	MaxBackoff duration.DurationOption `yaml:"max_backoff" envconfig:"MAX_BACKOFF"`
+}
+
+// validate checks the trust mark push configuration for errors.
+func (t *TrustMarkPushConf) validate() error {
+	if t.MaxAttempts < 0 {
+		return errors.New("trust_mark_push.max_attempts must not be negative")
+	}
+	if t.Interval.Duration() <= 0 {
+		t.Interval = duration.DurationOption(5 * time.Minute)
+	}
+	if t.RetryBackoff.Duration() <= 0 {
+		t.RetryBackoff = duration.DurationOption(time.Minute)
+	}
+	if t.MaxBackoff.Duration() <= 0 {
+		t.MaxBackoff = duration.DurationOption(6 * time.Hour)
+	}
+	if t.MaxBackoff.Duration() < t.RetryBackoff.Duration() {
+		return errors.New("trust_mark_push.max_backoff must not be shorter than retry_backoff")
+	}
+	return nil
+}
+
+// ToTrustMarkPushConfig converts config.TrustMarkPushConf to
+// lighthouse.TrustMarkPushConfig.
+func (t *TrustMarkPushConf) ToTrustMarkPushConfig() lighthouse.TrustMarkPushConfig {
+	return lighthouse.TrustMarkPushConfig{
+		Interval:     t.Interval.Duration(),
+		MaxAttempts:  t.MaxAttempts,
+		RetryBackoff: t.RetryBackoff.Duration(),
+		MaxBackoff:   t.MaxBackoff.Duration(),
+	}
+}
+
+var defaultTrustMarkPushConf = TrustMarkPushConf{
+	Enabled:      false,
+	Interval:     duration.DurationOption(5 * time.Minute),
+	MaxAttempts:  8,
+	RetryBackoff: duration.DurationOption(time.Minute),
+	MaxBackoff:   duration.DurationOption(6 * time.Hour),
+}
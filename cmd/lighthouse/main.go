@@ -75,6 +75,31 @@ func main() {
 
 	log.Info().Msg("Added Endpoints")
 
+	if c.Revalidation.Enabled {
+		lh.StartRevalidation(c.Revalidation.ToRevalidationConfig())
+	}
+	if c.KeyHealth.Enabled {
+		lh.StartKeyHealthMonitor(c.KeyHealth.ToKeyHealthConfig())
+	}
+	if c.StaticExport.Enabled {
+		lh.StartStaticExport(c.StaticExport.ToStaticExportConfig())
+	}
+	if c.TrustMarkDelegations.Enabled {
+		lh.StartTrustMarkDelegationRenewal(c.TrustMarkDelegations.ToTrustMarkDelegationRenewalConfig())
+	}
+	if c.TrustMarkPush.Enabled {
+		lh.StartTrustMarkPushDelivery(c.TrustMarkPush.ToTrustMarkPushConfig())
+	}
+	if c.TrustMarkPreIssuance.Enabled {
+		lh.StartTrustMarkPreIssuance(c.TrustMarkPreIssuance.ToTrustMarkPreIssuanceConfig())
+	}
+	if c.PublishedTrustMarks.Enabled {
+		lh.StartPublishedTrustMarkMonitor(c.PublishedTrustMarks.ToPublishedTrustMarkHealthConfig())
+	}
+	if c.Logos.Enabled {
+		lh.StartLogoHosting(c.Logos.ToLogoHostingConfig())
+	}
+
 	lh.Start()
 }
 
@@ -131,13 +156,14 @@ func initLighthouse(c *config.Config, backs model.Backends, statsConfig stats.Co
 		c.Signing.SigningConf,
 		backs,
 		lighthouse.AdminAPIOptions{
-			Enabled:      c.API.Admin.Enabled,
-			UsersEnabled: c.API.Admin.UsersEnabled,
-			Port:         c.API.Admin.Port,
-			ActorHeader:  c.API.Admin.ActorHeader,
-			ActorSource:  c.API.Admin.ActorSource,
-			CORS:         c.API.Admin.CORS,
-			TLS:          c.API.Admin.TLS,
+			Enabled:          c.API.Admin.Enabled,
+			UsersEnabled:     c.API.Admin.UsersEnabled,
+			Port:             c.API.Admin.Port,
+			ActorHeader:      c.API.Admin.ActorHeader,
+			ActorSource:      c.API.Admin.ActorSource,
+			CORS:             c.API.Admin.CORS,
+			TLS:              c.API.Admin.TLS,
+			ValidateMetadata: c.API.Admin.ValidateMetadata,
 		},
 		statsConfig,
 	)
@@ -150,6 +176,9 @@ func initLighthouse(c *config.Config, backs model.Backends, statsConfig stats.Co
 		lh.SetJTICleanupStop(startJTICleanup(backs.JTI, c.Storage.EndpointAuth.JTICleanupInterval.Duration()))
 	}
 
+	lh.SetNotifier(lighthouse.NewNotifier(c.Notifications))
+	lh.SetJWKSPolicy(c.JWKSPolicy.ToJWKSPolicy())
+
 	lh.LogoBanner = c.Logging.Banner.Logo
 	lh.VersionBanner = c.Logging.Banner.Version
 
@@ -158,12 +187,13 @@ func initLighthouse(c *config.Config, backs model.Backends, statsConfig stats.Co
 
 func setupTrustMarkIssuer(lh *lighthouse.LightHouse, entityID string, backs *model.Backends) {
 	lh.TrustMarkIssuer = oidfed.NewTrustMarkIssuer(
-		entityID, lh.GeneralJWTSigner.TrustMarkSigner(),
+		entityID, lh.PurposeSigner(model.SigningPurposeTrustMarks).TrustMarkSigner(),
 		nil,
 	)
 
 	if backs.TrustMarkSpecs != nil {
 		dbProvider := lighthouse.NewDBTrustMarkSpecProvider(backs.TrustMarkSpecs)
+		dbProvider.SetLogoURIRewriter(lh.HostedLogoURI)
 		lh.TrustMarkIssuer.SetProvider(dbProvider)
 		log.Info().Msg("Configured DB-based TrustMarkSpecProvider")
 	}
@@ -209,7 +239,9 @@ func startSubordinateJWKSRefresher(lh *lighthouse.LightHouse, backs *model.Backe
 		log.Debug().Msg("Subordinate storage not available; skipping subordinate JWKS refresher")
 		return nil
 	}
-	refresher, err := lighthouse.SetupSubordinateJWKSRefresher(backs.Subordinates, backs.SubordinateEvents)
+	refresher, err := lighthouse.SetupSubordinateJWKSRefresher(
+		backs.Subordinates, backs.SubordinateEvents, lh.JWKSPolicy(),
+	)
 	if err != nil {
 		return errors.Wrap(err, "failed to start subordinate JWKS refresher")
 	}
@@ -28,6 +28,7 @@ func (fed *LightHouse) AddEntityCollectionEndpoint(
 	if endpoint.Path == "" {
 		return nil
 	}
+	fed.entityCollector = collector
 	handler := func(ctx *fiber.Ctx) error {
 		var req apimodel.EntityCollectionRequest
 		if err := parseRequest(ctx, &req); err != nil {
@@ -94,7 +95,7 @@ func (fed *LightHouse) AddEntityCollectionEndpoint(
 			ctx.Status(errRes.Status)
 			return ctx.JSON(errRes)
 		}
-		return ctx.JSON(res)
+		return ctx.JSON(fed.rewriteCollectionLogoURIs(res))
 	}
 
 	if endpoint.AuthEnabled {
@@ -0,0 +1,189 @@
+package lighthouse
+
+import (
+	"io"
+
+	"github.com/pkg/errors"
+
+	"github.com/go-oidfed/lighthouse/storage/envelope"
+)
+
+// DBKeyEncryptionConf configures envelope encryption of the private keys
+// stored by the database KMS (kms=db). Each private key is encrypted with its
+// own data key, which is wrapped with the master key.
+// Env prefix: LH_SIGNING_DB_ENCRYPTION_
+type DBKeyEncryptionConf struct {
+	// MasterKey is the master key used to encrypt new private keys.
+	// Env prefix: LH_SIGNING_DB_ENCRYPTION_MASTER_KEY_
+	MasterKey MasterKeyConf `yaml:"master_key" envconfig:"MASTER_KEY"`
+	// PreviousMasterKeys are master keys that were used before a master key
+	// rotation. They are only used to decrypt private keys that were not yet
+	// re-wrapped with the current master key.
+	PreviousMasterKeys []MasterKeyConf `yaml:"previous_master_keys" ignored:"true"`
+	// RequireEncryption rejects private keys that are stored unencrypted
+	// instead of using them. Such keys can be encrypted with
+	// 'lhmigrate encrypt-keys'.
+	// Env: LH_SIGNING_DB_ENCRYPTION_REQUIRE_ENCRYPTION
+	RequireEncryption bool `yaml:"require_encryption" envconfig:"REQUIRE_ENCRYPTION"`
+}
+
+// MasterKeyConf configures a single master key. Exactly one of File, Env and
+// PKCS11 must be set.
+type MasterKeyConf struct {
+	// KeyID identifies the master key; it is stored with every encrypted
+	// private key. If empty, it is derived from the key (file and env) or the
+	// key label (pkcs11).
+	// Env: LH_SIGNING_DB_ENCRYPTION_MASTER_KEY_KEY_ID
+	KeyID string `yaml:"key_id" envconfig:"KEY_ID"`
+	// File is the path to a file holding a 32-byte AES key, either raw or
+	// base64 or hex encoded.
+	// Env: LH_SIGNING_DB_ENCRYPTION_MASTER_KEY_FILE
+	File string `yaml:"file" envconfig:"FILE"`
+	// Env is the name of an environment variable holding a base64 or hex
+	// encoded 32-byte AES key.
+	// Env: LH_SIGNING_DB_ENCRYPTION_MASTER_KEY_ENV
+	Env string `yaml:"env" envconfig:"ENV"`
+	// PKCS11 configures an AES wrapping key in a PKCS#11 token (HSM).
+	// Env prefix: LH_SIGNING_DB_ENCRYPTION_MASTER_KEY_PKCS11_
+	PKCS11 *MasterKeyPKCS11Conf `yaml:"pkcs11" envconfig:"PKCS11"`
+}
+
+// MasterKeyPKCS11Conf configures an AES wrapping key in a PKCS#11 token
+type MasterKeyPKCS11Conf struct {
+	// ModulePath is the path to the PKCS#11 module
+	ModulePath string `yaml:"module_path" envconfig:"MODULE_PATH"`
+	// TokenLabel selects the token by label
+	TokenLabel string `yaml:"token_label" envconfig:"TOKEN_LABEL"`
+	// TokenSerial selects the token by serial
+	TokenSerial string `yaml:"token_serial" envconfig:"TOKEN_SERIAL"`
+	// SlotNumber selects the token by slot number
+	SlotNumber *int `yaml:"token_slot" envconfig:"TOKEN_SLOT"`
+	// Pin is the user PIN for the token
+	Pin string `yaml:"pin" envconfig:"PIN"`
+	// LoginNotSupported should be set for tokens that do not support logging in
+	LoginNotSupported bool `yaml:"no_login" envconfig:"NO_LOGIN"`
+	// KeyLabel is the label of the AES secret key
+	KeyLabel string `yaml:"key_label" envconfig:"KEY_LABEL"`
+}
+
+// Enabled returns true if a master key is configured
+func (c DBKeyEncryptionConf) Enabled() bool {
+	return c.MasterKey.configured()
+}
+
+// Validate checks that the configured master keys are complete
+func (c DBKeyEncryptionConf) Validate() error {
+	if !c.Enabled() {
+		if len(c.PreviousMasterKeys) > 0 {
+			return errors.New("previous_master_keys requires master_key")
+		}
+		if c.RequireEncryption {
+			return errors.New("require_encryption requires master_key")
+		}
+		return nil
+	}
+	if err := c.MasterKey.validate(); err != nil {
+		return errors.Wrap(err, "master_key")
+	}
+	for i, k := range c.PreviousMasterKeys {
+		if err := k.validate(); err != nil {
+			return errors.Wrapf(err, "previous_master_keys[%d]", i)
+		}
+	}
+	return nil
+}
+
+// NewSealer loads the configured master keys and returns an envelope.Sealer
+// for them
+func (c DBKeyEncryptionConf) NewSealer() (*envelope.Sealer, error) {
+	if !c.Enabled() {
+		return nil, errors.New("no master key configured")
+	}
+	primary, err := c.MasterKey.load()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load master key")
+	}
+	loaded := []envelope.KeyWrapper{primary}
+	for i, k := range c.PreviousMasterKeys {
+		var w envelope.KeyWrapper
+		if w, err = k.load(); err != nil {
+			closeKeyWrappers(loaded)
+			return nil, errors.Wrapf(err, "failed to load previous master key %d", i)
+		}
+		loaded = append(loaded, w)
+	}
+	sealer, err := envelope.NewSealer(primary, loaded[1:]...)
+	if err != nil {
+		closeKeyWrappers(loaded)
+		return nil, err
+	}
+	return sealer, nil
+}
+
+// closeKeyWrappers releases loaded master keys that hold resources, e.g.
+// sessions to a PKCS#11 token
+func closeKeyWrappers(wrappers []envelope.KeyWrapper) {
+	for _, w := range wrappers {
+		if closer, ok := w.(io.Closer); ok {
+			_ = closer.Close()
+		}
+	}
+}
+
+func (c MasterKeyConf) configured() bool {
+	return c.File != "" || c.Env != "" || c.PKCS11 != nil
+}
+
+func (c MasterKeyConf) validate() error {
+	n := 0
+	for _, set := range []bool{
+		c.File != "",
+		c.Env != "",
+		c.PKCS11 != nil,
+	} {
+		if set {
+			n++
+		}
+	}
+	if n != 1 {
+		return errors.New("exactly one of file, env and pkcs11 must be specified")
+	}
+	if p := c.PKCS11; p != nil {
+		if p.ModulePath == "" {
+			return errors.New("pkcs11.module_path must be specified")
+		}
+		if p.KeyLabel == "" {
+			return errors.New("pkcs11.key_label must be specified")
+		}
+		if p.TokenLabel == "" && p.TokenSerial == "" && p.SlotNumber == nil {
+			return errors.New("pkcs11.token_label, pkcs11.token_serial or pkcs11.token_slot must be specified")
+		}
+		if p.Pin == "" && !p.LoginNotSupported {
+			return errors.New("pkcs11.pin must be specified")
+		}
+	}
+	return nil
+}
+
+func (c MasterKeyConf) load() (envelope.KeyWrapper, error) {
+	switch {
+	case c.File != "":
+		return envelope.LoadMasterKeyFile(c.KeyID, c.File)
+	case c.Env != "":
+		return envelope.LoadMasterKeyEnv(c.KeyID, c.Env)
+	case c.PKCS11 != nil:
+		return envelope.NewPKCS11KeyWrapper(
+			c.KeyID, envelope.PKCS11Config{
+				ModulePath:        c.PKCS11.ModulePath,
+				TokenLabel:        c.PKCS11.TokenLabel,
+				TokenSerial:       c.PKCS11.TokenSerial,
+				SlotNumber:        c.PKCS11.SlotNumber,
+				Pin:               c.PKCS11.Pin,
+				LoginNotSupported: c.PKCS11.LoginNotSupported,
+				KeyLabel:          c.PKCS11.KeyLabel,
+			},
+		)
+	default:
+		return nil, errors.New("no master key source specified")
+	}
+}
@@ -8,12 +8,12 @@ import (
 	"time"
 
 	oidfed "github.com/go-oidfed/lib"
-	"github.com/go-oidfed/lib/cache"
 	"github.com/go-oidfed/lib/oidfedconst"
 	"github.com/gofiber/fiber/v2"
 	"github.com/rs/zerolog/log"
 
 	"github.com/go-oidfed/lighthouse/internal"
+	"github.com/go-oidfed/lighthouse/internal/metadataschema"
 	"github.com/go-oidfed/lighthouse/storage"
 	"github.com/go-oidfed/lighthouse/storage/model"
 )
@@ -241,7 +241,7 @@ func (fed *LightHouse) ReloadEndpointsFromDB() error {
 	}
 
 	// Invalidate entity configuration cache so published metadata updates.
-	_ = cache.Delete(internal.CacheKeyEntityConfiguration)
+	_ = internal.DeleteCache(internal.CacheKeyEntityConfiguration)
 	return nil
 }
 
@@ -346,6 +346,9 @@ func (fed *LightHouse) dispatch(ctx *fiber.Ctx) error {
 		len(path) >= len("/api/v1/admin") && path[:len("/api/v1/admin")] == "/api/v1/admin" {
 		return ctx.Next()
 	}
+	if fed.isLogoPath(path) {
+		return fed.serveLogo(ctx)
+	}
 
 	ep := fed.endpointRegistry.lookup(path)
 	if ep == nil {
@@ -457,7 +460,7 @@ func (fed *LightHouse) loadEndpointFromDB(ep *model.FederationEndpoint) error {
 					StoreJWT:  cfg.ProactiveResolver.ResponseStorageStoreJWT,
 					StoreJSON: cfg.ProactiveResolver.ResponseStorageStoreJSON,
 				},
-				Signer:      fed.ResolveResponseSigner(),
+				Signer:      fed.PurposeSigner(model.SigningPurposeResolve).ResolveResponseSigner(),
 				RefreshLead: time.Duration(cfg.GracePeriodSeconds) * time.Second,
 				Concurrency: cfg.ProactiveResolver.ConcurrencyLimit,
 				QueueSize:   cfg.ProactiveResolver.QueueSize,
@@ -484,6 +487,14 @@ func (fed *LightHouse) loadEndpointFromDB(ep *model.FederationEndpoint) error {
 		issuedTrustMarkCache := NewIssuedTrustMarkCache()
 		stopIssuedCacheCleanup := issuedTrustMarkCache.StartCleanupRoutine(5 * time.Minute)
 		_ = stopIssuedCacheCleanup // TODO: manage lifecycle
+		fed.eligibilityCache = eligibilityCache
+		fed.issuedTrustMarkCache = issuedTrustMarkCache
+		var cfg trustMarkDBConfig
+		if ep.Config != "" {
+			if err := json.Unmarshal([]byte(ep.Config), &cfg); err != nil {
+				return fmt.Errorf("failed to parse trust_mark config: %w", err)
+			}
+		}
 		return fed.AddTrustMarkEndpointWithConfig(
 			endpointConf, TrustMarkEndpointConfig{
 				Store:                fed.storages.TrustMarks,
@@ -491,11 +502,13 @@ func (fed *LightHouse) loadEndpointFromDB(ep *model.FederationEndpoint) error {
 				InstanceStore:        fed.storages.TrustMarkInstances,
 				Cache:                eligibilityCache,
 				IssuedTrustMarkCache: issuedTrustMarkCache,
+				CheckTimeout:         time.Duration(cfg.CheckTimeoutSeconds) * time.Second,
+				ReportCounters:       fed.trustMarkReportCounters(),
 			},
 		)
 
 	case model.EndpointTypeTrustMarkRequest:
-		return fed.AddTrustMarkRequestEndpoint(endpointConf, fed.storages.TrustMarks)
+		return fed.AddTrustMarkRequestEndpoint(endpointConf, fed.storages.TrustMarks, fed.storages.TrustMarkRequests)
 
 	case model.EndpointTypeHistoricalKeys:
 		return fed.AddHistoricalKeysEndpoint(endpointConf)
@@ -507,15 +520,14 @@ func (fed *LightHouse) loadEndpointFromDB(ep *model.FederationEndpoint) error {
 				return fmt.Errorf("failed to parse enroll config: %w", err)
 			}
 		}
-		var checker EntityChecker
-		if cfg.CheckerType != "" {
-			c, err := EntityCheckerFromJSONConfig(cfg.CheckerType, cfg.CheckerConfig)
-			if err != nil {
-				return fmt.Errorf("failed to create entity checker: %w", err)
-			}
-			checker = c
+		checker, err := enrollCheckerFromDBConfig(ep.Config, fed.metadataValidator)
+		if err != nil {
+			return err
 		}
-		return fed.AddEnrollEndpoint(endpointConf, fed.storages.Subordinates, checker)
+		return fed.AddEnrollEndpoint(
+			endpointConf, fed.storages.Subordinates, checker,
+			time.Duration(cfg.CheckTimeoutSeconds)*time.Second,
+		)
 
 	case model.EndpointTypeEnrollRequest:
 		return fed.AddEnrollRequestEndpoint(endpointConf, fed.storages.Subordinates)
@@ -552,6 +564,12 @@ func (fed *LightHouse) loadEndpointFromDB(ep *model.FederationEndpoint) error {
 	case model.EndpointTypeJwksUpdate:
 		return fed.AddJWKSUpdateEndpoint(endpointConf, fed.storages.Subordinates)
 
+	case model.EndpointTypeTrustMarkDelegation:
+		return fed.AddTrustMarkDelegationEndpoint(endpointConf)
+
+	case model.EndpointTypeTrustMarkRequestStatus:
+		return fed.AddTrustMarkRequestStatusEndpoint(endpointConf, fed.storages.TrustMarkRequests)
+
 	default:
 		return fmt.Errorf("unknown endpoint type: %s", ep.Type)
 	}
@@ -619,8 +637,34 @@ type proactiveResolverDBConfig struct {
 }
 
 type enrollDBConfig struct {
-	CheckerType   string `json:"checker_type,omitempty"`
-	CheckerConfig any    `json:"checker_config,omitempty"`
+	CheckerType         string `json:"checker_type,omitempty"`
+	CheckerConfig       any    `json:"checker_config,omitempty"`
+	CheckTimeoutSeconds int64  `json:"check_timeout_seconds,omitempty"`
+}
+
+type trustMarkDBConfig struct {
+	CheckTimeoutSeconds int64 `json:"check_timeout_seconds,omitempty"`
+}
+
+// enrollCheckerFromDBConfig builds the EntityChecker configured for the enroll
+// endpoint from its JSON config and sets the checker context for contextual
+// checkers (like metadata_schema). It returns nil if no checker is configured.
+func enrollCheckerFromDBConfig(config string, metadataValidator *metadataschema.Validator) (EntityChecker, error) {
+	var cfg enrollDBConfig
+	if config != "" {
+		if err := json.Unmarshal([]byte(config), &cfg); err != nil {
+			return nil, fmt.Errorf("failed to parse enroll config: %w", err)
+		}
+	}
+	if cfg.CheckerType == "" {
+		return nil, nil
+	}
+	checker, err := EntityCheckerFromJSONConfig(cfg.CheckerType, cfg.CheckerConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create entity checker: %w", err)
+	}
+	setCheckersContext(CheckerContext{MetadataValidator: metadataValidator}, checker)
+	return checker, nil
 }
 
 type collectionDBConfig struct {
@@ -1,6 +1,8 @@
 package lighthouse
 
 import (
+	"time"
+
 	"github.com/go-oidfed/lib"
 	"github.com/go-oidfed/lib/jwx"
 	"github.com/go-oidfed/lib/oidfedconst"
@@ -17,10 +19,13 @@ type enrollRequest struct {
 }
 
 // AddEnrollEndpoint adds an endpoint to enroll to this IA/TA
+// checkTimeout is the overall time budget for running the checker per request;
+// if it is not positive, DefaultEntityCheckTimeout is used.
 func (fed *LightHouse) AddEnrollEndpoint(
 	endpoint EndpointConf,
 	store model.SubordinateStorageBackend,
 	checker EntityChecker,
+	checkTimeout time.Duration,
 ) error {
 	if fed.fedMetadata.Extra == nil {
 		fed.fedMetadata.Extra = make(map[string]any)
@@ -88,7 +93,9 @@ func (fed *LightHouse) AddEnrollEndpoint(
 			req.EntityTypes = entityConfig.Metadata.GuessEntityTypes()
 		}
 		if checker != nil {
-			ok, errStatus, errResponse := checker.Check(entityConfig, req.EntityTypes)
+			ok, errStatus, errResponse := RunEntityChecker(
+				ctx.UserContext(), checkTimeout, checker, entityConfig, req.EntityTypes,
+			)
 			if !ok {
 				ctx.Status(errStatus)
 				return ctx.JSON(errResponse)
@@ -1,8 +1,11 @@
 package lighthouse
 
 import (
+	"context"
 	"fmt"
 	"slices"
+	"strings"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/pkg/errors"
@@ -21,7 +24,10 @@ type EntityChecker interface {
 	// satisfies the requirements of this EntityChecker or not
 	// It returns a bool indicating this status,
 	// and if not a http status code as well as a oidfed.Error as api response
+	// The passed context carries the cancellation and overall deadline of the
+	// check; implementations doing I/O should abort once it is done.
 	Check(
+		ctx context.Context,
 		entityConfiguration *oidfed.EntityStatement,
 		entityTypes []string,
 	) (bool, int, *oidfed.Error)
@@ -29,6 +35,10 @@ type EntityChecker interface {
 	yaml.Unmarshaler
 }
 
+// DefaultEntityCheckTimeout is the overall time budget for the entity checks
+// of a single request if no other timeout is configured
+const DefaultEntityCheckTimeout = 30 * time.Second
+
 var entityCheckerRegistry = make(map[string]func() EntityChecker)
 
 // RegisterEntityChecker registers a custom EntityChecker so
@@ -46,6 +56,8 @@ func init() {
 	RegisterEntityChecker("entity_id", func() EntityChecker { return &EntityIDEntityChecker{} })
 	RegisterEntityChecker("multiple_and", func() EntityChecker { return &MultipleEntityCheckerAnd{} })
 	RegisterEntityChecker("multiple_or", func() EntityChecker { return &MultipleEntityCheckerOr{} })
+	RegisterEntityChecker("not", func() EntityChecker { return &NotEntityChecker{} })
+	RegisterEntityChecker("threshold", func() EntityChecker { return &ThresholdEntityChecker{} })
 }
 
 // EntityCheckerConfig is a type for configuring an EntityChecker through yaml
@@ -128,7 +140,7 @@ func EntityCheckerFromJSONConfig(checkerType string, config any) (EntityChecker,
 type EntityCheckerNone struct{}
 
 // Check implements the EntityChecker interface
-func (EntityCheckerNone) Check(_ *oidfed.EntityStatement, _ []string) (
+func (EntityCheckerNone) Check(_ context.Context, _ *oidfed.EntityStatement, _ []string) (
 	bool, int, *oidfed.Error,
 ) {
 	return true, 0, nil
@@ -141,8 +153,11 @@ func (EntityCheckerNone) UnmarshalYAML(_ *yaml.Node) error {
 
 // MultipleEntityCheckerOr is an EntityChecker that combines multiple
 // EntityChecker by requiring only one check to pass
+// If Concurrent is set, all checks are run at the same time and the remaining
+// checks are cancelled as soon as one check passed.
 type MultipleEntityCheckerOr struct {
-	Checkers []EntityChecker
+	Checkers   []EntityChecker
+	Concurrent bool
 }
 
 // NewMultipleEntityCheckerOr returns a new MultipleEntityCheckerOr using
@@ -153,12 +168,20 @@ func NewMultipleEntityCheckerOr(checkers ...EntityChecker) *MultipleEntityChecke
 
 // Check implements the EntityChecker interface
 func (c MultipleEntityCheckerOr) Check(
-	entityStatement *oidfed.EntityStatement, entityTypes []string,
+	ctx context.Context, entityStatement *oidfed.EntityStatement, entityTypes []string,
 ) (bool, int, *oidfed.Error) {
-	for _, checker := range c.Checkers {
-		if ok, _, _ := checker.Check(entityStatement, entityTypes); ok {
-			return true, 0, nil
-		}
+	passed := false
+	evaluateCheckers(
+		ctx, c.Concurrent, c.Checkers, entityStatement, entityTypes, func(r checkResult) bool {
+			passed = r.ok
+			return passed
+		},
+	)
+	if passed {
+		return true, 0, nil
+	}
+	if ctx.Err() != nil {
+		return entityCheckAborted(ctx)
 	}
 	return false, fiber.StatusForbidden, &oidfed.Error{
 		Error:            "forbidden",
@@ -166,26 +189,30 @@ func (c MultipleEntityCheckerOr) Check(
 	}
 }
 
+// SetContext implements the ContextualEntityChecker interface by passing the
+// context on to all contextual sub-checkers
+func (c *MultipleEntityCheckerOr) SetContext(ctx CheckerContext) {
+	setCheckersContext(ctx, c.Checkers...)
+}
+
 // UnmarshalYAML implements the yaml.Unmarshaler and EntityChecker interfaces
 func (c *MultipleEntityCheckerOr) UnmarshalYAML(node *yaml.Node) error {
-	var datas []EntityCheckerConfig
-	if err := node.Decode(&datas); err != nil {
-		return errors.WithStack(err)
-	}
-	for _, data := range datas {
-		checker, err := EntityCheckerFromEntityCheckerConfig(data)
-		if err != nil {
-			return errors.WithStack(err)
-		}
-		c.Checkers = append(c.Checkers, checker)
+	checkers, concurrent, err := decodeCheckerList(node)
+	if err != nil {
+		return err
 	}
+	c.Checkers = append(c.Checkers, checkers...)
+	c.Concurrent = concurrent
 	return nil
 }
 
 // MultipleEntityCheckerAnd is an EntityChecker that combines multiple
 // EntityChecker by requiring all checks to pass
+// If Concurrent is set, all checks are run at the same time and the remaining
+// checks are cancelled as soon as one check failed.
 type MultipleEntityCheckerAnd struct {
-	Checkers []EntityChecker
+	Checkers   []EntityChecker
+	Concurrent bool
 }
 
 // NewMultipleEntityCheckerAnd returns a new MultipleEntityCheckerAnd using
@@ -197,25 +224,250 @@ func NewMultipleEntityCheckerAnd(
 }
 
 // Check implements the EntityChecker interface
-func (c MultipleEntityCheckerAnd) Check(entityStatement *oidfed.EntityStatement, entityTypes []string) (
-	bool, int, *oidfed.Error,
-) {
-	for _, checker := range c.Checkers {
-		if ok, status, err := checker.Check(entityStatement, entityTypes); !ok {
-			return ok, status, err
-		}
+func (c MultipleEntityCheckerAnd) Check(
+	ctx context.Context, entityStatement *oidfed.EntityStatement, entityTypes []string,
+) (bool, int, *oidfed.Error) {
+	var failed *checkResult
+	evaluateCheckers(
+		ctx, c.Concurrent, c.Checkers, entityStatement, entityTypes, func(r checkResult) bool {
+			if !r.ok {
+				failed = &r
+			}
+			return failed != nil
+		},
+	)
+	if ctx.Err() != nil {
+		return entityCheckAborted(ctx)
+	}
+	if failed != nil {
+		return false, failed.status, failed.err
 	}
 	return true, 0, nil
 }
 
+// SetContext implements the ContextualEntityChecker interface by passing the
+// context on to all contextual sub-checkers
+func (c *MultipleEntityCheckerAnd) SetContext(ctx CheckerContext) {
+	setCheckersContext(ctx, c.Checkers...)
+}
+
 // UnmarshalYAML implements the yaml.Unmarshaler and EntityChecker interfaces
 func (c *MultipleEntityCheckerAnd) UnmarshalYAML(node *yaml.Node) error {
-	var datas []EntityCheckerConfig
-	if err := node.Decode(&datas); err != nil {
+	checkers, concurrent, err := decodeCheckerList(node)
+	if err != nil {
+		return err
+	}
+	c.Checkers = append(c.Checkers, checkers...)
+	c.Concurrent = concurrent
+	return nil
+}
+
+// decodeCheckerList decodes the config of the multiple_and and multiple_or
+// checkers. The config is either a list of checker configs or a mapping with
+// the keys "checkers" and "concurrent".
+func decodeCheckerList(node *yaml.Node) ([]EntityChecker, bool, error) {
+	var data struct {
+		Checkers   []EntityCheckerConfig `yaml:"checkers"`
+		Concurrent bool                  `yaml:"concurrent"`
+	}
+	var err error
+	if node.Kind == yaml.MappingNode {
+		err = node.Decode(&data)
+	} else {
+		err = node.Decode(&data.Checkers)
+	}
+	if err != nil {
+		return nil, false, errors.WithStack(err)
+	}
+	checkers := make([]EntityChecker, 0, len(data.Checkers))
+	for _, d := range data.Checkers {
+		checker, err := EntityCheckerFromEntityCheckerConfig(d)
+		if err != nil {
+			return nil, false, errors.WithStack(err)
+		}
+		checkers = append(checkers, checker)
+	}
+	return checkers, data.Concurrent, nil
+}
+
+// NotEntityChecker is an EntityChecker that negates another EntityChecker,
+// i.e. it passes if the wrapped check fails.
+// Server-side errors (5xx) of the wrapped check are not negated but passed
+// through, since they do not tell anything about the entity.
+type NotEntityChecker struct {
+	Checker EntityChecker
+}
+
+// NewNotEntityChecker returns a new NotEntityChecker negating the passed
+// EntityChecker
+func NewNotEntityChecker(checker EntityChecker) *NotEntityChecker {
+	return &NotEntityChecker{Checker: checker}
+}
+
+// Check implements the EntityChecker interface
+func (c NotEntityChecker) Check(
+	ctx context.Context, entityStatement *oidfed.EntityStatement, entityTypes []string,
+) (bool, int, *oidfed.Error) {
+	if c.Checker == nil {
+		return false, fiber.StatusInternalServerError,
+			oidfed.ErrorServerError("not checker: no check configured")
+	}
+	ok, status, err := c.Checker.Check(ctx, entityStatement, entityTypes)
+	// A check that failed because it was aborted must not be negated
+	if ctx.Err() != nil {
+		return entityCheckAborted(ctx)
+	}
+	if !ok {
+		if status >= fiber.StatusInternalServerError {
+			return false, status, err
+		}
+		return true, 0, nil
+	}
+	return false, fiber.StatusForbidden, &oidfed.Error{
+		Error:            "forbidden",
+		ErrorDescription: "entity satisfies a check it must not satisfy",
+	}
+}
+
+// SetContext implements the ContextualEntityChecker interface by passing the
+// context on to the wrapped checker if it is contextual
+func (c *NotEntityChecker) SetContext(ctx CheckerContext) {
+	setCheckersContext(ctx, c.Checker)
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler and EntityChecker interfaces
+func (c *NotEntityChecker) UnmarshalYAML(node *yaml.Node) error {
+	var data EntityCheckerConfig
+	if err := node.Decode(&data); err != nil {
+		return errors.WithStack(err)
+	}
+	checker, err := EntityCheckerFromEntityCheckerConfig(data)
+	if err != nil {
 		return errors.WithStack(err)
 	}
-	for _, data := range datas {
-		checker, err := EntityCheckerFromEntityCheckerConfig(data)
+	c.Checker = checker
+	return nil
+}
+
+// ThresholdEntityChecker is an EntityChecker that combines multiple
+// EntityChecker by requiring at least Min of them to pass (k-of-n)
+// If Concurrent is set, all checks are run at the same time and the remaining
+// checks are cancelled as soon as the outcome is decided.
+type ThresholdEntityChecker struct {
+	Min        int
+	Checkers   []EntityChecker
+	Concurrent bool
+}
+
+// NewThresholdEntityChecker returns a new ThresholdEntityChecker requiring
+// at least minPassed of the passed EntityChecker to pass. minPassed must be
+// between 1 and the number of checkers.
+func NewThresholdEntityChecker(
+	minPassed int, checkers ...EntityChecker,
+) (*ThresholdEntityChecker, error) {
+	if err := validateThresholdMin(minPassed, len(checkers)); err != nil {
+		return nil, err
+	}
+	return &ThresholdEntityChecker{
+		Min:      minPassed,
+		Checkers: checkers,
+	}, nil
+}
+
+// validateThresholdMin checks that the min of a ThresholdEntityChecker with
+// numCheckers checkers can be reached and is not trivially satisfied
+func validateThresholdMin(minPassed, numCheckers int) error {
+	if minPassed < 1 || minPassed > numCheckers {
+		return errors.Errorf(
+			"threshold checker: min must be between 1 and %d, got %d",
+			numCheckers, minPassed,
+		)
+	}
+	return nil
+}
+
+// Check implements the EntityChecker interface
+// If the threshold is not reached and no checker denied the entity, but at
+// least one failed with a server-side error (5xx), that error is returned,
+// since the entity was not actually found to be ineligible.
+func (c ThresholdEntityChecker) Check(
+	ctx context.Context, entityStatement *oidfed.EntityStatement, entityTypes []string,
+) (bool, int, *oidfed.Error) {
+	if err := validateThresholdMin(c.Min, len(c.Checkers)); err != nil {
+		return false, fiber.StatusInternalServerError, oidfed.ErrorServerError(err.Error())
+	}
+	passed, failed, denied := 0, 0, 0
+	var serverErr *checkResult
+	var reasons []string
+	evaluateCheckers(
+		ctx, c.Concurrent, c.Checkers, entityStatement, entityTypes, func(r checkResult) bool {
+			if r.ok {
+				passed++
+				return passed >= c.Min
+			}
+			failed++
+			if r.status >= fiber.StatusInternalServerError {
+				if serverErr == nil {
+					serverErr = &r
+				}
+			} else {
+				denied++
+			}
+			if r.err != nil && r.err.ErrorDescription != "" {
+				reasons = append(reasons, r.err.ErrorDescription)
+			}
+			// Stop early if the threshold can no longer be reached
+			return len(c.Checkers)-failed < c.Min
+		},
+	)
+	if passed >= c.Min {
+		return true, 0, nil
+	}
+	if ctx.Err() != nil {
+		return entityCheckAborted(ctx)
+	}
+	if denied == 0 && serverErr != nil {
+		return false, serverErr.status, serverErr.err
+	}
+	description := fmt.Sprintf(
+		"at least %d of %d checks must pass, but only %d passed",
+		c.Min, len(c.Checkers), passed,
+	)
+	if len(reasons) > 0 {
+		description += ": " + strings.Join(reasons, "; ")
+	}
+	return false, fiber.StatusForbidden, &oidfed.Error{
+		Error:            "forbidden",
+		ErrorDescription: description,
+	}
+}
+
+// SetContext implements the ContextualEntityChecker interface by passing the
+// context on to all contextual sub-checkers
+func (c *ThresholdEntityChecker) SetContext(ctx CheckerContext) {
+	setCheckersContext(ctx, c.Checkers...)
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler and EntityChecker interfaces
+func (c *ThresholdEntityChecker) UnmarshalYAML(node *yaml.Node) error {
+	var data struct {
+		Min        int                   `yaml:"min"`
+		Checkers   []EntityCheckerConfig `yaml:"checkers"`
+		Concurrent bool                  `yaml:"concurrent"`
+	}
+	if err := node.Decode(&data); err != nil {
+		return errors.WithStack(err)
+	}
+	if len(data.Checkers) == 0 {
+		return errors.New("threshold checker: no checkers configured")
+	}
+	if err := validateThresholdMin(data.Min, len(data.Checkers)); err != nil {
+		return err
+	}
+	c.Min = data.Min
+	c.Concurrent = data.Concurrent
+	for _, d := range data.Checkers {
+		checker, err := EntityCheckerFromEntityCheckerConfig(d)
 		if err != nil {
 			return errors.WithStack(err)
 		}
@@ -224,6 +476,119 @@ func (c *MultipleEntityCheckerAnd) UnmarshalYAML(node *yaml.Node) error {
 	return nil
 }
 
+// checkResult is the result of a single EntityChecker.Check call
+type checkResult struct {
+	ok     bool
+	status int
+	err    *oidfed.Error
+}
+
+// evaluateCheckers runs the passed checkers and passes each result to decided,
+// which returns true once the outcome of the composite check is known.
+// Without concurrent, the checkers run one after another in order; with
+// concurrent, they all start at once and the checks that are still running
+// are cancelled as soon as the outcome is known.
+func evaluateCheckers(
+	ctx context.Context, concurrent bool, checkers []EntityChecker,
+	entityStatement *oidfed.EntityStatement, entityTypes []string,
+	decided func(checkResult) bool,
+) {
+	if !concurrent {
+		for _, checker := range checkers {
+			if ctx.Err() != nil {
+				return
+			}
+			ok, status, err := checker.Check(ctx, entityStatement, entityTypes)
+			if decided(checkResult{ok: ok, status: status, err: err}) {
+				return
+			}
+		}
+		return
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	results := make(chan checkResult, len(checkers))
+	for _, checker := range checkers {
+		go func() {
+			ok, status, err := checker.Check(ctx, entityStatement, entityTypes)
+			results <- checkResult{ok: ok, status: status, err: err}
+		}()
+	}
+	for range checkers {
+		select {
+		case r := <-results:
+			if decided(r) {
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// checkWithContext runs check and returns early if ctx is done before check
+// completes. It is used for checks based on calls that cannot be cancelled;
+// these keep running in the background until they complete.
+func checkWithContext(
+	ctx context.Context, check func() (bool, int, *oidfed.Error),
+) (bool, int, *oidfed.Error) {
+	if ctx.Err() != nil {
+		return entityCheckAborted(ctx)
+	}
+	done := make(chan checkResult, 1)
+	go func() {
+		ok, status, err := check()
+		done <- checkResult{ok: ok, status: status, err: err}
+	}()
+	select {
+	case r := <-done:
+		return r.ok, r.status, r.err
+	case <-ctx.Done():
+		return entityCheckAborted(ctx)
+	}
+}
+
+// entityCheckAborted returns the result of an entity check that was aborted
+// because the passed context is done
+func entityCheckAborted(ctx context.Context) (bool, int, *oidfed.Error) {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return false, fiber.StatusGatewayTimeout,
+			oidfed.ErrorServerError("entity checks did not complete in time")
+	}
+	return false, fiber.StatusServiceUnavailable,
+		oidfed.ErrorServerError("entity checks were cancelled")
+}
+
+// RunEntityChecker runs the passed EntityChecker with an overall deadline of
+// timeout (DefaultEntityCheckTimeout if timeout is not positive) derived from
+// ctx. If the check fails because the deadline passed or ctx was cancelled,
+// a 504 or 503 server error is returned instead of the checker's result.
+func RunEntityChecker(
+	ctx context.Context, timeout time.Duration, checker EntityChecker,
+	entityConfiguration *oidfed.EntityStatement, entityTypes []string,
+) (bool, int, *oidfed.Error) {
+	if timeout <= 0 {
+		timeout = DefaultEntityCheckTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	ok, status, err := checker.Check(ctx, entityConfiguration, entityTypes)
+	if !ok && ctx.Err() != nil {
+		return entityCheckAborted(ctx)
+	}
+	return ok, status, err
+}
+
+// setCheckersContext sets the passed CheckerContext on all passed
+// EntityChecker that implement ContextualEntityChecker
+func setCheckersContext(ctx CheckerContext, checkers ...EntityChecker) {
+	for _, checker := range checkers {
+		if contextual, ok := checker.(ContextualEntityChecker); ok {
+			contextual.SetContext(ctx)
+		}
+	}
+}
+
 // TrustMarkEntityChecker checks that the entity has a
 // valid trust mark. The trust mark can be checked with a specific issuer or
 // through the federation
@@ -236,9 +601,18 @@ type TrustMarkEntityChecker struct {
 
 // Check implements the EntityChecker interface
 func (c TrustMarkEntityChecker) Check(
+	ctx context.Context,
 	entityConfiguration *oidfed.EntityStatement,
 	entityTypes []string, // skipcq: RVV-B0012
 ) (bool, int, *oidfed.Error) {
+	return checkWithContext(
+		ctx, func() (bool, int, *oidfed.Error) {
+			return c.check(entityConfiguration)
+		},
+	)
+}
+
+func (c TrustMarkEntityChecker) check(entityConfiguration *oidfed.EntityStatement) (bool, int, *oidfed.Error) {
 	tms := entityConfiguration.TrustMarks
 	noTrustMarkError := &oidfed.Error{
 		Error:            "forbidden",
@@ -312,27 +686,32 @@ func (c *TrustPathEntityChecker) UnmarshalYAML(node *yaml.Node) error {
 
 // Check implements the EntityChecker interface
 func (c TrustPathEntityChecker) Check(
+	ctx context.Context,
 	entityConfiguration *oidfed.EntityStatement,
 	entityTypes []string,
 ) (bool, int, *oidfed.Error) {
 	idChecker := EntityIDEntityChecker{AllowedIDs: c.TrustAnchorIDs}
-	if ok, _, _ := idChecker.Check(entityConfiguration, entityTypes); ok {
+	if ok, _, _ := idChecker.Check(ctx, entityConfiguration, entityTypes); ok {
 		return true, 0, nil
 	}
 
-	confirmedValid, _ := oidfed.DefaultMetadataResolver.ResolvePossible(
-		apimodel.ResolveRequest{
-			Subject:     entityConfiguration.Subject,
-			TrustAnchor: c.TrustAnchorIDs,
+	return checkWithContext(
+		ctx, func() (bool, int, *oidfed.Error) {
+			confirmedValid, _ := oidfed.DefaultMetadataResolver.ResolvePossible(
+				apimodel.ResolveRequest{
+					Subject:     entityConfiguration.Subject,
+					TrustAnchor: c.TrustAnchorIDs,
+				},
+			)
+			if !confirmedValid {
+				return false, fiber.StatusForbidden, &oidfed.Error{
+					Error:            "forbidden",
+					ErrorDescription: "no valid trust path to trust anchors found",
+				}
+			}
+			return true, 0, nil
 		},
 	)
-	if !confirmedValid {
-		return false, fiber.StatusForbidden, &oidfed.Error{
-			Error:            "forbidden",
-			ErrorDescription: "no valid trust path to trust anchors found",
-		}
-	}
-	return true, 0, nil
 }
 
 // EntityIDEntityChecker checks that the entity has a
@@ -355,6 +734,7 @@ func (c *EntityIDEntityChecker) UnmarshalYAML(node *yaml.Node) error {
 
 // Check implements the EntityChecker interface
 func (c EntityIDEntityChecker) Check(
+	_ context.Context,
 	entityConfiguration *oidfed.EntityStatement,
 	_ []string,
 ) (bool, int, *oidfed.Error) {
@@ -384,6 +764,7 @@ func (c *AuthorityHintEntityChecker) UnmarshalYAML(node *yaml.Node) error {
 
 // Check implements the EntityChecker interface
 func (c AuthorityHintEntityChecker) Check(
+	_ context.Context,
 	entityConfiguration *oidfed.EntityStatement,
 	_ []string,
 ) (bool, int, *oidfed.Error) {
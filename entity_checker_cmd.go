@@ -34,6 +34,7 @@ type CmdEntityChecker struct {
 
 // Check implements the EntityChecker interface
 func (c *CmdEntityChecker) Check(
+	ctx context.Context,
 	entityConfiguration *oidfed.EntityStatement,
 	entityTypes []string,
 ) (bool, int, *oidfed.Error) {
@@ -46,10 +47,10 @@ func (c *CmdEntityChecker) Check(
 	if timeout <= 0 {
 		timeout = 30
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(timeout)*time.Second)
+	cmdCtx, cancel := context.WithTimeout(ctx, time.Duration(timeout)*time.Second)
 	defer cancel()
 
-	cmd := exec.CommandContext(ctx, c.Path, c.Args...)
+	cmd := exec.CommandContext(cmdCtx, c.Path, c.Args...)
 
 	// Build environment: inherit current env, add entity vars, then user env.
 	cmd.Env = append(
@@ -77,7 +78,10 @@ func (c *CmdEntityChecker) Check(
 	cmd.WaitDelay = time.Duration(timeout) * time.Second
 
 	if err = cmd.Run(); err != nil {
-		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		if ctx.Err() != nil {
+			return entityCheckAborted(ctx)
+		}
+		if errors.Is(cmdCtx.Err(), context.DeadlineExceeded) {
 			log.Warn().Err(err).
 				Str("entity_id", entityConfiguration.Subject).
 				Str("cmd", c.Path).
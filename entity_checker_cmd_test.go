@@ -24,7 +24,7 @@ func testEntityStatement(sub string) *oidfed.EntityStatement {
 
 func TestCmdEntityChecker_AllowOnExitZero(t *testing.T) {
 	c := &CmdEntityChecker{Path: "sh", Args: []string{"-c", "exit 0"}}
-	ok, code, errResp := c.Check(testEntityStatement("https://op.example.org"), []string{"openid_provider"})
+	ok, code, errResp := c.Check(t.Context(), testEntityStatement("https://op.example.org"), []string{"openid_provider"})
 	assert.True(t, ok)
 	assert.Equal(t, 0, code)
 	assert.Nil(t, errResp)
@@ -35,7 +35,7 @@ func TestCmdEntityChecker_DenyOnNonZeroExit(t *testing.T) {
 		Path: "sh",
 		Args: []string{"-c", "echo 'not allowed' >&2; exit 1"},
 	}
-	ok, code, errResp := c.Check(testEntityStatement("https://op.example.org"), []string{"openid_provider"})
+	ok, code, errResp := c.Check(t.Context(), testEntityStatement("https://op.example.org"), []string{"openid_provider"})
 	assert.False(t, ok)
 	assert.Equal(t, 403, code)
 	require.NotNil(t, errResp)
@@ -45,7 +45,7 @@ func TestCmdEntityChecker_DenyOnNonZeroExit(t *testing.T) {
 
 func TestCmdEntityChecker_DenyEmptyStderr(t *testing.T) {
 	c := &CmdEntityChecker{Path: "sh", Args: []string{"-c", "exit 2"}}
-	ok, code, errResp := c.Check(testEntityStatement("https://op.example.org"), nil)
+	ok, code, errResp := c.Check(t.Context(), testEntityStatement("https://op.example.org"), nil)
 	assert.False(t, ok)
 	assert.Equal(t, 403, code)
 	require.NotNil(t, errResp)
@@ -57,7 +57,7 @@ func TestCmdEntityChecker_EntityIDEnvVar(t *testing.T) {
 		Path: "sh",
 		Args: []string{"-c", "test \"$ENTITY_ID\" = \"https://op.example.org\""},
 	}
-	ok, _, _ := c.Check(testEntityStatement("https://op.example.org"), nil)
+	ok, _, _ := c.Check(t.Context(), testEntityStatement("https://op.example.org"), nil)
 	assert.True(t, ok, "command should succeed when ENTITY_ID matches")
 }
 
@@ -67,6 +67,7 @@ func TestCmdEntityChecker_EntityTypesEnvVar(t *testing.T) {
 		Args: []string{"-c", "test \"$ENTITY_TYPES\" = \"openid_provider,trust_anchor\""},
 	}
 	ok, _, _ := c.Check(
+		t.Context(),
 		testEntityStatement("https://op.example.org"),
 		[]string{"openid_provider", "trust_anchor"},
 	)
@@ -79,7 +80,7 @@ func TestCmdEntityChecker_ExtraEnvVar(t *testing.T) {
 		Args: []string{"-c", "test \"$MY_VAR\" = \"hello\""},
 		Env:  []string{"MY_VAR=hello"},
 	}
-	ok, _, _ := c.Check(testEntityStatement("https://op.example.org"), nil)
+	ok, _, _ := c.Check(t.Context(), testEntityStatement("https://op.example.org"), nil)
 	assert.True(t, ok, "command should see extra env var")
 }
 
@@ -90,7 +91,7 @@ func TestCmdEntityChecker_StdinReceivesEntityConfiguration(t *testing.T) {
 		Path: "sh",
 		Args: []string{"-c", "grep -q '\"sub\":\"https://op.example.org\"'"},
 	}
-	ok, _, _ := c.Check(testEntityStatement("https://op.example.org"), nil)
+	ok, _, _ := c.Check(t.Context(), testEntityStatement("https://op.example.org"), nil)
 	assert.True(t, ok, "stdin should contain the entity configuration payload")
 }
 
@@ -104,7 +105,7 @@ func TestCmdEntityChecker_StdinContent(t *testing.T) {
 		Path: "sh",
 		Args: []string{"-c", "cat > " + tmp},
 	}
-	c.Check(es, nil)
+	c.Check(t.Context(), es, nil)
 
 	got, err := os.ReadFile(tmp)
 	require.NoError(t, err)
@@ -117,7 +118,7 @@ func TestCmdEntityChecker_Timeout(t *testing.T) {
 		Args:    []string{"-c", "sleep 10"},
 		Timeout: 1,
 	}
-	ok, code, errResp := c.Check(testEntityStatement("https://op.example.org"), nil)
+	ok, code, errResp := c.Check(t.Context(), testEntityStatement("https://op.example.org"), nil)
 	assert.False(t, ok)
 	assert.Equal(t, 500, code)
 	require.NotNil(t, errResp)
@@ -126,7 +127,7 @@ func TestCmdEntityChecker_Timeout(t *testing.T) {
 
 func TestCmdEntityChecker_MissingPath(t *testing.T) {
 	c := &CmdEntityChecker{}
-	ok, code, errResp := c.Check(testEntityStatement("https://op.example.org"), nil)
+	ok, code, errResp := c.Check(t.Context(), testEntityStatement("https://op.example.org"), nil)
 	assert.False(t, ok)
 	assert.Equal(t, 500, code)
 	require.NotNil(t, errResp)
@@ -135,7 +136,7 @@ func TestCmdEntityChecker_MissingPath(t *testing.T) {
 
 func TestCmdEntityChecker_CommandNotFound(t *testing.T) {
 	c := &CmdEntityChecker{Path: "/nonexistent/command/xyz"}
-	ok, code, errResp := c.Check(testEntityStatement("https://op.example.org"), nil)
+	ok, code, errResp := c.Check(t.Context(), testEntityStatement("https://op.example.org"), nil)
 	assert.False(t, ok)
 	assert.Equal(t, 500, code)
 	require.NotNil(t, errResp)
@@ -1,11 +1,14 @@
 package lighthouse
 
 import (
+	"context"
+
 	"github.com/gofiber/fiber/v2"
 	"gopkg.in/yaml.v3"
 
 	oidfed "github.com/go-oidfed/lib"
 
+	"github.com/go-oidfed/lighthouse/internal/metadataschema"
 	"github.com/go-oidfed/lighthouse/storage/model"
 )
 
@@ -20,8 +23,9 @@ type ContextualEntityChecker interface {
 
 // CheckerContext provides runtime context for contextual entity checkers
 type CheckerContext struct {
-	Store         model.TrustMarkedEntitiesStorageBackend
-	TrustMarkType string
+	Store             model.TrustMarkedEntitiesStorageBackend
+	TrustMarkType     string
+	MetadataValidator *metadataschema.Validator
 }
 
 // DBListEntityChecker checks if subject is in TrustMarkSubject table with active status.
@@ -37,6 +41,7 @@ func (c *DBListEntityChecker) SetContext(ctx CheckerContext) {
 
 // Check implements the EntityChecker interface
 func (c *DBListEntityChecker) Check(
+	_ context.Context,
 	entityConfiguration *oidfed.EntityStatement,
 	_ []string,
 ) (bool, int, *oidfed.Error) {
@@ -2,6 +2,7 @@ package lighthouse
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"io"
 	"net/http"
@@ -38,11 +39,15 @@ type HTTPListEntityChecker struct {
 
 // Check implements the EntityChecker interface
 func (c *HTTPListEntityChecker) Check(
+	ctx context.Context,
 	entityConfiguration *oidfed.EntityStatement,
 	_ []string,
 ) (bool, int, *oidfed.Error) {
-	list, err := c.getList()
+	list, err := c.getList(ctx)
 	if err != nil {
+		if ctx.Err() != nil {
+			return entityCheckAborted(ctx)
+		}
 		return false, fiber.StatusInternalServerError,
 			oidfed.ErrorServerError("failed to fetch entity list: " + err.Error())
 	}
@@ -57,7 +62,7 @@ func (c *HTTPListEntityChecker) Check(
 		}
 }
 
-func (c *HTTPListEntityChecker) getList() ([]string, error) {
+func (c *HTTPListEntityChecker) getList(ctx context.Context) ([]string, error) {
 	ttl := time.Duration(c.CacheTTL) * time.Second
 	if ttl == 0 {
 		ttl = 60 * time.Second
@@ -79,7 +84,7 @@ func (c *HTTPListEntityChecker) getList() ([]string, error) {
 		return c.cache, nil
 	}
 
-	list, err := c.fetchList()
+	list, err := c.fetchList(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -88,7 +93,7 @@ func (c *HTTPListEntityChecker) getList() ([]string, error) {
 	return list, nil
 }
 
-func (c *HTTPListEntityChecker) fetchList() ([]string, error) {
+func (c *HTTPListEntityChecker) fetchList(ctx context.Context) ([]string, error) {
 	method := c.Method
 	if method == "" {
 		method = http.MethodGet
@@ -99,7 +104,7 @@ func (c *HTTPListEntityChecker) fetchList() ([]string, error) {
 	}
 
 	client := &http.Client{Timeout: time.Duration(timeout) * time.Second}
-	req, err := http.NewRequest(method, c.URL, http.NoBody)
+	req, err := http.NewRequestWithContext(ctx, method, c.URL, http.NoBody)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to create HTTP request")
 	}
@@ -187,11 +192,15 @@ type HTTPListJWTEntityChecker struct {
 
 // Check implements the EntityChecker interface
 func (c *HTTPListJWTEntityChecker) Check(
+	ctx context.Context,
 	entityConfiguration *oidfed.EntityStatement,
 	_ []string,
 ) (bool, int, *oidfed.Error) {
-	list, err := c.getList()
+	list, err := c.getList(ctx)
 	if err != nil {
+		if ctx.Err() != nil {
+			return entityCheckAborted(ctx)
+		}
 		return false, fiber.StatusInternalServerError,
 			oidfed.ErrorServerError("failed to fetch/verify entity list: " + err.Error())
 	}
@@ -206,7 +215,7 @@ func (c *HTTPListJWTEntityChecker) Check(
 		}
 }
 
-func (c *HTTPListJWTEntityChecker) getList() ([]string, error) {
+func (c *HTTPListJWTEntityChecker) getList(ctx context.Context) ([]string, error) {
 	ttl := time.Duration(c.CacheTTL) * time.Second
 	if ttl == 0 {
 		ttl = 60 * time.Second
@@ -228,7 +237,7 @@ func (c *HTTPListJWTEntityChecker) getList() ([]string, error) {
 		return c.cache, nil
 	}
 
-	list, err := c.fetchAndVerifyList()
+	list, err := c.fetchAndVerifyList(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -237,9 +246,9 @@ func (c *HTTPListJWTEntityChecker) getList() ([]string, error) {
 	return list, nil
 }
 
-func (c *HTTPListJWTEntityChecker) fetchAndVerifyList() ([]string, error) {
+func (c *HTTPListJWTEntityChecker) fetchAndVerifyList(ctx context.Context) ([]string, error) {
 	// Fetch JWT from URL
-	jwtString, err := c.fetchJWT()
+	jwtString, err := c.fetchJWT(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -274,7 +283,7 @@ func (c *HTTPListJWTEntityChecker) fetchAndVerifyList() ([]string, error) {
 	return toStringSlice(rawList)
 }
 
-func (c *HTTPListJWTEntityChecker) fetchJWT() (string, error) {
+func (c *HTTPListJWTEntityChecker) fetchJWT(ctx context.Context) (string, error) {
 	method := c.Method
 	if method == "" {
 		method = http.MethodGet
@@ -285,7 +294,7 @@ func (c *HTTPListJWTEntityChecker) fetchJWT() (string, error) {
 	}
 
 	client := &http.Client{Timeout: time.Duration(timeout) * time.Second}
-	req, err := http.NewRequest(method, c.URL, http.NoBody)
+	req, err := http.NewRequestWithContext(ctx, method, c.URL, http.NoBody)
 	if err != nil {
 		return "", errors.Wrap(err, "failed to create HTTP request")
 	}
@@ -442,6 +451,7 @@ type HTTPEntityChecker struct {
 
 // Check implements the EntityChecker interface
 func (c *HTTPEntityChecker) Check(
+	ctx context.Context,
 	entityConfiguration *oidfed.EntityStatement,
 	entityTypes []string,
 ) (bool, int, *oidfed.Error) {
@@ -491,7 +501,7 @@ func (c *HTTPEntityChecker) Check(
 	}
 
 	client := &http.Client{Timeout: time.Duration(timeout) * time.Second}
-	req, err := http.NewRequest(method, c.URL, bodyReader)
+	req, err := http.NewRequestWithContext(ctx, method, c.URL, bodyReader)
 	if err != nil {
 		return false, fiber.StatusInternalServerError,
 			oidfed.ErrorServerError("http checker: could not create request: " + err.Error())
@@ -509,6 +519,9 @@ func (c *HTTPEntityChecker) Check(
 
 	resp, err := client.Do(req)
 	if err != nil {
+		if ctx.Err() != nil {
+			return entityCheckAborted(ctx)
+		}
 		log.Warn().Err(err).
 			Str("entity_id", entityConfiguration.Subject).
 			Str("url", c.URL).
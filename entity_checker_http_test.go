@@ -1,11 +1,13 @@
 package lighthouse
 
 import (
+	"context"
 	"encoding/json"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -19,7 +21,7 @@ func TestHTTPEntityChecker_AllowOn2xx(t *testing.T) {
 	defer srv.Close()
 
 	c := &HTTPEntityChecker{URL: srv.URL}
-	ok, code, errResp := c.Check(testEntityStatement("https://op.example.org"), []string{"openid_provider"})
+	ok, code, errResp := c.Check(t.Context(), testEntityStatement("https://op.example.org"), []string{"openid_provider"})
 	assert.True(t, ok)
 	assert.Equal(t, 0, code)
 	assert.Nil(t, errResp)
@@ -33,7 +35,7 @@ func TestHTTPEntityChecker_DenyOn4xx(t *testing.T) {
 	defer srv.Close()
 
 	c := &HTTPEntityChecker{URL: srv.URL}
-	ok, code, errResp := c.Check(testEntityStatement("https://op.example.org"), nil)
+	ok, code, errResp := c.Check(t.Context(), testEntityStatement("https://op.example.org"), nil)
 	assert.False(t, ok)
 	assert.Equal(t, http.StatusForbidden, code)
 	require.NotNil(t, errResp)
@@ -48,7 +50,7 @@ func TestHTTPEntityChecker_Deny4xxPassesThroughStatus(t *testing.T) {
 	defer srv.Close()
 
 	c := &HTTPEntityChecker{URL: srv.URL}
-	ok, code, _ := c.Check(testEntityStatement("https://op.example.org"), nil)
+	ok, code, _ := c.Check(t.Context(), testEntityStatement("https://op.example.org"), nil)
 	assert.False(t, ok)
 	assert.Equal(t, http.StatusNotFound, code)
 }
@@ -60,7 +62,7 @@ func TestHTTPEntityChecker_5xxReturnsBadGateway(t *testing.T) {
 	defer srv.Close()
 
 	c := &HTTPEntityChecker{URL: srv.URL}
-	ok, code, errResp := c.Check(testEntityStatement("https://op.example.org"), nil)
+	ok, code, errResp := c.Check(t.Context(), testEntityStatement("https://op.example.org"), nil)
 	assert.False(t, ok)
 	assert.Equal(t, http.StatusBadGateway, code)
 	require.NotNil(t, errResp)
@@ -72,7 +74,7 @@ func TestHTTPEntityChecker_NetworkErrorReturnsBadGateway(t *testing.T) {
 	srv.Close() // close immediately to force a connection error
 
 	c := &HTTPEntityChecker{URL: srv.URL, Timeout: 5}
-	ok, code, errResp := c.Check(testEntityStatement("https://op.example.org"), nil)
+	ok, code, errResp := c.Check(t.Context(), testEntityStatement("https://op.example.org"), nil)
 	assert.False(t, ok)
 	assert.Equal(t, http.StatusBadGateway, code)
 	require.NotNil(t, errResp)
@@ -87,7 +89,7 @@ func TestHTTPEntityChecker_DefaultMethodIsPOST(t *testing.T) {
 	defer srv.Close()
 
 	c := &HTTPEntityChecker{URL: srv.URL}
-	_, _, _ = c.Check(testEntityStatement("https://op.example.org"), nil)
+	_, _, _ = c.Check(t.Context(), testEntityStatement("https://op.example.org"), nil)
 	assert.Equal(t, http.MethodPost, gotMethod)
 }
 
@@ -100,7 +102,7 @@ func TestHTTPEntityChecker_DefaultBodyModeIsEntityConfiguration(t *testing.T) {
 
 	es := testEntityStatement("https://op.example.org")
 	c := &HTTPEntityChecker{URL: srv.URL}
-	_, _, _ = c.Check(es, nil)
+	_, _, _ = c.Check(t.Context(), es, nil)
 
 	expected, _ := json.Marshal(es.EntityStatementPayload)
 	assert.JSONEq(t, string(expected), string(gotBody))
@@ -114,7 +116,7 @@ func TestHTTPEntityChecker_BodyModeNone(t *testing.T) {
 	defer srv.Close()
 
 	c := &HTTPEntityChecker{URL: srv.URL, BodyMode: "none"}
-	_, _, _ = c.Check(testEntityStatement("https://op.example.org"), nil)
+	_, _, _ = c.Check(t.Context(), testEntityStatement("https://op.example.org"), nil)
 	assert.Empty(t, gotBody)
 }
 
@@ -127,6 +129,7 @@ func TestHTTPEntityChecker_BodyModeEntityID(t *testing.T) {
 
 	c := &HTTPEntityChecker{URL: srv.URL, BodyMode: "entity_id"}
 	_, _, _ = c.Check(
+		t.Context(),
 		testEntityStatement("https://op.example.org"),
 		[]string{"openid_provider"},
 	)
@@ -147,6 +150,7 @@ func TestHTTPEntityChecker_EntityHeadersAlwaysSet(t *testing.T) {
 
 	c := &HTTPEntityChecker{URL: srv.URL, BodyMode: "none"}
 	_, _, _ = c.Check(
+		t.Context(),
 		testEntityStatement("https://op.example.org"),
 		[]string{"openid_provider", "trust_anchor"},
 	)
@@ -165,7 +169,7 @@ func TestHTTPEntityChecker_CustomHeaders(t *testing.T) {
 		URL:     srv.URL,
 		Headers: map[string]string{"Authorization": "Bearer token123"},
 	}
-	_, _, _ = c.Check(testEntityStatement("https://op.example.org"), nil)
+	_, _, _ = c.Check(t.Context(), testEntityStatement("https://op.example.org"), nil)
 	assert.Equal(t, "Bearer token123", gotAuth)
 }
 
@@ -177,7 +181,7 @@ func TestHTTPEntityChecker_CustomMethod(t *testing.T) {
 	defer srv.Close()
 
 	c := &HTTPEntityChecker{URL: srv.URL, Method: "PUT"}
-	_, _, _ = c.Check(testEntityStatement("https://op.example.org"), nil)
+	_, _, _ = c.Check(t.Context(), testEntityStatement("https://op.example.org"), nil)
 	assert.Equal(t, "PUT", gotMethod)
 }
 
@@ -189,13 +193,13 @@ func TestHTTPEntityChecker_ContentTypeJSON(t *testing.T) {
 	defer srv.Close()
 
 	c := &HTTPEntityChecker{URL: srv.URL} // default body_mode = entity_configuration
-	_, _, _ = c.Check(testEntityStatement("https://op.example.org"), nil)
+	_, _, _ = c.Check(t.Context(), testEntityStatement("https://op.example.org"), nil)
 	assert.Equal(t, "application/json", gotCT)
 }
 
 func TestHTTPEntityChecker_MissingURL(t *testing.T) {
 	c := &HTTPEntityChecker{}
-	ok, code, errResp := c.Check(testEntityStatement("https://op.example.org"), nil)
+	ok, code, errResp := c.Check(t.Context(), testEntityStatement("https://op.example.org"), nil)
 	assert.False(t, ok)
 	assert.Equal(t, 500, code)
 	require.NotNil(t, errResp)
@@ -207,7 +211,7 @@ func TestHTTPEntityChecker_InvalidBodyMode(t *testing.T) {
 	defer srv.Close()
 
 	c := &HTTPEntityChecker{URL: srv.URL, BodyMode: "bogus"}
-	ok, code, errResp := c.Check(testEntityStatement("https://op.example.org"), nil)
+	ok, code, errResp := c.Check(t.Context(), testEntityStatement("https://op.example.org"), nil)
 	assert.False(t, ok)
 	assert.Equal(t, 500, code)
 	require.NotNil(t, errResp)
@@ -238,3 +242,21 @@ func TestHTTPEntityChecker_Registered(t *testing.T) {
 	require.True(t, ok, "http checker should be registered")
 	assert.NotNil(t, ctor())
 }
+
+func TestHTTPEntityChecker_ContextDeadline(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		<-release
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	ctx, cancel := context.WithTimeout(t.Context(), 50*time.Millisecond)
+	defer cancel()
+	c := &HTTPEntityChecker{URL: srv.URL}
+	ok, code, errResp := c.Check(ctx, testEntityStatement("https://op.example.org"), nil)
+	assert.False(t, ok)
+	assert.Equal(t, 504, code)
+	require.NotNil(t, errResp)
+}
@@ -0,0 +1,72 @@
+package lighthouse
+
+import (
+	"context"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+
+	oidfed "github.com/go-oidfed/lib"
+
+	"github.com/go-oidfed/lighthouse/internal/metadataschema"
+)
+
+// MetadataSchemaEntityChecker checks that the metadata of an entity matches
+// the JSON Schemas of its entity types. Schemas stored in the database take
+// precedence over the built-in default schemas; entity types without a schema
+// are not checked.
+// This checker requires SetContext to be called before Check.
+type MetadataSchemaEntityChecker struct {
+	// EntityTypes limits the check to these entity types. If empty, all
+	// entity types contained in the metadata are checked.
+	EntityTypes []string `yaml:"entity_types" json:"entity_types"`
+	validator   *metadataschema.Validator
+}
+
+// SetContext implements the ContextualEntityChecker interface
+func (c *MetadataSchemaEntityChecker) SetContext(ctx CheckerContext) {
+	c.validator = ctx.MetadataValidator
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler and EntityChecker interface
+func (c *MetadataSchemaEntityChecker) UnmarshalYAML(node *yaml.Node) error {
+	type Alias MetadataSchemaEntityChecker
+	alias := Alias(*c)
+	err := node.Decode(&alias)
+	if err != nil {
+		return err
+	}
+	*c = MetadataSchemaEntityChecker(alias)
+	return nil
+}
+
+// Check implements the EntityChecker interface
+func (c *MetadataSchemaEntityChecker) Check(
+	_ context.Context,
+	entityConfiguration *oidfed.EntityStatement,
+	_ []string,
+) (bool, int, *oidfed.Error) {
+	if c.validator == nil {
+		return false, fiber.StatusInternalServerError,
+			oidfed.ErrorServerError("metadata_schema checker not initialized with context")
+	}
+	if entityConfiguration.Metadata == nil {
+		return false, fiber.StatusBadRequest, oidfed.ErrorInvalidMetadata("entity configuration contains no metadata")
+	}
+	err := c.validator.Validate(entityConfiguration.Metadata, c.EntityTypes...)
+	if err == nil {
+		return true, 0, nil
+	}
+	var verr *metadataschema.ValidationError
+	if errors.As(err, &verr) {
+		return false, fiber.StatusBadRequest, oidfed.ErrorInvalidMetadata(verr.Error())
+	}
+	return false, fiber.StatusInternalServerError, oidfed.ErrorServerError(err.Error())
+}
+
+func init() {
+	RegisterEntityChecker(
+		"metadata_schema", func() EntityChecker { return &MetadataSchemaEntityChecker{} },
+	)
+}
@@ -0,0 +1,79 @@
+package lighthouse
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	oidfed "github.com/go-oidfed/lib"
+
+	"github.com/go-oidfed/lighthouse/internal/metadataschema"
+)
+
+func TestMetadataSchemaEntityChecker(t *testing.T) {
+	es := testEntityStatement("https://op.example.org")
+	checker := &MetadataSchemaEntityChecker{}
+
+	// The checker needs a validator from its context
+	ok, code, errResp := checker.Check(t.Context(), es, nil)
+	assert.False(t, ok)
+	assert.Equal(t, 500, code)
+	require.NotNil(t, errResp)
+
+	checker.SetContext(CheckerContext{MetadataValidator: metadataschema.NewValidator(nil)})
+	ok, code, errResp = checker.Check(t.Context(), es, nil)
+	assert.False(t, ok)
+	assert.Equal(t, 400, code)
+	require.NotNil(t, errResp)
+	assert.Equal(t, "invalid_metadata", errResp.Error)
+
+	es.Metadata = &oidfed.Metadata{
+		OpenIDProvider: &oidfed.OpenIDProviderMetadata{
+			Issuer:                 "https://op.example.org",
+			ResponseTypesSupported: []string{"code"},
+		},
+	}
+	ok, code, errResp = checker.Check(t.Context(), es, nil)
+	assert.True(t, ok)
+	assert.Equal(t, 0, code)
+	assert.Nil(t, errResp)
+
+	es.Metadata.OpenIDProvider.Issuer = "not a uri"
+	ok, code, errResp = checker.Check(t.Context(), es, nil)
+	assert.False(t, ok)
+	assert.Equal(t, 400, code)
+	require.NotNil(t, errResp)
+	assert.Contains(t, errResp.ErrorDescription, "/issuer")
+
+	// Entity types not listed are not checked
+	checker.EntityTypes = []string{"federation_entity"}
+	ok, _, _ = checker.Check(t.Context(), es, nil)
+	assert.True(t, ok)
+}
+
+func TestMetadataSchemaEntityChecker_FromYAML(t *testing.T) {
+	checker, err := EntityCheckerFromYAMLConfig(
+		[]byte(`
+type: metadata_schema
+config:
+  entity_types:
+    - openid_provider
+`),
+	)
+	require.NoError(t, err)
+	c, ok := checker.(*MetadataSchemaEntityChecker)
+	require.True(t, ok)
+	assert.Equal(t, []string{"openid_provider"}, c.EntityTypes)
+}
+
+func TestEnrollCheckerFromDBConfig_MetadataSchema(t *testing.T) {
+	validator := metadataschema.NewValidator(nil)
+	checker, err := enrollCheckerFromDBConfig(
+		`{"checker_type":"metadata_schema","checker_config":{"entity_types":["openid_provider"]}}`, validator,
+	)
+	require.NoError(t, err)
+	c, ok := checker.(*MetadataSchemaEntityChecker)
+	require.True(t, ok)
+	assert.Same(t, validator, c.validator)
+}
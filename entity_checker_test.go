@@ -0,0 +1,348 @@
+package lighthouse
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+
+	oidfed "github.com/go-oidfed/lib"
+)
+
+// contextRecordingChecker is a ContextualEntityChecker that records the
+// context it was given and passes if a trust mark type was set
+type contextRecordingChecker struct {
+	ctx *CheckerContext
+}
+
+func (c *contextRecordingChecker) SetContext(ctx CheckerContext) {
+	c.ctx = &ctx
+}
+
+func (c *contextRecordingChecker) Check(
+	_ context.Context, _ *oidfed.EntityStatement, _ []string,
+) (bool, int, *oidfed.Error) {
+	if c.ctx == nil || c.ctx.TrustMarkType == "" {
+		return false, 500, oidfed.ErrorServerError("no context")
+	}
+	return true, 0, nil
+}
+
+func (*contextRecordingChecker) UnmarshalYAML(_ *yaml.Node) error {
+	return nil
+}
+
+// blockingChecker is an EntityChecker that only returns once its context is
+// done
+type blockingChecker struct{}
+
+func (blockingChecker) Check(ctx context.Context, _ *oidfed.EntityStatement, _ []string) (bool, int, *oidfed.Error) {
+	<-ctx.Done()
+	return false, 403, &oidfed.Error{
+		Error:            "forbidden",
+		ErrorDescription: ctx.Err().Error(),
+	}
+}
+
+func (blockingChecker) UnmarshalYAML(_ *yaml.Node) error {
+	return nil
+}
+
+func allowIDs(ids ...string) EntityChecker {
+	return &EntityIDEntityChecker{AllowedIDs: ids}
+}
+
+func TestNotEntityChecker(t *testing.T) {
+	es := testEntityStatement("https://op.example.org")
+
+	ok, code, errResp := NewNotEntityChecker(allowIDs("https://other.example.org")).Check(t.Context(), es, nil)
+	assert.True(t, ok)
+	assert.Equal(t, 0, code)
+	assert.Nil(t, errResp)
+
+	ok, code, errResp = NewNotEntityChecker(allowIDs("https://op.example.org")).Check(t.Context(), es, nil)
+	assert.False(t, ok)
+	assert.Equal(t, 403, code)
+	require.NotNil(t, errResp)
+	assert.Equal(t, "forbidden", errResp.Error)
+}
+
+func TestNotEntityChecker_ServerErrorNotNegated(t *testing.T) {
+	ok, code, errResp := NewNotEntityChecker(&contextRecordingChecker{}).Check(
+		t.Context(),
+		testEntityStatement("https://op.example.org"), nil,
+	)
+	assert.False(t, ok)
+	assert.Equal(t, 500, code)
+	require.NotNil(t, errResp)
+	assert.Equal(t, "no context", errResp.ErrorDescription)
+}
+
+func newTestThresholdChecker(t *testing.T, minPassed int, checkers ...EntityChecker) *ThresholdEntityChecker {
+	t.Helper()
+	c, err := NewThresholdEntityChecker(minPassed, checkers...)
+	require.NoError(t, err)
+	return c
+}
+
+func TestThresholdEntityChecker(t *testing.T) {
+	es := testEntityStatement("https://op.example.org")
+	pass := allowIDs("https://op.example.org")
+	fail := allowIDs("https://other.example.org")
+
+	tests := []struct {
+		name     string
+		min      int
+		checkers []EntityChecker
+		expected bool
+	}{
+		{"2 of 4 passing", 2, []EntityChecker{fail, pass, fail, pass}, true},
+		{"1 of 4 passing", 2, []EntityChecker{fail, pass, fail, fail}, false},
+		{"all required", 3, []EntityChecker{pass, pass, pass}, true},
+		{"all required one failing", 3, []EntityChecker{pass, fail, pass}, false},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				ok, code, errResp := newTestThresholdChecker(t, tt.min, tt.checkers...).Check(t.Context(), es, nil)
+				assert.Equal(t, tt.expected, ok)
+				if tt.expected {
+					assert.Equal(t, 0, code)
+					assert.Nil(t, errResp)
+				} else {
+					assert.Equal(t, 403, code)
+					require.NotNil(t, errResp)
+				}
+			},
+		)
+	}
+}
+
+func TestNewThresholdEntityChecker_InvalidMin(t *testing.T) {
+	pass := allowIDs("https://op.example.org")
+	for _, minPassed := range []int{-1, 0, 3} {
+		_, err := NewThresholdEntityChecker(minPassed, pass, pass)
+		assert.Error(t, err, "min %d", minPassed)
+	}
+
+	// A checker built without the constructor does not allow everything
+	ok, code, _ := ThresholdEntityChecker{Checkers: []EntityChecker{pass}}.Check(
+		t.Context(), testEntityStatement("https://op.example.org"), nil,
+	)
+	assert.False(t, ok)
+	assert.Equal(t, 500, code)
+}
+
+func TestThresholdEntityChecker_ServerError(t *testing.T) {
+	es := testEntityStatement("https://op.example.org")
+	pass := allowIDs("https://op.example.org")
+	fail := allowIDs("https://other.example.org")
+
+	// No checker denied the entity, so the server error is passed through
+	ok, code, errResp := newTestThresholdChecker(t, 2, pass, &contextRecordingChecker{}).Check(t.Context(), es, nil)
+	assert.False(t, ok)
+	assert.Equal(t, 500, code)
+	require.NotNil(t, errResp)
+	assert.Equal(t, "no context", errResp.ErrorDescription)
+
+	// A denial takes precedence over server errors
+	ok, code, _ = newTestThresholdChecker(t, 2, fail, &contextRecordingChecker{}, pass).Check(t.Context(), es, nil)
+	assert.False(t, ok)
+	assert.Equal(t, 403, code)
+}
+
+func TestThresholdEntityChecker_ErrorDescription(t *testing.T) {
+	c := newTestThresholdChecker(
+		t, 2, allowIDs("https://op.example.org"), &AuthorityHintEntityChecker{EntityID: "https://ia.example.org"},
+	)
+	ok, _, errResp := c.Check(t.Context(), testEntityStatement("https://op.example.org"), nil)
+	assert.False(t, ok)
+	require.NotNil(t, errResp)
+	assert.Equal(
+		t,
+		"at least 2 of 2 checks must pass, but only 1 passed: must include 'https://ia.example.org' in authority_hints",
+		errResp.ErrorDescription,
+	)
+}
+
+func TestThresholdEntityChecker_UnmarshalYAML(t *testing.T) {
+	checker, err := EntityCheckerFromYAMLConfig(
+		[]byte(`
+type: threshold
+config:
+  min: 2
+  checkers:
+    - type: entity_id
+      config:
+        entity_ids: [https://op.example.org]
+    - type: none
+    - type: not
+      config:
+        type: none
+`),
+	)
+	require.NoError(t, err)
+	c, ok := checker.(*ThresholdEntityChecker)
+	require.True(t, ok)
+	assert.Equal(t, 2, c.Min)
+	require.Len(t, c.Checkers, 3)
+	assert.IsType(t, &NotEntityChecker{}, c.Checkers[2])
+}
+
+func TestThresholdEntityChecker_UnmarshalYAMLInvalidMin(t *testing.T) {
+	for _, config := range []string{
+		"type: threshold\nconfig:\n  min: 0\n  checkers:\n    - type: none\n",
+		"type: threshold\nconfig:\n  min: 2\n  checkers:\n    - type: none\n",
+		"type: threshold\nconfig:\n  min: 1\n",
+	} {
+		_, err := EntityCheckerFromYAMLConfig([]byte(config))
+		assert.Error(t, err, config)
+	}
+}
+
+func TestCompositeEntityCheckers_FromJSONConfig(t *testing.T) {
+	checker, err := EntityCheckerFromJSONConfig(
+		"not", map[string]any{
+			"type": "threshold",
+			"config": map[string]any{
+				"min": 1,
+				"checkers": []any{
+					map[string]any{
+						"type":   "entity_id",
+						"config": map[string]any{"entity_ids": []any{"https://blocked.example.org"}},
+					},
+				},
+			},
+		},
+	)
+	require.NoError(t, err)
+
+	ok, _, _ := checker.Check(t.Context(), testEntityStatement("https://op.example.org"), nil)
+	assert.True(t, ok)
+	ok, _, _ = checker.Check(t.Context(), testEntityStatement("https://blocked.example.org"), nil)
+	assert.False(t, ok)
+}
+
+func TestCompositeEntityCheckers_SetContext(t *testing.T) {
+	inner := []*contextRecordingChecker{{}, {}, {}, {}}
+	checker := NewMultipleEntityCheckerAnd(
+		NewNotEntityChecker(newTestThresholdChecker(t, 1, inner[0])),
+		NewMultipleEntityCheckerOr(inner[1]),
+		newTestThresholdChecker(t, 1, inner[2], inner[3]),
+	)
+	var contextual ContextualEntityChecker = checker
+	contextual.SetContext(CheckerContext{TrustMarkType: "https://tm.example.org"})
+
+	for _, c := range inner {
+		require.NotNil(t, c.ctx)
+		assert.Equal(t, "https://tm.example.org", c.ctx.TrustMarkType)
+	}
+}
+
+func TestRunEntityChecker_Timeout(t *testing.T) {
+	es := testEntityStatement("https://op.example.org")
+	for _, checker := range []EntityChecker{
+		blockingChecker{},
+		NewNotEntityChecker(blockingChecker{}),
+		NewMultipleEntityCheckerOr(allowIDs("https://other.example.org"), blockingChecker{}),
+		newTestThresholdChecker(t, 1, blockingChecker{}),
+	} {
+		start := time.Now()
+		ok, code, errResp := RunEntityChecker(t.Context(), 50*time.Millisecond, checker, es, nil)
+		assert.Less(t, time.Since(start), 5*time.Second)
+		assert.False(t, ok)
+		assert.Equal(t, 504, code)
+		require.NotNil(t, errResp)
+		assert.Equal(t, "server_error", errResp.Error)
+	}
+}
+
+func TestRunEntityChecker_Cancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+	ok, code, _ := RunEntityChecker(ctx, time.Minute, blockingChecker{}, testEntityStatement("https://op.example.org"), nil)
+	assert.False(t, ok)
+	assert.Equal(t, 503, code)
+}
+
+func TestCompositeEntityCheckers_ConcurrentShortCircuit(t *testing.T) {
+	es := testEntityStatement("https://op.example.org")
+	pass := allowIDs("https://op.example.org")
+	fail := allowIDs("https://other.example.org")
+
+	// Each checker contains a check that never completes on its own, so the
+	// checks only return because the outcome is decided by the other checks
+	tests := []struct {
+		name     string
+		checker  EntityChecker
+		expected bool
+	}{
+		{
+			name:     "or",
+			checker:  &MultipleEntityCheckerOr{Checkers: []EntityChecker{blockingChecker{}, pass}, Concurrent: true},
+			expected: true,
+		},
+		{
+			name:    "and",
+			checker: &MultipleEntityCheckerAnd{Checkers: []EntityChecker{blockingChecker{}, fail}, Concurrent: true},
+		},
+		{
+			name: "threshold passing",
+			checker: &ThresholdEntityChecker{
+				Min: 2, Checkers: []EntityChecker{pass, blockingChecker{}, pass}, Concurrent: true,
+			},
+			expected: true,
+		},
+		{
+			name: "threshold unreachable",
+			checker: &ThresholdEntityChecker{
+				Min: 2, Checkers: []EntityChecker{fail, blockingChecker{}, fail}, Concurrent: true,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				ok, code, errResp := RunEntityChecker(t.Context(), time.Minute, tt.checker, es, nil)
+				assert.Equal(t, tt.expected, ok)
+				if !tt.expected {
+					assert.Less(t, code, 500)
+					require.NotNil(t, errResp)
+				}
+			},
+		)
+	}
+}
+
+func TestMultipleEntityCheckers_UnmarshalYAMLConcurrent(t *testing.T) {
+	for _, typ := range []string{"multiple_and", "multiple_or"} {
+		checker, err := EntityCheckerFromYAMLConfig(
+			[]byte(`
+type: ` + typ + `
+config:
+  concurrent: true
+  checkers:
+    - type: none
+    - type: entity_id
+      config:
+        entity_ids: [https://op.example.org]
+`),
+		)
+		require.NoError(t, err)
+		switch c := checker.(type) {
+		case *MultipleEntityCheckerAnd:
+			assert.True(t, c.Concurrent)
+			assert.Len(t, c.Checkers, 2)
+		case *MultipleEntityCheckerOr:
+			assert.True(t, c.Concurrent)
+			assert.Len(t, c.Checkers, 2)
+		default:
+			t.Fatalf("unexpected checker type %T", checker)
+		}
+		ok, _, _ := checker.Check(t.Context(), testEntityStatement("https://op.example.org"), nil)
+		assert.True(t, ok)
+	}
+}
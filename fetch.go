@@ -1,6 +1,8 @@
 package lighthouse
 
 import (
+	"time"
+
 	oidfed "github.com/go-oidfed/lib"
 	"github.com/go-oidfed/lib/cache"
 	"github.com/go-oidfed/lib/jwx"
@@ -54,10 +56,20 @@ func (fed *LightHouse) AddFetchEndpoint(endpoint EndpointConf, store model.Subor
 			ctx.Status(fiber.StatusInternalServerError)
 			return ctx.JSON(oidfed.ErrorServerError(err.Error()))
 		}
-		if info == nil {
+		if info == nil || info.Status != model.StatusActive {
 			ctx.Status(fiber.StatusNotFound)
 			return ctx.JSON(oidfed.ErrorNotFound("the requested entity identifier is not found"))
 		}
+		if jwt, exp, ok := fed.presignedStatement(req.Subject); ok {
+			if ttl := min(MaximumSubordinateStatementCachePeriod, time.Until(exp.Add(-time.Minute))); ttl > 0 {
+				if cacheErr := cache.Set(cacheKey, jwt, ttl); cacheErr != nil {
+					log.Error().Err(cacheErr).Str("subordinate", req.Subject).
+						Msg("failed to cache subordinate statement")
+				}
+			}
+			ctx.Set(fiber.HeaderContentType, oidfedconst.ContentTypeEntityStatement)
+			return ctx.Send(jwt)
+		}
 		payload := fed.CreateSubordinateStatement(info)
 		jwt, err := fed.SignEntityStatement(payload)
 		if err != nil {
@@ -1,56 +1,69 @@
 package lighthouse
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"time"
+
 	"github.com/go-oidfed/lib"
+	"github.com/go-oidfed/lib/cache"
 	"github.com/go-oidfed/lib/jwx"
+	"github.com/go-oidfed/lib/jwx/keymanagement/public"
 	"github.com/go-oidfed/lib/oidfedconst"
 	"github.com/go-oidfed/lib/unixtime"
 	"github.com/gofiber/fiber/v2"
+	"github.com/lestrrat-go/jwx/v4/jwk"
 	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
 
+	"github.com/go-oidfed/lighthouse/internal"
 	"github.com/go-oidfed/lighthouse/middleware"
 	"github.com/go-oidfed/lighthouse/storage/model"
 )
 
+// historicalKeysCachePeriod is the maximum time the signed unfiltered
+// historical keys response is cached. Changes to the historical keys are
+// picked up right away, since the cache key depends on their state.
+const historicalKeysCachePeriod = time.Hour
+
+// revocationReasonUnspecified is published as revocation reason for revoked
+// keys that were stored without a reason.
+const revocationReasonUnspecified = "unspecified"
+
+// HistoricalKeysRequest holds the optional filter parameters of the
+// historical keys endpoint.
+type HistoricalKeysRequest struct {
+	// KID only returns the key with this kid.
+	KID string `json:"kid" query:"kid" form:"kid"`
+	// Since only returns keys that were revoked or expired at or after this
+	// unix timestamp.
+	Since int64 `json:"since" query:"since" form:"since"`
+	// Revoked only returns revoked keys.
+	Revoked bool `json:"revoked" query:"revoked" form:"revoked"`
+}
+
 // AddHistoricalKeysEndpoint adds the federation historical keys endpoint
 func (fed *LightHouse) AddHistoricalKeysEndpoint(endpoint EndpointConf) error {
 	fed.fedMetadata.FederationHistoricalLKeysEndpoint = endpoint.ValidateURL(fed.FederationEntity.EntityID())
 	if endpoint.Path == "" {
 		return nil
 	}
-	signer := fed.GeneralJWTSigner.Typed(oidfedconst.JWTTypeJWKS)
 	handler := func(ctx *fiber.Ctx) error {
-		kmsHistory, err := fed.keyManagement.KMSManagedPKs.GetHistorical()
-		if err != nil {
-			ctx.Status(fiber.StatusInternalServerError)
-			return ctx.JSON(oidfed.ErrorServerError(err.Error()))
+		var req HistoricalKeysRequest
+		if err := parseRequest(ctx, &req); err != nil {
+			ctx.Status(fiber.StatusBadRequest)
+			return ctx.JSON(oidfed.ErrorInvalidRequest("could not parse request parameters: " + err.Error()))
 		}
-		apiHistory, err := fed.keyManagement.APIManagedPKs.GetHistorical()
+		jwt, found, err := fed.historicalKeysJWT(req)
 		if err != nil {
 			ctx.Status(fiber.StatusInternalServerError)
 			return ctx.JSON(oidfed.ErrorServerError(err.Error()))
 		}
-		allEntries := append(kmsHistory, apiHistory...)
-		keys := jwx.NewJWKS()
-		for _, k := range allEntries {
-			kk, err := k.JWK()
-			if err != nil {
-				ctx.Status(fiber.StatusInternalServerError)
-				return ctx.JSON(oidfed.ErrorServerError(err.Error()))
-			}
-			_ = keys.AddKey(kk)
-		}
-
-		jwt, err := signer.JWT(
-			map[string]any{
-				"iss":  fed.FederationEntity.EntityID(),
-				"iat":  unixtime.Now(),
-				"keys": keys,
-			},
-		)
-		if err != nil {
-			ctx.Status(fiber.StatusInternalServerError)
-			return ctx.JSON(oidfed.ErrorServerError(err.Error()))
+		if !found {
+			ctx.Status(fiber.StatusNotFound)
+			return ctx.JSON(oidfed.ErrorNotFound("key not found"))
 		}
 		ctx.Set(fiber.HeaderContentType, oidfedconst.ContentTypeJWKS)
 		return ctx.Send(jwt)
@@ -77,3 +90,167 @@ func (fed *LightHouse) AddHistoricalKeysEndpoint(endpoint EndpointConf) error {
 
 	return nil
 }
+
+// historicalKeys returns the historical keys of the federation key set, the
+// API-managed keys and all key purposes.
+func (fed *LightHouse) historicalKeys() (public.PublicKeyEntryList, error) {
+	kmsHistory, err := fed.keyManagement.KMSManagedPKs.GetHistorical()
+	if err != nil {
+		return nil, err
+	}
+	apiHistory, err := fed.keyManagement.APIManagedPKs.GetHistorical()
+	if err != nil {
+		return nil, err
+	}
+	allEntries := append(kmsHistory, apiHistory...)
+	for _, purposeKeys := range fed.keyManagement.Purposes {
+		purposeHistory, err := purposeKeys.KMSManagedPKs.GetHistorical()
+		if err != nil {
+			return nil, err
+		}
+		allEntries = append(allEntries, purposeHistory...)
+	}
+	return allEntries, nil
+}
+
+// historicalKeysJWT returns the signed historical keys response for the
+// passed request. The unfiltered response is cached until the state of the
+// historical keys changes; filtered responses are signed for each request,
+// so arbitrary filter values cannot fill the cache. found is false if a kid
+// was requested that is not a historical key.
+func (fed *LightHouse) historicalKeysJWT(req HistoricalKeysRequest) (jwt []byte, found bool, err error) {
+	entries, err := fed.historicalKeys()
+	if err != nil {
+		return nil, false, err
+	}
+	entries = filterHistoricalKeys(entries, req)
+	if req.KID != "" && len(entries) == 0 {
+		return nil, false, nil
+	}
+
+	if req != (HistoricalKeysRequest{}) {
+		jwt, err = fed.signHistoricalKeys(entries)
+		return jwt, err == nil, err
+	}
+
+	cacheKey := historicalKeysCacheKey(entries)
+	var cached []byte
+	set, err := cache.Get(cacheKey, &cached)
+	if err != nil {
+		return nil, false, err
+	}
+	if set {
+		return cached, true, nil
+	}
+	jwt, err = fed.signHistoricalKeys(entries)
+	if err != nil {
+		return nil, false, err
+	}
+	if cacheErr := cache.Set(cacheKey, jwt, historicalKeysCachePeriod); cacheErr != nil {
+		log.Error().Err(cacheErr).Msg("failed to cache historical keys")
+	}
+	return jwt, true, nil
+}
+
+// signHistoricalKeys returns the signed historical keys response containing
+// the passed entries.
+func (fed *LightHouse) signHistoricalKeys(entries public.PublicKeyEntryList) ([]byte, error) {
+	keys := make([]jwk.Key, len(entries))
+	for i, e := range entries {
+		var err error
+		keys[i], err = historicalKeyJWK(e)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return fed.GeneralJWTSigner.Typed(oidfedconst.JWTTypeJWKS).JWT(
+		map[string]any{
+			"iss":  fed.FederationEntity.EntityID(),
+			"iat":  unixtime.Now(),
+			"keys": keys,
+		},
+	)
+}
+
+// filterHistoricalKeys returns the entries matching the filters of the
+// passed request.
+func filterHistoricalKeys(entries public.PublicKeyEntryList, req HistoricalKeysRequest) public.PublicKeyEntryList {
+	filtered := make(public.PublicKeyEntryList, 0, len(entries))
+	for _, e := range entries {
+		revoked := isRevoked(e)
+		if req.KID != "" && e.KID != req.KID {
+			continue
+		}
+		if req.Revoked && !revoked {
+			continue
+		}
+		if req.Since != 0 {
+			var historicalSince *unixtime.Unixtime
+			if revoked {
+				historicalSince = e.RevokedAt
+			} else {
+				historicalSince = e.ExpiresAt
+			}
+			if historicalSince == nil || historicalSince.Unix() < req.Since {
+				continue
+			}
+		}
+		filtered = append(filtered, e)
+	}
+	return filtered
+}
+
+func isRevoked(e public.PublicKeyEntry) bool {
+	return e.RevokedAt != nil && !e.RevokedAt.IsZero() && e.RevokedAt.Unix() != 0
+}
+
+// historicalKeyJWK returns the JWK of a historical key. Revoked keys always
+// carry a revoked object with revoked_at and reason.
+func historicalKeyJWK(e public.PublicKeyEntry) (jwk.Key, error) {
+	k, err := e.JWK()
+	if err != nil {
+		return nil, err
+	}
+	if !isRevoked(e) {
+		return k, nil
+	}
+	reason := e.Reason
+	if reason == "" {
+		reason = revocationReasonUnspecified
+	}
+	revoked := struct {
+		RevokedAt unixtime.Unixtime `json:"revoked_at"`
+		Reason    string            `json:"reason"`
+	}{
+		RevokedAt: *e.RevokedAt,
+		Reason:    reason,
+	}
+	if err = k.Set("revoked", revoked); err != nil {
+		return nil, errors.Wrap(err, "failed to set revoked")
+	}
+	return k, nil
+}
+
+// historicalKeysCacheKey derives the cache key of the unfiltered historical
+// keys response from the state of the returned keys, so that any key state
+// change (expiration, revocation, new historical keys) invalidates it.
+func historicalKeysCacheKey(entries public.PublicKeyEntryList) string {
+	states := make([]string, len(entries))
+	for i, e := range entries {
+		var exp, revokedAt int64
+		if e.ExpiresAt != nil {
+			exp = e.ExpiresAt.Unix()
+		}
+		if e.RevokedAt != nil {
+			revokedAt = e.RevokedAt.Unix()
+		}
+		states[i] = fmt.Sprintf("%s|%d|%d|%s", e.KID, exp, revokedAt, e.Reason)
+	}
+	sort.Strings(states)
+	h := sha256.New()
+	for _, s := range states {
+		_, _ = h.Write([]byte(s))
+		_, _ = h.Write([]byte{0})
+	}
+	return cache.Key(internal.CacheKeyHistoricalKeys, hex.EncodeToString(h.Sum(nil)[:16]))
+}
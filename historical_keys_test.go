@@ -0,0 +1,127 @@
+package lighthouse
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/go-oidfed/lib/jwx"
+	"github.com/go-oidfed/lib/jwx/keymanagement/public"
+	"github.com/go-oidfed/lib/unixtime"
+	"github.com/lestrrat-go/jwx/v4/jws"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/go-oidfed/lighthouse/storage/model"
+)
+
+func historicalKeysByKID(t *testing.T, signed []byte) map[string]map[string]any {
+	t.Helper()
+	msg, err := jws.Parse(signed)
+	require.NoError(t, err)
+	var payload struct {
+		Keys []map[string]any `json:"keys"`
+	}
+	require.NoError(t, json.Unmarshal(msg.Payload(), &payload))
+	keys := make(map[string]map[string]any, len(payload.Keys))
+	for _, k := range payload.Keys {
+		keys[k["kid"].(string)] = k
+	}
+	return keys
+}
+
+func addHistoricalPK(t *testing.T, pks public.PublicKeyStorage, kid string, meta public.UpdateablePublicKeyMetadata) {
+	t.Helper()
+	require.NoError(
+		t, pks.Add(
+			public.PublicKeyEntry{
+				KID:                         kid,
+				Key:                         public.JWKKey{Key: ecPublicJWK(t, kid)},
+				UpdateablePublicKeyMetadata: meta,
+			},
+		),
+	)
+}
+
+func TestHistoricalKeysJWT(t *testing.T) {
+	c := purposeTestSigningConf(t)
+	keyManagement, err := initKey("https://ta.example.com", c, model.Backends{})
+	require.NoError(t, err)
+	versatileSigner, err := createVersatileSigner(keyManagement, nil)
+	require.NoError(t, err)
+	fed := &LightHouse{
+		FederationEntity: stubFedEntity{},
+		GeneralJWTSigner: jwx.NewGeneralJWTSigner(versatileSigner, keyManagement.BasicKeys.GetAlgs()),
+		keyManagement:    keyManagement,
+	}
+
+	now := time.Now()
+	addHistoricalPK(
+		t, keyManagement.APIManagedPKs, "expired", public.UpdateablePublicKeyMetadata{
+			ExpiresAt: &unixtime.Unixtime{Time: now.Add(-10 * 24 * time.Hour)},
+		},
+	)
+	addHistoricalPK(
+		t, keyManagement.APIManagedPKs, "revoked", public.UpdateablePublicKeyMetadata{
+			RevokedAt: &unixtime.Unixtime{Time: now.Add(-time.Hour)},
+		},
+	)
+	addHistoricalPK(
+		t, keyManagement.Purposes[model.SigningPurposeTrustMarks].KMSManagedPKs, "compromised",
+		public.UpdateablePublicKeyMetadata{
+			RevokedAt: &unixtime.Unixtime{Time: now.Add(-24 * time.Hour)},
+			Reason:    KeyCompromiseReason,
+		},
+	)
+	addHistoricalPK(
+		t, keyManagement.APIManagedPKs, "active", public.UpdateablePublicKeyMetadata{
+			ExpiresAt: &unixtime.Unixtime{Time: now.Add(24 * time.Hour)},
+		},
+	)
+
+	jwt, found, err := fed.historicalKeysJWT(HistoricalKeysRequest{})
+	require.NoError(t, err)
+	require.True(t, found)
+	keys := historicalKeysByKID(t, jwt)
+	require.Len(t, keys, 3)
+	assert.NotContains(t, keys, "active")
+	assert.NotContains(t, keys["expired"], "revoked")
+	assert.Equal(t, revocationReasonUnspecified, keys["revoked"]["revoked"].(map[string]any)["reason"])
+	assert.Equal(t, KeyCompromiseReason, keys["compromised"]["revoked"].(map[string]any)["reason"])
+	assert.NotNil(t, keys["compromised"]["revoked"].(map[string]any)["revoked_at"])
+
+	// Responses are cached
+	cached, _, err := fed.historicalKeysJWT(HistoricalKeysRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, jwt, cached)
+
+	jwt, _, err = fed.historicalKeysJWT(HistoricalKeysRequest{Revoked: true})
+	require.NoError(t, err)
+	keys = historicalKeysByKID(t, jwt)
+	assert.Len(t, keys, 2)
+	assert.NotContains(t, keys, "expired")
+
+	jwt, _, err = fed.historicalKeysJWT(HistoricalKeysRequest{Since: now.Add(-2 * 24 * time.Hour).Unix()})
+	require.NoError(t, err)
+	keys = historicalKeysByKID(t, jwt)
+	assert.Len(t, keys, 2)
+	assert.NotContains(t, keys, "expired")
+
+	jwt, found, err = fed.historicalKeysJWT(HistoricalKeysRequest{KID: "expired"})
+	require.NoError(t, err)
+	require.True(t, found)
+	assert.Len(t, historicalKeysByKID(t, jwt), 1)
+
+	_, found, err = fed.historicalKeysJWT(HistoricalKeysRequest{KID: "active"})
+	require.NoError(t, err)
+	assert.False(t, found)
+
+	// A key state change invalidates the cached response
+	require.NoError(t, keyManagement.APIManagedPKs.Revoke("active", "superseded"))
+	jwt, _, err = fed.historicalKeysJWT(HistoricalKeysRequest{})
+	require.NoError(t, err)
+	assert.NotEqual(t, cached, jwt)
+	keys = historicalKeysByKID(t, jwt)
+	require.Contains(t, keys, "active")
+	assert.Equal(t, "superseded", keys["active"]["revoked"].(map[string]any)["reason"])
+}
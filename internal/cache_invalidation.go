@@ -0,0 +1,73 @@
+package internal
+
+import (
+	"encoding/base64"
+	"strings"
+	"sync"
+
+	"github.com/go-oidfed/lib/cache"
+)
+
+// CacheInvalidationListener is called after a cached value was invalidated.
+// If prefix is set, all values with keys starting with key were invalidated.
+type CacheInvalidationListener func(key string, prefix bool)
+
+var cacheInvalidationListeners = struct {
+	sync.RWMutex
+	next      int
+	listeners map[int]CacheInvalidationListener
+}{listeners: make(map[int]CacheInvalidationListener)}
+
+// OnCacheInvalidation registers a listener that is called whenever a cached
+// federation response is invalidated through DeleteCache or ClearCache. The
+// returned function removes the listener again.
+func OnCacheInvalidation(listener CacheInvalidationListener) (remove func()) {
+	cacheInvalidationListeners.Lock()
+	defer cacheInvalidationListeners.Unlock()
+	id := cacheInvalidationListeners.next
+	cacheInvalidationListeners.next++
+	cacheInvalidationListeners.listeners[id] = listener
+	return func() {
+		cacheInvalidationListeners.Lock()
+		defer cacheInvalidationListeners.Unlock()
+		delete(cacheInvalidationListeners.listeners, id)
+	}
+}
+
+func notifyCacheInvalidation(key string, prefix bool) {
+	cacheInvalidationListeners.RLock()
+	defer cacheInvalidationListeners.RUnlock()
+	for _, listener := range cacheInvalidationListeners.listeners {
+		listener(key, prefix)
+	}
+}
+
+// DeleteCache deletes the cached value for the passed key and notifies the
+// registered CacheInvalidationListeners.
+func DeleteCache(key string) error {
+	err := cache.Delete(key)
+	notifyCacheInvalidation(key, false)
+	return err
+}
+
+// ClearCache deletes all cached values with keys starting with the passed
+// prefix and notifies the registered CacheInvalidationListeners.
+func ClearCache(prefix string) error {
+	err := cache.Clear(prefix)
+	notifyCacheInvalidation(prefix, true)
+	return err
+}
+
+// SubordinateFromCacheKey returns the entity ID of the subordinate a cache key
+// created by SubordinateStatementCacheKey belongs to.
+func SubordinateFromCacheKey(key string) (string, bool) {
+	encoded, ok := strings.CutPrefix(key, cache.Key(CacheKeySubordinateStatement, ""))
+	if !ok || encoded == "" {
+		return "", false
+	}
+	entityID, err := base64.URLEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", false
+	}
+	return string(entityID), true
+}
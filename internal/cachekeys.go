@@ -9,6 +9,7 @@ import (
 const (
 	CacheKeyEntityConfiguration  = "lh:entity_configuration"
 	CacheKeySubordinateStatement = "lh:subordinate_statement"
+	CacheKeyHistoricalKeys       = "lh:historical_keys"
 )
 
 // SubordinateStatementCacheKey constructs the cache key for a signed
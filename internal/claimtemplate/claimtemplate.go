@@ -0,0 +1,328 @@
+// Package claimtemplate renders additional trust mark claims that are
+// templated from the entity configuration of the trust mark subject.
+//
+// A claim value (also nested in objects and arrays) is a template if it is a
+// string containing "{{". Templates use the text/template syntax; the
+// entity configuration is passed as data, so e.g.
+// "{{ .metadata.federation_entity.organization_name }}" copies the
+// organization name of the subject. Claims whose template renders to an
+// empty string, or fails to render because a referenced value is missing, are
+// omitted, which allows conditional claims.
+//
+// Templates are sandboxed: only the functions in Funcs and the text/template
+// builtins are available, the data only consists of the JSON values of the
+// entity configuration, and the size of templates and rendered values is
+// limited. The print builtins are replaced by variants that limit their
+// output and do not allow printf widths or precisions, which could allocate
+// arbitrarily large values. Templates can only range over the data, not over numbers, with at
+// most MaxRangeDepth nested ranges, and cannot define or call other
+// templates; the execution of a template is aborted after MaxExecutionTime.
+package claimtemplate
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/template"
+	"text/template/parse"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// MaxTemplateLength is the maximum length of a single template.
+	MaxTemplateLength = 1024
+	// MaxOutputLength is the maximum length of a rendered value.
+	MaxOutputLength = 4096
+	// MaxRangeDepth is the maximum nesting depth of range actions.
+	MaxRangeDepth = 2
+	// MaxExecutionTime is the maximum time the execution of a template may
+	// take.
+	MaxExecutionTime = 100 * time.Millisecond
+
+	noValue = "<no value>"
+)
+
+// Funcs are the functions available in templates in addition to the
+// text/template builtins. print, printf and println replace the builtins of
+// the same name.
+var Funcs = template.FuncMap{
+	"print": func(args ...any) (string, error) {
+		return limitOutput(fmt.Sprint(args...))
+	},
+	"println": func(args ...any) (string, error) {
+		return limitOutput(fmt.Sprintln(args...))
+	},
+	"printf": func(format string, args ...any) (string, error) {
+		if err := checkFormat(format); err != nil {
+			return "", err
+		}
+		return limitOutput(fmt.Sprintf(format, args...))
+	},
+	"lower": strings.ToLower,
+	"upper": strings.ToUpper,
+	"trim":  strings.TrimSpace,
+	"default": func(def, v any) any {
+		if v == nil || v == "" {
+			return def
+		}
+		return v
+	},
+	"join": func(sep string, v any) string {
+		values, ok := v.([]any)
+		if !ok {
+			return fmt.Sprint(v)
+		}
+		s := make([]string, len(values))
+		for i, value := range values {
+			s[i] = fmt.Sprint(value)
+		}
+		return strings.Join(s, sep)
+	},
+}
+
+// limitOutput fails for values longer than MaxOutputLength.
+func limitOutput(s string) (string, error) {
+	if len(s) > MaxOutputLength {
+		return "", errors.Errorf("rendered value exceeds %d characters", MaxOutputLength)
+	}
+	return s, nil
+}
+
+// checkFormat rejects printf formats with widths, precisions or argument
+// indexes; only flags and verbs are allowed.
+func checkFormat(format string) error {
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			continue
+		}
+		for i++; i < len(format); i++ {
+			c := format[i]
+			if c == '%' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') {
+				break
+			}
+			if !strings.ContainsRune("+-# 0", rune(c)) {
+				return errors.New("printf widths, precisions and argument indexes are not allowed")
+			}
+		}
+	}
+	return nil
+}
+
+// IsTemplate reports whether the passed claim value is a template.
+func IsTemplate(v string) bool {
+	return strings.Contains(v, "{{")
+}
+
+// HasTemplates reports whether any of the passed claims contains a template.
+func HasTemplates(claims map[string]any) bool {
+	found := false
+	walk(claims, "", func(_, _ string) {
+		found = true
+	})
+	return found
+}
+
+// Validate parses all templates in the passed claims and returns an error
+// naming the claim of an invalid template.
+func Validate(claims map[string]any) error {
+	var err error
+	walk(claims, "", func(path, tmpl string) {
+		if err != nil {
+			return
+		}
+		if _, e := parseTemplate(tmpl); e != nil {
+			err = errors.Wrapf(e, "invalid template in claim '%s'", path)
+		}
+	})
+	return err
+}
+
+// Render returns a copy of the passed claims with all templates rendered with
+// the passed data. Claims whose template renders to an empty string or
+// cannot be rendered are omitted.
+func Render(claims map[string]any, data map[string]any) map[string]any {
+	if claims == nil {
+		return nil
+	}
+	rendered, _ := render(claims, data).(map[string]any)
+	return rendered
+}
+
+func render(v, data any) any {
+	switch v := v.(type) {
+	case string:
+		if !IsTemplate(v) {
+			return v
+		}
+		out, err := execute(v, data)
+		if err != nil || out == "" {
+			return nil
+		}
+		return out
+	case map[string]any:
+		m := make(map[string]any, len(v))
+		for key, value := range v {
+			if r := render(value, data); r != nil {
+				m[key] = r
+			}
+		}
+		return m
+	case []any:
+		s := make([]any, 0, len(v))
+		for _, value := range v {
+			if r := render(value, data); r != nil {
+				s = append(s, r)
+			}
+		}
+		return s
+	default:
+		return v
+	}
+}
+
+func parseTemplate(tmpl string) (*template.Template, error) {
+	if len(tmpl) > MaxTemplateLength {
+		return nil, errors.Errorf("template exceeds %d characters", MaxTemplateLength)
+	}
+	t, err := template.New("claim").Funcs(Funcs).Parse(tmpl)
+	if err != nil {
+		return nil, err
+	}
+	if len(t.Templates()) > 1 {
+		return nil, errors.New("templates must not define other templates")
+	}
+	if err = checkNode(t.Root, 0); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// checkNode rejects the constructs that could make the execution of a
+// template unbounded: calls of other templates, ranges over anything but
+// the data, and deeply nested ranges.
+func checkNode(node parse.Node, rangeDepth int) error {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return nil
+		}
+		for _, child := range n.Nodes {
+			if err := checkNode(child, rangeDepth); err != nil {
+				return err
+			}
+		}
+	case *parse.TemplateNode:
+		return errors.New("templates must not call other templates")
+	case *parse.RangeNode:
+		if rangeDepth >= MaxRangeDepth {
+			return errors.Errorf("range actions must not be nested deeper than %d", MaxRangeDepth)
+		}
+		if !rangesOverData(n.Pipe) {
+			return errors.New("range actions must range over a field of the data")
+		}
+		if err := checkNode(n.List, rangeDepth+1); err != nil {
+			return err
+		}
+		return checkNode(n.ElseList, rangeDepth)
+	case *parse.IfNode:
+		if err := checkNode(n.List, rangeDepth); err != nil {
+			return err
+		}
+		return checkNode(n.ElseList, rangeDepth)
+	case *parse.WithNode:
+		if err := checkNode(n.List, rangeDepth); err != nil {
+			return err
+		}
+		return checkNode(n.ElseList, rangeDepth)
+	}
+	return nil
+}
+
+// rangesOverData reports whether the pipeline of a range action is a plain
+// reference to the data, i.e. ".", a field like ".metadata.contacts" or "$"
+// with fields. Numbers, variables holding arbitrary values, and function
+// results are rejected.
+func rangesOverData(pipe *parse.PipeNode) bool {
+	if pipe == nil || len(pipe.Cmds) != 1 || len(pipe.Cmds[0].Args) != 1 {
+		return false
+	}
+	switch arg := pipe.Cmds[0].Args[0].(type) {
+	case *parse.DotNode, *parse.FieldNode:
+		return true
+	case *parse.VariableNode:
+		return arg.Ident[0] == "$"
+	default:
+		return false
+	}
+}
+
+// limitedBuffer fails writes beyond MaxOutputLength or after the deadline,
+// which aborts the template execution.
+type limitedBuffer struct {
+	bytes.Buffer
+	deadline time.Time
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if b.Len()+len(p) > MaxOutputLength {
+		return 0, errors.Errorf("rendered value exceeds %d characters", MaxOutputLength)
+	}
+	if time.Now().After(b.deadline) {
+		return 0, errors.Errorf("template execution exceeds %s", MaxExecutionTime)
+	}
+	return b.Buffer.Write(p)
+}
+
+func execute(tmpl string, data any) (string, error) {
+	t, err := parseTemplate(tmpl)
+	if err != nil {
+		return "", err
+	}
+	type result struct {
+		out string
+		err error
+	}
+	// The execution cannot be interrupted, so it runs in its own goroutine
+	// and is abandoned after MaxExecutionTime
+	done := make(chan result, 1)
+	go func() {
+		buf := limitedBuffer{deadline: time.Now().Add(MaxExecutionTime)}
+		err := t.Execute(&buf, data)
+		done <- result{out: buf.String(), err: err}
+	}()
+	timer := time.NewTimer(MaxExecutionTime)
+	defer timer.Stop()
+	select {
+	case r := <-done:
+		if r.err != nil {
+			return "", r.err
+		}
+		return strings.TrimSpace(strings.ReplaceAll(r.out, noValue, "")), nil
+	case <-timer.C:
+		return "", errors.Errorf("template execution exceeds %s", MaxExecutionTime)
+	}
+}
+
+// walk calls fn for each template in v together with the path of its claim.
+func walk(v any, path string, fn func(path, tmpl string)) {
+	switch v := v.(type) {
+	case string:
+		if IsTemplate(v) {
+			fn(path, v)
+		}
+	case map[string]any:
+		for key, value := range v {
+			p := key
+			if path != "" {
+				p = path + "." + key
+			}
+			walk(value, p, fn)
+		}
+	case []any:
+		for i, value := range v {
+			walk(value, fmt.Sprintf("%s[%d]", path, i), fn)
+		}
+	}
+}
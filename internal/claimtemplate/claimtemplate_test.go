@@ -0,0 +1,160 @@
+package claimtemplate
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testEntityConfiguration = map[string]any{
+	"sub": "https://rp.example.org",
+	"metadata": map[string]any{
+		"federation_entity": map[string]any{
+			"organization_name": "Example Org",
+			"contacts":          []any{"a@example.org", "b@example.org"},
+		},
+	},
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		claims  map[string]any
+		wantErr string
+	}{
+		{
+			name:   "static",
+			claims: map[string]any{"level": "high", "n": 1},
+		},
+		{
+			name: "valid",
+			claims: map[string]any{
+				"org": "{{ .metadata.federation_entity.organization_name | upper }}",
+				"nested": map[string]any{
+					"list": []any{"{{ default \"none\" .sub }}"},
+				},
+			},
+		},
+		{
+			name:    "syntax error",
+			claims:  map[string]any{"org": "{{ .metadata"},
+			wantErr: "claim 'org'",
+		},
+		{
+			name:    "unknown function",
+			claims:  map[string]any{"nested": map[string]any{"list": []any{"{{ exec \"ls\" }}"}}},
+			wantErr: "claim 'nested.list[0]'",
+		},
+		{
+			name:   "range over data",
+			claims: map[string]any{"c": "{{ range $i, $c := .metadata.federation_entity.contacts }}{{ $c }}{{ end }}"},
+		},
+		{
+			name:    "range over number",
+			claims:  map[string]any{"loop": "{{ range 100000 }}{{ range 100000 }}{{ end }}{{ end }}"},
+			wantErr: "range over a field",
+		},
+		{
+			name:    "range over variable",
+			claims:  map[string]any{"loop": "{{ $n := 100000 }}{{ range $n }}{{ end }}"},
+			wantErr: "range over a field",
+		},
+		{
+			name:    "nested ranges",
+			claims:  map[string]any{"loop": "{{ range .a }}{{ range $.a }}{{ range $.a }}{{ end }}{{ end }}{{ end }}"},
+			wantErr: "nested",
+		},
+		{
+			name:    "recursive template",
+			claims:  map[string]any{"loop": "{{ define \"a\" }}{{ template \"a\" . }}{{ end }}{{ template \"a\" . }}"},
+			wantErr: "other templates",
+		},
+		{
+			name:    "too long",
+			claims:  map[string]any{"org": "{{ .sub }}" + strings.Repeat(" ", MaxTemplateLength)},
+			wantErr: "exceeds",
+		},
+	}
+	for _, test := range tests {
+		t.Run(
+			test.name, func(t *testing.T) {
+				err := Validate(test.claims)
+				if test.wantErr == "" {
+					assert.NoError(t, err)
+					return
+				}
+				assert.ErrorContains(t, err, test.wantErr)
+			},
+		)
+	}
+}
+
+func TestRender(t *testing.T) {
+	claims := map[string]any{
+		"static":   "value",
+		"number":   3,
+		"org":      "{{ .metadata.federation_entity.organization_name }}",
+		"contacts": "{{ join \", \" .metadata.federation_entity.contacts }}",
+		"missing":  "{{ .metadata.federation_entity.homepage_uri }}",
+		"invalid":  "{{ .metadata.openid_relying_party.client_name }}",
+		"default":  "{{ default \"n/a\" .metadata.federation_entity.homepage_uri }}",
+		"optional": "{{ with .metadata.federation_entity.organization_name }}Org: {{ . }}{{ end }}",
+		"nested": map[string]any{
+			"list": []any{"{{ .sub }}", "{{ .missing }}"},
+		},
+	}
+	assert.True(t, HasTemplates(claims))
+	assert.False(t, HasTemplates(map[string]any{"static": "value"}))
+
+	rendered := Render(claims, testEntityConfiguration)
+	assert.Equal(
+		t, map[string]any{
+			"static":   "value",
+			"number":   3,
+			"org":      "Example Org",
+			"contacts": "a@example.org, b@example.org",
+			"default":  "n/a",
+			"optional": "Org: Example Org",
+			"nested": map[string]any{
+				"list": []any{"https://rp.example.org"},
+			},
+		}, rendered,
+	)
+	// The claims are not modified
+	assert.Equal(t, "{{ .sub }}", claims["nested"].(map[string]any)["list"].([]any)[0])
+}
+
+func TestRenderOutputLimit(t *testing.T) {
+	claims := map[string]any{
+		"long": "{{ range .items }}{{ . }}{{ end }}",
+	}
+	items := make([]any, MaxOutputLength)
+	for i := range items {
+		items[i] = "xx"
+	}
+	assert.Empty(t, Render(claims, map[string]any{"items": items}))
+}
+
+func TestRenderPrintf(t *testing.T) {
+	claims := map[string]any{
+		"printf":    "{{ printf \"%s (%v)\" .sub 3 }}",
+		"flags":     "{{ printf \"%+d%%\" 5 }}",
+		"width":     "{{ printf \"%999999999s\" \"\" }}",
+		"precision": "{{ printf \"%.999999999f\" 1.0 }}",
+		"star":      "{{ printf \"%*s\" 999999999 \"\" }}",
+		"index":     "{{ printf \"%[1]s\" .sub }}",
+		"print":     "{{ print .sub }}",
+	}
+	start := time.Now()
+	rendered := Render(claims, testEntityConfiguration)
+	assert.Less(t, time.Since(start), MaxExecutionTime)
+	assert.Equal(
+		t, map[string]any{
+			"printf": "https://rp.example.org (3)",
+			"flags":  "+5%",
+			"print":  "https://rp.example.org",
+		}, rendered,
+	)
+}
@@ -0,0 +1,174 @@
+// Package keyrollover implements staged key rollovers. A staged rollover
+// generates the next signing keys, publishes them in the JWKS for a while
+// before they are used for signing, activates them, and finally retires the
+// previous keys. Each stage is scheduled at a fixed point in time.
+package keyrollover
+
+import (
+	"slices"
+	"time"
+
+	"github.com/go-oidfed/lib/jwx/keymanagement/public"
+	"github.com/go-oidfed/lib/unixtime"
+	"github.com/pkg/errors"
+
+	"github.com/go-oidfed/lighthouse/storage/model"
+)
+
+// Rollover stages in the order they are executed.
+const (
+	// StageGenerate generates the next keys; they are not yet published.
+	StageGenerate = "generate"
+	// StagePublish publishes the next keys in the JWKS; they are not yet
+	// used for signing.
+	StagePublish = "publish"
+	// StageActivate starts signing with the next keys.
+	StageActivate = "activate"
+	// StageRetire expires the previous keys, which removes them from the
+	// JWKS.
+	StageRetire = "retire"
+)
+
+// Stages lists all rollover stages in the order they are executed.
+var Stages = []string{StageGenerate, StagePublish, StageActivate, StageRetire}
+
+// Rollover statuses.
+const (
+	StatusScheduled  = "scheduled"
+	StatusInProgress = "in_progress"
+	StatusCompleted  = "completed"
+	StatusCancelled  = "cancelled"
+	StatusFailed     = "failed"
+)
+
+// Step is a single scheduled stage of a Rollover.
+type Step struct {
+	Stage       string             `json:"stage"`
+	ScheduledAt unixtime.Unixtime  `json:"scheduled_at"`
+	CompletedAt *unixtime.Unixtime `json:"completed_at,omitempty"`
+}
+
+// Rollover is a staged key rollover of a key set. It is stored in the key
+// value store in the signing scope of the key purpose.
+type Rollover struct {
+	Status    string            `json:"status"`
+	Steps     []Step            `json:"steps"`
+	OldKIDs   []string          `json:"old_kids,omitempty"`
+	NewKIDs   []string          `json:"new_kids,omitempty"`
+	Error     string            `json:"error,omitempty"`
+	CreatedAt unixtime.Unixtime `json:"created_at"`
+}
+
+// New returns a new scheduled Rollover. The stage times must not be
+// decreasing.
+func New(generateAt, publishAt, activateAt, retireAt time.Time) (*Rollover, error) {
+	times := []time.Time{generateAt, publishAt, activateAt, retireAt}
+	for i := 1; i < len(times); i++ {
+		if times[i].Before(times[i-1]) {
+			return nil, errors.Errorf(
+				"stage '%s' must not be scheduled before stage '%s'", Stages[i], Stages[i-1],
+			)
+		}
+	}
+	r := &Rollover{
+		Status:    StatusScheduled,
+		CreatedAt: unixtime.Now(),
+	}
+	for i, stage := range Stages {
+		r.Steps = append(
+			r.Steps, Step{
+				Stage:       stage,
+				ScheduledAt: unixtime.Unixtime{Time: times[i]},
+			},
+		)
+	}
+	return r, nil
+}
+
+// Step returns the Step of the passed stage or nil if it does not exist
+func (r *Rollover) Step(stage string) *Step {
+	for i := range r.Steps {
+		if r.Steps[i].Stage == stage {
+			return &r.Steps[i]
+		}
+	}
+	return nil
+}
+
+// NextStep returns the first Step that is not completed yet or nil if all
+// steps are completed
+func (r *Rollover) NextStep() *Step {
+	for i := range r.Steps {
+		if r.Steps[i].CompletedAt == nil {
+			return &r.Steps[i]
+		}
+	}
+	return nil
+}
+
+// Completed reports if the passed stage has been executed
+func (r *Rollover) Completed(stage string) bool {
+	s := r.Step(stage)
+	return s != nil && s.CompletedAt != nil
+}
+
+// Finished reports if the Rollover is no longer pending, i.e. it completed,
+// failed, or was cancelled
+func (r *Rollover) Finished() bool {
+	switch r.Status {
+	case StatusCompleted, StatusCancelled, StatusFailed:
+		return true
+	default:
+		return false
+	}
+}
+
+// unpublished reports if the passed kid is a generated key that must not be
+// published yet
+func (r *Rollover) unpublished(kid string, now time.Time) bool {
+	if r.Status == StatusCancelled || !slices.Contains(r.NewKIDs, kid) {
+		return false
+	}
+	publish := r.Step(StagePublish)
+	return publish != nil && publish.CompletedAt == nil && now.Before(publish.ScheduledAt.Time)
+}
+
+// Get returns the Rollover of the passed key purpose or nil if there is none
+func Get(kv model.KeyValueStore, purpose string) (*Rollover, error) {
+	if kv == nil {
+		return nil, nil
+	}
+	var r Rollover
+	found, err := kv.GetAs(model.SigningScope(purpose), model.KeyValueKeyKeyRollover, &r)
+	if err != nil || !found {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// Set stores the Rollover of the passed key purpose
+func Set(kv model.KeyValueStore, purpose string, r *Rollover) error {
+	if kv == nil {
+		return errors.New("key value store is not set")
+	}
+	return kv.SetAny(model.SigningScope(purpose), model.KeyValueKeyKeyRollover, r)
+}
+
+// PublishedKeys removes the keys from the passed list that were generated by
+// a rollover of the passed key purpose but must not be published yet
+func PublishedKeys(
+	kv model.KeyValueStore, purpose string, keys public.PublicKeyEntryList,
+) (public.PublicKeyEntryList, error) {
+	r, err := Get(kv, purpose)
+	if err != nil || r == nil || len(r.NewKIDs) == 0 {
+		return keys, err
+	}
+	now := time.Now()
+	published := make(public.PublicKeyEntryList, 0, len(keys))
+	for _, k := range keys {
+		if !r.unpublished(k.KID, now) {
+			published = append(published, k)
+		}
+	}
+	return published, nil
+}
@@ -0,0 +1,159 @@
+package keyrollover
+
+import (
+	"fmt"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/go-oidfed/lib/jwx/keymanagement/kms"
+	"github.com/go-oidfed/lib/jwx/keymanagement/public"
+	"github.com/lestrrat-go/jwx/v4/jwa"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/go-oidfed/lighthouse/storage"
+	"github.com/go-oidfed/lighthouse/storage/model"
+)
+
+func newTestKeySet(t *testing.T) (model.KeyValueStore, KeySet) {
+	t.Helper()
+	store, err := storage.NewStorage(
+		storage.Config{
+			Driver: storage.DriverSQLite,
+			DSN:    fmt.Sprintf("file:%s?mode=memory&cache=shared", url.PathEscape(t.Name())),
+		},
+	)
+	require.NoError(t, err)
+	kv := store.KeyValue()
+
+	dir := t.TempDir()
+	pks := &public.FilesystemPublicKeyStorage{
+		Dir:    dir,
+		TypeID: model.SigningPurposeFederation,
+	}
+	require.NoError(t, pks.Load())
+	rotation, err := storage.GetKeyRotation(kv)
+	require.NoError(t, err)
+	keys := kms.NewSingleAlgFilesystemKMS(
+		jwa.ES256(), kms.FilesystemKMSConfig{
+			KMSConfig: kms.KMSConfig{
+				GenerateKeys: true,
+				KeyRotation:  rotation,
+			},
+			Dir:    dir,
+			TypeID: model.SigningPurposeFederation,
+		}, pks,
+	)
+	require.NotNil(t, keys)
+	return kv, KeySet{
+		Purpose: model.SigningPurposeFederation,
+		Keys:    keys,
+		PKs:     pks,
+	}
+}
+
+func TestNew_StageOrder(t *testing.T) {
+	now := time.Now()
+	_, err := New(now, now.Add(time.Hour), now.Add(30*time.Minute), now.Add(2*time.Hour))
+	assert.Error(t, err)
+
+	r, err := New(now, now, now.Add(time.Hour), now.Add(2*time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, StatusScheduled, r.Status)
+	require.Len(t, r.Steps, len(Stages))
+	assert.Equal(t, StageGenerate, r.NextStep().Stage)
+}
+
+func TestAdvance_StagedRollover(t *testing.T) {
+	kv, ks := newTestKeySet(t)
+	oldKeys, err := ks.PKs.GetActive()
+	require.NoError(t, err)
+	require.Len(t, oldKeys, 1)
+
+	now := time.Now()
+	publishAt := now.Add(time.Hour)
+	activateAt := now.Add(48 * time.Hour)
+	retireAt := now.Add(72 * time.Hour)
+	r, err := New(now, publishAt, activateAt, retireAt)
+	require.NoError(t, err)
+	require.NoError(t, Schedule(kv, ks.Purpose, r))
+	assert.ErrorIs(t, Schedule(kv, ks.Purpose, r), ErrRolloverPending)
+
+	// Generate
+	r, executed, err := Advance(kv, ks, now)
+	require.NoError(t, err)
+	assert.Equal(t, []string{StageGenerate}, executed)
+	assert.Equal(t, StatusInProgress, r.Status)
+	assert.Equal(t, []string{oldKeys[0].KID}, r.OldKIDs)
+	require.Len(t, r.NewKIDs, 1)
+	newKey, err := ks.PKs.Get(r.NewKIDs[0])
+	require.NoError(t, err)
+	assert.WithinDuration(t, activateAt.Add(-30*time.Minute), newKey.NotBefore.Time, time.Minute)
+	oldKey, err := ks.PKs.Get(oldKeys[0].KID)
+	require.NoError(t, err)
+	assert.WithinDuration(t, retireAt, oldKey.ExpiresAt.Time, time.Second)
+
+	// The new key is not published before the publish stage
+	valid, err := ks.PKs.GetValid()
+	require.NoError(t, err)
+	require.Len(t, valid, 2)
+	published, err := PublishedKeys(kv, ks.Purpose, valid)
+	require.NoError(t, err)
+	require.Len(t, published, 1)
+	assert.Equal(t, oldKeys[0].KID, published[0].KID)
+
+	// Nothing is due yet
+	_, executed, err = Advance(kv, ks, now.Add(time.Minute))
+	require.NoError(t, err)
+	assert.Empty(t, executed)
+
+	// Publish and activate
+	r, executed, err = Advance(kv, ks, activateAt)
+	require.NoError(t, err)
+	assert.Equal(t, []string{StagePublish, StageActivate}, executed)
+	published, err = PublishedKeys(kv, ks.Purpose, valid)
+	require.NoError(t, err)
+	assert.Len(t, published, 2)
+	_, err = Cancel(kv, ks, activateAt)
+	assert.ErrorIs(t, err, ErrNotCancellable)
+
+	// Retire
+	r, executed, err = Advance(kv, ks, retireAt)
+	require.NoError(t, err)
+	assert.Equal(t, []string{StageRetire}, executed)
+	assert.Equal(t, StatusCompleted, r.Status)
+	for _, step := range r.Steps {
+		assert.NotNil(t, step.CompletedAt, step.Stage)
+	}
+	stored, err := Get(kv, ks.Purpose)
+	require.NoError(t, err)
+	assert.Equal(t, StatusCompleted, stored.Status)
+}
+
+func TestCancel(t *testing.T) {
+	kv, ks := newTestKeySet(t)
+	_, err := Cancel(kv, ks, time.Now())
+	assert.ErrorIs(t, err, ErrNoRollover)
+
+	now := time.Now()
+	r, err := New(now, now.Add(time.Hour), now.Add(48*time.Hour), now.Add(72*time.Hour))
+	require.NoError(t, err)
+	require.NoError(t, Schedule(kv, ks.Purpose, r))
+	r, _, err = Advance(kv, ks, now)
+	require.NoError(t, err)
+	require.Len(t, r.NewKIDs, 1)
+
+	r, err = Cancel(kv, ks, now)
+	require.NoError(t, err)
+	assert.Equal(t, StatusCancelled, r.Status)
+	newKey, err := ks.PKs.Get(r.NewKIDs[0])
+	require.NoError(t, err)
+	require.NotNil(t, newKey.ExpiresAt)
+	assert.False(t, newKey.ExpiresAt.After(now))
+
+	// A new rollover can be scheduled after cancelling
+	r, err = New(now, now, now.Add(48*time.Hour), now.Add(72*time.Hour))
+	require.NoError(t, err)
+	assert.NoError(t, Schedule(kv, ks.Purpose, r))
+}
@@ -0,0 +1,241 @@
+package keyrollover
+
+import (
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/go-oidfed/lib/jwx/keymanagement/kms"
+	"github.com/go-oidfed/lib/jwx/keymanagement/public"
+	"github.com/go-oidfed/lib/unixtime"
+	"github.com/lestrrat-go/jwx/v4/jwa"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/zachmann/go-utils/duration"
+
+	"github.com/go-oidfed/lighthouse/storage"
+	"github.com/go-oidfed/lighthouse/storage/model"
+)
+
+var (
+	// ErrNoRollover is returned if no rollover is scheduled for a key set
+	ErrNoRollover = errors.New("no key rollover scheduled")
+	// ErrRolloverPending is returned when scheduling a rollover while
+	// another one has not finished yet
+	ErrRolloverPending = errors.New("a key rollover is already scheduled or in progress")
+	// ErrNotCancellable is returned when cancelling a rollover that has
+	// already finished or whose new keys have already been activated
+	ErrNotCancellable = errors.New("key rollover can no longer be cancelled")
+)
+
+// KeySet is the key set a Rollover is executed on
+type KeySet struct {
+	Purpose string
+	Keys    kms.KeyManagementSystem
+	PKs     public.PublicKeyStorage
+}
+
+// Schedule stores the passed Rollover for the key set of the passed
+// purpose. It fails with ErrRolloverPending if another rollover has not
+// finished yet.
+func Schedule(kv model.KeyValueStore, purpose string, r *Rollover) error {
+	current, err := Get(kv, purpose)
+	if err != nil {
+		return err
+	}
+	if current != nil && !current.Finished() {
+		return ErrRolloverPending
+	}
+	return Set(kv, purpose, r)
+}
+
+// Advance executes all stages of the Rollover of the passed key set that are
+// due at now and returns the Rollover together with the executed stages. If a
+// stage fails, the Rollover is marked as failed and the error is returned.
+//
+// Automatic key rotation of the key set is paused from the generate stage
+// until the previous keys are retired.
+func Advance(kv model.KeyValueStore, ks KeySet, now time.Time) (r *Rollover, executed []string, err error) {
+	r, err = Get(kv, ks.Purpose)
+	if err != nil || r == nil || r.Finished() {
+		return
+	}
+	if r.Completed(StageGenerate) {
+		// Rotation might have been started again, e.g. after a restart
+		ks.Keys.StopAutomaticRotation()
+	}
+	for step := r.NextStep(); step != nil && !now.Before(step.ScheduledAt.Time); step = r.NextStep() {
+		if err = execute(kv, ks, r, step.Stage, now); err != nil {
+			r.Status = StatusFailed
+			r.Error = fmt.Sprintf("stage '%s' failed: %s", step.Stage, err)
+			if e := resumeRotation(kv, ks); e != nil {
+				log.Error().Err(e).Str("purpose", ks.Purpose).Msg("key rollover: failed to resume key rotation")
+			}
+			break
+		}
+		step.CompletedAt = &unixtime.Unixtime{Time: now}
+		r.Status = StatusInProgress
+		executed = append(executed, step.Stage)
+	}
+	if err == nil && r.NextStep() == nil {
+		r.Status = StatusCompleted
+	}
+	if len(executed) > 0 || err != nil {
+		if e := Set(kv, ks.Purpose, r); e != nil && err == nil {
+			err = e
+		}
+	}
+	return
+}
+
+// Cancel cancels the Rollover of the passed key set. Keys that were already
+// generated are expired. A rollover can only be cancelled before its new keys
+// are activated.
+func Cancel(kv model.KeyValueStore, ks KeySet, now time.Time) (*Rollover, error) {
+	r, err := Get(kv, ks.Purpose)
+	if err != nil {
+		return nil, err
+	}
+	if r == nil {
+		return nil, ErrNoRollover
+	}
+	if r.Finished() || r.Completed(StageActivate) {
+		return r, ErrNotCancellable
+	}
+	if r.Completed(StageGenerate) {
+		if err = expireKeys(ks.PKs, r.NewKIDs, now); err != nil {
+			return r, err
+		}
+		if err = resumeRotation(kv, ks); err != nil {
+			return r, err
+		}
+	}
+	r.Status = StatusCancelled
+	return r, Set(kv, ks.Purpose, r)
+}
+
+func execute(kv model.KeyValueStore, ks KeySet, r *Rollover, stage string, now time.Time) error {
+	switch stage {
+	case StageGenerate:
+		return generate(kv, ks, r, now)
+	case StagePublish:
+		// Nothing to do, generated keys are published once the publish
+		// stage is due, see PublishedKeys
+		return nil
+	case StageActivate:
+		// Loads the private keys of keys that became active; they are not
+		// loaded if they were generated before a restart
+		return errors.Wrap(ks.Keys.Load(), "could not load keys")
+	case StageRetire:
+		if err := expireKeys(ks.PKs, r.OldKIDs, now); err != nil {
+			return err
+		}
+		return resumeRotation(kv, ks)
+	default:
+		return errors.Errorf("unknown stage '%s'", stage)
+	}
+}
+
+// generate generates the next key for each algorithm of the key set. The new
+// keys become valid shortly before the activate stage and the current keys
+// are kept valid until the retire stage.
+func generate(kv model.KeyValueStore, ks KeySet, r *Rollover, now time.Time) error {
+	rotation, err := storage.GetKeyRotationForPurpose(kv, ks.Purpose)
+	if err != nil {
+		return err
+	}
+	activateAt := r.Step(StageActivate).ScheduledAt.Time
+	retireAt := r.Step(StageRetire).ScheduledAt.Time
+	// The KMS prefers a key for signing once it has been valid for half the
+	// overlap, so the key's nbf is set accordingly to start signing with it
+	// at activateAt.
+	nbf := activateAt.Add(-rotation.Overlap.Duration() / 2)
+	leadTime := max(nbf.Sub(now), time.Second)
+
+	before, err := ks.PKs.GetValid()
+	if err != nil {
+		return err
+	}
+	active, err := ks.PKs.GetActive()
+	if err != nil {
+		return err
+	}
+	algs := ks.Keys.GetAlgs()
+	r.OldKIDs = nil
+	for _, k := range active {
+		alg, _ := k.Key.Algorithm()
+		if sigAlg, ok := alg.(jwa.SignatureAlgorithm); !ok || !slices.Contains(algs, sigAlg) {
+			continue
+		}
+		r.OldKIDs = append(r.OldKIDs, k.KID)
+		if k.ExpiresAt == nil || k.ExpiresAt.Before(retireAt) {
+			k.ExpiresAt = &unixtime.Unixtime{Time: retireAt}
+			if err = ks.PKs.Update(k.KID, k.UpdateablePublicKeyMetadata); err != nil {
+				return err
+			}
+		}
+	}
+
+	ks.Keys.StopAutomaticRotation()
+	paused := rotation
+	paused.Enabled = false
+	rolloverConf := paused
+	rolloverConf.KeyAnnouncementLeadTime = duration.DurationOption(leadTime)
+	rolloverConf.KeyAnnouncementLeadTimeECMultiplier = 0
+	rolloverConf.Overlap = duration.DurationOption(retireAt.Sub(now.Add(leadTime)))
+	if err = ks.Keys.ChangeKeyRotationConfig(rolloverConf); err != nil {
+		return err
+	}
+	err = ks.Keys.RotateAllKeys(false, "")
+	if e := ks.Keys.ChangeKeyRotationConfig(paused); e != nil && err == nil {
+		err = e
+	}
+	if err != nil {
+		return err
+	}
+
+	after, err := ks.PKs.GetValid()
+	if err != nil {
+		return err
+	}
+	r.NewKIDs = nil
+	for _, k := range after {
+		if !slices.ContainsFunc(
+			before, func(b public.PublicKeyEntry) bool {
+				return b.KID == k.KID
+			},
+		) {
+			r.NewKIDs = append(r.NewKIDs, k.KID)
+		}
+	}
+	return nil
+}
+
+// expireKeys sets the expiration of the passed keys to now, unless they
+// already expired
+func expireKeys(pks public.PublicKeyStorage, kids []string, now time.Time) error {
+	for _, kid := range kids {
+		k, err := pks.Get(kid)
+		if err != nil {
+			return err
+		}
+		if k == nil || (k.ExpiresAt != nil && !k.ExpiresAt.After(now)) {
+			continue
+		}
+		k.ExpiresAt = &unixtime.Unixtime{Time: now}
+		if err = pks.Update(kid, k.UpdateablePublicKeyMetadata); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resumeRotation restores the stored key rotation config of the key set,
+// which also restarts automatic rotation if it is enabled
+func resumeRotation(kv model.KeyValueStore, ks KeySet) error {
+	rotation, err := storage.GetKeyRotationForPurpose(kv, ks.Purpose)
+	if err != nil {
+		return err
+	}
+	return ks.Keys.ChangeKeyRotationConfig(rotation)
+}
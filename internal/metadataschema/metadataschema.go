@@ -0,0 +1,267 @@
+// Package metadataschema validates entity metadata against JSON Schemas.
+//
+// Schemas are looked up per entity type. A schema stored in the database
+// (model.MetadataSchemaStore) takes precedence over the built-in default
+// schema for that entity type. Entity types without any schema are not
+// validated.
+package metadataschema
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/santhosh-tekuri/jsonschema/v6"
+
+	"github.com/go-oidfed/lighthouse/storage/model"
+)
+
+//go:embed builtin/*.json
+var builtinFS embed.FS
+
+// SourceBuiltin and SourceCustom describe where an effective schema comes from
+const (
+	SourceBuiltin = "builtin"
+	SourceCustom  = "custom"
+)
+
+// BuiltinSchema returns the built-in default schema for the passed entity
+// type and whether one exists.
+func BuiltinSchema(entityType string) (json.RawMessage, bool) {
+	data, err := builtinFS.ReadFile(path.Join("builtin", entityType+".json"))
+	if err != nil {
+		return nil, false
+	}
+	return data, true
+}
+
+// BuiltinEntityTypes returns the entity types for which a built-in schema
+// exists.
+func BuiltinEntityTypes() []string {
+	entries, _ := builtinFS.ReadDir("builtin")
+	types := make([]string, 0, len(entries))
+	for _, e := range entries {
+		types = append(types, strings.TrimSuffix(e.Name(), ".json"))
+	}
+	slices.Sort(types)
+	return types
+}
+
+// Compile compiles the passed JSON Schema document. It is used to verify
+// schemas before they are stored.
+func Compile(schema []byte) (*jsonschema.Schema, error) {
+	doc, err := jsonschema.UnmarshalJSON(bytes.NewReader(schema))
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid json")
+	}
+	const loc = "metadata-schema.json"
+	c := jsonschema.NewCompiler()
+	c.AssertFormat()
+	if err = c.AddResource(loc, doc); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	sch, err := c.Compile(loc)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return sch, nil
+}
+
+// ValidationError is returned if metadata does not match the schema of its
+// entity type
+type ValidationError struct {
+	EntityType string
+	Errors     []string
+}
+
+// Error implements the error interface
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf(
+		"metadata for entity type '%s' does not match schema: %s",
+		e.EntityType, strings.Join(e.Errors, "; "),
+	)
+}
+
+// Validator validates metadata against the effective schema of each entity
+// type. Compiled schemas are cached and recompiled when the stored schema
+// changes.
+type Validator struct {
+	store model.MetadataSchemaStore
+	mu    sync.Mutex
+	cache map[string]*jsonschema.Schema
+}
+
+// NewValidator returns a new Validator that looks up schemas in the passed
+// store. If store is nil, only the built-in schemas are used.
+func NewValidator(store model.MetadataSchemaStore) *Validator {
+	return &Validator{
+		store: store,
+		cache: make(map[string]*jsonschema.Schema),
+	}
+}
+
+// Schema returns the effective schema for the passed entity type and its
+// source (SourceCustom or SourceBuiltin). If there is no schema, nil and an
+// empty source are returned.
+func (v *Validator) Schema(entityType string) (json.RawMessage, string, error) {
+	if v.store != nil {
+		stored, err := v.store.Get(entityType)
+		if err == nil {
+			return json.RawMessage(stored.Schema), SourceCustom, nil
+		}
+		var notFound model.NotFoundError
+		if !errors.As(err, &notFound) {
+			return nil, "", err
+		}
+	}
+	if builtin, ok := BuiltinSchema(entityType); ok {
+		return builtin, SourceBuiltin, nil
+	}
+	return nil, "", nil
+}
+
+// compiled returns the compiled effective schema for the passed entity type
+// or nil if there is none.
+func (v *Validator) compiled(entityType string) (*jsonschema.Schema, error) {
+	raw, _, err := v.Schema(entityType)
+	if err != nil || raw == nil {
+		return nil, err
+	}
+	key := entityType + "\x00" + string(raw)
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if sch, ok := v.cache[key]; ok {
+		return sch, nil
+	}
+	sch, err := Compile(raw)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid metadata schema for entity type '%s'", entityType)
+	}
+	// Drop outdated compilations of this entity type's schema
+	for k := range v.cache {
+		if strings.HasPrefix(k, entityType+"\x00") {
+			delete(v.cache, k)
+		}
+	}
+	v.cache[key] = sch
+	return sch, nil
+}
+
+// ValidateEntityType validates the metadata of a single entity type. It
+// returns a *ValidationError if the metadata does not match the schema and
+// nil if it matches or no schema exists for the entity type.
+func (v *Validator) ValidateEntityType(entityType string, metadata any) error {
+	sch, err := v.compiled(entityType)
+	if err != nil || sch == nil {
+		return err
+	}
+	inst, err := toInstance(metadata)
+	if err != nil {
+		return err
+	}
+	if err = sch.Validate(inst); err != nil {
+		var verr *jsonschema.ValidationError
+		if !errors.As(err, &verr) {
+			return errors.WithStack(err)
+		}
+		return &ValidationError{
+			EntityType: entityType,
+			Errors:     flattenErrors(verr),
+		}
+	}
+	return nil
+}
+
+// Validate validates the metadata of all entity types contained in the passed
+// metadata (e.g. an oidfed.Metadata). If entityTypes are given, only these
+// entity types are validated; entity types not present in the metadata are
+// skipped.
+func (v *Validator) Validate(metadata any, entityTypes ...string) error {
+	inst, err := toInstance(metadata)
+	if err != nil {
+		return err
+	}
+	perType, ok := inst.(map[string]any)
+	if !ok {
+		if inst == nil {
+			return nil
+		}
+		return errors.New("metadata must be a json object")
+	}
+	if len(entityTypes) == 0 {
+		for et := range perType {
+			entityTypes = append(entityTypes, et)
+		}
+		slices.Sort(entityTypes)
+	}
+	for _, et := range entityTypes {
+		m, found := perType[et]
+		if !found {
+			continue
+		}
+		if err = v.ValidateEntityType(et, m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// toInstance converts the passed value into the generic representation used
+// by the jsonschema library. Object members that are null or empty strings are
+// dropped, since typed metadata structs marshal unset claims this way.
+func toInstance(value any) (any, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	inst, err := jsonschema.UnmarshalJSON(bytes.NewReader(data))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return pruneEmpty(inst), nil
+}
+
+// pruneEmpty recursively removes null and empty string members from objects
+func pruneEmpty(v any) any {
+	switch x := v.(type) {
+	case map[string]any:
+		for k, val := range x {
+			if val == nil || val == "" {
+				delete(x, k)
+				continue
+			}
+			x[k] = pruneEmpty(val)
+		}
+	case []any:
+		for i, val := range x {
+			x[i] = pruneEmpty(val)
+		}
+	}
+	return v
+}
+
+// flattenErrors returns one message per failed leaf of the passed
+// jsonschema.ValidationError, prefixed with the location in the metadata
+func flattenErrors(verr *jsonschema.ValidationError) []string {
+	var msgs []string
+	for _, unit := range verr.BasicOutput().Errors {
+		if unit.Error == nil {
+			continue
+		}
+		loc := unit.InstanceLocation
+		if loc == "" {
+			loc = "/"
+		}
+		msgs = append(msgs, fmt.Sprintf("%s: %s", loc, unit.Error.String()))
+	}
+	if len(msgs) == 0 {
+		msgs = append(msgs, verr.Error())
+	}
+	return msgs
+}
@@ -0,0 +1,142 @@
+package metadataschema
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/datatypes"
+
+	oidfed "github.com/go-oidfed/lib"
+
+	"github.com/go-oidfed/lighthouse/storage/model"
+)
+
+type mockSchemaStore struct {
+	schemas map[string]model.MetadataSchema
+}
+
+func (m *mockSchemaStore) List() ([]model.MetadataSchema, error) {
+	var items []model.MetadataSchema
+	for _, s := range m.schemas {
+		items = append(items, s)
+	}
+	return items, nil
+}
+
+func (m *mockSchemaStore) Get(entityType string) (*model.MetadataSchema, error) {
+	s, ok := m.schemas[entityType]
+	if !ok {
+		return nil, model.NotFoundError("metadata schema not found")
+	}
+	return &s, nil
+}
+
+func (m *mockSchemaStore) Put(entityType string, req model.AddMetadataSchema) (*model.MetadataSchema, error) {
+	s := model.MetadataSchema{
+		EntityType:  entityType,
+		Description: req.Description,
+		Schema:      req.Schema,
+	}
+	m.schemas[entityType] = s
+	return &s, nil
+}
+
+func (m *mockSchemaStore) Delete(entityType string) error {
+	delete(m.schemas, entityType)
+	return nil
+}
+
+func TestBuiltinSchemasCompile(t *testing.T) {
+	types := BuiltinEntityTypes()
+	assert.Contains(t, types, "openid_provider")
+	assert.Contains(t, types, "openid_relying_party")
+	assert.Contains(t, types, "oauth_authorization_server")
+	assert.Contains(t, types, "federation_entity")
+	for _, et := range types {
+		raw, ok := BuiltinSchema(et)
+		require.True(t, ok, et)
+		_, err := Compile(raw)
+		assert.NoError(t, err, et)
+	}
+}
+
+func TestValidator_Builtin(t *testing.T) {
+	v := NewValidator(nil)
+
+	valid := &oidfed.Metadata{
+		OpenIDProvider: &oidfed.OpenIDProviderMetadata{
+			Issuer:                 "https://op.example.org",
+			AuthorizationEndpoint:  "https://op.example.org/authorize",
+			ResponseTypesSupported: []string{"code"},
+		},
+	}
+	assert.NoError(t, v.Validate(valid))
+
+	err := v.ValidateEntityType(
+		"openid_provider", map[string]any{
+			"issuer":                   "not a uri",
+			"response_types_supported": "code",
+		},
+	)
+	require.Error(t, err)
+	verr, ok := err.(*ValidationError)
+	require.True(t, ok)
+	assert.Equal(t, "openid_provider", verr.EntityType)
+	assert.Len(t, verr.Errors, 2)
+	assert.Contains(t, err.Error(), "/issuer")
+	assert.Contains(t, err.Error(), "/response_types_supported")
+}
+
+func TestValidator_UnknownEntityTypeNotValidated(t *testing.T) {
+	v := NewValidator(nil)
+	assert.NoError(t, v.ValidateEntityType("custom_type", map[string]any{"anything": 1}))
+}
+
+func TestValidator_CustomSchemaTakesPrecedence(t *testing.T) {
+	store := &mockSchemaStore{schemas: map[string]model.MetadataSchema{}}
+	v := NewValidator(store)
+
+	metadata := map[string]any{
+		"openid_provider": map[string]any{"issuer": "https://op.example.org"},
+		"custom_type":     map[string]any{"foo": "bar"},
+	}
+	assert.NoError(t, v.Validate(metadata))
+
+	_, err := store.Put(
+		"custom_type", model.AddMetadataSchema{
+			Schema: datatypes.JSON(`{"type":"object","required":["baz"]}`),
+		},
+	)
+	require.NoError(t, err)
+	err = v.Validate(metadata)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "custom_type")
+
+	// Only validate the passed entity types
+	assert.NoError(t, v.Validate(metadata, "openid_provider"))
+
+	// Changing the stored schema is picked up
+	_, err = store.Put(
+		"custom_type", model.AddMetadataSchema{
+			Schema: datatypes.JSON(`{"type":"object","required":["foo"]}`),
+		},
+	)
+	require.NoError(t, err)
+	assert.NoError(t, v.Validate(metadata))
+
+	raw, source, err := v.Schema("openid_provider")
+	require.NoError(t, err)
+	assert.Equal(t, SourceBuiltin, source)
+	assert.NotEmpty(t, raw)
+	_, source, err = v.Schema("custom_type")
+	require.NoError(t, err)
+	assert.Equal(t, SourceCustom, source)
+}
+
+func TestCompile_Invalid(t *testing.T) {
+	_, err := Compile([]byte(`not json`))
+	assert.Error(t, err)
+	_, err = Compile([]byte(`{"type":"no-such-type"}`))
+	assert.Error(t, err)
+}
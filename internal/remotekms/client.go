@@ -0,0 +1,123 @@
+// Package remotekms implements a key management system that delegates signing
+// to a remote signing service. Private keys never leave the signing service;
+// LightHouse only holds the public keys, which are synced into a
+// public.PublicKeyStorage.
+package remotekms
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/asn1"
+	"math/big"
+	"time"
+
+	"github.com/lestrrat-go/jwx/v4/jwa"
+	"github.com/pkg/errors"
+)
+
+// Key is a signing key held by a remote signing service
+type Key struct {
+	// KID is the key id under which the public key is published
+	KID string
+	// Alg is the signature algorithm the key is used with; if empty, the
+	// algorithm is derived from the KMS configuration
+	Alg jwa.SignatureAlgorithm
+	// PublicKey is the public part of the key
+	PublicKey crypto.PublicKey
+	// CreatedAt is the time the key was created at the signing service
+	CreatedAt time.Time
+	// ref is an opaque, client-specific reference used for signing, e.g. a
+	// key version
+	ref string
+}
+
+// Client is the interface to a remote signing service
+type Client interface {
+	// Keys returns all keys of the signing service that can be used for
+	// signing
+	Keys(ctx context.Context) ([]Key, error)
+	// Sign signs digest with the passed key. For EdDSA the digest is the
+	// message itself. The signature must be returned in the format of
+	// crypto.Signer, i.e. ASN.1 DER encoded for ECDSA.
+	Sign(ctx context.Context, key Key, alg jwa.SignatureAlgorithm, digest []byte) ([]byte, error)
+	// Rotate creates a new key for the passed algorithm at the signing
+	// service and returns it
+	Rotate(ctx context.Context, alg jwa.SignatureAlgorithm) (Key, error)
+}
+
+// hashForAlg returns the hash function used with alg; crypto.Hash(0) for
+// EdDSA
+func hashForAlg(alg jwa.SignatureAlgorithm) (crypto.Hash, error) {
+	switch alg {
+	case jwa.RS256(), jwa.PS256(), jwa.ES256():
+		return crypto.SHA256, nil
+	case jwa.RS384(), jwa.PS384(), jwa.ES384():
+		return crypto.SHA384, nil
+	case jwa.RS512(), jwa.PS512(), jwa.ES512():
+		return crypto.SHA512, nil
+	case jwa.EdDSA():
+		return crypto.Hash(0), nil
+	default:
+		return 0, errors.Errorf("unsupported signing algorithm '%s'", alg)
+	}
+}
+
+// isPSS reports whether alg is an RSA-PSS algorithm
+func isPSS(alg jwa.SignatureAlgorithm) bool {
+	switch alg {
+	case jwa.PS256(), jwa.PS384(), jwa.PS512():
+		return true
+	default:
+		return false
+	}
+}
+
+// isRSA reports whether alg is an RSA algorithm
+func isRSA(alg jwa.SignatureAlgorithm) bool {
+	switch alg {
+	case jwa.RS256(), jwa.RS384(), jwa.RS512(), jwa.PS256(), jwa.PS384(), jwa.PS512():
+		return true
+	default:
+		return false
+	}
+}
+
+// keyMatchesAlg reports whether the passed public key can be used with alg
+func keyMatchesAlg(pub crypto.PublicKey, alg jwa.SignatureAlgorithm) bool {
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		return isRSA(alg)
+	case *ecdsa.PublicKey:
+		switch k.Curve.Params().Name {
+		case "P-256":
+			return alg == jwa.ES256()
+		case "P-384":
+			return alg == jwa.ES384()
+		case "P-521":
+			return alg == jwa.ES512()
+		}
+	case ed25519.PublicKey:
+		return alg == jwa.EdDSA()
+	}
+	return false
+}
+
+// ecdsaRawToASN1 converts an ECDSA signature in the JWS format (r || s) to
+// ASN.1 DER as returned by crypto.Signer
+func ecdsaRawToASN1(sig []byte) ([]byte, error) {
+	if len(sig) == 0 || len(sig)%2 != 0 {
+		return nil, errors.New("invalid ecdsa signature length")
+	}
+	n := len(sig) / 2
+	return asn1.Marshal(
+		struct {
+			R, S *big.Int
+		}{
+			R: new(big.Int).SetBytes(sig[:n]),
+			S: new(big.Int).SetBytes(sig[n:]),
+		},
+	)
+}
@@ -0,0 +1,84 @@
+package remotekms
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/pkg/errors"
+)
+
+// maxResponseSize limits the size of responses read from the signing service
+const maxResponseSize = 1 << 20
+
+// StatusError is returned if the signing service responds with a non-2xx
+// status code
+type StatusError struct {
+	StatusCode int
+	Method     string
+	Path       string
+	Body       string
+}
+
+// Error implements the error interface
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("signing service returned status %d for %s %s: %s", e.StatusCode, e.Method, e.Path, e.Body)
+}
+
+// httpDoer sends JSON requests to a signing service
+type httpDoer struct {
+	client  *http.Client
+	headers map[string]string
+}
+
+// doJSON sends a request with the passed body encoded as JSON and decodes a
+// JSON response into out. Non-2xx responses are returned as errors.
+func (d httpDoer) doJSON(ctx context.Context, method, url string, body, out any) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, reader)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Accept", "application/json")
+	for k, v := range d.headers {
+		req.Header.Set(k, v)
+	}
+	client := d.client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "signing service request failed")
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return errors.Wrap(err, "failed to read signing service response")
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return &StatusError{
+			StatusCode: resp.StatusCode,
+			Method:     method,
+			Path:       req.URL.Path,
+			Body:       string(bytes.TrimSpace(data)),
+		}
+	}
+	if out == nil || len(data) == 0 {
+		return nil
+	}
+	return errors.Wrap(json.Unmarshal(data, out), "invalid signing service response")
+}
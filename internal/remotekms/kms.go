@@ -0,0 +1,750 @@
+package remotekms
+
+import (
+	"cmp"
+	"context"
+	"crypto"
+	"io"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/go-oidfed/lib/jwx"
+	"github.com/go-oidfed/lib/jwx/keymanagement/kms"
+	"github.com/go-oidfed/lib/jwx/keymanagement/public"
+	"github.com/go-oidfed/lib/unixtime"
+	"github.com/lestrrat-go/jwx/v4/jwa"
+	"github.com/lestrrat-go/jwx/v4/jwk"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+)
+
+// DefaultSyncInterval is the default interval in which the keys of the signing
+// service are synced
+const DefaultSyncInterval = 15 * time.Minute
+
+// Config configures a KMS
+type Config struct {
+	kms.KMSConfig
+	// Timeout is the timeout for a single request to the signing service;
+	// 0 means no timeout
+	Timeout time.Duration
+	// SyncInterval is the interval in which the keys of the signing service
+	// are synced, so keys rotated outside LightHouse are picked up, also if
+	// automatic rotation is disabled; defaults to DefaultSyncInterval
+	SyncInterval time.Duration
+}
+
+// KMS is a kms.KeyManagementSystem that delegates signing to a remote signing
+// service. It uses a single signature algorithm. The public keys of the
+// signing service are synced into the public key storage; keys that appear at
+// the signing service while another key is active are announced for the key
+// announcement lead time before they are used. Rotation creates a new key at
+// the signing service.
+type KMS struct {
+	Config
+	PKs    public.PublicKeyStorage
+	client Client
+
+	mu      sync.RWMutex
+	signers map[string]*signer
+	// rotateMu serializes syncs and rotations and guards changes of
+	// GenerateKeys and KeyRotation
+	rotateMu sync.Mutex
+
+	rotationStop chan struct{}
+	rotationWG   sync.WaitGroup
+	syncStop     chan struct{}
+	syncWG       sync.WaitGroup
+}
+
+// NewSingleAlgKMS returns a new KMS using the passed Client and algorithm
+func NewSingleAlgKMS(
+	alg jwa.SignatureAlgorithm, conf Config, client Client, pks public.PublicKeyStorage,
+) *KMS {
+	conf.Algs = []jwa.SignatureAlgorithm{alg}
+	conf.DefaultAlg = alg
+	if conf.SyncInterval <= 0 {
+		conf.SyncInterval = DefaultSyncInterval
+	}
+	return &KMS{
+		Config:  conf,
+		PKs:     pks,
+		client:  client,
+		signers: make(map[string]*signer),
+	}
+}
+
+var errAlgChangeUnsupported = errors.New(
+	"remote kms: the signing algorithm cannot be changed at runtime; it is defined by the signing service keys",
+)
+
+// signer is a jwx.SigningKey and crypto.Signer for a key of the signing
+// service
+type signer struct {
+	kms *KMS
+	key Key
+	alg jwa.SignatureAlgorithm
+}
+
+// Public implements the crypto.Signer interface
+func (s *signer) Public() crypto.PublicKey {
+	return s.key.PublicKey
+}
+
+// KID returns the kid of the key; it is used for the kid header
+func (s *signer) KID() string {
+	return s.key.KID
+}
+
+// Sign implements the crypto.Signer interface
+func (s *signer) Sign(_ io.Reader, digest []byte, _ crypto.SignerOpts) ([]byte, error) {
+	ctx, cancel := s.kms.context()
+	defer cancel()
+	sig, err := s.kms.client.Sign(ctx, s.key, s.alg, digest)
+	return sig, errors.Wrapf(err, "remote kms: failed to sign with key '%s'", s.key.KID)
+}
+
+func (k *KMS) context() (context.Context, context.CancelFunc) {
+	if k.Timeout > 0 {
+		return context.WithTimeout(context.Background(), k.Timeout)
+	}
+	return context.WithCancel(context.Background())
+}
+
+func (k *KMS) alg() jwa.SignatureAlgorithm {
+	return k.Algs[0]
+}
+
+// GetDefaultAlg implements the kms.BasicKeyManagementSystem interface
+func (k *KMS) GetDefaultAlg() jwa.SignatureAlgorithm {
+	return k.DefaultAlg
+}
+
+// GetAlgs implements the kms.BasicKeyManagementSystem interface
+func (k *KMS) GetAlgs() []jwa.SignatureAlgorithm {
+	return k.Algs
+}
+
+// GetDefault implements the kms.BasicKeyManagementSystem interface
+func (k *KMS) GetDefault() (jwx.SigningKey, jwa.SignatureAlgorithm) {
+	return k.GetForAlgs(k.DefaultAlg.String())
+}
+
+// GetForAlgs implements the kms.BasicKeyManagementSystem interface. Of the
+// active keys, the one that became active last is used.
+func (k *KMS) GetForAlgs(algs ...string) (jwx.SigningKey, jwa.SignatureAlgorithm) {
+	alg := k.alg()
+	if !slices.Contains(algs, alg.String()) {
+		return nil, jwa.SignatureAlgorithm{}
+	}
+	active, err := k.PKs.GetActive()
+	if err != nil {
+		log.Error().Err(err).Msg("remote KMS: failed to get active public keys")
+		return nil, jwa.SignatureAlgorithm{}
+	}
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	var best *public.PublicKeyEntry
+	for _, pk := range active.ByAlg()[alg] {
+		if _, ok := k.signers[pk.KID]; !ok {
+			continue
+		}
+		if best == nil || nbfOf(pk).After(nbfOf(*best)) {
+			best = &pk
+		}
+	}
+	if best == nil {
+		log.Debug().Str("alg", alg.String()).Msg("remote KMS: no usable key found")
+		return nil, jwa.SignatureAlgorithm{}
+	}
+	return k.signers[best.KID], alg
+}
+
+func nbfOf(pk public.PublicKeyEntry) time.Time {
+	if pk.NotBefore != nil {
+		return pk.NotBefore.Time
+	}
+	if pk.IssuedAt != nil {
+		return pk.IssuedAt.Time
+	}
+	return time.Time{}
+}
+
+// Load implements the kms.BasicKeyManagementSystem interface. It syncs the
+// keys of the signing service and creates a key if there is none and key
+// generation is enabled.
+func (k *KMS) Load() error {
+	k.rotateMu.Lock()
+	defer k.rotateMu.Unlock()
+	added, err := k.sync()
+	if err != nil {
+		return err
+	}
+	if len(added) > 0 {
+		k.fireHooks(added, nil, false, "")
+	}
+	if k.hasUsableKey() {
+		return nil
+	}
+	if !k.GenerateKeys {
+		return errors.Errorf(
+			"remote kms: no signing key for alg '%s' at the signing service. Enable key generation or provision keys",
+			k.alg(),
+		)
+	}
+	pke, err := k.createKey(time.Now())
+	if err != nil {
+		return err
+	}
+	k.fireHooks([]string{pke.KID}, nil, false, "")
+	return nil
+}
+
+// hasUsableKey reports whether there is a valid key with a signer
+func (k *KMS) hasUsableKey() bool {
+	valid, err := k.PKs.GetValid()
+	if err != nil {
+		return false
+	}
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	for _, pk := range valid.ByAlg()[k.alg()] {
+		if _, ok := k.signers[pk.KID]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// sync reads the keys of the signing service, registers a signer for each
+// key usable with the configured algorithm and adds keys that are not yet
+// known to the public key storage. It returns the kids of added keys.
+// The caller must hold rotateMu.
+func (k *KMS) sync() ([]string, error) {
+	ctx, cancel := k.context()
+	defer cancel()
+	keys, err := k.client.Keys(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "remote kms: failed to list keys")
+	}
+	alg := k.alg()
+	signers := make(map[string]*signer, len(keys))
+	var unknown []Key
+	for _, key := range keys {
+		if key.Alg.String() != "" && key.Alg.String() != alg.String() {
+			continue
+		}
+		if !keyMatchesAlg(key.PublicKey, alg) {
+			log.Debug().Str("kid", key.KID).Str("alg", alg.String()).
+				Msg("remote KMS: skipping key not usable with the configured algorithm")
+			continue
+		}
+		signers[key.KID] = &signer{
+			kms: k,
+			key: key,
+			alg: alg,
+		}
+		existing, err := k.PKs.Get(key.KID)
+		if err != nil {
+			return nil, err
+		}
+		if existing == nil {
+			unknown = append(unknown, key)
+		}
+	}
+	k.mu.Lock()
+	k.signers = signers
+	k.mu.Unlock()
+
+	active, err := k.PKs.GetActive()
+	if err != nil {
+		return nil, err
+	}
+	hasActive := len(active.ByAlg()[alg]) > 0
+	var added []string
+	for i, key := range unknown {
+		if !hasActive && i < len(unknown)-1 {
+			// Without an active key, all but the newest of several unknown
+			// keys are superseded right away and only recorded as expired.
+			if _, err = k.addPublicKey(key, time.Now(), new(unixtime.Now())); err != nil {
+				return added, err
+			}
+			log.Info().Str("kid", key.KID).Msg("remote KMS: recorded superseded key from signing service")
+			continue
+		}
+		nbf, err := k.announcementTime(time.Now())
+		if err != nil {
+			return added, err
+		}
+		pke, err := k.addPublicKey(key, nbf, nil)
+		if err != nil {
+			return added, err
+		}
+		log.Info().Str("kid", key.KID).Time("nbf", nbf).Msg("remote KMS: added key from signing service")
+		added = append(added, pke.KID)
+	}
+	return added, nil
+}
+
+// announcementTime returns the NotBefore for a key that is added while other
+// keys may be active: now, if there is no active key, otherwise
+// now + key announcement lead time, shortening the expiration of the active
+// keys accordingly.
+func (k *KMS) announcementTime(now time.Time) (time.Time, error) {
+	active, err := k.PKs.GetActive()
+	if err != nil {
+		return now, err
+	}
+	algPKs := active.ByAlg()[k.alg()]
+	if len(algPKs) == 0 {
+		return now, nil
+	}
+	leadTime, err := k.KeyRotation.KeyAnnouncementLeadTimeDuration()
+	if err != nil {
+		return now, err
+	}
+	nbf := now.Add(leadTime)
+	k.limitExpiration(algPKs, nbf, false, "")
+	return nbf, nil
+}
+
+// limitExpiration sets the expiration of the passed keys to nbf + overlap if
+// they would expire later, and optionally revokes them
+func (k *KMS) limitExpiration(pks public.PublicKeyEntryList, nbf time.Time, revoked bool, reason string) {
+	exp := &unixtime.Unixtime{Time: nbf.Add(k.KeyRotation.Overlap.Duration())}
+	for _, pk := range pks {
+		if revoked {
+			pk.RevokedAt = new(unixtime.Now())
+			pk.Reason = reason
+		}
+		if pk.ExpiresAt == nil || pk.ExpiresAt.IsZero() || exp.Before(pk.ExpiresAt.Time) {
+			pk.ExpiresAt = exp
+		}
+		if err := k.PKs.Update(pk.KID, pk.UpdateablePublicKeyMetadata); err != nil {
+			log.Error().Err(err).Str("kid", pk.KID).Msg("remote KMS: failed to update key")
+		}
+	}
+}
+
+// addPublicKey adds the public key of a signing service key to the public key
+// storage. If exp is nil, the expiration is derived from the rotation config.
+func (k *KMS) addPublicKey(key Key, nbf time.Time, exp *unixtime.Unixtime) (*public.PublicKeyEntry, error) {
+	pk, err := jwk.Import[jwk.Key](key.PublicKey)
+	if err != nil {
+		return nil, errors.Wrapf(err, "remote kms: invalid public key '%s'", key.KID)
+	}
+	if err = pk.Set(jwk.KeyIDKey, key.KID); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if err = pk.Set(jwk.AlgorithmKey, k.alg()); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if err = pk.Set(jwk.KeyUsageKey, jwk.ForSignature); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	now := unixtime.Now()
+	if exp == nil && k.KeyRotation.Enabled {
+		exp = &unixtime.Unixtime{Time: nbf.Add(k.KeyRotation.Interval.Duration())}
+	}
+	pke := public.PublicKeyEntry{
+		KID:       key.KID,
+		Key:       public.JWKKey{Key: pk},
+		IssuedAt:  &now,
+		NotBefore: &unixtime.Unixtime{Time: nbf},
+		UpdateablePublicKeyMetadata: public.UpdateablePublicKeyMetadata{
+			ExpiresAt: exp,
+		},
+	}
+	if err = k.PKs.Add(pke); err != nil {
+		return nil, err
+	}
+	return &pke, nil
+}
+
+// createKey creates a new key at the signing service and adds it with the
+// passed NotBefore. The caller must hold rotateMu.
+func (k *KMS) createKey(nbf time.Time) (*public.PublicKeyEntry, error) {
+	ctx, cancel := k.context()
+	defer cancel()
+	key, err := k.client.Rotate(ctx, k.alg())
+	if err != nil {
+		return nil, errors.Wrap(err, "remote kms: failed to create key")
+	}
+	if !keyMatchesAlg(key.PublicKey, k.alg()) {
+		return nil, errors.Errorf(
+			"remote kms: key '%s' created by the signing service cannot be used with alg '%s'", key.KID, k.alg(),
+		)
+	}
+	k.mu.Lock()
+	k.signers[key.KID] = &signer{
+		kms: k,
+		key: key,
+		alg: k.alg(),
+	}
+	k.mu.Unlock()
+	if existing, err := k.PKs.Get(key.KID); err != nil {
+		return nil, err
+	} else if existing != nil {
+		return existing, nil
+	}
+	return k.addPublicKey(key, nbf, nil)
+}
+
+// rotateKeys replaces the passed keys with a new key of the signing service.
+// The caller must hold rotateMu.
+func (k *KMS) rotateKeys(kids []string, revoked bool, reason string) error {
+	log.Info().Strs("kids", kids).Bool("revoked", revoked).Msg("remote KMS: rotation: start")
+	pks := make(public.PublicKeyEntryList, 0, len(kids))
+	var latestExp time.Time
+	for _, kid := range kids {
+		pk, err := k.PKs.Get(kid)
+		if err != nil {
+			return err
+		}
+		if pk == nil {
+			return public.NotFoundError{KID: kid}
+		}
+		pks = append(pks, *pk)
+		if pk.ExpiresAt != nil && pk.ExpiresAt.After(latestExp) {
+			latestExp = pk.ExpiresAt.Time
+		}
+	}
+	now := time.Now()
+	nbf := now
+	if !revoked {
+		if leadTime, err := k.KeyRotation.KeyAnnouncementLeadTimeDuration(); err == nil {
+			// Avoid gaps: if the new key would only become valid after the
+			// current keys expired, activate it immediately.
+			if next := now.Add(leadTime); !next.After(latestExp) {
+				nbf = next
+			}
+		}
+	}
+	pke, err := k.createKey(nbf)
+	if err != nil {
+		return err
+	}
+	k.limitExpiration(pks, pke.NotBefore.Time, revoked, reason)
+	log.Info().Str("new_kid", pke.KID).Time("nbf", pke.NotBefore.Time).Msg("remote KMS: rotation: completed")
+	k.fireHooks([]string{pke.KID}, kids, revoked, reason)
+	return nil
+}
+
+// RotateKey implements the kms.KeyManagementSystem interface
+func (k *KMS) RotateKey(kid string, revoked bool, reason string) error {
+	k.rotateMu.Lock()
+	defer k.rotateMu.Unlock()
+	return k.rotateKeys([]string{kid}, revoked, reason)
+}
+
+// RotateAllKeys implements the kms.KeyManagementSystem interface
+func (k *KMS) RotateAllKeys(revoked bool, reason string) error {
+	k.rotateMu.Lock()
+	defer k.rotateMu.Unlock()
+	active, err := k.PKs.GetActive()
+	if err != nil {
+		return err
+	}
+	algPKs := active.ByAlg()[k.alg()]
+	if len(algPKs) == 0 {
+		pke, err := k.createKey(time.Now())
+		if err != nil {
+			return err
+		}
+		k.fireHooks([]string{pke.KID}, nil, false, "")
+		return nil
+	}
+	kids := make([]string, len(algPKs))
+	for i, pk := range algPKs {
+		kids[i] = pk.KID
+	}
+	return k.rotateKeys(kids, revoked, reason)
+}
+
+// StartAutomaticRotation implements the kms.KeyManagementSystem interface.
+// Before each rotation check, the loop syncs the keys of the signing service.
+func (k *KMS) StartAutomaticRotation() error {
+	k.rotateMu.Lock()
+	enabled := k.KeyRotation.Enabled
+	k.rotateMu.Unlock()
+	if !enabled || k.rotationStop != nil {
+		return nil
+	}
+	log.Info().Msg("remote KMS: automatic rotation: starting")
+	stop := make(chan struct{})
+	k.rotationStop = stop
+	k.rotationWG.Go(
+		func() {
+			for {
+				nextSleep := k.rotationStep(time.Now())
+				timer := time.NewTimer(nextSleep)
+				select {
+				case <-stop:
+					timer.Stop()
+					return
+				case <-timer.C:
+				}
+			}
+		},
+	)
+	return nil
+}
+
+// StopAutomaticRotation implements the kms.KeyManagementSystem interface
+func (k *KMS) StopAutomaticRotation() {
+	if k.rotationStop == nil {
+		return
+	}
+	close(k.rotationStop)
+	k.rotationWG.Wait()
+	k.rotationStop = nil
+	log.Info().Msg("remote KMS: automatic rotation: stopped")
+}
+
+// StartSync starts syncing the keys of the signing service every
+// SyncInterval in the background, independent of the automatic rotation.
+func (k *KMS) StartSync() {
+	if k.syncStop != nil {
+		return
+	}
+	stop := make(chan struct{})
+	k.syncStop = stop
+	k.syncWG.Go(
+		func() {
+			ticker := time.NewTicker(k.SyncInterval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-stop:
+					return
+				case <-ticker.C:
+					k.syncStep()
+				}
+			}
+		},
+	)
+	log.Info().Dur("interval", k.SyncInterval).Msg("remote KMS: key sync: started")
+}
+
+// StopSync stops the background sync started with StartSync
+func (k *KMS) StopSync() {
+	if k.syncStop == nil {
+		return
+	}
+	close(k.syncStop)
+	k.syncWG.Wait()
+	k.syncStop = nil
+	log.Info().Msg("remote KMS: key sync: stopped")
+}
+
+// Close stops the background sync and the automatic rotation
+func (k *KMS) Close() error {
+	k.StopSync()
+	k.StopAutomaticRotation()
+	return nil
+}
+
+// syncStep syncs the keys of the signing service and fires the hooks for
+// added keys
+func (k *KMS) syncStep() {
+	k.rotateMu.Lock()
+	defer k.rotateMu.Unlock()
+	added, err := k.sync()
+	if err != nil {
+		log.Error().Err(err).Msg("remote KMS: key sync failed")
+		return
+	}
+	if len(added) > 0 {
+		k.fireHooks(added, nil, false, "")
+	}
+}
+
+// rotationStep syncs the keys of the signing service, rotates the keys if
+// the current key is about to expire and returns the time to sleep until
+// the next step
+func (k *KMS) rotationStep(now time.Time) time.Duration {
+	const minSleep = time.Second
+	k.rotateMu.Lock()
+	defer k.rotateMu.Unlock()
+
+	nextSleep := k.SyncInterval
+	if added, err := k.sync(); err != nil {
+		log.Error().Err(err).Msg("remote KMS: automatic rotation: sync failed")
+		return nextSleep
+	} else if len(added) > 0 {
+		k.fireHooks(added, nil, false, "")
+	}
+
+	valid, err := k.PKs.GetValid()
+	if err != nil {
+		log.Error().Err(err).Msg("remote KMS: automatic rotation: failed to get valid public keys")
+		return nextSleep
+	}
+	var active public.PublicKeyEntryList
+	var futureNbf time.Time
+	for _, pk := range valid.ByAlg()[k.alg()] {
+		if nbf := nbfOf(pk); nbf.After(now) {
+			if futureNbf.IsZero() || nbf.Before(futureNbf) {
+				futureNbf = nbf
+			}
+			continue
+		}
+		active = append(active, pk)
+	}
+	if len(active) == 0 {
+		if !futureNbf.IsZero() {
+			return min(nextSleep, max(futureNbf.Sub(now), minSleep))
+		}
+		pke, err := k.createKey(now)
+		if err != nil {
+			log.Error().Err(err).Msg("remote KMS: automatic rotation: failed to create key")
+			return min(nextSleep, time.Minute)
+		}
+		k.fireHooks([]string{pke.KID}, nil, false, "")
+		return minSleep
+	}
+
+	current := slices.MaxFunc(
+		active, func(a, b public.PublicKeyEntry) int {
+			return cmp.Compare(expOf(a), expOf(b))
+		},
+	)
+	leadTime, err := k.KeyRotation.KeyAnnouncementLeadTimeDuration()
+	if err != nil {
+		log.Warn().Err(err).Msg("remote KMS: automatic rotation: failed to get key announcement lead time")
+	}
+	if current.ExpiresAt == nil || current.ExpiresAt.IsZero() {
+		current.ExpiresAt = &unixtime.Unixtime{Time: now.Add(leadTime).Add(k.KeyRotation.Overlap.Duration())}
+		if err = k.PKs.Update(current.KID, current.UpdateablePublicKeyMetadata); err != nil {
+			log.Error().Err(err).Msg("remote KMS: automatic rotation: failed to update key expiration")
+		}
+	}
+	threshold := current.ExpiresAt.Add(-k.KeyRotation.Overlap.Duration()).Add(-leadTime)
+	if threshold.After(now) {
+		return min(nextSleep, max(threshold.Sub(now), minSleep))
+	}
+	if !futureNbf.IsZero() {
+		k.limitExpiration(active, futureNbf, false, "")
+		return min(nextSleep, max(futureNbf.Sub(now), minSleep))
+	}
+	kids := make([]string, len(active))
+	for i, pk := range active {
+		kids[i] = pk.KID
+	}
+	if err = k.rotateKeys(kids, false, ""); err != nil {
+		log.Error().Err(err).Msg("remote KMS: automatic rotation: rotate failed")
+		return min(nextSleep, time.Minute)
+	}
+	return minSleep
+}
+
+func expOf(pk public.PublicKeyEntry) int64 {
+	if pk.ExpiresAt == nil {
+		return 0
+	}
+	return pk.ExpiresAt.UnixNano()
+}
+
+// fireHooks dispatches a kms.KeyRotationEvent to the configured hooks
+func (k *KMS) fireHooks(added, rotated []string, revoked bool, reason string) {
+	if len(k.KeyRotation.Hooks) == 0 {
+		return
+	}
+	valid, err := k.PKs.GetValid()
+	if err != nil {
+		log.Error().Err(err).Msg("remote KMS: key rotation hooks: failed to get valid public keys")
+		return
+	}
+	jwks, err := valid.JWKS()
+	if err != nil {
+		log.Error().Err(err).Msg("remote KMS: key rotation hooks: failed to build jwks")
+		return
+	}
+	event := kms.KeyRotationEvent{
+		EntityID:    k.EntityID,
+		NewJWKS:     jwks,
+		AddedKIDs:   added,
+		RotatedKIDs: rotated,
+		Revoked:     revoked,
+		Reason:      reason,
+	}
+	for _, hook := range k.KeyRotation.Hooks {
+		go func(h kms.KeyRotationHook) {
+			defer func() {
+				if r := recover(); r != nil {
+					log.Error().Interface("panic", r).Msg("remote KMS: key rotation hook panicked")
+				}
+			}()
+			if err := h(context.Background(), event); err != nil {
+				log.Error().Err(err).Msg("remote KMS: key rotation hook failed")
+			}
+		}(hook)
+	}
+}
+
+// ChangeGenerateKeys implements the kms.KeyManagementSystem interface
+func (k *KMS) ChangeGenerateKeys(generate bool) error {
+	k.rotateMu.Lock()
+	k.GenerateKeys = generate
+	k.rotateMu.Unlock()
+	if generate {
+		return k.Load()
+	}
+	return nil
+}
+
+// ChangeAlgs implements the kms.KeyManagementSystem interface; only the
+// configured algorithm is accepted
+func (k *KMS) ChangeAlgs(algs []jwa.SignatureAlgorithm) error {
+	if len(algs) == 1 && algs[0].String() == k.alg().String() {
+		return nil
+	}
+	return errAlgChangeUnsupported
+}
+
+// ChangeAlgsAt implements the kms.KeyManagementSystem interface; only the
+// configured algorithm is accepted
+func (k *KMS) ChangeAlgsAt(algs []jwa.SignatureAlgorithm, _ unixtime.Unixtime, _ time.Duration) error {
+	return k.ChangeAlgs(algs)
+}
+
+// ChangeDefaultAlgorithm implements the kms.KeyManagementSystem interface;
+// only the configured algorithm is accepted
+func (k *KMS) ChangeDefaultAlgorithm(alg jwa.SignatureAlgorithm) error {
+	return k.ChangeAlgs([]jwa.SignatureAlgorithm{alg})
+}
+
+// ChangeDefaultAlgorithmAt implements the kms.KeyManagementSystem interface;
+// only the configured algorithm is accepted
+func (k *KMS) ChangeDefaultAlgorithmAt(alg jwa.SignatureAlgorithm, _ unixtime.Unixtime) error {
+	return k.ChangeDefaultAlgorithm(alg)
+}
+
+// ChangeRSAKeyLength implements the kms.KeyManagementSystem interface. The
+// key length of remote keys is defined by the signing service.
+func (*KMS) ChangeRSAKeyLength(int) error {
+	return errors.New("remote kms: the rsa key length is defined by the signing service")
+}
+
+// ChangeKeyRotationConfig implements the kms.KeyManagementSystem interface
+func (k *KMS) ChangeKeyRotationConfig(config kms.KeyRotationConfig) error {
+	// The rotation loop is stopped before the config is changed; it takes
+	// rotateMu in each step, so it cannot be stopped while holding it.
+	k.StopAutomaticRotation()
+	k.rotateMu.Lock()
+	k.KeyRotation = config
+	k.rotateMu.Unlock()
+	if config.Enabled {
+		return k.StartAutomaticRotation()
+	}
+	return nil
+}
+
+// GetPendingChanges implements the kms.KeyManagementSystem interface; the
+// remote KMS does not support scheduled algorithm changes
+func (*KMS) GetPendingChanges() (*kms.PendingAlgChange, *kms.PendingDefaultChange) {
+	return nil, nil
+}
+
+var _ kms.KeyManagementSystem = (*KMS)(nil)
@@ -0,0 +1,528 @@
+package remotekms
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-oidfed/lib/jwx"
+	"github.com/go-oidfed/lib/jwx/keymanagement/kms"
+	"github.com/go-oidfed/lib/jwx/keymanagement/public"
+	"github.com/lestrrat-go/jwx/v4/jwa"
+	"github.com/lestrrat-go/jwx/v4/jwk"
+	"github.com/lestrrat-go/jwx/v4/jws"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zachmann/go-utils/duration"
+)
+
+// fakeRESTService is a stand-in for a REST signing service holding ES256
+// keys
+type fakeRESTService struct {
+	mu   sync.Mutex
+	keys []*ecdsa.PrivateKey
+	kids []string
+}
+
+func (s *fakeRESTService) addKey(t *testing.T) string {
+	t.Helper()
+	sk, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys = append(s.keys, sk)
+	kid := fmt.Sprintf("key-%d", len(s.keys))
+	s.kids = append(s.kids, kid)
+	return kid
+}
+
+func (s *fakeRESTService) restKey(i int) map[string]any {
+	pk, _ := jwk.Import[jwk.Key](s.keys[i].Public())
+	return map[string]any{
+		"kid": s.kids[i],
+		"alg": "ES256",
+		"jwk": pk,
+	}
+}
+
+func (s *fakeRESTService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") != "Bearer secret" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/keys":
+		s.mu.Lock()
+		keys := make([]map[string]any, len(s.keys))
+		for i := range s.keys {
+			keys[i] = s.restKey(i)
+		}
+		s.mu.Unlock()
+		_ = json.NewEncoder(w).Encode(map[string]any{"keys": keys})
+	case r.Method == http.MethodPost && r.URL.Path == "/keys":
+		sk, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		s.mu.Lock()
+		s.keys = append(s.keys, sk)
+		s.kids = append(s.kids, fmt.Sprintf("key-%d", len(s.keys)))
+		key := s.restKey(len(s.keys) - 1)
+		s.mu.Unlock()
+		_ = json.NewEncoder(w).Encode(key)
+	case r.Method == http.MethodPost && r.URL.Path == "/sign":
+		var req struct {
+			KID       string `json:"kid"`
+			Input     string `json:"input"`
+			Prehashed bool   `json:"prehashed"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || !req.Prehashed {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		digest, _ := base64.RawURLEncoding.DecodeString(req.Input)
+		s.mu.Lock()
+		var sk *ecdsa.PrivateKey
+		for i, kid := range s.kids {
+			if kid == req.KID {
+				sk = s.keys[i]
+			}
+		}
+		s.mu.Unlock()
+		if sk == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		r, sig, _ := ecdsa.Sign(rand.Reader, sk, digest)
+		raw := make([]byte, 64)
+		r.FillBytes(raw[:32])
+		sig.FillBytes(raw[32:])
+		_ = json.NewEncoder(w).Encode(map[string]string{"signature": base64.RawURLEncoding.EncodeToString(raw)})
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// fakeTransit is a stand-in for the Vault transit secrets engine holding a
+// single ed25519 key
+type fakeTransit struct {
+	mu       sync.Mutex
+	versions []ed25519.PrivateKey
+}
+
+func (f *fakeTransit) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("X-Vault-Token") != "root" {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch {
+	case r.URL.Path == "/v1/transit/keys/lh" && r.Method == http.MethodGet:
+		if len(f.versions) == 0 {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		keys := map[string]any{}
+		for i, sk := range f.versions {
+			keys[strconv.Itoa(i+1)] = map[string]string{
+				"public_key":    base64.StdEncoding.EncodeToString(sk.Public().(ed25519.PublicKey)),
+				"creation_time": time.Now().Format(time.RFC3339Nano),
+			}
+		}
+		_ = json.NewEncoder(w).Encode(
+			map[string]any{
+				"data": map[string]any{
+					"type":           "ed25519",
+					"latest_version": len(f.versions),
+					"keys":           keys,
+				},
+			},
+		)
+	case r.Method == http.MethodPost && (r.URL.Path == "/v1/transit/keys/lh" || r.URL.Path == "/v1/transit/keys/lh/rotate"):
+		_, sk, _ := ed25519.GenerateKey(rand.Reader)
+		f.versions = append(f.versions, sk)
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodPost && r.URL.Path == "/v1/transit/sign/lh":
+		var req struct {
+			Input      string `json:"input"`
+			KeyVersion int    `json:"key_version"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil ||
+			req.KeyVersion < 1 || req.KeyVersion > len(f.versions) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		input, _ := base64.StdEncoding.DecodeString(req.Input)
+		sig := ed25519.Sign(f.versions[req.KeyVersion-1], input)
+		_ = json.NewEncoder(w).Encode(
+			map[string]any{
+				"data": map[string]string{
+					"signature": fmt.Sprintf("vault:v%d:%s", req.KeyVersion, base64.StdEncoding.EncodeToString(sig)),
+				},
+			},
+		)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func newTestPKs(t *testing.T) public.PublicKeyStorage {
+	t.Helper()
+	pks := &public.FilesystemPublicKeyStorage{
+		Dir:    t.TempDir(),
+		TypeID: "federation",
+	}
+	require.NoError(t, pks.Load())
+	return pks
+}
+
+func testRotationConf() kms.KeyRotationConfig {
+	return kms.KeyRotationConfig{
+		Enabled:                 true,
+		Interval:                duration.DurationOption(24 * time.Hour),
+		Overlap:                 duration.DurationOption(time.Hour),
+		KeyAnnouncementLeadTime: duration.DurationOption(2 * time.Hour),
+	}
+}
+
+func signAndVerify(t *testing.T, k *KMS, alg jwa.SignatureAlgorithm) string {
+	t.Helper()
+	key, gotAlg := k.GetDefault()
+	require.NotNil(t, key)
+	assert.Equal(t, alg, gotAlg)
+	payload := []byte(`{"iss":"https://ta.example.com"}`)
+	signed, err := jwx.SignPayload(payload, alg, key, nil)
+	require.NoError(t, err)
+
+	msg, err := jws.Parse(signed)
+	require.NoError(t, err)
+	kid, ok := msg.Signatures()[0].ProtectedHeaders().KeyID()
+	require.True(t, ok)
+	verified, err := jws.Verify(signed, jws.WithKey(alg, key.Public()))
+	require.NoError(t, err)
+	assert.Equal(t, payload, verified)
+	return kid
+}
+
+func TestKMS_REST_LoadCreatesKeyAndSigns(t *testing.T) {
+	svc := &fakeRESTService{}
+	srv := httptest.NewServer(svc)
+	defer srv.Close()
+
+	pks := newTestPKs(t)
+	k := NewSingleAlgKMS(
+		jwa.ES256(), Config{KMSConfig: kms.KMSConfig{GenerateKeys: true}},
+		NewRESTClient(srv.URL, "secret", 5*time.Second), pks,
+	)
+	require.NoError(t, k.Load())
+	require.Len(t, svc.keys, 1)
+
+	kid := signAndVerify(t, k, jwa.ES256())
+	assert.Equal(t, "key-1", kid)
+
+	pk, err := pks.Get(kid)
+	require.NoError(t, err)
+	require.NotNil(t, pk)
+	pkAlg, _ := pk.Key.Algorithm()
+	assert.Equal(t, jwa.ES256().String(), pkAlg.String())
+}
+
+func TestKMS_REST_NoKeysWithoutGeneration(t *testing.T) {
+	srv := httptest.NewServer(&fakeRESTService{})
+	defer srv.Close()
+
+	k := NewSingleAlgKMS(
+		jwa.ES256(), Config{}, NewRESTClient(srv.URL, "secret", 5*time.Second), newTestPKs(t),
+	)
+	assert.Error(t, k.Load())
+}
+
+func TestKMS_REST_Unauthorized(t *testing.T) {
+	srv := httptest.NewServer(&fakeRESTService{})
+	defer srv.Close()
+
+	k := NewSingleAlgKMS(
+		jwa.ES256(), Config{KMSConfig: kms.KMSConfig{GenerateKeys: true}},
+		NewRESTClient(srv.URL, "wrong", 5*time.Second), newTestPKs(t),
+	)
+	err := k.Load()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "401")
+}
+
+func TestKMS_REST_UsesExistingKeys(t *testing.T) {
+	svc := &fakeRESTService{}
+	svc.addKey(t)
+	svc.addKey(t)
+	srv := httptest.NewServer(svc)
+	defer srv.Close()
+
+	pks := newTestPKs(t)
+	k := NewSingleAlgKMS(
+		jwa.ES256(), Config{}, NewRESTClient(srv.URL, "secret", 5*time.Second), pks,
+	)
+	require.NoError(t, k.Load())
+	assert.Len(t, svc.keys, 2)
+	// Only the newest key is used; the older one is recorded as expired
+	assert.Equal(t, "key-2", signAndVerify(t, k, jwa.ES256()))
+	active, err := pks.GetActive()
+	require.NoError(t, err)
+	require.Len(t, active, 1)
+	assert.Equal(t, "key-2", active[0].KID)
+}
+
+func TestKMS_REST_RotateAnnouncesNewKey(t *testing.T) {
+	svc := &fakeRESTService{}
+	srv := httptest.NewServer(svc)
+	defer srv.Close()
+
+	pks := newTestPKs(t)
+	k := NewSingleAlgKMS(
+		jwa.ES256(), Config{
+			KMSConfig: kms.KMSConfig{
+				GenerateKeys: true,
+				KeyRotation:  testRotationConf(),
+			},
+		},
+		NewRESTClient(srv.URL, "secret", 5*time.Second), pks,
+	)
+	require.NoError(t, k.Load())
+	require.NoError(t, k.RotateAllKeys(false, ""))
+	require.Len(t, svc.keys, 2)
+
+	// The old key stays in use until the new key becomes active
+	assert.Equal(t, "key-1", signAndVerify(t, k, jwa.ES256()))
+
+	next, err := pks.Get("key-2")
+	require.NoError(t, err)
+	require.NotNil(t, next)
+	require.NotNil(t, next.NotBefore)
+	assert.WithinDuration(t, time.Now().Add(2*time.Hour), next.NotBefore.Time, time.Minute)
+
+	old, err := pks.Get("key-1")
+	require.NoError(t, err)
+	require.NotNil(t, old.ExpiresAt)
+	assert.WithinDuration(t, next.NotBefore.Add(time.Hour), old.ExpiresAt.Time, time.Minute)
+}
+
+func TestKMS_REST_RevokedRotationActivatesImmediately(t *testing.T) {
+	svc := &fakeRESTService{}
+	srv := httptest.NewServer(svc)
+	defer srv.Close()
+
+	pks := newTestPKs(t)
+	k := NewSingleAlgKMS(
+		jwa.ES256(), Config{
+			KMSConfig: kms.KMSConfig{
+				GenerateKeys: true,
+				KeyRotation:  testRotationConf(),
+			},
+		},
+		NewRESTClient(srv.URL, "secret", 5*time.Second), pks,
+	)
+	require.NoError(t, k.Load())
+	require.NoError(t, k.RotateKey("key-1", true, "compromised"))
+
+	assert.Equal(t, "key-2", signAndVerify(t, k, jwa.ES256()))
+	old, err := pks.Get("key-1")
+	require.NoError(t, err)
+	require.NotNil(t, old.RevokedAt)
+	assert.Equal(t, "compromised", old.Reason)
+}
+
+func TestKMS_REST_SyncPicksUpExternalRotation(t *testing.T) {
+	svc := &fakeRESTService{}
+	srv := httptest.NewServer(svc)
+	defer srv.Close()
+
+	pks := newTestPKs(t)
+	k := NewSingleAlgKMS(
+		jwa.ES256(), Config{
+			KMSConfig: kms.KMSConfig{
+				GenerateKeys: true,
+				KeyRotation:  testRotationConf(),
+			},
+		},
+		NewRESTClient(srv.URL, "secret", 5*time.Second), pks,
+	)
+	require.NoError(t, k.Load())
+	svc.addKey(t)
+
+	k.rotateMu.Lock()
+	added, err := k.sync()
+	k.rotateMu.Unlock()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"key-2"}, added)
+
+	next, err := pks.Get("key-2")
+	require.NoError(t, err)
+	require.NotNil(t, next)
+	assert.True(t, next.NotBefore.After(time.Now().Add(time.Hour)))
+	assert.Equal(t, "key-1", signAndVerify(t, k, jwa.ES256()))
+}
+
+func TestKMS_REST_SyncWithoutAutomaticRotation(t *testing.T) {
+	svc := &fakeRESTService{}
+	srv := httptest.NewServer(svc)
+	defer srv.Close()
+
+	pks := newTestPKs(t)
+	k := NewSingleAlgKMS(
+		jwa.ES256(), Config{
+			KMSConfig:    kms.KMSConfig{GenerateKeys: true},
+			SyncInterval: 10 * time.Millisecond,
+		},
+		NewRESTClient(srv.URL, "secret", 5*time.Second), pks,
+	)
+	require.NoError(t, k.Load())
+	require.NoError(t, k.StartAutomaticRotation())
+	assert.Nil(t, k.rotationStop)
+	k.StartSync()
+	defer func() { require.NoError(t, k.Close()) }()
+	svc.addKey(t)
+
+	assert.Eventually(
+		t, func() bool {
+			next, err := pks.Get("key-2")
+			return err == nil && next != nil
+		}, 5*time.Second, 10*time.Millisecond,
+	)
+	require.NoError(t, k.Close())
+	assert.Nil(t, k.syncStop)
+}
+
+func TestKMS_ChangeConfigWhileRunning(t *testing.T) {
+	srv := httptest.NewServer(&fakeRESTService{})
+	defer srv.Close()
+
+	k := NewSingleAlgKMS(
+		jwa.ES256(), Config{
+			KMSConfig: kms.KMSConfig{
+				GenerateKeys: true,
+				KeyRotation:  testRotationConf(),
+			},
+			SyncInterval: time.Millisecond,
+		},
+		NewRESTClient(srv.URL, "secret", 5*time.Second), newTestPKs(t),
+	)
+	require.NoError(t, k.Load())
+	require.NoError(t, k.StartAutomaticRotation())
+	k.StartSync()
+	defer func() { require.NoError(t, k.Close()) }()
+
+	for range 10 {
+		require.NoError(t, k.ChangeGenerateKeys(true))
+		require.NoError(t, k.ChangeKeyRotationConfig(testRotationConf()))
+		time.Sleep(time.Millisecond)
+	}
+	assert.NotNil(t, k.rotationStop)
+
+	conf := testRotationConf()
+	conf.Enabled = false
+	require.NoError(t, k.ChangeKeyRotationConfig(conf))
+	assert.Nil(t, k.rotationStop)
+}
+
+func TestKMS_ChangeAlgRejected(t *testing.T) {
+	srv := httptest.NewServer(&fakeRESTService{})
+	defer srv.Close()
+
+	k := NewSingleAlgKMS(
+		jwa.ES256(), Config{KMSConfig: kms.KMSConfig{GenerateKeys: true}},
+		NewRESTClient(srv.URL, "secret", 5*time.Second), newTestPKs(t),
+	)
+	require.NoError(t, k.Load())
+	assert.NoError(t, k.ChangeDefaultAlgorithm(jwa.ES256()))
+	assert.Error(t, k.ChangeDefaultAlgorithm(jwa.RS256()))
+	assert.Error(t, k.ChangeAlgs([]jwa.SignatureAlgorithm{jwa.ES256(), jwa.ES384()}))
+	assert.Error(t, k.ChangeRSAKeyLength(4096))
+}
+
+func TestKMS_Transit(t *testing.T) {
+	transit := &fakeTransit{}
+	srv := httptest.NewServer(transit)
+	defer srv.Close()
+
+	pks := newTestPKs(t)
+	client := NewTransitClient(
+		TransitConfig{
+			Address: srv.URL + "/",
+			Token:   "root",
+			KeyName: "lh",
+		},
+	)
+	k := NewSingleAlgKMS(
+		jwa.EdDSA(), Config{
+			KMSConfig: kms.KMSConfig{
+				GenerateKeys: true,
+				KeyRotation:  testRotationConf(),
+			},
+		}, client, pks,
+	)
+	require.NoError(t, k.Load())
+	require.Len(t, transit.versions, 1)
+
+	kid := signAndVerify(t, k, jwa.EdDSA())
+	expectedKID, err := thumbprint(transit.versions[0].Public())
+	require.NoError(t, err)
+	assert.Equal(t, expectedKID, kid)
+
+	require.NoError(t, k.RotateKey(kid, true, ""))
+	require.Len(t, transit.versions, 2)
+	newKID := signAndVerify(t, k, jwa.EdDSA())
+	assert.NotEqual(t, kid, newKID)
+}
+
+func TestEcdsaRawToASN1(t *testing.T) {
+	sk, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	require.NoError(t, err)
+	digest := sha256.Sum256([]byte("test"))
+	r, s, err := ecdsa.Sign(rand.Reader, sk, digest[:])
+	require.NoError(t, err)
+	raw := make([]byte, 96)
+	r.FillBytes(raw[:48])
+	s.FillBytes(raw[48:])
+	der, err := ecdsaRawToASN1(raw)
+	require.NoError(t, err)
+	assert.True(t, ecdsa.VerifyASN1(&sk.PublicKey, digest[:], der))
+
+	_, err = ecdsaRawToASN1(raw[:95])
+	assert.Error(t, err)
+}
+
+func TestTransitKeyType(t *testing.T) {
+	tests := []struct {
+		alg       jwa.SignatureAlgorithm
+		rsaKeyLen int
+		expected  string
+		wantErr   bool
+	}{
+		{jwa.ES256(), 0, "ecdsa-p256", false},
+		{jwa.ES512(), 0, "ecdsa-p521", false},
+		{jwa.EdDSA(), 0, "ed25519", false},
+		{jwa.PS256(), 3072, "rsa-3072", false},
+		{jwa.RS256(), 1024, "", true},
+	}
+	for _, test := range tests {
+		t.Run(
+			test.alg.String()+"/"+strconv.Itoa(test.rsaKeyLen), func(t *testing.T) {
+				got, err := transitKeyType(test.alg, test.rsaKeyLen)
+				if test.wantErr {
+					assert.Error(t, err)
+					return
+				}
+				require.NoError(t, err)
+				assert.Equal(t, test.expected, got)
+			},
+		)
+	}
+}
@@ -0,0 +1,148 @@
+package remotekms
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/lestrrat-go/jwx/v4/jwa"
+	"github.com/lestrrat-go/jwx/v4/jwk"
+	"github.com/pkg/errors"
+)
+
+// RESTClient is a Client for a generic REST signing service. The service
+// must provide the following endpoints relative to the base URL:
+//
+//   - GET  /keys: returns {"keys": [key, ...]}
+//   - POST /keys: body {"alg": "<alg>"}; creates a new key and returns it
+//   - POST /sign: body {"kid": "<kid>", "alg": "<alg>", "input": "<b64url>",
+//     "prehashed": <bool>}; returns {"signature": "<b64url>"}
+//
+// A key is represented as {"kid": "<kid>", "alg": "<alg>", "jwk": {...},
+// "created_at": <unix seconds>}, where jwk is the public key. Signatures are
+// returned in the JWS format, i.e. r||s for ECDSA. Except for EdDSA, the
+// input is the digest of the signing input and prehashed is true.
+type RESTClient struct {
+	baseURL string
+	doer    httpDoer
+}
+
+// NewRESTClient returns a new RESTClient for the signing service at baseURL.
+// If token is not empty, it is sent as a bearer token.
+func NewRESTClient(baseURL, token string, timeout time.Duration) *RESTClient {
+	headers := map[string]string{}
+	if token != "" {
+		headers["Authorization"] = "Bearer " + token
+	}
+	return &RESTClient{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		doer: httpDoer{
+			client:  &http.Client{Timeout: timeout},
+			headers: headers,
+		},
+	}
+}
+
+type restKey struct {
+	KID       string          `json:"kid"`
+	Alg       string          `json:"alg,omitempty"`
+	JWK       json.RawMessage `json:"jwk"`
+	CreatedAt int64           `json:"created_at,omitempty"`
+}
+
+func (k restKey) toKey() (Key, error) {
+	if k.KID == "" {
+		return Key{}, errors.New("key without kid")
+	}
+	pk, err := jwk.ParseKey(k.JWK)
+	if err != nil {
+		return Key{}, errors.Wrapf(err, "invalid jwk for key '%s'", k.KID)
+	}
+	pub, err := jwk.PublicRawKeyOf(pk)
+	if err != nil {
+		return Key{}, errors.Wrapf(err, "invalid jwk for key '%s'", k.KID)
+	}
+	key := Key{
+		KID:       k.KID,
+		PublicKey: pub,
+		ref:       k.KID,
+	}
+	if k.CreatedAt > 0 {
+		key.CreatedAt = time.Unix(k.CreatedAt, 0)
+	}
+	if k.Alg != "" {
+		alg, ok := jwa.LookupSignatureAlgorithm(k.Alg)
+		if !ok {
+			return Key{}, errors.Errorf("unknown alg '%s' for key '%s'", k.Alg, k.KID)
+		}
+		key.Alg = alg
+	}
+	return key, nil
+}
+
+// Keys implements the Client interface
+func (c *RESTClient) Keys(ctx context.Context) ([]Key, error) {
+	var res struct {
+		Keys []restKey `json:"keys"`
+	}
+	if err := c.doer.doJSON(ctx, http.MethodGet, c.baseURL+"/keys", nil, &res); err != nil {
+		return nil, err
+	}
+	keys := make([]Key, 0, len(res.Keys))
+	for _, k := range res.Keys {
+		key, err := k.toKey()
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// Sign implements the Client interface
+func (c *RESTClient) Sign(ctx context.Context, key Key, alg jwa.SignatureAlgorithm, digest []byte) ([]byte, error) {
+	hash, err := hashForAlg(alg)
+	if err != nil {
+		return nil, err
+	}
+	req := struct {
+		KID       string `json:"kid"`
+		Alg       string `json:"alg"`
+		Input     string `json:"input"`
+		Prehashed bool   `json:"prehashed"`
+	}{
+		KID:       key.ref,
+		Alg:       alg.String(),
+		Input:     base64.RawURLEncoding.EncodeToString(digest),
+		Prehashed: hash != 0,
+	}
+	var res struct {
+		Signature string `json:"signature"`
+	}
+	if err = c.doer.doJSON(ctx, http.MethodPost, c.baseURL+"/sign", req, &res); err != nil {
+		return nil, err
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(res.Signature, "="))
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid signature encoding")
+	}
+	if alg == jwa.ES256() || alg == jwa.ES384() || alg == jwa.ES512() {
+		return ecdsaRawToASN1(sig)
+	}
+	return sig, nil
+}
+
+// Rotate implements the Client interface
+func (c *RESTClient) Rotate(ctx context.Context, alg jwa.SignatureAlgorithm) (Key, error) {
+	req := struct {
+		Alg string `json:"alg"`
+	}{Alg: alg.String()}
+	var res restKey
+	if err := c.doer.doJSON(ctx, http.MethodPost, c.baseURL+"/keys", req, &res); err != nil {
+		return Key{}, err
+	}
+	return res.toKey()
+}
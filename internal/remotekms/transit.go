@@ -0,0 +1,275 @@
+package remotekms
+
+import (
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lestrrat-go/jwx/v4/jwa"
+	"github.com/lestrrat-go/jwx/v4/jwk"
+	"github.com/pkg/errors"
+)
+
+// TransitConfig configures a TransitClient
+type TransitConfig struct {
+	// Address is the base URL of the Vault server, e.g. https://vault:8200
+	Address string
+	// Token is sent as X-Vault-Token
+	Token string
+	// Namespace is sent as X-Vault-Namespace if not empty
+	Namespace string
+	// Mount is the mount path of the transit secrets engine; defaults to
+	// "transit"
+	Mount string
+	// KeyName is the name of the transit key; each key version is a signing
+	// key
+	KeyName string
+	// RSAKeyLen is the key size used when the key is created for an RSA
+	// algorithm; defaults to 2048
+	RSAKeyLen int
+	// Timeout is the timeout for requests to Vault
+	Timeout time.Duration
+}
+
+// TransitClient is a Client for the HashiCorp Vault transit secrets engine
+// and compatible services. Each version of the configured transit key is a
+// signing key; rotating creates a new key version. The kid of a key version
+// is its JWK thumbprint.
+type TransitClient struct {
+	conf TransitConfig
+	doer httpDoer
+}
+
+// NewTransitClient returns a new TransitClient
+func NewTransitClient(conf TransitConfig) *TransitClient {
+	if conf.Mount == "" {
+		conf.Mount = "transit"
+	}
+	if conf.RSAKeyLen == 0 {
+		conf.RSAKeyLen = 2048
+	}
+	conf.Address = strings.TrimSuffix(conf.Address, "/")
+	conf.Mount = strings.Trim(conf.Mount, "/")
+	headers := map[string]string{}
+	if conf.Token != "" {
+		headers["X-Vault-Token"] = conf.Token
+	}
+	if conf.Namespace != "" {
+		headers["X-Vault-Namespace"] = conf.Namespace
+	}
+	return &TransitClient{
+		conf: conf,
+		doer: httpDoer{
+			client:  &http.Client{Timeout: conf.Timeout},
+			headers: headers,
+		},
+	}
+}
+
+func (c *TransitClient) url(parts ...string) string {
+	escaped := make([]string, len(parts))
+	for i, p := range parts {
+		escaped[i] = url.PathEscape(p)
+	}
+	return fmt.Sprintf("%s/v1/%s/%s", c.conf.Address, c.conf.Mount, strings.Join(escaped, "/"))
+}
+
+type transitKeyInfo struct {
+	Data struct {
+		Type          string `json:"type"`
+		LatestVersion int    `json:"latest_version"`
+		Keys          map[string]struct {
+			PublicKey    string `json:"public_key"`
+			CreationTime string `json:"creation_time"`
+		} `json:"keys"`
+	} `json:"data"`
+}
+
+// Keys implements the Client interface
+func (c *TransitClient) Keys(ctx context.Context) ([]Key, error) {
+	var info transitKeyInfo
+	if err := c.doer.doJSON(ctx, http.MethodGet, c.url("keys", c.conf.KeyName), nil, &info); err != nil {
+		if statusErr, ok := errors.Cause(err).(*StatusError); ok && statusErr.StatusCode == http.StatusNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	versions := make([]int, 0, len(info.Data.Keys))
+	for v := range info.Data.Keys {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, errors.Errorf("invalid key version '%s'", v)
+		}
+		versions = append(versions, n)
+	}
+	sort.Ints(versions)
+	keys := make([]Key, 0, len(versions))
+	for _, v := range versions {
+		version := info.Data.Keys[strconv.Itoa(v)]
+		pub, err := parseTransitPublicKey(info.Data.Type, version.PublicKey)
+		if err != nil {
+			return nil, errors.Wrapf(err, "key version %d", v)
+		}
+		kid, err := thumbprint(pub)
+		if err != nil {
+			return nil, err
+		}
+		key := Key{
+			KID:       kid,
+			PublicKey: pub,
+			ref:       strconv.Itoa(v),
+		}
+		if t, err := time.Parse(time.RFC3339Nano, version.CreationTime); err == nil {
+			key.CreatedAt = t
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// Sign implements the Client interface
+func (c *TransitClient) Sign(ctx context.Context, key Key, alg jwa.SignatureAlgorithm, digest []byte) ([]byte, error) {
+	hash, err := hashForAlg(alg)
+	if err != nil {
+		return nil, err
+	}
+	version, err := strconv.Atoi(key.ref)
+	if err != nil {
+		return nil, errors.Errorf("invalid key version '%s'", key.ref)
+	}
+	req := map[string]any{
+		"input":       base64.StdEncoding.EncodeToString(digest),
+		"key_version": version,
+	}
+	path := c.url("sign", c.conf.KeyName)
+	if hash != 0 {
+		path += "/" + transitHashName(hash)
+		req["prehashed"] = true
+		if isPSS(alg) {
+			req["signature_algorithm"] = "pss"
+			req["salt_length"] = "hash"
+		} else if isRSA(alg) {
+			req["signature_algorithm"] = "pkcs1v15"
+		}
+	}
+	var res struct {
+		Data struct {
+			Signature string `json:"signature"`
+		} `json:"data"`
+	}
+	if err = c.doer.doJSON(ctx, http.MethodPost, path, req, &res); err != nil {
+		return nil, err
+	}
+	// The signature has the format vault:v<version>:<base64>
+	parts := strings.SplitN(res.Data.Signature, ":", 3)
+	if len(parts) != 3 {
+		return nil, errors.New("invalid transit signature format")
+	}
+	sig, err := base64.StdEncoding.DecodeString(parts[2])
+	return sig, errors.Wrap(err, "invalid transit signature encoding")
+}
+
+// Rotate implements the Client interface. If the transit key does not exist
+// yet, it is created with a type matching alg.
+func (c *TransitClient) Rotate(ctx context.Context, alg jwa.SignatureAlgorithm) (Key, error) {
+	keys, err := c.Keys(ctx)
+	if err != nil {
+		return Key{}, err
+	}
+	if len(keys) == 0 {
+		keyType, err := transitKeyType(alg, c.conf.RSAKeyLen)
+		if err != nil {
+			return Key{}, err
+		}
+		if err = c.doer.doJSON(
+			ctx, http.MethodPost, c.url("keys", c.conf.KeyName), map[string]string{"type": keyType}, nil,
+		); err != nil {
+			return Key{}, err
+		}
+	} else if err = c.doer.doJSON(
+		ctx, http.MethodPost, c.url("keys", c.conf.KeyName, "rotate"), map[string]string{}, nil,
+	); err != nil {
+		return Key{}, err
+	}
+	if keys, err = c.Keys(ctx); err != nil {
+		return Key{}, err
+	}
+	if len(keys) == 0 {
+		return Key{}, errors.New("transit key has no versions after rotation")
+	}
+	return keys[len(keys)-1], nil
+}
+
+func transitHashName(hash crypto.Hash) string {
+	switch hash {
+	case crypto.SHA384:
+		return "sha2-384"
+	case crypto.SHA512:
+		return "sha2-512"
+	default:
+		return "sha2-256"
+	}
+}
+
+func transitKeyType(alg jwa.SignatureAlgorithm, rsaKeyLen int) (string, error) {
+	switch alg {
+	case jwa.ES256():
+		return "ecdsa-p256", nil
+	case jwa.ES384():
+		return "ecdsa-p384", nil
+	case jwa.ES512():
+		return "ecdsa-p521", nil
+	case jwa.EdDSA():
+		return "ed25519", nil
+	case jwa.RS256(), jwa.RS384(), jwa.RS512(), jwa.PS256(), jwa.PS384(), jwa.PS512():
+		switch rsaKeyLen {
+		case 2048, 3072, 4096:
+			return "rsa-" + strconv.Itoa(rsaKeyLen), nil
+		}
+		return "", errors.Errorf("unsupported rsa key length %d for transit", rsaKeyLen)
+	default:
+		return "", errors.Errorf("unsupported signing algorithm '%s' for transit", alg)
+	}
+}
+
+func parseTransitPublicKey(keyType, data string) (crypto.PublicKey, error) {
+	if keyType == "ed25519" {
+		raw, err := base64.StdEncoding.DecodeString(data)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid ed25519 public key")
+		}
+		if len(raw) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid ed25519 public key length")
+		}
+		return ed25519.PublicKey(raw), nil
+	}
+	block, _ := pem.Decode([]byte(data))
+	if block == nil {
+		return nil, errors.Errorf("invalid public key for key type '%s'", keyType)
+	}
+	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	return pub, errors.Wrap(err, "invalid public key")
+}
+
+// thumbprint returns the base64url encoded SHA-256 JWK thumbprint of pub
+func thumbprint(pub crypto.PublicKey) (string, error) {
+	k, err := jwk.Import[jwk.Key](pub)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	tp, err := k.Thumbprint(crypto.SHA256)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(tp), nil
+}
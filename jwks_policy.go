@@ -0,0 +1,228 @@
+package lighthouse
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"slices"
+	"strings"
+
+	oidfed "github.com/go-oidfed/lib"
+	"github.com/go-oidfed/lib/jwx"
+	"github.com/gofiber/fiber/v2"
+	"github.com/lestrrat-go/jwx/v4/jwa"
+	"github.com/lestrrat-go/jwx/v4/jwk"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"gopkg.in/yaml.v3"
+
+	"github.com/go-oidfed/lighthouse/storage/model"
+)
+
+// JWKSPolicy defines which federation keys of an entity are acceptable.
+// It is used by the jwks_policy EntityChecker and as a gate for JWKS updates
+// of subordinates (jwks_update and jwks_update_trigger endpoints and the
+// subordinate JWKS refresher).
+type JWKSPolicy struct {
+	// MinRSAKeySize is the minimum RSA modulus size in bits; 0 disables the
+	// check.
+	MinRSAKeySize int `yaml:"min_rsa_key_size" json:"min_rsa_key_size" envconfig:"MIN_RSA_KEY_SIZE"`
+	// AllowedKeyTypes lists the allowed values of the "kty" parameter. If
+	// empty, all asymmetric key types are allowed.
+	AllowedKeyTypes []string `yaml:"allowed_key_types" json:"allowed_key_types" envconfig:"ALLOWED_KEY_TYPES"`
+	// AllowedCurves lists the allowed values of the "crv" parameter of EC and
+	// OKP keys. If empty, all curves are allowed.
+	AllowedCurves []string `yaml:"allowed_curves" json:"allowed_curves" envconfig:"ALLOWED_CURVES"`
+	// AllowedAlgs lists the allowed values of the "alg" parameter. Keys
+	// without "alg" are not restricted. If empty, all algorithms are allowed.
+	AllowedAlgs []string `yaml:"allowed_algs" json:"allowed_algs" envconfig:"ALLOWED_ALGS"`
+	// RequireKID requires every key to have a non-empty "kid".
+	RequireKID bool `yaml:"require_kid" json:"require_kid" envconfig:"REQUIRE_KID"`
+	// AllowDuplicateKIDs allows multiple keys with the same "kid".
+	AllowDuplicateKIDs bool `yaml:"allow_duplicate_kids" json:"allow_duplicate_kids" envconfig:"ALLOW_DUPLICATE_KIDS"`
+}
+
+// DefaultJWKSPolicy is the JWKSPolicy used if no other values are configured
+var DefaultJWKSPolicy = JWKSPolicy{
+	MinRSAKeySize: 2048,
+	AllowedCurves: []string{"P-256", "P-384", "P-521", "Ed25519", "Ed448"},
+	RequireKID:    true,
+}
+
+// JWKSPolicyError is returned if a JWKS violates a JWKSPolicy
+type JWKSPolicyError struct {
+	Violations []string
+}
+
+// Error implements the error interface
+func (e *JWKSPolicyError) Error() string {
+	return "jwks violates key policy: " + strings.Join(e.Violations, "; ")
+}
+
+// Check checks the passed JWKS against the policy. It returns a
+// *JWKSPolicyError listing all violations or nil if the JWKS is acceptable.
+func (p JWKSPolicy) Check(jwks jwx.JWKS) error {
+	if jwks.Set == nil || jwks.Len() == 0 {
+		return &JWKSPolicyError{Violations: []string{"jwks contains no keys"}}
+	}
+	var violations []string
+	seen := make(map[string]bool)
+	for i, key := range jwks.All() {
+		kid, _ := key.KeyID()
+		name := fmt.Sprintf("key %d", i)
+		if kid != "" {
+			name = fmt.Sprintf("key '%s'", kid)
+		}
+		if kid == "" {
+			if p.RequireKID {
+				violations = append(violations, name+": missing kid")
+			}
+		} else if seen[kid] && !p.AllowDuplicateKIDs {
+			violations = append(violations, name+": duplicate kid")
+		}
+		seen[kid] = true
+		violations = append(violations, p.checkKey(name, key)...)
+	}
+	if len(violations) > 0 {
+		return &JWKSPolicyError{Violations: violations}
+	}
+	return nil
+}
+
+func (p JWKSPolicy) checkKey(name string, key jwk.Key) (violations []string) {
+	// Keys that cannot be used, e.g. RSA keys below 2048 bits, are parsed
+	// as unsupported keys
+	if unsupported, ok := key.(jwk.UnsupportedKey); ok {
+		return []string{fmt.Sprintf("%s: unsupported or invalid key: %s", name, unsupported.Reason())}
+	}
+	kty := key.KeyType()
+	if kty == jwa.OctetSeq() {
+		return []string{name + ": symmetric keys are not allowed"}
+	}
+	if private, err := jwk.IsPrivateKey(key); err == nil && private {
+		return []string{name + ": private keys must not be published"}
+	}
+	if len(p.AllowedKeyTypes) > 0 && !slices.Contains(p.AllowedKeyTypes, kty.String()) {
+		violations = append(violations, fmt.Sprintf("%s: key type '%s' is not allowed", name, kty))
+	}
+	if alg, ok := key.Algorithm(); ok && len(p.AllowedAlgs) > 0 && !slices.Contains(p.AllowedAlgs, alg.String()) {
+		violations = append(violations, fmt.Sprintf("%s: algorithm '%s' is not allowed", name, alg))
+	}
+	switch k := key.(type) {
+	case jwk.RSAPublicKey:
+		n, _ := k.N()
+		if size := new(big.Int).SetBytes(n).BitLen(); p.MinRSAKeySize > 0 && size < p.MinRSAKeySize {
+			violations = append(
+				violations, fmt.Sprintf(
+					"%s: rsa key size %d is below the minimum of %d", name, size, p.MinRSAKeySize,
+				),
+			)
+		}
+	case jwk.ECDSAPublicKey:
+		violations = append(violations, p.checkCurve(name, k.Crv)...)
+	case jwk.OKPPublicKey:
+		violations = append(violations, p.checkCurve(name, k.Crv)...)
+	}
+	return violations
+}
+
+func (p JWKSPolicy) checkCurve(name string, crvFn func() (jwa.EllipticCurveAlgorithm, bool)) []string {
+	if len(p.AllowedCurves) == 0 {
+		return nil
+	}
+	crv, ok := crvFn()
+	if !ok {
+		return []string{name + ": missing crv"}
+	}
+	if !slices.Contains(p.AllowedCurves, crv.String()) {
+		return []string{fmt.Sprintf("%s: curve '%s' is not allowed", name, crv)}
+	}
+	return nil
+}
+
+// JWKSPolicyEntityChecker checks that the federation keys in an entity's
+// Entity Configuration satisfy a JWKSPolicy. Options not set in the config
+// default to the values of DefaultJWKSPolicy.
+type JWKSPolicyEntityChecker struct {
+	JWKSPolicy `yaml:",inline"`
+}
+
+// NewJWKSPolicyEntityChecker returns a new JWKSPolicyEntityChecker for the
+// passed JWKSPolicy
+func NewJWKSPolicyEntityChecker(policy JWKSPolicy) *JWKSPolicyEntityChecker {
+	return &JWKSPolicyEntityChecker{JWKSPolicy: policy}
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler and EntityChecker interface
+func (c *JWKSPolicyEntityChecker) UnmarshalYAML(node *yaml.Node) error {
+	policy := DefaultJWKSPolicy
+	if err := node.Decode(&policy); err != nil {
+		return errors.WithStack(err)
+	}
+	c.JWKSPolicy = policy
+	return nil
+}
+
+// Check implements the EntityChecker interface
+func (c JWKSPolicyEntityChecker) Check(
+	_ context.Context,
+	entityConfiguration *oidfed.EntityStatement,
+	_ []string,
+) (bool, int, *oidfed.Error) {
+	if err := c.JWKSPolicy.Check(entityConfiguration.JWKS); err != nil {
+		return false, fiber.StatusBadRequest, oidfed.ErrorInvalidRequest(err.Error())
+	}
+	return true, 0, nil
+}
+
+func init() {
+	RegisterEntityChecker(
+		"jwks_policy", func() EntityChecker {
+			return &JWKSPolicyEntityChecker{JWKSPolicy: DefaultJWKSPolicy}
+		},
+	)
+}
+
+// SetJWKSPolicy sets the JWKSPolicy that new JWKS of subordinates must
+// satisfy when they are updated via the jwks_update or jwks_update_trigger
+// endpoints. Passing nil disables the policy.
+// The policy for the subordinate JWKS refresher is passed to
+// SetupSubordinateJWKSRefresher.
+func (fed *LightHouse) SetJWKSPolicy(policy *JWKSPolicy) {
+	fed.jwksPolicy = policy
+}
+
+// JWKSPolicy returns the JWKSPolicy set with SetJWKSPolicy or nil.
+func (fed *LightHouse) JWKSPolicy() *JWKSPolicy {
+	return fed.jwksPolicy
+}
+
+// checkJWKSPolicy checks the passed JWKS against policy; a nil policy accepts
+// all JWKS.
+func checkJWKSPolicy(policy *JWKSPolicy, jwks jwx.JWKS) error {
+	if policy == nil {
+		return nil
+	}
+	return policy.Check(jwks)
+}
+
+// recordJWKSRejected records a JWKSRejected event for a subordinate
+func recordJWKSRejected(
+	eventStore model.SubordinateEventStore, subordinateID uint, actor *string, err error,
+) {
+	if eventStore == nil {
+		return
+	}
+	msg := err.Error()
+	if addErr := eventStore.Add(
+		model.SubordinateEvent{
+			SubordinateID: subordinateID,
+			Actor:         actor,
+			Timestamp:     nowUnix(),
+			Type:          model.EventTypeJWKSRejected,
+			Message:       &msg,
+		},
+	); addErr != nil {
+		log.Warn().Err(addErr).Uint("subordinate_id", subordinateID).Msg("failed to record jwks_rejected event")
+	}
+}
@@ -0,0 +1,279 @@
+package lighthouse
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/lestrrat-go/jwx/v4/jwk"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+
+	oidfed "github.com/go-oidfed/lib"
+	"github.com/go-oidfed/lib/jwx"
+	"github.com/go-oidfed/lib/oidfedconst"
+
+	"github.com/go-oidfed/lighthouse/storage/model"
+)
+
+// policyTestJWKS builds a JWKS from the public keys of the passed raw keys.
+// Keys are assigned the passed kids in order; an empty kid leaves the key
+// without kid.
+func policyTestJWKS(t *testing.T, kids []string, raws ...any) jwx.JWKS {
+	t.Helper()
+	set := jwx.NewJWKS()
+	for i, raw := range raws {
+		key, err := jwk.Import[jwk.Key](raw)
+		require.NoError(t, err)
+		if pub, err := jwk.PublicKeyOf(key); err == nil {
+			key = pub
+		}
+		if kids[i] != "" {
+			require.NoError(t, key.Set(jwk.KeyIDKey, kids[i]))
+		}
+		require.NoError(t, set.AddKey(key))
+	}
+	return set
+}
+
+// weakRSAJWKS returns a JWKS with a single 1024 bit RSA key. The key is parsed
+// from JSON, since importing such keys from crypto/rsa is refused by jwx.
+func weakRSAJWKS(t *testing.T, kid string) jwx.JWKS {
+	t.Helper()
+	sk, err := rsa.GenerateKey(rand.Reader, 1024)
+	require.NoError(t, err)
+	data, err := json.Marshal(
+		map[string]any{
+			"keys": []map[string]any{
+				{
+					"kty": "RSA",
+					"kid": kid,
+					"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(sk.E)).Bytes()),
+					"n":   base64.RawURLEncoding.EncodeToString(sk.N.Bytes()),
+				},
+			},
+		},
+	)
+	require.NoError(t, err)
+	var jwks jwx.JWKS
+	require.NoError(t, json.Unmarshal(data, &jwks))
+	return jwks
+}
+
+func TestJWKSPolicy_Check(t *testing.T) {
+	rsa2048, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	p256, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	_, ed, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name      string
+		policy    JWKSPolicy
+		jwks      jwx.JWKS
+		violation string
+	}{
+		{
+			name:   "strong keys",
+			policy: DefaultJWKSPolicy,
+			jwks:   policyTestJWKS(t, []string{"a", "b", "c"}, rsa2048, p256, ed),
+		},
+		{
+			name:      "empty jwks",
+			policy:    DefaultJWKSPolicy,
+			jwks:      jwx.NewJWKS(),
+			violation: "jwks contains no keys",
+		},
+		{
+			name:      "weak rsa key",
+			policy:    DefaultJWKSPolicy,
+			jwks:      weakRSAJWKS(t, "weak"),
+			violation: "key 'weak': unsupported or invalid key",
+		},
+		{
+			name: "rsa key below configured minimum",
+			policy: JWKSPolicy{
+				MinRSAKeySize: 3072,
+			},
+			jwks:      policyTestJWKS(t, []string{"rsa"}, rsa2048),
+			violation: "key 'rsa': rsa key size 2048 is below the minimum of 3072",
+		},
+		{
+			name:      "missing kid",
+			policy:    DefaultJWKSPolicy,
+			jwks:      policyTestJWKS(t, []string{""}, p256),
+			violation: "key 0: missing kid",
+		},
+		{
+			name: "missing kid allowed",
+			policy: JWKSPolicy{
+				RequireKID: false,
+			},
+			jwks: policyTestJWKS(t, []string{""}, p256),
+		},
+		{
+			name:      "duplicate kid",
+			policy:    DefaultJWKSPolicy,
+			jwks:      policyTestJWKS(t, []string{"dup", "dup"}, p256, ed),
+			violation: "key 'dup': duplicate kid",
+		},
+		{
+			name: "disallowed curve",
+			policy: JWKSPolicy{
+				AllowedCurves: []string{"P-384"},
+			},
+			jwks:      policyTestJWKS(t, []string{"ec"}, p256),
+			violation: "key 'ec': curve 'P-256' is not allowed",
+		},
+		{
+			name: "disallowed key type",
+			policy: JWKSPolicy{
+				AllowedKeyTypes: []string{"EC"},
+			},
+			jwks:      policyTestJWKS(t, []string{"rsa"}, rsa2048),
+			violation: "key 'rsa': key type 'RSA' is not allowed",
+		},
+		{
+			name:      "symmetric key",
+			policy:    JWKSPolicy{},
+			jwks:      policyTestJWKS(t, []string{"oct"}, []byte("0123456789abcdef0123456789abcdef")),
+			violation: "key 'oct': symmetric keys are not allowed",
+		},
+	}
+	for _, test := range tests {
+		t.Run(
+			test.name, func(t *testing.T) {
+				err := test.policy.Check(test.jwks)
+				if test.violation == "" {
+					assert.NoError(t, err)
+					return
+				}
+				require.Error(t, err)
+				var policyErr *JWKSPolicyError
+				require.ErrorAs(t, err, &policyErr)
+				assert.Contains(t, err.Error(), test.violation)
+			},
+		)
+	}
+}
+
+func TestJWKSPolicyEntityChecker(t *testing.T) {
+	var checker JWKSPolicyEntityChecker
+	require.NoError(t, yaml.Unmarshal([]byte("allowed_key_types: [RSA, EC]"), &checker))
+	assert.Equal(t, DefaultJWKSPolicy.MinRSAKeySize, checker.MinRSAKeySize)
+	assert.True(t, checker.RequireKID)
+	assert.Equal(t, []string{"RSA", "EC"}, checker.AllowedKeyTypes)
+
+	es := testEntityStatement("https://op.example.org")
+	es.JWKS = weakRSAJWKS(t, "weak")
+	ok, code, errResp := checker.Check(t.Context(), es, nil)
+	assert.False(t, ok)
+	assert.Equal(t, http.StatusBadRequest, code)
+	require.NotNil(t, errResp)
+	assert.Contains(t, errResp.ErrorDescription, "key 'weak'")
+
+	c, err := EntityCheckerFromYAMLConfig([]byte("type: jwks_policy"))
+	require.NoError(t, err)
+	es.JWKS = pubJWKS(t, rsaKey(t))
+	ok, _, _ = c.Check(t.Context(), es, nil)
+	assert.True(t, ok)
+}
+
+func TestJwksUpdateEndpoint_PolicyViolation_Rejected(t *testing.T) {
+	entityID := "https://sub-policy.example"
+	store := newTestStorage(t)
+	subSK := rsaKey(t)
+	require.NoError(
+		t, store.SubordinateStorage().Add(
+			model.ExtendedSubordinateInfo{
+				BasicSubordinateInfo: model.BasicSubordinateInfo{
+					EntityID: entityID,
+					Status:   model.StatusActive,
+				},
+				JWKS: model.NewJWKS(pubJWKS(t, subSK)),
+			},
+		),
+	)
+	fed := &LightHouse{
+		FederationEntity: stubFedEntity{},
+		storages: model.Backends{
+			Subordinates:      store.SubordinateStorage(),
+			SubordinateEvents: store.SubordinateEventsStorage(),
+		},
+		fedMetadata: oidfed.FederationEntityMetadata{},
+	}
+	fed.SetJWKSPolicy(&DefaultJWKSPolicy)
+	require.NoError(t, fed.AddJWKSUpdateEndpoint(EndpointConf{Path: "/jwks-update"}, store.SubordinateStorage()))
+	app := fiber.New()
+	app.All("/*", fed.dispatch)
+
+	signed := signJWKSet(t, subSK, weakRSAJWKS(t, "weak"), entityID, entityID)
+	req := httptest.NewRequest("POST", "/jwks-update", bytes.NewReader(signed))
+	req.Header.Set("Content-Type", oidfedconst.ContentTypeJWKS)
+	resp, body := doRequestRaw(t, app, req)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assertErrBody(t, body, "invalid_request")
+
+	info, err := store.SubordinateStorage().Get(entityID)
+	require.NoError(t, err)
+	assert.Equal(t, oidfed.ExtractKIDs(pubJWKS(t, subSK)).List(), oidfed.ExtractKIDs(info.JWKS.Keys).List())
+	events, _, err := store.SubordinateEventsStorage().GetBySubordinateID(info.ID, model.EventQueryOpts{})
+	require.NoError(t, err)
+	require.NotEmpty(t, events)
+	assert.Equal(t, model.EventTypeJWKSRejected, events[0].Type)
+}
+
+func TestSubordinateJWKSRefreshStorage_PolicyViolationRecordedOnce(t *testing.T) {
+	entityID := "https://sub-refresh-policy.example"
+	store := newTestStorage(t)
+	subStore := store.SubordinateStorage()
+	eventStore := store.SubordinateEventsStorage()
+	require.NoError(
+		t, subStore.Add(
+			model.ExtendedSubordinateInfo{
+				BasicSubordinateInfo: model.BasicSubordinateInfo{
+					EntityID:         entityID,
+					Status:           model.StatusActive,
+					EnableJWKSUpdate: true,
+				},
+				JWKS: model.NewJWKS(pubJWKS(t, rsaKey(t))),
+			},
+		),
+	)
+	adapter := NewSubordinateJWKSRefreshStorage(subStore, eventStore, &DefaultJWKSPolicy)
+
+	p256, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	noKID := policyTestJWKS(t, []string{""}, p256)
+	for range 2 {
+		err = adapter.UpdateJWKS(entityID, noKID)
+		var policyErr *JWKSPolicyError
+		require.ErrorAs(t, err, &policyErr)
+	}
+
+	info, err := subStore.Get(entityID)
+	require.NoError(t, err)
+	events, _, err := eventStore.GetBySubordinateID(info.ID, model.EventQueryOpts{})
+	require.NoError(t, err)
+	rejected := 0
+	for _, e := range events {
+		if e.Type == model.EventTypeJWKSRejected {
+			rejected++
+		}
+	}
+	assert.Equal(t, 1, rejected)
+
+	require.NoError(t, adapter.UpdateJWKS(entityID, jwksWithKid(t, "good")))
+}
@@ -2,7 +2,6 @@ package lighthouse
 
 import (
 	oidfed "github.com/go-oidfed/lib"
-	"github.com/go-oidfed/lib/cache"
 	"github.com/go-oidfed/lib/oidfedconst"
 	"github.com/gofiber/fiber/v2"
 
@@ -98,12 +97,18 @@ func (fed *LightHouse) AddJWKSUpdateEndpoint(
 			)
 		}
 
+		if err = checkJWKSPolicy(fed.jwksPolicy, signed.Keys); err != nil {
+			recordJWKSRejected(fed.storages.SubordinateEvents, info.ID, &info.EntityID, err)
+			ctx.Status(fiber.StatusBadRequest)
+			return ctx.JSON(oidfed.ErrorInvalidRequest(err.Error()))
+		}
+
 		// Replace the stored JWKS.
 		if err := store.UpdateJWKSByEntityID(target, model.NewJWKS(signed.Keys)); err != nil {
 			ctx.Status(fiber.StatusInternalServerError)
 			return ctx.JSON(oidfed.ErrorServerError("failed to update JWKS: " + err.Error()))
 		}
-		_ = cache.Delete(internal.SubordinateStatementCacheKey(target))
+		_ = internal.DeleteCache(internal.SubordinateStatementCacheKey(target))
 
 		// Record an event.
 		if fed.storages.SubordinateEvents != nil {
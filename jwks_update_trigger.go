@@ -80,6 +80,12 @@ func (fed *LightHouse) AddJWKSUpdateTriggerEndpoint(
 
 		changed, err := fed.RefreshSubordinateJWKSFromEC(target)
 		if err != nil {
+			// A new JWKS violating the JWKS policy is rejected.
+			var policyErr *JWKSPolicyError
+			if stderrors.As(err, &policyErr) {
+				ctx.Status(fiber.StatusBadRequest)
+				return ctx.JSON(oidfed.ErrorInvalidRequest("failed to refresh JWKS: " + err.Error()))
+			}
 			// The EC signature failing to verify against the stored JWKS is an
 			// authenticity failure of the subordinate, mirroring the jwks_update
 			// endpoint semantics: 401 invalid_client.
@@ -5,8 +5,11 @@ import (
 	"github.com/go-oidfed/lib/jwx/keymanagement/public"
 	"github.com/lestrrat-go/jwx/v4/jwa"
 	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/zachmann/go-utils/duration"
 
 	"github.com/go-oidfed/lighthouse/api/adminapi"
+	"github.com/go-oidfed/lighthouse/internal/remotekms"
 	"github.com/go-oidfed/lighthouse/storage"
 	"github.com/go-oidfed/lighthouse/storage/model"
 )
@@ -14,7 +17,7 @@ import (
 // SigningConf holds signing configuration.
 //
 // Environment variables (with prefix LH_SIGNING_):
-//   - LH_SIGNING_KMS: Key management system ("filesystem", "pkcs11", or "db")
+//   - LH_SIGNING_KMS: Key management system ("filesystem", "pkcs11", "db", or "remote")
 //   - LH_SIGNING_PK_BACKEND: Public key storage backend ("filesystem" or "db")
 //   - LH_SIGNING_AUTO_GENERATE_KEYS: Auto-generate keys if missing (bool)
 //   - LH_SIGNING_FILESYSTEM_KEY_FILE: Path to single key file
@@ -30,13 +33,48 @@ import (
 //   - LH_SIGNING_PKCS11_NO_LOGIN: Token doesn't support login (bool)
 //   - LH_SIGNING_PKCS11_LABEL_PREFIX: Prefix for object labels
 //   - LH_SIGNING_PKCS11_LOAD_LABELS: Extra labels to load (comma-separated)
+//   - LH_SIGNING_DB_ENCRYPTION_MASTER_KEY_KEY_ID: ID of the master key for kms=db
+//   - LH_SIGNING_DB_ENCRYPTION_MASTER_KEY_FILE: Path to the master key file
+//   - LH_SIGNING_DB_ENCRYPTION_MASTER_KEY_ENV: Env var holding the master key
+//   - LH_SIGNING_REMOTE_TYPE: Remote signing service type ("transit" or "rest")
+//   - LH_SIGNING_REMOTE_URL: Base URL of the remote signing service
+//   - LH_SIGNING_REMOTE_TOKEN: Token for the remote signing service
+//   - LH_SIGNING_REMOTE_NAMESPACE: Vault namespace (transit only)
+//   - LH_SIGNING_REMOTE_MOUNT: Transit secrets engine mount path
+//   - LH_SIGNING_REMOTE_KEY_NAME: Transit key name
+//   - LH_SIGNING_REMOTE_TIMEOUT: Timeout for requests to the signing service
+//   - LH_SIGNING_REMOTE_SYNC_INTERVAL: Interval for syncing keys from the signing service
+//   - LH_SIGNING_PURPOSES_TRUST_MARKS_*: Key set for trust marks (same options as above, e.g.
+//     LH_SIGNING_PURPOSES_TRUST_MARKS_KMS)
+//   - LH_SIGNING_PURPOSES_RESOLVE_*: Key set for resolve responses (same options as above)
 type SigningConf struct {
-	// KMS specifies the key management system to use.
-	// Env: LH_SIGNING_KMS
-	KMS string `yaml:"kms" envconfig:"KMS"`
 	// PKBackend specifies the public key storage backend.
 	// Env: LH_SIGNING_PK_BACKEND
 	PKBackend string `yaml:"pk_backend" envconfig:"PK_BACKEND"`
+	// KeySetConf configures the federation entity keys.
+	KeySetConf `yaml:",inline"`
+	// Purposes holds separate key sets for signing trust marks and resolve
+	// responses. Purposes without a kms use the federation entity keys.
+	// Env prefix: LH_SIGNING_PURPOSES_
+	Purposes struct {
+		// TrustMarks is the key set for trust marks and trust mark status
+		// responses.
+		// Env prefix: LH_SIGNING_PURPOSES_TRUST_MARKS_
+		TrustMarks KeySetConf `yaml:"trust_marks" envconfig:"TRUST_MARKS"`
+		// Resolve is the key set for resolve responses.
+		// Env prefix: LH_SIGNING_PURPOSES_RESOLVE_
+		Resolve KeySetConf `yaml:"resolve" envconfig:"RESOLVE"`
+	} `yaml:"purposes" envconfig:"PURPOSES"`
+}
+
+// KeySetConf configures the key management system of a signing key set.
+// The environment variables are relative to the key set, e.g. LH_SIGNING_KMS
+// for the federation keys and LH_SIGNING_PURPOSES_TRUST_MARKS_KMS for the
+// trust mark keys.
+type KeySetConf struct {
+	// KMS specifies the key management system to use.
+	// Env: LH_SIGNING_KMS
+	KMS string `yaml:"kms" envconfig:"KMS"`
 	// AutoGenerateKeys enables automatic key generation if keys are missing.
 	// Env: LH_SIGNING_AUTO_GENERATE_KEYS
 	AutoGenerateKeys bool `yaml:"auto_generate_keys" envconfig:"AUTO_GENERATE_KEYS"`
@@ -95,12 +133,71 @@ type SigningConf struct {
 		// Env: LH_SIGNING_PKCS11_LOAD_LABELS (comma-separated)
 		ExtraLabels []string `yaml:"load_labels" envconfig:"LOAD_LABELS"`
 	} `yaml:"pkcs11" envconfig:"PKCS11"`
+	// DBEncryption configures encryption of private keys stored by the
+	// database KMS (kms=db).
+	// Env prefix: LH_SIGNING_DB_ENCRYPTION_
+	DBEncryption DBKeyEncryptionConf `yaml:"db_encryption" envconfig:"DB_ENCRYPTION"`
+	// RemoteBackend holds the configuration of a remote signing service
+	// (kms=remote).
+	// Env prefix: LH_SIGNING_REMOTE_
+	RemoteBackend struct {
+		// Type is the type of the signing service: "transit" for the
+		// HashiCorp Vault transit secrets engine or "rest" for the generic
+		// REST signing protocol.
+		// Env: LH_SIGNING_REMOTE_TYPE
+		Type string `yaml:"type" envconfig:"TYPE"`
+		// URL is the base URL of the signing service.
+		// Env: LH_SIGNING_REMOTE_URL
+		URL string `yaml:"url" envconfig:"URL"`
+		// Token is used to authenticate to the signing service.
+		// Env: LH_SIGNING_REMOTE_TOKEN
+		Token string `yaml:"token" envconfig:"TOKEN"`
+		// Namespace is the Vault namespace (transit only).
+		// Env: LH_SIGNING_REMOTE_NAMESPACE
+		Namespace string `yaml:"namespace" envconfig:"NAMESPACE"`
+		// Mount is the mount path of the transit secrets engine.
+		// Env: LH_SIGNING_REMOTE_MOUNT
+		Mount string `yaml:"mount" envconfig:"MOUNT"`
+		// KeyName is the name of the transit key.
+		// Env: LH_SIGNING_REMOTE_KEY_NAME
+		KeyName string `yaml:"key_name" envconfig:"KEY_NAME"`
+		// Timeout is the timeout for requests to the signing service.
+		// Env: LH_SIGNING_REMOTE_TIMEOUT
+		Timeout duration.DurationOption `yaml:"timeout" envconfig:"TIMEOUT"`
+		// SyncInterval is the interval in which keys are synced from the
+		// signing service.
+		// Env: LH_SIGNING_REMOTE_SYNC_INTERVAL
+		SyncInterval duration.DurationOption `yaml:"sync_interval" envconfig:"SYNC_INTERVAL"`
+	} `yaml:"remote" envconfig:"REMOTE"`
+}
+
+// Enabled reports whether a key set is configured
+func (c KeySetConf) Enabled() bool {
+	return c.KMS != ""
+}
+
+// PurposeKeySets returns the configured key sets by key purpose
+func (c SigningConf) PurposeKeySets() map[string]KeySetConf {
+	sets := make(map[string]KeySetConf)
+	if c.Purposes.TrustMarks.Enabled() {
+		sets[model.SigningPurposeTrustMarks] = c.Purposes.TrustMarks
+	}
+	if c.Purposes.Resolve.Enabled() {
+		sets[model.SigningPurposeResolve] = c.Purposes.Resolve
+	}
+	return sets
 }
 
 const (
 	KMSFilesystem = "filesystem"
 	KMSPKCS11     = "pkcs11"
 	KMSDatabase   = "db"
+	KMSRemote     = "remote"
+)
+
+const (
+	RemoteKMSTypeTransit = "transit"
+	RemoteKMSTypeREST    = "rest"
 )
 
 const (
@@ -112,76 +209,139 @@ func initKey(entityID string, c SigningConf, storages model.Backends) (
 	keyManagement adminapi.KeyManagement,
 	err error,
 ) {
-	keyManagement.KMS = c.KMS
+	keyManagement, err = initKeySet(entityID, model.SigningPurposeFederation, c.KeySetConf, c, storages)
+	if err != nil {
+		return
+	}
 	switch c.PKBackend {
 	case PKBackendFilesystem:
-		keyManagement.KMSManagedPKs = &public.FilesystemPublicKeyStorage{
-			Dir:    c.FileSystemBackend.KeyDir,
-			TypeID: "federation",
-		}
 		keyManagement.APIManagedPKs = &public.FilesystemPublicKeyStorage{
 			Dir:    c.FileSystemBackend.KeyDir,
 			TypeID: "api",
 		}
 	case PKBackendDatabase:
-		keyManagement.KMSManagedPKs = storages.PKStorages("federation")
 		keyManagement.APIManagedPKs = storages.PKStorages("api")
-	default:
-		err = errors.Errorf("unsupported public key backend '%s'", c.PKBackend)
+	}
+	if err = keyManagement.APIManagedPKs.Load(); err != nil {
 		return
 	}
-	if err = keyManagement.KMSManagedPKs.Load(); err != nil {
+	for purpose, keySet := range c.PurposeKeySets() {
+		purposeKeys, e := initKeySet(entityID, purpose, keySet, c, storages)
+		if e != nil {
+			err = errors.Wrapf(e, "could not initialize %s signing keys", purpose)
+			return
+		}
+		if keyManagement.Purposes == nil {
+			keyManagement.Purposes = make(map[string]adminapi.KeyManagement)
+		}
+		keyManagement.Purposes[purpose] = purposeKeys
+	}
+	return
+}
+
+// initKeySet initializes the key management system and public key storage of
+// the key set for the passed key purpose. The purpose is used as type id for
+// the stored keys.
+func initKeySet(entityID, purpose string, c KeySetConf, signingConf SigningConf, storages model.Backends) (
+	keyManagement adminapi.KeyManagement,
+	err error,
+) {
+	alg, algs, err := storage.GetSigningAlgsForPurpose(storages.KV, purpose)
+	if err != nil {
 		return
 	}
-	if err = keyManagement.APIManagedPKs.Load(); err != nil {
+	rsaKeyLen, err := storage.GetRSAKeyLenForPurpose(storages.KV, purpose)
+	if err != nil {
 		return
 	}
-	alg, e := storage.GetSigningAlg(storages.KV)
-	if e != nil {
-		err = e
+	rotationConf, err := storage.GetKeyRotationForPurpose(storages.KV, purpose)
+	if err != nil {
 		return
 	}
-	rsaKeyLen, e := storage.GetRSAKeyLen(storages.KV)
-	if e != nil {
-		err = e
+	return newKeySet(
+		entityID, purpose, c, signingConf, storages, keySetParams{
+			alg:       alg,
+			algs:      algs,
+			rsaKeyLen: rsaKeyLen,
+			rotation:  rotationConf,
+		},
+	)
+}
+
+// keySetParams holds the signing options of a key set that are stored in the
+// database.
+type keySetParams struct {
+	alg       jwa.SignatureAlgorithm
+	algs      []jwa.SignatureAlgorithm
+	rsaKeyLen int
+	rotation  kms.KeyRotationConfig
+}
+
+// newKeySet creates and loads the key management system and public key
+// storage of a key set with the passed signing options.
+func newKeySet(
+	entityID, purpose string, c KeySetConf, signingConf SigningConf, storages model.Backends, p keySetParams,
+) (
+	keyManagement adminapi.KeyManagement,
+	err error,
+) {
+	keyManagement.KMS = c.KMS
+	var remoteKMS *remotekms.KMS
+	switch signingConf.PKBackend {
+	case PKBackendFilesystem:
+		keyDir := c.FileSystemBackend.KeyDir
+		if keyDir == "" {
+			keyDir = signingConf.FileSystemBackend.KeyDir
+		}
+		keyManagement.KMSManagedPKs = &public.FilesystemPublicKeyStorage{
+			Dir:    keyDir,
+			TypeID: purpose,
+		}
+	case PKBackendDatabase:
+		keyManagement.KMSManagedPKs = storages.PKStorages(purpose)
+	default:
+		err = errors.Errorf("unsupported public key backend '%s'", signingConf.PKBackend)
 		return
 	}
-	rotationConf, e := storage.GetKeyRotation(storages.KV)
-	if e != nil {
-		err = e
+	if err = keyManagement.KMSManagedPKs.Load(); err != nil {
 		return
 	}
 	switch c.KMS {
 	case KMSFilesystem:
 		if c.FileSystemBackend.KeyFile != "" {
 			keyManagement.BasicKeys = &kms.SingleSigningKeyFile{
-				Alg:  alg,
+				Alg:  p.alg,
 				Path: c.FileSystemBackend.KeyFile,
 			}
 		} else {
-			keyManagement.Keys = kms.NewSingleAlgFilesystemKMS(
-				alg, kms.FilesystemKMSConfig{
-					KMSConfig: kms.KMSConfig{
+			keyManagement.Keys = &kms.FilesystemKMS{
+				PEMStorageKMS: kms.NewPEMStorageKMS(
+					kms.KMSConfig{
 						GenerateKeys: c.AutoGenerateKeys,
-						RSAKeyLen:    rsaKeyLen,
-						KeyRotation:  rotationConf,
+						Algs:         p.algs,
+						DefaultAlg:   p.alg,
+						RSAKeyLen:    p.rsaKeyLen,
+						KeyRotation:  p.rotation,
 						EntityID:     entityID,
 					},
-					Dir:    c.FileSystemBackend.KeyDir,
-					TypeID: "federation",
-				}, keyManagement.KMSManagedPKs,
-			)
+					&kms.FilesystemPEMStorage{Dir: c.FileSystemBackend.KeyDir},
+					&kms.FilesystemStateStorer{Dir: c.FileSystemBackend.KeyDir},
+					keyManagement.KMSManagedPKs,
+				),
+			}
 		}
 	case KMSPKCS11:
-		keyManagement.Keys = kms.NewSingleAlgPKCS11KMS(
-			alg, kms.PKCS11KMSConfig{
+		// There is no multi-alg constructor for the PKCS#11 KMS, so the
+		// algorithms are set on the returned KMS before it is loaded.
+		pkcs11KMS := kms.NewSingleAlgPKCS11KMS(
+			p.alg, kms.PKCS11KMSConfig{
 				KMSConfig: kms.KMSConfig{
 					GenerateKeys: c.AutoGenerateKeys,
-					RSAKeyLen:    rsaKeyLen,
-					KeyRotation:  rotationConf,
+					RSAKeyLen:    p.rsaKeyLen,
+					KeyRotation:  p.rotation,
 					EntityID:     entityID,
 				},
-				TypeID:            "federation",
+				TypeID:            purpose,
 				StorageDir:        c.PKCS11Backend.StorageDir,
 				ModulePath:        c.PKCS11Backend.ModulePath,
 				TokenLabel:        c.PKCS11Backend.TokenLabel,
@@ -194,22 +354,54 @@ func initKey(entityID string, c SigningConf, storages model.Backends) (
 				LabelPrefix:       c.PKCS11Backend.LabelPrefix,
 				ExtraLabels:       c.PKCS11Backend.ExtraLabels,
 			}, keyManagement.KMSManagedPKs,
-		)
+		).(*kms.PKCS11KMS)
+		pkcs11KMS.Algs = p.algs
+		keyManagement.Keys = pkcs11KMS
 	case KMSDatabase:
-		pemStorer := storage.NewDBPEMStorer(storages.DB, "federation")
-		stateStorer := storage.NewDBStateStorer(storages.KV, "federation")
-		keyManagement.Keys = kms.NewPEMStorageKMS(
+		var pemStorer *storage.DBPEMStorer
+		if pemStorer, err = newDBPEMStorer(c.DBEncryption, purpose, storages); err != nil {
+			return
+		}
+		stateStorer := storage.NewDBStateStorer(storages.KV, purpose)
+		pemKMS := kms.NewPEMStorageKMS(
 			kms.KMSConfig{
 				GenerateKeys: c.AutoGenerateKeys,
-				Algs:         []jwa.SignatureAlgorithm{alg},
-				RSAKeyLen:    rsaKeyLen,
-				KeyRotation:  rotationConf,
+				Algs:         p.algs,
+				DefaultAlg:   p.alg,
+				RSAKeyLen:    p.rsaKeyLen,
+				KeyRotation:  p.rotation,
 				EntityID:     entityID,
 			},
 			pemStorer,
 			stateStorer,
 			keyManagement.KMSManagedPKs,
 		)
+		keyManagement.Keys = &dbKMS{
+			PEMStorageKMS: pemKMS,
+			pemStorer:     pemStorer,
+		}
+	case KMSRemote:
+		if len(p.algs) > 1 {
+			err = errors.New("kms 'remote' only supports a single signing algorithm")
+			return
+		}
+		var client remotekms.Client
+		if client, err = newRemoteKMSClient(c, p.rsaKeyLen); err != nil {
+			return
+		}
+		remoteKMS = remotekms.NewSingleAlgKMS(
+			p.alg, remotekms.Config{
+				KMSConfig: kms.KMSConfig{
+					GenerateKeys: c.AutoGenerateKeys,
+					RSAKeyLen:    p.rsaKeyLen,
+					KeyRotation:  p.rotation,
+					EntityID:     entityID,
+				},
+				Timeout:      c.RemoteBackend.Timeout.Duration(),
+				SyncInterval: c.RemoteBackend.SyncInterval.Duration(),
+			}, client, keyManagement.KMSManagedPKs,
+		)
+		keyManagement.Keys = remoteKMS
 	default:
 		err = errors.Errorf("unsupported kms '%s'", c.KMS)
 		return
@@ -220,9 +412,78 @@ func initKey(entityID string, c SigningConf, storages model.Backends) (
 	if err = errors.Wrap(keyManagement.BasicKeys.Load(), "could not load kms"); err != nil {
 		return
 	}
-	if keyManagement.Keys != nil && rotationConf.Enabled {
+	if remoteKMS != nil {
+		remoteKMS.StartSync()
+	}
+	if keyManagement.Keys != nil && p.rotation.Enabled {
 		err = errors.Wrap(keyManagement.Keys.StartAutomaticRotation(), "could not start automatic key rotation")
 		return
 	}
 	return
 }
+
+// newRemoteKMSClient returns the remotekms.Client for the configured signing
+// service
+func newRemoteKMSClient(c KeySetConf, rsaKeyLen int) (remotekms.Client, error) {
+	conf := c.RemoteBackend
+	switch conf.Type {
+	case RemoteKMSTypeTransit:
+		return remotekms.NewTransitClient(
+			remotekms.TransitConfig{
+				Address:   conf.URL,
+				Token:     conf.Token,
+				Namespace: conf.Namespace,
+				Mount:     conf.Mount,
+				KeyName:   conf.KeyName,
+				RSAKeyLen: rsaKeyLen,
+				Timeout:   conf.Timeout.Duration(),
+			},
+		), nil
+	case RemoteKMSTypeREST:
+		return remotekms.NewRESTClient(conf.URL, conf.Token, conf.Timeout.Duration()), nil
+	default:
+		return nil, errors.Errorf("unsupported remote kms type '%s'", conf.Type)
+	}
+}
+
+// dbKMS is the database KMS (kms=db). Closing it releases the master keys
+// used to encrypt its private keys.
+type dbKMS struct {
+	*kms.PEMStorageKMS
+	pemStorer *storage.DBPEMStorer
+}
+
+// Close stops the automatic key rotation and releases the master keys.
+func (k *dbKMS) Close() error {
+	k.StopAutomaticRotation()
+	return k.pemStorer.Close()
+}
+
+// newDBPEMStorer returns the storage.DBPEMStorer for the private keys of the
+// passed type; if a master key is configured, the keys are encrypted.
+func newDBPEMStorer(conf DBKeyEncryptionConf, typeID string, storages model.Backends) (*storage.DBPEMStorer, error) {
+	if !conf.Enabled() {
+		return storage.NewDBPEMStorer(storages.DB, typeID), nil
+	}
+	sealer, err := conf.NewSealer()
+	if err != nil {
+		return nil, errors.Wrap(err, "could not initialize private key encryption")
+	}
+	pemStorer := storage.NewEncryptedDBPEMStorer(storages.DB, typeID, sealer)
+	if conf.RequireEncryption {
+		pemStorer.RequireEncryption()
+	}
+	n, err := pemStorer.CountUnsealed()
+	if err != nil {
+		_ = pemStorer.Close()
+		return nil, err
+	}
+	if n > 0 {
+		log.Warn().
+			Int("keys", n).
+			Str("type", typeID).
+			Str("master_key_id", sealer.PrimaryKeyID()).
+			Msg("private keys are not encrypted with the current master key; run 'lhmigrate encrypt-keys'")
+	}
+	return pemStorer, nil
+}
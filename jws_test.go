@@ -0,0 +1,150 @@
+package lighthouse
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/go-oidfed/lib/jwx"
+	"github.com/go-oidfed/lib/oidfedconst"
+	"github.com/lestrrat-go/jwx/v4/jwa"
+	"github.com/lestrrat-go/jwx/v4/jws"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/go-oidfed/lighthouse/storage"
+	"github.com/go-oidfed/lighthouse/storage/model"
+)
+
+func purposeTestSigningConf(t *testing.T) SigningConf {
+	t.Helper()
+	dir := t.TempDir()
+	var c SigningConf
+	c.PKBackend = PKBackendFilesystem
+	c.KMS = KMSFilesystem
+	c.AutoGenerateKeys = true
+	c.FileSystemBackend.KeyDir = filepath.Join(dir, "federation")
+	c.Purposes.TrustMarks.KMS = KMSFilesystem
+	c.Purposes.TrustMarks.AutoGenerateKeys = true
+	c.Purposes.TrustMarks.FileSystemBackend.KeyDir = filepath.Join(dir, "trust_marks")
+	return c
+}
+
+func signedKID(t *testing.T, signed []byte) string {
+	t.Helper()
+	msg, err := jws.Parse(signed)
+	require.NoError(t, err)
+	kid, ok := msg.Signatures()[0].ProtectedHeaders().KeyID()
+	require.True(t, ok)
+	return kid
+}
+
+func TestInitKey_PurposeKeySets(t *testing.T) {
+	c := purposeTestSigningConf(t)
+	keyManagement, err := initKey("https://ta.example.com", c, model.Backends{})
+	require.NoError(t, err)
+
+	require.Contains(t, keyManagement.Purposes, model.SigningPurposeTrustMarks)
+	assert.NotContains(t, keyManagement.Purposes, model.SigningPurposeResolve)
+	trustMarkKeys := keyManagement.Purposes[model.SigningPurposeTrustMarks]
+
+	fedPKs, err := keyManagement.KMSManagedPKs.GetValid()
+	require.NoError(t, err)
+	require.Len(t, fedPKs, 1)
+	tmPKs, err := trustMarkKeys.KMSManagedPKs.GetValid()
+	require.NoError(t, err)
+	require.Len(t, tmPKs, 1)
+	assert.NotEqual(t, fedPKs[0].KID, tmPKs[0].KID)
+
+	versatileSigner, err := createVersatileSigner(keyManagement, nil)
+	require.NoError(t, err)
+	fed := &LightHouse{
+		GeneralJWTSigner: jwx.NewGeneralJWTSigner(versatileSigner, keyManagement.BasicKeys.GetAlgs()),
+		purposeSigners:   createPurposeSigners(keyManagement, nil),
+	}
+
+	// The published JWKS holds the keys of all purposes
+	published, err := fed.GeneralJWTSigner.JWKS()
+	require.NoError(t, err)
+	_, ok := published.LookupKeyID(fedPKs[0].KID)
+	assert.True(t, ok)
+	_, ok = published.LookupKeyID(tmPKs[0].KID)
+	assert.True(t, ok)
+
+	// The purpose JWKS only holds the purpose keys
+	tmJWKS, err := fed.PurposeSigner(model.SigningPurposeTrustMarks).JWKS()
+	require.NoError(t, err)
+	assert.Equal(t, 1, tmJWKS.Len())
+
+	payload := map[string]any{"iss": "https://ta.example.com"}
+	tm, err := fed.PurposeSigner(model.SigningPurposeTrustMarks).TrustMarkSigner().JWT(payload)
+	require.NoError(t, err)
+	assert.Equal(t, tmPKs[0].KID, signedKID(t, tm))
+
+	es, err := fed.GeneralJWTSigner.JWT(payload, oidfedconst.JWTTypeEntityStatement)
+	require.NoError(t, err)
+	assert.Equal(t, fedPKs[0].KID, signedKID(t, es))
+
+	// Resolve responses fall back to the federation keys
+	rr, err := fed.PurposeSigner(model.SigningPurposeResolve).ResolveResponseSigner().JWT(payload)
+	require.NoError(t, err)
+	assert.Equal(t, fedPKs[0].KID, signedKID(t, rr))
+}
+
+func TestInitKey_MultipleAlgs(t *testing.T) {
+	c := purposeTestSigningConf(t)
+	c.Purposes.TrustMarks = KeySetConf{}
+	kv := newTestStorage(t).KeyValue()
+	require.NoError(
+		t, storage.SetSigningAlg(
+			kv, storage.SigningAlgWithNbf{
+				SigningAlg: "ES256",
+				Algs:       []string{"PS256"},
+			},
+		),
+	)
+	keyManagement, err := initKey("https://ta.example.com", c, model.Backends{KV: kv})
+	require.NoError(t, err)
+
+	assert.Equal(t, jwa.ES256(), keyManagement.BasicKeys.GetDefaultAlg())
+	assert.ElementsMatch(t, []jwa.SignatureAlgorithm{jwa.ES256(), jwa.PS256()}, keyManagement.BasicKeys.GetAlgs())
+	fedPKs, err := keyManagement.KMSManagedPKs.GetValid()
+	require.NoError(t, err)
+	require.Len(t, fedPKs, 2)
+
+	versatileSigner, err := createVersatileSigner(keyManagement, kv)
+	require.NoError(t, err)
+	fed := &LightHouse{
+		GeneralJWTSigner: jwx.NewGeneralJWTSigner(versatileSigner, keyManagement.BasicKeys.GetAlgs()),
+		keyManagement:    keyManagement,
+	}
+	assert.Equal(
+		t, []jwa.SignatureAlgorithm{jwa.ES256(), jwa.PS256()},
+		fed.PurposeSigningAlgs(model.SigningPurposeResolve),
+	)
+
+	algs, err := fed.negotiateSigningAlg(model.SigningPurposeResolve, nil)
+	require.NoError(t, err)
+	assert.Empty(t, algs)
+	algs, err = fed.negotiateSigningAlg(model.SigningPurposeResolve, []string{"RS256", "PS256", "ES256"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"PS256"}, algs)
+	_, err = fed.negotiateSigningAlg(model.SigningPurposeResolve, []string{"RS256"})
+	assert.Error(t, err)
+
+	payload := map[string]any{"iss": "https://ta.example.com"}
+	signedAlg := func(signed []byte) jwa.SignatureAlgorithm {
+		msg, err := jws.Parse(signed)
+		require.NoError(t, err)
+		alg, ok := msg.Signatures()[0].ProtectedHeaders().Algorithm()
+		require.True(t, ok)
+		return alg
+	}
+	es, err := fed.GeneralJWTSigner.JWT(payload, oidfedconst.JWTTypeEntityStatement)
+	require.NoError(t, err)
+	assert.Equal(t, jwa.ES256(), signedAlg(es))
+	rr, err := fed.PurposeSigner(model.SigningPurposeResolve).JWT(
+		payload, oidfedconst.JWTTypeResolveResponse, algs...,
+	)
+	require.NoError(t, err)
+	assert.Equal(t, jwa.PS256(), signedAlg(rr))
+}
@@ -0,0 +1,264 @@
+package lighthouse
+
+import (
+	"context"
+	"encoding/json"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	oidfed "github.com/go-oidfed/lib"
+	"github.com/go-oidfed/lib/jwx/keymanagement/public"
+	"github.com/go-oidfed/lib/oidfedconst"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+
+	"github.com/go-oidfed/lighthouse/api/adminapi"
+	"github.com/go-oidfed/lighthouse/internal"
+	"github.com/go-oidfed/lighthouse/storage/model"
+)
+
+// KeyCompromiseReason is the default revocation reason recorded for keys
+// revoked by the key compromise procedure.
+const KeyCompromiseReason = "compromised"
+
+// superiorNotificationTimeout is the timeout for notifying a single superior
+// about new keys.
+const superiorNotificationTimeout = 10 * time.Second
+
+// CompromiseKeys runs the emergency key compromise procedure for the key set
+// of the passed key purpose. It
+//   - revokes the compromised keys, recording the reason in the historical
+//     keys,
+//   - generates replacement keys that are used for signing right away,
+//   - purges cached entity configurations, subordinate statements, trust
+//     marks and stored resolve responses, so everything is re-signed with the
+//     new keys,
+//   - asks all superiors to re-fetch the entity configuration through their
+//     federation_jwks_update_trigger_endpoint, and
+//   - sends a critical key_compromised notification.
+//
+// If req.KIDs is empty, all active keys of the key set are revoked.
+func (fed *LightHouse) CompromiseKeys(
+	purpose string, req adminapi.KeyCompromiseRequest,
+) (*adminapi.KeyCompromiseResult, error) {
+	keys := fed.keyManagement
+	if purpose != model.SigningPurposeFederation {
+		purposeKeys, ok := fed.keyManagement.Purposes[purpose]
+		if !ok {
+			return nil, errors.Errorf("key purpose '%s' has no own key set", purpose)
+		}
+		keys = purposeKeys
+	}
+	if keys.Keys == nil || keys.KMSManagedPKs == nil {
+		return nil, adminapi.ErrRotationNotSupported
+	}
+	reason := req.Reason
+	if reason == "" {
+		reason = KeyCompromiseReason
+	}
+
+	before, err := keys.KMSManagedPKs.GetValid()
+	if err != nil {
+		return nil, err
+	}
+	kids := req.KIDs
+	if len(kids) == 0 {
+		active, err := keys.KMSManagedPKs.GetActive()
+		if err != nil {
+			return nil, err
+		}
+		for _, k := range active {
+			kids = append(kids, k.KID)
+		}
+		err = keys.Keys.RotateAllKeys(true, reason)
+		if err != nil {
+			return nil, errors.Wrap(err, "could not rotate keys")
+		}
+	} else {
+		for _, kid := range kids {
+			k, err := keys.KMSManagedPKs.Get(kid)
+			if err != nil || k == nil {
+				return nil, errors.Wrapf(adminapi.ErrKeyNotFound, "kid '%s'", kid)
+			}
+		}
+		for _, kid := range kids {
+			if err = keys.Keys.RotateKey(kid, true, reason); err != nil {
+				return nil, errors.Wrapf(err, "could not rotate key '%s'", kid)
+			}
+		}
+	}
+	after, err := keys.KMSManagedPKs.GetValid()
+	if err != nil {
+		return nil, err
+	}
+	res := &adminapi.KeyCompromiseResult{
+		Purpose:     purpose,
+		Reason:      reason,
+		RevokedKIDs: kids,
+		NewKIDs:     []string{},
+	}
+	for _, k := range after {
+		if !slices.ContainsFunc(
+			before, func(b public.PublicKeyEntry) bool {
+				return b.KID == k.KID
+			},
+		) {
+			res.NewKIDs = append(res.NewKIDs, k.KID)
+		}
+	}
+	log.Warn().Str("purpose", purpose).Strs("revoked_kids", res.RevokedKIDs).Strs("new_kids", res.NewKIDs).
+		Str("reason", reason).Msg("compromised keys revoked")
+
+	fed.purgeSignedCaches()
+	res.Superiors = fed.notifySuperiorsAboutNewKeys()
+
+	fed.notify(
+		Notification{
+			Type:     "key_compromised",
+			Severity: NotificationSeverityCritical,
+			Subject:  fed.FederationEntity.EntityID(),
+			Message: "signing keys " + strings.Join(res.RevokedKIDs, ", ") +
+				" were revoked as compromised and replaced by " + strings.Join(res.NewKIDs, ", "),
+			Details: map[string]any{
+				"purpose":      purpose,
+				"reason":       reason,
+				"revoked_kids": res.RevokedKIDs,
+				"new_kids":     res.NewKIDs,
+			},
+		},
+	)
+	return res, nil
+}
+
+// purgeSignedCaches drops all cached and stored statements signed by
+// LightHouse, so they are re-signed with the current keys on the next
+// request.
+func (fed *LightHouse) purgeSignedCaches() {
+	_ = internal.DeleteCache(internal.CacheKeyEntityConfiguration)
+	_ = internal.ClearCache(internal.CacheKeySubordinateStatement)
+	_ = internal.ClearCache(internal.CacheKeyHistoricalKeys)
+	if fed.trustMarkConfigProvider != nil {
+		fed.trustMarkConfigProvider.Invalidate()
+	}
+	if fed.issuedTrustMarkCache != nil {
+		fed.issuedTrustMarkCache.Clear()
+	}
+	fed.deleteTrustMarkBundles("")
+	if err := fed.purgeStoredResolveResponses(); err != nil {
+		log.Error().Err(err).Msg("failed to purge stored resolve responses")
+	}
+}
+
+// purgeStoredResolveResponses removes the signed resolve responses stored by
+// the proactive resolver. Stored unsigned responses are kept; they are signed
+// when served.
+func (fed *LightHouse) purgeStoredResolveResponses() error {
+	if fed.storages.FederationEndpoints == nil {
+		return nil
+	}
+	ep, err := fed.storages.FederationEndpoints.GetByType(model.EndpointTypeResolve)
+	if err != nil {
+		var notFound model.NotFoundError
+		if errors.As(err, &notFound) {
+			return nil
+		}
+		return err
+	}
+	var cfg resolveDBConfig
+	if ep.Config != "" {
+		if err = json.Unmarshal([]byte(ep.Config), &cfg); err != nil {
+			return errors.Wrap(err, "failed to parse resolve config")
+		}
+	}
+	if cfg.ProactiveResolver == nil || !cfg.ProactiveResolver.ResponseStorageStoreJWT {
+		return nil
+	}
+	dir := filepath.Join(cfg.ProactiveResolver.ResponseStorageDir, "resolve")
+	err = filepath.WalkDir(
+		dir, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() || filepath.Ext(path) != ".jwt" {
+				return nil
+			}
+			return os.Remove(path)
+		},
+	)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// notifySuperiorsAboutNewKeys asks each superior from the authority hints to
+// re-fetch the entity configuration through its
+// federation_jwks_update_trigger_endpoint. Superiors whose endpoint requires
+// client authentication are not notified, since the client assertion would
+// have to be signed with a key the superior does not know yet.
+func (fed *LightHouse) notifySuperiorsAboutNewKeys() []adminapi.SuperiorNotification {
+	results := []adminapi.SuperiorNotification{}
+	if fed.storages.AuthorityHints == nil {
+		return results
+	}
+	hints, err := fed.storages.AuthorityHints.List()
+	if err != nil {
+		log.Error().Err(err).Msg("failed to list authority hints")
+		return results
+	}
+	for _, hint := range hints {
+		result := adminapi.SuperiorNotification{EntityID: hint.EntityID}
+		result.Endpoint, err = fed.notifySuperiorAboutNewKeys(hint.EntityID)
+		if err != nil {
+			result.Error = err.Error()
+			log.Warn().Err(err).Str("superior", hint.EntityID).Msg("failed to notify superior about new keys")
+		} else {
+			result.Notified = true
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+func (fed *LightHouse) notifySuperiorAboutNewKeys(superior string) (string, error) {
+	ec, err := oidfed.GetEntityConfiguration(superior)
+	if err != nil {
+		return "", errors.Wrap(err, "could not obtain entity configuration")
+	}
+	if ec.Metadata == nil || ec.Metadata.FederationEntity == nil {
+		return "", errors.New("superior does not publish federation_entity metadata")
+	}
+	extra := ec.Metadata.FederationEntity.Extra
+	endpoint, _ := extra[oidfedconst.FederationJWKSUpdateTriggerEndpoint].(string)
+	if endpoint == "" {
+		return "", errors.New("superior does not publish a federation_jwks_update_trigger_endpoint")
+	}
+	if authMethods, ok := extra[oidfedconst.FederationJWKSUpdateTriggerEndpointAuthMethods].([]any); ok &&
+		len(authMethods) > 0 {
+		return endpoint, errors.New("federation_jwks_update_trigger_endpoint requires client authentication")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), superiorNotificationTimeout)
+	defer cancel()
+	form := url.Values{"sub": {fed.FederationEntity.EntityID()}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return endpoint, errors.Wrap(err, "failed to create request")
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return endpoint, errors.Wrap(err, "request failed")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return endpoint, errors.Errorf("superior returned HTTP %d", resp.StatusCode)
+	}
+	return endpoint, nil
+}
@@ -0,0 +1,98 @@
+package lighthouse
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-oidfed/lib/cache"
+	"github.com/go-oidfed/lib/jwx"
+	"github.com/go-oidfed/lib/oidfedconst"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/go-oidfed/lighthouse/api/adminapi"
+	"github.com/go-oidfed/lighthouse/internal"
+	"github.com/go-oidfed/lighthouse/storage"
+	"github.com/go-oidfed/lighthouse/storage/model"
+)
+
+func TestCompromiseKeys(t *testing.T) {
+	c := purposeTestSigningConf(t)
+	keyManagement, err := initKey("https://ta.example.com", c, model.Backends{})
+	require.NoError(t, err)
+	versatileSigner, err := createVersatileSigner(keyManagement, nil)
+	require.NoError(t, err)
+
+	store := newTestStorage(t)
+	endpoints := storage.NewFederationEndpointStorage(store.DB())
+	resolveDir := t.TempDir()
+	path := "/resolve"
+	_, err = endpoints.Create(
+		model.AddFederationEndpoint{
+			Type: model.EndpointTypeResolve,
+			Path: &path,
+			Config: `{"proactive_resolver": {"enabled": true, "response_storage_dir": "` + resolveDir +
+				`", "response_storage_store_jwt": true, "response_storage_store_json": true}}`,
+		},
+	)
+	require.NoError(t, err)
+	storedDir := filepath.Join(resolveDir, "resolve", "ta")
+	require.NoError(t, os.MkdirAll(storedDir, 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(storedDir, "a.jwt"), []byte("jwt"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(storedDir, "a.json"), []byte("{}"), 0o644))
+
+	fed := &LightHouse{
+		FederationEntity: stubFedEntity{},
+		GeneralJWTSigner: jwx.NewGeneralJWTSigner(versatileSigner, keyManagement.BasicKeys.GetAlgs()),
+		keyManagement:    keyManagement,
+		storages: model.Backends{
+			FederationEndpoints: endpoints,
+			AuthorityHints:      store.AuthorityHintsStorage(),
+		},
+	}
+	require.NoError(t, cache.Set(internal.CacheKeyEntityConfiguration, []byte("ec"), time.Minute))
+
+	oldPKs, err := keyManagement.KMSManagedPKs.GetActive()
+	require.NoError(t, err)
+	require.Len(t, oldPKs, 1)
+	oldKID := oldPKs[0].KID
+
+	_, err = fed.CompromiseKeys(model.SigningPurposeFederation, adminapi.KeyCompromiseRequest{KIDs: []string{"unknown"}})
+	assert.ErrorIs(t, err, adminapi.ErrKeyNotFound)
+
+	res, err := fed.CompromiseKeys(model.SigningPurposeFederation, adminapi.KeyCompromiseRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{oldKID}, res.RevokedKIDs)
+	assert.Equal(t, KeyCompromiseReason, res.Reason)
+	require.Len(t, res.NewKIDs, 1)
+	assert.Empty(t, res.Superiors)
+
+	// The old key is revoked with the reason and no longer published
+	old, err := keyManagement.KMSManagedPKs.Get(oldKID)
+	require.NoError(t, err)
+	require.NotNil(t, old.RevokedAt)
+	assert.Equal(t, KeyCompromiseReason, old.Reason)
+	published, err := fed.GeneralJWTSigner.JWKS()
+	require.NoError(t, err)
+	_, ok := published.LookupKeyID(oldKID)
+	assert.False(t, ok)
+
+	// The replacement key signs right away
+	es, err := fed.GeneralJWTSigner.JWT(map[string]any{"iss": "https://ta.example.com"}, oidfedconst.JWTTypeEntityStatement)
+	require.NoError(t, err)
+	assert.Equal(t, res.NewKIDs[0], signedKID(t, es))
+
+	// Cached and stored signed statements are purged
+	var cached []byte
+	set, err := cache.Get(internal.CacheKeyEntityConfiguration, &cached)
+	require.NoError(t, err)
+	assert.False(t, set)
+	assert.NoFileExists(t, filepath.Join(storedDir, "a.jwt"))
+	assert.FileExists(t, filepath.Join(storedDir, "a.json"))
+
+	// Key purposes without an own key set are rejected
+	_, err = fed.CompromiseKeys(model.SigningPurposeResolve, adminapi.KeyCompromiseRequest{})
+	assert.Error(t, err)
+}
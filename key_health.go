@@ -0,0 +1,543 @@
+package lighthouse
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-oidfed/lib/jwx"
+	"github.com/go-oidfed/lib/jwx/keymanagement/public"
+	"github.com/go-oidfed/lib/unixtime"
+	"github.com/lestrrat-go/jwx/v4/jwk"
+	"github.com/rs/zerolog/log"
+
+	"github.com/go-oidfed/lighthouse/api/adminapi"
+	"github.com/go-oidfed/lighthouse/storage"
+	"github.com/go-oidfed/lighthouse/storage/model"
+)
+
+// keyHealthActor is recorded as the actor of events created by the
+// KeyHealthMonitor.
+const keyHealthActor = "key_health"
+
+// KeyHealthConfig configures the KeyHealthMonitor.
+type KeyHealthConfig struct {
+	// Interval is the time between two checks.
+	Interval time.Duration
+	// WarningThreshold is how long before its expiration a key is reported
+	// with severity warning.
+	WarningThreshold time.Duration
+	// CriticalThreshold is how long before its expiration a key is reported
+	// with severity critical.
+	CriticalThreshold time.Duration
+}
+
+// keyHealthAlert is persisted in the KV store per reported key, so that a
+// key is only notified about again if its severity rises.
+type keyHealthAlert struct {
+	Status   string `json:"status"`
+	Severity string `json:"severity"`
+	Since    int64  `json:"since"`
+}
+
+// KeyHealthMonitor periodically checks the expiration of the signing keys,
+// the API-managed public keys, the keys of active subordinates and the keys
+// of trust anchors against the configured thresholds. Newly found issues are
+// recorded as subordinate events (for subordinate keys) and sent as
+// notifications.
+type KeyHealthMonitor struct {
+	fed    *LightHouse
+	conf   KeyHealthConfig
+	mu     sync.Mutex
+	last   *adminapi.KeyHealthReport
+	runner periodicRunner
+}
+
+// NewKeyHealthMonitor creates a new KeyHealthMonitor for the passed
+// LightHouse.
+func NewKeyHealthMonitor(fed *LightHouse, conf KeyHealthConfig) *KeyHealthMonitor {
+	if conf.Interval <= 0 {
+		conf.Interval = time.Hour
+	}
+	if conf.WarningThreshold <= 0 {
+		conf.WarningThreshold = 14 * 24 * time.Hour
+	}
+	if conf.CriticalThreshold <= 0 {
+		conf.CriticalThreshold = 72 * time.Hour
+	}
+	return &KeyHealthMonitor{
+		fed:  fed,
+		conf: conf,
+	}
+}
+
+// Start starts the periodic checks in the background. The first check runs
+// right away.
+func (m *KeyHealthMonitor) Start() {
+	m.runner.start(
+		periodicTask{
+			interval:   m.conf.Interval,
+			runAtStart: true,
+			run:        func(context.Context) { m.RunOnce() },
+		},
+	)
+	log.Info().Dur("interval", m.conf.Interval).Msg("key health monitor started")
+}
+
+// Stop stops the periodic checks and waits for a running check to finish.
+func (m *KeyHealthMonitor) Stop() {
+	m.runner.stop()
+}
+
+// Last returns the report of the latest check, or nil if no check ran yet.
+func (m *KeyHealthMonitor) Last() *adminapi.KeyHealthReport {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.last
+}
+
+// RunOnce checks all keys, records events and sends notifications for new or
+// escalated issues, and returns the report. Concurrent calls are serialized.
+func (m *KeyHealthMonitor) RunOnce() *adminapi.KeyHealthReport {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	report := m.Check(time.Now())
+	m.alert(report)
+	m.last = report
+	log.Debug().Str("status", report.Status).Int("issues", len(report.Issues)).Msg("key health checked")
+	return report
+}
+
+// Check evaluates the expiration of all monitored keys at the passed time
+// without sending any notifications.
+func (m *KeyHealthMonitor) Check(now time.Time) *adminapi.KeyHealthReport {
+	report := &adminapi.KeyHealthReport{
+		CheckedAt:         now.Unix(),
+		Status:            adminapi.KeyHealthStatusOK,
+		WarningThreshold:  int64(m.conf.WarningThreshold.Seconds()),
+		CriticalThreshold: int64(m.conf.CriticalThreshold.Seconds()),
+		SigningKeys:       []adminapi.KeyHealthEntry{},
+		Issues:            []adminapi.KeyHealthEntry{},
+	}
+	m.checkSigningKeys(report, now)
+	m.checkAPIKeys(report, now)
+	m.checkSubordinateKeys(report, now)
+	m.checkTrustAnchorKeys(report, now)
+	m.checkTrustMarkDelegations(report, now)
+	for _, issue := range report.Issues {
+		if issue.Severity == NotificationSeverityCritical {
+			report.Status = NotificationSeverityCritical
+			break
+		}
+		report.Status = NotificationSeverityWarning
+	}
+	return report
+}
+
+// evaluate sets status and severity of the entry from the passed key
+// expiration.
+func (m *KeyHealthMonitor) evaluate(entry *adminapi.KeyHealthEntry, exp, now time.Time) {
+	entry.ExpiresAt = exp.Unix()
+	remaining := exp.Sub(now)
+	switch {
+	case remaining < 0:
+		entry.Status = adminapi.KeyHealthStatusExpired
+		entry.Severity = NotificationSeverityCritical
+		entry.Message = fmt.Sprintf("%s expired at %s", keyHealthSubject(*entry), exp.UTC().Format(time.RFC3339))
+	case remaining <= m.conf.CriticalThreshold:
+		entry.Status = adminapi.KeyHealthStatusExpiring
+		entry.Severity = NotificationSeverityCritical
+	case remaining <= m.conf.WarningThreshold:
+		entry.Status = adminapi.KeyHealthStatusExpiring
+		entry.Severity = NotificationSeverityWarning
+	default:
+		entry.Status = adminapi.KeyHealthStatusOK
+		return
+	}
+	if entry.Message == "" {
+		entry.Message = fmt.Sprintf("%s expires at %s", keyHealthSubject(*entry), exp.UTC().Format(time.RFC3339))
+	}
+}
+
+// keyHealthSubject describes the checked key or delegation in messages.
+func keyHealthSubject(entry adminapi.KeyHealthEntry) string {
+	if entry.Kind == adminapi.KeyHealthKindTrustMarkDelegation {
+		return fmt.Sprintf("delegation for trust mark type %s (%s)", entry.TrustMarkType, entry.EntityID)
+	}
+	return "key " + entry.KID
+}
+
+// checkSigningKeys checks the KMS-managed keys of all key sets. Per
+// algorithm only the key with the latest expiration matters; earlier keys
+// are replaced by it as part of the regular rotation. If automatic key
+// rotation is enabled for a key set, the KMS replaces its keys before they
+// expire, so only expired keys are reported.
+func (m *KeyHealthMonitor) checkSigningKeys(report *adminapi.KeyHealthReport, now time.Time) {
+	keyManagement := m.fed.keyManagement
+	keySets := map[string]public.PublicKeyStorage{
+		model.SigningPurposeFederation: keyManagement.KMSManagedPKs,
+	}
+	for purpose, purposeKeys := range keyManagement.Purposes {
+		keySets[purpose] = purposeKeys.KMSManagedPKs
+	}
+	purposes := make([]string, 0, len(keySets))
+	for purpose := range keySets {
+		purposes = append(purposes, purpose)
+	}
+	sort.Strings(purposes)
+
+	for _, purpose := range purposes {
+		pks := keySets[purpose]
+		if pks == nil {
+			continue
+		}
+		valid, err := pks.GetValid()
+		if err != nil {
+			log.Warn().Err(err).Str("purpose", purpose).Msg("key health: failed to load signing keys")
+			continue
+		}
+		if len(valid) == 0 {
+			report.Issues = append(
+				report.Issues, adminapi.KeyHealthEntry{
+					Kind:     adminapi.KeyHealthKindSigningKey,
+					Purpose:  purpose,
+					Status:   adminapi.KeyHealthStatusExpired,
+					Severity: NotificationSeverityCritical,
+					Message:  "no valid signing key",
+				},
+			)
+			continue
+		}
+		latest := latestExpiringKeyPerAlg(valid)
+		autoRotation := m.autoRotationEnabled(purpose)
+		for _, k := range valid {
+			entry := adminapi.KeyHealthEntry{
+				Kind:    adminapi.KeyHealthKindSigningKey,
+				Purpose: purpose,
+				KID:     k.KID,
+				Status:  adminapi.KeyHealthStatusOK,
+			}
+			if k.ExpiresAt != nil && !k.ExpiresAt.IsZero() {
+				if latest[k.KID] {
+					m.evaluate(&entry, k.ExpiresAt.Time, now)
+					if autoRotation && entry.Status == adminapi.KeyHealthStatusExpiring {
+						entry.Status = adminapi.KeyHealthStatusOK
+						entry.Severity = ""
+						entry.Message = ""
+					}
+				} else {
+					entry.ExpiresAt = k.ExpiresAt.Unix()
+				}
+			}
+			report.SigningKeys = append(report.SigningKeys, entry)
+			if entry.Status != adminapi.KeyHealthStatusOK {
+				report.Issues = append(report.Issues, entry)
+			}
+		}
+	}
+}
+
+// autoRotationEnabled reports whether automatic key rotation is enabled for
+// the key set of the passed purpose.
+func (m *KeyHealthMonitor) autoRotationEnabled(purpose string) bool {
+	if m.fed.storages.KV == nil {
+		return false
+	}
+	rotation, err := storage.GetKeyRotationForPurpose(m.fed.storages.KV, purpose)
+	if err != nil {
+		log.Warn().Err(err).Str("purpose", purpose).Msg("key health: failed to load key rotation config")
+		return false
+	}
+	return rotation.Enabled
+}
+
+// latestExpiringKeyPerAlg returns the kids of the keys that expire last for
+// their algorithm; algorithms with a key without expiration have no such key.
+func latestExpiringKeyPerAlg(keys public.PublicKeyEntryList) map[string]bool {
+	latest := make(map[string]public.PublicKeyEntry)
+	neverExpires := make(map[string]bool)
+	for _, k := range keys {
+		alg := ""
+		if a, ok := k.Key.Algorithm(); ok {
+			alg = a.String()
+		}
+		if k.ExpiresAt == nil || k.ExpiresAt.IsZero() {
+			neverExpires[alg] = true
+			continue
+		}
+		if l, ok := latest[alg]; !ok || k.ExpiresAt.After(l.ExpiresAt.Time) {
+			latest[alg] = k
+		}
+	}
+	kids := make(map[string]bool, len(latest))
+	for alg, k := range latest {
+		if !neverExpires[alg] {
+			kids[k.KID] = true
+		}
+	}
+	return kids
+}
+
+func (m *KeyHealthMonitor) checkAPIKeys(report *adminapi.KeyHealthReport, now time.Time) {
+	pks := m.fed.keyManagement.APIManagedPKs
+	if pks == nil {
+		return
+	}
+	valid, err := pks.GetValid()
+	if err != nil {
+		log.Warn().Err(err).Msg("key health: failed to load api-managed keys")
+		return
+	}
+	for _, k := range valid {
+		if k.ExpiresAt == nil || k.ExpiresAt.IsZero() {
+			continue
+		}
+		entry := adminapi.KeyHealthEntry{
+			Kind: adminapi.KeyHealthKindAPIKey,
+			KID:  k.KID,
+		}
+		m.evaluate(&entry, k.ExpiresAt.Time, now)
+		if entry.Status != adminapi.KeyHealthStatusOK {
+			report.Issues = append(report.Issues, entry)
+		}
+	}
+}
+
+func (m *KeyHealthMonitor) checkSubordinateKeys(report *adminapi.KeyHealthReport, now time.Time) {
+	subordinates := m.fed.storages.Subordinates
+	if subordinates == nil {
+		return
+	}
+	active, err := subordinates.GetByStatus(model.StatusActive)
+	if err != nil {
+		log.Warn().Err(err).Msg("key health: failed to list active subordinates")
+		return
+	}
+	for _, sub := range active {
+		info, err := subordinates.Get(sub.EntityID)
+		if err != nil || info == nil {
+			continue
+		}
+		m.checkJWKS(report, adminapi.KeyHealthKindSubordinateKey, sub.EntityID, info.JWKS.Keys, now)
+	}
+}
+
+func (m *KeyHealthMonitor) checkTrustAnchorKeys(report *adminapi.KeyHealthReport, now time.Time) {
+	trustAnchors := m.fed.storages.TrustAnchors
+	if trustAnchors == nil {
+		return
+	}
+	tas, err := trustAnchors.List()
+	if err != nil {
+		log.Warn().Err(err).Msg("key health: failed to list trust anchors")
+		return
+	}
+	for _, ta := range tas {
+		m.checkJWKS(report, adminapi.KeyHealthKindTrustAnchorKey, ta.EntityID, ta.JWKS.Keys, now)
+	}
+}
+
+func (m *KeyHealthMonitor) checkJWKS(
+	report *adminapi.KeyHealthReport, kind, entityID string, jwks jwx.JWKS, now time.Time,
+) {
+	if jwks.Set == nil {
+		return
+	}
+	for _, k := range jwks.All() {
+		exp, err := jwk.Get[unixtime.Unixtime](k, "exp")
+		if err != nil || exp.IsZero() {
+			continue
+		}
+		kid, _ := k.KeyID()
+		entry := adminapi.KeyHealthEntry{
+			Kind:     kind,
+			EntityID: entityID,
+			KID:      kid,
+		}
+		m.evaluate(&entry, exp.Time, now)
+		if entry.Status != adminapi.KeyHealthStatusOK {
+			report.Issues = append(report.Issues, entry)
+		}
+	}
+}
+
+// checkTrustMarkDelegations checks the delegations LightHouse issued as
+// trust mark owner and the delegations of the trust mark types it issues.
+func (m *KeyHealthMonitor) checkTrustMarkDelegations(report *adminapi.KeyHealthReport, now time.Time) {
+	if m.fed.storages.KV != nil {
+		delegations, err := m.fed.TrustMarkDelegations()
+		if err != nil {
+			log.Warn().Err(err).Msg("key health: failed to load trust mark delegations")
+		}
+		for _, d := range delegations {
+			if d.ExpiresAt == 0 {
+				continue
+			}
+			m.checkDelegation(report, d.TrustMarkType, d.Issuer, time.Unix(d.ExpiresAt, 0), now)
+		}
+	}
+	specs := m.fed.storages.TrustMarkSpecs
+	if specs == nil {
+		return
+	}
+	list, err := specs.List()
+	if err != nil {
+		log.Warn().Err(err).Msg("key health: failed to list trust mark specs")
+		return
+	}
+	entityID := m.fed.FederationEntity.EntityID()
+	for _, spec := range list {
+		if spec.DelegationJWT == "" {
+			continue
+		}
+		claims, err := parseDelegationClaims(spec.DelegationJWT)
+		if err != nil || claims.ExpiresAt == nil || claims.Issuer == entityID {
+			// Delegations LightHouse issued to itself are checked above
+			continue
+		}
+		m.checkDelegation(report, spec.TrustMarkType, claims.Issuer, claims.ExpiresAt.Time, now)
+	}
+}
+
+func (m *KeyHealthMonitor) checkDelegation(
+	report *adminapi.KeyHealthReport, trustMarkType, entityID string, exp, now time.Time,
+) {
+	entry := adminapi.KeyHealthEntry{
+		Kind:          adminapi.KeyHealthKindTrustMarkDelegation,
+		EntityID:      entityID,
+		TrustMarkType: trustMarkType,
+	}
+	m.evaluate(&entry, exp, now)
+	if entry.Status != adminapi.KeyHealthStatusOK {
+		report.Issues = append(report.Issues, entry)
+	}
+}
+
+// keyHealthAlertKey identifies a reported key across checks.
+func keyHealthAlertKey(e adminapi.KeyHealthEntry) string {
+	key := e.Kind + "|" + e.Purpose + "|" + e.EntityID + "|" + e.KID
+	if e.TrustMarkType != "" {
+		key += "|" + e.TrustMarkType
+	}
+	return key
+}
+
+// alert records events and sends notifications for issues that are new or
+// whose status or severity changed since the previous check. The reported
+// issues are persisted in the KV store.
+func (m *KeyHealthMonitor) alert(report *adminapi.KeyHealthReport) {
+	kv := m.fed.storages.KV
+	previous := make(map[string]keyHealthAlert)
+	if kv != nil {
+		if _, err := kv.GetAs(model.KeyValueScopeKeyHealth, model.KeyValueKeyKeyHealthAlerts, &previous); err != nil {
+			log.Warn().Err(err).Msg("key health: failed to load alert state")
+		}
+	}
+	current := make(map[string]keyHealthAlert, len(report.Issues))
+	for _, issue := range report.Issues {
+		key := keyHealthAlertKey(issue)
+		state, found := previous[key]
+		if found && state.Status == issue.Status && state.Severity == issue.Severity {
+			current[key] = state
+			continue
+		}
+		current[key] = keyHealthAlert{
+			Status:   issue.Status,
+			Severity: issue.Severity,
+			Since:    report.CheckedAt,
+		}
+		m.notifyIssue(issue)
+	}
+	if kv == nil {
+		return
+	}
+	if err := kv.SetAny(model.KeyValueScopeKeyHealth, model.KeyValueKeyKeyHealthAlerts, current); err != nil {
+		log.Warn().Err(err).Msg("key health: failed to store alert state")
+	}
+}
+
+func (m *KeyHealthMonitor) notifyIssue(issue adminapi.KeyHealthEntry) {
+	eventType := model.EventTypeKeyExpiring
+	if issue.Status == adminapi.KeyHealthStatusExpired {
+		eventType = model.EventTypeKeyExpired
+	}
+	subject := issue.EntityID
+	if subject == "" {
+		subject = m.fed.FederationEntity.EntityID()
+	}
+	if issue.Kind == adminapi.KeyHealthKindSubordinateKey {
+		m.recordSubordinateEvent(issue.EntityID, eventType, issue.Message)
+	}
+	details := map[string]any{
+		"kind": issue.Kind,
+		"kid":  issue.KID,
+	}
+	if issue.Purpose != "" {
+		details["purpose"] = issue.Purpose
+	}
+	if issue.TrustMarkType != "" {
+		details["trust_mark_type"] = issue.TrustMarkType
+	}
+	if issue.ExpiresAt != 0 {
+		details["expires_at"] = issue.ExpiresAt
+	}
+	m.fed.notify(
+		Notification{
+			Type:     eventType,
+			Severity: issue.Severity,
+			Subject:  subject,
+			Message:  issue.Message,
+			Details:  details,
+		},
+	)
+}
+
+func (m *KeyHealthMonitor) recordSubordinateEvent(entityID, eventType, message string) {
+	storages := m.fed.storages
+	if storages.SubordinateEvents == nil || storages.Subordinates == nil {
+		return
+	}
+	info, err := storages.Subordinates.Get(entityID)
+	if err != nil || info == nil {
+		return
+	}
+	event := model.SubordinateEvent{
+		SubordinateID: info.ID,
+		Timestamp:     nowUnix(),
+		Type:          eventType,
+		Message:       strPtrOrNil(message),
+		Actor:         new(keyHealthActor),
+	}
+	if err = storages.SubordinateEvents.Add(event); err != nil {
+		log.Warn().Err(err).Str("entity_id", entityID).Msg("failed to record key health event")
+	}
+}
+
+// StartKeyHealthMonitor creates and starts a KeyHealthMonitor. It is stopped
+// with Stop.
+func (fed *LightHouse) StartKeyHealthMonitor(conf KeyHealthConfig) *KeyHealthMonitor {
+	if fed.keyHealth != nil {
+		fed.keyHealth.Stop()
+	}
+	fed.keyHealth = NewKeyHealthMonitor(fed, conf)
+	fed.keyHealth.Start()
+	return fed.keyHealth
+}
+
+// KeyHealth implements the adminapi.LighthouseController interface. If the
+// KeyHealthMonitor is not running, the keys are checked with the default
+// thresholds without sending notifications.
+func (fed *LightHouse) KeyHealth(refresh bool) (*adminapi.KeyHealthReport, error) {
+	if fed.keyHealth == nil {
+		return NewKeyHealthMonitor(fed, KeyHealthConfig{}).Check(time.Now()), nil
+	}
+	if !refresh {
+		if last := fed.keyHealth.Last(); last != nil {
+			return last, nil
+		}
+	}
+	return fed.keyHealth.RunOnce(), nil
+}
@@ -0,0 +1,150 @@
+package lighthouse
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-oidfed/lib/jwx"
+	"github.com/go-oidfed/lib/jwx/keymanagement/kms"
+	"github.com/go-oidfed/lib/jwx/keymanagement/public"
+	"github.com/go-oidfed/lib/unixtime"
+	"github.com/lestrrat-go/jwx/v4/jwa"
+	"github.com/lestrrat-go/jwx/v4/jwk"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/go-oidfed/lighthouse/api/adminapi"
+	"github.com/go-oidfed/lighthouse/storage"
+	"github.com/go-oidfed/lighthouse/storage/model"
+)
+
+type recordingNotifier struct {
+	mu            sync.Mutex
+	notifications []Notification
+}
+
+func (r *recordingNotifier) Notify(_ context.Context, n Notification) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.notifications = append(r.notifications, n)
+	return nil
+}
+
+func ecPublicJWK(t *testing.T, kid string) jwk.Key {
+	t.Helper()
+	sk, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	pub, err := jwk.PublicKeyOf(sk)
+	require.NoError(t, err)
+	require.NoError(t, pub.Set(jwk.KeyIDKey, kid))
+	require.NoError(t, pub.Set(jwk.AlgorithmKey, jwa.ES256()))
+	return pub
+}
+
+func addExpiringPK(t *testing.T, pks public.PublicKeyStorage, kid string, exp time.Time) {
+	t.Helper()
+	require.NoError(
+		t, pks.Add(
+			public.PublicKeyEntry{
+				KID: kid,
+				Key: public.JWKKey{Key: ecPublicJWK(t, kid)},
+				UpdateablePublicKeyMetadata: public.UpdateablePublicKeyMetadata{
+					ExpiresAt: &unixtime.Unixtime{Time: exp},
+				},
+			},
+		),
+	)
+}
+
+func jwksWithExp(t *testing.T, kid string, exp time.Time) jwx.JWKS {
+	t.Helper()
+	k := ecPublicJWK(t, kid)
+	require.NoError(t, k.Set("exp", unixtime.Unixtime{Time: exp}))
+	set := jwx.NewJWKS()
+	require.NoError(t, set.AddKey(k))
+	return set
+}
+
+func TestKeyHealthMonitor(t *testing.T) {
+	fed, store := newTestLightHouse(t)
+	notifier := &recordingNotifier{}
+	fed.notifier = notifier
+	trustAnchors := storage.NewTrustAnchorStorage(store.DB())
+	fed.storages.TrustAnchors = trustAnchors
+
+	now := time.Now()
+	kmsPKs := store.DBPublicKeyStorage("kms")
+	apiPKs := store.DBPublicKeyStorage("api")
+	require.NoError(t, kmsPKs.Load())
+	require.NoError(t, apiPKs.Load())
+	// The old key is replaced by the next key, which expires soon itself
+	addExpiringPK(t, kmsPKs, "old", now.Add(time.Hour))
+	addExpiringPK(t, kmsPKs, "next", now.Add(48*time.Hour))
+	addExpiringPK(t, apiPKs, "api", now.Add(10*24*time.Hour))
+	fed.keyManagement = adminapi.KeyManagement{
+		KMSManagedPKs: kmsPKs,
+		APIManagedPKs: apiPKs,
+	}
+
+	sub := addActiveSubordinate(t, fed, "https://rp.example.org")
+	jwks := jwksWithExp(t, "sub-expired", now.Add(-time.Hour))
+	for _, k := range jwksWithExp(t, "sub-valid", now.Add(90*24*time.Hour)).All() {
+		require.NoError(t, jwks.AddKey(k))
+	}
+	require.NoError(t, fed.storages.Subordinates.UpdateJWKSByEntityID(sub.EntityID, model.JWKS{Keys: jwks}))
+	_, err := trustAnchors.Create(
+		model.AddTrustAnchor{
+			EntityID: "https://ta.example.org",
+			JWKS:     &model.JWKS{Keys: jwksWithExp(t, "ta", now.Add(90*24*time.Hour))},
+		},
+	)
+	require.NoError(t, err)
+
+	m := NewKeyHealthMonitor(fed, KeyHealthConfig{})
+	report := m.Check(now)
+	assert.Equal(t, NotificationSeverityCritical, report.Status)
+	require.Len(t, report.SigningKeys, 2)
+	issues := make(map[string]adminapi.KeyHealthEntry)
+	for _, issue := range report.Issues {
+		issues[issue.KID] = issue
+	}
+	require.Len(t, issues, 3)
+	assert.Equal(t, adminapi.KeyHealthStatusExpiring, issues["next"].Status)
+	assert.Equal(t, NotificationSeverityCritical, issues["next"].Severity)
+	assert.Equal(t, adminapi.KeyHealthStatusExpiring, issues["api"].Status)
+	assert.Equal(t, NotificationSeverityWarning, issues["api"].Severity)
+	assert.Equal(t, adminapi.KeyHealthStatusExpired, issues["sub-expired"].Status)
+	assert.Equal(t, sub.EntityID, issues["sub-expired"].EntityID)
+
+	// Issues are only notified about once
+	m.RunOnce()
+	m.RunOnce()
+	assert.Len(t, notifier.notifications, 3)
+	assert.Equal(t, []string{model.EventTypeKeyExpired}, subordinateEventTypes(t, fed, sub.ID))
+
+	// A raised severity is notified about again
+	m.conf.CriticalThreshold = 11 * 24 * time.Hour
+	m.conf.WarningThreshold = 12 * 24 * time.Hour
+	m.RunOnce()
+	require.Len(t, notifier.notifications, 4)
+	assert.Equal(t, model.EventTypeKeyExpiring, notifier.notifications[3].Type)
+	assert.Equal(t, NotificationSeverityCritical, notifier.notifications[3].Severity)
+
+	// With automatic key rotation the KMS replaces expiring keys itself
+	require.NoError(t, storage.SetKeyRotation(fed.storages.KV, kms.KeyRotationConfig{Enabled: true}))
+	report = m.Check(now)
+	for _, issue := range report.Issues {
+		assert.NotEqual(t, adminapi.KeyHealthKindSigningKey, issue.Kind)
+	}
+	report = m.Check(now.Add(72 * time.Hour))
+	issues = make(map[string]adminapi.KeyHealthEntry)
+	for _, issue := range report.Issues {
+		issues[issue.KID] = issue
+	}
+	assert.Equal(t, adminapi.KeyHealthStatusExpired, issues["next"].Status)
+}
@@ -0,0 +1,135 @@
+package lighthouse
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/go-oidfed/lighthouse/internal"
+	"github.com/go-oidfed/lighthouse/internal/keyrollover"
+	"github.com/go-oidfed/lighthouse/storage/model"
+)
+
+// keyRolloverInterval is the time between two checks for due key rollover
+// stages.
+const keyRolloverInterval = 30 * time.Second
+
+// KeyRolloverRunner periodically executes the due stages of scheduled staged
+// key rollovers, see keyrollover.Rollover.
+type KeyRolloverRunner struct {
+	fed      *LightHouse
+	interval time.Duration
+	mu       sync.Mutex
+	runner   periodicRunner
+}
+
+// NewKeyRolloverRunner creates a new KeyRolloverRunner for the passed
+// LightHouse.
+func NewKeyRolloverRunner(fed *LightHouse) *KeyRolloverRunner {
+	return &KeyRolloverRunner{
+		fed:      fed,
+		interval: keyRolloverInterval,
+	}
+}
+
+// Start starts executing rollover stages in the background. Stages that
+// became due while the server was not running are executed right away.
+func (r *KeyRolloverRunner) Start() {
+	r.runner.start(
+		periodicTask{
+			interval:   r.interval,
+			runAtStart: true,
+			run:        func(context.Context) { r.RunOnce() },
+		},
+	)
+}
+
+// Stop stops the KeyRolloverRunner and waits for a running pass to finish.
+func (r *KeyRolloverRunner) Stop() {
+	r.runner.stop()
+}
+
+// RunOnce executes the due rollover stages of all key sets. Concurrent calls
+// are serialized.
+func (r *KeyRolloverRunner) RunOnce() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	kv := r.fed.storages.KV
+	if kv == nil {
+		return
+	}
+	keyManagement := r.fed.keyManagement
+	keySets := []keyrollover.KeySet{
+		{
+			Purpose: model.SigningPurposeFederation,
+			Keys:    keyManagement.Keys,
+			PKs:     keyManagement.KMSManagedPKs,
+		},
+	}
+	for purpose, purposeKeys := range keyManagement.Purposes {
+		keySets = append(
+			keySets, keyrollover.KeySet{
+				Purpose: purpose,
+				Keys:    purposeKeys.Keys,
+				PKs:     purposeKeys.KMSManagedPKs,
+			},
+		)
+	}
+	for _, ks := range keySets {
+		if ks.Keys == nil || ks.PKs == nil {
+			continue
+		}
+		r.advance(kv, ks)
+	}
+}
+
+func (r *KeyRolloverRunner) advance(kv model.KeyValueStore, ks keyrollover.KeySet) {
+	rollover, executed, err := keyrollover.Advance(kv, ks, time.Now())
+	for _, stage := range executed {
+		kids := rollover.NewKIDs
+		if stage == keyrollover.StageRetire {
+			kids = rollover.OldKIDs
+		}
+		log.Info().Str("purpose", ks.Purpose).Str("stage", stage).Strs("kids", kids).
+			Msg("key rollover stage executed")
+		r.fed.notify(
+			Notification{
+				Type:     "key_rollover_" + stage,
+				Severity: NotificationSeverityInfo,
+				Message:  "key rollover stage '" + stage + "' executed for keys " + strings.Join(kids, ", "),
+				Details: map[string]any{
+					"purpose": ks.Purpose,
+					"stage":   stage,
+					"kids":    kids,
+				},
+			},
+		)
+	}
+	if len(executed) > 0 {
+		// The published keys changed; the entity configuration and the
+		// historical keys must be re-signed right away, so the overlap
+		// between publishing and activating keys is kept
+		_ = internal.DeleteCache(internal.CacheKeyEntityConfiguration)
+		_ = internal.ClearCache(internal.CacheKeyHistoricalKeys)
+	}
+	if err != nil {
+		log.Error().Err(err).Str("purpose", ks.Purpose).Msg("key rollover failed")
+		if rollover == nil {
+			return
+		}
+		r.fed.notify(
+			Notification{
+				Type:     "key_rollover_failed",
+				Severity: NotificationSeverityCritical,
+				Message:  rollover.Error,
+				Details: map[string]any{
+					"purpose": ks.Purpose,
+				},
+			},
+		)
+	}
+}
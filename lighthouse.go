@@ -5,15 +5,19 @@ import (
 	_ "embed"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 	"net/url"
+	"slices"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/go-oidfed/lib"
 	"github.com/go-oidfed/lib/cache"
 	"github.com/go-oidfed/lib/jwx"
 	"github.com/go-oidfed/lib/jwx/keymanagement/kms"
+	"github.com/go-oidfed/lib/jwx/keymanagement/public"
 	"github.com/go-oidfed/lib/oidfedconst"
 	"github.com/go-oidfed/lib/unixtime"
 	"github.com/gofiber/fiber/v2"
@@ -23,11 +27,15 @@ import (
 	fiberlogger "github.com/gofiber/fiber/v2/middleware/logger"
 	"github.com/gofiber/fiber/v2/middleware/recover"
 	"github.com/gofiber/fiber/v2/middleware/requestid"
+	"github.com/lestrrat-go/jwx/v4/jwa"
+	"github.com/pkg/errors"
 	"github.com/rs/zerolog/log"
 
 	"github.com/go-oidfed/lighthouse/api/adminapi"
 	apistats "github.com/go-oidfed/lighthouse/api/stats"
 	"github.com/go-oidfed/lighthouse/internal"
+	"github.com/go-oidfed/lighthouse/internal/keyrollover"
+	"github.com/go-oidfed/lighthouse/internal/metadataschema"
 	"github.com/go-oidfed/lighthouse/internal/stats"
 	"github.com/go-oidfed/lighthouse/internal/utils"
 	"github.com/go-oidfed/lighthouse/internal/version"
@@ -107,6 +115,7 @@ type LightHouse struct {
 	oidfed.FederationEntity
 	*oidfed.TrustMarkIssuer
 	*jwx.GeneralJWTSigner
+	purposeSigners           map[string]*jwx.GeneralJWTSigner
 	server                   *fiber.App
 	adminAPIServer           *fiber.App
 	serverConf               ServerConf
@@ -115,6 +124,7 @@ type LightHouse struct {
 	LogoBanner               bool
 	VersionBanner            bool
 	storages                 model.Backends
+	metadataValidator        *metadataschema.Validator
 	statsCollector           *stats.Collector
 	statsAggregator          *stats.Aggregator
 	statsAggregatorCancel    context.CancelFunc
@@ -122,9 +132,29 @@ type LightHouse struct {
 	trustAnchorRepo          *TrustAnchorRepo
 	taJWKSRefresher          *oidfed.TAJWKSRefresher
 	subordinateJWKSRefresher *oidfed.SubordinateJWKSRefresher
+	jwksPolicy               *JWKSPolicy
 	endpointRegistry         *EndpointRegistry
 	backgroundStops          []func()
 	jtiCleanupStop           func()
+	notifier                 Notifier
+	revalidator              *EntityRevalidator
+	keyHealth                *KeyHealthMonitor
+	trustMarkHealth          *PublishedTrustMarkMonitor
+	keyRollover              *KeyRolloverRunner
+	staticExporter           *StaticExporter
+	delegationRenewer        *TrustMarkDelegationRenewer
+	delegationMu             sync.Mutex
+	pushDeliverer            *TrustMarkPushDeliverer
+	pushMu                   sync.Mutex
+	preIssuer                *TrustMarkPreIssuer
+	preIssueMu               sync.Mutex
+	logoHosting              *logoHosting
+	logoChecker              *LogoChecker
+	logoMu                   sync.Mutex
+	entityCollector          oidfed.EntityCollector
+	eligibilityCache         *EligibilityCache
+	issuedTrustMarkCache     *IssuedTrustMarkCache
+	reportCounters           *TrustMarkReportCounters
 }
 
 // FiberServerConfig is the fiber.Config that is used to init the http fiber.App
@@ -155,12 +185,17 @@ func NewLightHouse(
 		return nil, err
 	}
 
-	versatileSigner, err := createVersatileSigner(keyManagement)
+	versatileSigner, err := createVersatileSigner(keyManagement, storages.KV)
 	if err != nil {
 		return nil, err
 	}
 
 	generalSigner := jwx.NewGeneralJWTSigner(versatileSigner, keyManagement.BasicKeys.GetAlgs())
+	purposeSigners := createPurposeSigners(keyManagement, storages.KV)
+	trustMarkSigner := generalSigner
+	if s, ok := purposeSigners[model.SigningPurposeTrustMarks]; ok {
+		trustMarkSigner = s
+	}
 
 	server, err := initFiberServer(serverConf)
 	if err != nil {
@@ -178,18 +213,20 @@ func NewLightHouse(
 		storages.PublishedTrustMarks,
 		entityID,
 		"",
-		func() *jwx.TrustMarkSigner { return generalSigner.TrustMarkSigner() },
+		func() *jwx.TrustMarkSigner { return trustMarkSigner.TrustMarkSigner() },
 	)
 
 	entity := &LightHouse{
-		TrustMarkIssuer:         oidfed.NewTrustMarkIssuer(entityID, generalSigner.TrustMarkSigner(), nil),
+		TrustMarkIssuer:         oidfed.NewTrustMarkIssuer(entityID, trustMarkSigner.TrustMarkSigner(), nil),
 		GeneralJWTSigner:        generalSigner,
+		purposeSigners:          purposeSigners,
 		server:                  server,
 		serverConf:              serverConf,
 		LogoBanner:              true,
 		VersionBanner:           true,
 		keyManagement:           keyManagement,
 		storages:                storages,
+		metadataValidator:       metadataschema.NewValidator(storages.MetadataSchemas),
 		statsCollector:          statsCollector,
 		statsAggregator:         statsAggregator,
 		trustMarkConfigProvider: trustMarkConfigProvider,
@@ -220,11 +257,16 @@ func NewLightHouse(
 	return entity, nil
 }
 
-func createVersatileSigner(keyManagement adminapi.KeyManagement) (jwx.VersatileSigner, error) {
+// createVersatileSigner returns the jwx.VersatileSigner for the federation
+// keys. Its JWKS, which is published in the entity configuration, also
+// holds the keys of all key purposes, so trust marks and resolve responses can
+// be verified with it. Keys of a staged key rollover are left out until they
+// are published.
+func createVersatileSigner(keyManagement adminapi.KeyManagement, kv model.KeyValueStore) (jwx.VersatileSigner, error) {
 	return kms.KMSToVersatileSignerWithJWKSFunc(
 		keyManagement.BasicKeys,
 		func() (jwx.JWKS, error) {
-			kmsHistory, err := keyManagement.KMSManagedPKs.GetValid()
+			kmsHistory, err := publishedPKs(kv, model.SigningPurposeFederation, keyManagement.KMSManagedPKs)
 			if err != nil {
 				return jwx.JWKS{}, err
 			}
@@ -233,6 +275,13 @@ func createVersatileSigner(keyManagement adminapi.KeyManagement) (jwx.VersatileS
 				return jwx.JWKS{}, err
 			}
 			allEntries := append(kmsHistory, apiHistory...)
+			for purpose, purposeKeys := range keyManagement.Purposes {
+				purposeHistory, err := publishedPKs(kv, purpose, purposeKeys.KMSManagedPKs)
+				if err != nil {
+					return jwx.JWKS{}, err
+				}
+				allEntries = append(allEntries, purposeHistory...)
+			}
 			set := jwx.NewJWKS()
 			for _, k := range allEntries {
 				kk, err := k.JWK()
@@ -246,6 +295,107 @@ func createVersatileSigner(keyManagement adminapi.KeyManagement) (jwx.VersatileS
 	), nil
 }
 
+// createPurposeSigners returns a jwx.GeneralJWTSigner for each key purpose
+// with an own key set
+func createPurposeSigners(
+	keyManagement adminapi.KeyManagement, kv model.KeyValueStore,
+) map[string]*jwx.GeneralJWTSigner {
+	signers := make(map[string]*jwx.GeneralJWTSigner, len(keyManagement.Purposes))
+	for purpose, purposeKeys := range keyManagement.Purposes {
+		signers[purpose] = jwx.NewGeneralJWTSigner(
+			kms.KMSToVersatileSignerWithJWKSFunc(
+				purposeKeys.BasicKeys,
+				func() (jwx.JWKS, error) {
+					list, err := publishedPKs(kv, purpose, purposeKeys.KMSManagedPKs)
+					if err != nil {
+						return jwx.JWKS{}, err
+					}
+					return list.JWKS()
+				},
+			),
+			purposeKeys.BasicKeys.GetAlgs(),
+		)
+	}
+	return signers
+}
+
+// publishedPKs returns the valid keys of the passed public.PublicKeyStorage
+// without the keys of a staged key rollover that are not published yet
+func publishedPKs(
+	kv model.KeyValueStore, purpose string, pks public.PublicKeyStorage,
+) (public.PublicKeyEntryList, error) {
+	list, err := pks.GetValid()
+	if err != nil {
+		return nil, err
+	}
+	return keyrollover.PublishedKeys(kv, purpose, list)
+}
+
+// PurposeSigner returns the signer for the passed key purpose, e.g.
+// model.SigningPurposeTrustMarks. Purposes without an own key set use the
+// federation signer.
+func (fed *LightHouse) PurposeSigner(purpose string) *jwx.GeneralJWTSigner {
+	if s, ok := fed.purposeSigners[purpose]; ok {
+		return s
+	}
+	return fed.GeneralJWTSigner
+}
+
+// PurposeSigningAlgs returns the algorithms for which the key set of the
+// passed key purpose holds active keys; the default algorithm comes first.
+func (fed *LightHouse) PurposeSigningAlgs(purpose string) []jwa.SignatureAlgorithm {
+	keys := fed.keyManagement.BasicKeys
+	if purposeKeys, ok := fed.keyManagement.Purposes[purpose]; ok {
+		keys = purposeKeys.BasicKeys
+	}
+	if keys == nil {
+		return nil
+	}
+	defaultAlg := keys.GetDefaultAlg()
+	algs := []jwa.SignatureAlgorithm{defaultAlg}
+	for _, alg := range keys.GetAlgs() {
+		if alg != defaultAlg {
+			algs = append(algs, alg)
+		}
+	}
+	return algs
+}
+
+// requestedSigningAlgs returns the signing algorithms requested with the
+// optional 'alg' request parameter. The parameter holds a space separated
+// list of algorithms in order of preference.
+func requestedSigningAlgs(ctx *fiber.Ctx) []string {
+	alg := ctx.Query("alg")
+	if alg == "" && ctx.Method() == fiber.MethodPost {
+		alg = ctx.FormValue("alg")
+	}
+	return strings.Fields(alg)
+}
+
+// negotiateSigningAlg returns the first of the requested signing algorithms
+// for which the key set of the passed key purpose holds an active key. If no
+// algorithm was requested, nil is returned, i.e. the default algorithm is
+// used. If none of the requested algorithms is supported, an error is
+// returned.
+func (fed *LightHouse) negotiateSigningAlg(purpose string, requested []string) ([]string, error) {
+	if len(requested) == 0 {
+		return nil, nil
+	}
+	supported := make([]string, 0)
+	for _, alg := range fed.PurposeSigningAlgs(purpose) {
+		supported = append(supported, alg.String())
+	}
+	for _, r := range requested {
+		if slices.Contains(supported, r) {
+			return []string{r}, nil
+		}
+	}
+	return nil, errors.Errorf(
+		"none of the requested signing algorithms is supported, supported algorithms: %s",
+		strings.Join(supported, " "),
+	)
+}
+
 func initFiberServer(serverConf ServerConf) (*fiber.App, error) {
 	if tps := serverConf.TrustedProxies; len(tps) > 0 {
 		FiberServerConfig.TrustedProxies = serverConf.TrustedProxies
@@ -313,6 +463,7 @@ func buildDynamicFederationEntity(
 			if m == nil {
 				m = &oidfed.Metadata{}
 			}
+			entity.rewriteMetadataLogoURIs(m)
 			// Build base map from existing federation entity metadata (if any)
 			var base map[string]any
 			if m.FederationEntity != nil {
@@ -361,13 +512,17 @@ func buildDynamicFederationEntity(
 			return entity.GeneralJWTSigner.EntityStatementSigner(), nil
 		},
 		TrustMarks: func() ([]*oidfed.EntityConfigurationTrustMarkConfig, error) {
-			return entity.trustMarkConfigProvider.GetConfigs()
+			return entity.publishedTrustMarkConfigs()
 		},
 		TrustMarkIssuers: func() (oidfed.AllowedTrustMarkIssuers, error) {
 			return storages.TrustMarkTypes.IssuersByType()
 		},
 		TrustMarkOwners: func() (oidfed.TrustMarkOwners, error) {
-			return storages.TrustMarkTypes.OwnersByType()
+			owners, err := storages.TrustMarkTypes.OwnersByType()
+			if err != nil {
+				return nil, err
+			}
+			return entity.withOwnDelegationKeys(owners)
 		},
 		Extra: func() (map[string]any, []string, error) {
 			extra, crits, err := storage.GetEntityConfigurationAdditionalClaims(storages.AdditionalClaims)
@@ -384,36 +539,46 @@ func buildDynamicFederationEntity(
 }
 
 func registerEntityConfigurationEndpoint(server *fiber.App, entity *LightHouse) {
-	server.Get(
-		oidfedconst.FederationSuffix, func(ctx *fiber.Ctx) error {
-			var cached []byte
-			set, err := cache.Get(internal.CacheKeyEntityConfiguration, &cached)
-			if err != nil {
-				ctx.Status(fiber.StatusInternalServerError)
-				return ctx.JSON(oidfed.ErrorServerError(err.Error()))
-			}
-			if set {
-				ctx.Set(fiber.HeaderContentType, oidfedconst.ContentTypeEntityStatement)
-				return ctx.Send(cached)
-			}
-			ec, err := entity.EntityConfigurationPayload()
-			if err != nil {
-				return ctx.Status(fiber.StatusInternalServerError).JSON(oidfed.ErrorServerError(err.Error()))
-			}
-			jwt, err := entity.SignEntityStatement(*ec)
-			if err != nil {
-				return ctx.Status(fiber.StatusInternalServerError).JSON(oidfed.ErrorServerError(err.Error()))
-			}
-			if cacheErr := cache.Set(
-				internal.CacheKeyEntityConfiguration, jwt,
-				min(MaximumEntityConfigurationCachePeriod, time.Until(ec.ExpiresAt.Time.Add(-1*time.Minute))),
-			); cacheErr != nil {
+	server.Get(oidfedconst.FederationSuffix, entity.entityConfigurationHandler)
+}
+
+// entityConfigurationHandler serves the signed entity configuration.
+func (fed *LightHouse) entityConfigurationHandler(ctx *fiber.Ctx) error {
+	var cached []byte
+	set, err := cache.Get(internal.CacheKeyEntityConfiguration, &cached)
+	if err != nil {
+		ctx.Status(fiber.StatusInternalServerError)
+		return ctx.JSON(oidfed.ErrorServerError(err.Error()))
+	}
+	if set {
+		ctx.Set(fiber.HeaderContentType, oidfedconst.ContentTypeEntityStatement)
+		return ctx.Send(cached)
+	}
+	if jwt, exp, ok := fed.presignedStatement(fed.FederationEntity.EntityID()); ok {
+		if ttl := min(MaximumEntityConfigurationCachePeriod, time.Until(exp.Add(-time.Minute))); ttl > 0 {
+			if cacheErr := cache.Set(internal.CacheKeyEntityConfiguration, jwt, ttl); cacheErr != nil {
 				log.Error().Err(cacheErr).Msg("failed to cache entity configuration")
 			}
-			ctx.Set(fiber.HeaderContentType, oidfedconst.ContentTypeEntityStatement)
-			return ctx.Send(jwt)
-		},
-	)
+		}
+		ctx.Set(fiber.HeaderContentType, oidfedconst.ContentTypeEntityStatement)
+		return ctx.Send(jwt)
+	}
+	ec, err := fed.EntityConfigurationPayload()
+	if err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(oidfed.ErrorServerError(err.Error()))
+	}
+	jwt, err := fed.SignEntityStatement(*ec)
+	if err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(oidfed.ErrorServerError(err.Error()))
+	}
+	if cacheErr := cache.Set(
+		internal.CacheKeyEntityConfiguration, jwt,
+		min(MaximumEntityConfigurationCachePeriod, time.Until(ec.ExpiresAt.Time.Add(-1*time.Minute))),
+	); cacheErr != nil {
+		log.Error().Err(cacheErr).Msg("failed to cache entity configuration")
+	}
+	ctx.Set(fiber.HeaderContentType, oidfedconst.ContentTypeEntityStatement)
+	return ctx.Send(jwt)
 }
 
 func initAdminAPI(
@@ -466,6 +631,7 @@ func initAdminAPI(
 			UsersEnabled:               admin.UsersEnabled,
 			Port:                       admin.Port,
 			TrustMarkConfigInvalidator: trustMarkConfigProvider,
+			ValidateMetadata:           admin.ValidateMetadata,
 			Actor: adminapi.ActorConfig{
 				Header: admin.ActorHeader,
 				Source: adminapi.ActorSource(admin.ActorSource),
@@ -593,6 +759,13 @@ func (fed *LightHouse) Start() {
 		}()
 	}
 
+	// Execute staged key rollovers; like the aggregator only in the parent
+	// process.
+	if fed.storages.KV != nil && !fiber.IsChild() {
+		fed.keyRollover = NewKeyRolloverRunner(fed)
+		fed.keyRollover.Start()
+	}
+
 	conf := fed.serverConf
 	adminTLS := fed.adminAPIServer != nil && fed.adminAPIServer != fed.server && fed.serverConf.AdminTLS.Enabled
 
@@ -656,6 +829,9 @@ func (fed *LightHouse) Start() {
 
 // Stop gracefully shuts down the LightHouse server and its components.
 func (fed *LightHouse) Stop() error {
+	// Release the key sets once the servers are shut down
+	defer fed.closeKeySets()
+
 	// Stop background services
 	fed.stopBackgroundServices()
 
@@ -669,6 +845,45 @@ func (fed *LightHouse) Stop() error {
 		fed.subordinateJWKSRefresher.Stop()
 	}
 
+	// Stop entity revalidator if running
+	if fed.revalidator != nil {
+		fed.revalidator.Stop()
+	}
+
+	// Stop key health monitor if running
+	if fed.keyHealth != nil {
+		fed.keyHealth.Stop()
+	}
+
+	// Stop published trust mark monitor if running
+	if fed.trustMarkHealth != nil {
+		fed.trustMarkHealth.Stop()
+	}
+
+	// Stop key rollover runner if running
+	if fed.keyRollover != nil {
+		fed.keyRollover.Stop()
+	}
+
+	// Stop static export if running
+	if fed.staticExporter != nil {
+		fed.staticExporter.Stop()
+	}
+
+	// Stop trust mark delegation renewal if running
+	if fed.delegationRenewer != nil {
+		fed.delegationRenewer.Stop()
+	}
+	if fed.pushDeliverer != nil {
+		fed.pushDeliverer.Stop()
+	}
+	if fed.preIssuer != nil {
+		fed.preIssuer.Stop()
+	}
+	if fed.logoChecker != nil {
+		fed.logoChecker.Stop()
+	}
+
 	// Stop JTI cleanup if running
 	if fed.jtiCleanupStop != nil {
 		fed.jtiCleanupStop()
@@ -681,6 +896,11 @@ func (fed *LightHouse) Stop() error {
 		}
 	}
 
+	// Flush the buffered trust mark report counters
+	if fed.reportCounters != nil {
+		fed.reportCounters.Stop()
+	}
+
 	// Stop stats aggregator if running
 	if fed.statsAggregatorCancel != nil {
 		fed.statsAggregatorCancel()
@@ -700,6 +920,24 @@ func (fed *LightHouse) Stop() error {
 	return nil
 }
 
+// closeKeySets closes the key management systems of all key sets that hold
+// resources, e.g. background syncs or token sessions.
+func (fed *LightHouse) closeKeySets() {
+	closeKeySet := func(purpose string, keyManagement adminapi.KeyManagement) {
+		closer, ok := keyManagement.BasicKeys.(io.Closer)
+		if !ok {
+			return
+		}
+		if err := closer.Close(); err != nil {
+			log.Warn().Err(err).Str("purpose", purpose).Msg("error closing key management system")
+		}
+	}
+	closeKeySet(model.SigningPurposeFederation, fed.keyManagement)
+	for purpose, keyManagement := range fed.keyManagement.Purposes {
+		closeKeySet(purpose, keyManagement)
+	}
+}
+
 // CreateSubordinateStatement returns an oidfed.EntityStatementPayload for the passed storage.ExtendedSubordinateInfo
 func (fed *LightHouse) CreateSubordinateStatement(subordinate *model.ExtendedSubordinateInfo) oidfed.EntityStatementPayload {
 	now := time.Now()
@@ -847,6 +1085,9 @@ type AdminAPIOptions struct {
 	CORS CORSConf
 	// TLS holds TLS configuration for the admin API.
 	TLS TLSConf
+	// ValidateMetadata enables JSON Schema validation of metadata written
+	// through the admin API.
+	ValidateMetadata bool
 }
 
 // corsConfigFromConf converts a CORSConf to a Fiber CORS middleware configuration.
@@ -0,0 +1,852 @@
+package lighthouse
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"image"
+	_ "image/gif"  // register GIF for logo validation
+	_ "image/jpeg" // register JPEG for logo validation
+	_ "image/png"  // register PNG for logo validation
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	oidfed "github.com/go-oidfed/lib"
+	"github.com/go-oidfed/lib/apimodel"
+	"github.com/gofiber/fiber/v2"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+
+	"github.com/go-oidfed/lighthouse/api/adminapi"
+	"github.com/go-oidfed/lighthouse/internal"
+	"github.com/go-oidfed/lighthouse/storage"
+	"github.com/go-oidfed/lighthouse/storage/model"
+)
+
+// logoHTTPTimeout is the timeout for fetching external logos.
+const logoHTTPTimeout = 10 * time.Second
+
+// logoCollectionUsedByPrefix prefixes the usages of logo URIs taken from the
+// ui_infos of the entity collection.
+const logoCollectionUsedByPrefix = "entity_collection:"
+
+// maxLogoCollectionPages limits the number of entity collection pages that
+// are read for the logo check.
+const maxLogoCollectionPages = 100
+
+// logoMirrorsCacheTTL is how long the mapping of external logo URIs to hosted
+// logos is kept in memory.
+const logoMirrorsCacheTTL = time.Minute
+
+// errLogoHostingDisabled is returned by the logo management if logo hosting is
+// not enabled.
+var errLogoHostingDisabled = model.ValidationError("logo hosting is not enabled")
+
+// errNotSVG is returned by svgDimensions if the data is not an SVG image.
+var errNotSVG = errors.New("not an svg image")
+
+// LogoHostingConfig configures the hosting of logos.
+type LogoHostingConfig struct {
+	// Path is the path the hosted logos are served under.
+	Path string
+	// MaxSize is the maximum size of a logo in bytes.
+	MaxSize int
+	// MaxWidth and MaxHeight are the maximum dimensions of a logo in pixels.
+	MaxWidth  int
+	MaxHeight int
+	// CacheMaxAge is the max-age of the Cache-Control header of served logos.
+	CacheMaxAge time.Duration
+	// CheckExternal enables the periodic check of the external logo URIs.
+	CheckExternal bool
+	// CheckInterval is the time between two checks of the external logo URIs.
+	CheckInterval time.Duration
+	// MirrorExternal makes the check host copies of valid external logos that
+	// are not hosted yet.
+	MirrorExternal bool
+}
+
+func withLogoHostingDefaults(conf LogoHostingConfig) LogoHostingConfig {
+	conf.Path = "/" + strings.Trim(conf.Path, "/")
+	if conf.Path == "/" {
+		conf.Path = "/logos"
+	}
+	if conf.MaxSize <= 0 {
+		conf.MaxSize = 256 * 1024
+	}
+	if conf.MaxWidth <= 0 {
+		conf.MaxWidth = 1024
+	}
+	if conf.MaxHeight <= 0 {
+		conf.MaxHeight = 1024
+	}
+	if conf.CacheMaxAge <= 0 {
+		conf.CacheMaxAge = 24 * time.Hour
+	}
+	if conf.CheckInterval <= 0 {
+		conf.CheckInterval = 24 * time.Hour
+	}
+	return conf
+}
+
+// logoHosting holds the state of the logo hosting.
+type logoHosting struct {
+	conf LogoHostingConfig
+
+	mu       sync.RWMutex
+	mirrors  map[string]uint
+	loadedAt time.Time
+}
+
+// StartLogoHosting enables the hosting of logos and, if configured, starts a
+// LogoChecker. The LogoChecker is stopped with Stop.
+func (fed *LightHouse) StartLogoHosting(conf LogoHostingConfig) {
+	conf = withLogoHostingDefaults(conf)
+	fed.logoHosting = &logoHosting{conf: conf}
+	if fed.logoChecker != nil {
+		fed.logoChecker.Stop()
+		fed.logoChecker = nil
+	}
+	if conf.CheckExternal {
+		fed.logoChecker = NewLogoChecker(fed)
+		fed.logoChecker.Start()
+	}
+	log.Info().Str("path", conf.Path).Msg("logo hosting enabled")
+}
+
+// logoURI returns the public URI of the hosted logo with the passed id.
+func (fed *LightHouse) logoURI(id uint) string {
+	uri, _ := url.JoinPath(fed.FederationEntity.EntityID(), fed.logoHosting.conf.Path, strconv.FormatUint(uint64(id), 10))
+	return uri
+}
+
+// isHostedLogoURI reports whether the passed URI points to a hosted logo.
+func (fed *LightHouse) isHostedLogoURI(uri string) bool {
+	base, _ := url.JoinPath(fed.FederationEntity.EntityID(), fed.logoHosting.conf.Path)
+	return strings.HasPrefix(uri, base+"/")
+}
+
+// logoMirrors returns the ids of the hosted logos per external logo URI.
+func (fed *LightHouse) logoMirrors() map[string]uint {
+	h := fed.logoHosting
+	h.mu.RLock()
+	if h.mirrors != nil && time.Since(h.loadedAt) < logoMirrorsCacheTTL {
+		defer h.mu.RUnlock()
+		return h.mirrors
+	}
+	h.mu.RUnlock()
+
+	logos, err := fed.storages.Logos.List()
+	if err != nil {
+		log.Warn().Err(err).Msg("failed to list hosted logos")
+		return nil
+	}
+	mirrors := make(map[string]uint)
+	for _, l := range logos {
+		if l.SourceURI != "" {
+			mirrors[l.SourceURI] = l.ID
+		}
+	}
+	h.mu.Lock()
+	h.mirrors = mirrors
+	h.loadedAt = time.Now()
+	h.mu.Unlock()
+	return mirrors
+}
+
+// logosChanged drops the cached logo mirrors and the cached entity
+// configuration, whose logo URIs might be rewritten.
+func (fed *LightHouse) logosChanged() {
+	h := fed.logoHosting
+	h.mu.Lock()
+	h.mirrors = nil
+	h.mu.Unlock()
+	_ = internal.DeleteCache(internal.CacheKeyEntityConfiguration)
+}
+
+// HostedLogoURI returns the URI of the hosted logo that is published in place
+// of the passed external logo URI. If there is none or logo hosting is not
+// enabled, the passed URI is returned.
+func (fed *LightHouse) HostedLogoURI(uri string) string {
+	if uri == "" || fed.logoHosting == nil || fed.storages.Logos == nil {
+		return uri
+	}
+	if id, ok := fed.logoMirrors()[uri]; ok {
+		return fed.logoURI(id)
+	}
+	return uri
+}
+
+// rewriteMetadataLogoURIs replaces the logo URIs of the passed metadata with
+// the URIs of their hosted logos.
+func (fed *LightHouse) rewriteMetadataLogoURIs(m *oidfed.Metadata) {
+	if m == nil || fed.logoHosting == nil {
+		return
+	}
+	if m.FederationEntity != nil {
+		m.FederationEntity.LogoURI = fed.HostedLogoURI(m.FederationEntity.LogoURI)
+	}
+	if m.OpenIDProvider != nil {
+		m.OpenIDProvider.LogoURI = fed.HostedLogoURI(m.OpenIDProvider.LogoURI)
+	}
+	if m.RelyingParty != nil {
+		m.RelyingParty.LogoURI = fed.HostedLogoURI(m.RelyingParty.LogoURI)
+	}
+	if m.OAuthProtectedResource != nil {
+		m.OAuthProtectedResource.LogoURI = fed.HostedLogoURI(m.OAuthProtectedResource.LogoURI)
+	}
+}
+
+// rewriteCollectionLogoURIs returns the passed entity collection response
+// with the logo URIs of the ui_infos replaced with the URIs of their hosted
+// logos. The passed response is not modified.
+func (fed *LightHouse) rewriteCollectionLogoURIs(res *oidfed.EntityCollectionResponse) *oidfed.EntityCollectionResponse {
+	if res == nil || fed.logoHosting == nil || fed.storages.Logos == nil || len(fed.logoMirrors()) == 0 {
+		return res
+	}
+	rewritten := *res
+	rewritten.Entities = make([]*oidfed.CollectedEntity, len(res.Entities))
+	for i, e := range res.Entities {
+		rewritten.Entities[i] = e
+		if e == nil || len(e.UIInfos) == 0 {
+			continue
+		}
+		entity := *e
+		entity.UIInfos = make(map[string]oidfed.UIInfo, len(e.UIInfos))
+		for entityType, ui := range e.UIInfos {
+			ui.LogoURI = fed.HostedLogoURI(ui.LogoURI)
+			entity.UIInfos[entityType] = ui
+		}
+		rewritten.Entities[i] = &entity
+	}
+	return &rewritten
+}
+
+// validateLogo checks that the passed data is a PNG, JPEG, GIF or SVG image
+// within the configured size and dimensions and returns it as a model.Logo.
+func (h *logoHosting) validateLogo(data []byte) (*model.Logo, error) {
+	if len(data) == 0 {
+		return nil, model.ValidationError("logo is empty")
+	}
+	if len(data) > h.conf.MaxSize {
+		return nil, model.ValidationErrorFmt("logo exceeds the maximum size of %d bytes", h.conf.MaxSize)
+	}
+	logo := &model.Logo{Size: len(data)}
+	if config, format, err := image.DecodeConfig(bytes.NewReader(data)); err == nil {
+		logo.ContentType = "image/" + format
+		logo.Width, logo.Height = config.Width, config.Height
+	} else {
+		width, height, svgErr := svgDimensions(data)
+		if errors.Is(svgErr, errNotSVG) {
+			return nil, model.ValidationError("unsupported image type: supported are PNG, JPEG, GIF and SVG")
+		}
+		if svgErr != nil {
+			return nil, svgErr
+		}
+		logo.ContentType = "image/svg+xml"
+		logo.Width, logo.Height = width, height
+	}
+	if logo.Width > h.conf.MaxWidth || logo.Height > h.conf.MaxHeight {
+		return nil, model.ValidationErrorFmt(
+			"logo dimensions %dx%d exceed the maximum of %dx%d", logo.Width, logo.Height, h.conf.MaxWidth,
+			h.conf.MaxHeight,
+		)
+	}
+	if logo.ContentType != "image/svg+xml" {
+		if _, _, err := image.Decode(bytes.NewReader(data)); err != nil {
+			return nil, model.ValidationErrorFmt("invalid %s image: %v", strings.TrimPrefix(logo.ContentType, "image/"), err)
+		}
+	}
+	digest := sha256.Sum256(data)
+	logo.Digest = hex.EncodeToString(digest[:])
+	logo.Data = data
+	return logo, nil
+}
+
+// svgDimensions checks that the passed data is an SVG image without scripts,
+// event handlers and references to external resources, and returns its
+// dimensions; they are 0 if the image has no absolute dimensions. It returns
+// errNotSVG if the data is not an SVG image.
+func svgDimensions(data []byte) (width, height int, err error) {
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	root := true
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if root {
+				return 0, 0, errNotSVG
+			}
+			return 0, 0, model.ValidationErrorFmt("invalid svg image: %v", err)
+		}
+		switch t := tok.(type) {
+		case xml.Directive:
+			if bytes.Contains(bytes.ToUpper(t), []byte("ENTITY")) {
+				return 0, 0, model.ValidationError("svg image must not declare entities")
+			}
+		case xml.StartElement:
+			name := strings.ToLower(t.Name.Local)
+			if root {
+				if name != "svg" {
+					return 0, 0, errNotSVG
+				}
+				root = false
+				width, height = svgSize(t.Attr)
+			}
+			if name == "script" || name == "foreignobject" {
+				return 0, 0, model.ValidationErrorFmt("svg image must not contain %s elements", t.Name.Local)
+			}
+			for _, attr := range t.Attr {
+				attrName := strings.ToLower(attr.Name.Local)
+				if strings.HasPrefix(attrName, "on") {
+					return 0, 0, model.ValidationError("svg image must not contain event handlers")
+				}
+				value := strings.TrimSpace(attr.Value)
+				if attrName == "href" && !strings.HasPrefix(value, "#") && !strings.HasPrefix(value, "data:image/") {
+					return 0, 0, model.ValidationError("svg image must not reference external resources")
+				}
+			}
+		}
+	}
+	if root {
+		return 0, 0, errNotSVG
+	}
+	return width, height, nil
+}
+
+// svgSize returns the dimensions of an SVG image from the width and height or
+// the viewBox attributes of its root element.
+func svgSize(attrs []xml.Attr) (width, height int) {
+	var viewBox string
+	var w, h float64
+	for _, attr := range attrs {
+		switch attr.Name.Local {
+		case "width":
+			w = svgLength(attr.Value)
+		case "height":
+			h = svgLength(attr.Value)
+		case "viewBox":
+			viewBox = attr.Value
+		}
+	}
+	if (w == 0 || h == 0) && viewBox != "" {
+		fields := strings.FieldsFunc(viewBox, func(r rune) bool { return r == ' ' || r == ',' })
+		if len(fields) == 4 {
+			vw, errW := strconv.ParseFloat(fields[2], 64)
+			vh, errH := strconv.ParseFloat(fields[3], 64)
+			if errW == nil && errH == nil {
+				w, h = vw, vh
+			}
+		}
+	}
+	return int(math.Ceil(w)), int(math.Ceil(h))
+}
+
+// svgLength parses an absolute SVG length in pixels; it returns 0 for
+// relative lengths.
+func svgLength(v string) float64 {
+	f, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(v), "px"), 64)
+	if err != nil || f < 0 {
+		return 0
+	}
+	return f
+}
+
+// fetchLogo fetches and validates the external logo at the passed URI.
+// Third-party logo URIs, i.e. those only found in the entity collection, must
+// be https URIs of hosts with public addresses, also after redirects.
+func (fed *LightHouse) fetchLogo(uri string, thirdParty bool) (*model.Logo, error) {
+	u, err := url.Parse(uri)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return nil, model.ValidationErrorFmt("invalid logo uri: %s", uri)
+	}
+	client := &http.Client{Timeout: logoHTTPTimeout}
+	if thirdParty {
+		if u.Scheme != "https" || u.User != nil {
+			return nil, model.ValidationErrorFmt("invalid third-party logo uri: %s", uri)
+		}
+		client = publicHTTPSClient(logoHTTPTimeout)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), logoHTTPTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, uri, http.NoBody)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fetch logo")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("failed to fetch logo: unexpected status %d", resp.StatusCode)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, int64(fed.logoHosting.conf.MaxSize)+1))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read logo")
+	}
+	logo, err := fed.logoHosting.validateLogo(data)
+	if err != nil {
+		return nil, err
+	}
+	logo.SourceURI = uri
+	return logo, nil
+}
+
+// serveLogo serves a hosted logo.
+func (fed *LightHouse) serveLogo(ctx *fiber.Ctx) error {
+	if ctx.Method() != fiber.MethodGet && ctx.Method() != fiber.MethodHead {
+		return ctx.Status(fiber.StatusMethodNotAllowed).JSON(
+			map[string]string{
+				"error":             "method_not_allowed",
+				"error_description": "logos can only be retrieved with GET",
+			},
+		)
+	}
+	ident := strings.TrimPrefix(ctx.Path(), fed.logoHosting.conf.Path+"/")
+	logo, err := fed.storages.Logos.Get(ident)
+	if err != nil {
+		var notFound model.NotFoundError
+		if errors.As(err, &notFound) {
+			return ctx.Status(fiber.StatusNotFound).JSON(oidfed.ErrorNotFound("logo not found"))
+		}
+		return ctx.Status(fiber.StatusInternalServerError).JSON(oidfed.ErrorServerError(err.Error()))
+	}
+	ctx.Set(fiber.HeaderContentType, logo.ContentType)
+	ctx.Set(fiber.HeaderCacheControl, fmt.Sprintf("public, max-age=%d", int(fed.logoHosting.conf.CacheMaxAge.Seconds())))
+	ctx.Set(fiber.HeaderETag, `"`+logo.Digest+`"`)
+	ctx.Set(fiber.HeaderXContentTypeOptions, "nosniff")
+	ctx.Set(fiber.HeaderContentSecurityPolicy, "default-src 'none'; style-src 'unsafe-inline'; sandbox")
+	if ctx.Fresh() {
+		return ctx.SendStatus(fiber.StatusNotModified)
+	}
+	return ctx.Send(logo.Data)
+}
+
+// isLogoPath reports whether the passed path is served by the logo hosting.
+func (fed *LightHouse) isLogoPath(path string) bool {
+	return fed.logoHosting != nil && fed.storages.Logos != nil &&
+		strings.HasPrefix(path, fed.logoHosting.conf.Path+"/")
+}
+
+func (fed *LightHouse) hostedLogo(logo *model.Logo) *adminapi.HostedLogo {
+	return &adminapi.HostedLogo{
+		Logo: *logo,
+		URI:  fed.logoURI(logo.ID),
+	}
+}
+
+func (fed *LightHouse) logoHostingEnabled() error {
+	if fed.logoHosting == nil || fed.storages.Logos == nil {
+		return errLogoHostingDisabled
+	}
+	return nil
+}
+
+// HostedLogos implements the adminapi.LighthouseController interface.
+func (fed *LightHouse) HostedLogos() ([]adminapi.HostedLogo, error) {
+	if err := fed.logoHostingEnabled(); err != nil {
+		return nil, err
+	}
+	logos, err := fed.storages.Logos.List()
+	if err != nil {
+		return nil, err
+	}
+	hosted := make([]adminapi.HostedLogo, len(logos))
+	for i := range logos {
+		hosted[i] = *fed.hostedLogo(&logos[i])
+	}
+	return hosted, nil
+}
+
+// HostedLogo implements the adminapi.LighthouseController interface.
+func (fed *LightHouse) HostedLogo(logoID string) (*adminapi.HostedLogo, error) {
+	if err := fed.logoHostingEnabled(); err != nil {
+		return nil, err
+	}
+	logo, err := fed.storages.Logos.Get(logoID)
+	if err != nil {
+		return nil, err
+	}
+	return fed.hostedLogo(logo), nil
+}
+
+// AddLogo implements the adminapi.LighthouseController interface.
+func (fed *LightHouse) AddLogo(data []byte, sourceURI string) (*adminapi.HostedLogo, error) {
+	if err := fed.logoHostingEnabled(); err != nil {
+		return nil, err
+	}
+	logo, err := fed.logoHosting.validateLogo(data)
+	if err != nil {
+		return nil, err
+	}
+	logo.SourceURI = sourceURI
+	created, err := fed.storages.Logos.Create(logo)
+	if err != nil {
+		return nil, err
+	}
+	fed.logosChanged()
+	return fed.hostedLogo(created), nil
+}
+
+// ReplaceLogo implements the adminapi.LighthouseController interface.
+func (fed *LightHouse) ReplaceLogo(logoID string, data []byte) (*adminapi.HostedLogo, error) {
+	if err := fed.logoHostingEnabled(); err != nil {
+		return nil, err
+	}
+	logo, err := fed.logoHosting.validateLogo(data)
+	if err != nil {
+		return nil, err
+	}
+	updated, err := fed.storages.Logos.UpdateImage(logoID, logo)
+	if err != nil {
+		return nil, err
+	}
+	return fed.hostedLogo(updated), nil
+}
+
+// ImportLogo implements the adminapi.LighthouseController interface.
+func (fed *LightHouse) ImportLogo(sourceURI string) (*adminapi.HostedLogo, error) {
+	if err := fed.logoHostingEnabled(); err != nil {
+		return nil, err
+	}
+	fed.logoMu.Lock()
+	defer fed.logoMu.Unlock()
+	logo, err := fed.fetchLogo(sourceURI, false)
+	if err != nil {
+		return nil, err
+	}
+	imported, err := fed.storeFetchedLogo(logo)
+	if err != nil {
+		return nil, err
+	}
+	return fed.hostedLogo(imported), nil
+}
+
+// storeFetchedLogo hosts a copy of the passed fetched external logo or
+// updates the existing copy.
+func (fed *LightHouse) storeFetchedLogo(logo *model.Logo) (*model.Logo, error) {
+	existing, err := fed.storages.Logos.GetBySourceURI(logo.SourceURI)
+	var notFound model.NotFoundError
+	if errors.As(err, &notFound) {
+		created, err := fed.storages.Logos.Create(logo)
+		if err != nil {
+			return nil, err
+		}
+		if err = fed.storages.Logos.SetCheckResult(logoIdent(created.ID), nowUnix(), ""); err != nil {
+			return nil, err
+		}
+		fed.logosChanged()
+		return fed.storages.Logos.Get(logoIdent(created.ID))
+	}
+	if err != nil {
+		return nil, err
+	}
+	ident := logoIdent(existing.ID)
+	if existing.Digest != logo.Digest {
+		if _, err = fed.storages.Logos.UpdateImage(ident, logo); err != nil {
+			return nil, err
+		}
+	}
+	if err = fed.storages.Logos.SetCheckResult(ident, nowUnix(), ""); err != nil {
+		return nil, err
+	}
+	return fed.storages.Logos.Get(ident)
+}
+
+// DeleteLogo implements the adminapi.LighthouseController interface.
+func (fed *LightHouse) DeleteLogo(logoID string) error {
+	if err := fed.logoHostingEnabled(); err != nil {
+		return err
+	}
+	if err := fed.storages.Logos.Delete(logoID); err != nil {
+		return err
+	}
+	fed.logosChanged()
+	return nil
+}
+
+// LogoCheckReport implements the adminapi.LighthouseController interface.
+func (fed *LightHouse) LogoCheckReport() (*adminapi.LogoCheckReport, error) {
+	if err := fed.logoHostingEnabled(); err != nil {
+		return nil, err
+	}
+	report := &adminapi.LogoCheckReport{Logos: []adminapi.LogoCheck{}}
+	if fed.storages.KV == nil {
+		return report, nil
+	}
+	if _, err := fed.storages.KV.GetAs(model.KeyValueScopeLogos, model.KeyValueKeyLogoCheck, report); err != nil {
+		return nil, errors.Wrap(err, "failed to load logo check report")
+	}
+	return report, nil
+}
+
+// CheckLogos implements the adminapi.LighthouseController interface.
+func (fed *LightHouse) CheckLogos() (*adminapi.LogoCheckReport, error) {
+	if err := fed.logoHostingEnabled(); err != nil {
+		return nil, err
+	}
+	return fed.checkLogos()
+}
+
+func logoIdent(id uint) string {
+	return strconv.FormatUint(uint64(id), 10)
+}
+
+// externalLogoURIs returns the external logo URIs in use together with where
+// they are used. The source URIs of hosted logos are included.
+func (fed *LightHouse) externalLogoURIs() (map[string][]string, error) {
+	uses := make(map[string][]string)
+	add := func(uri, usedBy string) {
+		if uri == "" || fed.isHostedLogoURI(uri) {
+			return
+		}
+		if usedBy == "" {
+			if _, ok := uses[uri]; !ok {
+				uses[uri] = nil
+			}
+			return
+		}
+		uses[uri] = append(uses[uri], usedBy)
+	}
+	if fed.storages.TrustMarkSpecs != nil {
+		specs, err := fed.storages.TrustMarkSpecs.List()
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to list trust mark specs")
+		}
+		for _, spec := range specs {
+			add(spec.LogoURI, "trust_mark_spec:"+spec.TrustMarkType)
+		}
+	}
+	metadata, err := storage.GetMetadata(fed.storages.KV)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load metadata")
+	}
+	if metadata != nil {
+		if metadata.FederationEntity != nil {
+			add(metadata.FederationEntity.LogoURI, "entity_configuration:federation_entity")
+		}
+		if metadata.OpenIDProvider != nil {
+			add(metadata.OpenIDProvider.LogoURI, "entity_configuration:openid_provider")
+		}
+		if metadata.RelyingParty != nil {
+			add(metadata.RelyingParty.LogoURI, "entity_configuration:openid_relying_party")
+		}
+		if metadata.OAuthProtectedResource != nil {
+			add(metadata.OAuthProtectedResource.LogoURI, "entity_configuration:oauth_resource")
+		}
+	}
+	logos, err := fed.storages.Logos.List()
+	if err != nil {
+		return nil, err
+	}
+	for _, l := range logos {
+		add(l.SourceURI, "")
+	}
+	fed.collectionLogoURIs(add)
+	return uses, nil
+}
+
+// collectionLogoURIs passes the logo URIs of the ui_infos of the collected
+// entities to add. Errors of the entity collection are only logged, so they
+// do not prevent the check of the other logos.
+func (fed *LightHouse) collectionLogoURIs(add func(uri, usedBy string)) {
+	if fed.entityCollector == nil {
+		return
+	}
+	req := apimodel.EntityCollectionRequest{
+		TrustAnchor:  fed.FederationEntity.EntityID(),
+		EntityClaims: []string{"entity_id", "ui_infos"},
+		UIClaims:     []string{"logo_uri"},
+	}
+	for range maxLogoCollectionPages {
+		res, errRes := fed.entityCollector.CollectEntities(req)
+		if errRes != nil {
+			log.Warn().Int("status", errRes.Status).Interface("response", errRes.Error).
+				Msg("logo check: failed to collect entities")
+			return
+		}
+		if res == nil {
+			return
+		}
+		for _, e := range res.Entities {
+			if e == nil {
+				continue
+			}
+			for entityType, ui := range e.UIInfos {
+				add(ui.LogoURI, logoCollectionUsedByPrefix+e.EntityID+":"+entityType)
+			}
+		}
+		if res.Next == "" {
+			return
+		}
+		req.From = res.Next
+	}
+	log.Warn().Int("pages", maxLogoCollectionPages).Msg("logo check: entity collection has too many pages")
+}
+
+// isThirdPartyLogo reports whether a logo URI with the passed usages is only
+// used by collected entities, i.e. was not configured in LightHouse.
+func isThirdPartyLogo(usedBy []string) bool {
+	if len(usedBy) == 0 {
+		return false
+	}
+	for _, u := range usedBy {
+		if !strings.HasPrefix(u, logoCollectionUsedByPrefix) {
+			return false
+		}
+	}
+	return true
+}
+
+// checkLogos fetches and validates all external logo URIs, updates the hosted
+// copies and, if configured, hosts copies of valid external logos. The report
+// is stored and new problems are notified.
+func (fed *LightHouse) checkLogos() (*adminapi.LogoCheckReport, error) {
+	fed.logoMu.Lock()
+	defer fed.logoMu.Unlock()
+
+	previous, err := fed.LogoCheckReport()
+	if err != nil {
+		return nil, err
+	}
+	previousValid := make(map[string]bool, len(previous.Logos))
+	for _, c := range previous.Logos {
+		previousValid[c.URI] = c.Valid
+	}
+	uses, err := fed.externalLogoURIs()
+	if err != nil {
+		return nil, err
+	}
+	uris := make([]string, 0, len(uses))
+	for uri := range uses {
+		uris = append(uris, uri)
+	}
+	sort.Strings(uris)
+
+	mirrors := fed.logoMirrors()
+	report := &adminapi.LogoCheckReport{
+		CheckedAt: nowUnix(),
+		Logos:     make([]adminapi.LogoCheck, 0, len(uris)),
+	}
+	for _, uri := range uris {
+		check := adminapi.LogoCheck{
+			URI:    uri,
+			UsedBy: uses[uri],
+			LogoID: mirrors[uri],
+		}
+		logo, err := fed.fetchLogo(uri, isThirdPartyLogo(uses[uri]))
+		if err != nil {
+			check.Error = err.Error()
+			if check.LogoID != 0 {
+				if err = fed.storages.Logos.SetCheckResult(logoIdent(check.LogoID), report.CheckedAt, check.Error); err != nil {
+					log.Warn().Err(err).Str("uri", uri).Msg("logo check: failed to record result")
+				}
+			}
+			if valid, found := previousValid[uri]; !found || valid {
+				fed.notifyInvalidLogo(check)
+			}
+			report.Logos = append(report.Logos, check)
+			continue
+		}
+		check.Valid = true
+		check.ContentType = logo.ContentType
+		check.Size = logo.Size
+		check.Width = logo.Width
+		check.Height = logo.Height
+		if check.LogoID != 0 || fed.logoHosting.conf.MirrorExternal {
+			stored, err := fed.storeFetchedLogo(logo)
+			if err != nil {
+				log.Warn().Err(err).Str("uri", uri).Msg("logo check: failed to store logo")
+			} else {
+				check.LogoID = stored.ID
+			}
+		}
+		report.Logos = append(report.Logos, check)
+	}
+	if fed.storages.KV != nil {
+		if err = fed.storages.KV.SetAny(model.KeyValueScopeLogos, model.KeyValueKeyLogoCheck, report); err != nil {
+			return nil, errors.Wrap(err, "failed to store logo check report")
+		}
+	}
+	return report, nil
+}
+
+func (fed *LightHouse) notifyInvalidLogo(check adminapi.LogoCheck) {
+	message := fmt.Sprintf("logo %s is invalid: %s", check.URI, check.Error)
+	if check.LogoID != 0 {
+		message += "; the hosted copy is kept"
+	}
+	fed.notify(
+		Notification{
+			Type:     "logo_invalid",
+			Severity: NotificationSeverityWarning,
+			Subject:  fed.FederationEntity.EntityID(),
+			Message:  message,
+			Details: map[string]any{
+				"uri":     check.URI,
+				"used_by": check.UsedBy,
+				"logo_id": check.LogoID,
+			},
+		},
+	)
+}
+
+// LogoChecker periodically fetches and validates the external logo URIs
+// used by LightHouse and updates the hosted copies of external logos.
+type LogoChecker struct {
+	fed    *LightHouse
+	runner periodicRunner
+}
+
+// NewLogoChecker creates a new LogoChecker for the passed LightHouse; logo
+// hosting must be enabled.
+func NewLogoChecker(fed *LightHouse) *LogoChecker {
+	return &LogoChecker{fed: fed}
+}
+
+// Start starts the periodic check in the background. The first check starts
+// right away.
+func (c *LogoChecker) Start() {
+	interval := c.fed.logoHosting.conf.CheckInterval
+	c.runner.start(
+		periodicTask{
+			interval:   interval,
+			runAtStart: true,
+			run:        func(context.Context) { c.RunOnce() },
+		},
+	)
+	log.Info().Dur("interval", interval).Msg("logo check started")
+}
+
+// Stop stops the periodic check and waits for a running check to finish.
+func (c *LogoChecker) Stop() {
+	c.runner.stop()
+}
+
+// RunOnce checks all external logo URIs.
+func (c *LogoChecker) RunOnce() {
+	report, err := c.fed.checkLogos()
+	if err != nil {
+		log.Warn().Err(err).Msg("logo check failed")
+		return
+	}
+	invalid := 0
+	for _, l := range report.Logos {
+		if !l.Valid {
+			invalid++
+		}
+	}
+	log.Debug().Int("logos", len(report.Logos)).Int("invalid", invalid).Msg("logo check finished")
+}
@@ -0,0 +1,322 @@
+package lighthouse
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	oidfed "github.com/go-oidfed/lib"
+	"github.com/go-oidfed/lib/apimodel"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/go-oidfed/lighthouse/api/adminapi"
+	"github.com/go-oidfed/lighthouse/storage"
+	"github.com/go-oidfed/lighthouse/storage/model"
+)
+
+func testPNG(t *testing.T, width, height int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, width, height))))
+	return buf.Bytes()
+}
+
+func TestValidateLogo(t *testing.T) {
+	h := &logoHosting{conf: withLogoHostingDefaults(LogoHostingConfig{MaxSize: 4096, MaxWidth: 64, MaxHeight: 64})}
+
+	logo, err := h.validateLogo(testPNG(t, 32, 16))
+	require.NoError(t, err)
+	assert.Equal(t, "image/png", logo.ContentType)
+	assert.Equal(t, 32, logo.Width)
+	assert.Equal(t, 16, logo.Height)
+	assert.Len(t, logo.Digest, 64)
+
+	logo, err = h.validateLogo([]byte(`<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 48 24"><rect width="10" height="10"/></svg>`))
+	require.NoError(t, err)
+	assert.Equal(t, "image/svg+xml", logo.ContentType)
+	assert.Equal(t, 48, logo.Width)
+	assert.Equal(t, 24, logo.Height)
+
+	invalid := map[string][]byte{
+		"empty":          nil,
+		"too large":      bytes.Repeat([]byte{'a'}, 5000),
+		"too wide":       testPNG(t, 65, 10),
+		"not an image":   []byte("hello world"),
+		"truncated png":  testPNG(t, 32, 32)[:40],
+		"svg script":     []byte(`<svg xmlns="http://www.w3.org/2000/svg"><script>alert(1)</script></svg>`),
+		"svg handler":    []byte(`<svg xmlns="http://www.w3.org/2000/svg" onload="alert(1)"></svg>`),
+		"svg external":   []byte(`<svg xmlns="http://www.w3.org/2000/svg"><image href="https://evil.example.org/x.png"/></svg>`),
+		"svg too large":  []byte(`<svg xmlns="http://www.w3.org/2000/svg" width="100px" height="10"></svg>`),
+		"svg entities":   []byte(`<!DOCTYPE svg [<!ENTITY a "b">]><svg xmlns="http://www.w3.org/2000/svg"></svg>`),
+		"html not svg":   []byte(`<html><body></body></html>`),
+		"svg not closed": []byte(`<svg xmlns="http://www.w3.org/2000/svg"><g>`),
+	}
+	for name, data := range invalid {
+		t.Run(
+			name, func(t *testing.T) {
+				_, err := h.validateLogo(data)
+				var validationErr model.ValidationError
+				assert.ErrorAs(t, err, &validationErr)
+			},
+		)
+	}
+}
+
+func TestServeLogo(t *testing.T) {
+	fed, _ := newTestLightHouse(t)
+	fed.endpointRegistry = NewEndpointRegistry()
+	fed.StartLogoHosting(LogoHostingConfig{})
+	app := fiber.New()
+	app.All("/*", fed.dispatch)
+
+	data := testPNG(t, 8, 8)
+	logo, err := fed.AddLogo(data, "")
+	require.NoError(t, err)
+	assert.Equal(t, testLighthouseID+"/logos/1", logo.URI)
+
+	resp, err := app.Test(httptest.NewRequest(http.MethodGet, "/logos/1", http.NoBody))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "image/png", resp.Header.Get(fiber.HeaderContentType))
+	assert.Equal(t, "public, max-age=86400", resp.Header.Get(fiber.HeaderCacheControl))
+	assert.Equal(t, "nosniff", resp.Header.Get(fiber.HeaderXContentTypeOptions))
+	etag := resp.Header.Get(fiber.HeaderETag)
+	assert.Equal(t, `"`+logo.Digest+`"`, etag)
+	body, _ := io.ReadAll(resp.Body)
+	assert.Equal(t, data, body)
+
+	req := httptest.NewRequest(http.MethodGet, "/logos/1", http.NoBody)
+	req.Header.Set(fiber.HeaderIfNoneMatch, etag)
+	resp, err = app.Test(req)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusNotModified, resp.StatusCode)
+
+	resp, err = app.Test(httptest.NewRequest(http.MethodGet, "/logos/2", http.NoBody))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	resp, err = app.Test(httptest.NewRequest(http.MethodPost, "/logos/1", http.NoBody))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+
+	// Without logo hosting the path is not served
+	fed.logoHosting = nil
+	resp, err = app.Test(httptest.NewRequest(http.MethodGet, "/logos/1", http.NoBody))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	_, err = fed.AddLogo(data, "")
+	assert.ErrorIs(t, err, errLogoHostingDisabled)
+}
+
+func TestLogoMirroring(t *testing.T) {
+	logoData := testPNG(t, 16, 16)
+	var broken atomic.Bool
+	server := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				if broken.Load() || r.URL.Path == "/missing.png" {
+					w.WriteHeader(http.StatusNotFound)
+					return
+				}
+				_, _ = w.Write(logoData)
+			},
+		),
+	)
+	defer server.Close()
+	const trustMarkType = "https://lighthouse.example.org/tm/logo"
+	imported := server.URL + "/imported.png"
+	external := server.URL + "/external.png"
+
+	fed, _ := newTestLightHouse(t)
+	fed.endpointRegistry = NewEndpointRegistry()
+	fed.StartLogoHosting(LogoHostingConfig{})
+	notifier := &recordingNotifier{}
+	fed.notifier = notifier
+	_, err := fed.storages.TrustMarkSpecs.Create(
+		&model.AddTrustMarkSpec{
+			TrustMarkType: trustMarkType,
+			LogoURI:       imported,
+		},
+	)
+	require.NoError(t, err)
+
+	logo, err := fed.ImportLogo(imported)
+	require.NoError(t, err)
+	assert.Equal(t, imported, logo.SourceURI)
+	assert.NotZero(t, logo.LastCheckedAt)
+	assert.Equal(t, logo.URI, fed.HostedLogoURI(imported))
+	assert.Equal(t, external, fed.HostedLogoURI(external))
+
+	// The logo URI of the trust mark spec and the metadata are rewritten
+	provider := NewDBTrustMarkSpecProvider(fed.storages.TrustMarkSpecs)
+	provider.SetLogoURIRewriter(fed.HostedLogoURI)
+	assert.Equal(t, logo.URI, provider.GetTrustMarkSpec(trustMarkType).LogoURI)
+	metadata := &oidfed.Metadata{FederationEntity: &oidfed.FederationEntityMetadata{LogoURI: imported}}
+	fed.rewriteMetadataLogoURIs(metadata)
+	assert.Equal(t, logo.URI, metadata.FederationEntity.LogoURI)
+	collection := &oidfed.EntityCollectionResponse{
+		Entities: []*oidfed.CollectedEntity{
+			{
+				EntityID: "https://op.example.org",
+				UIInfos:  map[string]oidfed.UIInfo{"openid_provider": {LogoURI: imported}},
+			},
+		},
+	}
+	rewritten := fed.rewriteCollectionLogoURIs(collection)
+	assert.Equal(t, logo.URI, rewritten.Entities[0].UIInfos["openid_provider"].LogoURI)
+	assert.Equal(t, imported, collection.Entities[0].UIInfos["openid_provider"].LogoURI)
+
+	// Importing the same URI again updates the hosted copy
+	_, err = fed.ImportLogo(imported)
+	require.NoError(t, err)
+	logos, err := fed.HostedLogos()
+	require.NoError(t, err)
+	assert.Len(t, logos, 1)
+	_, err = fed.AddLogo(logoData, imported)
+	var exists model.AlreadyExistsError
+	assert.ErrorAs(t, err, &exists)
+
+	// Only the imported logo is hosted without mirror_external
+	require.NoError(
+		t, storage.SetMetadata(
+			fed.storages.KV, &oidfed.Metadata{FederationEntity: &oidfed.FederationEntityMetadata{LogoURI: external}},
+		),
+	)
+	_, err = fed.storages.TrustMarkSpecs.Create(
+		&model.AddTrustMarkSpec{
+			TrustMarkType: trustMarkType + "/missing",
+			LogoURI:       server.URL + "/missing.png",
+		},
+	)
+	require.NoError(t, err)
+	report, err := fed.CheckLogos()
+	require.NoError(t, err)
+	require.Len(t, report.Logos, 3)
+	byURI := make(map[string]int)
+	for i, c := range report.Logos {
+		byURI[c.URI] = i
+	}
+	assert.True(t, report.Logos[byURI[external]].Valid)
+	assert.Zero(t, report.Logos[byURI[external]].LogoID)
+	assert.Equal(t, []string{"entity_configuration:federation_entity"}, report.Logos[byURI[external]].UsedBy)
+	assert.True(t, report.Logos[byURI[imported]].Valid)
+	assert.Equal(t, logo.ID, report.Logos[byURI[imported]].LogoID)
+	missing := report.Logos[byURI[server.URL+"/missing.png"]]
+	assert.False(t, missing.Valid)
+	assert.Contains(t, missing.Error, "404")
+	require.Len(t, notifier.notifications, 1)
+	assert.Equal(t, "logo_invalid", notifier.notifications[0].Type)
+
+	stored, err := fed.LogoCheckReport()
+	require.NoError(t, err)
+	assert.Equal(t, report.CheckedAt, stored.CheckedAt)
+
+	// A broken source keeps the hosted copy; problems are notified once
+	broken.Store(true)
+	report, err = fed.CheckLogos()
+	require.NoError(t, err)
+	for _, c := range report.Logos {
+		assert.False(t, c.Valid)
+	}
+	assert.Len(t, notifier.notifications, 3)
+	hosted, err := fed.HostedLogo(strings.TrimPrefix(logo.URI, testLighthouseID+"/logos/"))
+	require.NoError(t, err)
+	assert.Contains(t, hosted.LastError, "404")
+	assert.Equal(t, logo.Digest, hosted.Digest)
+	_, err = fed.CheckLogos()
+	require.NoError(t, err)
+	assert.Len(t, notifier.notifications, 3)
+
+	// With mirror_external valid external logos are hosted
+	broken.Store(false)
+	fed.logoHosting.conf.MirrorExternal = true
+	report, err = fed.CheckLogos()
+	require.NoError(t, err)
+	assert.NotZero(t, report.Logos[byURI[external]].LogoID)
+	assert.NotEqual(t, external, fed.HostedLogoURI(external))
+
+	require.NoError(t, fed.DeleteLogo(strings.TrimPrefix(logo.URI, testLighthouseID+"/logos/")))
+	assert.Equal(t, imported, fed.HostedLogoURI(imported))
+}
+
+type stubEntityCollector struct {
+	res *oidfed.EntityCollectionResponse
+}
+
+func (c stubEntityCollector) CollectEntities(apimodel.EntityCollectionRequest) (
+	*oidfed.EntityCollectionResponse, *oidfed.ErrorResponse,
+) {
+	return c.res, nil
+}
+
+func TestCollectionLogos(t *testing.T) {
+	logoData := testPNG(t, 16, 16)
+	handler := http.HandlerFunc(
+		func(w http.ResponseWriter, _ *http.Request) {
+			_, _ = w.Write(logoData)
+		},
+	)
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	tlsServer := httptest.NewTLSServer(handler)
+	defer tlsServer.Close()
+	configured := server.URL + "/configured.png"
+
+	fed, _ := newTestLightHouse(t)
+	fed.endpointRegistry = NewEndpointRegistry()
+	fed.StartLogoHosting(LogoHostingConfig{})
+	fed.notifier = &recordingNotifier{}
+	require.NoError(
+		t, storage.SetMetadata(
+			fed.storages.KV, &oidfed.Metadata{FederationEntity: &oidfed.FederationEntityMetadata{LogoURI: configured}},
+		),
+	)
+	fed.entityCollector = stubEntityCollector{
+		res: &oidfed.EntityCollectionResponse{
+			Entities: []*oidfed.CollectedEntity{
+				{
+					EntityID: "https://op.example.org",
+					UIInfos: map[string]oidfed.UIInfo{
+						"openid_provider":   {LogoURI: tlsServer.URL + "/internal.png"},
+						"federation_entity": {LogoURI: configured},
+					},
+				},
+				{
+					EntityID: "https://rp.example.org",
+					UIInfos:  map[string]oidfed.UIInfo{"openid_relying_party": {LogoURI: server.URL + "/plain.png"}},
+				},
+			},
+		},
+	}
+
+	report, err := fed.CheckLogos()
+	require.NoError(t, err)
+	require.Len(t, report.Logos, 3)
+	checks := make(map[string]adminapi.LogoCheck)
+	for _, c := range report.Logos {
+		checks[c.URI] = c
+	}
+	// Logos configured in LightHouse may be hosted internally
+	assert.True(t, checks[configured].Valid)
+	assert.ElementsMatch(
+		t, []string{
+			"entity_configuration:federation_entity",
+			"entity_collection:https://op.example.org:federation_entity",
+		}, checks[configured].UsedBy,
+	)
+	// Third-party logos must be https URIs of public hosts
+	internal := checks[tlsServer.URL+"/internal.png"]
+	assert.False(t, internal.Valid)
+	assert.Contains(t, internal.Error, "not public")
+	assert.Equal(t, []string{"entity_collection:https://op.example.org:openid_provider"}, internal.UsedBy)
+	plain := checks[server.URL+"/plain.png"]
+	assert.False(t, plain.Valid)
+	assert.Contains(t, plain.Error, "invalid third-party logo uri")
+}
@@ -0,0 +1,190 @@
+package lighthouse
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	stderrors "errors"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+)
+
+// Notification severities.
+const (
+	NotificationSeverityInfo     = "info"
+	NotificationSeverityWarning  = "warning"
+	NotificationSeverityCritical = "critical"
+)
+
+// Notification is an operator-facing message about something that happened
+// in the background, e.g. an entity failing re-validation.
+type Notification struct {
+	// Type is a machine-readable notification type, e.g. "revalidation_failed".
+	Type string `json:"type"`
+	// Severity is one of info, warning, critical.
+	Severity string `json:"severity"`
+	// Subject is the entity ID the notification is about, if any.
+	Subject string `json:"subject,omitempty"`
+	// Message is a human-readable description.
+	Message string `json:"message"`
+	// Time is the unix timestamp when the notification was created.
+	Time int64 `json:"time"`
+	// Details holds additional type-specific information.
+	Details map[string]any `json:"details,omitempty"`
+}
+
+// Notifier delivers Notifications to operators.
+type Notifier interface {
+	Notify(ctx context.Context, n Notification) error
+}
+
+// NotificationConf configures where notifications are delivered to.
+// Notifications are always logged; webhooks are optional and can only be
+// configured in the config file.
+type NotificationConf struct {
+	// Webhooks receive each notification as a JSON POST request.
+	Webhooks []WebhookNotifierConf `yaml:"webhooks" ignored:"true"`
+}
+
+// WebhookNotifierConf configures a single webhook notification target.
+type WebhookNotifierConf struct {
+	// URL is the webhook URL notifications are POSTed to.
+	URL string `yaml:"url"`
+	// Headers are added to each request, e.g. for authentication.
+	Headers map[string]string `yaml:"headers"`
+	// Timeout is the request timeout in seconds (default: 10).
+	Timeout int `yaml:"timeout"`
+	// MinSeverity only delivers notifications with at least this severity
+	// (default: info).
+	MinSeverity string `yaml:"min_severity"`
+}
+
+// NewNotifier builds a Notifier from the passed NotificationConf. The
+// returned Notifier always logs notifications and additionally delivers them
+// to all configured webhooks.
+func NewNotifier(conf NotificationConf) Notifier {
+	notifiers := MultiNotifier{LogNotifier{}}
+	for _, w := range conf.Webhooks {
+		if w.URL == "" {
+			continue
+		}
+		notifiers = append(notifiers, NewWebhookNotifier(w))
+	}
+	return notifiers
+}
+
+// MultiNotifier delivers a Notification to all contained Notifiers. Errors
+// are collected; delivery continues for the remaining Notifiers.
+type MultiNotifier []Notifier
+
+// Notify implements the Notifier interface
+func (m MultiNotifier) Notify(ctx context.Context, n Notification) error {
+	var errs []error
+	for _, notifier := range m {
+		if err := notifier.Notify(ctx, n); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return stderrors.Join(errs...)
+}
+
+// LogNotifier writes Notifications to the internal log.
+type LogNotifier struct{}
+
+// Notify implements the Notifier interface
+func (LogNotifier) Notify(_ context.Context, n Notification) error {
+	event := log.Info()
+	switch n.Severity {
+	case NotificationSeverityWarning:
+		event = log.Warn()
+	case NotificationSeverityCritical:
+		event = log.Error()
+	}
+	event.Str("notification", n.Type).
+		Str("subject", n.Subject).
+		Interface("details", n.Details).
+		Msg(n.Message)
+	return nil
+}
+
+// WebhookNotifier POSTs Notifications as JSON to a URL.
+type WebhookNotifier struct {
+	conf   WebhookNotifierConf
+	client *http.Client
+}
+
+// NewWebhookNotifier creates a new WebhookNotifier
+func NewWebhookNotifier(conf WebhookNotifierConf) *WebhookNotifier {
+	timeout := conf.Timeout
+	if timeout <= 0 {
+		timeout = 10
+	}
+	return &WebhookNotifier{
+		conf:   conf,
+		client: &http.Client{Timeout: time.Duration(timeout) * time.Second},
+	}
+}
+
+// Notify implements the Notifier interface
+func (w *WebhookNotifier) Notify(ctx context.Context, n Notification) error {
+	if severityRank(n.Severity) < severityRank(w.conf.MinSeverity) {
+		return nil
+	}
+	body, err := json.Marshal(n)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.conf.URL, bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrap(err, "failed to create webhook request")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range w.conf.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "webhook request failed")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return errors.Errorf("webhook returned HTTP %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func severityRank(severity string) int {
+	switch severity {
+	case NotificationSeverityWarning:
+		return 1
+	case NotificationSeverityCritical:
+		return 2
+	default:
+		return 0
+	}
+}
+
+// SetNotifier sets the Notifier used for background notifications.
+func (fed *LightHouse) SetNotifier(n Notifier) {
+	fed.notifier = n
+}
+
+// notify delivers a notification through the configured Notifier, or only
+// logs it if no Notifier is set. Delivery errors are logged, not returned.
+func (fed *LightHouse) notify(n Notification) {
+	if n.Time == 0 {
+		n.Time = nowUnix()
+	}
+	if fed.notifier == nil {
+		_ = LogNotifier{}.Notify(context.Background(), n)
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := fed.notifier.Notify(ctx, n); err != nil {
+		log.Warn().Err(err).Str("notification", n.Type).Msg("failed to deliver notification")
+	}
+}
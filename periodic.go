@@ -0,0 +1,70 @@
+package lighthouse
+
+import (
+	"context"
+	"time"
+)
+
+// periodicTask describes the work of a periodicRunner.
+type periodicTask struct {
+	// interval is the time between two runs.
+	interval time.Duration
+	// runAtStart makes the first run happen right away instead of after one
+	// interval.
+	runAtStart bool
+	// run is called on each tick; ctx is canceled when the runner is stopped.
+	run func(ctx context.Context)
+	// trigger optionally signals runs outside the interval; onTrigger is
+	// called for each received signal.
+	trigger   <-chan struct{}
+	onTrigger func(ctx context.Context)
+}
+
+// periodicRunner runs a periodicTask in the background until it is stopped.
+// The background workers of LightHouse hold one in their runner field and
+// expose it through their Start and Stop methods.
+type periodicRunner struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// start starts running the task in the background.
+func (r *periodicRunner) start(task periodicTask) {
+	ctx, cancel := context.WithCancel(context.Background())
+	r.cancel = cancel
+	r.done = make(chan struct{})
+	go func() {
+		defer close(r.done)
+		if task.runAtStart {
+			task.run(ctx)
+		}
+		ticker := time.NewTicker(task.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				task.run(ctx)
+			case <-task.trigger:
+				task.onTrigger(ctx)
+			}
+		}
+	}()
+}
+
+// running reports whether the runner was started and not stopped yet.
+func (r *periodicRunner) running() bool {
+	return r.cancel != nil
+}
+
+// stop stops the runner and waits for a running run to finish. Stopping a
+// runner that is not running does nothing.
+func (r *periodicRunner) stop() {
+	if r.cancel == nil {
+		return
+	}
+	r.cancel()
+	<-r.done
+	r.cancel = nil
+}
@@ -0,0 +1,45 @@
+package lighthouse
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPeriodicRunner(t *testing.T) {
+	var runs, triggered atomic.Int32
+	trigger := make(chan struct{})
+	var r periodicRunner
+	assert.False(t, r.running())
+	r.start(
+		periodicTask{
+			interval:   time.Hour,
+			runAtStart: true,
+			run:        func(context.Context) { runs.Add(1) },
+			trigger:    trigger,
+			onTrigger:  func(context.Context) { triggered.Add(1) },
+		},
+	)
+	assert.True(t, r.running())
+	trigger <- struct{}{}
+	trigger <- struct{}{}
+	r.stop()
+	assert.False(t, r.running())
+	assert.Equal(t, int32(1), runs.Load())
+	assert.Equal(t, int32(2), triggered.Load())
+	r.stop()
+
+	// Without runAtStart the first run happens after one interval
+	runs.Store(0)
+	r.start(
+		periodicTask{
+			interval: 10 * time.Millisecond,
+			run:      func(context.Context) { runs.Add(1) },
+		},
+	)
+	assert.Eventually(t, func() bool { return runs.Load() > 0 }, time.Second, 5*time.Millisecond)
+	r.stop()
+}
@@ -0,0 +1,301 @@
+package lighthouse
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+
+	oidfed "github.com/go-oidfed/lib"
+	"github.com/go-oidfed/lib/oidfedconst"
+	"github.com/go-oidfed/lib/unixtime"
+	"github.com/lestrrat-go/jwx/v4/jws"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+
+	"github.com/go-oidfed/lighthouse/api/adminapi"
+	"github.com/go-oidfed/lighthouse/internal"
+	"github.com/go-oidfed/lighthouse/storage/model"
+)
+
+// presignedStatementClaims are the claims of a pre-signed statement that are
+// needed to serve it.
+type presignedStatementClaims struct {
+	Issuer    string            `json:"iss"`
+	Subject   string            `json:"sub"`
+	IssuedAt  unixtime.Unixtime `json:"iat"`
+	ExpiresAt unixtime.Unixtime `json:"exp"`
+}
+
+func invalidCeremonyBundle(format string, args ...any) error {
+	return errors.WithMessagef(adminapi.ErrInvalidCeremonyBundle, format, args...)
+}
+
+// CeremonyPayloads returns the unsigned entity configuration and the
+// subordinate statements of all active subordinates, to be pre-signed in an
+// offline key ceremony.
+func (fed *LightHouse) CeremonyPayloads() (*adminapi.CeremonyPayloads, error) {
+	ec, err := fed.EntityConfigurationPayload()
+	if err != nil {
+		return nil, err
+	}
+	payloads := &adminapi.CeremonyPayloads{
+		EntityConfiguration:   ec,
+		SubordinateStatements: []oidfed.EntityStatementPayload{},
+	}
+	if fed.storages.Subordinates == nil {
+		return payloads, nil
+	}
+	subordinates, err := fed.storages.Subordinates.GetByStatus(model.StatusActive)
+	if err != nil {
+		return nil, err
+	}
+	for _, sub := range subordinates {
+		info, err := fed.storages.Subordinates.Get(sub.EntityID)
+		if err != nil {
+			return nil, err
+		}
+		if info == nil {
+			continue
+		}
+		payloads.SubordinateStatements = append(payloads.SubordinateStatements, fed.CreateSubordinateStatement(info))
+	}
+	return payloads, nil
+}
+
+// ImportCeremonyBundle verifies a signed transfer bundle from an offline key
+// ceremony and stores its pre-signed statements. The bundle and all
+// statements must be signed with one of the API-managed public keys, i.e. the
+// ceremony keys must have been imported through
+// POST /entity-configuration/keys before. Pre-signed statements are served
+// instead of signing the statements online.
+func (fed *LightHouse) ImportCeremonyBundle(bundle []byte) (*adminapi.CeremonyImportResult, error) {
+	validKeys, err := fed.keyManagement.APIManagedPKs.GetValid()
+	if err != nil {
+		return nil, err
+	}
+	keys, err := validKeys.JWKS()
+	if err != nil {
+		return nil, err
+	}
+	if keys.Set == nil || keys.Len() == 0 {
+		return nil, invalidCeremonyBundle("no imported public keys to verify the bundle against")
+	}
+
+	msg, err := jws.Parse(bundle)
+	if err != nil {
+		return nil, invalidCeremonyBundle("could not parse bundle: %s", err.Error())
+	}
+	if sigs := msg.Signatures(); len(sigs) == 0 {
+		return nil, invalidCeremonyBundle("bundle is not signed")
+	} else if typ, _ := sigs[0].ProtectedHeaders().Type(); typ != JWTTypeCeremonyBundle {
+		return nil, invalidCeremonyBundle("bundle has type '%s', expected '%s'", typ, JWTTypeCeremonyBundle)
+	}
+	payload, err := jws.Verify(bundle, jws.WithKeySet(keys.Set))
+	if err != nil {
+		return nil, invalidCeremonyBundle("bundle signature could not be verified with the imported public keys")
+	}
+	var b ceremonyBundle
+	if err = json.Unmarshal(payload, &b); err != nil {
+		return nil, invalidCeremonyBundle("could not parse bundle: %s", err.Error())
+	}
+	entityID := fed.FederationEntity.EntityID()
+	if b.Issuer != entityID {
+		return nil, invalidCeremonyBundle("bundle was issued by '%s', not by '%s'", b.Issuer, entityID)
+	}
+
+	now := time.Now()
+	imported := make([]adminapi.PresignedStatement, 0, len(b.Statements))
+	for i, statement := range b.Statements {
+		msg, err = jws.Parse([]byte(statement))
+		if err != nil {
+			return nil, invalidCeremonyBundle("could not parse statement %d: %s", i, err.Error())
+		}
+		if sigs := msg.Signatures(); len(sigs) == 0 {
+			return nil, invalidCeremonyBundle("statement %d is not signed", i)
+		} else if typ, _ := sigs[0].ProtectedHeaders().Type(); typ != oidfedconst.JWTTypeEntityStatement {
+			return nil, invalidCeremonyBundle(
+				"statement %d has type '%s', expected '%s'", i, typ, oidfedconst.JWTTypeEntityStatement,
+			)
+		}
+		payload, err = jws.Verify([]byte(statement), jws.WithKeySet(keys.Set))
+		if err != nil {
+			return nil, invalidCeremonyBundle("signature of statement %d could not be verified", i)
+		}
+		var claims presignedStatementClaims
+		if err = json.Unmarshal(payload, &claims); err != nil {
+			return nil, invalidCeremonyBundle("could not parse statement %d: %s", i, err.Error())
+		}
+		if claims.Issuer != entityID || claims.Subject == "" {
+			return nil, invalidCeremonyBundle("statement %d is not a statement issued by '%s'", i, entityID)
+		}
+		if !claims.ExpiresAt.After(claims.IssuedAt.Time) {
+			return nil, invalidCeremonyBundle("statement %d expires before it is issued", i)
+		}
+		if claims.ExpiresAt.Before(now) {
+			continue
+		}
+		imported = append(
+			imported, adminapi.PresignedStatement{
+				Subject:   claims.Subject,
+				IssuedAt:  claims.IssuedAt.Unix(),
+				ExpiresAt: claims.ExpiresAt.Unix(),
+				JWT:       statement,
+			},
+		)
+	}
+
+	stored, err := fed.PresignedStatements()
+	if err != nil {
+		return nil, err
+	}
+	statements := mergePresignedStatements(stored, imported, now)
+	if err = fed.storages.KV.SetAny(
+		model.KeyValueScopeCeremony, model.KeyValueKeyPresignedStatements, statements,
+	); err != nil {
+		return nil, err
+	}
+
+	res := &adminapi.CeremonyImportResult{
+		Imported: len(imported),
+		Subjects: []string{},
+	}
+	subjects := make(map[string]struct{})
+	for _, s := range imported {
+		if _, ok := subjects[s.Subject]; !ok {
+			subjects[s.Subject] = struct{}{}
+			res.Subjects = append(res.Subjects, s.Subject)
+		}
+		res.ValidUntil = max(res.ValidUntil, s.ExpiresAt)
+	}
+	fed.purgePresignedStatementCaches(res.Subjects)
+	return res, nil
+}
+
+// mergePresignedStatements adds the imported statements to the stored ones,
+// replacing stored statements with the same subject and issuance time and
+// dropping expired statements.
+func mergePresignedStatements(
+	stored, imported []adminapi.PresignedStatement, now time.Time,
+) []adminapi.PresignedStatement {
+	type statementKey struct {
+		subject string
+		iat     int64
+	}
+	byKey := make(map[statementKey]adminapi.PresignedStatement, len(stored)+len(imported))
+	for _, s := range append(stored, imported...) {
+		if s.ExpiresAt <= now.Unix() {
+			continue
+		}
+		byKey[statementKey{s.Subject, s.IssuedAt}] = s
+	}
+	merged := make([]adminapi.PresignedStatement, 0, len(byKey))
+	for _, s := range byKey {
+		merged = append(merged, s)
+	}
+	sort.Slice(
+		merged, func(i, j int) bool {
+			if merged[i].Subject != merged[j].Subject {
+				return merged[i].Subject < merged[j].Subject
+			}
+			return merged[i].IssuedAt < merged[j].IssuedAt
+		},
+	)
+	return merged
+}
+
+// PresignedStatements returns the stored pre-signed statements.
+func (fed *LightHouse) PresignedStatements() ([]adminapi.PresignedStatement, error) {
+	statements := []adminapi.PresignedStatement{}
+	if fed.storages.KV == nil {
+		return statements, nil
+	}
+	if _, err := fed.storages.KV.GetAs(
+		model.KeyValueScopeCeremony, model.KeyValueKeyPresignedStatements, &statements,
+	); err != nil {
+		return nil, err
+	}
+	return statements, nil
+}
+
+// DeletePresignedStatements removes all pre-signed statements; the
+// statements are signed online again afterwards.
+func (fed *LightHouse) DeletePresignedStatements() error {
+	stored, err := fed.PresignedStatements()
+	if err != nil {
+		return err
+	}
+	if err = fed.storages.KV.Delete(model.KeyValueScopeCeremony, model.KeyValueKeyPresignedStatements); err != nil {
+		return err
+	}
+	subjects := make([]string, len(stored))
+	for i, s := range stored {
+		subjects[i] = s.Subject
+	}
+	fed.purgePresignedStatementCaches(subjects)
+	return nil
+}
+
+// DeleteSubjectPresignedStatements removes the pre-signed statements about
+// the passed subject, e.g. because the subordinate was blocked or deleted.
+func (fed *LightHouse) DeleteSubjectPresignedStatements(subject string) error {
+	stored, err := fed.PresignedStatements()
+	if err != nil {
+		return err
+	}
+	statements := make([]adminapi.PresignedStatement, 0, len(stored))
+	for _, s := range stored {
+		if s.Subject != subject {
+			statements = append(statements, s)
+		}
+	}
+	if len(statements) == len(stored) {
+		return nil
+	}
+	if err = fed.storages.KV.SetAny(
+		model.KeyValueScopeCeremony, model.KeyValueKeyPresignedStatements, statements,
+	); err != nil {
+		return err
+	}
+	fed.purgePresignedStatementCaches([]string{subject})
+	return nil
+}
+
+// purgePresignedStatementCaches removes the cached statements about the
+// passed subjects, so that changed pre-signed statements are served right
+// away.
+func (fed *LightHouse) purgePresignedStatementCaches(subjects []string) {
+	entityID := fed.FederationEntity.EntityID()
+	for _, sub := range subjects {
+		key := internal.SubordinateStatementCacheKey(sub)
+		if sub == entityID {
+			key = internal.CacheKeyEntityConfiguration
+		}
+		if err := internal.DeleteCache(key); err != nil {
+			log.Warn().Err(err).Str("subject", sub).Msg("failed to purge cached statement")
+		}
+	}
+}
+
+// presignedStatement returns the current pre-signed statement about the
+// passed subject, i.e. the most recently issued statement that is valid now.
+func (fed *LightHouse) presignedStatement(subject string) (jwt []byte, exp time.Time, found bool) {
+	statements, err := fed.PresignedStatements()
+	if err != nil {
+		log.Warn().Err(err).Msg("failed to load pre-signed statements")
+		return nil, time.Time{}, false
+	}
+	now := time.Now().Unix()
+	var current *adminapi.PresignedStatement
+	for i, s := range statements {
+		if s.Subject != subject || s.IssuedAt > now || s.ExpiresAt <= now {
+			continue
+		}
+		if current == nil || s.IssuedAt > current.IssuedAt {
+			current = &statements[i]
+		}
+	}
+	if current == nil {
+		return nil, time.Time{}, false
+	}
+	return []byte(current.JWT), time.Unix(current.ExpiresAt, 0), true
+}
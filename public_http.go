@@ -0,0 +1,76 @@
+package lighthouse
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// maxPublicHTTPRedirects limits the number of redirects followed by the
+// client returned by publicHTTPSClient.
+const maxPublicHTTPRedirects = 5
+
+// nonPublicPrefixes are address ranges that are not covered by the net.IP
+// helpers but must not be reached by requests to URLs published by other
+// entities.
+var nonPublicPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("64:ff9b::/96"),
+}
+
+// publicHTTPSClient returns an http.Client for requests to URLs published by
+// other entities, e.g. third-party logos or push endpoints. It only connects
+// to public addresses and only follows redirects to https URLs. The
+// addresses are checked when connecting, so neither DNS rebinding nor
+// redirects can reach internal hosts.
+func publicHTTPSClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: timeout,
+		Control: func(_, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return errors.WithStack(err)
+			}
+			addr, err := netip.ParseAddr(host)
+			if err != nil || !isPublicAddr(addr) {
+				return errors.Errorf("host address %s is not public", host)
+			}
+			return nil
+		},
+	}
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: timeout,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if req.URL.Scheme != "https" {
+				return errors.New("redirected to a non-https uri")
+			}
+			if len(via) >= maxPublicHTTPRedirects {
+				return errors.New("too many redirects")
+			}
+			return nil
+		},
+	}
+}
+
+// isPublicAddr reports whether the passed address is a public unicast
+// address.
+func isPublicAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if !addr.IsGlobalUnicast() || addr.IsPrivate() {
+		return false
+	}
+	for _, prefix := range nonPublicPrefixes {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,31 @@
+package lighthouse
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsPublicAddr(t *testing.T) {
+	for addr, public := range map[string]bool{
+		"93.184.216.34":      true,
+		"2606:2800:220:1::":  true,
+		"127.0.0.1":          false,
+		"10.1.2.3":           false,
+		"172.16.0.1":         false,
+		"192.168.1.1":        false,
+		"169.254.169.254":    false,
+		"100.64.0.1":         false,
+		"0.0.0.0":            false,
+		"::1":                false,
+		"fd00::1":            false,
+		"fe80::1":            false,
+		"::ffff:127.0.0.1":   false,
+		"64:ff9b::a9fe:a9fe": false,
+		"224.0.0.1":          false,
+		"255.255.255.255":    false,
+	} {
+		assert.Equal(t, public, isPublicAddr(netip.MustParseAddr(addr)), addr)
+	}
+}
@@ -0,0 +1,589 @@
+package lighthouse
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	oidfed "github.com/go-oidfed/lib"
+	"github.com/go-oidfed/lib/oidfedconst"
+	"github.com/gofiber/fiber/v2"
+	"github.com/lestrrat-go/jwx/v4/jws"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+
+	"github.com/go-oidfed/lighthouse/api/adminapi"
+	"github.com/go-oidfed/lighthouse/internal"
+	"github.com/go-oidfed/lighthouse/storage/model"
+)
+
+const (
+	// trustMarkStatusHTTPTimeout limits a request to an issuer's trust mark
+	// status endpoint.
+	trustMarkStatusHTTPTimeout = 30 * time.Second
+	// maxTrustMarkStatusResponseSize limits the size of a trust mark status
+	// response.
+	maxTrustMarkStatusResponseSize = 64 * 1024
+)
+
+// publishedTrustMarkStatusRank orders the health states by severity.
+var publishedTrustMarkStatusRank = map[string]int{
+	adminapi.PublishedTrustMarkStatusValid:    0,
+	adminapi.PublishedTrustMarkStatusExpiring: 1,
+	adminapi.PublishedTrustMarkStatusUnknown:  2,
+	adminapi.PublishedTrustMarkStatusInvalid:  3,
+}
+
+// PublishedTrustMarkHealthConfig configures the PublishedTrustMarkMonitor.
+type PublishedTrustMarkHealthConfig struct {
+	// Interval is the time between two checks.
+	Interval time.Duration
+	// ExpiryWarning is how long before its expiration a trust mark that is
+	// not refreshed automatically is reported as expiring.
+	ExpiryWarning time.Duration
+	// DropInvalid removes invalid trust marks from the entity configuration;
+	// otherwise they are only reported.
+	DropInvalid bool
+}
+
+// publishedTrustMarkAlert is persisted in the KV store per trust mark type
+// that is not valid, so that it is only notified about again if its status
+// changes, and invalid trust marks stay dropped across restarts.
+type publishedTrustMarkAlert struct {
+	Status string `json:"status"`
+	Since  int64  `json:"since"`
+}
+
+// PublishedTrustMarkMonitor periodically verifies the trust marks published
+// in the entity configuration: their expiration, the signature of the
+// issuer, the delegation of the trust mark owner, and their status at the
+// issuer's trust mark status endpoint. Newly found issues are sent as
+// notifications; invalid trust marks are dropped from the entity
+// configuration if configured.
+type PublishedTrustMarkMonitor struct {
+	fed    *LightHouse
+	conf   PublishedTrustMarkHealthConfig
+	mu     sync.Mutex
+	last   *adminapi.PublishedTrustMarkHealthReport
+	runner periodicRunner
+
+	droppedMu sync.RWMutex
+	dropped   map[string]bool
+}
+
+// NewPublishedTrustMarkMonitor creates a new PublishedTrustMarkMonitor for
+// the passed LightHouse. Trust marks found invalid by a previous run are
+// dropped right away.
+func NewPublishedTrustMarkMonitor(fed *LightHouse, conf PublishedTrustMarkHealthConfig) *PublishedTrustMarkMonitor {
+	if conf.Interval <= 0 {
+		conf.Interval = time.Hour
+	}
+	if conf.ExpiryWarning <= 0 {
+		conf.ExpiryWarning = 7 * 24 * time.Hour
+	}
+	m := &PublishedTrustMarkMonitor{
+		fed:     fed,
+		conf:    conf,
+		dropped: make(map[string]bool),
+	}
+	if conf.DropInvalid {
+		for trustMarkType, state := range m.alertState() {
+			if state.Status == adminapi.PublishedTrustMarkStatusInvalid {
+				m.dropped[trustMarkType] = true
+			}
+		}
+	}
+	return m
+}
+
+// Start starts the periodic checks in the background. The first check runs
+// right away.
+func (m *PublishedTrustMarkMonitor) Start() {
+	m.runner.start(
+		periodicTask{
+			interval:   m.conf.Interval,
+			runAtStart: true,
+			run:        func(context.Context) { m.RunOnce() },
+		},
+	)
+	log.Info().Dur("interval", m.conf.Interval).Msg("published trust mark monitor started")
+}
+
+// Stop stops the periodic checks and waits for a running check to finish.
+func (m *PublishedTrustMarkMonitor) Stop() {
+	m.runner.stop()
+}
+
+// Last returns the report of the latest check, or nil if no check ran yet.
+func (m *PublishedTrustMarkMonitor) Last() *adminapi.PublishedTrustMarkHealthReport {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.last
+}
+
+// RunOnce checks all published trust marks, updates the dropped trust marks,
+// sends notifications for new issues, and returns the report. Concurrent
+// calls are serialized.
+func (m *PublishedTrustMarkMonitor) RunOnce() *adminapi.PublishedTrustMarkHealthReport {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	report := m.Check(time.Now())
+	m.updateDropped(report)
+	m.alert(report)
+	m.last = report
+	log.Debug().Str("status", report.Status).Int("trust_marks", len(report.TrustMarks)).
+		Msg("published trust marks checked")
+	return report
+}
+
+// Check verifies all published trust marks at the passed time without
+// sending any notifications or changing the entity configuration.
+func (m *PublishedTrustMarkMonitor) Check(now time.Time) *adminapi.PublishedTrustMarkHealthReport {
+	report := &adminapi.PublishedTrustMarkHealthReport{
+		CheckedAt:   now.Unix(),
+		Status:      adminapi.KeyHealthStatusOK,
+		DropInvalid: m.conf.DropInvalid,
+		TrustMarks:  []adminapi.PublishedTrustMarkHealth{},
+	}
+	store := m.fed.storages.PublishedTrustMarks
+	if store == nil {
+		return report
+	}
+	trustMarks, err := store.List()
+	if err != nil {
+		log.Warn().Err(err).Msg("published trust marks: failed to list trust marks")
+		return report
+	}
+	configs := make(map[string]*oidfed.EntityConfigurationTrustMarkConfig)
+	if provider := m.fed.trustMarkConfigProvider; provider != nil {
+		list, err := provider.GetConfigs()
+		if err != nil {
+			log.Warn().Err(err).Msg("published trust marks: failed to load trust mark configs")
+		}
+		for _, config := range list {
+			configs[config.TrustMarkType] = config
+		}
+	}
+	owners := m.fed.trustedTrustMarkOwners()
+
+	for _, tm := range trustMarks {
+		health := m.checkTrustMark(tm, configs[tm.TrustMarkType], owners, now)
+		health.Dropped = m.conf.DropInvalid && health.Status == adminapi.PublishedTrustMarkStatusInvalid
+		report.TrustMarks = append(report.TrustMarks, health)
+		switch health.Status {
+		case adminapi.PublishedTrustMarkStatusInvalid:
+			report.Status = NotificationSeverityCritical
+		case adminapi.PublishedTrustMarkStatusExpiring, adminapi.PublishedTrustMarkStatusUnknown:
+			if report.Status != NotificationSeverityCritical {
+				report.Status = NotificationSeverityWarning
+			}
+		}
+	}
+	sort.Slice(
+		report.TrustMarks, func(i, j int) bool {
+			return report.TrustMarks[i].TrustMarkType < report.TrustMarks[j].TrustMarkType
+		},
+	)
+	return report
+}
+
+// setPublishedTrustMarkStatus records a problem of the trust mark and raises
+// its status if the passed status is more severe.
+func setPublishedTrustMarkStatus(health *adminapi.PublishedTrustMarkHealth, status, problem string) {
+	if publishedTrustMarkStatusRank[status] > publishedTrustMarkStatusRank[health.Status] {
+		health.Status = status
+	}
+	health.Problems = append(health.Problems, problem)
+}
+
+// checkTrustMark checks a single published trust mark. The trust mark JWT
+// is taken from its config, i.e. it is the JWT currently published; if
+// needed it is refreshed first.
+func (m *PublishedTrustMarkMonitor) checkTrustMark(
+	tm model.PublishedTrustMark, config *oidfed.EntityConfigurationTrustMarkConfig, owners oidfed.TrustMarkOwners,
+	now time.Time,
+) adminapi.PublishedTrustMarkHealth {
+	health := adminapi.PublishedTrustMarkHealth{
+		TrustMarkType:   tm.TrustMarkType,
+		TrustMarkIssuer: tm.TrustMarkIssuer,
+		SelfIssued:      tm.SelfIssuanceSpec != nil,
+		Status:          adminapi.PublishedTrustMarkStatusValid,
+	}
+	trustMarkJWT := tm.TrustMarkJWT
+	refresh := false
+	if config == nil {
+		// The config provider skips trust marks it cannot load, so these are
+		// not published at all.
+		setPublishedTrustMarkStatus(
+			&health, adminapi.PublishedTrustMarkStatusInvalid,
+			"trust mark configuration could not be loaded; the trust mark is not published",
+		)
+	} else {
+		var err error
+		refresh = config.Refresh
+		trustMarkJWT, err = config.TrustMarkJWT()
+		if err != nil && trustMarkJWT != "" {
+			setPublishedTrustMarkStatus(
+				&health, adminapi.PublishedTrustMarkStatusUnknown,
+				fmt.Sprintf("failed to refresh trust mark: %s", err),
+			)
+		} else if err != nil {
+			setPublishedTrustMarkStatus(
+				&health, adminapi.PublishedTrustMarkStatusInvalid, fmt.Sprintf("failed to obtain trust mark: %s", err),
+			)
+			return health
+		}
+	}
+	if trustMarkJWT == "" {
+		setPublishedTrustMarkStatus(&health, adminapi.PublishedTrustMarkStatusInvalid, "no trust mark jwt available")
+		return health
+	}
+	trustMark, err := oidfed.ParseTrustMark([]byte(trustMarkJWT))
+	if err != nil {
+		setPublishedTrustMarkStatus(
+			&health, adminapi.PublishedTrustMarkStatusInvalid, fmt.Sprintf("failed to parse trust mark: %s", err),
+		)
+		return health
+	}
+	health.TrustMarkIssuer = trustMark.Issuer
+	entityID := m.fed.FederationEntity.EntityID()
+	health.SelfIssued = health.SelfIssued || trustMark.Issuer == entityID
+
+	if trustMark.TrustMarkType != tm.TrustMarkType {
+		setPublishedTrustMarkStatus(
+			&health, adminapi.PublishedTrustMarkStatusInvalid,
+			fmt.Sprintf("trust mark has type '%s'", trustMark.TrustMarkType),
+		)
+	}
+	if trustMark.Subject != entityID {
+		setPublishedTrustMarkStatus(
+			&health, adminapi.PublishedTrustMarkStatusInvalid,
+			fmt.Sprintf("trust mark was issued to '%s'", trustMark.Subject),
+		)
+	}
+	if exp := trustMark.ExpiresAt; exp != nil && !exp.IsZero() {
+		health.ExpiresAt = exp.Unix()
+		switch remaining := exp.Sub(now); {
+		case remaining <= 0:
+			setPublishedTrustMarkStatus(
+				&health, adminapi.PublishedTrustMarkStatusInvalid,
+				fmt.Sprintf("trust mark expired at %s", exp.UTC().Format(time.RFC3339)),
+			)
+			return health
+		case remaining <= m.conf.ExpiryWarning && !refresh:
+			setPublishedTrustMarkStatus(
+				&health, adminapi.PublishedTrustMarkStatusExpiring,
+				fmt.Sprintf("trust mark expires at %s", exp.UTC().Format(time.RFC3339)),
+			)
+		}
+	}
+	if health.SelfIssued || health.Status == adminapi.PublishedTrustMarkStatusInvalid {
+		return health
+	}
+	m.verifyExternalTrustMark(&health, trustMark, trustMarkJWT, owners)
+	return health
+}
+
+// trustedTrustMarkOwners returns the trust mark owners known from trusted
+// sources: the configured trust mark owners and the trust_mark_owners
+// published by the configured trust anchors. Owners configured locally take
+// precedence.
+func (fed *LightHouse) trustedTrustMarkOwners() oidfed.TrustMarkOwners {
+	owners := make(oidfed.TrustMarkOwners)
+	if fed.storages.TrustMarkTypes != nil {
+		configured, err := fed.storages.TrustMarkTypes.OwnersByType()
+		if err != nil {
+			log.Warn().Err(err).Msg("published trust marks: failed to load trust mark owners")
+		}
+		maps.Copy(owners, configured)
+	}
+	if fed.storages.TrustAnchors == nil {
+		return owners
+	}
+	trustAnchors, err := fed.storages.TrustAnchors.List()
+	if err != nil {
+		log.Warn().Err(err).Msg("published trust marks: failed to load trust anchors")
+		return owners
+	}
+	for _, ta := range trustAnchors {
+		ec, err := oidfed.GetEntityConfiguration(ta.EntityID)
+		if err != nil {
+			log.Warn().Err(err).Str("trust_anchor", ta.EntityID).
+				Msg("published trust marks: failed to obtain entity configuration of trust anchor")
+			continue
+		}
+		if ta.JWKS.Keys.Set != nil && !ec.Verify(ta.JWKS.Keys) {
+			log.Warn().Str("trust_anchor", ta.EntityID).
+				Msg("published trust marks: entity configuration of trust anchor is not signed with its configured keys")
+			continue
+		}
+		for trustMarkType, owner := range ec.TrustMarkOwners {
+			if _, ok := owners[trustMarkType]; !ok {
+				owners[trustMarkType] = owner
+			}
+		}
+	}
+	return owners
+}
+
+// verifyExternalTrustMark verifies the signature and delegation of a trust
+// mark issued by another entity and queries its status at the issuer. The
+// delegation is only verified against owners from trusted sources; a trust
+// mark with a delegation of an unknown owner is reported as unknown.
+func (*PublishedTrustMarkMonitor) verifyExternalTrustMark(
+	health *adminapi.PublishedTrustMarkHealth, trustMark *oidfed.TrustMark, trustMarkJWT string,
+	owners oidfed.TrustMarkOwners,
+) {
+	issuer, err := oidfed.GetEntityConfiguration(trustMark.Issuer)
+	if err != nil {
+		setPublishedTrustMarkStatus(
+			health, adminapi.PublishedTrustMarkStatusUnknown,
+			fmt.Sprintf("failed to obtain entity configuration of the issuer: %s", err),
+		)
+		return
+	}
+	var ownerSpecs []oidfed.TrustMarkOwnerSpec
+	if owner, ok := owners[trustMark.TrustMarkType]; ok {
+		ownerSpecs = append(ownerSpecs, owner)
+	} else if trustMark.DelegationJWT != "" {
+		setPublishedTrustMarkStatus(
+			health, adminapi.PublishedTrustMarkStatusUnknown,
+			"the trust mark contains a delegation, but no trusted owner of the trust mark type is known",
+		)
+		return
+	}
+	if err = trustMark.VerifyExternal(issuer.JWKS, ownerSpecs...); err != nil {
+		setPublishedTrustMarkStatus(health, adminapi.PublishedTrustMarkStatusInvalid, err.Error())
+		return
+	}
+
+	var statusEndpoint string
+	if issuer.Metadata != nil && issuer.Metadata.FederationEntity != nil {
+		statusEndpoint = issuer.Metadata.FederationEntity.FederationTrustMarkStatusEndpoint
+	}
+	if statusEndpoint == "" {
+		return
+	}
+	status, err := queryTrustMarkStatus(statusEndpoint, trustMarkJWT, issuer)
+	if err != nil {
+		setPublishedTrustMarkStatus(
+			health, adminapi.PublishedTrustMarkStatusUnknown,
+			fmt.Sprintf("failed to query the trust mark status: %s", err),
+		)
+		return
+	}
+	health.IssuerStatus = status
+	if status != string(model.TrustMarkStatusActive) {
+		setPublishedTrustMarkStatus(
+			health, adminapi.PublishedTrustMarkStatusInvalid, fmt.Sprintf("issuer reports status '%s'", status),
+		)
+	}
+}
+
+// queryTrustMarkStatus requests the status of the trust mark from the
+// issuer's trust mark status endpoint and verifies the signed response with
+// the issuer's keys.
+func queryTrustMarkStatus(endpoint, trustMarkJWT string, issuer *oidfed.EntityStatement) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), trustMarkStatusHTTPTimeout)
+	defer cancel()
+	form := url.Values{}
+	form.Set("trust_mark", trustMarkJWT)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	req.Header.Set(fiber.HeaderContentType, oidfedconst.ContentTypeForm)
+	req.Header.Set(fiber.HeaderAccept, oidfedconst.ContentTypeTrustMarkStatusResponse)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxTrustMarkStatusResponseSize))
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", errors.Errorf("trust mark issuer responded with status %d", resp.StatusCode)
+	}
+	if issuer.JWKS.Set == nil {
+		return "", errors.New("no jwks of the trust mark issuer to verify the status response against")
+	}
+	payload, err := jws.Verify(
+		[]byte(strings.TrimSpace(string(body))),
+		jws.WithKeySet(issuer.JWKS.Set, jws.WithInferAlgorithmFromKey(true)),
+	)
+	if err != nil {
+		return "", errors.New("status response could not be verified with the jwks of the trust mark issuer")
+	}
+	var res TrustMarkStatusResponse
+	if err = json.Unmarshal(payload, &res); err != nil {
+		return "", errors.Wrap(err, "failed to parse status response")
+	}
+	if res.TrustMark != trustMarkJWT {
+		return "", errors.New("status response is for a different trust mark")
+	}
+	return res.Status, nil
+}
+
+// updateDropped updates the trust mark types that are dropped from the
+// entity configuration and purges the cached entity configuration if they
+// changed.
+func (m *PublishedTrustMarkMonitor) updateDropped(report *adminapi.PublishedTrustMarkHealthReport) {
+	dropped := make(map[string]bool)
+	for _, health := range report.TrustMarks {
+		if health.Dropped {
+			dropped[health.TrustMarkType] = true
+		}
+	}
+	m.droppedMu.Lock()
+	changed := len(dropped) != len(m.dropped)
+	for trustMarkType := range dropped {
+		if !m.dropped[trustMarkType] {
+			changed = true
+		}
+	}
+	m.dropped = dropped
+	m.droppedMu.Unlock()
+	if changed {
+		_ = internal.DeleteCache(internal.CacheKeyEntityConfiguration)
+	}
+}
+
+// filter removes the dropped trust marks from the passed configs.
+func (m *PublishedTrustMarkMonitor) filter(
+	configs []*oidfed.EntityConfigurationTrustMarkConfig,
+) []*oidfed.EntityConfigurationTrustMarkConfig {
+	m.droppedMu.RLock()
+	defer m.droppedMu.RUnlock()
+	if len(m.dropped) == 0 {
+		return configs
+	}
+	filtered := make([]*oidfed.EntityConfigurationTrustMarkConfig, 0, len(configs))
+	for _, config := range configs {
+		if !m.dropped[config.TrustMarkType] {
+			filtered = append(filtered, config)
+		}
+	}
+	return filtered
+}
+
+// alertState loads the persisted alert state.
+func (m *PublishedTrustMarkMonitor) alertState() map[string]publishedTrustMarkAlert {
+	state := make(map[string]publishedTrustMarkAlert)
+	kv := m.fed.storages.KV
+	if kv == nil {
+		return state
+	}
+	if _, err := kv.GetAs(model.KeyValueScopePublishedTrustMarks, model.KeyValueKeyTrustMarkHealth, &state); err != nil {
+		log.Warn().Err(err).Msg("published trust marks: failed to load alert state")
+	}
+	return state
+}
+
+// alert sends notifications for trust marks that are not valid and whose
+// status changed since the previous check. The states are persisted in the
+// KV store.
+func (m *PublishedTrustMarkMonitor) alert(report *adminapi.PublishedTrustMarkHealthReport) {
+	previous := m.alertState()
+	current := make(map[string]publishedTrustMarkAlert)
+	for _, health := range report.TrustMarks {
+		if health.Status == adminapi.PublishedTrustMarkStatusValid {
+			continue
+		}
+		state, found := previous[health.TrustMarkType]
+		if found && state.Status == health.Status {
+			current[health.TrustMarkType] = state
+			continue
+		}
+		current[health.TrustMarkType] = publishedTrustMarkAlert{
+			Status: health.Status,
+			Since:  report.CheckedAt,
+		}
+		m.notifyIssue(health)
+	}
+	kv := m.fed.storages.KV
+	if kv == nil {
+		return
+	}
+	if err := kv.SetAny(model.KeyValueScopePublishedTrustMarks, model.KeyValueKeyTrustMarkHealth, current); err != nil {
+		log.Warn().Err(err).Msg("published trust marks: failed to store alert state")
+	}
+}
+
+func (m *PublishedTrustMarkMonitor) notifyIssue(health adminapi.PublishedTrustMarkHealth) {
+	severity := NotificationSeverityWarning
+	if health.Status == adminapi.PublishedTrustMarkStatusInvalid {
+		severity = NotificationSeverityCritical
+	}
+	message := fmt.Sprintf("published trust mark %s is %s", health.TrustMarkType, health.Status)
+	if len(health.Problems) > 0 {
+		message += ": " + strings.Join(health.Problems, "; ")
+	}
+	details := map[string]any{
+		"trust_mark_type":   health.TrustMarkType,
+		"trust_mark_issuer": health.TrustMarkIssuer,
+		"dropped":           health.Dropped,
+	}
+	if health.ExpiresAt != 0 {
+		details["expires_at"] = health.ExpiresAt
+	}
+	if health.IssuerStatus != "" {
+		details["issuer_status"] = health.IssuerStatus
+	}
+	m.fed.notify(
+		Notification{
+			Type:     "published_trust_mark_" + health.Status,
+			Severity: severity,
+			Subject:  m.fed.FederationEntity.EntityID(),
+			Message:  message,
+			Details:  details,
+		},
+	)
+}
+
+// publishedTrustMarkConfigs returns the trust mark configs for the entity
+// configuration without the trust marks dropped by the
+// PublishedTrustMarkMonitor.
+func (fed *LightHouse) publishedTrustMarkConfigs() ([]*oidfed.EntityConfigurationTrustMarkConfig, error) {
+	configs, err := fed.trustMarkConfigProvider.GetConfigs()
+	if err != nil || fed.trustMarkHealth == nil {
+		return configs, err
+	}
+	return fed.trustMarkHealth.filter(configs), nil
+}
+
+// StartPublishedTrustMarkMonitor creates and starts a
+// PublishedTrustMarkMonitor. It is stopped with Stop.
+func (fed *LightHouse) StartPublishedTrustMarkMonitor(conf PublishedTrustMarkHealthConfig) *PublishedTrustMarkMonitor {
+	if fed.trustMarkHealth != nil {
+		fed.trustMarkHealth.Stop()
+	}
+	fed.trustMarkHealth = NewPublishedTrustMarkMonitor(fed, conf)
+	fed.trustMarkHealth.Start()
+	return fed.trustMarkHealth
+}
+
+// PublishedTrustMarkHealth implements the adminapi.LighthouseController
+// interface. If the PublishedTrustMarkMonitor is not running, the trust marks
+// are checked without sending notifications or dropping invalid ones.
+func (fed *LightHouse) PublishedTrustMarkHealth(refresh bool) (*adminapi.PublishedTrustMarkHealthReport, error) {
+	if fed.trustMarkHealth == nil {
+		return NewPublishedTrustMarkMonitor(fed, PublishedTrustMarkHealthConfig{}).Check(time.Now()), nil
+	}
+	if !refresh {
+		if last := fed.trustMarkHealth.Last(); last != nil {
+			return last, nil
+		}
+	}
+	return fed.trustMarkHealth.RunOnce(), nil
+}
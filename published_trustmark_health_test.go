@@ -0,0 +1,315 @@
+package lighthouse
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	oidfed "github.com/go-oidfed/lib"
+	"github.com/go-oidfed/lib/oidfedconst"
+	"github.com/go-oidfed/lib/unixtime"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/go-oidfed/lighthouse/api/adminapi"
+	"github.com/go-oidfed/lighthouse/storage"
+	"github.com/go-oidfed/lighthouse/storage/model"
+)
+
+// testTrustMarkIssuer is a trust mark issuer serving its entity
+// configuration, a trust mark endpoint and a trust mark status endpoint.
+type testTrustMarkIssuer struct {
+	*httptest.Server
+	mu sync.Mutex
+	// statuses holds the status reported per trust mark type
+	statuses map[string]string
+	// signers holds the LightHouse whose keys sign the trust marks of a
+	// type; the issuer's keys are used if not set
+	signers map[string]*LightHouse
+}
+
+func newTestTrustMarkIssuer(t *testing.T, issuer *LightHouse) *testTrustMarkIssuer {
+	t.Helper()
+	jwks, err := issuer.GeneralJWTSigner.JWKS()
+	require.NoError(t, err)
+	s := &testTrustMarkIssuer{
+		statuses: make(map[string]string),
+		signers:  make(map[string]*LightHouse),
+	}
+	s.Server = httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				now := time.Now()
+				s.mu.Lock()
+				defer s.mu.Unlock()
+				switch r.URL.Path {
+				case "/trustmark":
+					trustMarkType := r.URL.Query().Get("trust_mark_type")
+					signer := s.signers[trustMarkType]
+					if signer == nil {
+						signer = issuer
+					}
+					w.Header().Set("Content-Type", oidfedconst.ContentTypeTrustMark)
+					_, _ = w.Write(
+						[]byte(signTestTrustMark(
+							t, signer, s.URL, r.URL.Query().Get("sub"), trustMarkType, now.Add(30*24*time.Hour),
+						)),
+					)
+				case "/status":
+					trustMarkJWT := r.FormValue("trust_mark")
+					tm, err := oidfed.ParseTrustMark([]byte(trustMarkJWT))
+					if err != nil {
+						w.WriteHeader(http.StatusBadRequest)
+						return
+					}
+					jwt, err := issuer.GeneralJWTSigner.JWT(
+						TrustMarkStatusResponse{
+							Issuer:    s.URL,
+							IssuedAt:  now.Unix(),
+							TrustMark: trustMarkJWT,
+							Status:    s.statuses[tm.TrustMarkType],
+						}, oidfedconst.JWTTypeTrustMarkStatusResponse,
+					)
+					require.NoError(t, err)
+					w.Header().Set("Content-Type", oidfedconst.ContentTypeTrustMarkStatusResponse)
+					_, _ = w.Write(jwt)
+				default:
+					payload := oidfed.EntityStatementPayload{
+						Issuer:    s.URL,
+						Subject:   s.URL,
+						IssuedAt:  unixtime.Unixtime{Time: now},
+						ExpiresAt: unixtime.Unixtime{Time: now.Add(time.Hour)},
+						JWKS:      jwks,
+						Metadata: &oidfed.Metadata{
+							FederationEntity: &oidfed.FederationEntityMetadata{
+								FederationTrustMarkEndpoint:       s.URL + "/trustmark",
+								FederationTrustMarkStatusEndpoint: s.URL + "/status",
+							},
+						},
+					}
+					jwt, err := issuer.GeneralJWTSigner.EntityStatementSigner().JWT(payload)
+					require.NoError(t, err)
+					w.Header().Set("Content-Type", oidfedconst.ContentTypeEntityStatement)
+					_, _ = w.Write(jwt)
+				}
+			},
+		),
+	)
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *testTrustMarkIssuer) setStatus(trustMarkType, status string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.statuses[trustMarkType] = status
+}
+
+func signTestTrustMark(
+	t *testing.T, signer *LightHouse, issuer, subject, trustMarkType string, exp time.Time,
+) string {
+	t.Helper()
+	jwt, err := signer.GeneralJWTSigner.JWT(
+		map[string]any{
+			"iss":             issuer,
+			"sub":             subject,
+			"trust_mark_type": trustMarkType,
+			"iat":             time.Now().Unix(),
+			"exp":             exp.Unix(),
+		}, oidfedconst.JWTTypeTrustMark,
+	)
+	require.NoError(t, err)
+	return string(jwt)
+}
+
+func TestPublishedTrustMarkMonitor(t *testing.T) {
+	const (
+		activeType  = "https://tm.example.org/active"
+		revokedType = "https://tm.example.org/revoked"
+		forgedType  = "https://tm.example.org/forged"
+	)
+	fed, _ := newTestLightHouse(t)
+	setDelegationTestSigner(t, fed)
+	fed.trustMarkConfigProvider = storage.NewTrustMarkConfigProvider(
+		fed.storages.PublishedTrustMarks, fed.FederationEntity.EntityID(), "", nil,
+	)
+	notifier := &recordingNotifier{}
+	fed.notifier = notifier
+
+	issuerFed, _ := newTestLightHouse(t)
+	setDelegationTestSigner(t, issuerFed)
+	issuer := newTestTrustMarkIssuer(t, issuerFed)
+	issuer.setStatus(activeType, string(model.TrustMarkStatusActive))
+	issuer.setStatus(revokedType, string(model.TrustMarkStatusRevoked))
+	issuer.setStatus(forgedType, string(model.TrustMarkStatusActive))
+	// Signed with the keys of LightHouse instead of the issuer's keys
+	issuer.signers[forgedType] = fed
+
+	for _, trustMarkType := range []string{activeType, revokedType, forgedType} {
+		_, err := fed.storages.PublishedTrustMarks.Create(
+			model.AddTrustMark{
+				TrustMarkType:   trustMarkType,
+				TrustMarkIssuer: issuer.URL,
+				Refresh:         true,
+			},
+		)
+		require.NoError(t, err)
+	}
+
+	monitor := NewPublishedTrustMarkMonitor(fed, PublishedTrustMarkHealthConfig{DropInvalid: true})
+	fed.trustMarkHealth = monitor
+	report := monitor.RunOnce()
+	assert.Equal(t, NotificationSeverityCritical, report.Status)
+	assert.True(t, report.DropInvalid)
+	require.Len(t, report.TrustMarks, 3)
+	byType := make(map[string]adminapi.PublishedTrustMarkHealth)
+	for _, h := range report.TrustMarks {
+		byType[h.TrustMarkType] = h
+	}
+
+	active := byType[activeType]
+	assert.Equal(t, adminapi.PublishedTrustMarkStatusValid, active.Status)
+	assert.Equal(t, string(model.TrustMarkStatusActive), active.IssuerStatus)
+	assert.Equal(t, issuer.URL, active.TrustMarkIssuer)
+	assert.NotZero(t, active.ExpiresAt)
+	assert.False(t, active.Dropped)
+
+	revoked := byType[revokedType]
+	assert.Equal(t, adminapi.PublishedTrustMarkStatusInvalid, revoked.Status)
+	assert.Equal(t, string(model.TrustMarkStatusRevoked), revoked.IssuerStatus)
+	assert.True(t, revoked.Dropped)
+
+	forged := byType[forgedType]
+	assert.Equal(t, adminapi.PublishedTrustMarkStatusInvalid, forged.Status)
+	assert.Empty(t, forged.IssuerStatus)
+	assert.True(t, forged.Dropped)
+
+	configs, err := fed.publishedTrustMarkConfigs()
+	require.NoError(t, err)
+	require.Len(t, configs, 1)
+	assert.Equal(t, activeType, configs[0].TrustMarkType)
+	assert.Len(t, notifier.notifications, 2)
+
+	// Unchanged issues are not notified again; a new monitor restores the
+	// dropped trust marks from the persisted state.
+	monitor.RunOnce()
+	assert.Len(t, notifier.notifications, 2)
+	all, err := fed.trustMarkConfigProvider.GetConfigs()
+	require.NoError(t, err)
+	require.Len(t, all, 3)
+	restored := NewPublishedTrustMarkMonitor(fed, PublishedTrustMarkHealthConfig{DropInvalid: true})
+	assert.Len(t, restored.filter(all), 1)
+	notDropping := NewPublishedTrustMarkMonitor(fed, PublishedTrustMarkHealthConfig{})
+	assert.Len(t, notDropping.filter(all), 3)
+
+	// Once the issuer reports the trust mark as active again, it is published
+	issuer.setStatus(revokedType, string(model.TrustMarkStatusActive))
+	report = monitor.RunOnce()
+	require.Len(t, report.TrustMarks, 3)
+	assert.Equal(t, revokedType, report.TrustMarks[2].TrustMarkType)
+	assert.Equal(t, adminapi.PublishedTrustMarkStatusValid, report.TrustMarks[2].Status)
+	configs, err = fed.publishedTrustMarkConfigs()
+	require.NoError(t, err)
+	assert.Len(t, configs, 2)
+}
+
+func TestPublishedTrustMarkMonitor_Expiry(t *testing.T) {
+	const trustMarkType = "https://tm.example.org/expiring"
+	fed, _ := newTestLightHouse(t)
+	setDelegationTestSigner(t, fed)
+	fed.trustMarkConfigProvider = storage.NewTrustMarkConfigProvider(
+		fed.storages.PublishedTrustMarks, fed.FederationEntity.EntityID(), "", nil,
+	)
+	issuer, _ := newTestLightHouse(t)
+	setDelegationTestSigner(t, issuer)
+	issuerServer := newTestTrustMarkIssuer(t, issuer)
+	issuerServer.setStatus(trustMarkType, string(model.TrustMarkStatusActive))
+	entityID := fed.FederationEntity.EntityID()
+	now := time.Now()
+	monitor := NewPublishedTrustMarkMonitor(fed, PublishedTrustMarkHealthConfig{})
+
+	check := func(exp time.Time) adminapi.PublishedTrustMarkHealth {
+		config := &oidfed.EntityConfigurationTrustMarkConfig{
+			TrustMarkType: trustMarkType,
+			JWT:           signTestTrustMark(t, issuer, issuerServer.URL, entityID, trustMarkType, exp),
+		}
+		return monitor.checkTrustMark(
+			model.PublishedTrustMark{TrustMarkType: trustMarkType}, config, nil, now,
+		)
+	}
+
+	expiring := check(now.Add(24 * time.Hour))
+	assert.Equal(t, adminapi.PublishedTrustMarkStatusExpiring, expiring.Status)
+	assert.Equal(t, now.Add(24*time.Hour).Unix(), expiring.ExpiresAt)
+	assert.Equal(t, string(model.TrustMarkStatusActive), expiring.IssuerStatus)
+
+	expired := check(now.Add(-time.Minute))
+	assert.Equal(t, adminapi.PublishedTrustMarkStatusInvalid, expired.Status)
+	require.Len(t, expired.Problems, 1)
+	assert.Contains(t, expired.Problems[0], "expired")
+
+	missing := monitor.checkTrustMark(model.PublishedTrustMark{TrustMarkType: trustMarkType}, nil, nil, now)
+	assert.Equal(t, adminapi.PublishedTrustMarkStatusInvalid, missing.Status)
+}
+
+func TestPublishedTrustMarkMonitor_UntrustedDelegation(t *testing.T) {
+	const trustMarkType = "https://tm.example.org/delegated"
+	fed, _ := newTestLightHouse(t)
+	setDelegationTestSigner(t, fed)
+	issuerFed, _ := newTestLightHouse(t)
+	setDelegationTestSigner(t, issuerFed)
+	issuer := newTestTrustMarkIssuer(t, issuerFed)
+	issuer.setStatus(trustMarkType, string(model.TrustMarkStatusActive))
+
+	// The issuer vouches for itself as owner of the trust mark type
+	now := time.Now()
+	delegation, err := issuerFed.GeneralJWTSigner.JWT(
+		map[string]any{
+			"iss":             issuer.URL,
+			"sub":             issuer.URL,
+			"trust_mark_type": trustMarkType,
+			"iat":             now.Unix(),
+			"exp":             now.Add(time.Hour).Unix(),
+		}, oidfedconst.JWTTypeTrustMarkDelegation,
+	)
+	require.NoError(t, err)
+	trustMarkJWT, err := issuerFed.GeneralJWTSigner.JWT(
+		map[string]any{
+			"iss":             issuer.URL,
+			"sub":             fed.FederationEntity.EntityID(),
+			"trust_mark_type": trustMarkType,
+			"iat":             now.Unix(),
+			"exp":             now.Add(time.Hour).Unix(),
+			"delegation":      string(delegation),
+		}, oidfedconst.JWTTypeTrustMark,
+	)
+	require.NoError(t, err)
+	trustMark, err := oidfed.ParseTrustMark(trustMarkJWT)
+	require.NoError(t, err)
+
+	monitor := NewPublishedTrustMarkMonitor(fed, PublishedTrustMarkHealthConfig{})
+	health := adminapi.PublishedTrustMarkHealth{Status: adminapi.PublishedTrustMarkStatusValid}
+	monitor.verifyExternalTrustMark(&health, trustMark, string(trustMarkJWT), fed.trustedTrustMarkOwners())
+	assert.Equal(t, adminapi.PublishedTrustMarkStatusUnknown, health.Status)
+	assert.Empty(t, health.IssuerStatus)
+
+	// Once the owner is configured, the delegation is verified with its keys
+	ownerJWKS, err := issuerFed.GeneralJWTSigner.JWKS()
+	require.NoError(t, err)
+	_, err = fed.storages.TrustMarkTypes.Create(
+		model.AddTrustMarkType{
+			TrustMarkType: trustMarkType,
+			TrustMarkOwner: &model.AddTrustMarkOwner{
+				EntityID: issuer.URL,
+				JWKS:     model.JWKS{Keys: ownerJWKS},
+			},
+		},
+	)
+	require.NoError(t, err)
+	health = adminapi.PublishedTrustMarkHealth{Status: adminapi.PublishedTrustMarkStatusValid}
+	monitor.verifyExternalTrustMark(&health, trustMark, string(trustMarkJWT), fed.trustedTrustMarkOwners())
+	assert.Equal(t, adminapi.PublishedTrustMarkStatusValid, health.Status, health.Problems)
+	assert.Equal(t, string(model.TrustMarkStatusActive), health.IssuerStatus)
+}
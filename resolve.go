@@ -25,8 +25,10 @@ func (fed *LightHouse) AddResolveEndpoint(
 		return nil
 	}
 
-	writeResponse := func(ctx *fiber.Ctx, res *oidfed.ResolveResponse) error {
-		jwt, err := fed.GeneralJWTSigner.ResolveResponseSigner().JWT(res)
+	writeResponse := func(ctx *fiber.Ctx, res *oidfed.ResolveResponse, algs []string) error {
+		jwt, err := fed.PurposeSigner(model.SigningPurposeResolve).JWT(
+			res, oidfedconst.JWTTypeResolveResponse, algs...,
+		)
 		if err != nil {
 			ctx.Status(fiber.StatusInternalServerError)
 			return ctx.JSON(oidfed.ErrorServerError(err.Error()))
@@ -49,6 +51,11 @@ func (fed *LightHouse) AddResolveEndpoint(
 			ctx.Status(fiber.StatusBadRequest)
 			return ctx.JSON(oidfed.ErrorInvalidRequest("required parameter 'trust_anchor' not given"))
 		}
+		algs, err := fed.negotiateSigningAlg(model.SigningPurposeResolve, requestedSigningAlgs(ctx))
+		if err != nil {
+			ctx.Status(fiber.StatusBadRequest)
+			return ctx.JSON(oidfed.ErrorInvalidRequest(err.Error()))
+		}
 		if len(allowedTrustAnchors) > 0 {
 			req.TrustAnchor = go2.Intersect(allowedTrustAnchors, req.TrustAnchor)
 			if len(req.TrustAnchor) == 0 {
@@ -62,14 +69,17 @@ func (fed *LightHouse) AddResolveEndpoint(
 		}
 		if proactiveResolver != nil {
 			for _, ta := range req.TrustAnchor {
-				jwt, err := proactiveResolver.Store.ReadJWT(req.Subject, ta, req.EntityTypes)
-				if err != nil {
-					ctx.Status(fiber.StatusInternalServerError)
-					return ctx.JSON(oidfed.ErrorServerError(err.Error()))
-				}
-				if jwt != nil {
-					ctx.Set(fiber.HeaderContentType, oidfedconst.ContentTypeResolveResponse)
-					return ctx.Send(jwt)
+				// Stored responses are signed with the default algorithm
+				if len(algs) == 0 {
+					jwt, err := proactiveResolver.Store.ReadJWT(req.Subject, ta, req.EntityTypes)
+					if err != nil {
+						ctx.Status(fiber.StatusInternalServerError)
+						return ctx.JSON(oidfed.ErrorServerError(err.Error()))
+					}
+					if jwt != nil {
+						ctx.Set(fiber.HeaderContentType, oidfedconst.ContentTypeResolveResponse)
+						return ctx.Send(jwt)
+					}
 				}
 				res, err := proactiveResolver.Store.ReadJSON(req.Subject, ta, req.EntityTypes)
 				if err != nil {
@@ -77,7 +87,7 @@ func (fed *LightHouse) AddResolveEndpoint(
 					return ctx.JSON(oidfed.ErrorServerError(err.Error()))
 				}
 				if res != nil {
-					return writeResponse(ctx, res)
+					return writeResponse(ctx, res, algs)
 				}
 			}
 		}
@@ -86,7 +96,7 @@ func (fed *LightHouse) AddResolveEndpoint(
 			return err
 		}
 		if res != nil {
-			return writeResponse(ctx, res)
+			return writeResponse(ctx, res, algs)
 		}
 		// we are here only if createResolveResponse returned send an
 		// error (ctx.JSON(Error)), but that was successful.
@@ -0,0 +1,533 @@
+package lighthouse
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	oidfed "github.com/go-oidfed/lib"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+
+	"github.com/go-oidfed/lighthouse/internal"
+	"github.com/go-oidfed/lighthouse/storage/model"
+)
+
+// Actions that can be applied when an entity fails re-validation for longer
+// than the configured grace period.
+const (
+	// RevalidationActionAlert only records events and sends notifications.
+	RevalidationActionAlert = "alert"
+	// RevalidationActionPending sets the entity's status to pending.
+	RevalidationActionPending = "pending"
+	// RevalidationActionInactive sets the entity's status to inactive. For
+	// trust mark subjects this also revokes all issued trust mark instances.
+	RevalidationActionInactive = "inactive"
+	// RevalidationActionRevoke revokes all issued trust mark instances of a
+	// trust mark subject but keeps its status. Only valid for trust mark
+	// subjects.
+	RevalidationActionRevoke = "revoke"
+)
+
+// revalidationActor is recorded as the actor of events created by the
+// EntityRevalidator.
+const revalidationActor = "revalidation"
+
+// RevalidationConfig configures the EntityRevalidator.
+type RevalidationConfig struct {
+	// Interval is the time between two re-validation runs.
+	Interval time.Duration
+	// Subordinates configures re-validation of active subordinates against
+	// the checker configured for the enroll endpoint.
+	Subordinates RevalidationPolicy
+	// RequireAuthorityHint additionally requires that a subordinate's Entity
+	// Configuration lists this entity in its authority_hints.
+	RequireAuthorityHint bool
+	// TrustMarkSubjects configures re-validation of active trust mark
+	// subjects against the checker of their TrustMarkSpec's
+	// EligibilityConfig.
+	TrustMarkSubjects RevalidationPolicy
+}
+
+// RevalidationPolicy defines what happens when an entity fails
+// re-validation.
+type RevalidationPolicy struct {
+	// Enabled turns re-validation on for this kind of entity.
+	Enabled bool
+	// Action is applied once an entity has been failing for longer than
+	// GracePeriod. One of RevalidationActionAlert, RevalidationActionPending,
+	// RevalidationActionInactive, RevalidationActionRevoke.
+	Action string
+	// GracePeriod is how long an entity may keep failing before Action is
+	// applied.
+	GracePeriod time.Duration
+}
+
+// revalidationState is persisted in the KV store per failing entity, so that
+// grace periods survive restarts.
+type revalidationState struct {
+	FailingSince int64  `json:"failing_since"`
+	Reason       string `json:"reason"`
+	Enforced     bool   `json:"enforced,omitempty"`
+}
+
+// RevalidationSummary summarizes a single re-validation run.
+type RevalidationSummary struct {
+	Checked   int `json:"checked"`
+	Failed    int `json:"failed"`
+	Recovered int `json:"recovered"`
+	Enforced  int `json:"enforced"`
+}
+
+// EntityRevalidator periodically re-runs the configured entity checks against
+// active subordinates and trust mark subjects.
+type EntityRevalidator struct {
+	fed    *LightHouse
+	conf   RevalidationConfig
+	fetch  func(entityID string) (*oidfed.EntityStatement, error)
+	mu     sync.Mutex
+	runner periodicRunner
+}
+
+// NewEntityRevalidator creates a new EntityRevalidator for the passed
+// LightHouse.
+func NewEntityRevalidator(fed *LightHouse, conf RevalidationConfig) *EntityRevalidator {
+	if conf.Interval <= 0 {
+		conf.Interval = 24 * time.Hour
+	}
+	return &EntityRevalidator{
+		fed:   fed,
+		conf:  conf,
+		fetch: oidfed.GetEntityConfiguration,
+	}
+}
+
+// Start starts the periodic re-validation in the background. The first run
+// happens after one interval.
+func (r *EntityRevalidator) Start() {
+	r.runner.start(
+		periodicTask{
+			interval: r.conf.Interval,
+			run: func(ctx context.Context) {
+				summary := r.RunOnce(ctx)
+				log.Info().
+					Int("checked", summary.Checked).
+					Int("failed", summary.Failed).
+					Int("recovered", summary.Recovered).
+					Int("enforced", summary.Enforced).
+					Msg("entity re-validation finished")
+			},
+		},
+	)
+	log.Info().Dur("interval", r.conf.Interval).Msg("entity revalidator started")
+}
+
+// Stop stops the periodic re-validation and waits for a running pass to
+// finish.
+func (r *EntityRevalidator) Stop() {
+	r.runner.stop()
+}
+
+// RunOnce runs a single re-validation pass over all active subordinates and
+// trust mark subjects. Concurrent calls are serialized.
+func (r *EntityRevalidator) RunOnce(ctx context.Context) RevalidationSummary {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var summary RevalidationSummary
+	if r.conf.Subordinates.Enabled {
+		r.revalidateSubordinates(ctx, &summary)
+	}
+	if r.conf.TrustMarkSubjects.Enabled {
+		r.revalidateTrustMarkSubjects(ctx, &summary)
+	}
+	return summary
+}
+
+func (r *EntityRevalidator) revalidateSubordinates(ctx context.Context, summary *RevalidationSummary) {
+	storages := r.fed.storages
+	if storages.Subordinates == nil {
+		return
+	}
+	var checker EntityChecker
+	if storages.FederationEndpoints != nil {
+		ep, err := storages.FederationEndpoints.GetByType(model.EndpointTypeEnroll)
+		if err == nil && ep != nil {
+			checker, err = enrollCheckerFromDBConfig(ep.Config, r.fed.metadataValidator)
+			if err != nil {
+				log.Warn().Err(err).Msg("revalidation: failed to load enroll checker; skipping subordinates")
+				return
+			}
+		}
+	}
+	if checker == nil && !r.conf.RequireAuthorityHint {
+		log.Debug().Msg("revalidation: no enroll checker configured; skipping subordinates")
+		return
+	}
+
+	subordinates, err := storages.Subordinates.GetByStatus(model.StatusActive)
+	if err != nil {
+		log.Warn().Err(err).Msg("revalidation: failed to list active subordinates")
+		return
+	}
+	for _, sub := range subordinates {
+		if ctx.Err() != nil {
+			return
+		}
+		summary.Checked++
+		reason := r.checkSubordinate(ctx, sub.EntityID, checker)
+		if ctx.Err() != nil {
+			return
+		}
+		r.handleResult(
+			revalidationTarget{
+				key:      "subordinate:" + strconv.FormatUint(uint64(sub.ID), 10),
+				entityID: sub.EntityID,
+				policy:   r.conf.Subordinates,
+				record:   r.subordinateEventRecorder(sub.ID),
+				enforce: func(action string) (*model.Status, error) {
+					return r.enforceSubordinate(sub.EntityID, action)
+				},
+			}, reason, summary,
+		)
+	}
+}
+
+// checkSubordinate fetches and verifies the subordinate's Entity
+// Configuration and runs the checks. It returns an empty string if all checks
+// pass, otherwise the reason for the failure.
+func (r *EntityRevalidator) checkSubordinate(ctx context.Context, entityID string, checker EntityChecker) string {
+	info, err := r.fed.storages.Subordinates.Get(entityID)
+	if err != nil || info == nil {
+		return "could not load subordinate"
+	}
+	ec, err := r.fetch(entityID)
+	if err != nil {
+		return "could not obtain entity configuration: " + err.Error()
+	}
+	if info.JWKS.Keys.Set != nil && info.JWKS.Keys.Len() > 0 && !ec.Verify(info.JWKS.Keys) {
+		return "entity configuration signature could not be verified against stored JWKS"
+	}
+	if r.conf.RequireAuthorityHint && !slices.Contains(ec.AuthorityHints, r.fed.FederationEntity.EntityID()) {
+		return fmt.Sprintf("entity configuration does not include '%s' in authority_hints", r.fed.FederationEntity.EntityID())
+	}
+	if checker == nil {
+		return ""
+	}
+	return checkerFailureReason(ctx, checker, ec)
+}
+
+func (r *EntityRevalidator) enforceSubordinate(entityID, action string) (*model.Status, error) {
+	var status model.Status
+	switch action {
+	case RevalidationActionPending:
+		status = model.StatusPending
+	case RevalidationActionInactive:
+		status = model.StatusInactive
+	default:
+		return nil, errors.Errorf("unsupported revalidation action for subordinates: %s", action)
+	}
+	if err := r.fed.storages.Subordinates.UpdateStatus(entityID, status); err != nil {
+		return nil, err
+	}
+	_ = internal.DeleteCache(internal.SubordinateStatementCacheKey(entityID))
+	if err := r.fed.DeleteSubjectPresignedStatements(entityID); err != nil {
+		log.Warn().Err(err).Str("subordinate", entityID).Msg("failed to delete pre-signed statements")
+	}
+	r.fed.notifySubordinateJWKSRefresher(entityID)
+	return &status, nil
+}
+
+func (r *EntityRevalidator) subordinateEventRecorder(subordinateID uint) func(eventType string, status *model.Status, message string) {
+	return func(eventType string, status *model.Status, message string) {
+		if r.fed.storages.SubordinateEvents == nil {
+			return
+		}
+		event := model.SubordinateEvent{
+			SubordinateID: subordinateID,
+			Timestamp:     nowUnix(),
+			Type:          eventType,
+			Message:       strPtrOrNil(message),
+			Actor:         new(revalidationActor),
+		}
+		if status != nil {
+			event.Status = new(status.String())
+		}
+		if err := r.fed.storages.SubordinateEvents.Add(event); err != nil {
+			log.Warn().Err(err).Uint("subordinate_id", subordinateID).Msg("failed to record revalidation event")
+		}
+	}
+}
+
+func (r *EntityRevalidator) revalidateTrustMarkSubjects(ctx context.Context, summary *RevalidationSummary) {
+	storages := r.fed.storages
+	if storages.TrustMarkSpecs == nil {
+		return
+	}
+	specs, err := storages.TrustMarkSpecs.List()
+	if err != nil {
+		log.Warn().Err(err).Msg("revalidation: failed to list trust mark specs")
+		return
+	}
+	active := model.StatusActive
+	for _, spec := range specs {
+		if spec.EligibilityConfig == nil || spec.EligibilityConfig.Checker == nil ||
+			spec.EligibilityConfig.Mode == model.EligibilityModeDBOnly {
+			continue
+		}
+		checker, err := newEligibilityChecker(
+			spec.TrustMarkType, spec.EligibilityConfig.Checker, storages.TrustMarks, r.fed.metadataValidator,
+		)
+		if err != nil {
+			log.Warn().Err(err).Str("trust_mark_type", spec.TrustMarkType).
+				Msg("revalidation: failed to build eligibility checker")
+			continue
+		}
+		specIdent := strconv.FormatUint(uint64(spec.ID), 10)
+		subjects, err := storages.TrustMarkSpecs.ListSubjects(specIdent, &active)
+		if err != nil {
+			log.Warn().Err(err).Str("trust_mark_type", spec.TrustMarkType).
+				Msg("revalidation: failed to list trust mark subjects")
+			continue
+		}
+		for _, subject := range subjects {
+			if ctx.Err() != nil {
+				return
+			}
+			summary.Checked++
+			var reason string
+			ec, err := r.fetch(subject.EntityID)
+			if err != nil {
+				reason = "could not obtain entity configuration: " + err.Error()
+			} else {
+				reason = checkerFailureReason(ctx, checker, ec)
+			}
+			if ctx.Err() != nil {
+				return
+			}
+			trustMarkType := spec.TrustMarkType
+			subjectIdent := strconv.FormatUint(uint64(subject.ID), 10)
+			r.handleResult(
+				revalidationTarget{
+					key:      "trust_mark_subject:" + subjectIdent,
+					entityID: subject.EntityID,
+					details:  map[string]any{"trust_mark_type": trustMarkType},
+					policy:   r.conf.TrustMarkSubjects,
+					record:   r.trustMarkSubjectEventRecorder(subject.ID),
+					enforce: func(action string) (*model.Status, error) {
+						return r.enforceTrustMarkSubject(specIdent, subjectIdent, trustMarkType, subject, action)
+					},
+				}, reason, summary,
+			)
+		}
+	}
+}
+
+func (r *EntityRevalidator) enforceTrustMarkSubject(
+	specIdent, subjectIdent, trustMarkType string, subject model.TrustMarkSubject, action string,
+) (*model.Status, error) {
+	var status *model.Status
+	switch action {
+	case RevalidationActionPending, RevalidationActionInactive:
+		s := model.StatusPending
+		if action == RevalidationActionInactive {
+			s = model.StatusInactive
+		}
+		if _, err := r.fed.storages.TrustMarkSpecs.ChangeSubjectStatus(specIdent, subjectIdent, s); err != nil {
+			return nil, err
+		}
+		status = &s
+	case RevalidationActionRevoke:
+		if r.fed.storages.TrustMarkInstances == nil {
+			return nil, errors.New("trust mark instance storage not available")
+		}
+		if _, err := r.fed.storages.TrustMarkInstances.RevokeBySubjectID(subject.ID); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, errors.Errorf("unsupported revalidation action for trust mark subjects: %s", action)
+	}
+	if r.fed.issuedTrustMarkCache != nil {
+		r.fed.issuedTrustMarkCache.Invalidate(trustMarkType, subject.EntityID)
+	}
+	if r.fed.eligibilityCache != nil {
+		r.fed.eligibilityCache.Invalidate(trustMarkType, subject.EntityID)
+	}
+	return status, nil
+}
+
+func (r *EntityRevalidator) trustMarkSubjectEventRecorder(subjectID uint) func(eventType string, status *model.Status, message string) {
+	return func(eventType string, status *model.Status, message string) {
+		if r.fed.storages.TrustMarkSubjectEvents == nil {
+			return
+		}
+		event := model.TrustMarkSubjectEvent{
+			TrustMarkSubjectID: subjectID,
+			Timestamp:          nowUnix(),
+			Type:               eventType,
+			Message:            strPtrOrNil(message),
+			Actor:              new(revalidationActor),
+		}
+		if status != nil {
+			event.Status = new(status.String())
+		}
+		if err := r.fed.storages.TrustMarkSubjectEvents.Add(event); err != nil {
+			log.Warn().Err(err).Uint("trust_mark_subject_id", subjectID).Msg("failed to record revalidation event")
+		}
+	}
+}
+
+// revalidationTarget bundles everything handleResult needs to know about a
+// single re-validated entity.
+type revalidationTarget struct {
+	key      string
+	entityID string
+	details  map[string]any
+	policy   RevalidationPolicy
+	record   func(eventType string, status *model.Status, message string)
+	enforce  func(action string) (*model.Status, error)
+}
+
+// handleResult updates the persisted failure state of a target, records
+// events, sends notifications and applies the configured action once the
+// grace period has elapsed. An empty reason means the checks passed.
+func (r *EntityRevalidator) handleResult(t revalidationTarget, reason string, summary *RevalidationSummary) {
+	kv := r.fed.storages.KV
+	var state revalidationState
+	var found bool
+	if kv != nil {
+		var err error
+		found, err = kv.GetAs(model.KeyValueScopeRevalidation, t.key, &state)
+		if err != nil {
+			log.Warn().Err(err).Str("entity_id", t.entityID).Msg("revalidation: failed to load state")
+		}
+	}
+
+	if reason == "" {
+		if !found {
+			return
+		}
+		summary.Recovered++
+		r.deleteState(t.key)
+		t.record(model.EventTypeRevalidationRecovered, nil, "")
+		r.fed.notify(
+			Notification{
+				Type:     model.EventTypeRevalidationRecovered,
+				Severity: NotificationSeverityInfo,
+				Subject:  t.entityID,
+				Message:  "entity passes re-validation again",
+				Details:  t.details,
+			},
+		)
+		return
+	}
+
+	summary.Failed++
+	now := nowUnix()
+	if !found || state.Reason != reason {
+		if !found {
+			state.FailingSince = now
+		}
+		state.Reason = reason
+		r.saveState(t.key, state)
+		t.record(model.EventTypeRevalidationFailed, nil, reason)
+		r.fed.notify(
+			Notification{
+				Type:     model.EventTypeRevalidationFailed,
+				Severity: NotificationSeverityWarning,
+				Subject:  t.entityID,
+				Message:  "entity failed re-validation: " + reason,
+				Details:  t.details,
+			},
+		)
+	}
+
+	action := t.policy.Action
+	if action == "" || action == RevalidationActionAlert || state.Enforced {
+		return
+	}
+	if time.Duration(now-state.FailingSince)*time.Second < t.policy.GracePeriod {
+		return
+	}
+	status, err := t.enforce(action)
+	if err != nil {
+		log.Error().Err(err).Str("entity_id", t.entityID).Str("action", action).
+			Msg("revalidation: failed to apply action")
+		return
+	}
+	summary.Enforced++
+	if status != nil {
+		// The entity is no longer active and won't be re-validated, so there
+		// is no state to keep.
+		r.deleteState(t.key)
+	} else {
+		state.Enforced = true
+		r.saveState(t.key, state)
+	}
+	t.record(model.EventTypeRevalidationEnforced, status, action+": "+reason)
+	r.fed.notify(
+		Notification{
+			Type:     model.EventTypeRevalidationEnforced,
+			Severity: NotificationSeverityCritical,
+			Subject:  t.entityID,
+			Message:  fmt.Sprintf("applied re-validation action '%s': %s", action, reason),
+			Details:  t.details,
+		},
+	)
+}
+
+func (r *EntityRevalidator) saveState(key string, state revalidationState) {
+	if r.fed.storages.KV == nil {
+		return
+	}
+	if err := r.fed.storages.KV.SetAny(model.KeyValueScopeRevalidation, key, state); err != nil {
+		log.Warn().Err(err).Str("key", key).Msg("revalidation: failed to store state")
+	}
+}
+
+func (r *EntityRevalidator) deleteState(key string) {
+	if r.fed.storages.KV == nil {
+		return
+	}
+	if err := r.fed.storages.KV.Delete(model.KeyValueScopeRevalidation, key); err != nil {
+		log.Warn().Err(err).Str("key", key).Msg("revalidation: failed to delete state")
+	}
+}
+
+// checkerFailureReason runs the checker against the passed Entity
+// Configuration and returns an empty string on success, otherwise a
+// description of the failure.
+func checkerFailureReason(ctx context.Context, checker EntityChecker, ec *oidfed.EntityStatement) string {
+	var entityTypes []string
+	if ec.Metadata != nil {
+		entityTypes = ec.Metadata.GuessEntityTypes()
+	}
+	ok, _, errResponse := RunEntityChecker(ctx, 0, checker, ec, entityTypes)
+	if ok {
+		return ""
+	}
+	if errResponse != nil && errResponse.ErrorDescription != "" {
+		return errResponse.ErrorDescription
+	}
+	return "entity check failed"
+}
+
+// StartRevalidation creates and starts an EntityRevalidator. It is stopped
+// with Stop.
+func (fed *LightHouse) StartRevalidation(conf RevalidationConfig) *EntityRevalidator {
+	if fed.revalidator != nil {
+		fed.revalidator.Stop()
+	}
+	fed.revalidator = NewEntityRevalidator(fed, conf)
+	fed.revalidator.Start()
+	return fed.revalidator
+}
+
+// Revalidator returns the EntityRevalidator, or nil if re-validation is not
+// running.
+func (fed *LightHouse) Revalidator() *EntityRevalidator {
+	return fed.revalidator
+}
@@ -0,0 +1,280 @@
+package lighthouse
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	oidfed "github.com/go-oidfed/lib"
+
+	"github.com/go-oidfed/lighthouse/storage"
+	"github.com/go-oidfed/lighthouse/storage/model"
+)
+
+const testLighthouseID = "https://lighthouse.example.org"
+
+func newTestLightHouse(t *testing.T) (*LightHouse, *storage.Storage) {
+	t.Helper()
+	store := newTestStorage(t)
+	backends, err := store.Backends(storage.JTIStorageDB)
+	require.NoError(t, err)
+	return &LightHouse{
+		FederationEntity: stubFedEntity{},
+		storages:         backends,
+	}, store
+}
+
+// stubFetch returns an entity configuration fetcher serving the passed
+// authority hints for every entity.
+func stubFetch(authorityHints *[]string) func(string) (*oidfed.EntityStatement, error) {
+	return func(entityID string) (*oidfed.EntityStatement, error) {
+		ec := testEntityStatement(entityID)
+		ec.AuthorityHints = *authorityHints
+		return ec, nil
+	}
+}
+
+func addActiveSubordinate(t *testing.T, fed *LightHouse, entityID string) *model.ExtendedSubordinateInfo {
+	t.Helper()
+	require.NoError(
+		t, fed.storages.Subordinates.Add(
+			model.ExtendedSubordinateInfo{
+				BasicSubordinateInfo: model.BasicSubordinateInfo{
+					EntityID: entityID,
+					Status:   model.StatusActive,
+				},
+			},
+		),
+	)
+	info, err := fed.storages.Subordinates.Get(entityID)
+	require.NoError(t, err)
+	return info
+}
+
+func subordinateEventTypes(t *testing.T, fed *LightHouse, id uint) []string {
+	t.Helper()
+	events, _, err := fed.storages.SubordinateEvents.GetBySubordinateID(id, model.EventQueryOpts{})
+	require.NoError(t, err)
+	types := make([]string, len(events))
+	for i, e := range events {
+		types[i] = e.Type
+	}
+	return types
+}
+
+func TestEntityRevalidator_Subordinate_EnforcedWithoutGracePeriod(t *testing.T) {
+	fed, _ := newTestLightHouse(t)
+	info := addActiveSubordinate(t, fed, "https://rp.example.org")
+
+	hints := []string{"https://other.example.org"}
+	r := NewEntityRevalidator(
+		fed, RevalidationConfig{
+			Subordinates: RevalidationPolicy{
+				Enabled: true,
+				Action:  RevalidationActionPending,
+			},
+			RequireAuthorityHint: true,
+		},
+	)
+	r.fetch = stubFetch(&hints)
+
+	summary := r.RunOnce(context.Background())
+	assert.Equal(t, RevalidationSummary{Checked: 1, Failed: 1, Enforced: 1}, summary)
+
+	updated, err := fed.storages.Subordinates.Get(info.EntityID)
+	require.NoError(t, err)
+	assert.Equal(t, model.StatusPending, updated.Status)
+	assert.Equal(
+		t, []string{model.EventTypeRevalidationEnforced, model.EventTypeRevalidationFailed},
+		subordinateEventTypes(t, fed, info.ID),
+	)
+
+	// Pending subordinates are no longer re-validated.
+	summary = r.RunOnce(context.Background())
+	assert.Equal(t, RevalidationSummary{}, summary)
+}
+
+func TestEntityRevalidator_Subordinate_GracePeriodAndRecovery(t *testing.T) {
+	fed, _ := newTestLightHouse(t)
+	info := addActiveSubordinate(t, fed, "https://rp.example.org")
+
+	hints := []string{"https://other.example.org"}
+	r := NewEntityRevalidator(
+		fed, RevalidationConfig{
+			Subordinates: RevalidationPolicy{
+				Enabled:     true,
+				Action:      RevalidationActionInactive,
+				GracePeriod: time.Hour,
+			},
+			RequireAuthorityHint: true,
+		},
+	)
+	r.fetch = stubFetch(&hints)
+
+	summary := r.RunOnce(context.Background())
+	assert.Equal(t, RevalidationSummary{Checked: 1, Failed: 1}, summary)
+	updated, err := fed.storages.Subordinates.Get(info.EntityID)
+	require.NoError(t, err)
+	assert.Equal(t, model.StatusActive, updated.Status)
+
+	// A repeated failure with the same reason within the grace period does
+	// not record another event.
+	r.RunOnce(context.Background())
+	assert.Equal(t, []string{model.EventTypeRevalidationFailed}, subordinateEventTypes(t, fed, info.ID))
+
+	hints = []string{testLighthouseID}
+	summary = r.RunOnce(context.Background())
+	assert.Equal(t, RevalidationSummary{Checked: 1, Recovered: 1}, summary)
+	assert.Equal(
+		t, []string{model.EventTypeRevalidationRecovered, model.EventTypeRevalidationFailed},
+		subordinateEventTypes(t, fed, info.ID),
+	)
+
+	var state revalidationState
+	found, err := fed.storages.KV.GetAs(
+		model.KeyValueScopeRevalidation, "subordinate:"+strconv.FormatUint(uint64(info.ID), 10), &state,
+	)
+	require.NoError(t, err)
+	assert.False(t, found)
+}
+
+func TestEntityRevalidator_Subordinate_EnforcedAfterGracePeriod(t *testing.T) {
+	fed, _ := newTestLightHouse(t)
+	info := addActiveSubordinate(t, fed, "https://rp.example.org")
+
+	hints := []string{}
+	r := NewEntityRevalidator(
+		fed, RevalidationConfig{
+			Subordinates: RevalidationPolicy{
+				Enabled:     true,
+				Action:      RevalidationActionInactive,
+				GracePeriod: time.Hour,
+			},
+			RequireAuthorityHint: true,
+		},
+	)
+	r.fetch = stubFetch(&hints)
+
+	r.RunOnce(context.Background())
+
+	// Simulate that the subordinate has been failing for longer than the
+	// grace period.
+	key := "subordinate:" + strconv.FormatUint(uint64(info.ID), 10)
+	var state revalidationState
+	found, err := fed.storages.KV.GetAs(model.KeyValueScopeRevalidation, key, &state)
+	require.NoError(t, err)
+	require.True(t, found)
+	state.FailingSince = time.Now().Add(-2 * time.Hour).Unix()
+	require.NoError(t, fed.storages.KV.SetAny(model.KeyValueScopeRevalidation, key, state))
+
+	summary := r.RunOnce(context.Background())
+	assert.Equal(t, RevalidationSummary{Checked: 1, Failed: 1, Enforced: 1}, summary)
+	updated, err := fed.storages.Subordinates.Get(info.EntityID)
+	require.NoError(t, err)
+	assert.Equal(t, model.StatusInactive, updated.Status)
+}
+
+func TestEntityRevalidator_Subordinate_EnrollChecker(t *testing.T) {
+	fed, _ := newTestLightHouse(t)
+	allowed := addActiveSubordinate(t, fed, "https://allowed.example.org")
+	denied := addActiveSubordinate(t, fed, "https://denied.example.org")
+
+	_, err := fed.storages.FederationEndpoints.Create(
+		model.AddFederationEndpoint{
+			Type:   model.EndpointTypeEnroll,
+			Path:   new("/enroll"),
+			Config: `{"checker_type":"entity_id","checker_config":{"entity_ids":["https://allowed.example.org"]}}`,
+		},
+	)
+	require.NoError(t, err)
+
+	hints := []string{testLighthouseID}
+	r := NewEntityRevalidator(
+		fed, RevalidationConfig{
+			Subordinates: RevalidationPolicy{
+				Enabled: true,
+				Action:  RevalidationActionAlert,
+			},
+		},
+	)
+	r.fetch = stubFetch(&hints)
+
+	summary := r.RunOnce(context.Background())
+	assert.Equal(t, RevalidationSummary{Checked: 2, Failed: 1}, summary)
+	assert.Empty(t, subordinateEventTypes(t, fed, allowed.ID))
+	assert.Equal(t, []string{model.EventTypeRevalidationFailed}, subordinateEventTypes(t, fed, denied.ID))
+
+	// The alert action never changes the status.
+	updated, err := fed.storages.Subordinates.Get(denied.EntityID)
+	require.NoError(t, err)
+	assert.Equal(t, model.StatusActive, updated.Status)
+}
+
+func TestEntityRevalidator_TrustMarkSubject_Revoke(t *testing.T) {
+	fed, _ := newTestLightHouse(t)
+	const trustMarkType = "https://tm.example.org/type"
+
+	_, err := fed.storages.TrustMarkSpecs.Create(
+		&model.AddTrustMarkSpec{
+			TrustMarkType: trustMarkType,
+			EligibilityConfig: &model.EligibilityConfig{
+				Mode: model.EligibilityModeCheckOnly,
+				Checker: &model.CheckerConfig{
+					Type:   "authority_hints",
+					Config: map[string]any{"entity_id": testLighthouseID},
+				},
+			},
+		},
+	)
+	require.NoError(t, err)
+	subject, err := fed.storages.TrustMarkSpecs.CreateSubject(
+		trustMarkType, &model.AddTrustMarkSubject{
+			EntityID: "https://rp.example.org",
+			Status:   model.StatusActive,
+		},
+	)
+	require.NoError(t, err)
+	require.NoError(
+		t, fed.storages.TrustMarkInstances.Create(
+			&model.IssuedTrustMarkInstance{
+				JTI:                "jti-1",
+				ExpiresAt:          int(time.Now().Add(time.Hour).Unix()),
+				TrustMarkSubjectID: subject.ID,
+				TrustMarkType:      trustMarkType,
+			},
+		),
+	)
+
+	hints := []string{}
+	r := NewEntityRevalidator(
+		fed, RevalidationConfig{
+			TrustMarkSubjects: RevalidationPolicy{
+				Enabled: true,
+				Action:  RevalidationActionRevoke,
+			},
+		},
+	)
+	r.fetch = stubFetch(&hints)
+
+	summary := r.RunOnce(context.Background())
+	assert.Equal(t, RevalidationSummary{Checked: 1, Failed: 1, Enforced: 1}, summary)
+
+	status, err := fed.storages.TrustMarkInstances.GetStatus("jti-1")
+	require.NoError(t, err)
+	assert.Equal(t, model.TrustMarkStatusRevoked, status)
+
+	// The subject stays active; the action is only applied once.
+	summary = r.RunOnce(context.Background())
+	assert.Equal(t, RevalidationSummary{Checked: 1, Failed: 1}, summary)
+
+	events, total, err := fed.storages.TrustMarkSubjectEvents.GetBySubjectID(subject.ID, model.EventQueryOpts{})
+	require.NoError(t, err)
+	assert.EqualValues(t, 2, total)
+	assert.Equal(t, model.EventTypeRevalidationEnforced, events[0].Type)
+	require.NotNil(t, events[0].Actor)
+	assert.Equal(t, "revalidation", *events[0].Actor)
+}
@@ -0,0 +1,554 @@
+package lighthouse
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-oidfed/lib/oidfedconst"
+	"github.com/gofiber/fiber/v2"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+
+	"github.com/go-oidfed/lighthouse/api/adminapi"
+	"github.com/go-oidfed/lighthouse/internal"
+	"github.com/go-oidfed/lighthouse/storage/model"
+)
+
+// StaticExportManifest is the name of the manifest file in the export
+// directory. It lists the URL and content type of every exported file.
+const StaticExportManifest = "_manifest.json"
+
+// staticExportDebounce is the time invalidations are collected before the
+// affected responses are rendered again.
+const staticExportDebounce = time.Second
+
+// StaticExportConfig configures the StaticExporter.
+type StaticExportConfig struct {
+	// Dir is the directory the federation responses are rendered into.
+	Dir string
+	// Interval is the time between two full renderings, so that signed
+	// statements are renewed before they expire.
+	Interval time.Duration
+}
+
+// StaticExporter renders the federation responses of LightHouse into a
+// directory tree of static files that mirrors their URL layout, so that they
+// can be published from a CDN. Responses are rendered again whenever their
+// cached version is invalidated, and all responses are rendered periodically.
+type StaticExporter struct {
+	fed  *LightHouse
+	conf StaticExportConfig
+	app  *fiber.App
+
+	mu     sync.Mutex
+	files  map[string]adminapi.StaticExportFile
+	layout string
+	last   *adminapi.StaticExportResult
+
+	pendingMu sync.Mutex
+	pending   staticExportChanges
+	changed   chan struct{}
+
+	removeListener func()
+	runner         periodicRunner
+}
+
+// staticExportChanges collects the invalidations since the last rendering.
+type staticExportChanges struct {
+	entityConfiguration bool
+	historicalKeys      bool
+	allSubordinates     bool
+	subordinates        map[string]struct{}
+}
+
+// staticEndpoint is an exported federation endpoint.
+type staticEndpoint struct {
+	// path is the internal path the endpoint is served at.
+	path string
+	// url is the external URL the endpoint is published at.
+	url *url.URL
+}
+
+// staticTarget is a federation response that is rendered into a file.
+type staticTarget struct {
+	// request is the internal path and query the response is rendered from.
+	request string
+	url     string
+	file    string
+}
+
+// target returns the staticTarget for a request to the endpoint. Responses to
+// a request with a query parameter are stored in a directory named after the
+// endpoint, in a file named after the query escaped parameter value.
+func (ep staticEndpoint) target(param, value string) staticTarget {
+	t := staticTarget{
+		request: ep.path,
+		url:     ep.url.String(),
+		file:    strings.Trim(ep.url.Path, "/"),
+	}
+	if param != "" {
+		query := url.Values{param: {value}}.Encode()
+		t.request += "?" + query
+		t.url += "?" + query
+		t.file = path.Join(t.file, url.QueryEscape(value))
+	}
+	return t
+}
+
+// NewStaticExporter creates a new StaticExporter for the passed LightHouse.
+// The files of a previous export are taken over from its manifest, so that
+// they are removed if they are not rendered anymore.
+func NewStaticExporter(fed *LightHouse, conf StaticExportConfig) *StaticExporter {
+	if conf.Interval <= 0 {
+		conf.Interval = time.Hour
+	}
+	app := fiber.New(fiber.Config{ErrorHandler: handleError})
+	app.Get(oidfedconst.FederationSuffix, fed.entityConfigurationHandler)
+	app.All("/*", fed.dispatch)
+	e := &StaticExporter{
+		fed:     fed,
+		conf:    conf,
+		app:     app,
+		files:   make(map[string]adminapi.StaticExportFile),
+		changed: make(chan struct{}, 1),
+	}
+	if content, err := os.ReadFile(filepath.Join(conf.Dir, StaticExportManifest)); err == nil {
+		var manifest adminapi.StaticExportResult
+		if err = json.Unmarshal(content, &manifest); err != nil {
+			log.Warn().Err(err).Msg("failed to parse static export manifest")
+		}
+		for _, f := range manifest.Files {
+			e.files[f.Path] = f
+		}
+	}
+	return e
+}
+
+// Start renders all responses in the background and then keeps the export up
+// to date until Stop is called.
+func (e *StaticExporter) Start() {
+	e.removeListener = internal.OnCacheInvalidation(e.invalidated)
+	e.runner.start(
+		periodicTask{
+			interval:   e.conf.Interval,
+			runAtStart: true,
+			run:        func(context.Context) { e.RunOnce() },
+			trigger:    e.changed,
+			onTrigger: func(ctx context.Context) {
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(staticExportDebounce):
+				}
+				e.runChanges()
+			},
+		},
+	)
+	log.Info().Str("dir", e.conf.Dir).Dur("interval", e.conf.Interval).Msg("static export started")
+}
+
+// Stop stops the static export and waits for a running rendering to finish.
+func (e *StaticExporter) Stop() {
+	if !e.runner.running() {
+		return
+	}
+	e.removeListener()
+	e.runner.stop()
+}
+
+// Last returns the result of the latest rendering, or nil if nothing was
+// rendered yet.
+func (e *StaticExporter) Last() *adminapi.StaticExportResult {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.last
+}
+
+// RunOnce renders all federation responses, removes the files of responses
+// that are no longer published, and returns the result. Concurrent calls are
+// serialized.
+func (e *StaticExporter) RunOnce() *adminapi.StaticExportResult {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.renderAll()
+}
+
+// invalidated is the internal.CacheInvalidationListener of the
+// StaticExporter; it records which responses changed.
+func (e *StaticExporter) invalidated(key string, prefix bool) {
+	e.pendingMu.Lock()
+	switch {
+	case key == internal.CacheKeyEntityConfiguration:
+		e.pending.entityConfiguration = true
+	case strings.HasPrefix(key, internal.CacheKeyHistoricalKeys):
+		e.pending.historicalKeys = true
+	case prefix && key == internal.CacheKeySubordinateStatement:
+		e.pending.allSubordinates = true
+	default:
+		sub, ok := internal.SubordinateFromCacheKey(key)
+		if !ok {
+			e.pendingMu.Unlock()
+			return
+		}
+		if e.pending.subordinates == nil {
+			e.pending.subordinates = make(map[string]struct{})
+		}
+		e.pending.subordinates[sub] = struct{}{}
+	}
+	e.pendingMu.Unlock()
+	select {
+	case e.changed <- struct{}{}:
+	default:
+	}
+}
+
+// runChanges renders the responses that changed since the last rendering.
+func (e *StaticExporter) runChanges() *adminapi.StaticExportResult {
+	e.pendingMu.Lock()
+	changes := e.pending
+	e.pending = staticExportChanges{}
+	e.pendingMu.Unlock()
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.renderChanges(changes)
+}
+
+// endpoints returns the federation endpoints that can be exported. Endpoints
+// that require client authentication cannot be served statically and are
+// skipped.
+func (e *StaticExporter) endpoints() map[model.FederationEndpointType]staticEndpoint {
+	fed := e.fed
+	endpoints := make(map[model.FederationEndpointType]staticEndpoint)
+	if fed.endpointRegistry == nil {
+		return endpoints
+	}
+	externalURLs := map[model.FederationEndpointType]string{
+		model.EndpointTypeFetch:            fed.fedMetadata.FederationFetchEndpoint,
+		model.EndpointTypeList:             fed.fedMetadata.FederationListEndpoint,
+		model.EndpointTypeTrustMarkListing: fed.fedMetadata.FederationTrustMarkListEndpoint,
+		model.EndpointTypeHistoricalKeys:   fed.fedMetadata.FederationHistoricalLKeysEndpoint,
+	}
+	for t, externalURL := range externalURLs {
+		ep := fed.endpointRegistry.lookupByType(t)
+		if ep == nil || externalURL == "" {
+			continue
+		}
+		if ep.Method != fiber.MethodGet {
+			log.Debug().Str("endpoint", string(t)).Msg("skipping endpoint with client authentication in static export")
+			continue
+		}
+		u, err := url.Parse(externalURL)
+		if err != nil {
+			log.Warn().Err(err).Str("endpoint", string(t)).Msg("skipping endpoint with invalid URL in static export")
+			continue
+		}
+		endpoints[t] = staticEndpoint{
+			path: ep.Path,
+			url:  u,
+		}
+	}
+	return endpoints
+}
+
+// staticExportLayout identifies the URL layout of the exported endpoints, so
+// that a changed layout triggers a full rendering.
+func staticExportLayout(endpoints map[model.FederationEndpointType]staticEndpoint) string {
+	layout := make([]string, 0, len(endpoints))
+	for t, ep := range endpoints {
+		layout = append(layout, fmt.Sprintf("%s %s %s", t, ep.path, ep.url))
+	}
+	sort.Strings(layout)
+	return strings.Join(layout, "\n")
+}
+
+func (e *StaticExporter) entityConfigurationTarget() (staticTarget, error) {
+	u, err := url.Parse(e.fed.FederationEntity.EntityID())
+	if err != nil {
+		return staticTarget{}, errors.Wrap(err, "invalid entity id")
+	}
+	return staticEndpoint{
+		path: oidfedconst.FederationSuffix,
+		url:  u.JoinPath(oidfedconst.FederationSuffix),
+	}.target("", ""), nil
+}
+
+// subordinateTargets returns the fetch endpoint responses of all active
+// subordinates.
+func (e *StaticExporter) subordinateTargets(fetch staticEndpoint) ([]staticTarget, error) {
+	if e.fed.storages.Subordinates == nil {
+		return nil, nil
+	}
+	subordinates, err := e.fed.storages.Subordinates.GetByStatus(model.StatusActive)
+	if err != nil {
+		return nil, err
+	}
+	targets := make([]staticTarget, len(subordinates))
+	for i, sub := range subordinates {
+		targets[i] = fetch.target("sub", sub.EntityID)
+	}
+	return targets, nil
+}
+
+// renderAll renders all federation responses.
+func (e *StaticExporter) renderAll() *adminapi.StaticExportResult {
+	res := &adminapi.StaticExportResult{}
+	endpoints := e.endpoints()
+	var targets []staticTarget
+	complete := true
+
+	ec, err := e.entityConfigurationTarget()
+	if err != nil {
+		res.Errors = append(res.Errors, err.Error())
+		complete = false
+	} else {
+		targets = append(targets, ec)
+	}
+	if ep, ok := endpoints[model.EndpointTypeList]; ok {
+		targets = append(targets, ep.target("", ""))
+	}
+	if ep, ok := endpoints[model.EndpointTypeHistoricalKeys]; ok {
+		targets = append(targets, ep.target("", ""))
+	}
+	if ep, ok := endpoints[model.EndpointTypeTrustMarkListing]; ok && e.fed.TrustMarkIssuer != nil {
+		for _, trustMarkType := range e.fed.TrustMarkIssuer.TrustMarkTypes() {
+			targets = append(targets, ep.target("trust_mark_type", trustMarkType))
+		}
+	}
+	if ep, ok := endpoints[model.EndpointTypeFetch]; ok {
+		subordinates, err := e.subordinateTargets(ep)
+		if err != nil {
+			res.Errors = append(res.Errors, errors.Wrap(err, "failed to list subordinates").Error())
+			complete = false
+		}
+		targets = append(targets, subordinates...)
+	}
+
+	previous := e.files
+	e.files = make(map[string]adminapi.StaticExportFile, len(previous))
+	e.render(res, targets)
+	for p, f := range previous {
+		if _, ok := e.files[p]; ok {
+			continue
+		}
+		if !complete {
+			// Without the full list of responses, stale files cannot be
+			// told apart from files that were not rendered.
+			e.files[p] = f
+			continue
+		}
+		if e.remove(p) {
+			res.Removed++
+		}
+	}
+	e.layout = staticExportLayout(endpoints)
+	return e.finish(res)
+}
+
+// renderChanges renders the responses affected by the passed changes. If the
+// URL layout of the endpoints changed, everything is rendered again.
+func (e *StaticExporter) renderChanges(changes staticExportChanges) *adminapi.StaticExportResult {
+	endpoints := e.endpoints()
+	if changes.allSubordinates || staticExportLayout(endpoints) != e.layout {
+		return e.renderAll()
+	}
+	res := &adminapi.StaticExportResult{}
+	var targets []staticTarget
+	if changes.entityConfiguration {
+		ec, err := e.entityConfigurationTarget()
+		if err != nil {
+			res.Errors = append(res.Errors, err.Error())
+		} else {
+			targets = append(targets, ec)
+		}
+	}
+	if ep, ok := endpoints[model.EndpointTypeHistoricalKeys]; ok &&
+		(changes.entityConfiguration || changes.historicalKeys) {
+		// Key changes invalidate the entity configuration
+		targets = append(targets, ep.target("", ""))
+	}
+	if len(changes.subordinates) > 0 {
+		if ep, ok := endpoints[model.EndpointTypeList]; ok {
+			targets = append(targets, ep.target("", ""))
+		}
+		if ep, ok := endpoints[model.EndpointTypeFetch]; ok {
+			for sub := range changes.subordinates {
+				t := ep.target("sub", sub)
+				if e.isActiveSubordinate(sub) {
+					targets = append(targets, t)
+				} else if e.remove(t.file) {
+					res.Removed++
+				}
+			}
+		}
+	}
+	e.render(res, targets)
+	return e.finish(res)
+}
+
+func (e *StaticExporter) isActiveSubordinate(entityID string) bool {
+	if e.fed.storages.Subordinates == nil {
+		return false
+	}
+	info, err := e.fed.storages.Subordinates.Get(entityID)
+	if err != nil {
+		log.Warn().Err(err).Str("entity_id", entityID).Msg("failed to load subordinate for static export")
+		return false
+	}
+	return info != nil && info.Status == model.StatusActive
+}
+
+// render renders the targets into their files. Files of responses that are
+// not found are removed.
+func (e *StaticExporter) render(res *adminapi.StaticExportResult, targets []staticTarget) {
+	for _, t := range targets {
+		f, err := e.renderTarget(t)
+		if err != nil {
+			res.Errors = append(res.Errors, err.Error())
+			continue
+		}
+		if f == nil {
+			if e.remove(t.file) {
+				res.Removed++
+			}
+			continue
+		}
+		e.files[f.Path] = *f
+		res.Rendered++
+	}
+}
+
+// renderTarget renders a single response into its file. It returns nil if
+// the response was not found.
+func (e *StaticExporter) renderTarget(t staticTarget) (*adminapi.StaticExportFile, error) {
+	if t.file == "" || !filepath.IsLocal(filepath.FromSlash(t.file)) {
+		return nil, errors.Errorf("'%s' cannot be exported into a file", t.url)
+	}
+	resp, err := e.app.Test(httptest.NewRequest(fiber.MethodGet, t.request, nil), -1)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to render '%s'", t.url)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to render '%s'", t.url)
+	}
+	switch resp.StatusCode {
+	case fiber.StatusOK:
+	case fiber.StatusNotFound:
+		return nil, nil
+	default:
+		return nil, errors.Errorf("rendering '%s' failed with status %d: %s", t.url, resp.StatusCode, body)
+	}
+	if err = writeFileIfChanged(filepath.Join(e.conf.Dir, filepath.FromSlash(t.file)), body); err != nil {
+		return nil, err
+	}
+	return &adminapi.StaticExportFile{
+		URL:         t.url,
+		Path:        t.file,
+		ContentType: resp.Header.Get(fiber.HeaderContentType),
+	}, nil
+}
+
+// remove removes an exported file; it returns true if the file existed.
+func (e *StaticExporter) remove(file string) bool {
+	delete(e.files, file)
+	if !filepath.IsLocal(filepath.FromSlash(file)) {
+		return false
+	}
+	err := os.Remove(filepath.Join(e.conf.Dir, filepath.FromSlash(file)))
+	if err != nil && !os.IsNotExist(err) {
+		log.Warn().Err(err).Str("file", file).Msg("failed to remove static export file")
+	}
+	return err == nil
+}
+
+// finish completes the result, writes the manifest and stores the result as
+// the latest one.
+func (e *StaticExporter) finish(res *adminapi.StaticExportResult) *adminapi.StaticExportResult {
+	res.Dir = e.conf.Dir
+	res.RenderedAt = time.Now().Unix()
+	res.Files = make([]adminapi.StaticExportFile, 0, len(e.files))
+	for _, f := range e.files {
+		res.Files = append(res.Files, f)
+	}
+	sort.Slice(res.Files, func(i, j int) bool { return res.Files[i].Path < res.Files[j].Path })
+
+	manifest, err := json.MarshalIndent(res, "", "  ")
+	if err == nil {
+		err = writeFileIfChanged(filepath.Join(e.conf.Dir, StaticExportManifest), manifest)
+	}
+	if err != nil {
+		res.Errors = append(res.Errors, errors.Wrap(err, "failed to write manifest").Error())
+	}
+	for _, msg := range res.Errors {
+		log.Warn().Str("error", msg).Msg("static export")
+	}
+	log.Debug().Int("rendered", res.Rendered).Int("removed", res.Removed).Msg("static export rendered")
+	e.last = res
+	return res
+}
+
+// writeFileIfChanged atomically replaces the content of a file, unless it is
+// unchanged.
+func writeFileIfChanged(name string, data []byte) error {
+	if current, err := os.ReadFile(name); err == nil && bytes.Equal(current, data) {
+		return nil
+	}
+	dir := filepath.Dir(name)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return errors.WithStack(err)
+	}
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(name)+".*")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return errors.WithStack(err)
+	}
+	if err = tmp.Close(); err != nil {
+		return errors.WithStack(err)
+	}
+	if err = os.Chmod(tmp.Name(), 0644); err != nil {
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(os.Rename(tmp.Name(), name))
+}
+
+// StartStaticExport creates and starts a StaticExporter. It is stopped with
+// Stop.
+func (fed *LightHouse) StartStaticExport(conf StaticExportConfig) *StaticExporter {
+	if fed.staticExporter != nil {
+		fed.staticExporter.Stop()
+	}
+	fed.staticExporter = NewStaticExporter(fed, conf)
+	fed.staticExporter.Start()
+	return fed.staticExporter
+}
+
+// StaticExport implements the adminapi.LighthouseController interface.
+func (fed *LightHouse) StaticExport(render bool) (*adminapi.StaticExportResult, error) {
+	if fed.staticExporter == nil {
+		return nil, adminapi.ErrStaticExportDisabled
+	}
+	if !render {
+		if last := fed.staticExporter.Last(); last != nil {
+			return last, nil
+		}
+	}
+	return fed.staticExporter.RunOnce(), nil
+}
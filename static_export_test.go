@@ -0,0 +1,117 @@
+package lighthouse
+
+import (
+	"encoding/json"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	oidfed "github.com/go-oidfed/lib"
+	"github.com/go-oidfed/lib/oidfedconst"
+	"github.com/go-oidfed/lib/unixtime"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/go-oidfed/lighthouse/api/adminapi"
+	"github.com/go-oidfed/lighthouse/internal"
+	"github.com/go-oidfed/lighthouse/storage/model"
+)
+
+// staticExportTestEntity "signs" statements by prefixing their subject.
+type staticExportTestEntity struct {
+	stubFedEntity
+}
+
+func (staticExportTestEntity) EntityID() string { return "https://static-export.example.org" }
+func (e staticExportTestEntity) EntityConfigurationPayload() (*oidfed.EntityStatementPayload, error) {
+	now := time.Now()
+	return &oidfed.EntityStatementPayload{
+		Issuer:    e.EntityID(),
+		Subject:   e.EntityID(),
+		IssuedAt:  unixtime.Unixtime{Time: now},
+		ExpiresAt: unixtime.Unixtime{Time: now.Add(time.Hour)},
+	}, nil
+}
+func (staticExportTestEntity) SignEntityStatement(payload oidfed.EntityStatementPayload) ([]byte, error) {
+	return []byte("signed:" + payload.Subject), nil
+}
+
+func readStaticExportFile(t *testing.T, dir, file string) string {
+	t.Helper()
+	content, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(file)))
+	require.NoError(t, err)
+	return string(content)
+}
+
+func staticExportFilesByPath(res *adminapi.StaticExportResult) map[string]adminapi.StaticExportFile {
+	files := make(map[string]adminapi.StaticExportFile, len(res.Files))
+	for _, f := range res.Files {
+		files[f.Path] = f
+	}
+	return files
+}
+
+func TestStaticExporter(t *testing.T) {
+	fed, _ := newTestLightHouse(t)
+	fed.FederationEntity = staticExportTestEntity{}
+	require.NoError(t, fed.AddFetchEndpoint(EndpointConf{Path: "/fetch"}, fed.storages.Subordinates))
+	require.NoError(
+		t, fed.AddSubordinateListingEndpoint(
+			EndpointConf{Path: "/list"}, fed.storages.Subordinates, fed.storages.TrustMarks,
+		),
+	)
+	_ = internal.DeleteCache(internal.CacheKeyEntityConfiguration)
+	_ = internal.ClearCache(internal.CacheKeySubordinateStatement)
+	rp1 := "https://rp1.static-export.example.org"
+	rp2 := "https://rp2.static-export.example.org"
+	addActiveSubordinate(t, fed, rp1)
+	addActiveSubordinate(t, fed, rp2)
+
+	dir := t.TempDir()
+	exporter := NewStaticExporter(fed, StaticExportConfig{Dir: dir})
+	res := exporter.RunOnce()
+	require.Empty(t, res.Errors)
+	assert.Equal(t, 4, res.Rendered)
+
+	assert.Equal(t, "signed:https://static-export.example.org", readStaticExportFile(t, dir, ".well-known/openid-federation"))
+	assert.Equal(t, "signed:"+rp1, readStaticExportFile(t, dir, "fetch/"+url.QueryEscape(rp1)))
+	var list []string
+	require.NoError(t, json.Unmarshal([]byte(readStaticExportFile(t, dir, "list")), &list))
+	assert.ElementsMatch(t, []string{rp1, rp2}, list)
+
+	files := staticExportFilesByPath(res)
+	assert.Equal(t, oidfedconst.ContentTypeEntityStatement, files[".well-known/openid-federation"].ContentType)
+	assert.Equal(
+		t, "https://static-export.example.org/fetch?sub="+url.QueryEscape(rp2),
+		files["fetch/"+url.QueryEscape(rp2)].URL,
+	)
+	assert.Contains(t, files["list"].ContentType, "application/json")
+	var manifest adminapi.StaticExportResult
+	require.NoError(t, json.Unmarshal([]byte(readStaticExportFile(t, dir, StaticExportManifest)), &manifest))
+	assert.Equal(t, res.Files, manifest.Files)
+
+	// A removed subordinate is removed incrementally after its cached
+	// statement was invalidated
+	removeListener := internal.OnCacheInvalidation(exporter.invalidated)
+	defer removeListener()
+	require.NoError(t, fed.storages.Subordinates.Delete(rp2))
+	_ = internal.DeleteCache(internal.SubordinateStatementCacheKey(rp2))
+	res = exporter.runChanges()
+	require.Empty(t, res.Errors)
+	assert.Equal(t, 1, res.Rendered)
+	assert.Equal(t, 1, res.Removed)
+	assert.NoFileExists(t, filepath.Join(dir, "fetch", url.QueryEscape(rp2)))
+	require.NoError(t, json.Unmarshal([]byte(readStaticExportFile(t, dir, "list")), &list))
+	assert.Equal(t, []string{rp1}, list)
+
+	// Files of endpoints that are no longer published are removed, also by
+	// a new exporter that takes them over from the manifest
+	fed.unregisterEndpoint(model.EndpointTypeFetch)
+	res = NewStaticExporter(fed, StaticExportConfig{Dir: dir}).RunOnce()
+	require.Empty(t, res.Errors)
+	assert.Equal(t, 1, res.Removed)
+	assert.NoFileExists(t, filepath.Join(dir, "fetch", url.QueryEscape(rp1)))
+	assert.Len(t, res.Files, 2)
+}
@@ -223,21 +223,26 @@ func (s *Storage) backendsWithDB(db *gorm.DB, withTransaction bool, jtiType JTIS
 		return model.Backends{}, err
 	}
 	backends := model.Backends{
-		DB:                  db,
-		Subordinates:        &SubordinateStorage{db: db},
-		SubordinateEvents:   NewSubordinateEventsStorage(db),
-		TrustMarks:          &TrustMarkedEntitiesStorage{db: db},
-		TrustMarkSpecs:      &TrustMarkSpecStorage{db: db},
-		TrustMarkInstances:  NewIssuedTrustMarkInstanceStorage(db),
-		AuthorityHints:      &AuthorityHintsStorage{db: db},
-		TrustMarkTypes:      &TrustMarkTypesStorage{db: db},
-		TrustMarkOwners:     &TrustMarkOwnersStorage{db: db},
-		TrustMarkIssuers:    &TrustMarkIssuersStorage{db: db},
-		AdditionalClaims:    &AdditionalClaimsStorage{db: db},
-		PublishedTrustMarks: &PublishedTrustMarksStorage{db: db},
-		TrustAnchors:        NewTrustAnchorStorage(db),
-		FederationEndpoints: NewFederationEndpointStorage(db),
-		KV:                  &KeyValueStorage{db: db},
+		DB:                     db,
+		Subordinates:           &SubordinateStorage{db: db},
+		SubordinateEvents:      NewSubordinateEventsStorage(db),
+		TrustMarks:             &TrustMarkedEntitiesStorage{db: db},
+		TrustMarkSpecs:         &TrustMarkSpecStorage{db: db},
+		TrustMarkInstances:     NewIssuedTrustMarkInstanceStorage(db),
+		TrustMarkSubjectEvents: NewTrustMarkSubjectEventsStorage(db),
+		TrustMarkRequests:      NewTrustMarkRequestsStorage(db),
+		TrustMarkReports:       NewTrustMarkReportsStorage(db),
+		AuthorityHints:         &AuthorityHintsStorage{db: db},
+		TrustMarkTypes:         &TrustMarkTypesStorage{db: db},
+		TrustMarkOwners:        &TrustMarkOwnersStorage{db: db},
+		TrustMarkIssuers:       &TrustMarkIssuersStorage{db: db},
+		AdditionalClaims:       &AdditionalClaimsStorage{db: db},
+		PublishedTrustMarks:    &PublishedTrustMarksStorage{db: db},
+		TrustAnchors:           NewTrustAnchorStorage(db),
+		FederationEndpoints:    NewFederationEndpointStorage(db),
+		MetadataSchemas:        NewMetadataSchemasStorage(db),
+		Logos:                  NewLogosStorage(db),
+		KV:                     &KeyValueStorage{db: db},
 		Users: &UsersStorage{
 			db:     db,
 			params: s.userParams,
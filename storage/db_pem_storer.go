@@ -1,18 +1,28 @@
 package storage
 
 import (
+	"sync"
+
 	"github.com/go-oidfed/lib/jwx/keymanagement/public"
 	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
 	"gorm.io/gorm"
 	"gorm.io/gorm/clause"
 
+	"github.com/go-oidfed/lighthouse/storage/envelope"
 	"github.com/go-oidfed/lighthouse/storage/model"
 )
 
 // DBPEMStorer implements kms.PEMStorer using a database backend.
+// If a envelope.Sealer is set, private keys are stored encrypted; keys that
+// were stored unencrypted before can still be read, unless encryption is
+// required.
 type DBPEMStorer struct {
-	db  *gorm.DB
-	tbl string
+	db                *gorm.DB
+	tbl               string
+	sealer            *envelope.Sealer
+	requireEncryption bool
+	warnedUnsealed    sync.Map
 }
 
 // NewDBPEMStorer creates a new DBPEMStorer.
@@ -32,6 +42,34 @@ func NewDBPEMStorer(db *gorm.DB, typeID string) *DBPEMStorer {
 	return d
 }
 
+// NewEncryptedDBPEMStorer creates a new DBPEMStorer that encrypts private keys
+// with the passed envelope.Sealer.
+func NewEncryptedDBPEMStorer(db *gorm.DB, typeID string, sealer *envelope.Sealer) *DBPEMStorer {
+	d := NewDBPEMStorer(db, typeID)
+	d.sealer = sealer
+	return d
+}
+
+// RequireEncryption makes ReadPEM reject private keys that are stored
+// unencrypted. It has no effect without an envelope.Sealer.
+func (d *DBPEMStorer) RequireEncryption() {
+	d.requireEncryption = true
+}
+
+// additionalData binds an encrypted private key to its table and kid, so it
+// cannot be moved to another row.
+func (d *DBPEMStorer) additionalData(kid string) []byte {
+	return []byte(d.tbl + "/" + kid)
+}
+
+// Close releases the master keys of the envelope.Sealer, if one is set.
+func (d *DBPEMStorer) Close() error {
+	if d.sealer == nil {
+		return nil
+	}
+	return d.sealer.Close()
+}
+
 // Load initializes the database table.
 func (d *DBPEMStorer) Load() error {
 	return d.db.Table(d.tbl).AutoMigrate(&model.PrivateKeyEntry{})
@@ -47,11 +85,36 @@ func (d *DBPEMStorer) ReadPEM(kid string) ([]byte, error) {
 		}
 		return nil, errors.WithStack(err)
 	}
-	return []byte(row.PEMData), nil
+	if !envelope.IsSealed(row.PEMData) {
+		if d.sealer == nil {
+			return []byte(row.PEMData), nil
+		}
+		if d.requireEncryption {
+			return nil, errors.Errorf(
+				"private key '%s' is not encrypted; run 'lhmigrate encrypt-keys' to encrypt it", kid,
+			)
+		}
+		if _, warned := d.warnedUnsealed.LoadOrStore(kid, true); !warned {
+			log.Warn().Str("table", d.tbl).Str("kid", kid).
+				Msg("using unencrypted private key; run 'lhmigrate encrypt-keys' to encrypt it")
+		}
+		return []byte(row.PEMData), nil
+	}
+	if d.sealer == nil {
+		return nil, errors.Errorf("private key '%s' is encrypted, but no master key is configured", kid)
+	}
+	data, err := d.sealer.Open(row.PEMData, d.additionalData(kid))
+	return data, errors.Wrapf(err, "failed to decrypt private key '%s'", kid)
 }
 
 // WritePEM stores or updates a PEM-encoded private key by KID.
 func (d *DBPEMStorer) WritePEM(kid string, data []byte) error {
+	if d.sealer != nil {
+		var err error
+		if data, err = d.sealer.Seal(data, d.additionalData(kid)); err != nil {
+			return errors.Wrapf(err, "failed to encrypt private key '%s'", kid)
+		}
+	}
 	entry := model.PrivateKeyEntry{
 		KID:     kid,
 		PEMData: model.PEMData(data),
@@ -81,6 +144,7 @@ func (d *DBPEMStorer) Delete(kid string) error {
 }
 
 // GetAll returns all stored private keys (for debugging/migration purposes).
+// Encrypted keys are returned as stored.
 func (d *DBPEMStorer) GetAll() ([]model.PrivateKeyEntry, error) {
 	var rows []model.PrivateKeyEntry
 	err := errors.WithStack(d.db.Session(&gorm.Session{NewDB: true}).Table(d.tbl).Find(&rows).Error)
@@ -89,3 +153,68 @@ func (d *DBPEMStorer) GetAll() ([]model.PrivateKeyEntry, error) {
 	}
 	return rows, err
 }
+
+// CountUnsealed returns the number of stored private keys that are not
+// encrypted with the primary master key of the configured envelope.Sealer,
+// i.e. keys that are stored unencrypted or wrapped with a previous master key.
+func (d *DBPEMStorer) CountUnsealed() (int, error) {
+	if d.sealer == nil {
+		return 0, errors.New("no master key configured")
+	}
+	rows, err := d.GetAll()
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, row := range rows {
+		if d.sealer.NeedsRewrap(row.PEMData) {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// Reseal encrypts all unencrypted private keys in place and re-wraps the data
+// keys of keys encrypted with a previous master key with the primary master
+// key. All keys are updated in a single transaction, so either all or none of
+// them are updated. It returns the number of updated keys.
+func (d *DBPEMStorer) Reseal() (int, error) {
+	if d.sealer == nil {
+		return 0, errors.New("no master key configured")
+	}
+	updated := 0
+	err := d.db.Transaction(
+		func(tx *gorm.DB) error {
+			var rows []model.PrivateKeyEntry
+			if err := tx.Table(d.tbl).Find(&rows).Error; err != nil {
+				return errors.WithStack(err)
+			}
+			for _, row := range rows {
+				if !d.sealer.NeedsRewrap(row.PEMData) {
+					continue
+				}
+				var data []byte
+				var err error
+				if envelope.IsSealed(row.PEMData) {
+					data, err = d.sealer.Rewrap(row.PEMData)
+				} else {
+					data, err = d.sealer.Seal(row.PEMData, d.additionalData(row.KID))
+				}
+				if err != nil {
+					return errors.Wrapf(err, "failed to encrypt private key '%s'", row.KID)
+				}
+				if err = tx.Table(d.tbl).
+					Where("kid = ?", row.KID).
+					Update("pem_data", model.PEMData(data)).Error; err != nil {
+					return errors.WithStack(err)
+				}
+				updated++
+			}
+			return nil
+		},
+	)
+	if err != nil {
+		return 0, err
+	}
+	return updated, nil
+}
@@ -0,0 +1,229 @@
+// Package envelope implements envelope encryption for secrets stored in the
+// database.
+//
+// Each secret is encrypted with its own random data key using AES-256-GCM.
+// The data key is wrapped (encrypted) with a master key and stored next to the
+// ciphertext together with the ID of the master key. Master keys can be
+// rotated by re-wrapping the data keys without re-encrypting the secrets.
+package envelope
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	stderrors "errors"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// sealedPrefix marks data sealed by a Sealer
+const sealedPrefix = "lh-envelope-v1:"
+
+const dataKeySize = 32
+
+// ErrUnknownMasterKey is returned if sealed data was wrapped with a master key
+// that is not known to the Sealer
+var ErrUnknownMasterKey = errors.New("data key was wrapped with an unknown master key")
+
+// KeyWrapper wraps and unwraps data keys with a master key
+type KeyWrapper interface {
+	// KeyID returns the ID of the master key; it is stored with the sealed
+	// data to select the master key for unwrapping
+	KeyID() string
+	// Wrap encrypts the passed data key
+	Wrap(dataKey []byte) ([]byte, error)
+	// Unwrap decrypts a data key encrypted by Wrap
+	Unwrap(wrapped []byte) ([]byte, error)
+}
+
+// sealed is the stored representation of sealed data
+type sealed struct {
+	MasterKeyID string `json:"mkid"`
+	WrappedKey  []byte `json:"wk"`
+	Nonce       []byte `json:"n"`
+	Ciphertext  []byte `json:"ct"`
+}
+
+// Sealer encrypts data with a fresh data key wrapped by its primary master key
+// and decrypts data wrapped by the primary or one of the previous master keys
+type Sealer struct {
+	primary KeyWrapper
+	byID    map[string]KeyWrapper
+}
+
+// NewSealer returns a new Sealer sealing with primary. The previous master
+// keys are only used to open data sealed before a master key rotation.
+func NewSealer(primary KeyWrapper, previous ...KeyWrapper) (*Sealer, error) {
+	if primary == nil {
+		return nil, errors.New("no master key given")
+	}
+	s := &Sealer{
+		primary: primary,
+		byID:    make(map[string]KeyWrapper, len(previous)+1),
+	}
+	for _, w := range append([]KeyWrapper{primary}, previous...) {
+		if w.KeyID() == "" {
+			return nil, errors.New("master key without key id")
+		}
+		if _, ok := s.byID[w.KeyID()]; ok {
+			return nil, errors.Errorf("duplicate master key id '%s'", w.KeyID())
+		}
+		s.byID[w.KeyID()] = w
+	}
+	return s, nil
+}
+
+// PrimaryKeyID returns the ID of the master key used for sealing
+func (s *Sealer) PrimaryKeyID() string {
+	return s.primary.KeyID()
+}
+
+// Close releases the master keys that hold resources, e.g. sessions to a
+// PKCS#11 token
+func (s *Sealer) Close() error {
+	var errs []error
+	for _, w := range s.byID {
+		if closer, ok := w.(io.Closer); ok {
+			if err := closer.Close(); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return stderrors.Join(errs...)
+}
+
+// IsSealed reports whether the passed data was sealed by a Sealer
+func IsSealed(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(sealedPrefix))
+}
+
+// MasterKeyID returns the ID of the master key the passed sealed data was
+// wrapped with
+func MasterKeyID(data []byte) (string, error) {
+	env, err := parse(data)
+	if err != nil {
+		return "", err
+	}
+	return env.MasterKeyID, nil
+}
+
+// Seal encrypts plaintext. The additional data is authenticated but not
+// stored; the same additional data must be passed to Open.
+func (s *Sealer) Seal(plaintext, additionalData []byte) ([]byte, error) {
+	dataKey := make([]byte, dataKeySize)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	wrapped, err := s.primary.Wrap(dataKey)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to wrap data key")
+	}
+	return encode(
+		sealed{
+			MasterKeyID: s.primary.KeyID(),
+			WrappedKey:  wrapped,
+			Nonce:       nonce,
+			Ciphertext:  aead.Seal(nil, nonce, plaintext, additionalData),
+		},
+	)
+}
+
+// Open decrypts data sealed by Seal
+func (s *Sealer) Open(data, additionalData []byte) ([]byte, error) {
+	env, err := parse(data)
+	if err != nil {
+		return nil, err
+	}
+	dataKey, err := s.unwrap(env)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := aead.Open(nil, env.Nonce, env.Ciphertext, additionalData)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decrypt sealed data")
+	}
+	return plaintext, nil
+}
+
+// NeedsRewrap reports whether the passed data is not sealed or sealed with
+// another master key than the primary one
+func (s *Sealer) NeedsRewrap(data []byte) bool {
+	id, err := MasterKeyID(data)
+	return err != nil || id != s.primary.KeyID()
+}
+
+// Rewrap re-wraps the data key of the passed sealed data with the primary
+// master key. The ciphertext is not changed.
+func (s *Sealer) Rewrap(data []byte) ([]byte, error) {
+	env, err := parse(data)
+	if err != nil {
+		return nil, err
+	}
+	if env.MasterKeyID == s.primary.KeyID() {
+		return data, nil
+	}
+	dataKey, err := s.unwrap(env)
+	if err != nil {
+		return nil, err
+	}
+	if env.WrappedKey, err = s.primary.Wrap(dataKey); err != nil {
+		return nil, errors.Wrap(err, "failed to wrap data key")
+	}
+	env.MasterKeyID = s.primary.KeyID()
+	return encode(env)
+}
+
+func (s *Sealer) unwrap(env sealed) ([]byte, error) {
+	w, ok := s.byID[env.MasterKeyID]
+	if !ok {
+		return nil, errors.Wrapf(ErrUnknownMasterKey, "master key id '%s'", env.MasterKeyID)
+	}
+	dataKey, err := w.Unwrap(env.WrappedKey)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to unwrap data key")
+	}
+	return dataKey, nil
+}
+
+func parse(data []byte) (sealed, error) {
+	var env sealed
+	if !IsSealed(data) {
+		return env, errors.New("data is not sealed")
+	}
+	if err := json.Unmarshal(data[len(sealedPrefix):], &env); err != nil {
+		return env, errors.Wrap(err, "invalid sealed data")
+	}
+	return env, nil
+}
+
+func encode(env sealed) ([]byte, error) {
+	data, err := json.Marshal(env)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return append([]byte(sealedPrefix), data...), nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	aead, err := cipher.NewGCM(block)
+	return aead, errors.WithStack(err)
+}
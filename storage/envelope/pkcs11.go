@@ -0,0 +1,75 @@
+package envelope
+
+import (
+	"github.com/eclipse-keypont/crypto11"
+	"github.com/pkg/errors"
+)
+
+// PKCS11Config configures a wrapping key stored in a PKCS#11 token (HSM)
+type PKCS11Config struct {
+	ModulePath        string
+	TokenLabel        string
+	TokenSerial       string
+	SlotNumber        *int
+	Pin               string
+	LoginNotSupported bool
+	// KeyLabel is the label of the AES secret key in the token
+	KeyLabel string
+}
+
+// PKCS11KeyWrapper is an AEADKeyWrapper whose master key is held by a PKCS#11
+// token. It keeps a session to the token open until it is closed.
+type PKCS11KeyWrapper struct {
+	*AEADKeyWrapper
+	ctx *crypto11.Context
+}
+
+// Close closes the session to the PKCS#11 token
+func (w *PKCS11KeyWrapper) Close() error {
+	return errors.WithStack(w.ctx.Close())
+}
+
+// NewPKCS11KeyWrapper returns a KeyWrapper that wraps data keys with
+// AES-GCM inside the PKCS#11 token, so the master key never leaves the token.
+// If id is empty, the key label is used as key id.
+func NewPKCS11KeyWrapper(id string, conf PKCS11Config) (w *PKCS11KeyWrapper, err error) {
+	if conf.KeyLabel == "" {
+		return nil, errors.New("pkcs11 wrapping key: key label must be given")
+	}
+	ctx, err := crypto11.Configure(
+		&crypto11.Config{
+			Path:              conf.ModulePath,
+			TokenLabel:        conf.TokenLabel,
+			TokenSerial:       conf.TokenSerial,
+			SlotNumber:        conf.SlotNumber,
+			Pin:               conf.Pin,
+			LoginNotSupported: conf.LoginNotSupported,
+		},
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "pkcs11 wrapping key: failed to open token")
+	}
+	defer func() {
+		if err != nil {
+			_ = ctx.Close()
+		}
+	}()
+	key, err := ctx.FindKey(nil, []byte(conf.KeyLabel))
+	if err != nil {
+		return nil, errors.Wrap(err, "pkcs11 wrapping key: failed to find key")
+	}
+	if key == nil {
+		return nil, errors.Errorf("pkcs11 wrapping key: no key with label '%s' found", conf.KeyLabel)
+	}
+	aead, err := key.NewGCM()
+	if err != nil {
+		return nil, errors.Wrap(err, "pkcs11 wrapping key: key does not support AES-GCM")
+	}
+	if id == "" {
+		id = "pkcs11:" + conf.KeyLabel
+	}
+	return &PKCS11KeyWrapper{
+		AEADKeyWrapper: NewAEADKeyWrapper(id, aead),
+		ctx:            ctx,
+	}, nil
+}
@@ -0,0 +1,136 @@
+package envelope
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// MasterKeySize is the size in bytes of master keys loaded from a file or an
+// environment variable (AES-256)
+const MasterKeySize = 32
+
+// AEADKeyWrapper is a KeyWrapper wrapping data keys with an AEAD cipher.
+// The master key ID is used as additional data, so a wrapped data key cannot
+// be attributed to another master key.
+type AEADKeyWrapper struct {
+	id   string
+	aead cipher.AEAD
+}
+
+// NewAEADKeyWrapper returns a new AEADKeyWrapper for the passed AEAD
+func NewAEADKeyWrapper(id string, aead cipher.AEAD) *AEADKeyWrapper {
+	return &AEADKeyWrapper{
+		id:   id,
+		aead: aead,
+	}
+}
+
+// NewAESKeyWrapper returns a new AEADKeyWrapper using AES-256-GCM with the
+// passed master key. If id is empty, it is derived from the master key.
+func NewAESKeyWrapper(id string, masterKey []byte) (*AEADKeyWrapper, error) {
+	if len(masterKey) != MasterKeySize {
+		return nil, errors.Errorf("master key must be %d bytes, got %d", MasterKeySize, len(masterKey))
+	}
+	aead, err := newGCM(masterKey)
+	if err != nil {
+		return nil, err
+	}
+	if id == "" {
+		sum := sha256.Sum256(masterKey)
+		id = "sha256:" + hex.EncodeToString(sum[:8])
+	}
+	return NewAEADKeyWrapper(id, aead), nil
+}
+
+// KeyID implements the KeyWrapper interface
+func (w *AEADKeyWrapper) KeyID() string {
+	return w.id
+}
+
+// Wrap implements the KeyWrapper interface
+func (w *AEADKeyWrapper) Wrap(dataKey []byte) ([]byte, error) {
+	nonce := make([]byte, w.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return w.aead.Seal(nonce, nonce, dataKey, []byte(w.id)), nil
+}
+
+// Unwrap implements the KeyWrapper interface
+func (w *AEADKeyWrapper) Unwrap(wrapped []byte) ([]byte, error) {
+	n := w.aead.NonceSize()
+	if len(wrapped) < n {
+		return nil, errors.New("wrapped data key too short")
+	}
+	dataKey, err := w.aead.Open(nil, wrapped[:n], wrapped[n:], []byte(w.id))
+	return dataKey, errors.WithStack(err)
+}
+
+// ParseMasterKey parses a master key encoded as base64 or hex. Surrounding
+// whitespace is ignored.
+func ParseMasterKey(data []byte) ([]byte, error) {
+	s := string(bytes.TrimSpace(data))
+	for _, decode := range []func(string) ([]byte, error){
+		base64.StdEncoding.DecodeString,
+		base64.RawStdEncoding.DecodeString,
+		base64.URLEncoding.DecodeString,
+		base64.RawURLEncoding.DecodeString,
+		hex.DecodeString,
+	} {
+		if key, err := decode(s); err == nil && len(key) == MasterKeySize {
+			return key, nil
+		}
+	}
+	return nil, errors.Errorf("master key must be the base64 or hex encoding of %d bytes", MasterKeySize)
+}
+
+// isText reports whether data only consists of printable ASCII characters
+// and whitespace, i.e. whether it is meant as an encoded key.
+func isText(data []byte) bool {
+	for _, b := range data {
+		if (b < 0x20 || b > 0x7e) && b != '\n' && b != '\r' && b != '\t' {
+			return false
+		}
+	}
+	return true
+}
+
+// LoadMasterKeyFile returns an AES KeyWrapper for the master key in the
+// passed file. The file holds either the raw key or its base64 or hex
+// encoding; a file consisting only of text is always decoded.
+func LoadMasterKeyFile(id, path string) (*AEADKeyWrapper, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read master key file")
+	}
+	if len(data) == MasterKeySize && !isText(data) {
+		return NewAESKeyWrapper(id, data)
+	}
+	key, err := ParseMasterKey(data)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid master key in '%s'", path)
+	}
+	return NewAESKeyWrapper(id, key)
+}
+
+// LoadMasterKeyEnv returns an AES KeyWrapper for the base64 or hex encoded
+// master key in the passed environment variable
+func LoadMasterKeyEnv(id, name string) (*AEADKeyWrapper, error) {
+	value, ok := os.LookupEnv(name)
+	if !ok || value == "" {
+		return nil, errors.Errorf("master key environment variable '%s' is not set", name)
+	}
+	key, err := ParseMasterKey([]byte(value))
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid master key in environment variable '%s'", name)
+	}
+	return NewAESKeyWrapper(id, key)
+}
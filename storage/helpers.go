@@ -156,19 +156,45 @@ func findCurrentAlgIndex(algs []SigningAlgWithNbf, now time.Time) int {
 
 // GetSigningAlg returns the signing algorithm
 func GetSigningAlg(kvStorage model.KeyValueStore) (jwa.SignatureAlgorithm, error) {
+	return GetSigningAlgForPurpose(kvStorage, model.SigningPurposeFederation)
+}
+
+// GetSigningAlgForPurpose returns the signing algorithm of the passed key
+// purpose. If no algorithm is stored for the purpose, the federation signing
+// algorithm is used.
+func GetSigningAlgForPurpose(kvStorage model.KeyValueStore, purpose string) (jwa.SignatureAlgorithm, error) {
+	alg, _, err := GetSigningAlgsForPurpose(kvStorage, purpose)
+	return alg, err
+}
+
+// GetSigningAlgs returns the default signing algorithm and all signing
+// algorithms for which keys are kept active
+func GetSigningAlgs(kvStorage model.KeyValueStore) (jwa.SignatureAlgorithm, []jwa.SignatureAlgorithm, error) {
+	return GetSigningAlgsForPurpose(kvStorage, model.SigningPurposeFederation)
+}
+
+// GetSigningAlgsForPurpose returns the default signing algorithm and all
+// signing algorithms for which keys are kept active for the passed key
+// purpose. The default algorithm is always the first entry of the returned
+// list. If no algorithm is stored for the purpose, the federation signing
+// algorithms are used.
+func GetSigningAlgsForPurpose(kvStorage model.KeyValueStore, purpose string) (
+	jwa.SignatureAlgorithm, []jwa.SignatureAlgorithm, error,
+) {
 	if kvStorage == nil {
-		return jwa.ES512(), nil
+		return DefaultSigningAlg, []jwa.SignatureAlgorithm{DefaultSigningAlg}, nil
 	}
+	scope := model.SigningScope(purpose)
 	var algs []SigningAlgWithNbf
-	found, err := kvStorage.GetAs(
-		model.KeyValueScopeSigning,
-		model.KeyValueKeyAlg, &algs,
-	)
+	found, err := kvStorage.GetAs(scope, model.KeyValueKeyAlg, &algs)
 	if err != nil {
-		return jwa.SignatureAlgorithm{}, err
+		return jwa.SignatureAlgorithm{}, nil, err
 	}
 	if !found {
-		return DefaultSigningAlg, nil
+		if scope != model.KeyValueScopeSigning {
+			return GetSigningAlgs(kvStorage)
+		}
+		return DefaultSigningAlg, []jwa.SignatureAlgorithm{DefaultSigningAlg}, nil
 	}
 
 	sortAlgsByNbf(algs)
@@ -176,60 +202,84 @@ func GetSigningAlg(kvStorage model.KeyValueStore) (jwa.SignatureAlgorithm, error
 
 	if currentIndex == -1 {
 		// Only future algs stored, returning default
-		return DefaultSigningAlg, nil
+		return DefaultSigningAlg, []jwa.SignatureAlgorithm{DefaultSigningAlg}, nil
 	}
 
-	alg := algs[currentIndex].SigningAlg
-	a, ok := jwa.LookupSignatureAlgorithm(alg)
+	current := algs[currentIndex]
+	defaultAlg, ok := jwa.LookupSignatureAlgorithm(current.SigningAlg)
 	if !ok {
-		return a, errors.Errorf("invalid signing algorithm: %s", alg)
+		return defaultAlg, nil, errors.Errorf("invalid signing algorithm: %s", current.SigningAlg)
+	}
+	activeAlgs := []jwa.SignatureAlgorithm{defaultAlg}
+	for _, alg := range current.Algs {
+		a, ok := jwa.LookupSignatureAlgorithm(alg)
+		if !ok {
+			return defaultAlg, nil, errors.Errorf("invalid signing algorithm: %s", alg)
+		}
+		if !slices.Contains(activeAlgs, a) {
+			activeAlgs = append(activeAlgs, a)
+		}
 	}
 
 	// Clean up expired algorithms
-	if err = kvStorage.SetAny(
-		model.KeyValueScopeSigning,
-		model.KeyValueKeyAlg, algs[currentIndex:],
-	); err != nil {
+	if err = kvStorage.SetAny(scope, model.KeyValueKeyAlg, algs[currentIndex:]); err != nil {
 		log.Error().Err(err).Msg("failed to remove expired signing algorithms")
 	}
-	return a, nil
+	return defaultAlg, activeAlgs, nil
 }
 
 // SigningAlgWithNbf is a signing algorithm with a not-before time used for
 // database storage
 type SigningAlgWithNbf struct {
+	// SigningAlg is the default signing algorithm
 	SigningAlg string
-	Nbf        *unixtime.Unixtime
+	// Algs are additional signing algorithms for which keys are kept active
+	Algs []string `json:",omitempty"`
+	Nbf  *unixtime.Unixtime
 }
 
 // SetSigningAlg sets the signing algorithm
 func SetSigningAlg(kvStorage model.KeyValueStore, alg SigningAlgWithNbf) error {
+	return SetSigningAlgForPurpose(kvStorage, model.SigningPurposeFederation, alg)
+}
+
+// SetSigningAlgForPurpose sets the signing algorithm of the passed key purpose
+func SetSigningAlgForPurpose(kvStorage model.KeyValueStore, purpose string, alg SigningAlgWithNbf) error {
 	if kvStorage == nil {
 		return errors.New("key value store is not set")
 	}
+	scope := model.SigningScope(purpose)
 	var stored []SigningAlgWithNbf
-	_, err := kvStorage.GetAs(model.KeyValueScopeSigning, model.KeyValueKeyAlg, &stored)
+	_, err := kvStorage.GetAs(scope, model.KeyValueKeyAlg, &stored)
 	if err != nil {
 		return err
 	}
-	return kvStorage.SetAny(model.KeyValueScopeSigning, model.KeyValueKeyAlg, append(stored, alg))
+	return kvStorage.SetAny(scope, model.KeyValueKeyAlg, append(stored, alg))
 }
 
 // GetRSAKeyLen returns the RSA key length
 func GetRSAKeyLen(kvStorage model.KeyValueStore) (int, error) {
+	return GetRSAKeyLenForPurpose(kvStorage, model.SigningPurposeFederation)
+}
+
+// GetRSAKeyLenForPurpose returns the RSA key length of the passed key
+// purpose. If no key length is stored for the purpose, the federation key
+// length is used.
+func GetRSAKeyLenForPurpose(kvStorage model.KeyValueStore, purpose string) (int, error) {
 	const d = 2048
 	if kvStorage == nil {
 		return d, nil
 	}
+	scope := model.SigningScope(purpose)
 	var l int
-	found, err := kvStorage.GetAs(
-		model.KeyValueScopeSigning,
-		model.KeyValueKeyRSAKeyLen, &l,
-	)
+	found, err := kvStorage.GetAs(scope, model.KeyValueKeyRSAKeyLen, &l)
 	if err != nil {
 		return d, err
 	}
 	if !found {
+		if scope != model.KeyValueScopeSigning {
+			return GetRSAKeyLen(kvStorage)
+		}
 		l = d
 	}
 	return l, nil
@@ -237,14 +287,26 @@ func GetRSAKeyLen(kvStorage model.KeyValueStore) (int, error) {
 
 // SetRSAKeyLen sets the RSA key length
 func SetRSAKeyLen(kvStorage model.KeyValueStore, rsaKeyLen int) error {
+	return SetRSAKeyLenForPurpose(kvStorage, model.SigningPurposeFederation, rsaKeyLen)
+}
+
+// SetRSAKeyLenForPurpose sets the RSA key length of the passed key purpose
+func SetRSAKeyLenForPurpose(kvStorage model.KeyValueStore, purpose string, rsaKeyLen int) error {
 	if kvStorage == nil {
 		return errors.New("key value store is not set")
 	}
-	return kvStorage.SetAny(model.KeyValueScopeSigning, model.KeyValueKeyRSAKeyLen, rsaKeyLen)
+	return kvStorage.SetAny(model.SigningScope(purpose), model.KeyValueKeyRSAKeyLen, rsaKeyLen)
 }
 
 // GetKeyRotation returns the kms.KeyRotationConfig
 func GetKeyRotation(kvStorage model.KeyValueStore) (c kms.KeyRotationConfig, err error) {
+	return GetKeyRotationForPurpose(kvStorage, model.SigningPurposeFederation)
+}
+
+// GetKeyRotationForPurpose returns the kms.KeyRotationConfig of the passed
+// key purpose. If no rotation config is stored for the purpose, the
+// federation rotation config is used.
+func GetKeyRotationForPurpose(kvStorage model.KeyValueStore, purpose string) (c kms.KeyRotationConfig, err error) {
 	c = kms.KeyRotationConfig{
 		Enabled:  false,
 		Interval: duration.DurationOption(time.Second * 600000), // a little bit under a week
@@ -256,19 +318,26 @@ func GetKeyRotation(kvStorage model.KeyValueStore) (c kms.KeyRotationConfig, err
 	if kvStorage == nil {
 		return
 	}
-	_, err = kvStorage.GetAs(
-		model.KeyValueScopeSigning,
-		model.KeyValueKeyKeyRotation, &c,
-	)
+	scope := model.SigningScope(purpose)
+	found, err := kvStorage.GetAs(scope, model.KeyValueKeyKeyRotation, &c)
+	if err == nil && !found && scope != model.KeyValueScopeSigning {
+		return GetKeyRotation(kvStorage)
+	}
 	return
 }
 
 // SetKeyRotation sets the kms.KeyRotationConfig
 func SetKeyRotation(kvStorage model.KeyValueStore, keyRotation kms.KeyRotationConfig) error {
+	return SetKeyRotationForPurpose(kvStorage, model.SigningPurposeFederation, keyRotation)
+}
+
+// SetKeyRotationForPurpose sets the kms.KeyRotationConfig of the passed key
+// purpose
+func SetKeyRotationForPurpose(kvStorage model.KeyValueStore, purpose string, keyRotation kms.KeyRotationConfig) error {
 	if kvStorage == nil {
 		return errors.New("key value store is not set")
 	}
-	return kvStorage.SetAny(model.KeyValueScopeSigning, model.KeyValueKeyKeyRotation, keyRotation)
+	return kvStorage.SetAny(model.SigningScope(purpose), model.KeyValueKeyKeyRotation, keyRotation)
 }
 
 // SetEntityConfigurationLifetime sets the entity configuration lifetime in seconds
@@ -0,0 +1,120 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-oidfed/lib/jwx/keymanagement/kms"
+	"github.com/lestrrat-go/jwx/v4/jwa"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zachmann/go-utils/duration"
+
+	"github.com/go-oidfed/lighthouse/storage/model"
+)
+
+func newTestKeyValueStorage(t *testing.T) *KeyValueStorage {
+	t.Helper()
+	db := newTestDB(t)
+	require.NoError(t, db.AutoMigrate(&model.KeyValue{}))
+	return &KeyValueStorage{db: db}
+}
+
+func TestSigningOptionsForPurpose_FallBackToFederation(t *testing.T) {
+	kv := newTestKeyValueStorage(t)
+
+	require.NoError(t, SetSigningAlg(kv, SigningAlgWithNbf{SigningAlg: "ES256"}))
+	require.NoError(t, SetRSAKeyLen(kv, 4096))
+	require.NoError(
+		t, SetKeyRotation(
+			kv, kms.KeyRotationConfig{
+				Enabled:  true,
+				Interval: duration.DurationOption(48 * time.Hour),
+			},
+		),
+	)
+
+	alg, err := GetSigningAlgForPurpose(kv, model.SigningPurposeTrustMarks)
+	require.NoError(t, err)
+	assert.Equal(t, jwa.ES256(), alg)
+	rsaKeyLen, err := GetRSAKeyLenForPurpose(kv, model.SigningPurposeTrustMarks)
+	require.NoError(t, err)
+	assert.Equal(t, 4096, rsaKeyLen)
+	rotation, err := GetKeyRotationForPurpose(kv, model.SigningPurposeTrustMarks)
+	require.NoError(t, err)
+	assert.True(t, rotation.Enabled)
+	assert.Equal(t, 48*time.Hour, rotation.Interval.Duration())
+}
+
+func TestSigningOptionsForPurpose_Separate(t *testing.T) {
+	kv := newTestKeyValueStorage(t)
+
+	require.NoError(t, SetSigningAlg(kv, SigningAlgWithNbf{SigningAlg: "ES256"}))
+	require.NoError(
+		t, SetSigningAlgForPurpose(kv, model.SigningPurposeResolve, SigningAlgWithNbf{SigningAlg: "EdDSA"}),
+	)
+	require.NoError(t, SetRSAKeyLenForPurpose(kv, model.SigningPurposeResolve, 3072))
+	require.NoError(
+		t, SetKeyRotationForPurpose(
+			kv, model.SigningPurposeResolve, kms.KeyRotationConfig{
+				Enabled:  true,
+				Interval: duration.DurationOption(time.Hour),
+			},
+		),
+	)
+
+	alg, err := GetSigningAlgForPurpose(kv, model.SigningPurposeResolve)
+	require.NoError(t, err)
+	assert.Equal(t, jwa.EdDSA(), alg)
+	rsaKeyLen, err := GetRSAKeyLenForPurpose(kv, model.SigningPurposeResolve)
+	require.NoError(t, err)
+	assert.Equal(t, 3072, rsaKeyLen)
+	rotation, err := GetKeyRotationForPurpose(kv, model.SigningPurposeResolve)
+	require.NoError(t, err)
+	assert.Equal(t, time.Hour, rotation.Interval.Duration())
+
+	// The federation options are not affected
+	alg, err = GetSigningAlg(kv)
+	require.NoError(t, err)
+	assert.Equal(t, jwa.ES256(), alg)
+	rsaKeyLen, err = GetRSAKeyLen(kv)
+	require.NoError(t, err)
+	assert.Equal(t, 2048, rsaKeyLen)
+	rotation, err = GetKeyRotation(kv)
+	require.NoError(t, err)
+	assert.False(t, rotation.Enabled)
+}
+
+func TestGetSigningAlgsForPurpose(t *testing.T) {
+	kv := newTestKeyValueStorage(t)
+
+	defaultAlg, algs, err := GetSigningAlgs(kv)
+	require.NoError(t, err)
+	assert.Equal(t, DefaultSigningAlg, defaultAlg)
+	assert.Equal(t, []jwa.SignatureAlgorithm{DefaultSigningAlg}, algs)
+
+	require.NoError(
+		t, SetSigningAlg(
+			kv, SigningAlgWithNbf{
+				SigningAlg: "ES256",
+				Algs:       []string{"RS256", "ES256", "PS256"},
+			},
+		),
+	)
+	defaultAlg, algs, err = GetSigningAlgs(kv)
+	require.NoError(t, err)
+	assert.Equal(t, jwa.ES256(), defaultAlg)
+	assert.Equal(t, []jwa.SignatureAlgorithm{jwa.ES256(), jwa.RS256(), jwa.PS256()}, algs)
+
+	// Purposes without own algorithms use the federation algorithms
+	defaultAlg, algs, err = GetSigningAlgsForPurpose(kv, model.SigningPurposeResolve)
+	require.NoError(t, err)
+	assert.Equal(t, jwa.ES256(), defaultAlg)
+	assert.Len(t, algs, 3)
+}
+
+func TestSigningScope(t *testing.T) {
+	assert.Equal(t, model.KeyValueScopeSigning, model.SigningScope(""))
+	assert.Equal(t, model.KeyValueScopeSigning, model.SigningScope(model.SigningPurposeFederation))
+	assert.Equal(t, "signing:trust_marks", model.SigningScope(model.SigningPurposeTrustMarks))
+}
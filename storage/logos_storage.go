@@ -0,0 +1,139 @@
+package storage
+
+import (
+	"strconv"
+
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+
+	"github.com/go-oidfed/lighthouse/storage/model"
+)
+
+// logoListColumns are the columns of a Logo without the image data.
+var logoListColumns = []string{
+	"id", "created_at", "updated_at", "source_uri", "content_type", "size", "width", "height", "digest",
+	"last_checked_at", "last_error",
+}
+
+// LogosStorage implements model.LogoStore using GORM.
+type LogosStorage struct {
+	db *gorm.DB
+}
+
+// NewLogosStorage creates a new LogosStorage.
+func NewLogosStorage(db *gorm.DB) *LogosStorage {
+	return &LogosStorage{db: db}
+}
+
+// LogosStorage returns a LogosStorage
+func (s *Storage) LogosStorage() *LogosStorage {
+	return NewLogosStorage(s.db)
+}
+
+// List returns all hosted logos without their image data ordered by id.
+func (s *LogosStorage) List() ([]model.Logo, error) {
+	var items []model.Logo
+	if err := s.db.Select(logoListColumns).Order("id").Find(&items).Error; err != nil {
+		return nil, errors.Wrap(err, "logos: list failed")
+	}
+	return items, nil
+}
+
+// Get returns the logo with the passed id including its image data.
+func (s *LogosStorage) Get(ident string) (*model.Logo, error) {
+	id, err := strconv.ParseUint(ident, 10, 64)
+	if err != nil {
+		return nil, model.NotFoundError("logo not found")
+	}
+	var item model.Logo
+	if err = s.db.First(&item, uint(id)).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, model.NotFoundError("logo not found")
+		}
+		return nil, errors.Wrap(err, "logos: get failed")
+	}
+	return &item, nil
+}
+
+// GetBySourceURI returns the logo hosted in place of the passed external URI.
+func (s *LogosStorage) GetBySourceURI(sourceURI string) (*model.Logo, error) {
+	var item model.Logo
+	if err := s.db.Where("source_uri = ?", sourceURI).First(&item).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, model.NotFoundError("logo not found")
+		}
+		return nil, errors.Wrap(err, "logos: get failed")
+	}
+	return &item, nil
+}
+
+// Create stores a new logo.
+func (s *LogosStorage) Create(logo *model.Logo) (*model.Logo, error) {
+	if logo.SourceURI != "" {
+		_, err := s.GetBySourceURI(logo.SourceURI)
+		if err == nil {
+			return nil, model.AlreadyExistsError("a logo for this source_uri already exists")
+		}
+		var notFound model.NotFoundError
+		if !errors.As(err, &notFound) {
+			return nil, err
+		}
+	}
+	item := *logo
+	item.ID = 0
+	if err := s.db.Create(&item).Error; err != nil {
+		return nil, errors.Wrap(err, "logos: create failed")
+	}
+	return &item, nil
+}
+
+// UpdateImage replaces the image of the logo.
+func (s *LogosStorage) UpdateImage(ident string, logo *model.Logo) (*model.Logo, error) {
+	item, err := s.Get(ident)
+	if err != nil {
+		return nil, err
+	}
+	item.ContentType = logo.ContentType
+	item.Size = logo.Size
+	item.Width = logo.Width
+	item.Height = logo.Height
+	item.Digest = logo.Digest
+	item.Data = logo.Data
+	if err = s.db.Save(item).Error; err != nil {
+		return nil, errors.Wrap(err, "logos: update failed")
+	}
+	return item, nil
+}
+
+// SetCheckResult records the result of a check of the logo's SourceURI.
+func (s *LogosStorage) SetCheckResult(ident string, checkedAt int64, checkErr string) error {
+	item, err := s.Get(ident)
+	if err != nil {
+		return err
+	}
+	if err = s.db.Model(item).Updates(
+		map[string]any{
+			"last_checked_at": checkedAt,
+			"last_error":      checkErr,
+		},
+	).Error; err != nil {
+		return errors.Wrap(err, "logos: update failed")
+	}
+	return nil
+}
+
+// Delete removes the logo.
+func (s *LogosStorage) Delete(ident string) error {
+	id, err := strconv.ParseUint(ident, 10, 64)
+	if err != nil {
+		return model.NotFoundError("logo not found")
+	}
+	res := s.db.Delete(&model.Logo{}, uint(id))
+	if res.Error != nil {
+		return errors.Wrap(res.Error, "logos: delete failed")
+	}
+	if res.RowsAffected == 0 {
+		return model.NotFoundError("logo not found")
+	}
+	return nil
+}
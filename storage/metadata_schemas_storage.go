@@ -0,0 +1,81 @@
+package storage
+
+import (
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/go-oidfed/lighthouse/storage/model"
+)
+
+// MetadataSchemasStorage implements model.MetadataSchemaStore using GORM.
+type MetadataSchemasStorage struct {
+	db *gorm.DB
+}
+
+// NewMetadataSchemasStorage creates a new MetadataSchemasStorage.
+func NewMetadataSchemasStorage(db *gorm.DB) *MetadataSchemasStorage {
+	return &MetadataSchemasStorage{db: db}
+}
+
+// MetadataSchemasStorage returns a MetadataSchemasStorage
+func (s *Storage) MetadataSchemasStorage() *MetadataSchemasStorage {
+	return NewMetadataSchemasStorage(s.db)
+}
+
+// List returns all stored metadata schemas ordered by entity type.
+func (s *MetadataSchemasStorage) List() ([]model.MetadataSchema, error) {
+	var items []model.MetadataSchema
+	if err := s.db.Order("entity_type").Find(&items).Error; err != nil {
+		return nil, errors.Wrap(err, "metadata_schemas: list failed")
+	}
+	return items, nil
+}
+
+// Get returns the stored schema for an entity type.
+func (s *MetadataSchemasStorage) Get(entityType string) (*model.MetadataSchema, error) {
+	var item model.MetadataSchema
+	if err := s.db.Where("entity_type = ?", entityType).First(&item).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, model.NotFoundError("metadata schema not found")
+		}
+		return nil, errors.Wrap(err, "metadata_schemas: get failed")
+	}
+	return &item, nil
+}
+
+// Put creates or replaces the stored schema for an entity type.
+func (s *MetadataSchemasStorage) Put(entityType string, req model.AddMetadataSchema) (*model.MetadataSchema, error) {
+	if entityType == "" {
+		return nil, model.ValidationError("entity_type is required")
+	}
+	if len(req.Schema) == 0 {
+		return nil, model.ValidationError("schema is required")
+	}
+	item := &model.MetadataSchema{
+		EntityType:  entityType,
+		Description: req.Description,
+		Schema:      req.Schema,
+	}
+	if err := s.db.Clauses(
+		clause.OnConflict{
+			Columns:   []clause.Column{{Name: "entity_type"}},
+			DoUpdates: clause.AssignmentColumns([]string{"description", "schema", "updated_at"}),
+		},
+	).Create(item).Error; err != nil {
+		return nil, errors.Wrap(err, "metadata_schemas: put failed")
+	}
+	return s.Get(entityType)
+}
+
+// Delete removes the stored schema for an entity type.
+func (s *MetadataSchemasStorage) Delete(entityType string) error {
+	res := s.db.Where("entity_type = ?", entityType).Delete(&model.MetadataSchema{})
+	if res.Error != nil {
+		return errors.Wrap(res.Error, "metadata_schemas: delete failed")
+	}
+	if res.RowsAffected == 0 {
+		return model.NotFoundError("metadata schema not found")
+	}
+	return nil
+}
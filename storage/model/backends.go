@@ -26,25 +26,30 @@ type TransactionFunc func(txBackends *Backends) error
 // It provides a single struct that can be passed around instead of
 // multiple return values for each storage backend.
 type Backends struct {
-	DB                  *gorm.DB
-	Subordinates        SubordinateStorageBackend
-	SubordinateEvents   SubordinateEventStore
-	TrustMarks          TrustMarkedEntitiesStorageBackend
-	TrustMarkSpecs      TrustMarkSpecStore
-	TrustMarkInstances  IssuedTrustMarkInstanceStore
-	AuthorityHints      AuthorityHintsStore
-	TrustMarkTypes      TrustMarkTypesStore
-	TrustMarkOwners     TrustMarkOwnersStore
-	TrustMarkIssuers    TrustMarkIssuersStore
-	AdditionalClaims    AdditionalClaimsStore
-	PublishedTrustMarks PublishedTrustMarksStore
-	TrustAnchors        TrustAnchorStore
-	FederationEndpoints FederationEndpointStore
-	KV                  KeyValueStore
-	Users               UsersStore
-	PKStorages          func(string) public.PublicKeyStorage
-	Stats               StatsStorageBackend
-	JTI                 JTIStorageBackend
+	DB                     *gorm.DB
+	Subordinates           SubordinateStorageBackend
+	SubordinateEvents      SubordinateEventStore
+	TrustMarks             TrustMarkedEntitiesStorageBackend
+	TrustMarkSpecs         TrustMarkSpecStore
+	TrustMarkInstances     IssuedTrustMarkInstanceStore
+	TrustMarkSubjectEvents TrustMarkSubjectEventStore
+	TrustMarkRequests      TrustMarkRequestStore
+	TrustMarkReports       TrustMarkReportStore
+	AuthorityHints         AuthorityHintsStore
+	TrustMarkTypes         TrustMarkTypesStore
+	TrustMarkOwners        TrustMarkOwnersStore
+	TrustMarkIssuers       TrustMarkIssuersStore
+	AdditionalClaims       AdditionalClaimsStore
+	PublishedTrustMarks    PublishedTrustMarksStore
+	TrustAnchors           TrustAnchorStore
+	FederationEndpoints    FederationEndpointStore
+	MetadataSchemas        MetadataSchemaStore
+	Logos                  LogoStore
+	KV                     KeyValueStore
+	Users                  UsersStore
+	PKStorages             func(string) public.PublicKeyStorage
+	Stats                  StatsStorageBackend
+	JTI                    JTIStorageBackend
 
 	// Transaction wraps multiple storage operations in a single DB transaction.
 	// All backends provided to the TransactionFunc operate within the same transaction.
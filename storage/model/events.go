@@ -50,6 +50,35 @@ const (
 	// (approach C) accepts a signed JWK Set from the subordinate and updates
 	// the stored JWKS.
 	EventTypeJWKSUpdated = "jwks_updated"
+	// EventTypeJWKSRejected is recorded when a new JWKS of a subordinate
+	// (via the jwks_update or jwks_update_trigger endpoints or the refresher)
+	// is rejected because it violates the configured JWKS policy.
+	EventTypeJWKSRejected = "jwks_rejected"
+	// EventTypeRevalidationFailed is recorded when the periodic re-validation
+	// finds that an entity no longer passes its configured checks.
+	EventTypeRevalidationFailed = "revalidation_failed"
+	// EventTypeRevalidationRecovered is recorded when an entity that
+	// previously failed re-validation passes its checks again.
+	EventTypeRevalidationRecovered = "revalidation_recovered"
+	// EventTypeRevalidationEnforced is recorded when the configured
+	// re-validation action (e.g. status change, revocation) is applied after
+	// the grace period has elapsed.
+	EventTypeRevalidationEnforced = "revalidation_enforced"
+	// EventTypeKeyExpiring is recorded when the key health monitoring finds a
+	// key in the subordinate's JWKS that expires soon.
+	EventTypeKeyExpiring = "key_expiring"
+	// EventTypeKeyExpired is recorded when the key health monitoring finds an
+	// expired key in the subordinate's JWKS.
+	EventTypeKeyExpired = "key_expired"
+	// EventTypeTrustMarkPushed is recorded when a trust mark was pushed to a
+	// trust mark subject.
+	EventTypeTrustMarkPushed = "trust_mark_pushed"
+	// EventTypeTrustMarkPushFailed is recorded when pushing a trust mark to a
+	// trust mark subject failed.
+	EventTypeTrustMarkPushFailed = "trust_mark_push_failed"
+	// EventTypeTrustMarkRequested is recorded when a trust mark subject
+	// requested a trust mark through the trust mark request endpoint.
+	EventTypeTrustMarkRequested = "trust_mark_requested"
 )
 
 // SubordinateEvent stores an event related to a subordinate.
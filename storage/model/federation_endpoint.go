@@ -11,19 +11,21 @@ import (
 type FederationEndpointType string
 
 const (
-	EndpointTypeFetch             FederationEndpointType = "fetch"
-	EndpointTypeList              FederationEndpointType = "list"
-	EndpointTypeResolve           FederationEndpointType = "resolve"
-	EndpointTypeTrustMark         FederationEndpointType = "trust_mark"
-	EndpointTypeTrustMarkStatus   FederationEndpointType = "trust_mark_status"
-	EndpointTypeTrustMarkListing  FederationEndpointType = "trust_mark_listing"
-	EndpointTypeHistoricalKeys    FederationEndpointType = "historical_keys"
-	EndpointTypeEnroll            FederationEndpointType = "enroll"
-	EndpointTypeEnrollRequest     FederationEndpointType = "enroll_request"
-	EndpointTypeTrustMarkRequest  FederationEndpointType = "trust_mark_request"
-	EndpointTypeEntityCollection  FederationEndpointType = "entity_collection"
-	EndpointTypeJwksUpdateTrigger FederationEndpointType = "jwks_update_trigger"
-	EndpointTypeJwksUpdate        FederationEndpointType = "jwks_update"
+	EndpointTypeFetch                  FederationEndpointType = "fetch"
+	EndpointTypeList                   FederationEndpointType = "list"
+	EndpointTypeResolve                FederationEndpointType = "resolve"
+	EndpointTypeTrustMark              FederationEndpointType = "trust_mark"
+	EndpointTypeTrustMarkStatus        FederationEndpointType = "trust_mark_status"
+	EndpointTypeTrustMarkListing       FederationEndpointType = "trust_mark_listing"
+	EndpointTypeHistoricalKeys         FederationEndpointType = "historical_keys"
+	EndpointTypeEnroll                 FederationEndpointType = "enroll"
+	EndpointTypeEnrollRequest          FederationEndpointType = "enroll_request"
+	EndpointTypeTrustMarkRequest       FederationEndpointType = "trust_mark_request"
+	EndpointTypeEntityCollection       FederationEndpointType = "entity_collection"
+	EndpointTypeJwksUpdateTrigger      FederationEndpointType = "jwks_update_trigger"
+	EndpointTypeJwksUpdate             FederationEndpointType = "jwks_update"
+	EndpointTypeTrustMarkDelegation    FederationEndpointType = "trust_mark_delegation"
+	EndpointTypeTrustMarkRequestStatus FederationEndpointType = "trust_mark_request_status"
 )
 
 // AllFederationEndpointTypes returns all valid endpoint types.
@@ -42,6 +44,8 @@ func AllFederationEndpointTypes() []FederationEndpointType {
 		EndpointTypeEntityCollection,
 		EndpointTypeJwksUpdateTrigger,
 		EndpointTypeJwksUpdate,
+		EndpointTypeTrustMarkDelegation,
+		EndpointTypeTrustMarkRequestStatus,
 	}
 }
 
@@ -10,18 +10,58 @@ const (
 	KeyValueScopeEntityConfiguration  = "entity_configuration"
 	KeyValueScopeSubordinateStatement = "subordinate_statement"
 	KeyValueScopeSigning              = "signing"
+	KeyValueScopeRevalidation         = "revalidation"
+	KeyValueScopeKeyHealth            = "key_health"
+	KeyValueScopeCeremony             = "ceremony"
+	KeyValueScopeTrustMarkDelegations = "trust_mark_delegations"
+	KeyValueScopeTrustMarkPush        = "trust_mark_push"
+	KeyValueScopePublishedTrustMarks  = "published_trust_marks"
+	// KeyValueScopeTrustMarkPreIssuance holds the bundles of pre-issued
+	// trust marks; the key is the trust mark type
+	KeyValueScopeTrustMarkPreIssuance = "trust_mark_pre_issuance"
+	KeyValueScopeLogos                = "logos"
 
-	KeyValueKeyLifetime           = "lifetime"
-	KeyValueKeyMetadataPolicy     = "metadata_policy"
-	KeyValueKeyMetadata           = "metadata"
-	KeyValueKeyConstraints        = "constraints"
-	KeyValueKeyAlg                = "alg"
-	KeyValueKeyRSAKeyLen          = "rsa_key_len"
-	KeyValueKeyKeyRotation        = "key_rotation"
-	KeyValueKeyAdditionalClaims   = "additional_claims"
-	KeyValueKeyMetadataPolicyCrit = "metadata_policy_crit"
+	KeyValueKeyLifetime            = "lifetime"
+	KeyValueKeyMetadataPolicy      = "metadata_policy"
+	KeyValueKeyMetadata            = "metadata"
+	KeyValueKeyConstraints         = "constraints"
+	KeyValueKeyAlg                 = "alg"
+	KeyValueKeyRSAKeyLen           = "rsa_key_len"
+	KeyValueKeyKeyRotation         = "key_rotation"
+	KeyValueKeyAdditionalClaims    = "additional_claims"
+	KeyValueKeyMetadataPolicyCrit  = "metadata_policy_crit"
+	KeyValueKeyKeyRollover         = "key_rollover"
+	KeyValueKeyKeyHealthAlerts     = "alerts"
+	KeyValueKeyPresignedStatements = "presigned_statements"
+	KeyValueKeyDelegationConfigs   = "configs"
+	KeyValueKeyIssuedDelegations   = "issued"
+	KeyValueKeyExternalDelegations = "external"
+	KeyValueKeyPendingPushes       = "pending"
+	KeyValueKeyTrustMarkHealth     = "health"
+	KeyValueKeyLogoCheck           = "check"
 )
 
+// Signing key purposes. Each purpose can use its own key set; purposes
+// without an own key set use the federation keys.
+const (
+	SigningPurposeFederation = "federation"
+	SigningPurposeTrustMarks = "trust_marks"
+	SigningPurposeResolve    = "resolve"
+)
+
+// SigningPurposes lists the signing key purposes that can have their own key
+// set
+var SigningPurposes = []string{SigningPurposeTrustMarks, SigningPurposeResolve}
+
+// SigningScope returns the key value scope holding the signing options of
+// the passed key purpose
+func SigningScope(purpose string) string {
+	if purpose == "" || purpose == SigningPurposeFederation {
+		return KeyValueScopeSigning
+	}
+	return KeyValueScopeSigning + ":" + purpose
+}
+
 // KeyValue stores arbitrary key-value data.
 //
 // Values are serialized efficiently using GORM's json serializer, which
@@ -0,0 +1,50 @@
+package model
+
+// Logo is an image hosted by LightHouse, e.g. the logo of a trust mark type or
+// of an entity. If SourceURI is set, the logo is a copy of the external logo
+// at that URI and is published in its place.
+type Logo struct {
+	ID        uint `gorm:"primarykey" json:"id"`
+	CreatedAt int  `json:"created_at"`
+	UpdatedAt int  `json:"updated_at"`
+	// SourceURI is the external logo URI the hosted logo replaces.
+	SourceURI   string `gorm:"size:2048" json:"source_uri,omitempty"`
+	ContentType string `gorm:"size:64" json:"content_type"`
+	// Size is the size of the image in bytes.
+	Size int `json:"size"`
+	// Width and Height are the dimensions of the image in pixels; they are 0
+	// for SVG images without absolute dimensions.
+	Width  int `json:"width"`
+	Height int `json:"height"`
+	// Digest is the hex-encoded SHA-256 digest of the image, also used as
+	// ETag.
+	Digest string `gorm:"size:64" json:"digest"`
+	Data   []byte `json:"-"`
+	// LastCheckedAt is the unix timestamp the SourceURI was last fetched at.
+	LastCheckedAt int64 `json:"last_checked_at,omitempty"`
+	// LastError is the error of the last check of the SourceURI; the
+	// previously fetched image is kept in that case.
+	LastError string `json:"last_error,omitempty"`
+}
+
+// LogoStore is the storage interface for hosted logos
+type LogoStore interface {
+	// List returns all hosted logos without their image data.
+	List() ([]Logo, error)
+	// Get returns the logo with the passed id including its image data or a
+	// NotFoundError.
+	Get(ident string) (*Logo, error)
+	// GetBySourceURI returns the logo hosted in place of the passed external
+	// URI or a NotFoundError.
+	GetBySourceURI(sourceURI string) (*Logo, error)
+	// Create stores a new logo. It returns an AlreadyExistsError if a logo
+	// with the same SourceURI exists.
+	Create(logo *Logo) (*Logo, error)
+	// UpdateImage replaces the image of the logo with the image of the passed
+	// logo.
+	UpdateImage(ident string, logo *Logo) (*Logo, error)
+	// SetCheckResult records the result of a check of the logo's SourceURI.
+	SetCheckResult(ident string, checkedAt int64, checkErr string) error
+	// Delete removes the logo or returns a NotFoundError.
+	Delete(ident string) error
+}
@@ -0,0 +1,36 @@
+package model
+
+import (
+	"gorm.io/datatypes"
+)
+
+// MetadataSchema stores a JSON Schema used to validate the metadata of an
+// entity type. A stored schema takes precedence over the built-in default
+// schema for the same entity type.
+type MetadataSchema struct {
+	ID          uint           `gorm:"primarykey" json:"id"`
+	CreatedAt   int            `json:"created_at"`
+	UpdatedAt   int            `json:"updated_at"`
+	EntityType  string         `gorm:"size:255;uniqueIndex" json:"entity_type"`
+	Description string         `json:"description,omitempty"`
+	Schema      datatypes.JSON `json:"schema"`
+}
+
+// AddMetadataSchema is the request payload to create/replace a MetadataSchema
+type AddMetadataSchema struct {
+	Description string         `json:"description,omitempty"`
+	Schema      datatypes.JSON `json:"schema"`
+}
+
+// MetadataSchemaStore is the storage interface for metadata schemas
+type MetadataSchemaStore interface {
+	// List returns all stored metadata schemas.
+	List() ([]MetadataSchema, error)
+	// Get returns the stored schema for an entity type or a NotFoundError.
+	Get(entityType string) (*MetadataSchema, error)
+	// Put creates or replaces the stored schema for an entity type.
+	Put(entityType string, req AddMetadataSchema) (*MetadataSchema, error)
+	// Delete removes the stored schema for an entity type or returns a
+	// NotFoundError.
+	Delete(entityType string) error
+}
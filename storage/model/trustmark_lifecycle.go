@@ -0,0 +1,59 @@
+package model
+
+// TrustMarkLifecycleState is the lifecycle state of a trust mark type or
+// TrustMarkSpec.
+type TrustMarkLifecycleState string
+
+const (
+	// TrustMarkLifecycleActive is a trust mark type that is issued normally
+	// (default; an empty state is treated as active)
+	TrustMarkLifecycleActive TrustMarkLifecycleState = "active"
+	// TrustMarkLifecycleDeprecated is a trust mark type that is still issued,
+	// but should be replaced by its successor
+	TrustMarkLifecycleDeprecated TrustMarkLifecycleState = "deprecated"
+	// TrustMarkLifecycleRetired is a trust mark type that is no longer issued;
+	// the status of already issued trust marks can still be queried
+	TrustMarkLifecycleRetired TrustMarkLifecycleState = "retired"
+)
+
+// Valid reports whether the state is one of the defined constants or empty.
+func (s TrustMarkLifecycleState) Valid() bool {
+	switch s {
+	case "", TrustMarkLifecycleActive, TrustMarkLifecycleDeprecated, TrustMarkLifecycleRetired:
+		return true
+	default:
+		return false
+	}
+}
+
+// Deprecated reports whether the state is deprecated or retired.
+func (s TrustMarkLifecycleState) Deprecated() bool {
+	return s == TrustMarkLifecycleDeprecated || s == TrustMarkLifecycleRetired
+}
+
+// ValidateTrustMarkLifecycle checks the lifecycle state and successor type of
+// the passed trust mark type.
+func ValidateTrustMarkLifecycle(trustMarkType string, state TrustMarkLifecycleState, successorType string) error {
+	if !state.Valid() {
+		return ValidationErrorFmt("invalid lifecycle_state: %s", state)
+	}
+	if successorType != "" && successorType == trustMarkType {
+		return ValidationError("successor_type must differ from trust_mark_type")
+	}
+	return nil
+}
+
+// TrustMarkSubjectMigration is the result of migrating the TrustMarkSubjects
+// of a TrustMarkSpec to the TrustMarkSpec of its successor type.
+type TrustMarkSubjectMigration struct {
+	TrustMarkType string `json:"trust_mark_type"`
+	SuccessorType string `json:"successor_type"`
+	// SpecCreated is set if the TrustMarkSpec of the successor type did not
+	// exist and was created from the migrated TrustMarkSpec.
+	SpecCreated bool `json:"spec_created"`
+	// Migrated lists the entity ids of the subjects copied to the successor.
+	Migrated []string `json:"migrated"`
+	// Skipped lists the entity ids of eligible subjects that already exist
+	// for the successor.
+	Skipped []string `json:"skipped"`
+}
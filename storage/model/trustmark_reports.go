@@ -0,0 +1,106 @@
+package model
+
+import (
+	"io"
+	"time"
+
+	"github.com/go-oidfed/lighthouse/internal/stats"
+)
+
+// TrustMarkReportCounter identifies a trust mark endpoint outcome that is not
+// reflected in the issued trust mark instances and therefore counted
+// separately.
+type TrustMarkReportCounter string
+
+const (
+	// TrustMarkReportCounterCacheHits counts trust marks served from the
+	// issued trust mark cache.
+	TrustMarkReportCounterCacheHits TrustMarkReportCounter = "cache_hits"
+	// TrustMarkReportCounterEligibilityDenials counts trust mark requests
+	// that were denied because the subject was not eligible.
+	TrustMarkReportCounterEligibilityDenials TrustMarkReportCounter = "eligibility_denials"
+)
+
+// TrustMarkReportDayFormat is the format of TrustMarkIssuanceCounter.Day.
+const TrustMarkReportDayFormat = "2006-01-02"
+
+// TrustMarkIssuanceCounter holds the daily counters of a trust mark type.
+type TrustMarkIssuanceCounter struct {
+	// Day is the UTC day in the format 2006-01-02
+	Day                string `gorm:"primaryKey;size:10" json:"day"`
+	TrustMarkType      string `gorm:"primaryKey;size:255" json:"trust_mark_type"`
+	CacheHits          int64  `json:"cache_hits"`
+	EligibilityDenials int64  `json:"eligibility_denials"`
+}
+
+// MaxTrustMarkReportPeriods is the maximum number of periods a trust mark
+// report can cover.
+const MaxTrustMarkReportPeriods = 1000
+
+// TrustMarkReportFilter selects the data of a trust mark report.
+type TrustMarkReportFilter struct {
+	From time.Time
+	To   time.Time
+	// TrustMarkType restricts the report to a single trust mark type; if
+	// empty all types are reported.
+	TrustMarkType string
+	// Interval is the length of the report periods; supported are day, week
+	// and month, anything else is reported per day.
+	Interval stats.Interval
+}
+
+// TrustMarkReportEntry holds the aggregated issuance data of a trust mark
+// type for a single period.
+type TrustMarkReportEntry struct {
+	// Period is the UTC start of the period
+	Period        time.Time `json:"period"`
+	TrustMarkType string    `json:"trust_mark_type"`
+	// Issued is the number of trust marks issued in the period, including
+	// re-issuances
+	Issued int64 `json:"issued"`
+	// Reissued is the number of trust marks issued to subjects that already
+	// had been issued a trust mark of this type before
+	Reissued int64 `json:"reissued"`
+	// Revoked is the number of trust marks revoked in the period
+	Revoked            int64 `json:"revoked"`
+	CacheHits          int64 `json:"cache_hits"`
+	EligibilityDenials int64 `json:"eligibility_denials"`
+	// ActiveHolders is the number of subjects holding a valid trust mark of
+	// this type at the end of the period
+	ActiveHolders int64 `json:"active_holders"`
+}
+
+// TrustMarkHolderReport holds the issuance data of a single subject for a
+// trust mark type.
+type TrustMarkHolderReport struct {
+	TrustMarkType string `json:"trust_mark_type"`
+	Subject       string `json:"subject"`
+	// Issued is the number of trust marks issued to the subject in the
+	// report range
+	Issued int64 `json:"issued"`
+	// Revoked is the number of trust marks of the subject revoked in the
+	// report range
+	Revoked       int64 `json:"revoked"`
+	FirstIssuedAt int64 `json:"first_issued_at"`
+	LastIssuedAt  int64 `json:"last_issued_at"`
+	// Active indicates if the subject held a valid trust mark at the end of
+	// the report range
+	Active bool `json:"active"`
+}
+
+// TrustMarkReportStore aggregates trust mark issuance data for reporting.
+type TrustMarkReportStore interface {
+	// AddCounters adds the passed counts to the daily counters of their
+	// trust mark types.
+	AddCounters(counters []TrustMarkIssuanceCounter) error
+	// Report returns the aggregated issuance data per trust mark type and
+	// period, ordered by period and type.
+	Report(filter TrustMarkReportFilter) ([]TrustMarkReportEntry, error)
+	// Holders returns the subjects that were issued or had revoked a trust
+	// mark in the report range, ordered by type and subject.
+	Holders(filter TrustMarkReportFilter) ([]TrustMarkHolderReport, error)
+	// ExportCSV writes the report as CSV.
+	ExportCSV(filter TrustMarkReportFilter, w io.Writer) error
+	// ExportJSON writes the report as newline-delimited JSON.
+	ExportJSON(filter TrustMarkReportFilter, w io.Writer) error
+}
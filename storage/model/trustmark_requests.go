@@ -0,0 +1,93 @@
+package model
+
+import (
+	"gorm.io/gorm"
+)
+
+// TrustMarkRequestStatus is the state of a TrustMarkRequest.
+type TrustMarkRequestStatus string
+
+const (
+	// TrustMarkRequestStatusPending is the status of a request that waits for
+	// a decision.
+	TrustMarkRequestStatusPending TrustMarkRequestStatus = "pending"
+	// TrustMarkRequestStatusApproved is the status of an approved request.
+	TrustMarkRequestStatusApproved TrustMarkRequestStatus = "approved"
+	// TrustMarkRequestStatusRejected is the status of a rejected request.
+	TrustMarkRequestStatusRejected TrustMarkRequestStatus = "rejected"
+)
+
+// Valid reports whether the status is a known TrustMarkRequestStatus.
+func (s TrustMarkRequestStatus) Valid() bool {
+	switch s {
+	case TrustMarkRequestStatusPending, TrustMarkRequestStatusApproved, TrustMarkRequestStatusRejected:
+		return true
+	default:
+		return false
+	}
+}
+
+// TrustMarkRequest is a request of an entity to be entitled for a trust mark,
+// submitted through the trust mark request endpoint, together with the
+// evidence the requester submitted and the review of the request.
+type TrustMarkRequest struct {
+	ID            uint                   `gorm:"primarykey" json:"id"`
+	CreatedAt     int                    `json:"created_at"`
+	UpdatedAt     int                    `json:"updated_at"`
+	DeletedAt     gorm.DeletedAt         `gorm:"index" json:"-"`
+	TrustMarkType string                 `gorm:"size:255;index:idx_tmrequest_type_entity" json:"trust_mark_type"`
+	EntityID      string                 `gorm:"size:255;index:idx_tmrequest_type_entity" json:"entity_id"`
+	Status        TrustMarkRequestStatus `gorm:"size:32;index" json:"status"`
+	// RequestedBy is the entity that authenticated at the trust mark request
+	// endpoint, if authentication is enabled.
+	RequestedBy string `gorm:"size:255" json:"requested_by,omitempty"`
+	// Evidence is free-form JSON submitted by the requester.
+	Evidence map[string]any `gorm:"serializer:json" json:"evidence,omitempty"`
+	// Attachments are references (e.g. URLs) to documents submitted by the
+	// requester.
+	Attachments []string `gorm:"serializer:json" json:"attachments,omitempty"`
+	// DecisionReason is the reason given with the approval or rejection.
+	DecisionReason string `gorm:"type:text" json:"decision_reason,omitempty"`
+	// DecidedBy is the actor that approved or rejected the request.
+	DecidedBy string `gorm:"size:255" json:"decided_by,omitempty"`
+	// DecidedAt is the time the request was approved or rejected.
+	DecidedAt int64                     `json:"decided_at,omitempty"`
+	Comments  []TrustMarkRequestComment `gorm:"constraint:OnDelete:CASCADE" json:"comments,omitempty"`
+}
+
+// TrustMarkRequestComment is a comment of a reviewer on a TrustMarkRequest.
+type TrustMarkRequestComment struct {
+	ID                 uint   `gorm:"primarykey" json:"id"`
+	CreatedAt          int    `json:"created_at"`
+	TrustMarkRequestID uint   `gorm:"index" json:"-"`
+	Actor              string `gorm:"size:255" json:"actor,omitempty"`
+	Comment            string `gorm:"type:text" json:"comment"`
+}
+
+// TrustMarkRequestFilter filters the TrustMarkRequests returned by
+// TrustMarkRequestStore.List; empty fields do not filter.
+type TrustMarkRequestFilter struct {
+	TrustMarkType string
+	EntityID      string
+	Status        TrustMarkRequestStatus
+}
+
+// TrustMarkRequestStore is an interface for storing and retrieving
+// TrustMarkRequests.
+type TrustMarkRequestStore interface {
+	// Create stores a new request.
+	Create(request *TrustMarkRequest) error
+	// Get returns a request including its comments.
+	Get(id uint) (*TrustMarkRequest, error)
+	// List returns the requests matching the filter, newest first.
+	List(filter TrustMarkRequestFilter) ([]TrustMarkRequest, error)
+	// Latest returns the newest request for a trust mark type and entity, or
+	// nil if there is none.
+	Latest(trustMarkType, entityID string) (*TrustMarkRequest, error)
+	// UpdateEvidence replaces the evidence and attachments of a request.
+	UpdateEvidence(id uint, evidence map[string]any, attachments []string) error
+	// AddComment adds a reviewer comment to a request.
+	AddComment(id uint, actor, comment string) (*TrustMarkRequestComment, error)
+	// Decide approves or rejects a pending request.
+	Decide(id uint, status TrustMarkRequestStatus, reason, actor string) (*TrustMarkRequest, error)
+}
@@ -0,0 +1,35 @@
+package model
+
+import (
+	"gorm.io/gorm"
+)
+
+// TrustMarkSubjectEvent stores an event related to a trust mark subject.
+// It uses the same event types as SubordinateEvent where applicable (e.g.
+// EventTypeStatusUpdated, EventTypeRevalidationFailed).
+type TrustMarkSubjectEvent struct {
+	ID                 uint           `gorm:"primarykey" json:"id"`
+	CreatedAt          int            `json:"created_at"`
+	UpdatedAt          int            `json:"updated_at"`
+	DeletedAt          gorm.DeletedAt `gorm:"index" json:"-"`
+	TrustMarkSubjectID uint           `gorm:"index" json:"trust_mark_subject_id"`
+	Timestamp          int64          `gorm:"index" json:"timestamp"`
+	Type               string         `gorm:"index" json:"type"`
+	Status             *string        `json:"status,omitempty"`
+	Message            *string        `json:"message,omitempty"`
+	Actor              *string        `json:"actor,omitempty"`
+}
+
+// TrustMarkSubjectEventStore is an interface for storing and retrieving trust
+// mark subject events.
+type TrustMarkSubjectEventStore interface {
+	// Add creates a new event record.
+	Add(event TrustMarkSubjectEvent) error
+
+	// GetBySubjectID returns events for a trust mark subject with optional
+	// filtering and pagination.
+	GetBySubjectID(subjectID uint, opts EventQueryOpts) ([]TrustMarkSubjectEvent, int64, error)
+
+	// DeleteBySubjectID removes all events for a trust mark subject.
+	DeleteBySubjectID(subjectID uint) error
+}
@@ -19,6 +19,14 @@ type TrustMarkType struct {
 	Owner         *TrustMarkOwner   `json:"owner,omitempty"`
 	Description   string            `gorm:"type:text" json:"description,omitempty"`
 	Issuers       []TrustMarkIssuer `gorm:"many2many:trust_mark_type_issuers" json:"issuers,omitempty"`
+	// LifecycleState is the lifecycle state of the trust mark type within
+	// the federation.
+	LifecycleState TrustMarkLifecycleState `gorm:"size:16" json:"lifecycle_state,omitempty"`
+	// SuccessorType is the trust mark type replacing this one.
+	SuccessorType string `gorm:"size:255" json:"successor_type,omitempty"`
+	// DeprecatedAt is the time the trust mark type was deprecated (unix
+	// timestamp); it is set automatically when the lifecycle state changes.
+	DeprecatedAt int64 `json:"deprecated_at,omitempty"`
 }
 
 // TrustMarkOwner represents the owner of a trust mark type.
@@ -72,10 +80,12 @@ func (a *AddTrustMarkOwner) UnmarshalJSON(data []byte) error {
 // Optional fields are used by separate endpoints; issuers and owner can be set
 // during creation for convenience.
 type AddTrustMarkType struct {
-	TrustMarkType    string               `json:"trust_mark_type"`
-	Description      string               `json:"description,omitempty"`
-	TrustMarkOwner   *AddTrustMarkOwner   `json:"trust_mark_owner,omitempty"`
-	TrustMarkIssuers []AddTrustMarkIssuer `json:"trust_mark_issuers,omitempty"`
+	TrustMarkType    string                  `json:"trust_mark_type"`
+	Description      string                  `json:"description,omitempty"`
+	TrustMarkOwner   *AddTrustMarkOwner      `json:"trust_mark_owner,omitempty"`
+	TrustMarkIssuers []AddTrustMarkIssuer    `json:"trust_mark_issuers,omitempty"`
+	LifecycleState   TrustMarkLifecycleState `json:"lifecycle_state,omitempty"`
+	SuccessorType    string                  `json:"successor_type,omitempty"`
 }
 
 // TrustMarkTypesStore abstracts CRUD and relations for TrustMarkType, its owner, and issuers.
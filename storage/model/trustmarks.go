@@ -1,6 +1,8 @@
 package model
 
 import (
+	"time"
+
 	"gorm.io/gorm"
 )
 
@@ -55,6 +57,24 @@ type TrustMarkSpec struct {
 	// This reduces signing operations and database writes for repeated requests.
 	// 0 = no caching (default)
 	CacheTTL int `json:"cache_ttl,omitempty"`
+	// DelegationURL is the URL a renewed DelegationJWT is fetched from. If
+	// empty, the federation_trust_mark_delegation_endpoint of the trust mark
+	// owner is used.
+	DelegationURL string `gorm:"size:512" json:"delegation_url,omitempty"`
+	// PushDelivery enables the push delivery of issued trust marks to the
+	// subjects.
+	PushDelivery bool `json:"push_delivery,omitempty"`
+	// PreIssuance enables the periodic issuance of trust marks to all active
+	// subjects in advance, e.g. for the distribution to offline verifiers.
+	PreIssuance bool `json:"pre_issuance,omitempty"`
+	// LifecycleState is the lifecycle state of the trust mark type; retired
+	// trust marks are no longer issued.
+	LifecycleState TrustMarkLifecycleState `gorm:"size:16" json:"lifecycle_state,omitempty"`
+	// SuccessorType is the trust mark type replacing this one.
+	SuccessorType string `gorm:"size:255" json:"successor_type,omitempty"`
+	// DeprecatedAt is the time the trust mark type was deprecated (unix
+	// timestamp); it is set automatically when the lifecycle state changes.
+	DeprecatedAt int64 `json:"deprecated_at,omitempty"`
 }
 
 // TrustMarkSubject represents a subject eligible for a specific trust mark issuance.
@@ -69,6 +89,25 @@ type TrustMarkSubject struct {
 	Status           Status         `gorm:"index" json:"status"`
 	AdditionalClaims map[string]any `gorm:"serializer:json" json:"additional_claims,omitempty"`
 	Description      string         `gorm:"type:text" json:"description,omitempty"`
+	// PushURL is the URL trust marks are pushed to if push delivery is
+	// enabled for the TrustMarkSpec. If empty, the
+	// federation_trust_mark_push_endpoint from the subject's entity
+	// configuration is used.
+	PushURL string `gorm:"size:512" json:"push_url,omitempty"`
+	// Lifetime overrides the lifetime of the TrustMarkSpec for this subject
+	// (in seconds); 0 uses the lifetime of the TrustMarkSpec.
+	Lifetime uint `json:"lifetime,omitempty"`
+	// NotBefore and NotAfter limit the validity window of the subject (unix
+	// timestamps, 0 means unlimited). Trust marks are only issued within the
+	// window and expire at NotAfter at the latest.
+	NotBefore int64 `json:"not_before,omitempty"`
+	NotAfter  int64 `json:"not_after,omitempty"`
+}
+
+// WithinValidity reports whether the passed time is within the validity
+// window of the subject.
+func (s TrustMarkSubject) WithinValidity(t time.Time) bool {
+	return (s.NotBefore == 0 || t.Unix() >= s.NotBefore) && (s.NotAfter == 0 || t.Unix() < s.NotAfter)
 }
 
 // IssuedTrustMarkInstance represents an instance of a TrustMark in the database.
@@ -147,19 +186,28 @@ type TrustMarkSpecStore interface {
 	UpdateSubject(specIdent, subjectIdent string, subject *AddTrustMarkSubject) (*TrustMarkSubject, error)
 	DeleteSubject(specIdent, subjectIdent string) error
 	ChangeSubjectStatus(specIdent, subjectIdent string, status Status) (*TrustMarkSubject, error)
+
+	// MigrateSubjects copies the active and pending subjects of a spec to the
+	// spec of its successor type, creating the successor spec if needed.
+	MigrateSubjects(specIdent string) (*TrustMarkSubjectMigration, error)
 }
 
 // AddTrustMarkSpec represents the payload for creating or updating a TrustMarkSpec.
 type AddTrustMarkSpec struct {
-	TrustMarkType     string             `json:"trust_mark_type"`
-	Lifetime          uint               `json:"lifetime,omitempty"`
-	Ref               string             `json:"ref,omitempty"`
-	LogoURI           string             `json:"logo_uri,omitempty"`
-	DelegationJWT     string             `json:"delegation_jwt,omitempty"`
-	AdditionalClaims  map[string]any     `json:"additional_claims,omitempty"`
-	Description       string             `json:"description,omitempty"`
-	EligibilityConfig *EligibilityConfig `json:"eligibility_config,omitempty"`
-	CacheTTL          int                `json:"cache_ttl,omitempty"`
+	TrustMarkType     string                  `json:"trust_mark_type"`
+	Lifetime          uint                    `json:"lifetime,omitempty"`
+	Ref               string                  `json:"ref,omitempty"`
+	LogoURI           string                  `json:"logo_uri,omitempty"`
+	DelegationJWT     string                  `json:"delegation_jwt,omitempty"`
+	AdditionalClaims  map[string]any          `json:"additional_claims,omitempty"`
+	Description       string                  `json:"description,omitempty"`
+	EligibilityConfig *EligibilityConfig      `json:"eligibility_config,omitempty"`
+	CacheTTL          int                     `json:"cache_ttl,omitempty"`
+	DelegationURL     string                  `json:"delegation_url,omitempty"`
+	PushDelivery      bool                    `json:"push_delivery,omitempty"`
+	PreIssuance       bool                    `json:"pre_issuance,omitempty"`
+	LifecycleState    TrustMarkLifecycleState `json:"lifecycle_state,omitempty"`
+	SuccessorType     string                  `json:"successor_type,omitempty"`
 }
 
 // AddTrustMarkSubject represents the payload for creating or updating a TrustMarkSubject.
@@ -168,4 +216,8 @@ type AddTrustMarkSubject struct {
 	Status           Status         `json:"status"`
 	Description      string         `json:"description,omitempty"`
 	AdditionalClaims map[string]any `json:"additional_claims,omitempty"`
+	PushURL          string         `json:"push_url,omitempty"`
+	Lifetime         uint           `json:"lifetime,omitempty"`
+	NotBefore        int64          `json:"not_before,omitempty"`
+	NotAfter         int64          `json:"not_after,omitempty"`
 }
@@ -36,6 +36,10 @@ var models = []any{
 	&model.TrustMarkIssuer{},
 	&model.TrustMarkSpec{},
 	&model.TrustMarkSubject{},
+	&model.TrustMarkSubjectEvent{},
+	&model.TrustMarkRequest{},
+	&model.TrustMarkRequestComment{},
+	&model.TrustMarkIssuanceCounter{},
 	&model.PublishedTrustMark{},
 	&model.HistoricalKey{},
 	&model.AuthorityHint{},
@@ -46,6 +50,8 @@ var models = []any{
 	&model.TrustAnchor{},
 	&model.FederationEndpoint{},
 	&model.FederationEndpointAuthTA{},
+	&model.MetadataSchema{},
+	&model.Logo{},
 }
 
 // statsModels contains models for the stats feature.
@@ -322,6 +328,9 @@ func (s *TrustMarkTypesStorage) List() ([]model.TrustMarkType, error) {
 }
 
 func (s *TrustMarkTypesStorage) Create(req model.AddTrustMarkType) (*model.TrustMarkType, error) {
+	if err := model.ValidateTrustMarkLifecycle(req.TrustMarkType, req.LifecycleState, req.SuccessorType); err != nil {
+		return nil, err
+	}
 	var existing model.TrustMarkType
 	result := s.db.Unscoped().Where("trust_mark_type = ?", req.TrustMarkType).First(&existing)
 	if result.Error == nil {
@@ -329,6 +338,9 @@ func (s *TrustMarkTypesStorage) Create(req model.AddTrustMarkType) (*model.Trust
 			existing.DeletedAt = gorm.DeletedAt{}
 			existing.TrustMarkType = req.TrustMarkType
 			existing.Description = req.Description
+			existing.LifecycleState = req.LifecycleState
+			existing.SuccessorType = req.SuccessorType
+			existing.DeprecatedAt = deprecatedAt(0, req.LifecycleState)
 			if err := s.db.Save(&existing).Error; err != nil {
 				return nil, errors.Wrap(err, "trust_mark_types: reactivation failed")
 			}
@@ -338,8 +350,11 @@ func (s *TrustMarkTypesStorage) Create(req model.AddTrustMarkType) (*model.Trust
 	}
 
 	item := &model.TrustMarkType{
-		TrustMarkType: req.TrustMarkType,
-		Description:   req.Description,
+		TrustMarkType:  req.TrustMarkType,
+		Description:    req.Description,
+		LifecycleState: req.LifecycleState,
+		SuccessorType:  req.SuccessorType,
+		DeprecatedAt:   deprecatedAt(0, req.LifecycleState),
 	}
 	if err := s.db.Create(item).Error; err != nil {
 		if isUniqueConstraintError(err) {
@@ -365,11 +380,17 @@ func (s *TrustMarkTypesStorage) Get(ident string) (*model.TrustMarkType, error)
 }
 
 func (s *TrustMarkTypesStorage) Update(ident string, req model.AddTrustMarkType) (*model.TrustMarkType, error) {
+	if err := model.ValidateTrustMarkLifecycle(req.TrustMarkType, req.LifecycleState, req.SuccessorType); err != nil {
+		return nil, err
+	}
 	item, err := s.findTypeByIdent(ident)
 	if err != nil {
 		return nil, err
 	}
 	item.TrustMarkType = req.TrustMarkType
+	item.LifecycleState = req.LifecycleState
+	item.SuccessorType = req.SuccessorType
+	item.DeprecatedAt = deprecatedAt(item.DeprecatedAt, req.LifecycleState)
 	if err = s.db.Save(item).Error; err != nil {
 		if isUniqueConstraintError(err) {
 			return nil, model.AlreadyExistsError("trust mark type already exists")
@@ -1026,6 +1047,12 @@ func (s *TrustMarkSpecStorage) Create(spec *model.AddTrustMarkSpec) (*model.Trus
 			existing.Description = spec.Description
 			existing.EligibilityConfig = spec.EligibilityConfig
 			existing.CacheTTL = spec.CacheTTL
+			existing.DelegationURL = spec.DelegationURL
+			existing.PushDelivery = spec.PushDelivery
+			existing.PreIssuance = spec.PreIssuance
+			if err := setTrustMarkSpecLifecycle(&existing, spec.LifecycleState, spec.SuccessorType); err != nil {
+				return nil, err
+			}
 			if err := s.db.Save(&existing).Error; err != nil {
 				return nil, errors.Wrap(err, "trust_mark_specs: reactivation failed")
 			}
@@ -1044,6 +1071,12 @@ func (s *TrustMarkSpecStorage) Create(spec *model.AddTrustMarkSpec) (*model.Trus
 		Description:       spec.Description,
 		EligibilityConfig: spec.EligibilityConfig,
 		CacheTTL:          spec.CacheTTL,
+		DelegationURL:     spec.DelegationURL,
+		PushDelivery:      spec.PushDelivery,
+		PreIssuance:       spec.PreIssuance,
+	}
+	if err := setTrustMarkSpecLifecycle(record, spec.LifecycleState, spec.SuccessorType); err != nil {
+		return nil, err
 	}
 	if err := s.db.Create(record).Error; err != nil {
 		if isUniqueConstraintError(err) {
@@ -1105,6 +1138,12 @@ func (s *TrustMarkSpecStorage) Update(ident string, spec *model.AddTrustMarkSpec
 	existing.Description = spec.Description
 	existing.EligibilityConfig = spec.EligibilityConfig
 	existing.CacheTTL = spec.CacheTTL
+	existing.DelegationURL = spec.DelegationURL
+	existing.PushDelivery = spec.PushDelivery
+	existing.PreIssuance = spec.PreIssuance
+	if err = setTrustMarkSpecLifecycle(existing, spec.LifecycleState, spec.SuccessorType); err != nil {
+		return nil, err
+	}
 
 	if err = s.db.Save(existing).Error; err != nil {
 		if isUniqueConstraintError(err) {
@@ -1149,6 +1188,9 @@ func (s *TrustMarkSpecStorage) Patch(ident string, updates map[string]any) (*mod
 			dbUpdates[key] = value
 		}
 	}
+	if err = patchTrustMarkSpecLifecycle(existing, dbUpdates); err != nil {
+		return nil, err
+	}
 
 	if err = s.db.Model(existing).Updates(dbUpdates).Error; err != nil {
 		if isUniqueConstraintError(err) {
@@ -1163,6 +1205,68 @@ func (s *TrustMarkSpecStorage) Patch(ident string, updates map[string]any) (*mod
 	return existing, nil
 }
 
+// setTrustMarkSpecLifecycle validates and sets the lifecycle state and
+// successor type of a TrustMarkSpec and maintains its DeprecatedAt timestamp.
+func setTrustMarkSpecLifecycle(
+	spec *model.TrustMarkSpec, state model.TrustMarkLifecycleState, successorType string,
+) error {
+	if err := model.ValidateTrustMarkLifecycle(spec.TrustMarkType, state, successorType); err != nil {
+		return err
+	}
+	spec.LifecycleState = state
+	spec.SuccessorType = successorType
+	spec.DeprecatedAt = deprecatedAt(spec.DeprecatedAt, state)
+	return nil
+}
+
+// patchTrustMarkSpecLifecycle validates the lifecycle fields of a patch and
+// adds the resulting deprecated_at to the updates.
+func patchTrustMarkSpecLifecycle(existing *model.TrustMarkSpec, updates map[string]any) error {
+	_, stateSet := updates["lifecycle_state"]
+	_, successorSet := updates["successor_type"]
+	if !stateSet && !successorSet {
+		return nil
+	}
+	patched := *existing
+	if stateSet {
+		state, ok := updates["lifecycle_state"].(string)
+		if updates["lifecycle_state"] != nil && !ok {
+			return model.ValidationError("lifecycle_state must be a string")
+		}
+		patched.LifecycleState = model.TrustMarkLifecycleState(state)
+		updates["lifecycle_state"] = state
+	}
+	if successorSet {
+		successorType, ok := updates["successor_type"].(string)
+		if updates["successor_type"] != nil && !ok {
+			return model.ValidationError("successor_type must be a string")
+		}
+		patched.SuccessorType = successorType
+		updates["successor_type"] = successorType
+	}
+	if trustMarkType, ok := updates["trust_mark_type"].(string); ok {
+		patched.TrustMarkType = trustMarkType
+	}
+	if err := setTrustMarkSpecLifecycle(&patched, patched.LifecycleState, patched.SuccessorType); err != nil {
+		return err
+	}
+	updates["deprecated_at"] = patched.DeprecatedAt
+	return nil
+}
+
+// deprecatedAt returns the deprecation timestamp for the passed lifecycle
+// state: the current one if it is already deprecated, now if it becomes
+// deprecated, and 0 otherwise.
+func deprecatedAt(current int64, state model.TrustMarkLifecycleState) int64 {
+	if !state.Deprecated() {
+		return 0
+	}
+	if current == 0 {
+		return time.Now().Unix()
+	}
+	return current
+}
+
 // Delete deletes a TrustMarkSpec
 func (s *TrustMarkSpecStorage) Delete(ident string) error {
 	existing, err := s.findByIdent(ident)
@@ -1206,6 +1310,10 @@ func (s *TrustMarkSpecStorage) CreateSubject(specIdent string, subject *model.Ad
 		Status:           subject.Status,
 		Description:      subject.Description,
 		AdditionalClaims: subject.AdditionalClaims,
+		PushURL:          subject.PushURL,
+		Lifetime:         subject.Lifetime,
+		NotBefore:        subject.NotBefore,
+		NotAfter:         subject.NotAfter,
 	}
 
 	// Check for soft-deleted record with same entity_id (unscoped to include deleted)
@@ -1220,6 +1328,10 @@ func (s *TrustMarkSpecStorage) CreateSubject(specIdent string, subject *model.Ad
 			existing.Status = subject.Status
 			existing.AdditionalClaims = subject.AdditionalClaims
 			existing.Description = subject.Description
+			existing.PushURL = subject.PushURL
+			existing.Lifetime = subject.Lifetime
+			existing.NotBefore = subject.NotBefore
+			existing.NotAfter = subject.NotAfter
 			if err := s.db.Unscoped().Save(&existing).Error; err != nil {
 				return nil, errors.Wrap(err, "trust_mark_specs: restore subject failed")
 			}
@@ -1277,6 +1389,10 @@ func (s *TrustMarkSpecStorage) UpdateSubject(specIdent, subjectIdent string, sub
 	existing.Status = subject.Status
 	existing.Description = subject.Description
 	existing.AdditionalClaims = subject.AdditionalClaims
+	existing.PushURL = subject.PushURL
+	existing.Lifetime = subject.Lifetime
+	existing.NotBefore = subject.NotBefore
+	existing.NotAfter = subject.NotAfter
 
 	if err = s.db.Save(existing).Error; err != nil {
 		if isUniqueConstraintError(err) {
@@ -1323,6 +1439,100 @@ func (s *TrustMarkSpecStorage) ChangeSubjectStatus(specIdent, subjectIdent strin
 	return existing, nil
 }
 
+// MigrateSubjects copies the active and pending TrustMarkSubjects of a
+// TrustMarkSpec, including their additional claims, to the TrustMarkSpec of
+// its successor type. If the successor spec does not exist, it is created
+// from the migrated spec. Subjects that already exist for the successor are
+// skipped.
+func (s *TrustMarkSpecStorage) MigrateSubjects(specIdent string) (*model.TrustMarkSubjectMigration, error) {
+	var migration *model.TrustMarkSubjectMigration
+	err := s.db.Transaction(
+		func(tx *gorm.DB) error {
+			var err error
+			migration, err = (&TrustMarkSpecStorage{db: tx}).migrateSubjects(specIdent)
+			return err
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	return migration, nil
+}
+
+func (s *TrustMarkSpecStorage) migrateSubjects(specIdent string) (*model.TrustMarkSubjectMigration, error) {
+	spec, err := s.findByIdent(specIdent)
+	if err != nil {
+		return nil, err
+	}
+	if spec.SuccessorType == "" {
+		return nil, model.ValidationError("trust mark spec has no successor_type")
+	}
+	migration := &model.TrustMarkSubjectMigration{
+		TrustMarkType: spec.TrustMarkType,
+		SuccessorType: spec.SuccessorType,
+		Migrated:      []string{},
+		Skipped:       []string{},
+	}
+	successor, err := s.GetByType(spec.SuccessorType)
+	if err != nil {
+		var notFound model.NotFoundError
+		if !errors.As(err, &notFound) {
+			return nil, err
+		}
+		// The delegation is bound to the trust mark type and cannot be copied
+		successor, err = s.Create(
+			&model.AddTrustMarkSpec{
+				TrustMarkType:     spec.SuccessorType,
+				Lifetime:          spec.Lifetime,
+				Ref:               spec.Ref,
+				LogoURI:           spec.LogoURI,
+				AdditionalClaims:  spec.AdditionalClaims,
+				Description:       spec.Description,
+				EligibilityConfig: spec.EligibilityConfig,
+				CacheTTL:          spec.CacheTTL,
+				PushDelivery:      spec.PushDelivery,
+				PreIssuance:       spec.PreIssuance,
+			},
+		)
+		if err != nil {
+			return nil, err
+		}
+		migration.SpecCreated = true
+	}
+	successorIdent := strconv.FormatUint(uint64(successor.ID), 10)
+
+	var subjects []model.TrustMarkSubject
+	if err = s.db.Where(
+		"trust_mark_spec_id = ? AND status IN ?", spec.ID, []model.Status{model.StatusActive, model.StatusPending},
+	).Order("entity_id").Find(&subjects).Error; err != nil {
+		return nil, errors.Wrap(err, "trust_mark_specs: list subjects for migration failed")
+	}
+	for _, subject := range subjects {
+		_, err = s.CreateSubject(
+			successorIdent, &model.AddTrustMarkSubject{
+				EntityID:         subject.EntityID,
+				Status:           subject.Status,
+				Description:      subject.Description,
+				AdditionalClaims: subject.AdditionalClaims,
+				PushURL:          subject.PushURL,
+				Lifetime:         subject.Lifetime,
+				NotBefore:        subject.NotBefore,
+				NotAfter:         subject.NotAfter,
+			},
+		)
+		if err != nil {
+			var alreadyExists model.AlreadyExistsError
+			if errors.As(err, &alreadyExists) {
+				migration.Skipped = append(migration.Skipped, subject.EntityID)
+				continue
+			}
+			return nil, err
+		}
+		migration.Migrated = append(migration.Migrated, subject.EntityID)
+	}
+	return migration, nil
+}
+
 // revokeInstancesForSubject revokes all issued trust mark instances for a subject.
 // This is called when a subject's status changes to blocked/inactive or when deleted.
 func (s *TrustMarkSpecStorage) revokeInstancesForSubject(subjectID uint, entityID, specIdent string) {
@@ -0,0 +1,472 @@
+package storage
+
+import (
+	"cmp"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"maps"
+	"math"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/go-oidfed/lighthouse/internal/stats"
+	"github.com/go-oidfed/lighthouse/storage/model"
+)
+
+// TrustMarkReportsStorage implements the TrustMarkReportStore interface
+// using GORM. Issuances, re-issuances, revocations and active holders are
+// derived from the issued trust mark instances; cache hits and eligibility
+// denials are read from the daily counters.
+type TrustMarkReportsStorage struct {
+	db *gorm.DB
+}
+
+// NewTrustMarkReportsStorage creates a new TrustMarkReportsStorage.
+func NewTrustMarkReportsStorage(db *gorm.DB) *TrustMarkReportsStorage {
+	return &TrustMarkReportsStorage{db: db}
+}
+
+// AddCounters adds the passed counts to the daily counters of their trust
+// mark types in a single transaction.
+func (s *TrustMarkReportsStorage) AddCounters(counters []model.TrustMarkIssuanceCounter) error {
+	if len(counters) == 0 {
+		return nil
+	}
+	err := s.db.Transaction(
+		func(tx *gorm.DB) error {
+			for _, row := range counters {
+				if row.Day == "" || row.TrustMarkType == "" {
+					return model.ValidationError("trust mark report counter requires day and trust mark type")
+				}
+				if err := tx.Clauses(
+					clause.OnConflict{
+						Columns: []clause.Column{
+							{Name: "day"},
+							{Name: "trust_mark_type"},
+						},
+						DoUpdates: clause.Assignments(
+							map[string]any{
+								"cache_hits":          gorm.Expr("cache_hits + ?", row.CacheHits),
+								"eligibility_denials": gorm.Expr("eligibility_denials + ?", row.EligibilityDenials),
+							},
+						),
+					},
+				).Create(&row).Error; err != nil {
+					return errors.WithStack(err)
+				}
+			}
+			return nil
+		},
+	)
+	return errors.Wrap(err, "trust_mark_issuance_counters: add failed")
+}
+
+// reportInstance holds the columns of an issued trust mark instance needed
+// for reporting.
+type reportInstance struct {
+	TrustMarkType string
+	Subject       string
+	CreatedAt     int64
+	UpdatedAt     int64
+	ExpiresAt     int64
+	Revoked       bool
+}
+
+// activeAt reports if the instance was valid at the passed unix time. The
+// revocation time is taken from the last update of a revoked instance.
+func (i reportInstance) activeAt(t int64) bool {
+	return i.CreatedAt <= t &&
+		(i.ExpiresAt == 0 || i.ExpiresAt > t) &&
+		(!i.Revoked || i.UpdatedAt > t)
+}
+
+func (i reportInstance) revokedIn(from, to int64) bool {
+	return i.Revoked && i.UpdatedAt >= from && i.UpdatedAt <= to
+}
+
+// instances returns the instances that are relevant for the report range,
+// oldest first: instances issued up to the end of the range, except those
+// that expired or were revoked before its start. Instances revoked in the
+// range are always included.
+func (s *TrustMarkReportsStorage) instances(filter model.TrustMarkReportFilter) ([]reportInstance, error) {
+	from := filter.From.Unix()
+	query := s.db.Model(&model.IssuedTrustMarkInstance{}).
+		Select("trust_mark_type, subject, created_at, updated_at, expires_at, revoked").
+		Where("created_at <= ?", filter.To.Unix()).
+		Where("NOT (revoked = ? AND updated_at < ?)", true, from).
+		Where("(expires_at = 0 OR expires_at > ? OR revoked = ?)", from, true)
+	if filter.TrustMarkType != "" {
+		query = query.Where("trust_mark_type = ?", filter.TrustMarkType)
+	}
+	var instances []reportInstance
+	if err := query.Order("created_at ASC").Find(&instances).Error; err != nil {
+		return nil, errors.Wrap(err, "issued_trust_mark_instances: report query failed")
+	}
+	return instances, nil
+}
+
+// previouslyIssued returns per trust mark type the subjects that were issued
+// a trust mark in the report range and already before it.
+func (s *TrustMarkReportsStorage) previouslyIssued(filter model.TrustMarkReportFilter) (
+	map[string]map[string]bool, error,
+) {
+	from, to := filter.From.Unix(), filter.To.Unix()
+	inRange := s.db.Table("issued_trust_mark_instances AS j").
+		Select("1").
+		Where("j.trust_mark_type = i.trust_mark_type AND j.subject = i.subject").
+		Where("j.created_at BETWEEN ? AND ?", from, to)
+	query := s.db.Table("issued_trust_mark_instances AS i").
+		Distinct("i.trust_mark_type", "i.subject").
+		Where("i.created_at < ?", from).
+		Where("EXISTS (?)", inRange)
+	if filter.TrustMarkType != "" {
+		query = query.Where("i.trust_mark_type = ?", filter.TrustMarkType)
+	}
+	var rows []struct {
+		TrustMarkType string
+		Subject       string
+	}
+	if err := query.Find(&rows).Error; err != nil {
+		return nil, errors.Wrap(err, "issued_trust_mark_instances: report query failed")
+	}
+	issued := make(map[string]map[string]bool)
+	for _, row := range rows {
+		if issued[row.TrustMarkType] == nil {
+			issued[row.TrustMarkType] = make(map[string]bool)
+		}
+		issued[row.TrustMarkType][row.Subject] = true
+	}
+	return issued, nil
+}
+
+// periodStart returns the UTC start of the period containing t.
+func periodStart(t time.Time, interval stats.Interval) time.Time {
+	t = t.UTC()
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	switch interval {
+	case stats.IntervalWeek:
+		return day.AddDate(0, 0, -((int(day.Weekday()) + 6) % 7))
+	case stats.IntervalMonth:
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+	default:
+		return day
+	}
+}
+
+// nextPeriod returns the start of the period following the one starting at
+// start.
+func nextPeriod(start time.Time, interval stats.Interval) time.Time {
+	switch interval {
+	case stats.IntervalWeek:
+		return start.AddDate(0, 0, 7)
+	case stats.IntervalMonth:
+		return start.AddDate(0, 1, 0)
+	default:
+		return start.AddDate(0, 0, 1)
+	}
+}
+
+type reportKey struct {
+	period        time.Time
+	trustMarkType string
+}
+
+// reportPeriods returns the starts of the periods of the report range. At
+// most model.MaxTrustMarkReportPeriods periods are allowed.
+func reportPeriods(filter model.TrustMarkReportFilter) ([]time.Time, error) {
+	var periods []time.Time
+	for start := periodStart(filter.From, filter.Interval); !start.After(filter.To); start = nextPeriod(
+		start, filter.Interval,
+	) {
+		if len(periods) == model.MaxTrustMarkReportPeriods {
+			return nil, model.ValidationErrorFmt(
+				"the report range must not cover more than %d periods", model.MaxTrustMarkReportPeriods,
+			)
+		}
+		periods = append(periods, start)
+	}
+	return periods, nil
+}
+
+// activeHolders returns per trust mark type the number of distinct subjects
+// holding an active trust mark at each of the passed, ascending times. The
+// instances are swept once: each instance is active in a contiguous range of
+// times, the ranges are merged per subject and counted with a difference
+// array per type.
+func activeHolders(instances []reportInstance, times []int64) map[string][]int64 {
+	type subjectKey struct {
+		trustMarkType string
+		subject       string
+	}
+	type timeRange struct{ lo, hi int }
+	ranges := make(map[subjectKey][]timeRange)
+	for _, instance := range instances {
+		lo, _ := slices.BinarySearch(times, instance.CreatedAt)
+		end := int64(math.MaxInt64)
+		if instance.ExpiresAt != 0 {
+			end = instance.ExpiresAt
+		}
+		if instance.Revoked {
+			end = min(end, instance.UpdatedAt)
+		}
+		hi, _ := slices.BinarySearch(times, end)
+		if lo >= hi {
+			continue
+		}
+		key := subjectKey{
+			trustMarkType: instance.TrustMarkType,
+			subject:       instance.Subject,
+		}
+		ranges[key] = append(ranges[key], timeRange{lo: lo, hi: hi})
+	}
+	counts := make(map[string][]int64)
+	for key, rs := range ranges {
+		diff, ok := counts[key.trustMarkType]
+		if !ok {
+			diff = make([]int64, len(times)+1)
+			counts[key.trustMarkType] = diff
+		}
+		slices.SortFunc(rs, func(a, b timeRange) int { return cmp.Compare(a.lo, b.lo) })
+		current := rs[0]
+		for _, r := range rs[1:] {
+			if r.lo <= current.hi {
+				current.hi = max(current.hi, r.hi)
+				continue
+			}
+			diff[current.lo]++
+			diff[current.hi]--
+			current = r
+		}
+		diff[current.lo]++
+		diff[current.hi]--
+	}
+	for _, diff := range counts {
+		for i := 1; i < len(diff); i++ {
+			diff[i] += diff[i-1]
+		}
+	}
+	return counts
+}
+
+// Report returns the aggregated issuance data per trust mark type and period,
+// ordered by period and type. Every trust mark type with issued instances or
+// counters in the range is reported for every period.
+func (s *TrustMarkReportsStorage) Report(filter model.TrustMarkReportFilter) ([]model.TrustMarkReportEntry, error) {
+	if filter.To.Before(filter.From) {
+		return nil, model.ValidationError("'to' must not be before 'from'")
+	}
+	periods, err := reportPeriods(filter)
+	if err != nil {
+		return nil, err
+	}
+	instances, err := s.instances(filter)
+	if err != nil {
+		return nil, err
+	}
+	previous, err := s.previouslyIssued(filter)
+	if err != nil {
+		return nil, err
+	}
+	counterQuery := s.db.Model(&model.TrustMarkIssuanceCounter{}).
+		Where(
+			"day BETWEEN ? AND ?",
+			filter.From.UTC().Format(model.TrustMarkReportDayFormat), filter.To.UTC().Format(model.TrustMarkReportDayFormat),
+		)
+	if filter.TrustMarkType != "" {
+		counterQuery = counterQuery.Where("trust_mark_type = ?", filter.TrustMarkType)
+	}
+	var counters []model.TrustMarkIssuanceCounter
+	if err = counterQuery.Find(&counters).Error; err != nil {
+		return nil, errors.Wrap(err, "trust_mark_issuance_counters: report query failed")
+	}
+
+	from, to := filter.From.Unix(), filter.To.Unix()
+	entries := make(map[reportKey]*model.TrustMarkReportEntry)
+	entry := func(t time.Time, trustMarkType string) *model.TrustMarkReportEntry {
+		key := reportKey{
+			period:        periodStart(t, filter.Interval),
+			trustMarkType: trustMarkType,
+		}
+		e, ok := entries[key]
+		if !ok {
+			e = &model.TrustMarkReportEntry{
+				Period:        key.period,
+				TrustMarkType: trustMarkType,
+			}
+			entries[key] = e
+		}
+		return e
+	}
+
+	var types []string
+	seen := make(map[string]map[string]bool)
+	for _, instance := range instances {
+		subjects, ok := seen[instance.TrustMarkType]
+		if !ok {
+			subjects = make(map[string]bool)
+			maps.Copy(subjects, previous[instance.TrustMarkType])
+			seen[instance.TrustMarkType] = subjects
+			types = append(types, instance.TrustMarkType)
+		}
+		if instance.CreatedAt >= from {
+			e := entry(time.Unix(instance.CreatedAt, 0), instance.TrustMarkType)
+			e.Issued++
+			if subjects[instance.Subject] {
+				e.Reissued++
+			}
+		}
+		subjects[instance.Subject] = true
+		if instance.revokedIn(from, to) {
+			entry(time.Unix(instance.UpdatedAt, 0), instance.TrustMarkType).Revoked++
+		}
+	}
+	for _, counter := range counters {
+		day, err := time.Parse(model.TrustMarkReportDayFormat, counter.Day)
+		if err != nil {
+			continue
+		}
+		if _, ok := seen[counter.TrustMarkType]; !ok {
+			seen[counter.TrustMarkType] = make(map[string]bool)
+			types = append(types, counter.TrustMarkType)
+		}
+		e := entry(day, counter.TrustMarkType)
+		e.CacheHits += counter.CacheHits
+		e.EligibilityDenials += counter.EligibilityDenials
+	}
+	slices.Sort(types)
+
+	ends := make([]int64, len(periods))
+	for i, start := range periods {
+		ends[i] = min(nextPeriod(start, filter.Interval).Unix()-1, to, time.Now().Unix())
+	}
+	holders := activeHolders(instances, ends)
+	report := make([]model.TrustMarkReportEntry, 0, len(periods)*len(types))
+	for i, start := range periods {
+		for _, trustMarkType := range types {
+			e := entry(start, trustMarkType)
+			if counts, ok := holders[trustMarkType]; ok {
+				e.ActiveHolders = counts[i]
+			}
+			report = append(report, *e)
+		}
+	}
+	return report, nil
+}
+
+// Holders returns the subjects that were issued or had revoked a trust mark
+// in the report range, ordered by type and subject.
+func (s *TrustMarkReportsStorage) Holders(filter model.TrustMarkReportFilter) ([]model.TrustMarkHolderReport, error) {
+	if filter.To.Before(filter.From) {
+		return nil, model.ValidationError("'to' must not be before 'from'")
+	}
+	instances, err := s.instances(filter)
+	if err != nil {
+		return nil, err
+	}
+	from, to := filter.From.Unix(), filter.To.Unix()
+	end := min(to, time.Now().Unix())
+	type holderKey struct {
+		trustMarkType string
+		subject       string
+	}
+	holders := make(map[holderKey]*model.TrustMarkHolderReport)
+	active := make(map[holderKey]bool)
+	for _, instance := range instances {
+		key := holderKey{
+			trustMarkType: instance.TrustMarkType,
+			subject:       instance.Subject,
+		}
+		if instance.activeAt(end) {
+			active[key] = true
+		}
+		issued := instance.CreatedAt >= from
+		revoked := instance.revokedIn(from, to)
+		if !issued && !revoked {
+			continue
+		}
+		h, ok := holders[key]
+		if !ok {
+			h = &model.TrustMarkHolderReport{
+				TrustMarkType: instance.TrustMarkType,
+				Subject:       instance.Subject,
+			}
+			holders[key] = h
+		}
+		if issued {
+			h.Issued++
+			if h.FirstIssuedAt == 0 {
+				h.FirstIssuedAt = instance.CreatedAt
+			}
+			h.LastIssuedAt = instance.CreatedAt
+		}
+		if revoked {
+			h.Revoked++
+		}
+	}
+	report := make([]model.TrustMarkHolderReport, 0, len(holders))
+	for key, h := range holders {
+		h.Active = active[key]
+		report = append(report, *h)
+	}
+	slices.SortFunc(
+		report, func(a, b model.TrustMarkHolderReport) int {
+			return cmp.Or(cmp.Compare(a.TrustMarkType, b.TrustMarkType), cmp.Compare(a.Subject, b.Subject))
+		},
+	)
+	return report, nil
+}
+
+// ExportCSV writes the report as CSV.
+func (s *TrustMarkReportsStorage) ExportCSV(filter model.TrustMarkReportFilter, w io.Writer) error {
+	report, err := s.Report(filter)
+	if err != nil {
+		return err
+	}
+	writer := csv.NewWriter(w)
+	defer writer.Flush()
+
+	header := []string{
+		"period", "trust_mark_type", "issued", "reissued", "revoked",
+		"cache_hits", "eligibility_denials", "active_holders",
+	}
+	if err = writer.Write(header); err != nil {
+		return err
+	}
+	for _, e := range report {
+		record := []string{
+			e.Period.Format(time.RFC3339),
+			e.TrustMarkType,
+			strconv.FormatInt(e.Issued, 10),
+			strconv.FormatInt(e.Reissued, 10),
+			strconv.FormatInt(e.Revoked, 10),
+			strconv.FormatInt(e.CacheHits, 10),
+			strconv.FormatInt(e.EligibilityDenials, 10),
+			strconv.FormatInt(e.ActiveHolders, 10),
+		}
+		if err = writer.Write(record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ExportJSON writes the report as newline-delimited JSON.
+func (s *TrustMarkReportsStorage) ExportJSON(filter model.TrustMarkReportFilter, w io.Writer) error {
+	report, err := s.Report(filter)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(w)
+	for _, e := range report {
+		if err = encoder.Encode(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,225 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/csv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/go-oidfed/lighthouse/internal/stats"
+	"github.com/go-oidfed/lighthouse/storage/model"
+)
+
+const (
+	reportTypeA = "https://tm.example.org/a"
+	reportTypeB = "https://tm.example.org/b"
+)
+
+func newTestTrustMarkReportsStorage(t *testing.T) *TrustMarkReportsStorage {
+	t.Helper()
+	db := newTestDB(t)
+	require.NoError(t, db.AutoMigrate(&model.IssuedTrustMarkInstance{}, &model.TrustMarkIssuanceCounter{}))
+	return NewTrustMarkReportsStorage(db)
+}
+
+func insertReportInstance(
+	t *testing.T, s *TrustMarkReportsStorage, jti, trustMarkType, subject string, issued time.Time,
+	revokedAt *time.Time,
+) {
+	t.Helper()
+	instance := &model.IssuedTrustMarkInstance{
+		JTI:           jti,
+		CreatedAt:     int(issued.Unix()),
+		UpdatedAt:     int(issued.Unix()),
+		TrustMarkType: trustMarkType,
+		Subject:       subject,
+	}
+	if revokedAt != nil {
+		instance.Revoked = true
+		instance.UpdatedAt = int(revokedAt.Unix())
+	}
+	require.NoError(t, s.db.Create(instance).Error)
+}
+
+func TestTrustMarkReport_Report(t *testing.T) {
+	s := newTestTrustMarkReportsStorage(t)
+	day1 := time.Date(2025, 3, 3, 10, 0, 0, 0, time.UTC)
+	day2 := day1.AddDate(0, 0, 1)
+	revoked := day2.Add(2 * time.Hour)
+
+	// Issued before the report range; counts as active holder only
+	insertReportInstance(t, s, "old", reportTypeA, "https://rp1.example.org", day1.AddDate(0, 0, -10), nil)
+	insertReportInstance(t, s, "a1", reportTypeA, "https://rp1.example.org", day1, nil)
+	insertReportInstance(t, s, "a2", reportTypeA, "https://rp2.example.org", day1, &revoked)
+	insertReportInstance(t, s, "a3", reportTypeA, "https://rp3.example.org", day2, nil)
+	insertReportInstance(t, s, "b1", reportTypeB, "https://rp1.example.org", day2, nil)
+	require.NoError(
+		t, s.db.Create(
+			&model.TrustMarkIssuanceCounter{
+				Day:                day2.Format("2006-01-02"),
+				TrustMarkType:      reportTypeA,
+				CacheHits:          5,
+				EligibilityDenials: 2,
+			},
+		).Error,
+	)
+
+	filter := model.TrustMarkReportFilter{
+		From:     time.Date(2025, 3, 3, 0, 0, 0, 0, time.UTC),
+		To:       time.Date(2025, 3, 4, 23, 59, 59, 0, time.UTC),
+		Interval: stats.IntervalDay,
+	}
+	report, err := s.Report(filter)
+	require.NoError(t, err)
+	require.Len(t, report, 4)
+
+	a1, b1, a2, b2 := report[0], report[1], report[2], report[3]
+	assert.Equal(t, reportTypeA, a1.TrustMarkType)
+	assert.Equal(t, time.Date(2025, 3, 3, 0, 0, 0, 0, time.UTC), a1.Period)
+	assert.Equal(t, int64(2), a1.Issued)
+	assert.Equal(t, int64(1), a1.Reissued)
+	assert.Equal(t, int64(2), a1.ActiveHolders)
+	assert.Equal(t, int64(0), b1.Issued)
+	assert.Equal(t, int64(0), b1.ActiveHolders)
+
+	assert.Equal(t, int64(1), a2.Issued)
+	assert.Equal(t, int64(0), a2.Reissued)
+	assert.Equal(t, int64(1), a2.Revoked)
+	assert.Equal(t, int64(5), a2.CacheHits)
+	assert.Equal(t, int64(2), a2.EligibilityDenials)
+	assert.Equal(t, int64(2), a2.ActiveHolders)
+	assert.Equal(t, int64(1), b2.Issued)
+	assert.Equal(t, int64(1), b2.ActiveHolders)
+
+	filter.Interval = stats.IntervalMonth
+	filter.TrustMarkType = reportTypeA
+	report, err = s.Report(filter)
+	require.NoError(t, err)
+	require.Len(t, report, 1)
+	assert.Equal(t, time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC), report[0].Period)
+	assert.Equal(t, int64(3), report[0].Issued)
+	assert.Equal(t, int64(1), report[0].Revoked)
+
+	var buf bytes.Buffer
+	require.NoError(t, s.ExportCSV(filter, &buf))
+	records, err := csv.NewReader(&buf).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+	assert.Equal(t, []string{"2025-03-01T00:00:00Z", reportTypeA, "3", "1", "1", "5", "2", "2"}, records[1])
+}
+
+func TestTrustMarkReport_ReportHistory(t *testing.T) {
+	s := newTestTrustMarkReportsStorage(t)
+	day := time.Date(2025, 3, 3, 10, 0, 0, 0, time.UTC)
+	longAgo := day.AddDate(-2, 0, 0)
+	revokedLongAgo := longAgo.Add(time.Hour)
+
+	// Expired and revoked before the range; only relevant for re-issuances
+	expired := &model.IssuedTrustMarkInstance{
+		JTI:           "expired",
+		CreatedAt:     int(longAgo.Unix()),
+		UpdatedAt:     int(longAgo.Unix()),
+		ExpiresAt:     int(longAgo.AddDate(0, 1, 0).Unix()),
+		TrustMarkType: reportTypeA,
+		Subject:       "https://rp1.example.org",
+	}
+	require.NoError(t, s.db.Create(expired).Error)
+	insertReportInstance(t, s, "revoked", reportTypeA, "https://rp2.example.org", longAgo, &revokedLongAgo)
+	insertReportInstance(t, s, "a1", reportTypeA, "https://rp1.example.org", day, nil)
+	insertReportInstance(t, s, "a2", reportTypeA, "https://rp3.example.org", day, nil)
+	// Two overlapping instances of the same subject count as one holder
+	insertReportInstance(t, s, "a3", reportTypeA, "https://rp3.example.org", day.Add(time.Hour), nil)
+
+	filter := model.TrustMarkReportFilter{
+		From:     time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC),
+		To:       time.Date(2025, 3, 5, 23, 59, 59, 0, time.UTC),
+		Interval: stats.IntervalDay,
+	}
+	instances, err := s.instances(filter)
+	require.NoError(t, err)
+	assert.Len(t, instances, 3)
+
+	report, err := s.Report(filter)
+	require.NoError(t, err)
+	require.Len(t, report, 5)
+	assert.Equal(t, int64(0), report[1].ActiveHolders)
+	assert.Equal(t, int64(3), report[2].Issued)
+	assert.Equal(t, int64(2), report[2].Reissued)
+	for _, e := range report[2:] {
+		assert.Equal(t, int64(2), e.ActiveHolders)
+	}
+
+	// The number of periods is limited
+	filter.From = filter.To.AddDate(0, 0, -model.MaxTrustMarkReportPeriods)
+	_, err = s.Report(filter)
+	var validationErr model.ValidationError
+	assert.ErrorAs(t, err, &validationErr)
+	filter.Interval = stats.IntervalMonth
+	_, err = s.Report(filter)
+	assert.NoError(t, err)
+}
+
+func TestTrustMarkReport_Holders(t *testing.T) {
+	s := newTestTrustMarkReportsStorage(t)
+	day := time.Date(2025, 3, 3, 10, 0, 0, 0, time.UTC)
+	revoked := day.Add(time.Hour)
+	insertReportInstance(t, s, "a1", reportTypeA, "https://rp1.example.org", day, nil)
+	insertReportInstance(t, s, "a2", reportTypeA, "https://rp1.example.org", day.Add(time.Minute), nil)
+	insertReportInstance(t, s, "a3", reportTypeA, "https://rp2.example.org", day, &revoked)
+	insertReportInstance(t, s, "a4", reportTypeA, "https://rp3.example.org", day.AddDate(0, 0, -10), nil)
+
+	holders, err := s.Holders(
+		model.TrustMarkReportFilter{
+			From: time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC),
+			To:   time.Date(2025, 3, 31, 0, 0, 0, 0, time.UTC),
+		},
+	)
+	require.NoError(t, err)
+	require.Len(t, holders, 2)
+	assert.Equal(t, "https://rp1.example.org", holders[0].Subject)
+	assert.Equal(t, int64(2), holders[0].Issued)
+	assert.Equal(t, day.Unix(), holders[0].FirstIssuedAt)
+	assert.Equal(t, day.Add(time.Minute).Unix(), holders[0].LastIssuedAt)
+	assert.True(t, holders[0].Active)
+	assert.Equal(t, "https://rp2.example.org", holders[1].Subject)
+	assert.Equal(t, int64(1), holders[1].Revoked)
+	assert.False(t, holders[1].Active)
+}
+
+func TestTrustMarkReport_AddCounters(t *testing.T) {
+	s := newTestTrustMarkReportsStorage(t)
+	day := time.Now().UTC().Format("2006-01-02")
+	require.NoError(
+		t, s.AddCounters(
+			[]model.TrustMarkIssuanceCounter{
+				{
+					Day:           day,
+					TrustMarkType: reportTypeA,
+					CacheHits:     2,
+				},
+			},
+		),
+	)
+	require.NoError(
+		t, s.AddCounters(
+			[]model.TrustMarkIssuanceCounter{
+				{
+					Day:                day,
+					TrustMarkType:      reportTypeA,
+					CacheHits:          1,
+					EligibilityDenials: 1,
+				},
+			},
+		),
+	)
+	require.Error(t, s.AddCounters([]model.TrustMarkIssuanceCounter{{TrustMarkType: reportTypeA}}))
+
+	var counter model.TrustMarkIssuanceCounter
+	require.NoError(t, s.db.First(&counter).Error)
+	assert.Equal(t, day, counter.Day)
+	assert.Equal(t, int64(3), counter.CacheHits)
+	assert.Equal(t, int64(1), counter.EligibilityDenials)
+}
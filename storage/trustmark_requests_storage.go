@@ -0,0 +1,139 @@
+package storage
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+
+	"github.com/go-oidfed/lighthouse/storage/model"
+)
+
+// TrustMarkRequestsStorage implements the TrustMarkRequestStore interface
+// using GORM.
+type TrustMarkRequestsStorage struct {
+	db *gorm.DB
+}
+
+// NewTrustMarkRequestsStorage creates a new TrustMarkRequestsStorage.
+func NewTrustMarkRequestsStorage(db *gorm.DB) *TrustMarkRequestsStorage {
+	return &TrustMarkRequestsStorage{db: db}
+}
+
+// Create stores a new request.
+func (s *TrustMarkRequestsStorage) Create(request *model.TrustMarkRequest) error {
+	if request.Status == "" {
+		request.Status = model.TrustMarkRequestStatusPending
+	}
+	if err := s.db.Create(request).Error; err != nil {
+		return errors.Wrap(err, "trust_mark_requests: create failed")
+	}
+	return nil
+}
+
+// Get returns a request including its comments.
+func (s *TrustMarkRequestsStorage) Get(id uint) (*model.TrustMarkRequest, error) {
+	var request model.TrustMarkRequest
+	if err := s.db.Preload(
+		"Comments", func(db *gorm.DB) *gorm.DB {
+			return db.Order("id ASC")
+		},
+	).First(&request, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, model.NotFoundError("trust mark request not found")
+		}
+		return nil, errors.Wrap(err, "trust_mark_requests: get failed")
+	}
+	return &request, nil
+}
+
+// List returns the requests matching the filter, newest first. Comments are
+// not loaded.
+func (s *TrustMarkRequestsStorage) List(filter model.TrustMarkRequestFilter) ([]model.TrustMarkRequest, error) {
+	query := s.db.Model(&model.TrustMarkRequest{})
+	if filter.TrustMarkType != "" {
+		query = query.Where("trust_mark_type = ?", filter.TrustMarkType)
+	}
+	if filter.EntityID != "" {
+		query = query.Where("entity_id = ?", filter.EntityID)
+	}
+	if filter.Status != "" {
+		query = query.Where("status = ?", filter.Status)
+	}
+	var requests []model.TrustMarkRequest
+	if err := query.Order("id DESC").Find(&requests).Error; err != nil {
+		return nil, errors.Wrap(err, "trust_mark_requests: list failed")
+	}
+	return requests, nil
+}
+
+// Latest returns the newest request for a trust mark type and entity, or nil
+// if there is none.
+func (s *TrustMarkRequestsStorage) Latest(trustMarkType, entityID string) (*model.TrustMarkRequest, error) {
+	var request model.TrustMarkRequest
+	err := s.db.Where("trust_mark_type = ? AND entity_id = ?", trustMarkType, entityID).
+		Order("id DESC").
+		First(&request).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, errors.Wrap(err, "trust_mark_requests: get latest failed")
+	}
+	return &request, nil
+}
+
+// UpdateEvidence replaces the evidence and attachments of a request.
+func (s *TrustMarkRequestsStorage) UpdateEvidence(id uint, evidence map[string]any, attachments []string) error {
+	request, err := s.Get(id)
+	if err != nil {
+		return err
+	}
+	request.Evidence = evidence
+	request.Attachments = attachments
+	if err = s.db.Omit("Comments").Save(request).Error; err != nil {
+		return errors.Wrap(err, "trust_mark_requests: update evidence failed")
+	}
+	return nil
+}
+
+// AddComment adds a reviewer comment to a request.
+func (s *TrustMarkRequestsStorage) AddComment(id uint, actor, comment string) (*model.TrustMarkRequestComment, error) {
+	if _, err := s.Get(id); err != nil {
+		return nil, err
+	}
+	c := &model.TrustMarkRequestComment{
+		TrustMarkRequestID: id,
+		Actor:              actor,
+		Comment:            comment,
+	}
+	if err := s.db.Create(c).Error; err != nil {
+		return nil, errors.Wrap(err, "trust_mark_requests: add comment failed")
+	}
+	return c, nil
+}
+
+// Decide approves or rejects a pending request. A model.ValidationError is
+// returned if the request was already decided.
+func (s *TrustMarkRequestsStorage) Decide(
+	id uint, status model.TrustMarkRequestStatus, reason, actor string,
+) (*model.TrustMarkRequest, error) {
+	if status != model.TrustMarkRequestStatusApproved && status != model.TrustMarkRequestStatusRejected {
+		return nil, model.ValidationErrorFmt("invalid decision '%s'", status)
+	}
+	request, err := s.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	if request.Status != model.TrustMarkRequestStatusPending {
+		return nil, model.ValidationErrorFmt("trust mark request is already %s", request.Status)
+	}
+	request.Status = status
+	request.DecisionReason = reason
+	request.DecidedBy = actor
+	request.DecidedAt = time.Now().Unix()
+	if err = s.db.Omit("Comments").Save(request).Error; err != nil {
+		return nil, errors.Wrap(err, "trust_mark_requests: decide failed")
+	}
+	return request, nil
+}
@@ -0,0 +1,85 @@
+package storage
+
+import (
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+
+	"github.com/go-oidfed/lighthouse/storage/model"
+)
+
+// TrustMarkSubjectEventsStorage implements the TrustMarkSubjectEventStore
+// interface using GORM.
+type TrustMarkSubjectEventsStorage struct {
+	db *gorm.DB
+}
+
+// NewTrustMarkSubjectEventsStorage creates a new TrustMarkSubjectEventsStorage.
+func NewTrustMarkSubjectEventsStorage(db *gorm.DB) *TrustMarkSubjectEventsStorage {
+	return &TrustMarkSubjectEventsStorage{db: db}
+}
+
+// TrustMarkSubjectEventsStorage returns a TrustMarkSubjectEventsStorage
+func (s *Storage) TrustMarkSubjectEventsStorage() *TrustMarkSubjectEventsStorage {
+	return NewTrustMarkSubjectEventsStorage(s.db)
+}
+
+// Add creates a new event record.
+func (s *TrustMarkSubjectEventsStorage) Add(event model.TrustMarkSubjectEvent) error {
+	if err := s.db.Create(&event).Error; err != nil {
+		return errors.Wrap(err, "trust_mark_subject_events: failed to create event")
+	}
+	return nil
+}
+
+// GetBySubjectID returns events for a trust mark subject with optional
+// filtering and pagination.
+// Returns the events, total count (for pagination), and any error.
+func (s *TrustMarkSubjectEventsStorage) GetBySubjectID(
+	subjectID uint, opts model.EventQueryOpts,
+) ([]model.TrustMarkSubjectEvent, int64, error) {
+	limit := opts.Limit
+	if limit <= 0 {
+		limit = 50
+	}
+	if limit > 100 {
+		limit = 100
+	}
+
+	offset := max(opts.Offset, 0)
+
+	query := s.db.Model(&model.TrustMarkSubjectEvent{}).Where("trust_mark_subject_id = ?", subjectID)
+
+	if opts.EventType != nil && *opts.EventType != "" {
+		query = query.Where("type = ?", *opts.EventType)
+	}
+	if opts.FromTime != nil {
+		query = query.Where("timestamp >= ?", *opts.FromTime)
+	}
+	if opts.ToTime != nil {
+		query = query.Where("timestamp <= ?", *opts.ToTime)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, errors.Wrap(err, "trust_mark_subject_events: failed to count events")
+	}
+
+	var events []model.TrustMarkSubjectEvent
+	if err := query.Order("timestamp DESC, id DESC").
+		Limit(limit).
+		Offset(offset).
+		Find(&events).Error; err != nil {
+		return nil, 0, errors.Wrap(err, "trust_mark_subject_events: failed to get events")
+	}
+
+	return events, total, nil
+}
+
+// DeleteBySubjectID removes all events for a trust mark subject.
+func (s *TrustMarkSubjectEventsStorage) DeleteBySubjectID(subjectID uint) error {
+	if err := s.db.Where("trust_mark_subject_id = ?", subjectID).
+		Delete(&model.TrustMarkSubjectEvent{}).Error; err != nil {
+		return errors.Wrap(err, "trust_mark_subject_events: failed to delete events")
+	}
+	return nil
+}
@@ -2,10 +2,10 @@ package lighthouse
 
 import (
 	"strings"
+	"sync"
 	"time"
 
 	oidfed "github.com/go-oidfed/lib"
-	"github.com/go-oidfed/lib/cache"
 	"github.com/go-oidfed/lib/jwx"
 	"github.com/pkg/errors"
 	"github.com/rs/zerolog/log"
@@ -33,16 +33,26 @@ var (
 type subordinateJWKSRefreshStorage struct {
 	store      model.SubordinateStorageBackend
 	eventStore model.SubordinateEventStore
+	policy     *JWKSPolicy
+
+	// rejected holds the last policy violation per entity, so that a JWKS
+	// that is rejected on every poll is only recorded once.
+	rejectedMu sync.Mutex
+	rejected   map[string]string
 }
 
 // NewSubordinateJWKSRefreshStorage creates an adapter over the subordinate
 // storage backend that also records JWKSRefreshed events when the JWKS change.
+// If policy is not nil, new JWKS that violate it are rejected and recorded as
+// JWKSRejected events.
 func NewSubordinateJWKSRefreshStorage(
-	store model.SubordinateStorageBackend, eventStore model.SubordinateEventStore,
+	store model.SubordinateStorageBackend, eventStore model.SubordinateEventStore, policy *JWKSPolicy,
 ) oidfed.SubordinateJWKSRefreshStorage {
 	return &subordinateJWKSRefreshStorage{
 		store:      store,
 		eventStore: eventStore,
+		policy:     policy,
+		rejected:   make(map[string]string),
 	}
 }
 
@@ -93,10 +103,17 @@ func pollIntervalPtr(v int64) *int64 {
 }
 
 func (a *subordinateJWKSRefreshStorage) UpdateJWKS(entityID string, jwks jwx.JWKS) error {
+	if err := checkJWKSPolicy(a.policy, jwks); err != nil {
+		a.recordRejected(entityID, err)
+		return err
+	}
+	a.rejectedMu.Lock()
+	delete(a.rejected, entityID)
+	a.rejectedMu.Unlock()
 	if err := a.store.UpdateJWKSByEntityID(entityID, model.NewJWKS(jwks)); err != nil {
 		return err
 	}
-	_ = cache.Delete(internal.SubordinateStatementCacheKey(entityID))
+	_ = internal.DeleteCache(internal.SubordinateStatementCacheKey(entityID))
 	if a.eventStore != nil {
 		info, err := a.store.Get(entityID)
 		if err != nil || info == nil {
@@ -115,12 +132,31 @@ func (a *subordinateJWKSRefreshStorage) UpdateJWKS(entityID string, jwks jwx.JWK
 	return nil
 }
 
+// recordRejected records a JWKSRejected event for the passed entity unless the
+// same violation was already recorded for it.
+func (a *subordinateJWKSRefreshStorage) recordRejected(entityID string, err error) {
+	a.rejectedMu.Lock()
+	if a.rejected[entityID] == err.Error() {
+		a.rejectedMu.Unlock()
+		return
+	}
+	a.rejected[entityID] = err.Error()
+	a.rejectedMu.Unlock()
+	log.Warn().Err(err).Str("entity_id", entityID).Msg("rejected refreshed subordinate JWKS")
+	info, getErr := a.store.Get(entityID)
+	if getErr != nil || info == nil {
+		return
+	}
+	recordJWKSRejected(a.eventStore, info.ID, nil, err)
+}
+
 // RefreshSubordinateJWKSFromEC fetches the subordinate's Entity Configuration
 // and updates the stored JWKS if it changed. It returns whether the JWKS changed.
 //
 // The subordinate must exist and have a status of Active or Pending (not
 // Blocked or Inactive). The EC signature is verified against the currently
-// stored JWKS.
+// stored JWKS. If a JWKS policy is set and the new JWKS violates it, a
+// *JWKSPolicyError is returned and the stored JWKS is kept.
 func (fed *LightHouse) RefreshSubordinateJWKSFromEC(entityID string) (changed bool, err error) {
 	store := fed.storages.Subordinates
 	if store == nil {
@@ -161,11 +197,15 @@ func (fed *LightHouse) RefreshSubordinateJWKSFromEC(entityID string) (changed bo
 	if !changed {
 		return false, nil
 	}
+	if err = checkJWKSPolicy(fed.jwksPolicy, ec.JWKS); err != nil {
+		recordJWKSRejected(fed.storages.SubordinateEvents, info.ID, nil, err)
+		return false, err
+	}
 
 	if err := store.UpdateJWKSByEntityID(entityID, model.NewJWKS(ec.JWKS)); err != nil {
 		return false, errors.Wrap(err, "failed to update stored JWKS")
 	}
-	_ = cache.Delete(internal.SubordinateStatementCacheKey(entityID))
+	_ = internal.DeleteCache(internal.SubordinateStatementCacheKey(entityID))
 	if fed.storages.SubordinateEvents != nil {
 		if err := fed.storages.SubordinateEvents.Add(
 			model.SubordinateEvent{
@@ -221,10 +261,11 @@ func addedRemovedMsg(added, removed []string) string {
 
 // SetupSubordinateJWKSRefresher builds and starts the subordinate JWKS
 // refresher from storage. The returned refresher must be stopped on shutdown.
+// If policy is not nil, refreshed JWKS must satisfy it.
 func SetupSubordinateJWKSRefresher(
-	store model.SubordinateStorageBackend, eventStore model.SubordinateEventStore,
+	store model.SubordinateStorageBackend, eventStore model.SubordinateEventStore, policy *JWKSPolicy,
 ) (*oidfed.SubordinateJWKSRefresher, error) {
-	adapter := NewSubordinateJWKSRefreshStorage(store, eventStore)
+	adapter := NewSubordinateJWKSRefreshStorage(store, eventStore, policy)
 	refresher, err := oidfed.NewSubordinateJWKSRefresher(adapter, oidfed.GetEntityConfiguration)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to create subordinate JWKS refresher")
@@ -89,7 +89,7 @@ func TestSubordinateJWKSRefreshStorage_Adapter(t *testing.T) {
 		),
 	)
 
-	adapter := NewSubordinateJWKSRefreshStorage(subStore, eventStore)
+	adapter := NewSubordinateJWKSRefreshStorage(subStore, eventStore, nil)
 
 	listed, err := adapter.ListEnabled()
 	require.NoError(t, err)
@@ -282,7 +282,7 @@ func TestSubordinateJWKSRefreshStorage_UpdateJWKS_InvalidatesCache(t *testing.T)
 	require.True(t, set)
 
 	// Update JWKS via the adapter — should invalidate the cache entry.
-	adapter := NewSubordinateJWKSRefreshStorage(subStore, eventStore)
+	adapter := NewSubordinateJWKSRefreshStorage(subStore, eventStore, nil)
 	newKeys := jwksWithKid(t, "new-kid")
 	require.NoError(t, adapter.UpdateJWKS(entityID, newKeys))
 
@@ -1,10 +1,14 @@
 package lighthouse
 
 import (
+	"context"
+	"encoding/json"
+	"maps"
 	"time"
 
 	"github.com/go-oidfed/lib/jwx"
 	"github.com/go-oidfed/lib/oidfedconst"
+	"github.com/go-oidfed/lib/unixtime"
 	"github.com/gofiber/fiber/v2"
 	"github.com/google/uuid"
 	"github.com/pkg/errors"
@@ -12,6 +16,8 @@ import (
 
 	oidfed "github.com/go-oidfed/lib"
 
+	"github.com/go-oidfed/lighthouse/internal/claimtemplate"
+	"github.com/go-oidfed/lighthouse/internal/metadataschema"
 	"github.com/go-oidfed/lighthouse/middleware"
 	"github.com/go-oidfed/lighthouse/storage/model"
 )
@@ -40,6 +46,12 @@ type TrustMarkEndpointConfig struct {
 	// IssuedTrustMarkCache caches issued trust mark JWTs to avoid repeated signing.
 	// The TTL is configured per trust mark type via the TrustMarkSpec.CacheTTL field.
 	IssuedTrustMarkCache *IssuedTrustMarkCache
+	// CheckTimeout is the overall time budget for the eligibility checks of
+	// a request; if not positive, DefaultEntityCheckTimeout is used.
+	CheckTimeout time.Duration
+	// ReportCounters counts cache hits and eligibility denials for trust mark
+	// issuance reports; optional.
+	ReportCounters *TrustMarkReportCounters
 }
 
 // AddTrustMarkEndpoint adds a trust mark endpoint
@@ -136,6 +148,15 @@ func (fed *LightHouse) handleTrustMarkRequest(
 		// If not found in DB, continue with legacy behavior
 	}
 
+	// Deprecated trust mark types are still issued with a deprecation hint,
+	// retired ones are no longer issued
+	lifecycle := fed.trustMarkLifecycle(req.TrustMarkType, dbSpec)
+	setDeprecationHeaders(ctx, lifecycle)
+	if lifecycle.State == model.TrustMarkLifecycleRetired {
+		ctx.Status(fiber.StatusNotFound)
+		return ctx.JSON(oidfed.ErrorNotFound("'trust_mark_type' is retired"))
+	}
+
 	// Default to db_only mode if no eligibility config
 	if eligibilityConfig == nil {
 		eligibilityConfig = &model.EligibilityConfig{Mode: model.EligibilityModeDBOnly}
@@ -147,6 +168,7 @@ func (fed *LightHouse) handleTrustMarkRequest(
 			if eligible {
 				return fed.issueAndSendTrustMarkWithClaims(ctx, req.TrustMarkType, req.Subject, dbSpec, config)
 			}
+			countTrustMarkReportCounter(config, req.TrustMarkType, model.TrustMarkReportCounterEligibilityDenials)
 			ctx.Status(httpCode)
 			return ctx.JSON(
 				&oidfed.Error{
@@ -158,10 +180,13 @@ func (fed *LightHouse) handleTrustMarkRequest(
 	}
 
 	// Run eligibility check based on mode
-	eligible, httpCode, reason := fed.checkEligibility(req.TrustMarkType, req.Subject, eligibilityConfig, config)
+	eligible, httpCode, reason := fed.checkEligibility(
+		ctx.UserContext(), req.TrustMarkType, req.Subject, eligibilityConfig, config,
+	)
 
-	// Cache result if caching is enabled
-	if config.Cache != nil && eligibilityConfig.CheckCacheTTL > 0 {
+	// Cache result if caching is enabled; aborted checks are not cached
+	if config.Cache != nil && eligibilityConfig.CheckCacheTTL > 0 &&
+		httpCode != fiber.StatusGatewayTimeout && httpCode != fiber.StatusServiceUnavailable {
 		config.Cache.Set(
 			req.TrustMarkType, req.Subject, eligible, httpCode, reason,
 			time.Duration(eligibilityConfig.CheckCacheTTL)*time.Second,
@@ -172,6 +197,7 @@ func (fed *LightHouse) handleTrustMarkRequest(
 		return fed.issueAndSendTrustMarkWithClaims(ctx, req.TrustMarkType, req.Subject, dbSpec, config)
 	}
 
+	countTrustMarkReportCounter(config, req.TrustMarkType, model.TrustMarkReportCounterEligibilityDenials)
 	ctx.Status(httpCode)
 	return ctx.JSON(
 		&oidfed.Error{
@@ -183,6 +209,7 @@ func (fed *LightHouse) handleTrustMarkRequest(
 
 // checkEligibility checks if a subject is eligible for a trust mark based on the eligibility mode
 func (fed *LightHouse) checkEligibility(
+	ctx context.Context,
 	trustMarkType, sub string,
 	eligibilityConfig *model.EligibilityConfig,
 	config TrustMarkEndpointConfig,
@@ -192,30 +219,67 @@ func (fed *LightHouse) checkEligibility(
 		return fed.checkDBEligibility(trustMarkType, sub, config)
 
 	case model.EligibilityModeCheckOnly:
-		return fed.runChecker(trustMarkType, sub, eligibilityConfig.Checker, config)
+		return fed.runChecker(ctx, trustMarkType, sub, eligibilityConfig.Checker, config)
 
 	case model.EligibilityModeDBOrCheck:
 		// DB first, then checker
 		if ok, _, _ := fed.checkDBEligibility(trustMarkType, sub, config); ok {
 			return true, 0, ""
 		}
-		return fed.runChecker(trustMarkType, sub, eligibilityConfig.Checker, config)
+		return fed.runChecker(ctx, trustMarkType, sub, eligibilityConfig.Checker, config)
 
 	case model.EligibilityModeDBAndCheck:
 		// Must pass both
 		if ok, code, reason := fed.checkDBEligibility(trustMarkType, sub, config); !ok {
 			return false, code, reason
 		}
-		return fed.runChecker(trustMarkType, sub, eligibilityConfig.Checker, config)
+		return fed.runChecker(ctx, trustMarkType, sub, eligibilityConfig.Checker, config)
 
 	case model.EligibilityModeCustom:
-		return fed.runChecker(trustMarkType, sub, eligibilityConfig.Checker, config)
+		return fed.runChecker(ctx, trustMarkType, sub, eligibilityConfig.Checker, config)
 
 	default:
 		return false, fiber.StatusInternalServerError, "unknown eligibility mode"
 	}
 }
 
+// errSubjectNotEligible is returned by checkSpecEligibility if the subject
+// fails the eligibility check of the trust mark spec.
+var errSubjectNotEligible = errors.New("subject is not eligible for this trust mark")
+
+// checkSpecEligibility runs the eligibility check of the trust mark spec for
+// a subject outside of a trust mark request, i.e. before trust marks are
+// pushed or pre-issued. Results are shared with the eligibility cache of the
+// trust mark endpoint.
+func (fed *LightHouse) checkSpecEligibility(ctx context.Context, spec *model.TrustMarkSpec, sub string) error {
+	eligibilityConfig := spec.EligibilityConfig
+	if eligibilityConfig == nil {
+		eligibilityConfig = &model.EligibilityConfig{Mode: model.EligibilityModeDBOnly}
+	}
+	useCache := fed.eligibilityCache != nil && eligibilityConfig.CheckCacheTTL > 0
+	if useCache {
+		if eligible, _, reason, found := fed.eligibilityCache.Get(spec.TrustMarkType, sub); found {
+			if !eligible {
+				return errors.Wrap(errSubjectNotEligible, reason)
+			}
+			return nil
+		}
+	}
+	eligible, httpCode, reason := fed.checkEligibility(
+		ctx, spec.TrustMarkType, sub, eligibilityConfig, TrustMarkEndpointConfig{Store: fed.storages.TrustMarks},
+	)
+	if useCache && httpCode != fiber.StatusGatewayTimeout && httpCode != fiber.StatusServiceUnavailable {
+		fed.eligibilityCache.Set(
+			spec.TrustMarkType, sub, eligible, httpCode, reason,
+			time.Duration(eligibilityConfig.CheckCacheTTL)*time.Second,
+		)
+	}
+	if !eligible {
+		return errors.Wrap(errSubjectNotEligible, reason)
+	}
+	return nil
+}
+
 // checkDBEligibility checks if a subject is eligible based on the database status
 func (*LightHouse) checkDBEligibility(
 	trustMarkType, sub string,
@@ -242,31 +306,44 @@ func (*LightHouse) checkDBEligibility(
 	}
 }
 
+// newEligibilityChecker builds the EntityChecker for the passed
+// model.CheckerConfig and sets the checker context for contextual checkers
+// (like db_list). It returns nil if no checker is configured.
+func newEligibilityChecker(
+	trustMarkType string,
+	checkerConfig *model.CheckerConfig,
+	store model.TrustMarkedEntitiesStorageBackend,
+	metadataValidator *metadataschema.Validator,
+) (EntityChecker, error) {
+	if checkerConfig == nil {
+		return nil, nil
+	}
+	checker, err := EntityCheckerFromJSONConfig(checkerConfig.Type, checkerConfig.Config)
+	if err != nil {
+		return nil, err
+	}
+	if contextual, ok := checker.(ContextualEntityChecker); ok {
+		contextual.SetContext(
+			CheckerContext{
+				Store:             store,
+				TrustMarkType:     trustMarkType,
+				MetadataValidator: metadataValidator,
+			},
+		)
+	}
+	return checker, nil
+}
+
 // runChecker runs an entity checker against a subject
-func (*LightHouse) runChecker(
+func (fed *LightHouse) runChecker(
+	ctx context.Context,
 	trustMarkType, sub string,
 	checkerConfig *model.CheckerConfig,
 	config TrustMarkEndpointConfig,
 ) (eligible bool, httpCode int, reason string) {
-	var checker EntityChecker
-
-	// Try to build checker from config
-	if checkerConfig != nil {
-		var err error
-		checker, err = EntityCheckerFromJSONConfig(checkerConfig.Type, checkerConfig.Config)
-		if err != nil {
-			return false, fiber.StatusInternalServerError, "failed to build checker: " + err.Error()
-		}
-
-		// If it's a contextual checker (like db_list), set the context
-		if contextual, ok := checker.(ContextualEntityChecker); ok {
-			contextual.SetContext(
-				CheckerContext{
-					Store:         config.Store,
-					TrustMarkType: trustMarkType,
-				},
-			)
-		}
+	checker, err := newEligibilityChecker(trustMarkType, checkerConfig, config.Store, fed.metadataValidator)
+	if err != nil {
+		return false, fiber.StatusInternalServerError, "failed to build checker: " + err.Error()
 	}
 
 	// No checker means not eligible
@@ -281,7 +358,9 @@ func (*LightHouse) runChecker(
 	}
 
 	// Run the checker
-	ok, code, errResponse := checker.Check(entityConfig, entityConfig.Metadata.GuessEntityTypes())
+	ok, code, errResponse := RunEntityChecker(
+		ctx, config.CheckTimeout, checker, entityConfig, entityConfig.Metadata.GuessEntityTypes(),
+	)
 	if !ok {
 		httpCode := fiber.StatusForbidden
 		if code != 0 {
@@ -307,51 +386,160 @@ func (fed *LightHouse) issueAndSendTrustMarkWithClaims(
 ) error {
 	// Get cache TTL from spec (0 means no caching)
 	var cacheTTLSeconds int
+	var preIssued bool
 	if dbSpec != nil {
 		cacheTTLSeconds = dbSpec.CacheTTL
+		preIssued = dbSpec.PreIssuance
 	}
 
-	// Check cache first if caching is enabled for this trust mark type
-	if config.IssuedTrustMarkCache != nil && cacheTTLSeconds > 0 {
+	// Check cache first if caching is enabled for this trust mark type; it
+	// also holds the pre-issued trust marks
+	if config.IssuedTrustMarkCache != nil && (cacheTTLSeconds > 0 || preIssued) {
 		if cachedTM, found := config.IssuedTrustMarkCache.Get(trustMarkType, sub); found {
+			countTrustMarkReportCounter(config, trustMarkType, model.TrustMarkReportCounterCacheHits)
 			ctx.Set(fiber.HeaderContentType, oidfedconst.ContentTypeTrustMark)
 			return ctx.SendString(cachedTM)
 		}
 	}
 
-	// Get subject-specific additional claims
-	var subjectClaims map[string]any
-	if dbSpec != nil && config.SpecStore != nil {
-		// Try to get subject-specific claims
-		subject, err := config.SpecStore.GetSubject(dbSpec.TrustMarkType, sub)
-		if err == nil && subject != nil {
-			subjectClaims = subject.AdditionalClaims
+	tm, expiresAt, err := fed.issueTrustMarkInstance(
+		trustMarkType, sub, dbSpec, config.SpecStore, config.InstanceStore,
+	)
+	if errors.Is(err, errSubjectOutsideValidity) {
+		countTrustMarkReportCounter(config, trustMarkType, model.TrustMarkReportCounterEligibilityDenials)
+		ctx.Status(fiber.StatusForbidden)
+		return ctx.JSON(
+			&oidfed.Error{
+				Error:            "not_eligible",
+				ErrorDescription: err.Error(),
+			},
+		)
+	}
+	if err != nil {
+		ctx.Status(fiber.StatusInternalServerError)
+		return ctx.JSON(oidfed.ErrorServerError(err.Error()))
+	}
+
+	// Cache the issued trust mark if caching is enabled for this trust mark type
+	if config.IssuedTrustMarkCache != nil && cacheTTLSeconds > 0 {
+		cacheTTL := time.Duration(cacheTTLSeconds) * time.Second
+		// If the trust mark has an expiration, don't cache longer than that
+		if expiresAt != nil {
+			timeUntilExpiry := time.Until(expiresAt.Time)
+			if timeUntilExpiry > 0 && timeUntilExpiry < cacheTTL {
+				cacheTTL = timeUntilExpiry
+			}
+		}
+		config.IssuedTrustMarkCache.Set(trustMarkType, sub, tm, cacheTTL)
+	}
+
+	ctx.Set(fiber.HeaderContentType, oidfedconst.ContentTypeTrustMark)
+	return ctx.SendString(tm)
+}
+
+// countTrustMarkReportCounter increments a trust mark report counter if
+// report counters are configured. The counters are buffered in memory, so
+// reporting never affects issuance.
+func countTrustMarkReportCounter(
+	config TrustMarkEndpointConfig, trustMarkType string, counter model.TrustMarkReportCounter,
+) {
+	if config.ReportCounters == nil {
+		return
+	}
+	config.ReportCounters.Count(trustMarkType, counter)
+}
+
+// errSubjectOutsideValidity is returned by issueTrustMarkInstance if the
+// current time is outside the validity window of the subject.
+var errSubjectOutsideValidity = errors.New("subject is not within its validity window for this trust mark")
+
+// subjectTrustMarkLifetime returns the lifetime of a trust mark issued to the
+// subject: the lifetime of the subject or the spec, capped at the end of the
+// validity window of the subject. 0 means the lifetime of the spec is used.
+func subjectTrustMarkLifetime(spec *model.TrustMarkSpec, subject *model.TrustMarkSubject, now time.Time) time.Duration {
+	lifetime := time.Duration(spec.Lifetime) * time.Second
+	if subject.Lifetime > 0 {
+		lifetime = time.Duration(subject.Lifetime) * time.Second
+	}
+	if subject.NotAfter > 0 {
+		if remaining := time.Unix(subject.NotAfter, 0).Sub(now); lifetime == 0 || remaining < lifetime {
+			lifetime = remaining
 		}
 	}
+	return lifetime
+}
 
-	// Generate JTI (JWT ID) for this issuance
-	jti := uuid.New().String()
+// entityConfigurationData returns the claims of the subject's entity
+// configuration as data for claim templates.
+func entityConfigurationData(sub string) (map[string]any, error) {
+	ec, err := oidfed.GetEntityConfiguration(sub)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not obtain entity configuration for claim templates")
+	}
+	raw, err := json.Marshal(ec.EntityStatementPayload)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	var data map[string]any
+	if err = json.Unmarshal(raw, &data); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return data, nil
+}
 
-	// Merge JTI into subject claims
-	if subjectClaims == nil {
-		subjectClaims = make(map[string]any)
+// issueTrustMarkInstance issues a trust mark with merged and rendered
+// additional claims and the lifetime of the subject, and persists the issued
+// instance for status tracking and revocation.
+func (fed *LightHouse) issueTrustMarkInstance(
+	trustMarkType, sub string,
+	dbSpec *model.TrustMarkSpec,
+	specStore model.TrustMarkSpecStore,
+	instanceStore model.IssuedTrustMarkInstanceStore,
+) (string, *unixtime.Unixtime, error) {
+	now := time.Now()
+	var opts oidfed.IssueTrustMarkOptions
+
+	// Merge spec-level and subject-specific additional claims
+	if fed.trustMarkLifecycle(trustMarkType, dbSpec).State == model.TrustMarkLifecycleRetired {
+		return "", nil, errTrustMarkTypeRetired
+	}
+	claims := make(map[string]any)
+	if dbSpec != nil {
+		maps.Copy(claims, dbSpec.AdditionalClaims)
+		var subject *model.TrustMarkSubject
+		if specStore != nil {
+			subject, _ = specStore.GetSubject(dbSpec.TrustMarkType, sub)
+		}
+		if subject != nil {
+			if !subject.WithinValidity(now) {
+				return "", nil, errSubjectOutsideValidity
+			}
+			maps.Copy(claims, subject.AdditionalClaims)
+			opts.Lifetime = subjectTrustMarkLifetime(dbSpec, subject, now)
+		}
+		if claimtemplate.HasTemplates(claims) {
+			data, err := entityConfigurationData(sub)
+			if err != nil {
+				return "", nil, err
+			}
+			claims = claimtemplate.Render(claims, data)
+		}
 	}
-	subjectClaims["jti"] = jti
 
-	// Use IssueTrustMarkWithOptions which handles claim merging
-	// (spec.Extra claims are already loaded via the TrustMarkSpecProvider)
-	tm, expiresAt, err := fed.IssueTrustMarkWithOptions(
-		trustMarkType, sub, oidfed.IssueTrustMarkOptions{
-			SubjectClaims: subjectClaims,
-		},
-	)
+	// Generate JTI (JWT ID) for this issuance
+	jti := uuid.New().String()
+	claims["jti"] = jti
+	opts.SubjectClaims = claims
+
+	// Use IssueTrustMarkWithOptions with the merged claims, which replace the
+	// spec.Extra claims loaded via the TrustMarkSpecProvider
+	tm, expiresAt, err := fed.IssueTrustMarkWithOptions(trustMarkType, sub, opts)
 	if err != nil {
-		ctx.Status(fiber.StatusInternalServerError)
-		return ctx.JSON(oidfed.ErrorServerError(err.Error()))
+		return "", nil, err
 	}
 
 	// Persist the issued instance for status tracking and revocation
-	if config.InstanceStore != nil {
+	if instanceStore != nil {
 		instance := &model.IssuedTrustMarkInstance{
 			JTI:           jti,
 			TrustMarkType: trustMarkType,
@@ -365,12 +553,12 @@ func (fed *LightHouse) issueAndSendTrustMarkWithClaims(
 		}
 
 		// Try to link to TrustMarkSubject record if it exists
-		if subjectID, err := config.InstanceStore.FindSubjectID(trustMarkType, sub); err == nil && subjectID > 0 {
+		if subjectID, err := instanceStore.FindSubjectID(trustMarkType, sub); err == nil && subjectID > 0 {
 			instance.TrustMarkSubjectID = subjectID
 		}
 
-		if err = config.InstanceStore.Create(instance); err != nil {
-			// Log the error but don't fail the request - the trust mark was issued successfully
+		if err = instanceStore.Create(instance); err != nil {
+			// Log the error but don't fail the issuance - the trust mark was issued successfully
 			log.Warn().Err(err).
 				Str("jti", jti).
 				Str("trust_mark_type", trustMarkType).
@@ -378,20 +566,5 @@ func (fed *LightHouse) issueAndSendTrustMarkWithClaims(
 				Msg("failed to persist issued trust mark instance")
 		}
 	}
-
-	// Cache the issued trust mark if caching is enabled for this trust mark type
-	if config.IssuedTrustMarkCache != nil && cacheTTLSeconds > 0 {
-		cacheTTL := time.Duration(cacheTTLSeconds) * time.Second
-		// If the trust mark has an expiration, don't cache longer than that
-		if expiresAt != nil {
-			timeUntilExpiry := time.Until(expiresAt.Time)
-			if timeUntilExpiry > 0 && timeUntilExpiry < cacheTTL {
-				cacheTTL = timeUntilExpiry
-			}
-		}
-		config.IssuedTrustMarkCache.Set(trustMarkType, sub, tm, cacheTTL)
-	}
-
-	ctx.Set(fiber.HeaderContentType, oidfedconst.ContentTypeTrustMark)
-	return ctx.SendString(tm)
+	return tm, expiresAt, nil
 }
@@ -0,0 +1,415 @@
+package lighthouse
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	oidfed "github.com/go-oidfed/lib"
+	"github.com/go-oidfed/lib/oidfedconst"
+	"github.com/gofiber/fiber/v2"
+	"github.com/lestrrat-go/jwx/v4/jws"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+
+	"github.com/go-oidfed/lighthouse/api/adminapi"
+	"github.com/go-oidfed/lighthouse/storage/model"
+)
+
+const (
+	// defaultDelegationLifetime is the lifetime of issued delegation JWTs if
+	// none is configured for the trust mark type.
+	defaultDelegationLifetime = 30 * 24 * time.Hour
+	// delegationHTTPTimeout is the timeout for pushing a delegation JWT to a
+	// trust mark issuer and for fetching one from a trust mark owner.
+	delegationHTTPTimeout = 30 * time.Second
+)
+
+// TrustMarkDelegationRenewalConfig configures the TrustMarkDelegationRenewer.
+type TrustMarkDelegationRenewalConfig struct {
+	// Interval is the time between two renewal runs.
+	Interval time.Duration
+}
+
+// TrustMarkDelegationRenewer periodically renews the delegation JWTs
+// LightHouse issues as owner of trust mark types and fetches renewed
+// delegation JWTs from the owners of the trust mark types it issues.
+type TrustMarkDelegationRenewer struct {
+	fed    *LightHouse
+	conf   TrustMarkDelegationRenewalConfig
+	runner periodicRunner
+}
+
+// NewTrustMarkDelegationRenewer creates a new TrustMarkDelegationRenewer for
+// the passed LightHouse.
+func NewTrustMarkDelegationRenewer(
+	fed *LightHouse, conf TrustMarkDelegationRenewalConfig,
+) *TrustMarkDelegationRenewer {
+	if conf.Interval <= 0 {
+		conf.Interval = time.Hour
+	}
+	return &TrustMarkDelegationRenewer{
+		fed:  fed,
+		conf: conf,
+	}
+}
+
+// Start starts the periodic renewal in the background. The first run starts
+// right away.
+func (r *TrustMarkDelegationRenewer) Start() {
+	r.runner.start(
+		periodicTask{
+			interval:   r.conf.Interval,
+			runAtStart: true,
+			run:        func(context.Context) { r.RunOnce() },
+		},
+	)
+	log.Info().Dur("interval", r.conf.Interval).Msg("trust mark delegation renewal started")
+}
+
+// Stop stops the periodic renewal and waits for a running renewal to finish.
+func (r *TrustMarkDelegationRenewer) Stop() {
+	r.runner.stop()
+}
+
+// RunOnce renews all delegations LightHouse issues that are due and fetches
+// renewed delegations for the trust mark types of other owners.
+func (r *TrustMarkDelegationRenewer) RunOnce() {
+	delegations, err := r.fed.RenewTrustMarkDelegations(false)
+	if err != nil {
+		log.Warn().Err(err).Msg("trust mark delegations: renewal failed")
+	} else {
+		log.Debug().Int("delegations", len(delegations)).Msg("trust mark delegations renewed")
+	}
+	if _, err = r.fed.RefreshExternalTrustMarkDelegations("", false); err != nil {
+		log.Warn().Err(err).Msg("trust mark delegations: refreshing external delegations failed")
+	}
+}
+
+// StartTrustMarkDelegationRenewal creates and starts a
+// TrustMarkDelegationRenewer. It is stopped with Stop.
+func (fed *LightHouse) StartTrustMarkDelegationRenewal(
+	conf TrustMarkDelegationRenewalConfig,
+) *TrustMarkDelegationRenewer {
+	if fed.delegationRenewer != nil {
+		fed.delegationRenewer.Stop()
+	}
+	fed.delegationRenewer = NewTrustMarkDelegationRenewer(fed, conf)
+	fed.delegationRenewer.Start()
+	return fed.delegationRenewer
+}
+
+// ownedTrustMarkTypes returns the trust mark types LightHouse is the owner of
+// together with their trust mark issuers.
+func (fed *LightHouse) ownedTrustMarkTypes() (map[string][]string, error) {
+	owned := make(map[string][]string)
+	types := fed.storages.TrustMarkTypes
+	if types == nil {
+		return owned, nil
+	}
+	owners, err := types.OwnersByType()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load trust mark owners")
+	}
+	issuers, err := types.IssuersByType()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load trust mark issuers")
+	}
+	entityID := fed.FederationEntity.EntityID()
+	for trustMarkType, owner := range owners {
+		if owner.ID == entityID {
+			owned[trustMarkType] = issuers[trustMarkType]
+		}
+	}
+	return owned, nil
+}
+
+// withOwnDelegationKeys sets the keys LightHouse signs delegations with as
+// jwks of the trust mark types it owns, so that the published owner keys
+// always match the issued delegations.
+func (fed *LightHouse) withOwnDelegationKeys(owners oidfed.TrustMarkOwners) (oidfed.TrustMarkOwners, error) {
+	entityID := fed.FederationEntity.EntityID()
+	for trustMarkType, owner := range owners {
+		if owner.ID != entityID {
+			continue
+		}
+		jwks, err := fed.PurposeSigner(model.SigningPurposeTrustMarks).JWKS()
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to get trust mark delegation keys")
+		}
+		owner.JWKS = jwks
+		owners[trustMarkType] = owner
+	}
+	return owners, nil
+}
+
+// delegationConfigs returns the stored delegation configurations by trust
+// mark type.
+func (fed *LightHouse) delegationConfigs() (map[string]adminapi.TrustMarkDelegationConfig, error) {
+	configs := make(map[string]adminapi.TrustMarkDelegationConfig)
+	if _, err := fed.storages.KV.GetAs(
+		model.KeyValueScopeTrustMarkDelegations, model.KeyValueKeyDelegationConfigs, &configs,
+	); err != nil {
+		return nil, errors.Wrap(err, "failed to load trust mark delegation configs")
+	}
+	return configs, nil
+}
+
+// withDelegationDefaults fills unset values of the configuration with the
+// defaults.
+func withDelegationDefaults(conf adminapi.TrustMarkDelegationConfig) adminapi.TrustMarkDelegationConfig {
+	if conf.Lifetime <= 0 {
+		conf.Lifetime = int64(defaultDelegationLifetime.Seconds())
+	}
+	if conf.RenewBefore <= 0 || conf.RenewBefore >= conf.Lifetime {
+		conf.RenewBefore = conf.Lifetime / 3
+	}
+	return conf
+}
+
+// TrustMarkDelegations implements the adminapi.LighthouseController
+// interface.
+func (fed *LightHouse) TrustMarkDelegations() ([]adminapi.TrustMarkDelegation, error) {
+	delegations := []adminapi.TrustMarkDelegation{}
+	if _, err := fed.storages.KV.GetAs(
+		model.KeyValueScopeTrustMarkDelegations, model.KeyValueKeyIssuedDelegations, &delegations,
+	); err != nil {
+		return nil, errors.Wrap(err, "failed to load trust mark delegations")
+	}
+	return delegations, nil
+}
+
+// TrustMarkDelegationConfig implements the adminapi.LighthouseController
+// interface. Unset values are returned with their defaults.
+func (fed *LightHouse) TrustMarkDelegationConfig(trustMarkType string) (*adminapi.TrustMarkDelegationConfig, error) {
+	configs, err := fed.delegationConfigs()
+	if err != nil {
+		return nil, err
+	}
+	conf := withDelegationDefaults(configs[trustMarkType])
+	return &conf, nil
+}
+
+// SetTrustMarkDelegationConfig implements the adminapi.LighthouseController
+// interface. The delegations of the trust mark type are re-issued with the
+// new configuration.
+func (fed *LightHouse) SetTrustMarkDelegationConfig(
+	trustMarkType string, conf adminapi.TrustMarkDelegationConfig,
+) (*adminapi.TrustMarkDelegationConfig, error) {
+	owned, err := fed.ownedTrustMarkTypes()
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := owned[trustMarkType]; !ok {
+		return nil, adminapi.ErrNotTrustMarkOwner
+	}
+	fed.delegationMu.Lock()
+	configs, err := fed.delegationConfigs()
+	if err == nil {
+		configs[trustMarkType] = conf
+		err = fed.storages.KV.SetAny(
+			model.KeyValueScopeTrustMarkDelegations, model.KeyValueKeyDelegationConfigs, configs,
+		)
+	}
+	fed.delegationMu.Unlock()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to store trust mark delegation config")
+	}
+	if _, err = fed.renewTrustMarkDelegations(
+		func(t string) bool { return t == trustMarkType },
+	); err != nil {
+		return nil, err
+	}
+	conf = withDelegationDefaults(conf)
+	return &conf, nil
+}
+
+// RenewTrustMarkDelegations implements the adminapi.LighthouseController
+// interface. Delegations are issued for all issuers of the trust mark types
+// LightHouse owns that have no delegation yet or whose delegation is due for
+// renewal; with force all delegations are re-issued.
+func (fed *LightHouse) RenewTrustMarkDelegations(force bool) ([]adminapi.TrustMarkDelegation, error) {
+	return fed.renewTrustMarkDelegations(func(string) bool { return force })
+}
+
+func delegationKey(trustMarkType, issuer string) string {
+	return trustMarkType + "|" + issuer
+}
+
+func (fed *LightHouse) renewTrustMarkDelegations(
+	force func(trustMarkType string) bool,
+) ([]adminapi.TrustMarkDelegation, error) {
+	fed.delegationMu.Lock()
+	defer fed.delegationMu.Unlock()
+
+	owned, err := fed.ownedTrustMarkTypes()
+	if err != nil {
+		return nil, err
+	}
+	configs, err := fed.delegationConfigs()
+	if err != nil {
+		return nil, err
+	}
+	stored, err := fed.TrustMarkDelegations()
+	if err != nil {
+		return nil, err
+	}
+	previous := make(map[string]adminapi.TrustMarkDelegation, len(stored))
+	for _, d := range stored {
+		previous[delegationKey(d.TrustMarkType, d.Issuer)] = d
+	}
+
+	trustMarkTypes := make([]string, 0, len(owned))
+	for trustMarkType := range owned {
+		trustMarkTypes = append(trustMarkTypes, trustMarkType)
+	}
+	sort.Strings(trustMarkTypes)
+
+	entityID := fed.FederationEntity.EntityID()
+	now := time.Now()
+	delegations := make([]adminapi.TrustMarkDelegation, 0, len(stored))
+	var errs []string
+	for _, trustMarkType := range trustMarkTypes {
+		conf := withDelegationDefaults(configs[trustMarkType])
+		owner := oidfed.NewTrustMarkOwner(
+			entityID,
+			fed.PurposeSigner(model.SigningPurposeTrustMarks).TrustMarkDelegationSigner(),
+			[]oidfed.OwnedTrustMark{
+				{
+					ID:                 trustMarkType,
+					DelegationLifetime: time.Duration(conf.Lifetime) * time.Second,
+					Ref:                conf.Ref,
+				},
+			},
+		)
+		for _, issuer := range owned[trustMarkType] {
+			d, found := previous[delegationKey(trustMarkType, issuer)]
+			if !found || force(trustMarkType) ||
+				d.ExpiresAt-now.Unix() <= conf.RenewBefore {
+				issued, err := issueDelegation(owner, trustMarkType, issuer)
+				if err != nil {
+					log.Warn().Err(err).Str("trust_mark_type", trustMarkType).Str("issuer", issuer).
+						Msg("trust mark delegations: failed to issue delegation")
+					errs = append(errs, err.Error())
+					if !found {
+						continue
+					}
+				} else {
+					d = *issued
+					if issuer == entityID {
+						fed.applyOwnDelegation(trustMarkType, d.JWT)
+					}
+				}
+			}
+			if url := conf.PushURLs[issuer]; url != "" && d.PushedAt == 0 {
+				if err = pushDelegation(url, d.JWT); err != nil {
+					log.Warn().Err(err).Str("trust_mark_type", trustMarkType).Str("issuer", issuer).
+						Msg("trust mark delegations: failed to push delegation")
+					d.PushError = err.Error()
+				} else {
+					d.PushedAt = time.Now().Unix()
+					d.PushError = ""
+				}
+			}
+			delegations = append(delegations, d)
+		}
+	}
+
+	if err = fed.storages.KV.SetAny(
+		model.KeyValueScopeTrustMarkDelegations, model.KeyValueKeyIssuedDelegations, delegations,
+	); err != nil {
+		return nil, errors.Wrap(err, "failed to store trust mark delegations")
+	}
+	if len(errs) > 0 {
+		return delegations, errors.Errorf("failed to issue delegations: %s", strings.Join(errs, "; "))
+	}
+	return delegations, nil
+}
+
+// issueDelegation issues a new delegation JWT for the trust mark type and
+// issuer.
+func issueDelegation(owner *oidfed.TrustMarkOwner, trustMarkType, issuer string) (
+	*adminapi.TrustMarkDelegation, error,
+) {
+	delegationJWT, err := owner.DelegationJWT(trustMarkType, issuer)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to sign delegation for '%s'", issuer)
+	}
+	claims, err := parseDelegationClaims(string(delegationJWT))
+	if err != nil {
+		return nil, err
+	}
+	d := &adminapi.TrustMarkDelegation{
+		TrustMarkType: trustMarkType,
+		Issuer:        issuer,
+		IssuedAt:      claims.IssuedAt.Unix(),
+		JWT:           string(delegationJWT),
+	}
+	if claims.ExpiresAt != nil {
+		d.ExpiresAt = claims.ExpiresAt.Unix()
+	}
+	return d, nil
+}
+
+// parseDelegationClaims returns the claims of a delegation JWT without
+// verifying its signature.
+func parseDelegationClaims(delegationJWT string) (*oidfed.DelegationJWT, error) {
+	msg, err := jws.Parse([]byte(delegationJWT))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse delegation jwt")
+	}
+	var claims oidfed.DelegationJWT
+	if err = json.Unmarshal(msg.Payload(), &claims); err != nil {
+		return nil, errors.Wrap(err, "failed to parse delegation jwt")
+	}
+	return &claims, nil
+}
+
+// applyOwnDelegation stores a delegation LightHouse issued to itself in the
+// trust mark spec of the trust mark type, so that it is used for issuing
+// trust marks right away.
+func (fed *LightHouse) applyOwnDelegation(trustMarkType, delegationJWT string) {
+	specs := fed.storages.TrustMarkSpecs
+	if specs == nil {
+		return
+	}
+	spec, err := specs.GetByType(trustMarkType)
+	if err != nil || spec == nil {
+		return
+	}
+	if _, err = specs.Patch(
+		fmt.Sprintf("%d", spec.ID), map[string]any{"delegation_jwt": delegationJWT},
+	); err != nil {
+		log.Warn().Err(err).Str("trust_mark_type", trustMarkType).
+			Msg("trust mark delegations: failed to update trust mark spec")
+		return
+	}
+	if fed.issuedTrustMarkCache != nil {
+		fed.issuedTrustMarkCache.InvalidateAll(trustMarkType)
+	}
+	fed.deleteTrustMarkBundles(trustMarkType)
+}
+
+// pushDelegation posts a delegation JWT to a trust mark issuer.
+func pushDelegation(url, delegationJWT string) error {
+	req, err := http.NewRequest(http.MethodPost, url, strings.NewReader(delegationJWT))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	req.Header.Set(fiber.HeaderContentType, oidfedconst.ContentTypeTrustMarkDelegation)
+	resp, err := (&http.Client{Timeout: delegationHTTPTimeout}).Do(req)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return errors.Errorf("issuer responded with status %d", resp.StatusCode)
+	}
+	return nil
+}
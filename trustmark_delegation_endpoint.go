@@ -0,0 +1,63 @@
+package lighthouse
+
+import (
+	oidfed "github.com/go-oidfed/lib"
+	"github.com/go-oidfed/lib/oidfedconst"
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/go-oidfed/lighthouse/storage/model"
+)
+
+// TrustMarkDelegationEndpointMetadataKey is the federation_entity metadata
+// claim under which the trust mark delegation endpoint is published.
+const TrustMarkDelegationEndpointMetadataKey = "federation_trust_mark_delegation_endpoint"
+
+// AddTrustMarkDelegationEndpoint adds an endpoint where trust mark issuers can
+// obtain the current delegation JWT LightHouse issued to them as owner of a
+// trust mark type. The endpoint takes the parameters trust_mark_type and sub
+// (the issuer) and returns the delegation JWT with media type
+// application/trust-mark-delegation+jwt.
+//
+// Delegations are not confidential (they are included in every issued trust
+// mark), so no client authentication is used.
+func (fed *LightHouse) AddTrustMarkDelegationEndpoint(endpoint EndpointConf) error {
+	if fed.fedMetadata.Extra == nil {
+		fed.fedMetadata.Extra = make(map[string]any)
+	}
+	fed.fedMetadata.Extra[TrustMarkDelegationEndpointMetadataKey] = endpoint.ValidateURL(
+		fed.FederationEntity.EntityID(),
+	)
+	if endpoint.Path == "" {
+		return nil
+	}
+	handler := func(ctx *fiber.Ctx) error {
+		var req trustMarkQueryRequest
+		if err := parseRequest(ctx, &req); err != nil {
+			ctx.Status(fiber.StatusBadRequest)
+			return ctx.JSON(oidfed.ErrorInvalidRequest("could not parse request parameters: " + err.Error()))
+		}
+		if req.Subject == "" {
+			ctx.Status(fiber.StatusBadRequest)
+			return ctx.JSON(oidfed.ErrorInvalidRequest("required parameter 'sub' not given"))
+		}
+		if req.TrustMarkType == "" {
+			ctx.Status(fiber.StatusBadRequest)
+			return ctx.JSON(oidfed.ErrorInvalidRequest("required parameter 'trust_mark_type' not given"))
+		}
+		delegations, err := fed.TrustMarkDelegations()
+		if err != nil {
+			ctx.Status(fiber.StatusInternalServerError)
+			return ctx.JSON(oidfed.ErrorServerError(err.Error()))
+		}
+		for _, d := range delegations {
+			if d.TrustMarkType == req.TrustMarkType && d.Issuer == req.Subject {
+				ctx.Set(fiber.HeaderContentType, oidfedconst.ContentTypeTrustMarkDelegation)
+				return ctx.SendString(d.JWT)
+			}
+		}
+		ctx.Status(fiber.StatusNotFound)
+		return ctx.JSON(oidfed.ErrorNotFound("no delegation for this trust mark type and subject"))
+	}
+	fed.registerEndpoint(model.EndpointTypeTrustMarkDelegation, endpoint.Path, fiber.MethodGet, handler, nil)
+	return nil
+}
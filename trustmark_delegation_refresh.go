@@ -0,0 +1,303 @@
+package lighthouse
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	oidfed "github.com/go-oidfed/lib"
+	"github.com/go-oidfed/lib/oidfedconst"
+	"github.com/gofiber/fiber/v2"
+	"github.com/lestrrat-go/jwx/v4/jws"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+
+	"github.com/go-oidfed/lighthouse/api/adminapi"
+	"github.com/go-oidfed/lighthouse/storage/model"
+)
+
+// maxDelegationResponseSize limits the size of a fetched delegation JWT.
+const maxDelegationResponseSize = 64 * 1024
+
+// externalDelegationState is persisted per trust mark type to track the
+// fetching of renewed delegations.
+type externalDelegationState struct {
+	URL         string `json:"url,omitempty"`
+	CheckedAt   int64  `json:"checked_at,omitempty"`
+	RefreshedAt int64  `json:"refreshed_at,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+// externalDelegationSpecs returns the trust mark specs of trust mark types
+// that are owned by another entity, together with the owners by trust mark
+// type.
+func (fed *LightHouse) externalDelegationSpecs() ([]model.TrustMarkSpec, oidfed.TrustMarkOwners, error) {
+	if fed.storages.TrustMarkSpecs == nil || fed.storages.TrustMarkTypes == nil {
+		return nil, nil, nil
+	}
+	owners, err := fed.storages.TrustMarkTypes.OwnersByType()
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to load trust mark owners")
+	}
+	specs, err := fed.storages.TrustMarkSpecs.List()
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to list trust mark specs")
+	}
+	entityID := fed.FederationEntity.EntityID()
+	external := make([]model.TrustMarkSpec, 0, len(specs))
+	for _, spec := range specs {
+		if owner, ok := owners[spec.TrustMarkType]; ok && owner.ID != entityID {
+			external = append(external, spec)
+		}
+	}
+	sort.Slice(external, func(i, j int) bool { return external[i].TrustMarkType < external[j].TrustMarkType })
+	return external, owners, nil
+}
+
+func (fed *LightHouse) externalDelegationStates() (map[string]externalDelegationState, error) {
+	states := make(map[string]externalDelegationState)
+	if _, err := fed.storages.KV.GetAs(
+		model.KeyValueScopeTrustMarkDelegations, model.KeyValueKeyExternalDelegations, &states,
+	); err != nil {
+		return nil, errors.Wrap(err, "failed to load external trust mark delegation states")
+	}
+	return states, nil
+}
+
+func externalDelegation(
+	spec model.TrustMarkSpec, owner string, state externalDelegationState,
+) adminapi.ExternalTrustMarkDelegation {
+	d := adminapi.ExternalTrustMarkDelegation{
+		TrustMarkType: spec.TrustMarkType,
+		Owner:         owner,
+		URL:           state.URL,
+		CheckedAt:     state.CheckedAt,
+		RefreshedAt:   state.RefreshedAt,
+		Error:         state.Error,
+	}
+	if spec.DelegationJWT == "" {
+		return d
+	}
+	if claims, err := parseDelegationClaims(spec.DelegationJWT); err == nil {
+		d.IssuedAt = claims.IssuedAt.Unix()
+		if claims.ExpiresAt != nil {
+			d.ExpiresAt = claims.ExpiresAt.Unix()
+		}
+	}
+	return d
+}
+
+// ExternalTrustMarkDelegations implements the adminapi.LighthouseController
+// interface.
+func (fed *LightHouse) ExternalTrustMarkDelegations() ([]adminapi.ExternalTrustMarkDelegation, error) {
+	specs, owners, err := fed.externalDelegationSpecs()
+	if err != nil {
+		return nil, err
+	}
+	states, err := fed.externalDelegationStates()
+	if err != nil {
+		return nil, err
+	}
+	delegations := make([]adminapi.ExternalTrustMarkDelegation, 0, len(specs))
+	for _, spec := range specs {
+		delegations = append(
+			delegations, externalDelegation(spec, owners[spec.TrustMarkType].ID, states[spec.TrustMarkType]),
+		)
+	}
+	return delegations, nil
+}
+
+// externalDelegationDue reports whether a renewed delegation should be
+// fetched, i.e. if there is no delegation yet or less than a third of its
+// lifetime is left. Delegations without expiration are never due.
+func externalDelegationDue(d adminapi.ExternalTrustMarkDelegation, now time.Time) bool {
+	if d.IssuedAt == 0 {
+		return true
+	}
+	if d.ExpiresAt == 0 {
+		return false
+	}
+	return d.ExpiresAt-now.Unix() <= (d.ExpiresAt-d.IssuedAt)/3
+}
+
+// RefreshExternalTrustMarkDelegations implements the
+// adminapi.LighthouseController interface. A fetched delegation only replaces
+// the current one if it is signed by the trust mark owner and issued to
+// LightHouse for the trust mark type.
+func (fed *LightHouse) RefreshExternalTrustMarkDelegations(trustMarkType string, force bool) (
+	[]adminapi.ExternalTrustMarkDelegation, error,
+) {
+	fed.delegationMu.Lock()
+	defer fed.delegationMu.Unlock()
+
+	specs, owners, err := fed.externalDelegationSpecs()
+	if err != nil {
+		return nil, err
+	}
+	states, err := fed.externalDelegationStates()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	delegations := make([]adminapi.ExternalTrustMarkDelegation, 0, len(specs))
+	for _, spec := range specs {
+		owner := owners[spec.TrustMarkType]
+		state := states[spec.TrustMarkType]
+		d := externalDelegation(spec, owner.ID, state)
+		if (trustMarkType != "" && spec.TrustMarkType != trustMarkType) ||
+			(!force && !externalDelegationDue(d, now)) {
+			delegations = append(delegations, d)
+			continue
+		}
+		state.CheckedAt = now.Unix()
+		var delegationJWT string
+		delegationJWT, state.URL, err = fed.fetchExternalDelegation(spec, owner)
+		if err == nil {
+			err = fed.verifyExternalDelegation(delegationJWT, spec, owner, d)
+		}
+		if err == nil && delegationJWT != spec.DelegationJWT {
+			_, err = fed.storages.TrustMarkSpecs.Patch(
+				fmt.Sprintf("%d", spec.ID), map[string]any{"delegation_jwt": delegationJWT},
+			)
+			if err == nil {
+				spec.DelegationJWT = delegationJWT
+				state.RefreshedAt = state.CheckedAt
+				if fed.issuedTrustMarkCache != nil {
+					fed.issuedTrustMarkCache.InvalidateAll(spec.TrustMarkType)
+				}
+				fed.deleteTrustMarkBundles(spec.TrustMarkType)
+			}
+		}
+		if err != nil {
+			log.Warn().Err(err).Str("trust_mark_type", spec.TrustMarkType).Str("owner", owner.ID).
+				Msg("trust mark delegations: failed to refresh delegation")
+			state.Error = err.Error()
+		} else {
+			state.Error = ""
+		}
+		states[spec.TrustMarkType] = state
+		delegations = append(delegations, externalDelegation(spec, owner.ID, state))
+	}
+
+	if err = fed.storages.KV.SetAny(
+		model.KeyValueScopeTrustMarkDelegations, model.KeyValueKeyExternalDelegations, states,
+	); err != nil {
+		return nil, errors.Wrap(err, "failed to store external trust mark delegation states")
+	}
+	return delegations, nil
+}
+
+// externalDelegationURL returns the URL a renewed delegation for the trust
+// mark spec is fetched from: the configured delegation url or the
+// federation_trust_mark_delegation_endpoint of the owner. The trust_mark_type
+// and sub parameters are added if not already part of the URL.
+func (fed *LightHouse) externalDelegationURL(spec model.TrustMarkSpec, owner string) (string, error) {
+	endpoint := spec.DelegationURL
+	if endpoint == "" {
+		ec, err := oidfed.GetEntityConfiguration(owner)
+		if err != nil {
+			return "", errors.Wrap(err, "could not obtain entity configuration of the trust mark owner")
+		}
+		if ec.Metadata != nil && ec.Metadata.FederationEntity != nil {
+			endpoint, _ = ec.Metadata.FederationEntity.Extra[TrustMarkDelegationEndpointMetadataKey].(string)
+		}
+		if endpoint == "" {
+			return "", errors.Errorf(
+				"no delegation url configured and the trust mark owner does not publish a %s",
+				TrustMarkDelegationEndpointMetadataKey,
+			)
+		}
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", errors.Wrap(err, "invalid delegation url")
+	}
+	q := u.Query()
+	if !q.Has("trust_mark_type") {
+		q.Set("trust_mark_type", spec.TrustMarkType)
+	}
+	if !q.Has("sub") {
+		q.Set("sub", fed.FederationEntity.EntityID())
+	}
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// fetchExternalDelegation fetches a delegation JWT for the trust mark spec
+// and returns it together with the URL it was fetched from.
+func (fed *LightHouse) fetchExternalDelegation(spec model.TrustMarkSpec, owner oidfed.TrustMarkOwnerSpec) (
+	string, string, error,
+) {
+	endpoint, err := fed.externalDelegationURL(spec, owner.ID)
+	if err != nil {
+		return "", "", err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), delegationHTTPTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return "", endpoint, errors.WithStack(err)
+	}
+	req.Header.Set(fiber.HeaderAccept, oidfedconst.ContentTypeTrustMarkDelegation)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", endpoint, errors.WithStack(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxDelegationResponseSize))
+	if err != nil {
+		return "", endpoint, errors.WithStack(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", endpoint, errors.Errorf("trust mark owner responded with status %d", resp.StatusCode)
+	}
+	return strings.TrimSpace(string(body)), endpoint, nil
+}
+
+// verifyExternalDelegation checks that a fetched delegation JWT is signed by
+// the trust mark owner, issued to LightHouse for the trust mark type, valid,
+// and not older than the current delegation.
+func (fed *LightHouse) verifyExternalDelegation(
+	delegationJWT string, spec model.TrustMarkSpec, owner oidfed.TrustMarkOwnerSpec,
+	current adminapi.ExternalTrustMarkDelegation,
+) error {
+	if owner.JWKS.Set == nil || owner.JWKS.Len() == 0 {
+		return errors.New("no jwks of the trust mark owner to verify the delegation against")
+	}
+	msg, err := jws.Parse([]byte(delegationJWT))
+	if err != nil {
+		return errors.Wrap(err, "failed to parse delegation jwt")
+	}
+	if sigs := msg.Signatures(); len(sigs) == 0 {
+		return errors.New("delegation jwt is not signed")
+	} else if typ, _ := sigs[0].ProtectedHeaders().Type(); typ != oidfedconst.JWTTypeTrustMarkDelegation {
+		return errors.Errorf("delegation jwt has type '%s', expected '%s'", typ, oidfedconst.JWTTypeTrustMarkDelegation)
+	}
+	if _, err = jws.Verify(
+		[]byte(delegationJWT), jws.WithKeySet(owner.JWKS.Set, jws.WithInferAlgorithmFromKey(true)),
+	); err != nil {
+		return errors.New("delegation jwt signature could not be verified with the jwks of the trust mark owner")
+	}
+	claims, err := parseDelegationClaims(delegationJWT)
+	if err != nil {
+		return err
+	}
+	switch {
+	case claims.Issuer != owner.ID:
+		return errors.Errorf("delegation jwt was issued by '%s', expected '%s'", claims.Issuer, owner.ID)
+	case claims.Subject != fed.FederationEntity.EntityID():
+		return errors.Errorf("delegation jwt was issued to '%s'", claims.Subject)
+	case claims.TrustMarkType != spec.TrustMarkType:
+		return errors.Errorf("delegation jwt is for trust mark type '%s'", claims.TrustMarkType)
+	case claims.ExpiresAt != nil && !claims.ExpiresAt.After(time.Now()):
+		return errors.New("delegation jwt is expired")
+	case claims.IssuedAt.Unix() < current.IssuedAt:
+		return errors.New("delegation jwt is older than the current delegation")
+	}
+	return nil
+}
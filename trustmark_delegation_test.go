@@ -0,0 +1,280 @@
+package lighthouse
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/go-oidfed/lib/jwx"
+	"github.com/go-oidfed/lib/oidfedconst"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/adaptor"
+	"github.com/lestrrat-go/jwx/v4/jws"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/go-oidfed/lighthouse/api/adminapi"
+	"github.com/go-oidfed/lighthouse/storage"
+	"github.com/go-oidfed/lighthouse/storage/model"
+)
+
+func setDelegationTestSigner(t *testing.T, fed *LightHouse) {
+	t.Helper()
+	c := purposeTestSigningConf(t)
+	keyManagement, err := initKey(fed.FederationEntity.EntityID(), c, model.Backends{})
+	require.NoError(t, err)
+	versatileSigner, err := createVersatileSigner(keyManagement, nil)
+	require.NoError(t, err)
+	fed.GeneralJWTSigner = jwx.NewGeneralJWTSigner(versatileSigner, keyManagement.BasicKeys.GetAlgs())
+	fed.keyManagement = keyManagement
+	fed.purposeSigners = createPurposeSigners(keyManagement, nil)
+}
+
+func TestTrustMarkDelegations(t *testing.T) {
+	fed, _ := newTestLightHouse(t)
+	setDelegationTestSigner(t, fed)
+	entityID := fed.FederationEntity.EntityID()
+	const (
+		ownedType    = "https://lighthouse.example.org/tm/owned"
+		externalType = "https://lighthouse.example.org/tm/external"
+		issuer       = "https://tmi.example.org"
+	)
+	var pushed []string
+	issuerServer := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, oidfedconst.ContentTypeTrustMarkDelegation, r.Header.Get("Content-Type"))
+				body, _ := io.ReadAll(r.Body)
+				pushed = append(pushed, string(body))
+			},
+		),
+	)
+	defer issuerServer.Close()
+
+	ownerJWKS, err := fed.PurposeSigner(model.SigningPurposeTrustMarks).JWKS()
+	require.NoError(t, err)
+	_, err = fed.storages.TrustMarkTypes.Create(
+		model.AddTrustMarkType{
+			TrustMarkType: ownedType,
+			TrustMarkOwner: &model.AddTrustMarkOwner{
+				EntityID: entityID,
+				JWKS:     model.JWKS{Keys: ownerJWKS},
+			},
+			TrustMarkIssuers: []model.AddTrustMarkIssuer{
+				{Issuer: issuer},
+				{Issuer: entityID},
+			},
+		},
+	)
+	require.NoError(t, err)
+	_, err = fed.storages.TrustMarkTypes.Create(
+		model.AddTrustMarkType{
+			TrustMarkType: externalType,
+			TrustMarkOwner: &model.AddTrustMarkOwner{
+				EntityID: "https://owner.example.org",
+				JWKS:     model.JWKS{Keys: ownerJWKS},
+			},
+			TrustMarkIssuers: []model.AddTrustMarkIssuer{{Issuer: entityID}},
+		},
+	)
+	require.NoError(t, err)
+	_, err = fed.storages.TrustMarkSpecs.Create(&model.AddTrustMarkSpec{TrustMarkType: ownedType})
+	require.NoError(t, err)
+
+	_, err = fed.SetTrustMarkDelegationConfig(externalType, adminapi.TrustMarkDelegationConfig{})
+	assert.ErrorIs(t, err, adminapi.ErrNotTrustMarkOwner)
+
+	conf, err := fed.SetTrustMarkDelegationConfig(
+		ownedType, adminapi.TrustMarkDelegationConfig{
+			Lifetime: 3600,
+			Ref:      "https://lighthouse.example.org/tm/owned/ref",
+			PushURLs: map[string]string{issuer: issuerServer.URL},
+		},
+	)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1200), conf.RenewBefore)
+
+	delegations, err := fed.TrustMarkDelegations()
+	require.NoError(t, err)
+	require.Len(t, delegations, 2)
+	byIssuer := make(map[string]adminapi.TrustMarkDelegation)
+	for _, d := range delegations {
+		assert.Equal(t, ownedType, d.TrustMarkType)
+		assert.Equal(t, int64(3600), d.ExpiresAt-d.IssuedAt)
+		byIssuer[d.Issuer] = d
+	}
+
+	// The delegation is signed with the trust marks key set, which is
+	// published as owner jwks in the entity configuration
+	owners, err := fed.storages.TrustMarkTypes.OwnersByType()
+	require.NoError(t, err)
+	owners, err = fed.withOwnDelegationKeys(owners)
+	require.NoError(t, err)
+	_, err = jws.Verify([]byte(byIssuer[issuer].JWT), jws.WithKeySet(owners[ownedType].JWKS.Set))
+	require.NoError(t, err)
+	parsed, err := parseDelegationClaims(byIssuer[issuer].JWT)
+	require.NoError(t, err)
+	assert.Equal(t, issuer, parsed.Subject)
+	assert.Equal(t, "https://lighthouse.example.org/tm/owned/ref", parsed.Ref)
+	assert.Equal(t, "https://owner.example.org", owners[externalType].ID)
+
+	// The delegation for the external issuer was pushed, the one for
+	// LightHouse itself was stored in its trust mark spec
+	assert.Equal(t, []string{byIssuer[issuer].JWT}, pushed)
+	assert.NotZero(t, byIssuer[issuer].PushedAt)
+	spec, err := fed.storages.TrustMarkSpecs.GetByType(ownedType)
+	require.NoError(t, err)
+	assert.Equal(t, byIssuer[entityID].JWT, spec.DelegationJWT)
+
+	// Delegations that are not due are kept, forced renewals re-issue them
+	renewed, err := fed.RenewTrustMarkDelegations(false)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, delegations, renewed)
+	assert.Len(t, pushed, 1)
+	time.Sleep(time.Second)
+	renewed, err = fed.RenewTrustMarkDelegations(true)
+	require.NoError(t, err)
+	require.Len(t, renewed, 2)
+	for _, d := range renewed {
+		assert.NotEqual(t, byIssuer[d.Issuer].JWT, d.JWT)
+	}
+	assert.Len(t, pushed, 2)
+
+	// Delegations close to their expiration are reported by the key health
+	// check
+	report := NewKeyHealthMonitor(fed, KeyHealthConfig{}).Check(time.Now())
+	var delegationIssues int
+	for _, issue := range report.Issues {
+		if issue.Kind == adminapi.KeyHealthKindTrustMarkDelegation {
+			delegationIssues++
+			assert.Equal(t, ownedType, issue.TrustMarkType)
+			assert.Equal(t, adminapi.KeyHealthStatusExpiring, issue.Status)
+		}
+	}
+	assert.Equal(t, 2, delegationIssues)
+}
+
+// delegationOwnerEntity is a trust mark owner that is also a LightHouse.
+type delegationOwnerEntity struct {
+	stubFedEntity
+}
+
+func (delegationOwnerEntity) EntityID() string { return "https://owner.example.org" }
+
+func TestRefreshExternalTrustMarkDelegations(t *testing.T) {
+	const trustMarkType = "https://owner.example.org/tm/member"
+	fed, _ := newTestLightHouse(t)
+	setDelegationTestSigner(t, fed)
+	issuer := fed.FederationEntity.EntityID()
+
+	// The owner issues the delegation and serves it from its delegation
+	// endpoint
+	ownerStore, err := storage.NewStorage(
+		storage.Config{
+			Driver: storage.DriverSQLite,
+			DSN:    fmt.Sprintf("file:%s?mode=memory&cache=shared", url.PathEscape(t.Name()+"-owner")),
+		},
+	)
+	require.NoError(t, err)
+	owner := &LightHouse{
+		FederationEntity: delegationOwnerEntity{},
+		storages: model.Backends{
+			TrustMarkTypes: ownerStore.TrustMarkTypesStorage(),
+			KV:             ownerStore.KeyValue(),
+		},
+	}
+	setDelegationTestSigner(t, owner)
+	ownerJWKS, err := owner.PurposeSigner(model.SigningPurposeTrustMarks).JWKS()
+	require.NoError(t, err)
+	addType := func(store model.TrustMarkTypesStore) {
+		_, err := store.Create(
+			model.AddTrustMarkType{
+				TrustMarkType: trustMarkType,
+				TrustMarkOwner: &model.AddTrustMarkOwner{
+					EntityID: owner.FederationEntity.EntityID(),
+					JWKS:     model.JWKS{Keys: ownerJWKS},
+				},
+				TrustMarkIssuers: []model.AddTrustMarkIssuer{{Issuer: issuer}},
+			},
+		)
+		require.NoError(t, err)
+	}
+	addType(owner.storages.TrustMarkTypes)
+	addType(fed.storages.TrustMarkTypes)
+	_, err = owner.RenewTrustMarkDelegations(false)
+	require.NoError(t, err)
+	require.NoError(t, owner.AddTrustMarkDelegationEndpoint(EndpointConf{Path: "/delegation"}))
+	app := fiber.New()
+	app.Use(owner.dispatch)
+	ownerServer := httptest.NewServer(adaptor.FiberApp(app))
+	defer ownerServer.Close()
+
+	_, err = fed.storages.TrustMarkSpecs.Create(
+		&model.AddTrustMarkSpec{
+			TrustMarkType: trustMarkType,
+			DelegationURL: ownerServer.URL + "/delegation",
+		},
+	)
+	require.NoError(t, err)
+
+	// A missing delegation is fetched right away
+	delegations, err := fed.RefreshExternalTrustMarkDelegations("", false)
+	require.NoError(t, err)
+	require.Len(t, delegations, 1)
+	first := delegations[0]
+	assert.Empty(t, first.Error)
+	assert.Equal(t, owner.FederationEntity.EntityID(), first.Owner)
+	assert.NotZero(t, first.RefreshedAt)
+	assert.Contains(t, first.URL, "sub="+url.QueryEscape(issuer))
+	issued, err := owner.TrustMarkDelegations()
+	require.NoError(t, err)
+	spec, err := fed.storages.TrustMarkSpecs.GetByType(trustMarkType)
+	require.NoError(t, err)
+	assert.Equal(t, issued[0].JWT, spec.DelegationJWT)
+	assert.Equal(t, issued[0].ExpiresAt, first.ExpiresAt)
+
+	// A delegation that is not due is not fetched again
+	delegations, err = fed.RefreshExternalTrustMarkDelegations("", false)
+	require.NoError(t, err)
+	assert.Equal(t, first, delegations[0])
+
+	// A renewed delegation is only accepted if it verifies with the owner's
+	// jwks
+	time.Sleep(time.Second)
+	_, err = owner.RenewTrustMarkDelegations(true)
+	require.NoError(t, err)
+	fedJWKS, err := fed.PurposeSigner(model.SigningPurposeTrustMarks).JWKS()
+	require.NoError(t, err)
+	_, err = fed.storages.TrustMarkTypes.UpdateOwner(
+		trustMarkType, model.AddTrustMarkOwner{
+			EntityID: owner.FederationEntity.EntityID(),
+			JWKS:     model.JWKS{Keys: fedJWKS},
+		},
+	)
+	require.NoError(t, err)
+	delegations, err = fed.RefreshExternalTrustMarkDelegations(trustMarkType, true)
+	require.NoError(t, err)
+	assert.Contains(t, delegations[0].Error, "could not be verified")
+	spec, err = fed.storages.TrustMarkSpecs.GetByType(trustMarkType)
+	require.NoError(t, err)
+	assert.Equal(t, issued[0].JWT, spec.DelegationJWT)
+
+	_, err = fed.storages.TrustMarkTypes.UpdateOwner(
+		trustMarkType, model.AddTrustMarkOwner{
+			EntityID: owner.FederationEntity.EntityID(),
+			JWKS:     model.JWKS{Keys: ownerJWKS},
+		},
+	)
+	require.NoError(t, err)
+	delegations, err = fed.RefreshExternalTrustMarkDelegations(trustMarkType, true)
+	require.NoError(t, err)
+	assert.Empty(t, delegations[0].Error)
+	assert.Greater(t, delegations[0].IssuedAt, first.IssuedAt)
+	spec, err = fed.storages.TrustMarkSpecs.GetByType(trustMarkType)
+	require.NoError(t, err)
+	assert.NotEqual(t, issued[0].JWT, spec.DelegationJWT)
+}
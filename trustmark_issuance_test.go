@@ -0,0 +1,342 @@
+package lighthouse
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	oidfed "github.com/go-oidfed/lib"
+	"github.com/go-oidfed/lib/oidfedconst"
+	"github.com/go-oidfed/lib/unixtime"
+	"github.com/gofiber/fiber/v2"
+	"github.com/lestrrat-go/jwx/v4/jws"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/go-oidfed/lighthouse/storage"
+	"github.com/go-oidfed/lighthouse/storage/model"
+)
+
+// newEntityConfigurationServer serves a signed entity configuration with the
+// passed organization_name as entity ID of the returned server.
+func newEntityConfigurationServer(t *testing.T, fed *LightHouse, organizationName string) *httptest.Server {
+	t.Helper()
+	jwks, err := fed.GeneralJWTSigner.JWKS()
+	require.NoError(t, err)
+	var server *httptest.Server
+	server = httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				now := time.Now()
+				payload := oidfed.EntityStatementPayload{
+					Issuer:    server.URL,
+					Subject:   server.URL,
+					IssuedAt:  unixtime.Unixtime{Time: now},
+					ExpiresAt: unixtime.Unixtime{Time: now.Add(time.Hour)},
+					JWKS:      jwks,
+					Metadata: &oidfed.Metadata{
+						FederationEntity: &oidfed.FederationEntityMetadata{
+							OrganizationName: organizationName,
+						},
+					},
+				}
+				jwt, err := fed.GeneralJWTSigner.EntityStatementSigner().JWT(payload)
+				if err != nil {
+					w.WriteHeader(http.StatusInternalServerError)
+					return
+				}
+				w.Header().Set("Content-Type", oidfedconst.ContentTypeEntityStatement)
+				_, _ = w.Write(jwt)
+			},
+		),
+	)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestIssueTrustMarkInstance(t *testing.T) {
+	fed, _ := newTestLightHouse(t)
+	setDelegationTestSigner(t, fed)
+	fed.TrustMarkIssuer = oidfed.NewTrustMarkIssuer(
+		fed.FederationEntity.EntityID(), fed.PurposeSigner(model.SigningPurposeTrustMarks).TrustMarkSigner(), nil,
+	)
+	fed.TrustMarkIssuer.SetProvider(NewDBTrustMarkSpecProvider(fed.storages.TrustMarkSpecs))
+	const trustMarkType = "https://lighthouse.example.org/tm/templated"
+	subject := newEntityConfigurationServer(t, fed, "Example Org").URL
+
+	spec, err := fed.storages.TrustMarkSpecs.Create(
+		&model.AddTrustMarkSpec{
+			TrustMarkType: trustMarkType,
+			Lifetime:      86400,
+			AdditionalClaims: map[string]any{
+				"level":        "standard",
+				"organization": "{{ .metadata.federation_entity.organization_name }}",
+			},
+		},
+	)
+	require.NoError(t, err)
+	_, err = fed.storages.TrustMarkSpecs.CreateSubject(
+		trustMarkType, &model.AddTrustMarkSubject{
+			EntityID: subject,
+			Status:   model.StatusActive,
+			Lifetime: 3600,
+			AdditionalClaims: map[string]any{
+				"level":    "premium",
+				"homepage": "{{ .metadata.federation_entity.homepage_uri }}",
+			},
+		},
+	)
+	require.NoError(t, err)
+
+	issue := func() (*oidfed.TrustMark, error) {
+		tm, _, err := fed.issueTrustMarkInstance(
+			trustMarkType, subject, spec, fed.storages.TrustMarkSpecs, fed.storages.TrustMarkInstances,
+		)
+		if err != nil {
+			return nil, err
+		}
+		payload, err := jws.Parse([]byte(tm))
+		require.NoError(t, err)
+		var claims oidfed.TrustMark
+		require.NoError(t, claims.UnmarshalJSON(payload.Payload()))
+		return &claims, nil
+	}
+
+	// Spec and subject claims are merged and rendered, and the subject
+	// lifetime is used
+	tm, err := issue()
+	require.NoError(t, err)
+	assert.Equal(t, "premium", tm.Extra["level"])
+	assert.Equal(t, "Example Org", tm.Extra["organization"])
+	assert.NotContains(t, tm.Extra, "homepage")
+	assert.NotEmpty(t, tm.Extra["jti"])
+	require.NotNil(t, tm.ExpiresAt)
+	assert.Equal(t, time.Hour, tm.ExpiresAt.Sub(tm.IssuedAt.Time))
+
+	// The trust mark expires at the end of the validity window
+	notAfter := time.Now().Add(10 * time.Minute).Unix()
+	_, err = fed.storages.TrustMarkSpecs.UpdateSubject(
+		trustMarkType, subject, &model.AddTrustMarkSubject{
+			EntityID: subject,
+			Status:   model.StatusActive,
+			Lifetime: 3600,
+			NotAfter: notAfter,
+		},
+	)
+	require.NoError(t, err)
+	tm, err = issue()
+	require.NoError(t, err)
+	assert.InDelta(t, notAfter, tm.ExpiresAt.Unix(), 1)
+	assert.Equal(t, "standard", tm.Extra["level"])
+
+	// No trust marks are issued outside of the validity window
+	_, err = fed.storages.TrustMarkSpecs.UpdateSubject(
+		trustMarkType, subject, &model.AddTrustMarkSubject{
+			EntityID:  subject,
+			Status:    model.StatusActive,
+			NotBefore: time.Now().Add(time.Hour).Unix(),
+		},
+	)
+	require.NoError(t, err)
+	_, err = issue()
+	assert.ErrorIs(t, err, errSubjectOutsideValidity)
+}
+
+func TestTrustMarkEndpoint_ReportCounters(t *testing.T) {
+	fed, store := newTestLightHouse(t)
+	setDelegationTestSigner(t, fed)
+	fed.TrustMarkIssuer = oidfed.NewTrustMarkIssuer(
+		fed.FederationEntity.EntityID(), fed.PurposeSigner(model.SigningPurposeTrustMarks).TrustMarkSigner(), nil,
+	)
+	fed.TrustMarkIssuer.SetProvider(NewDBTrustMarkSpecProvider(fed.storages.TrustMarkSpecs))
+	reports := storage.NewTrustMarkReportsStorage(store.DB())
+	counters := NewTrustMarkReportCounters(reports, 0)
+	const (
+		trustMarkType = "https://lighthouse.example.org/tm/reported"
+		subject       = "https://rp.example.org"
+	)
+	_, err := fed.storages.TrustMarkSpecs.Create(
+		&model.AddTrustMarkSpec{
+			TrustMarkType: trustMarkType,
+			CacheTTL:      3600,
+		},
+	)
+	require.NoError(t, err)
+	_, err = fed.storages.TrustMarkSpecs.CreateSubject(
+		trustMarkType, &model.AddTrustMarkSubject{
+			EntityID: subject,
+			Status:   model.StatusActive,
+		},
+	)
+	require.NoError(t, err)
+
+	require.NoError(
+		t, fed.AddTrustMarkEndpointWithConfig(
+			EndpointConf{Path: "/trustmark"}, TrustMarkEndpointConfig{
+				Store:                fed.storages.TrustMarks,
+				SpecStore:            fed.storages.TrustMarkSpecs,
+				InstanceStore:        fed.storages.TrustMarkInstances,
+				IssuedTrustMarkCache: NewIssuedTrustMarkCache(),
+				ReportCounters:       counters,
+			},
+		),
+	)
+	app := fiber.New()
+	app.All("/*", fed.dispatch)
+	request := func(sub string) int {
+		params := url.Values{
+			"sub":             {sub},
+			"trust_mark_type": {trustMarkType},
+		}
+		resp, err := app.Test(httptest.NewRequest(http.MethodGet, "/trustmark?"+params.Encode(), nil), -1)
+		require.NoError(t, err)
+		return resp.StatusCode
+	}
+	assert.Equal(t, http.StatusOK, request(subject))
+	assert.Equal(t, http.StatusOK, request(subject))
+	assert.Equal(t, http.StatusNotFound, request("https://other.example.org"))
+
+	// The counters are only written when they are flushed
+	now := time.Now()
+	filter := model.TrustMarkReportFilter{
+		From: now.Add(-time.Hour),
+		To:   now.Add(time.Hour),
+	}
+	report, err := reports.Report(filter)
+	require.NoError(t, err)
+	for _, e := range report {
+		assert.Zero(t, e.CacheHits)
+		assert.Zero(t, e.EligibilityDenials)
+	}
+	require.NoError(t, counters.Flush())
+
+	report, err = reports.Report(filter)
+	require.NoError(t, err)
+	require.NotEmpty(t, report)
+	var issued, cacheHits, denials int64
+	for _, e := range report {
+		issued += e.Issued
+		cacheHits += e.CacheHits
+		denials += e.EligibilityDenials
+	}
+	assert.Equal(t, int64(1), issued)
+	assert.Equal(t, int64(1), cacheHits)
+	assert.Equal(t, int64(1), denials)
+}
+
+func TestTrustMarkEndpoint_Lifecycle(t *testing.T) {
+	fed, _ := newTestLightHouse(t)
+	setDelegationTestSigner(t, fed)
+	fed.TrustMarkIssuer = oidfed.NewTrustMarkIssuer(
+		fed.FederationEntity.EntityID(), fed.PurposeSigner(model.SigningPurposeTrustMarks).TrustMarkSigner(), nil,
+	)
+	fed.TrustMarkIssuer.SetProvider(NewDBTrustMarkSpecProvider(fed.storages.TrustMarkSpecs))
+	const (
+		trustMarkType = "https://lighthouse.example.org/tm/v1"
+		successorType = "https://lighthouse.example.org/tm/v2"
+		subject       = "https://rp.example.org"
+	)
+	_, err := fed.storages.TrustMarkSpecs.Create(&model.AddTrustMarkSpec{TrustMarkType: trustMarkType})
+	require.NoError(t, err)
+	_, err = fed.storages.TrustMarkSpecs.CreateSubject(
+		trustMarkType, &model.AddTrustMarkSubject{
+			EntityID: subject,
+			Status:   model.StatusActive,
+		},
+	)
+	require.NoError(t, err)
+	require.NoError(
+		t, fed.AddTrustMarkEndpointWithConfig(
+			EndpointConf{Path: "/trustmark"}, TrustMarkEndpointConfig{
+				Store:         fed.storages.TrustMarks,
+				SpecStore:     fed.storages.TrustMarkSpecs,
+				InstanceStore: fed.storages.TrustMarkInstances,
+			},
+		),
+	)
+	require.NoError(
+		t, fed.AddTrustMarkStatusEndpoint(
+			EndpointConf{Path: "/status"}, TrustMarkStatusConfig{InstanceStore: fed.storages.TrustMarkInstances},
+		),
+	)
+	app := fiber.New()
+	app.All("/*", fed.dispatch)
+	request := func() (*http.Response, string) {
+		params := url.Values{
+			"sub":             {subject},
+			"trust_mark_type": {trustMarkType},
+		}
+		resp, err := app.Test(httptest.NewRequest(http.MethodGet, "/trustmark?"+params.Encode(), nil), -1)
+		require.NoError(t, err)
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp, string(body)
+	}
+	setLifecycle := func(state model.TrustMarkLifecycleState) {
+		_, err := fed.storages.TrustMarkSpecs.Patch(
+			trustMarkType, map[string]any{
+				"lifecycle_state": string(state),
+				"successor_type":  successorType,
+			},
+		)
+		require.NoError(t, err)
+	}
+
+	resp, _ := request()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Empty(t, resp.Header.Get("Deprecation"))
+
+	// Deprecated trust marks are issued with a deprecation hint
+	setLifecycle(model.TrustMarkLifecycleDeprecated)
+	resp, trustMark := request()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Regexp(t, `^@\d+$`, resp.Header.Get("Deprecation"))
+	assert.Equal(t, `<`+successorType+`>; rel="successor-version"`, resp.Header.Get(fiber.HeaderLink))
+
+	// Retired trust marks are no longer issued, but their status is available
+	setLifecycle(model.TrustMarkLifecycleRetired)
+	resp, _ = request()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	assert.NotEmpty(t, resp.Header.Get("Deprecation"))
+	spec, err := fed.storages.TrustMarkSpecs.GetByType(trustMarkType)
+	require.NoError(t, err)
+	_, _, err = fed.issueTrustMarkInstance(
+		trustMarkType, subject, spec, fed.storages.TrustMarkSpecs, fed.storages.TrustMarkInstances,
+	)
+	assert.ErrorIs(t, err, errTrustMarkTypeRetired)
+
+	statusReq := httptest.NewRequest(
+		http.MethodPost, "/status", strings.NewReader(url.Values{"trust_mark": {trustMark}}.Encode()),
+	)
+	statusReq.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationForm)
+	resp, err = app.Test(statusReq, -1)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	status, err := jws.Parse(body)
+	require.NoError(t, err)
+	assert.Contains(t, string(status.Payload()), `"status":"active"`)
+
+	// A trust mark type retired in the federation is not issued either
+	setLifecycle(model.TrustMarkLifecycleActive)
+	fedType, err := fed.storages.TrustMarkTypes.Create(
+		model.AddTrustMarkType{TrustMarkType: trustMarkType, LifecycleState: model.TrustMarkLifecycleRetired},
+	)
+	require.NoError(t, err)
+	assert.NotZero(t, fedType.DeprecatedAt)
+	spec, err = fed.storages.TrustMarkSpecs.GetByType(trustMarkType)
+	require.NoError(t, err)
+	_, _, err = fed.issueTrustMarkInstance(
+		trustMarkType, subject, spec, fed.storages.TrustMarkSpecs, fed.storages.TrustMarkInstances,
+	)
+	assert.ErrorIs(t, err, errTrustMarkTypeRetired)
+	resp, _ = request()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	assert.Equal(t, fmt.Sprintf("@%d", fedType.DeprecatedAt), resp.Header.Get("Deprecation"))
+}
@@ -0,0 +1,57 @@
+package lighthouse
+
+import (
+	"fmt"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/pkg/errors"
+
+	"github.com/go-oidfed/lighthouse/storage/model"
+)
+
+// errTrustMarkTypeRetired is returned by issueTrustMarkInstance if the trust
+// mark type is retired.
+var errTrustMarkTypeRetired = errors.New("trust mark type is retired")
+
+// trustMarkLifecycle is the effective lifecycle of a trust mark type.
+type trustMarkLifecycle struct {
+	State         model.TrustMarkLifecycleState
+	SuccessorType string
+	// DeprecatedAt is the unix timestamp the type was deprecated at
+	DeprecatedAt int64
+}
+
+// trustMarkLifecycle returns the lifecycle of the passed trust mark type. The
+// lifecycle of the TrustMarkSpec takes precedence; if the spec is active, the
+// lifecycle of the federation's TrustMarkType is used.
+func (fed *LightHouse) trustMarkLifecycle(trustMarkType string, spec *model.TrustMarkSpec) trustMarkLifecycle {
+	if spec != nil && spec.LifecycleState.Deprecated() {
+		return trustMarkLifecycle{
+			State:         spec.LifecycleState,
+			SuccessorType: spec.SuccessorType,
+			DeprecatedAt:  spec.DeprecatedAt,
+		}
+	}
+	if fed.storages.TrustMarkTypes != nil {
+		if t, err := fed.storages.TrustMarkTypes.Get(trustMarkType); err == nil && t.LifecycleState.Deprecated() {
+			return trustMarkLifecycle{
+				State:         t.LifecycleState,
+				SuccessorType: t.SuccessorType,
+				DeprecatedAt:  t.DeprecatedAt,
+			}
+		}
+	}
+	return trustMarkLifecycle{State: model.TrustMarkLifecycleActive}
+}
+
+// setDeprecationHeaders sets the Deprecation (RFC 9745) and successor-version
+// Link (RFC 5829) headers for deprecated and retired trust mark types.
+func setDeprecationHeaders(ctx *fiber.Ctx, lifecycle trustMarkLifecycle) {
+	if !lifecycle.State.Deprecated() {
+		return
+	}
+	ctx.Set("Deprecation", fmt.Sprintf("@%d", lifecycle.DeprecatedAt))
+	if lifecycle.SuccessorType != "" {
+		ctx.Append(fiber.HeaderLink, fmt.Sprintf(`<%s>; rel="successor-version"`, lifecycle.SuccessorType))
+	}
+}
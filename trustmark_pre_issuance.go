@@ -0,0 +1,324 @@
+package lighthouse
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+
+	"github.com/go-oidfed/lighthouse/api/adminapi"
+	"github.com/go-oidfed/lighthouse/storage/model"
+)
+
+// preIssuedTrustMarkCacheTTL is how long pre-issued trust marks without
+// expiration are kept in the IssuedTrustMarkCache.
+const preIssuedTrustMarkCacheTTL = 24 * time.Hour
+
+// TrustMarkPreIssuanceConfig configures the TrustMarkPreIssuer.
+type TrustMarkPreIssuanceConfig struct {
+	// Interval is the time between two pre-issuance runs.
+	Interval time.Duration
+}
+
+func withPreIssuanceDefaults(conf TrustMarkPreIssuanceConfig) TrustMarkPreIssuanceConfig {
+	if conf.Interval <= 0 {
+		conf.Interval = time.Hour
+	}
+	return conf
+}
+
+// TrustMarkPreIssuer periodically issues trust marks in advance for the
+// active subjects of trust mark specs with pre-issuance whose trust marks are
+// missing or expire soon. The trust marks are kept in a bundle per trust mark
+// type and warm the IssuedTrustMarkCache of the trust mark endpoint.
+type TrustMarkPreIssuer struct {
+	fed    *LightHouse
+	conf   TrustMarkPreIssuanceConfig
+	runner periodicRunner
+}
+
+// NewTrustMarkPreIssuer creates a new TrustMarkPreIssuer for the passed
+// LightHouse.
+func NewTrustMarkPreIssuer(fed *LightHouse, conf TrustMarkPreIssuanceConfig) *TrustMarkPreIssuer {
+	return &TrustMarkPreIssuer{
+		fed:  fed,
+		conf: withPreIssuanceDefaults(conf),
+	}
+}
+
+// Start starts the periodic pre-issuance in the background. The first run
+// starts right away.
+func (p *TrustMarkPreIssuer) Start() {
+	p.runner.start(
+		periodicTask{
+			interval:   p.conf.Interval,
+			runAtStart: true,
+			run:        func(context.Context) { p.RunOnce() },
+		},
+	)
+	log.Info().Dur("interval", p.conf.Interval).Msg("trust mark pre-issuance started")
+}
+
+// Stop stops the periodic pre-issuance and waits for a running pre-issuance
+// to finish.
+func (p *TrustMarkPreIssuer) Stop() {
+	p.runner.stop()
+}
+
+// RunOnce issues trust marks to all active subjects whose pre-issued trust
+// marks are missing or expire soon.
+func (p *TrustMarkPreIssuer) RunOnce() {
+	results, err := p.fed.preIssueTrustMarks("", false)
+	if err != nil {
+		log.Warn().Err(err).Msg("trust mark pre-issuance failed")
+		return
+	}
+	for _, r := range results {
+		log.Debug().Str("trust_mark_type", r.TrustMarkType).Int("issued", r.Issued).
+			Int("current", r.Current).Int("failed", r.Failed).Msg("trust mark pre-issuance run finished")
+	}
+}
+
+// StartTrustMarkPreIssuance creates and starts a TrustMarkPreIssuer. It is
+// stopped with Stop.
+func (fed *LightHouse) StartTrustMarkPreIssuance(conf TrustMarkPreIssuanceConfig) *TrustMarkPreIssuer {
+	if fed.preIssuer != nil {
+		fed.preIssuer.Stop()
+	}
+	fed.preIssuer = NewTrustMarkPreIssuer(fed, conf)
+	fed.preIssuer.Start()
+	return fed.preIssuer
+}
+
+// loadTrustMarkBundle loads the bundle of pre-issued trust marks of the trust
+// mark type; it returns an empty bundle if none is stored.
+func (fed *LightHouse) loadTrustMarkBundle(trustMarkType string) (*adminapi.TrustMarkBundle, error) {
+	bundle := &adminapi.TrustMarkBundle{
+		TrustMarkType: trustMarkType,
+		TrustMarks:    []adminapi.PreIssuedTrustMark{},
+	}
+	if _, err := fed.storages.KV.GetAs(model.KeyValueScopeTrustMarkPreIssuance, trustMarkType, bundle); err != nil {
+		return nil, errors.Wrap(err, "failed to load trust mark bundle")
+	}
+	return bundle, nil
+}
+
+// preIssuanceDue reports whether a new trust mark should be issued in place of
+// the pre-issued one, i.e. if it is expired or less than a third of its
+// lifetime is left. Trust marks without expiration are never due.
+func preIssuanceDue(tm adminapi.PreIssuedTrustMark, now time.Time) bool {
+	if tm.ExpiresAt == 0 {
+		return false
+	}
+	return tm.ExpiresAt-now.Unix() <= (tm.ExpiresAt-tm.IssuedAt)/3
+}
+
+// preIssuedTrustMarkActive reports whether a pre-issued trust mark can still
+// be handed out, i.e. it verifies with the current keys and its instance is
+// neither revoked nor expired.
+func (fed *LightHouse) preIssuedTrustMarkActive(tm adminapi.PreIssuedTrustMark) bool {
+	status, err := fed.determineTrustMarkStatus(
+		tm.TrustMark, TrustMarkStatusConfig{InstanceStore: fed.storages.TrustMarkInstances},
+	)
+	return err == nil && status == model.TrustMarkStatusActive
+}
+
+// deleteTrustMarkBundles removes the stored bundles of pre-issued trust
+// marks, so the next pre-issuance run issues new trust marks; trustMarkType
+// "" removes the bundles of all trust mark types. It is called when the
+// stored trust marks must no longer be handed out, e.g. after a key
+// compromise or a delegation change.
+func (fed *LightHouse) deleteTrustMarkBundles(trustMarkType string) {
+	if fed.storages.KV == nil || fed.storages.TrustMarkSpecs == nil {
+		return
+	}
+	fed.preIssueMu.Lock()
+	defer fed.preIssueMu.Unlock()
+	types := []string{trustMarkType}
+	if trustMarkType == "" {
+		specs, err := fed.storages.TrustMarkSpecs.List()
+		if err != nil {
+			log.Error().Err(err).Msg("failed to list trust mark specs to delete trust mark bundles")
+			return
+		}
+		types = types[:0]
+		for _, spec := range specs {
+			types = append(types, spec.TrustMarkType)
+		}
+	}
+	for _, t := range types {
+		if err := fed.storages.KV.Delete(model.KeyValueScopeTrustMarkPreIssuance, t); err != nil {
+			log.Error().Err(err).Str("trust_mark_type", t).Msg("failed to delete trust mark bundle")
+		}
+	}
+}
+
+// cachePreIssuedTrustMark puts a pre-issued trust mark into the
+// IssuedTrustMarkCache until it expires.
+func (fed *LightHouse) cachePreIssuedTrustMark(trustMarkType string, tm adminapi.PreIssuedTrustMark) {
+	if fed.issuedTrustMarkCache == nil {
+		return
+	}
+	ttl := preIssuedTrustMarkCacheTTL
+	if tm.ExpiresAt != 0 {
+		ttl = time.Until(time.Unix(tm.ExpiresAt, 0))
+	}
+	fed.issuedTrustMarkCache.Set(trustMarkType, tm.Subject, tm.TrustMark, ttl)
+}
+
+// preIssueTrustMarks issues trust marks for the active subjects of the trust
+// mark specs with pre-issuance and stores the resulting bundles. Trust marks
+// of subjects that are no longer active or outside their validity window are
+// removed from the bundles.
+func (fed *LightHouse) preIssueTrustMarks(trustMarkType string, force bool) (
+	[]adminapi.TrustMarkPreIssuance, error,
+) {
+	results := []adminapi.TrustMarkPreIssuance{}
+	if fed.storages.TrustMarkSpecs == nil {
+		return results, nil
+	}
+	fed.preIssueMu.Lock()
+	defer fed.preIssueMu.Unlock()
+
+	specs, err := fed.storages.TrustMarkSpecs.List()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list trust mark specs")
+	}
+	sort.Slice(specs, func(i, j int) bool { return specs[i].TrustMarkType < specs[j].TrustMarkType })
+	active := model.StatusActive
+	for _, spec := range specs {
+		if !spec.PreIssuance || spec.LifecycleState == model.TrustMarkLifecycleRetired ||
+			(trustMarkType != "" && spec.TrustMarkType != trustMarkType) {
+			continue
+		}
+		subjects, err := fed.storages.TrustMarkSpecs.ListSubjects(fmt.Sprintf("%d", spec.ID), &active)
+		if err != nil {
+			log.Warn().Err(err).Str("trust_mark_type", spec.TrustMarkType).
+				Msg("trust mark pre-issuance: failed to list trust mark subjects")
+			continue
+		}
+		previous, err := fed.loadTrustMarkBundle(spec.TrustMarkType)
+		if err != nil {
+			return nil, err
+		}
+		existing := make(map[string]adminapi.PreIssuedTrustMark, len(previous.TrustMarks))
+		for _, tm := range previous.TrustMarks {
+			existing[tm.Subject] = tm
+		}
+
+		now := time.Now()
+		result := adminapi.TrustMarkPreIssuance{TrustMarkType: spec.TrustMarkType}
+		bundle := adminapi.TrustMarkBundle{
+			TrustMarkType: spec.TrustMarkType,
+			UpdatedAt:     now.Unix(),
+			TrustMarks:    []adminapi.PreIssuedTrustMark{},
+		}
+		for _, subject := range subjects {
+			if !subject.WithinValidity(now) {
+				continue
+			}
+			current, ok := existing[subject.EntityID]
+			ok = ok && fed.preIssuedTrustMarkActive(current)
+			if ok && !force && !preIssuanceDue(current, now) {
+				result.Current++
+				bundle.TrustMarks = append(bundle.TrustMarks, current)
+				fed.cachePreIssuedTrustMark(spec.TrustMarkType, current)
+				continue
+			}
+			if err = fed.checkSpecEligibility(context.Background(), &spec, subject.EntityID); err != nil {
+				log.Debug().Err(err).Str("trust_mark_type", spec.TrustMarkType).
+					Str("subject", subject.EntityID).Msg("trust mark pre-issuance: subject is not eligible")
+				result.Ineligible++
+				if fed.issuedTrustMarkCache != nil {
+					fed.issuedTrustMarkCache.Invalidate(spec.TrustMarkType, subject.EntityID)
+				}
+				continue
+			}
+			tm, expiresAt, err := fed.issueTrustMarkInstance(
+				spec.TrustMarkType, subject.EntityID, &spec, fed.storages.TrustMarkSpecs,
+				fed.storages.TrustMarkInstances,
+			)
+			if err != nil {
+				log.Warn().Err(err).Str("trust_mark_type", spec.TrustMarkType).
+					Str("subject", subject.EntityID).Msg("trust mark pre-issuance: failed to issue trust mark")
+				result.Failed++
+				// A still valid trust mark is kept in the bundle
+				if ok && (current.ExpiresAt == 0 || current.ExpiresAt > now.Unix()) {
+					bundle.TrustMarks = append(bundle.TrustMarks, current)
+				}
+				continue
+			}
+			issued := adminapi.PreIssuedTrustMark{
+				Subject:   subject.EntityID,
+				TrustMark: tm,
+				IssuedAt:  now.Unix(),
+			}
+			if expiresAt != nil {
+				issued.ExpiresAt = expiresAt.Unix()
+			}
+			result.Issued++
+			bundle.TrustMarks = append(bundle.TrustMarks, issued)
+			fed.cachePreIssuedTrustMark(spec.TrustMarkType, issued)
+		}
+		sort.Slice(
+			bundle.TrustMarks, func(i, j int) bool { return bundle.TrustMarks[i].Subject < bundle.TrustMarks[j].Subject },
+		)
+		if err = fed.storages.KV.SetAny(
+			model.KeyValueScopeTrustMarkPreIssuance, spec.TrustMarkType, bundle,
+		); err != nil {
+			return nil, errors.Wrap(err, "failed to store trust mark bundle")
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// PreIssueTrustMarks implements the adminapi.LighthouseController interface.
+func (fed *LightHouse) PreIssueTrustMarks(trustMarkType string, force bool) (
+	[]adminapi.TrustMarkPreIssuance, error,
+) {
+	return fed.preIssueTrustMarks(trustMarkType, force)
+}
+
+// TrustMarkBundle implements the adminapi.LighthouseController interface.
+// Trust marks that expired, were revoked or are no longer verifiable with
+// the current keys, and trust marks of subjects that are no longer active are
+// left out.
+func (fed *LightHouse) TrustMarkBundle(trustMarkType string) (*adminapi.TrustMarkBundle, error) {
+	if fed.storages.TrustMarkSpecs == nil {
+		return nil, model.NotFoundError("trust mark spec not found")
+	}
+	spec, err := fed.storages.TrustMarkSpecs.GetByType(trustMarkType)
+	if err != nil {
+		return nil, err
+	}
+	if !spec.PreIssuance {
+		return nil, model.NotFoundError("pre-issuance is not enabled for this trust mark type")
+	}
+	active := model.StatusActive
+	subjects, err := fed.storages.TrustMarkSpecs.ListSubjects(fmt.Sprintf("%d", spec.ID), &active)
+	if err != nil {
+		return nil, err
+	}
+	activeSubjects := make(map[string]bool, len(subjects))
+	for _, subject := range subjects {
+		activeSubjects[subject.EntityID] = true
+	}
+	bundle, err := fed.loadTrustMarkBundle(trustMarkType)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().Unix()
+	current := make([]adminapi.PreIssuedTrustMark, 0, len(bundle.TrustMarks))
+	for _, tm := range bundle.TrustMarks {
+		if activeSubjects[tm.Subject] && (tm.ExpiresAt == 0 || tm.ExpiresAt > now) &&
+			fed.preIssuedTrustMarkActive(tm) {
+			current = append(current, tm)
+		}
+	}
+	bundle.TrustMarks = current
+	return bundle, nil
+}
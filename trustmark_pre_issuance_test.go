@@ -0,0 +1,186 @@
+package lighthouse
+
+import (
+	"testing"
+	"time"
+
+	oidfed "github.com/go-oidfed/lib"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/go-oidfed/lighthouse/api/adminapi"
+	"github.com/go-oidfed/lighthouse/storage/model"
+)
+
+func TestPreIssueTrustMarks(t *testing.T) {
+	fed, _ := newTestLightHouse(t)
+	setDelegationTestSigner(t, fed)
+	fed.TrustMarkIssuer = oidfed.NewTrustMarkIssuer(
+		fed.FederationEntity.EntityID(), fed.PurposeSigner(model.SigningPurposeTrustMarks).TrustMarkSigner(), nil,
+	)
+	fed.TrustMarkIssuer.SetProvider(NewDBTrustMarkSpecProvider(fed.storages.TrustMarkSpecs))
+	fed.issuedTrustMarkCache = NewIssuedTrustMarkCache()
+	const (
+		trustMarkType = "https://lighthouse.example.org/tm/pre-issued"
+		onDemandType  = "https://lighthouse.example.org/tm/on-demand"
+		subjectA      = "https://a.example.org"
+		subjectB      = "https://b.example.org"
+		blocked       = "https://blocked.example.org"
+	)
+
+	_, err := fed.storages.TrustMarkSpecs.Create(
+		&model.AddTrustMarkSpec{
+			TrustMarkType: trustMarkType,
+			Lifetime:      3600,
+			PreIssuance:   true,
+		},
+	)
+	require.NoError(t, err)
+	_, err = fed.storages.TrustMarkSpecs.Create(
+		&model.AddTrustMarkSpec{
+			TrustMarkType: onDemandType,
+			Lifetime:      3600,
+		},
+	)
+	require.NoError(t, err)
+	for _, sub := range []struct {
+		entityID string
+		status   model.Status
+	}{
+		{subjectA, model.StatusActive},
+		{subjectB, model.StatusActive},
+		{blocked, model.StatusBlocked},
+	} {
+		_, err = fed.storages.TrustMarkSpecs.CreateSubject(
+			trustMarkType, &model.AddTrustMarkSubject{
+				EntityID: sub.entityID,
+				Status:   sub.status,
+			},
+		)
+		require.NoError(t, err)
+	}
+	_, err = fed.storages.TrustMarkSpecs.CreateSubject(
+		onDemandType, &model.AddTrustMarkSubject{
+			EntityID: subjectA,
+			Status:   model.StatusActive,
+		},
+	)
+	require.NoError(t, err)
+
+	// Only the spec with pre-issuance is considered
+	results, err := fed.PreIssueTrustMarks("", false)
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.Equal(
+		t, adminapi.TrustMarkPreIssuance{TrustMarkType: trustMarkType, Issued: 2}, results[0],
+	)
+
+	bundle, err := fed.TrustMarkBundle(trustMarkType)
+	require.NoError(t, err)
+	require.Len(t, bundle.TrustMarks, 2)
+	assert.Equal(t, subjectA, bundle.TrustMarks[0].Subject)
+	assert.Equal(t, subjectB, bundle.TrustMarks[1].Subject)
+	assert.NotZero(t, bundle.UpdatedAt)
+	first := bundle.TrustMarks[0]
+	assert.Equal(t, first.IssuedAt+3600, first.ExpiresAt)
+	tm, err := oidfed.ParseTrustMark([]byte(first.TrustMark))
+	require.NoError(t, err)
+	assert.Equal(t, subjectA, tm.Subject)
+	assert.Equal(t, trustMarkType, tm.TrustMarkType)
+
+	// The pre-issued trust marks are served from the cache
+	cached, ok := fed.issuedTrustMarkCache.Get(trustMarkType, subjectA)
+	require.True(t, ok)
+	assert.Equal(t, first.TrustMark, cached)
+
+	// Current trust marks are kept, unless forced
+	results, err = fed.PreIssueTrustMarks(trustMarkType, false)
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.Equal(
+		t, adminapi.TrustMarkPreIssuance{TrustMarkType: trustMarkType, Current: 2}, results[0],
+	)
+	results, err = fed.PreIssueTrustMarks(trustMarkType, true)
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.Equal(
+		t, adminapi.TrustMarkPreIssuance{TrustMarkType: trustMarkType, Issued: 2}, results[0],
+	)
+
+	// Subjects that are no longer active are left out of the bundle right
+	// away and dropped by the next run
+	_, err = fed.storages.TrustMarkSpecs.ChangeSubjectStatus(trustMarkType, subjectB, model.StatusBlocked)
+	require.NoError(t, err)
+	bundle, err = fed.TrustMarkBundle(trustMarkType)
+	require.NoError(t, err)
+	require.Len(t, bundle.TrustMarks, 1)
+	assert.Equal(t, subjectA, bundle.TrustMarks[0].Subject)
+	results, err = fed.PreIssueTrustMarks(trustMarkType, false)
+	require.NoError(t, err)
+	assert.Equal(
+		t, adminapi.TrustMarkPreIssuance{TrustMarkType: trustMarkType, Current: 1}, results[0],
+	)
+
+	// Revoked trust marks are neither kept nor handed out
+	tm, err = oidfed.ParseTrustMark([]byte(bundle.TrustMarks[0].TrustMark))
+	require.NoError(t, err)
+	jti, _ := tm.Extra["jti"].(string)
+	require.NoError(t, fed.storages.TrustMarkInstances.Revoke(jti))
+	bundle, err = fed.TrustMarkBundle(trustMarkType)
+	require.NoError(t, err)
+	assert.Empty(t, bundle.TrustMarks)
+	results, err = fed.PreIssueTrustMarks(trustMarkType, false)
+	require.NoError(t, err)
+	assert.Equal(
+		t, adminapi.TrustMarkPreIssuance{TrustMarkType: trustMarkType, Issued: 1}, results[0],
+	)
+
+	// Stored bundles are dropped when signed trust marks are purged
+	fed.purgeSignedCaches()
+	bundle, err = fed.TrustMarkBundle(trustMarkType)
+	require.NoError(t, err)
+	assert.Empty(t, bundle.TrustMarks)
+
+	// Subjects failing the eligibility check of the spec are not issued
+	_, err = fed.storages.TrustMarkSpecs.Patch(
+		"1", map[string]any{
+			"eligibility_config": &model.EligibilityConfig{Mode: model.EligibilityModeCheckOnly},
+		},
+	)
+	require.NoError(t, err)
+	results, err = fed.PreIssueTrustMarks(trustMarkType, false)
+	require.NoError(t, err)
+	assert.Equal(
+		t, adminapi.TrustMarkPreIssuance{TrustMarkType: trustMarkType, Ineligible: 1}, results[0],
+	)
+	_, ok = fed.issuedTrustMarkCache.Get(trustMarkType, subjectA)
+	assert.False(t, ok)
+
+	// No bundle exists for specs without pre-issuance
+	_, err = fed.TrustMarkBundle(onDemandType)
+	var notFound model.NotFoundError
+	assert.ErrorAs(t, err, &notFound)
+}
+
+func TestPreIssuanceDue(t *testing.T) {
+	now := time.Now()
+	issuedAt := now.Add(-time.Hour).Unix()
+	tests := []struct {
+		name      string
+		expiresAt int64
+		due       bool
+	}{
+		{"no expiration", 0, false},
+		{"fresh", now.Add(2 * time.Hour).Unix(), false},
+		{"expiring soon", now.Add(10 * time.Minute).Unix(), true},
+		{"expired", now.Add(-time.Minute).Unix(), true},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				tm := adminapi.PreIssuedTrustMark{IssuedAt: issuedAt, ExpiresAt: tt.expiresAt}
+				assert.Equal(t, tt.due, preIssuanceDue(tm, now))
+			},
+		)
+	}
+}
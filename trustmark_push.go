@@ -0,0 +1,544 @@
+package lighthouse
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	oidfed "github.com/go-oidfed/lib"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+
+	"github.com/go-oidfed/lighthouse/api/adminapi"
+	"github.com/go-oidfed/lighthouse/storage/model"
+)
+
+const (
+	// TrustMarkPushEndpointMetadataKey is the federation_entity metadata
+	// claim under which trust mark subjects publish the URL trust marks are
+	// pushed to.
+	TrustMarkPushEndpointMetadataKey = "federation_trust_mark_push_endpoint"
+	// JWTTypeTrustMarkDelivery is the JWT type of the signed requests trust
+	// marks are pushed with.
+	JWTTypeTrustMarkDelivery = "trust-mark-delivery+jwt"
+	// ContentTypeTrustMarkDelivery is the media type of the signed requests
+	// trust marks are pushed with.
+	ContentTypeTrustMarkDelivery = "application/trust-mark-delivery+jwt"
+
+	// trustMarkDeliveryLifetime is the lifetime of a signed push request.
+	trustMarkDeliveryLifetime = 5 * time.Minute
+	// trustMarkPushHTTPTimeout is the timeout for pushing a trust mark.
+	trustMarkPushHTTPTimeout = 30 * time.Second
+	// maxTrustMarkPushResponseSize limits how much of the subject's response
+	// to a push is read.
+	maxTrustMarkPushResponseSize = 64 * 1024
+	// trustMarkPushActor is the actor recorded in the delivery log.
+	trustMarkPushActor = "push_delivery"
+)
+
+// TrustMarkPushConfig configures the TrustMarkPushDeliverer.
+type TrustMarkPushConfig struct {
+	// Interval is the time between two delivery runs.
+	Interval time.Duration
+	// MaxAttempts is the number of delivery attempts before a push is given
+	// up.
+	MaxAttempts int
+	// RetryBackoff is the delay before the first retry; it doubles with each
+	// further attempt.
+	RetryBackoff time.Duration
+	// MaxBackoff caps the delay between two attempts.
+	MaxBackoff time.Duration
+}
+
+func withPushDefaults(conf TrustMarkPushConfig) TrustMarkPushConfig {
+	if conf.Interval <= 0 {
+		conf.Interval = 5 * time.Minute
+	}
+	if conf.MaxAttempts <= 0 {
+		conf.MaxAttempts = 8
+	}
+	if conf.RetryBackoff <= 0 {
+		conf.RetryBackoff = time.Minute
+	}
+	if conf.MaxBackoff <= 0 {
+		conf.MaxBackoff = 6 * time.Hour
+	}
+	return conf
+}
+
+// backoff returns the delay before the next attempt after the passed number
+// of failed attempts.
+func (conf TrustMarkPushConfig) backoff(attempts int) time.Duration {
+	d := conf.RetryBackoff
+	for i := 1; i < attempts && d < conf.MaxBackoff; i++ {
+		d *= 2
+	}
+	return min(d, conf.MaxBackoff)
+}
+
+// TrustMarkPushDeliverer periodically issues trust marks for the subjects of
+// trust mark specs with push delivery whose trust marks are missing or expire
+// soon, pushes them to the subjects, and retries failed deliveries.
+type TrustMarkPushDeliverer struct {
+	fed    *LightHouse
+	conf   TrustMarkPushConfig
+	runner periodicRunner
+}
+
+// NewTrustMarkPushDeliverer creates a new TrustMarkPushDeliverer for the
+// passed LightHouse.
+func NewTrustMarkPushDeliverer(fed *LightHouse, conf TrustMarkPushConfig) *TrustMarkPushDeliverer {
+	return &TrustMarkPushDeliverer{
+		fed:  fed,
+		conf: withPushDefaults(conf),
+	}
+}
+
+// Start starts the periodic delivery in the background. The first run starts
+// right away.
+func (d *TrustMarkPushDeliverer) Start() {
+	d.runner.start(
+		periodicTask{
+			interval:   d.conf.Interval,
+			runAtStart: true,
+			run:        func(context.Context) { d.RunOnce() },
+		},
+	)
+	log.Info().Dur("interval", d.conf.Interval).Msg("trust mark push delivery started")
+}
+
+// Stop stops the periodic delivery and waits for a running delivery to
+// finish.
+func (d *TrustMarkPushDeliverer) Stop() {
+	d.runner.stop()
+}
+
+// RunOnce retries pending pushes and pushes trust marks to all subjects whose
+// trust marks are missing or expire soon.
+func (d *TrustMarkPushDeliverer) RunOnce() {
+	pushes, err := d.fed.pushTrustMarks(d.conf, "", false)
+	if err != nil {
+		log.Warn().Err(err).Msg("trust mark push: delivery failed")
+		return
+	}
+	log.Debug().Int("pushes", len(pushes)).Msg("trust mark push: delivery run finished")
+}
+
+// StartTrustMarkPushDelivery creates and starts a TrustMarkPushDeliverer. It
+// is stopped with Stop.
+func (fed *LightHouse) StartTrustMarkPushDelivery(conf TrustMarkPushConfig) *TrustMarkPushDeliverer {
+	if fed.pushDeliverer != nil {
+		fed.pushDeliverer.Stop()
+	}
+	fed.pushDeliverer = NewTrustMarkPushDeliverer(fed, conf)
+	fed.pushDeliverer.Start()
+	return fed.pushDeliverer
+}
+
+// pendingTrustMarkPush is a push whose delivery failed and is retried; it is
+// persisted together with the pushed trust mark.
+type pendingTrustMarkPush struct {
+	adminapi.TrustMarkPush
+	SubjectID uint   `json:"subject_id"`
+	TrustMark string `json:"trust_mark"`
+	// SelfPublishedURL is set if the URL was taken from the entity
+	// configuration of the subject; such URLs are only pushed to over https
+	// and only if they resolve to public addresses.
+	SelfPublishedURL bool `json:"self_published_url,omitempty"`
+}
+
+func pushKey(trustMarkType, subject string) string {
+	return trustMarkType + "|" + subject
+}
+
+func (fed *LightHouse) pendingTrustMarkPushes() (map[string]pendingTrustMarkPush, error) {
+	pending := make(map[string]pendingTrustMarkPush)
+	if _, err := fed.storages.KV.GetAs(
+		model.KeyValueScopeTrustMarkPush, model.KeyValueKeyPendingPushes, &pending,
+	); err != nil {
+		return nil, errors.Wrap(err, "failed to load pending trust mark pushes")
+	}
+	return pending, nil
+}
+
+func sortedPushes(pushes []adminapi.TrustMarkPush) []adminapi.TrustMarkPush {
+	sort.Slice(
+		pushes, func(i, j int) bool {
+			if pushes[i].TrustMarkType != pushes[j].TrustMarkType {
+				return pushes[i].TrustMarkType < pushes[j].TrustMarkType
+			}
+			return pushes[i].Subject < pushes[j].Subject
+		},
+	)
+	return pushes
+}
+
+// PendingTrustMarkPushes implements the adminapi.LighthouseController
+// interface.
+func (fed *LightHouse) PendingTrustMarkPushes() ([]adminapi.TrustMarkPush, error) {
+	pending, err := fed.pendingTrustMarkPushes()
+	if err != nil {
+		return nil, err
+	}
+	pushes := make([]adminapi.TrustMarkPush, 0, len(pending))
+	for _, p := range pending {
+		pushes = append(pushes, p.TrustMarkPush)
+	}
+	return sortedPushes(pushes), nil
+}
+
+// PushTrustMarks implements the adminapi.LighthouseController interface.
+func (fed *LightHouse) PushTrustMarks(trustMarkType string, force bool) ([]adminapi.TrustMarkPush, error) {
+	conf := TrustMarkPushConfig{}
+	if fed.pushDeliverer != nil {
+		conf = fed.pushDeliverer.conf
+	}
+	return fed.pushTrustMarks(withPushDefaults(conf), trustMarkType, force)
+}
+
+// trustMarkPushDue reports whether a new trust mark should be pushed to a
+// subject, i.e. if the subject has no valid trust mark of the type or less
+// than a third of its lifetime is left. Trust marks without expiration are
+// never due.
+func trustMarkPushDue(instances []model.IssuedTrustMarkInstance, now time.Time) bool {
+	var latest *model.IssuedTrustMarkInstance
+	for i, instance := range instances {
+		if instance.Revoked || (instance.ExpiresAt != 0 && int64(instance.ExpiresAt) <= now.Unix()) {
+			continue
+		}
+		if instance.ExpiresAt == 0 {
+			return false
+		}
+		if latest == nil || instance.ExpiresAt > latest.ExpiresAt {
+			latest = &instances[i]
+		}
+	}
+	if latest == nil {
+		return true
+	}
+	return int64(latest.ExpiresAt)-now.Unix() <= int64(latest.ExpiresAt-latest.CreatedAt)/3
+}
+
+// pushTrustMarks retries the pending pushes that are due and issues and
+// pushes trust marks for the subjects of the trust mark specs with push
+// delivery. It returns the pushes attempted in this run.
+func (fed *LightHouse) pushTrustMarks(conf TrustMarkPushConfig, trustMarkType string, force bool) (
+	[]adminapi.TrustMarkPush, error,
+) {
+	if fed.storages.TrustMarkSpecs == nil {
+		return []adminapi.TrustMarkPush{}, nil
+	}
+	fed.pushMu.Lock()
+	defer fed.pushMu.Unlock()
+
+	pending, err := fed.pendingTrustMarkPushes()
+	if err != nil {
+		return nil, err
+	}
+	specs, err := fed.storages.TrustMarkSpecs.List()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list trust mark specs")
+	}
+	now := time.Now()
+	pushes := []adminapi.TrustMarkPush{}
+	// retried holds the pushes retried in this run, so that no further trust
+	// mark is issued for them
+	retried := make(map[string]bool)
+
+	for key, p := range pending {
+		if (trustMarkType != "" && p.TrustMarkType != trustMarkType) ||
+			(!force && p.NextAttemptAt > now.Unix()) {
+			continue
+		}
+		if p.ExpiresAt != 0 && p.ExpiresAt <= now.Unix() {
+			// A new trust mark is issued below
+			delete(pending, key)
+			continue
+		}
+		if err = fed.pendingTrustMarkPushValid(p); err != nil {
+			if !errors.Is(err, errTrustMarkPushObsolete) {
+				log.Warn().Err(err).Str("trust_mark_type", p.TrustMarkType).Str("subject", p.Subject).
+					Msg("trust mark push: failed to re-validate pending push")
+				continue
+			}
+			// The subject is no longer entitled to the pushed trust mark
+			log.Info().Err(err).Str("trust_mark_type", p.TrustMarkType).Str("subject", p.Subject).
+				Msg("trust mark push: dropping pending push")
+			fed.recordTrustMarkPushEvent(
+				p.SubjectID, model.EventTypeTrustMarkPushFailed, "pending push dropped: "+err.Error(),
+			)
+			delete(pending, key)
+			continue
+		}
+		fed.deliverTrustMarkPush(conf, &p, now)
+		pushes = append(pushes, p.TrustMarkPush)
+		retried[key] = true
+		if p.DeliveredAt != 0 || p.NextAttemptAt == 0 {
+			delete(pending, key)
+		} else {
+			pending[key] = p
+		}
+	}
+
+	active := model.StatusActive
+	for _, spec := range specs {
+		if !spec.PushDelivery || spec.LifecycleState == model.TrustMarkLifecycleRetired ||
+			(trustMarkType != "" && spec.TrustMarkType != trustMarkType) {
+			continue
+		}
+		subjects, err := fed.storages.TrustMarkSpecs.ListSubjects(fmt.Sprintf("%d", spec.ID), &active)
+		if err != nil {
+			log.Warn().Err(err).Str("trust_mark_type", spec.TrustMarkType).
+				Msg("trust mark push: failed to list trust mark subjects")
+			continue
+		}
+		for _, subject := range subjects {
+			key := pushKey(spec.TrustMarkType, subject.EntityID)
+			if _, ok := pending[key]; ok || retried[key] || !subject.WithinValidity(now) {
+				continue
+			}
+			if !force && fed.storages.TrustMarkInstances != nil {
+				instances, err := fed.storages.TrustMarkInstances.ListBySubject(spec.TrustMarkType, subject.EntityID)
+				if err != nil {
+					log.Warn().Err(err).Str("trust_mark_type", spec.TrustMarkType).
+						Str("subject", subject.EntityID).Msg("trust mark push: failed to list issued trust marks")
+					continue
+				}
+				if !trustMarkPushDue(instances, now) {
+					continue
+				}
+			}
+			p, err := fed.issueTrustMarkPush(spec, subject)
+			if err != nil {
+				log.Warn().Err(err).Str("trust_mark_type", spec.TrustMarkType).
+					Str("subject", subject.EntityID).Msg("trust mark push: failed to issue trust mark")
+				fed.recordTrustMarkPushEvent(
+					subject.ID, model.EventTypeTrustMarkPushFailed, err.Error(),
+				)
+				continue
+			}
+			fed.deliverTrustMarkPush(conf, p, now)
+			pushes = append(pushes, p.TrustMarkPush)
+			if p.DeliveredAt == 0 && p.NextAttemptAt != 0 {
+				pending[key] = *p
+			}
+		}
+	}
+
+	if err = fed.storages.KV.SetAny(
+		model.KeyValueScopeTrustMarkPush, model.KeyValueKeyPendingPushes, pending,
+	); err != nil {
+		return nil, errors.Wrap(err, "failed to store pending trust mark pushes")
+	}
+	return sortedPushes(pushes), nil
+}
+
+// issueTrustMarkPush resolves the push URL of the subject and issues a new
+// trust mark for it.
+func (fed *LightHouse) issueTrustMarkPush(spec model.TrustMarkSpec, subject model.TrustMarkSubject) (
+	*pendingTrustMarkPush, error,
+) {
+	if err := fed.checkSpecEligibility(context.Background(), &spec, subject.EntityID); err != nil {
+		return nil, err
+	}
+	pushURL, selfPublished, err := trustMarkPushURL(subject)
+	if err != nil {
+		return nil, err
+	}
+	tm, expiresAt, err := fed.issueTrustMarkInstance(
+		spec.TrustMarkType, subject.EntityID, &spec, fed.storages.TrustMarkSpecs, fed.storages.TrustMarkInstances,
+	)
+	if err != nil {
+		return nil, err
+	}
+	p := &pendingTrustMarkPush{
+		TrustMarkPush: adminapi.TrustMarkPush{
+			TrustMarkType: spec.TrustMarkType,
+			Subject:       subject.EntityID,
+			URL:           pushURL,
+		},
+		SubjectID:        subject.ID,
+		TrustMark:        tm,
+		SelfPublishedURL: selfPublished,
+	}
+	if expiresAt != nil {
+		p.ExpiresAt = expiresAt.Unix()
+	}
+	return p, nil
+}
+
+// errTrustMarkPushObsolete is returned by pendingTrustMarkPushValid if a
+// pending push must no longer be delivered.
+var errTrustMarkPushObsolete = errors.New("pending trust mark push is obsolete")
+
+// pendingTrustMarkPushValid checks before a retry that the subject is still
+// active for the trust mark type and that the pushed trust mark was not
+// revoked and still verifies with the current keys.
+func (fed *LightHouse) pendingTrustMarkPushValid(p pendingTrustMarkPush) error {
+	subject, err := fed.storages.TrustMarkSpecs.GetSubject(p.TrustMarkType, p.Subject)
+	if err != nil {
+		var notFound model.NotFoundError
+		if errors.As(err, &notFound) {
+			return errors.Wrap(errTrustMarkPushObsolete, "subject not found")
+		}
+		return err
+	}
+	if subject.Status != model.StatusActive || !subject.WithinValidity(time.Now()) {
+		return errors.Wrapf(errTrustMarkPushObsolete, "subject is %s for this trust mark type", subject.Status)
+	}
+	status, err := fed.determineTrustMarkStatus(
+		p.TrustMark, TrustMarkStatusConfig{InstanceStore: fed.storages.TrustMarkInstances},
+	)
+	if err != nil || status != model.TrustMarkStatusActive {
+		return errors.Wrapf(errTrustMarkPushObsolete, "pushed trust mark is %s", status)
+	}
+	return nil
+}
+
+// trustMarkPushURL returns the URL trust marks are pushed to: the push url
+// configured for the subject or the federation_trust_mark_push_endpoint of
+// the subject. selfPublished is set in the latter case; such a URL must be an
+// https URL.
+func trustMarkPushURL(subject model.TrustMarkSubject) (pushURL string, selfPublished bool, err error) {
+	if subject.PushURL != "" {
+		return subject.PushURL, false, nil
+	}
+	ec, err := oidfed.GetEntityConfiguration(subject.EntityID)
+	if err != nil {
+		return "", false, errors.Wrap(err, "could not obtain entity configuration of the subject")
+	}
+	var endpoint string
+	if ec.Metadata != nil && ec.Metadata.FederationEntity != nil {
+		endpoint, _ = ec.Metadata.FederationEntity.Extra[TrustMarkPushEndpointMetadataKey].(string)
+	}
+	if endpoint == "" {
+		return "", false, errors.Errorf(
+			"no push url configured and the subject does not publish a %s", TrustMarkPushEndpointMetadataKey,
+		)
+	}
+	if err = checkSelfPublishedPushURL(endpoint); err != nil {
+		return "", false, err
+	}
+	return endpoint, true, nil
+}
+
+// checkSelfPublishedPushURL checks that a push URL published by the subject
+// is an https URL without user info.
+func checkSelfPublishedPushURL(pushURL string) error {
+	u, err := url.Parse(pushURL)
+	if err != nil || u.Scheme != "https" || u.Host == "" || u.User != nil {
+		return errors.Errorf("invalid %s '%s': must be an https url", TrustMarkPushEndpointMetadataKey, pushURL)
+	}
+	return nil
+}
+
+// deliverTrustMarkPush makes a delivery attempt and records it in the
+// delivery log. After a failed attempt the next attempt is scheduled with
+// exponential backoff, unless the maximum number of attempts is reached; in
+// that case NextAttemptAt is zero.
+func (fed *LightHouse) deliverTrustMarkPush(conf TrustMarkPushConfig, p *pendingTrustMarkPush, now time.Time) {
+	err := fed.sendTrustMarkPush(p)
+	if err == nil {
+		p.DeliveredAt = now.Unix()
+		p.NextAttemptAt = 0
+		p.Error = ""
+		fed.recordTrustMarkPushEvent(p.SubjectID, model.EventTypeTrustMarkPushed, "trust mark pushed to "+p.URL)
+		return
+	}
+	p.Attempts++
+	p.Error = err.Error()
+	log.Warn().Err(err).Str("trust_mark_type", p.TrustMarkType).Str("subject", p.Subject).
+		Int("attempts", p.Attempts).Msg("trust mark push: delivery attempt failed")
+	if p.Attempts >= conf.MaxAttempts {
+		p.NextAttemptAt = 0
+		msg := fmt.Sprintf("giving up after %d attempts: %s", p.Attempts, p.Error)
+		fed.recordTrustMarkPushEvent(p.SubjectID, model.EventTypeTrustMarkPushFailed, msg)
+		fed.notify(
+			Notification{
+				Type:     model.EventTypeTrustMarkPushFailed,
+				Severity: NotificationSeverityWarning,
+				Subject:  p.Subject,
+				Message:  "pushing trust mark failed, " + msg,
+				Details: map[string]any{
+					"trust_mark_type": p.TrustMarkType,
+					"url":             p.URL,
+				},
+			},
+		)
+		return
+	}
+	p.NextAttemptAt = now.Add(conf.backoff(p.Attempts)).Unix()
+	fed.recordTrustMarkPushEvent(
+		p.SubjectID, model.EventTypeTrustMarkPushFailed, fmt.Sprintf("attempt %d: %s", p.Attempts, p.Error),
+	)
+}
+
+// sendTrustMarkPush posts the trust mark in a request JWT signed with the
+// federation key, so the subject can authenticate it with the JWKS from
+// LightHouse's entity configuration.
+func (fed *LightHouse) sendTrustMarkPush(p *pendingTrustMarkPush) error {
+	now := time.Now()
+	body, err := fed.GeneralJWTSigner.Typed(JWTTypeTrustMarkDelivery).JWT(
+		map[string]any{
+			"iss":             fed.FederationEntity.EntityID(),
+			"sub":             p.Subject,
+			"aud":             p.URL,
+			"iat":             now.Unix(),
+			"exp":             now.Add(trustMarkDeliveryLifetime).Unix(),
+			"jti":             uuid.New().String(),
+			"trust_mark_type": p.TrustMarkType,
+			"trust_mark":      p.TrustMark,
+		},
+	)
+	if err != nil {
+		return errors.Wrap(err, "failed to sign push request")
+	}
+	req, err := http.NewRequest(http.MethodPost, p.URL, strings.NewReader(string(body)))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	req.Header.Set(fiber.HeaderContentType, ContentTypeTrustMarkDelivery)
+	client := &http.Client{Timeout: trustMarkPushHTTPTimeout}
+	if p.SelfPublishedURL {
+		if err = checkSelfPublishedPushURL(p.URL); err != nil {
+			return err
+		}
+		client = publicHTTPSClient(trustMarkPushHTTPTimeout)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, maxTrustMarkPushResponseSize))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return errors.Errorf("subject responded with status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// recordTrustMarkPushEvent adds an entry to the delivery log, i.e. the event
+// history of the trust mark subject.
+func (fed *LightHouse) recordTrustMarkPushEvent(subjectID uint, eventType, message string) {
+	if fed.storages.TrustMarkSubjectEvents == nil || subjectID == 0 {
+		return
+	}
+	if err := fed.storages.TrustMarkSubjectEvents.Add(
+		model.TrustMarkSubjectEvent{
+			TrustMarkSubjectID: subjectID,
+			Timestamp:          time.Now().Unix(),
+			Type:               eventType,
+			Message:            strPtrOrNil(message),
+			Actor:              new(trustMarkPushActor),
+		},
+	); err != nil {
+		log.Warn().Err(err).Uint("trust_mark_subject_id", subjectID).Msg("failed to record trust mark push event")
+	}
+}
@@ -0,0 +1,186 @@
+package lighthouse
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	oidfed "github.com/go-oidfed/lib"
+	"github.com/lestrrat-go/jwx/v4/jws"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/go-oidfed/lighthouse/api/adminapi"
+	"github.com/go-oidfed/lighthouse/storage/model"
+)
+
+func TestPushTrustMarks(t *testing.T) {
+	fed, _ := newTestLightHouse(t)
+	setDelegationTestSigner(t, fed)
+	fed.TrustMarkIssuer = oidfed.NewTrustMarkIssuer(
+		fed.FederationEntity.EntityID(), fed.PurposeSigner(model.SigningPurposeTrustMarks).TrustMarkSigner(), nil,
+	)
+	fed.TrustMarkIssuer.SetProvider(NewDBTrustMarkSpecProvider(fed.storages.TrustMarkSpecs))
+	const (
+		trustMarkType = "https://lighthouse.example.org/tm/push"
+		subject       = "https://rp.example.org"
+	)
+
+	fail := true
+	var received [][]byte
+	subjectServer := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, ContentTypeTrustMarkDelivery, r.Header.Get("Content-Type"))
+				if fail {
+					w.WriteHeader(http.StatusServiceUnavailable)
+					return
+				}
+				body, _ := io.ReadAll(r.Body)
+				received = append(received, body)
+			},
+		),
+	)
+	defer subjectServer.Close()
+
+	_, err := fed.storages.TrustMarkSpecs.Create(
+		&model.AddTrustMarkSpec{
+			TrustMarkType: trustMarkType,
+			Lifetime:      3600,
+			PushDelivery:  true,
+		},
+	)
+	require.NoError(t, err)
+	sub, err := fed.storages.TrustMarkSpecs.CreateSubject(
+		trustMarkType, &model.AddTrustMarkSubject{
+			EntityID: subject,
+			Status:   model.StatusActive,
+			PushURL:  subjectServer.URL,
+		},
+	)
+	require.NoError(t, err)
+
+	// The first delivery fails and is retried later
+	pushes, err := fed.PushTrustMarks("", false)
+	require.NoError(t, err)
+	require.Len(t, pushes, 1)
+	assert.Equal(t, 1, pushes[0].Attempts)
+	assert.Zero(t, pushes[0].DeliveredAt)
+	assert.NotZero(t, pushes[0].NextAttemptAt)
+	assert.Contains(t, pushes[0].Error, "503")
+
+	pending, err := fed.PendingTrustMarkPushes()
+	require.NoError(t, err)
+	require.Len(t, pending, 1)
+	assert.Equal(t, subject, pending[0].Subject)
+
+	// The retry is not due yet and no further trust mark is issued
+	pushes, err = fed.PushTrustMarks("", false)
+	require.NoError(t, err)
+	assert.Empty(t, pushes)
+	instances, err := fed.storages.TrustMarkInstances.ListBySubject(trustMarkType, subject)
+	require.NoError(t, err)
+	require.Len(t, instances, 1)
+
+	fail = false
+	pushes, err = fed.PushTrustMarks(trustMarkType, true)
+	require.NoError(t, err)
+	require.Len(t, pushes, 1)
+	assert.NotZero(t, pushes[0].DeliveredAt)
+	require.Len(t, received, 1)
+
+	pending, err = fed.PendingTrustMarkPushes()
+	require.NoError(t, err)
+	assert.Empty(t, pending)
+
+	// The push request is signed with the federation key
+	jwks, err := fed.GeneralJWTSigner.JWKS()
+	require.NoError(t, err)
+	payload, err := jws.Verify(received[0], jws.WithKeySet(jwks.Set, jws.WithInferAlgorithmFromKey(true)))
+	require.NoError(t, err)
+	var claims map[string]any
+	require.NoError(t, json.Unmarshal(payload, &claims))
+	assert.Equal(t, fed.FederationEntity.EntityID(), claims["iss"])
+	assert.Equal(t, subject, claims["sub"])
+	assert.Equal(t, trustMarkType, claims["trust_mark_type"])
+	assert.NotEmpty(t, claims["trust_mark"])
+
+	// The pushed trust mark is still fresh
+	pushes, err = fed.PushTrustMarks("", false)
+	require.NoError(t, err)
+	assert.Empty(t, pushes)
+
+	events, _, err := fed.storages.TrustMarkSubjectEvents.GetBySubjectID(sub.ID, model.EventQueryOpts{})
+	require.NoError(t, err)
+	types := make([]string, 0, len(events))
+	for _, e := range events {
+		types = append(types, e.Type)
+	}
+	assert.ElementsMatch(
+		t, []string{model.EventTypeTrustMarkPushFailed, model.EventTypeTrustMarkPushed}, types,
+	)
+
+	// Pending pushes are dropped if the subject is no longer active
+	fail = true
+	pushes, err = fed.PushTrustMarks(trustMarkType, true)
+	require.NoError(t, err)
+	require.Len(t, pushes, 1)
+	_, err = fed.storages.TrustMarkSpecs.ChangeSubjectStatus(trustMarkType, subject, model.StatusBlocked)
+	require.NoError(t, err)
+	pushes, err = fed.PushTrustMarks(trustMarkType, true)
+	require.NoError(t, err)
+	assert.Empty(t, pushes)
+	pending, err = fed.PendingTrustMarkPushes()
+	require.NoError(t, err)
+	assert.Empty(t, pending)
+
+	// Subjects failing the eligibility check of the spec get no trust mark
+	fail = false
+	_, err = fed.storages.TrustMarkSpecs.ChangeSubjectStatus(trustMarkType, subject, model.StatusActive)
+	require.NoError(t, err)
+	_, err = fed.storages.TrustMarkSpecs.Patch(
+		"1", map[string]any{
+			"eligibility_config": &model.EligibilityConfig{Mode: model.EligibilityModeCheckOnly},
+		},
+	)
+	require.NoError(t, err)
+	pushes, err = fed.PushTrustMarks(trustMarkType, true)
+	require.NoError(t, err)
+	assert.Empty(t, pushes)
+	assert.Len(t, received, 1)
+}
+
+func TestSendTrustMarkPush_SelfPublishedURL(t *testing.T) {
+	fed, _ := newTestLightHouse(t)
+	setDelegationTestSigner(t, fed)
+	var requests int
+	handler := http.HandlerFunc(func(http.ResponseWriter, *http.Request) { requests++ })
+	plainServer := httptest.NewServer(handler)
+	defer plainServer.Close()
+	tlsServer := httptest.NewTLSServer(handler)
+	defer tlsServer.Close()
+
+	push := func(pushURL string, selfPublished bool) error {
+		return fed.sendTrustMarkPush(
+			&pendingTrustMarkPush{
+				TrustMarkPush: adminapi.TrustMarkPush{
+					TrustMarkType: "https://lighthouse.example.org/tm/push",
+					Subject:       "https://rp.example.org",
+					URL:           pushURL,
+				},
+				TrustMark:        "tm",
+				SelfPublishedURL: selfPublished,
+			},
+		)
+	}
+
+	// Push URLs published by the subject must be https URLs of public hosts
+	assert.ErrorContains(t, push(plainServer.URL, true), "must be an https url")
+	assert.ErrorContains(t, push(tlsServer.URL, true), "not public")
+	assert.Zero(t, requests)
+	// Configured push URLs are trusted
+	require.NoError(t, push(plainServer.URL, false))
+	assert.Equal(t, 1, requests)
+}
@@ -0,0 +1,141 @@
+package lighthouse
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/go-oidfed/lighthouse/storage/model"
+)
+
+// trustMarkReportCountersFlushInterval is the interval in which the buffered
+// trust mark report counters are written to the database.
+const trustMarkReportCountersFlushInterval = 10 * time.Second
+
+// trustMarkReportCounterKey identifies the daily counters of a trust mark
+// type.
+type trustMarkReportCounterKey struct {
+	day           string
+	trustMarkType string
+}
+
+// TrustMarkReportCounters counts cache hits and eligibility denials of the
+// trust mark endpoint in memory and periodically flushes them to the
+// model.TrustMarkReportStore, so counting does not add a database write to
+// each request.
+type TrustMarkReportCounters struct {
+	store    model.TrustMarkReportStore
+	interval time.Duration
+	mu       sync.Mutex
+	counts   map[trustMarkReportCounterKey]*model.TrustMarkIssuanceCounter
+	runner   periodicRunner
+}
+
+// trustMarkReportCounters returns the TrustMarkReportCounters shared by the
+// trust mark endpoints and starts them on first use. It returns nil if no
+// report store is configured.
+func (fed *LightHouse) trustMarkReportCounters() *TrustMarkReportCounters {
+	if fed.storages.TrustMarkReports == nil {
+		return nil
+	}
+	if fed.reportCounters == nil {
+		fed.reportCounters = NewTrustMarkReportCounters(fed.storages.TrustMarkReports, 0)
+		fed.reportCounters.Start()
+	}
+	return fed.reportCounters
+}
+
+// NewTrustMarkReportCounters creates new TrustMarkReportCounters flushing to
+// the passed store. If interval is not positive, the counters are flushed
+// every 10 seconds.
+func NewTrustMarkReportCounters(store model.TrustMarkReportStore, interval time.Duration) *TrustMarkReportCounters {
+	if interval <= 0 {
+		interval = trustMarkReportCountersFlushInterval
+	}
+	return &TrustMarkReportCounters{
+		store:    store,
+		interval: interval,
+		counts:   make(map[trustMarkReportCounterKey]*model.TrustMarkIssuanceCounter),
+	}
+}
+
+// Count increments a counter of a trust mark type for the current day.
+func (c *TrustMarkReportCounters) Count(trustMarkType string, counter model.TrustMarkReportCounter) {
+	key := trustMarkReportCounterKey{
+		day:           time.Now().UTC().Format(model.TrustMarkReportDayFormat),
+		trustMarkType: trustMarkType,
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	row, ok := c.counts[key]
+	if !ok {
+		row = &model.TrustMarkIssuanceCounter{
+			Day:           key.day,
+			TrustMarkType: key.trustMarkType,
+		}
+		c.counts[key] = row
+	}
+	switch counter {
+	case model.TrustMarkReportCounterCacheHits:
+		row.CacheHits++
+	case model.TrustMarkReportCounterEligibilityDenials:
+		row.EligibilityDenials++
+	default:
+		log.Warn().Str("counter", string(counter)).Msg("unknown trust mark report counter")
+	}
+}
+
+// Flush writes the buffered counters to the store. If writing fails, the
+// counters are kept for the next flush.
+func (c *TrustMarkReportCounters) Flush() error {
+	c.mu.Lock()
+	counts := c.counts
+	c.counts = make(map[trustMarkReportCounterKey]*model.TrustMarkIssuanceCounter)
+	c.mu.Unlock()
+	if len(counts) == 0 {
+		return nil
+	}
+	rows := make([]model.TrustMarkIssuanceCounter, 0, len(counts))
+	for _, row := range counts {
+		rows = append(rows, *row)
+	}
+	err := c.store.AddCounters(rows)
+	if err == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, row := range counts {
+		if current, ok := c.counts[key]; ok {
+			current.CacheHits += row.CacheHits
+			current.EligibilityDenials += row.EligibilityDenials
+		} else {
+			c.counts[key] = row
+		}
+	}
+	return err
+}
+
+// Start starts flushing the counters in the background.
+func (c *TrustMarkReportCounters) Start() {
+	c.runner.start(
+		periodicTask{
+			interval: c.interval,
+			run:      func(context.Context) { c.flush() },
+		},
+	)
+}
+
+// Stop stops the background flushing and flushes the remaining counters.
+func (c *TrustMarkReportCounters) Stop() {
+	c.runner.stop()
+	c.flush()
+}
+
+func (c *TrustMarkReportCounters) flush() {
+	if err := c.Flush(); err != nil {
+		log.Warn().Err(err).Msg("failed to flush trust mark report counters")
+	}
+}
@@ -0,0 +1,48 @@
+package lighthouse
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/go-oidfed/lighthouse/storage/model"
+)
+
+type recordingReportStore struct {
+	model.TrustMarkReportStore
+	fail  bool
+	added []model.TrustMarkIssuanceCounter
+}
+
+func (s *recordingReportStore) AddCounters(counters []model.TrustMarkIssuanceCounter) error {
+	if s.fail {
+		return errors.New("database unavailable")
+	}
+	s.added = append(s.added, counters...)
+	return nil
+}
+
+func TestTrustMarkReportCounters(t *testing.T) {
+	const trustMarkType = "https://tm.example.org/a"
+	store := &recordingReportStore{fail: true}
+	counters := NewTrustMarkReportCounters(store, 0)
+	counters.Count(trustMarkType, model.TrustMarkReportCounterCacheHits)
+	counters.Count(trustMarkType, model.TrustMarkReportCounterCacheHits)
+	counters.Count(trustMarkType, model.TrustMarkReportCounterEligibilityDenials)
+
+	// Counters are kept if flushing fails
+	require.Error(t, counters.Flush())
+	counters.Count(trustMarkType, model.TrustMarkReportCounterCacheHits)
+	store.fail = false
+	require.NoError(t, counters.Flush())
+	require.Len(t, store.added, 1)
+	assert.Equal(t, trustMarkType, store.added[0].TrustMarkType)
+	assert.Equal(t, int64(3), store.added[0].CacheHits)
+	assert.Equal(t, int64(1), store.added[0].EligibilityDenials)
+
+	// Nothing is written without new counts
+	require.NoError(t, counters.Flush())
+	assert.Len(t, store.added, 1)
+}
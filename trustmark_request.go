@@ -1,12 +1,18 @@
 package lighthouse
 
 import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"reflect"
 	"slices"
+	"time"
 
 	"github.com/go-oidfed/lib/jwx"
 	"github.com/go-oidfed/lib/oidfedconst"
 	"github.com/gofiber/fiber/v2"
 	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
 
 	"github.com/go-oidfed/lib"
 
@@ -14,11 +20,38 @@ import (
 	"github.com/go-oidfed/lighthouse/storage/model"
 )
 
+// Limits for the evidence and attachments submitted with trust mark requests.
+// They apply to the accumulated evidence and attachments of a request.
+const (
+	maxTrustMarkRequestEvidenceLength   = 16 * 1024
+	maxTrustMarkRequestAttachments      = 10
+	maxTrustMarkRequestAttachmentLength = 2048
+)
+
+type trustMarkRequestRequest struct {
+	trustMarkQueryRequest
+	// Evidence is a JSON object with free-form evidence for the request.
+	Evidence string `json:"evidence" form:"evidence" query:"evidence"`
+	// Attachments are references (e.g. URLs) to documents supporting the
+	// request.
+	Attachments []string `json:"attachment" form:"attachment" query:"attachment"`
+}
+
+// trustMarkRequestResponse is returned by the trust mark request endpoint if
+// a request is pending.
+type trustMarkRequestResponse struct {
+	RequestID uint                         `json:"request_id"`
+	Status    model.TrustMarkRequestStatus `json:"status"`
+}
+
 // AddTrustMarkRequestEndpoint adds an endpoint where entities can request to
-// be entitled for a trust mark
+// be entitled for a trust mark. If requests is not nil, each request is
+// recorded together with the submitted evidence, so it can be reviewed
+// through the admin API.
 func (fed *LightHouse) AddTrustMarkRequestEndpoint(
 	endpoint EndpointConf,
 	store model.TrustMarkedEntitiesStorageBackend,
+	requests model.TrustMarkRequestStore,
 ) error {
 	if fed.fedMetadata.Extra == nil {
 		fed.fedMetadata.Extra = make(map[string]any)
@@ -28,11 +61,26 @@ func (fed *LightHouse) AddTrustMarkRequestEndpoint(
 		return nil
 	}
 	handler := func(ctx *fiber.Ctx) error {
-		var req trustMarkQueryRequest
+		var req trustMarkRequestRequest
 		if err := parseRequest(ctx, &req); err != nil {
 			ctx.Status(fiber.StatusBadRequest)
 			return ctx.JSON(oidfed.ErrorInvalidRequest("could not parse request parameters: " + err.Error()))
 		}
+		var evidence map[string]any
+		if req.Evidence != "" {
+			if len(req.Evidence) > maxTrustMarkRequestEvidenceLength {
+				ctx.Status(fiber.StatusBadRequest)
+				return ctx.JSON(oidfed.ErrorInvalidRequest("parameter 'evidence' is too long"))
+			}
+			if err := json.Unmarshal([]byte(req.Evidence), &evidence); err != nil {
+				ctx.Status(fiber.StatusBadRequest)
+				return ctx.JSON(oidfed.ErrorInvalidRequest("parameter 'evidence' must be a JSON object"))
+			}
+		}
+		if err := validateTrustMarkRequestAttachments(req.Attachments); err != nil {
+			ctx.Status(fiber.StatusBadRequest)
+			return ctx.JSON(oidfed.ErrorInvalidRequest(err.Error()))
+		}
 		if req.Subject == "" {
 			ctx.Status(fiber.StatusBadRequest)
 			return ctx.JSON(
@@ -49,6 +97,16 @@ func (fed *LightHouse) AddTrustMarkRequestEndpoint(
 				),
 			)
 		}
+		if evidence != nil || len(req.Attachments) > 0 {
+			if clientID, _ := ctx.Locals("client_entity_id").(string); clientID != req.Subject {
+				ctx.Status(fiber.StatusForbidden)
+				return ctx.JSON(
+					oidfed.ErrorInvalidRequest(
+						"evidence and attachments can only be submitted by the authenticated subject",
+					),
+				)
+			}
+		}
 		if !slices.Contains(
 			fed.TrustMarkIssuer.TrustMarkTypes(),
 			req.TrustMarkType,
@@ -72,8 +130,6 @@ func (fed *LightHouse) AddTrustMarkRequestEndpoint(
 			ctx.Status(fiber.StatusForbidden)
 			return ctx.JSON(oidfed.ErrorInvalidRequest("subject cannot obtain this trust mark"))
 		case model.StatusPending:
-			ctx.Status(fiber.StatusAccepted)
-			return nil
 		case model.StatusInactive:
 			fallthrough
 		default:
@@ -81,9 +137,28 @@ func (fed *LightHouse) AddTrustMarkRequestEndpoint(
 				ctx.Status(fiber.StatusInternalServerError)
 				return ctx.JSON(oidfed.ErrorServerError(err.Error()))
 			}
+		}
+		if requests == nil {
 			ctx.Status(fiber.StatusAccepted)
 			return nil
 		}
+		request, err := fed.recordTrustMarkRequest(ctx, requests, req.trustMarkQueryRequest, evidence, req.Attachments)
+		if err != nil {
+			var validationErr model.ValidationError
+			if errors.As(err, &validationErr) {
+				ctx.Status(fiber.StatusBadRequest)
+				return ctx.JSON(oidfed.ErrorInvalidRequest(validationErr.Error()))
+			}
+			ctx.Status(fiber.StatusInternalServerError)
+			return ctx.JSON(oidfed.ErrorServerError(err.Error()))
+		}
+		ctx.Status(fiber.StatusAccepted)
+		return ctx.JSON(
+			trustMarkRequestResponse{
+				RequestID: request.ID,
+				Status:    request.Status,
+			},
+		)
 	}
 
 	if endpoint.AuthEnabled {
@@ -112,3 +187,132 @@ func (fed *LightHouse) AddTrustMarkRequestEndpoint(
 
 	return nil
 }
+
+// validateTrustMarkRequestAttachments checks the number and length of
+// attachments.
+func validateTrustMarkRequestAttachments(attachments []string) error {
+	if len(attachments) > maxTrustMarkRequestAttachments {
+		return model.ValidationErrorFmt("at most %d attachments are allowed", maxTrustMarkRequestAttachments)
+	}
+	for _, a := range attachments {
+		if len(a) > maxTrustMarkRequestAttachmentLength {
+			return model.ValidationError("parameter 'attachment' is too long")
+		}
+	}
+	return nil
+}
+
+// recordTrustMarkRequest returns the pending TrustMarkRequest for the
+// requested trust mark type and subject, or creates one. Evidence and
+// attachments submitted with a repeated request are appended to those of the
+// pending request; previously submitted evidence is never replaced.
+func (fed *LightHouse) recordTrustMarkRequest(
+	ctx *fiber.Ctx,
+	requests model.TrustMarkRequestStore,
+	req trustMarkQueryRequest,
+	evidence map[string]any,
+	attachments []string,
+) (*model.TrustMarkRequest, error) {
+	request, err := requests.Latest(req.TrustMarkType, req.Subject)
+	if err != nil {
+		return nil, err
+	}
+	if request != nil && request.Status == model.TrustMarkRequestStatusPending {
+		if evidence == nil && len(attachments) == 0 {
+			return request, nil
+		}
+		merged, err := appendTrustMarkRequestEvidence(request, evidence, attachments)
+		if err != nil {
+			return nil, err
+		}
+		if err = requests.UpdateEvidence(request.ID, merged.Evidence, merged.Attachments); err != nil {
+			return nil, err
+		}
+		request.Evidence = merged.Evidence
+		request.Attachments = merged.Attachments
+		return request, nil
+	}
+	request = &model.TrustMarkRequest{
+		TrustMarkType: req.TrustMarkType,
+		EntityID:      req.Subject,
+		Status:        model.TrustMarkRequestStatusPending,
+		Evidence:      evidence,
+		Attachments:   attachments,
+	}
+	if requestedBy, ok := ctx.Locals("client_entity_id").(string); ok {
+		request.RequestedBy = requestedBy
+	}
+	if err = requests.Create(request); err != nil {
+		return nil, err
+	}
+	fed.recordTrustMarkRequestedEvent(request)
+	return request, nil
+}
+
+// appendTrustMarkRequestEvidence returns the evidence and attachments of
+// request with evidence and attachments appended. Evidence keys that were
+// already submitted cannot be changed, and the accumulated evidence and
+// attachments must stay within the request limits.
+func appendTrustMarkRequestEvidence(
+	request *model.TrustMarkRequest, evidence map[string]any, attachments []string,
+) (*model.TrustMarkRequest, error) {
+	merged := &model.TrustMarkRequest{
+		Evidence:    maps.Clone(request.Evidence),
+		Attachments: slices.Clone(request.Attachments),
+	}
+	if len(evidence) > 0 && merged.Evidence == nil {
+		merged.Evidence = make(map[string]any, len(evidence))
+	}
+	for k, v := range evidence {
+		if old, ok := merged.Evidence[k]; ok {
+			if !reflect.DeepEqual(old, v) {
+				return nil, model.ValidationErrorFmt("evidence '%s' was already submitted", k)
+			}
+			continue
+		}
+		merged.Evidence[k] = v
+	}
+	for _, a := range attachments {
+		if !slices.Contains(merged.Attachments, a) {
+			merged.Attachments = append(merged.Attachments, a)
+		}
+	}
+	if err := validateTrustMarkRequestAttachments(merged.Attachments); err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(merged.Evidence)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal evidence")
+	}
+	if len(data) > maxTrustMarkRequestEvidenceLength {
+		return nil, model.ValidationError("evidence is too long")
+	}
+	return merged, nil
+}
+
+// recordTrustMarkRequestedEvent records the trust_mark_requested event for
+// the TrustMarkSubject of a new request.
+func (fed *LightHouse) recordTrustMarkRequestedEvent(request *model.TrustMarkRequest) {
+	if fed.storages.TrustMarkSpecs == nil || fed.storages.TrustMarkSubjectEvents == nil {
+		return
+	}
+	subject, err := fed.storages.TrustMarkSpecs.GetSubject(request.TrustMarkType, request.EntityID)
+	if err != nil {
+		log.Warn().Err(err).Str("trust_mark_type", request.TrustMarkType).Str("entity_id", request.EntityID).
+			Msg("failed to look up trust mark subject for request event")
+		return
+	}
+	status := string(request.Status)
+	message := fmt.Sprintf("trust mark request %d", request.ID)
+	if err = fed.storages.TrustMarkSubjectEvents.Add(
+		model.TrustMarkSubjectEvent{
+			TrustMarkSubjectID: subject.ID,
+			Timestamp:          time.Now().Unix(),
+			Type:               model.EventTypeTrustMarkRequested,
+			Status:             &status,
+			Message:            &message,
+		},
+	); err != nil {
+		log.Warn().Err(err).Uint("trust_mark_subject_id", subject.ID).Msg("failed to record trust_mark_requested event")
+	}
+}
@@ -0,0 +1,166 @@
+package lighthouse
+
+import (
+	"strconv"
+	"time"
+
+	oidfed "github.com/go-oidfed/lib"
+	"github.com/go-oidfed/lib/jwx"
+	"github.com/go-oidfed/lib/oidfedconst"
+	"github.com/gofiber/fiber/v2"
+	"github.com/pkg/errors"
+
+	"github.com/go-oidfed/lighthouse/middleware"
+	"github.com/go-oidfed/lighthouse/storage/model"
+)
+
+const (
+	// TrustMarkRequestStatusEndpointMetadataKey is the federation_entity
+	// metadata claim under which the trust mark request status endpoint is
+	// published.
+	TrustMarkRequestStatusEndpointMetadataKey = "federation_trust_mark_request_status_endpoint"
+	// JWTTypeTrustMarkRequestStatus is the JWT type of trust mark request
+	// status responses.
+	JWTTypeTrustMarkRequestStatus = "trust-mark-request-status+jwt"
+	// ContentTypeTrustMarkRequestStatus is the media type of trust mark
+	// request status responses.
+	ContentTypeTrustMarkRequestStatus = "application/trust-mark-request-status+jwt"
+)
+
+type trustMarkRequestStatusRequest struct {
+	trustMarkQueryRequest
+	RequestID string `json:"request_id" form:"request_id" query:"request_id"`
+}
+
+// TrustMarkRequestStatusResponse is the JWT payload of a trust mark request
+// status response.
+type TrustMarkRequestStatusResponse struct {
+	Issuer         string                       `json:"iss"`
+	Subject        string                       `json:"sub"`
+	IssuedAt       int64                        `json:"iat"`
+	TrustMarkType  string                       `json:"trust_mark_type"`
+	RequestID      uint                         `json:"request_id"`
+	Status         model.TrustMarkRequestStatus `json:"status"`
+	RequestedAt    int64                        `json:"requested_at"`
+	DecidedAt      int64                        `json:"decided_at,omitempty"`
+	DecisionReason string                       `json:"decision_reason,omitempty"`
+}
+
+// AddTrustMarkRequestStatusEndpoint adds an endpoint where entities can query
+// the status of their trust mark requests. The endpoint takes the parameters
+// sub and trust_mark_type (and optionally request_id; otherwise the latest
+// request is used) and returns a JWT signed with the federation key and media
+// type application/trust-mark-request-status+jwt. Reviewer comments are not
+// included.
+func (fed *LightHouse) AddTrustMarkRequestStatusEndpoint(
+	endpoint EndpointConf,
+	requests model.TrustMarkRequestStore,
+) error {
+	if fed.fedMetadata.Extra == nil {
+		fed.fedMetadata.Extra = make(map[string]any)
+	}
+	fed.fedMetadata.Extra[TrustMarkRequestStatusEndpointMetadataKey] = endpoint.ValidateURL(
+		fed.FederationEntity.EntityID(),
+	)
+	if endpoint.Path == "" {
+		return nil
+	}
+	if requests == nil {
+		return errors.New("trust mark request status endpoint requires a trust mark request store")
+	}
+	handler := func(ctx *fiber.Ctx) error {
+		var req trustMarkRequestStatusRequest
+		if err := parseRequest(ctx, &req); err != nil {
+			ctx.Status(fiber.StatusBadRequest)
+			return ctx.JSON(oidfed.ErrorInvalidRequest("could not parse request parameters: " + err.Error()))
+		}
+		if req.Subject == "" {
+			ctx.Status(fiber.StatusBadRequest)
+			return ctx.JSON(oidfed.ErrorInvalidRequest("required parameter 'sub' not given"))
+		}
+		if req.TrustMarkType == "" {
+			ctx.Status(fiber.StatusBadRequest)
+			return ctx.JSON(oidfed.ErrorInvalidRequest("required parameter 'trust_mark_type' not given"))
+		}
+		request, err := findTrustMarkRequest(requests, req)
+		if err != nil {
+			var notFound model.NotFoundError
+			if errors.As(err, &notFound) {
+				ctx.Status(fiber.StatusNotFound)
+				return ctx.JSON(oidfed.ErrorNotFound("trust mark request not found"))
+			}
+			ctx.Status(fiber.StatusInternalServerError)
+			return ctx.JSON(oidfed.ErrorServerError(err.Error()))
+		}
+		signed, err := fed.GeneralJWTSigner.Typed(JWTTypeTrustMarkRequestStatus).JWT(
+			TrustMarkRequestStatusResponse{
+				Issuer:         fed.FederationEntity.EntityID(),
+				Subject:        request.EntityID,
+				IssuedAt:       time.Now().Unix(),
+				TrustMarkType:  request.TrustMarkType,
+				RequestID:      request.ID,
+				Status:         request.Status,
+				RequestedAt:    int64(request.CreatedAt),
+				DecidedAt:      request.DecidedAt,
+				DecisionReason: request.DecisionReason,
+			},
+		)
+		if err != nil {
+			ctx.Status(fiber.StatusInternalServerError)
+			return ctx.JSON(oidfed.ErrorServerError("failed to sign response: " + err.Error()))
+		}
+		ctx.Set(fiber.HeaderContentType, ContentTypeTrustMarkRequestStatus)
+		return ctx.Send(signed)
+	}
+
+	if endpoint.AuthEnabled {
+		auth, err := middleware.NewPrivateKeyJWTAuth(
+			fed.FederationEntity.EntityID(),
+			fed.FederationEntity,
+			endpoint.AuthTrustAnchors,
+			fed.TAResolver(),
+			fed.storages.JTI,
+		)
+		if err != nil {
+			return errors.Wrap(err, "failed to create auth middleware for trust mark request status endpoint")
+		}
+
+		fed.registerEndpoint(
+			model.EndpointTypeTrustMarkRequestStatus, endpoint.Path, fiber.MethodPost, handler, auth.Middleware(),
+		)
+		fed.fedMetadata.Extra["federation_trust_mark_request_status_endpoint_auth_methods"] = []string{oidfedconst.AuthMethodPrivateKeyJWT}
+		fed.fedMetadata.EndpointAuthSigningAlgValuesSupported = jwx.SupportedAlgsStrings()
+	} else {
+		fed.registerEndpoint(model.EndpointTypeTrustMarkRequestStatus, endpoint.Path, fiber.MethodGet, handler, nil)
+	}
+	return nil
+}
+
+// findTrustMarkRequest returns the requested TrustMarkRequest, which must
+// belong to the passed subject and trust mark type.
+func findTrustMarkRequest(
+	requests model.TrustMarkRequestStore, req trustMarkRequestStatusRequest,
+) (*model.TrustMarkRequest, error) {
+	if req.RequestID == "" {
+		request, err := requests.Latest(req.TrustMarkType, req.Subject)
+		if err != nil {
+			return nil, err
+		}
+		if request == nil {
+			return nil, model.NotFoundError("trust mark request not found")
+		}
+		return request, nil
+	}
+	id, err := strconv.ParseUint(req.RequestID, 10, 64)
+	if err != nil {
+		return nil, model.NotFoundError("trust mark request not found")
+	}
+	request, err := requests.Get(uint(id))
+	if err != nil {
+		return nil, err
+	}
+	if request.EntityID != req.Subject || request.TrustMarkType != req.TrustMarkType {
+		return nil, model.NotFoundError("trust mark request not found")
+	}
+	return request, nil
+}
@@ -0,0 +1,217 @@
+package lighthouse
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+
+	oidfed "github.com/go-oidfed/lib"
+	"github.com/gofiber/fiber/v2"
+	"github.com/lestrrat-go/jwx/v4/jws"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/go-oidfed/lighthouse/storage"
+	"github.com/go-oidfed/lighthouse/storage/model"
+)
+
+func TestTrustMarkRequestWorkflow(t *testing.T) {
+	fed, store := newTestLightHouse(t)
+	setDelegationTestSigner(t, fed)
+	fed.storages.TrustMarkRequests = storage.NewTrustMarkRequestsStorage(store.DB())
+	fed.TrustMarkIssuer = oidfed.NewTrustMarkIssuer(
+		fed.FederationEntity.EntityID(), fed.PurposeSigner(model.SigningPurposeTrustMarks).TrustMarkSigner(), nil,
+	)
+	fed.TrustMarkIssuer.SetProvider(NewDBTrustMarkSpecProvider(fed.storages.TrustMarkSpecs))
+	const (
+		trustMarkType = "https://lighthouse.example.org/tm/requested"
+		subject       = "https://rp.example.org"
+	)
+	_, err := fed.storages.TrustMarkSpecs.Create(&model.AddTrustMarkSpec{TrustMarkType: trustMarkType})
+	require.NoError(t, err)
+
+	require.NoError(
+		t, fed.AddTrustMarkRequestEndpoint(
+			EndpointConf{Path: "/request"}, fed.storages.TrustMarks, fed.storages.TrustMarkRequests,
+		),
+	)
+	require.NoError(
+		t, fed.AddTrustMarkRequestStatusEndpoint(
+			EndpointConf{Path: "/request-status"}, fed.storages.TrustMarkRequests,
+		),
+	)
+	app := fiber.New(fiber.Config{ReadBufferSize: 2 * maxTrustMarkRequestEvidenceLength})
+	// Stands in for the private_key_jwt client authentication
+	app.Use(
+		func(ctx *fiber.Ctx) error {
+			if client := ctx.Get("X-Client-Entity-ID"); client != "" {
+				ctx.Locals("client_entity_id", client)
+			}
+			return ctx.Next()
+		},
+	)
+	app.All("/*", fed.dispatch)
+	getAs := func(client, path string, params url.Values) (*http.Response, []byte) {
+		req := httptest.NewRequest(http.MethodGet, path+"?"+params.Encode(), nil)
+		if client != "" {
+			req.Header.Set("X-Client-Entity-ID", client)
+		}
+		resp, err := app.Test(req, -1)
+		require.NoError(t, err)
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp, body
+	}
+	get := func(path string, params url.Values) (*http.Response, []byte) {
+		return getAs(subject, path, params)
+	}
+	status := func() TrustMarkRequestStatusResponse {
+		resp, body := get("/request-status", url.Values{"sub": {subject}, "trust_mark_type": {trustMarkType}})
+		require.Equal(t, http.StatusOK, resp.StatusCode, string(body))
+		assert.Equal(t, ContentTypeTrustMarkRequestStatus, resp.Header.Get("Content-Type"))
+		msg, err := jws.Parse(body)
+		require.NoError(t, err)
+		var payload TrustMarkRequestStatusResponse
+		require.NoError(t, json.Unmarshal(msg.Payload(), &payload))
+		return payload
+	}
+
+	t.Run("NoRequest", func(t *testing.T) {
+		resp, _ := get("/request-status", url.Values{"sub": {subject}, "trust_mark_type": {trustMarkType}})
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
+
+	t.Run("InvalidEvidence", func(t *testing.T) {
+		resp, _ := get(
+			"/request", url.Values{"sub": {subject}, "trust_mark_type": {trustMarkType}, "evidence": {"[1"}},
+		)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+		attachments := make([]string, maxTrustMarkRequestAttachments+1)
+		for i := range attachments {
+			attachments[i] = "https://rp.example.org/" + strconv.Itoa(i)
+		}
+		resp, _ = get(
+			"/request", url.Values{"sub": {subject}, "trust_mark_type": {trustMarkType}, "attachment": attachments},
+		)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+		resp, _ = get(
+			"/request", url.Values{
+				"sub":             {subject},
+				"trust_mark_type": {trustMarkType},
+				"evidence":        {`{"a":"` + strings.Repeat("a", maxTrustMarkRequestEvidenceLength) + `"}`},
+			},
+		)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+
+	t.Run("EvidenceFromOtherEntity", func(t *testing.T) {
+		params := url.Values{
+			"sub":             {subject},
+			"trust_mark_type": {trustMarkType},
+			"evidence":        {`{"registration":"forged"}`},
+		}
+		resp, _ := getAs("", "/request", params)
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+		resp, _ = getAs("https://other.example.org", "/request", params)
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+		request, err := fed.storages.TrustMarkRequests.Latest(trustMarkType, subject)
+		require.NoError(t, err)
+		assert.Nil(t, request)
+	})
+
+	var requestID uint
+	t.Run("Request", func(t *testing.T) {
+		resp, body := get(
+			"/request", url.Values{
+				"sub":             {subject},
+				"trust_mark_type": {trustMarkType},
+				"evidence":        {`{"registration":"HRB 1234"}`},
+				"attachment":      {"https://rp.example.org/cert.pdf"},
+			},
+		)
+		require.Equal(t, http.StatusAccepted, resp.StatusCode, string(body))
+		var res trustMarkRequestResponse
+		require.NoError(t, json.Unmarshal(body, &res))
+		assert.Equal(t, model.TrustMarkRequestStatusPending, res.Status)
+		requestID = res.RequestID
+
+		request, err := fed.storages.TrustMarkRequests.Get(requestID)
+		require.NoError(t, err)
+		assert.Equal(t, map[string]any{"registration": "HRB 1234"}, request.Evidence)
+		assert.Equal(t, []string{"https://rp.example.org/cert.pdf"}, request.Attachments)
+		tmStatus, err := fed.storages.TrustMarks.TrustMarkedStatus(trustMarkType, subject)
+		require.NoError(t, err)
+		assert.Equal(t, model.StatusPending, tmStatus)
+
+		// A repeated request appends to the pending request
+		resp, body = get(
+			"/request", url.Values{
+				"sub":             {subject},
+				"trust_mark_type": {trustMarkType},
+				"evidence":        {`{"vat_id":"DE123"}`},
+				"attachment":      {"https://rp.example.org/cert.pdf", "https://rp.example.org/vat.pdf"},
+			},
+		)
+		require.Equal(t, http.StatusAccepted, resp.StatusCode, string(body))
+		require.NoError(t, json.Unmarshal(body, &res))
+		assert.Equal(t, requestID, res.RequestID)
+		request, err = fed.storages.TrustMarkRequests.Get(requestID)
+		require.NoError(t, err)
+		assert.Equal(t, map[string]any{"registration": "HRB 1234", "vat_id": "DE123"}, request.Evidence)
+		assert.Equal(
+			t, []string{"https://rp.example.org/cert.pdf", "https://rp.example.org/vat.pdf"}, request.Attachments,
+		)
+
+		// Submitted evidence cannot be replaced
+		resp, _ = get(
+			"/request", url.Values{
+				"sub":             {subject},
+				"trust_mark_type": {trustMarkType},
+				"evidence":        {`{"registration":"HRB 5678"}`},
+			},
+		)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+		request, err = fed.storages.TrustMarkRequests.Get(requestID)
+		require.NoError(t, err)
+		assert.Equal(t, "HRB 1234", request.Evidence["registration"])
+	})
+
+	t.Run("PendingStatus", func(t *testing.T) {
+		payload := status()
+		assert.Equal(t, fed.FederationEntity.EntityID(), payload.Issuer)
+		assert.Equal(t, subject, payload.Subject)
+		assert.Equal(t, requestID, payload.RequestID)
+		assert.Equal(t, model.TrustMarkRequestStatusPending, payload.Status)
+	})
+
+	t.Run("DecidedStatus", func(t *testing.T) {
+		_, err := fed.storages.TrustMarkRequests.Decide(
+			requestID, model.TrustMarkRequestStatusRejected, "registration could not be verified", "admin",
+		)
+		require.NoError(t, err)
+		payload := status()
+		assert.Equal(t, model.TrustMarkRequestStatusRejected, payload.Status)
+		assert.Equal(t, "registration could not be verified", payload.DecisionReason)
+		assert.NotZero(t, payload.DecidedAt)
+
+		_, err = fed.storages.TrustMarkRequests.Decide(requestID, model.TrustMarkRequestStatusApproved, "", "admin")
+		assert.Error(t, err)
+	})
+
+	t.Run("WrongSubject", func(t *testing.T) {
+		resp, _ := get(
+			"/request-status", url.Values{
+				"sub":             {"https://other.example.org"},
+				"trust_mark_type": {trustMarkType},
+				"request_id":      {"1"},
+			},
+		)
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
+}
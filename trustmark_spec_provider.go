@@ -18,7 +18,8 @@ func secondsToDurationOption(seconds uint) duration.DurationOption {
 // by fetching TrustMarkSpecs from the database.
 // It is safe for concurrent use as it delegates to the thread-safe storage layer.
 type DBTrustMarkSpecProvider struct {
-	store model.TrustMarkSpecStore
+	store   model.TrustMarkSpecStore
+	logoURI func(string) string
 }
 
 // NewDBTrustMarkSpecProvider creates a new DBTrustMarkSpecProvider.
@@ -26,6 +27,12 @@ func NewDBTrustMarkSpecProvider(store model.TrustMarkSpecStore) *DBTrustMarkSpec
 	return &DBTrustMarkSpecProvider{store: store}
 }
 
+// SetLogoURIRewriter sets a function that rewrites the logo URIs of the trust
+// mark specs, e.g. LightHouse.HostedLogoURI.
+func (p *DBTrustMarkSpecProvider) SetLogoURIRewriter(rewrite func(string) string) {
+	p.logoURI = rewrite
+}
+
 // GetTrustMarkSpec returns the TrustMarkSpec for the given trust mark type.
 // Returns nil if the trust mark type is not found.
 func (p *DBTrustMarkSpecProvider) GetTrustMarkSpec(trustMarkType string) *oidfed.TrustMarkSpec {
@@ -36,11 +43,15 @@ func (p *DBTrustMarkSpecProvider) GetTrustMarkSpec(trustMarkType string) *oidfed
 	if err != nil {
 		return nil
 	}
+	logoURI := spec.LogoURI
+	if p.logoURI != nil {
+		logoURI = p.logoURI(logoURI)
+	}
 	return &oidfed.TrustMarkSpec{
 		TrustMarkType: spec.TrustMarkType,
 		Lifetime:      secondsToDurationOption(spec.Lifetime),
 		Ref:           spec.Ref,
-		LogoURI:       spec.LogoURI,
+		LogoURI:       logoURI,
 		DelegationJWT: spec.DelegationJWT,
 		Extra:         spec.AdditionalClaims,
 	}
@@ -93,12 +93,17 @@ func (fed *LightHouse) handleTrustMarkStatusRequest(
 		ctx.Status(fiber.StatusBadRequest)
 		return ctx.JSON(oidfed.ErrorInvalidRequest("required parameter 'trust_mark' not given"))
 	}
+	algs, err := fed.negotiateSigningAlg(model.SigningPurposeTrustMarks, requestedSigningAlgs(ctx))
+	if err != nil {
+		ctx.Status(fiber.StatusBadRequest)
+		return ctx.JSON(oidfed.ErrorInvalidRequest(err.Error()))
+	}
 
 	// Parse and validate the trust mark JWT
 	status, err := fed.determineTrustMarkStatus(req.TrustMark, config)
 	if err != nil {
 		// If we can't parse the trust mark at all, it's invalid
-		return fed.sendTrustMarkStatusResponse(ctx, req.TrustMark, model.TrustMarkStatusInvalid)
+		return fed.sendTrustMarkStatusResponse(ctx, req.TrustMark, model.TrustMarkStatusInvalid, algs)
 	}
 
 	// If the trust mark is not found (unknown JTI), return 404
@@ -107,7 +112,7 @@ func (fed *LightHouse) handleTrustMarkStatusRequest(
 		return ctx.JSON(oidfed.ErrorNotFound("trust mark not found"))
 	}
 
-	return fed.sendTrustMarkStatusResponse(ctx, req.TrustMark, status)
+	return fed.sendTrustMarkStatusResponse(ctx, req.TrustMark, status, algs)
 }
 
 // determineTrustMarkStatus parses the trust mark JWT and determines its status
@@ -126,7 +131,8 @@ func (fed *LightHouse) determineTrustMarkStatus(
 		return model.TrustMarkStatusInvalid, nil
 	}
 
-	// Verify the signature using our federation keys
+	// Verify the signature using our published keys; they include the trust
+	// mark keys and the federation keys used for earlier trust marks
 	// Get the signer to access the JWKS for verification
 	signer := fed.GeneralJWTSigner.TrustMarkSigner()
 	if signer == nil {
@@ -186,11 +192,13 @@ func (fed *LightHouse) determineTrustMarkStatus(
 	return status, nil
 }
 
-// sendTrustMarkStatusResponse creates and sends a signed trust mark status response JWT
+// sendTrustMarkStatusResponse creates and sends a signed trust mark status
+// response JWT. If algs is empty, the default signing algorithm is used.
 func (fed *LightHouse) sendTrustMarkStatusResponse(
 	ctx *fiber.Ctx,
 	trustMarkJWT string,
 	status model.TrustMarkInstanceStatus,
+	algs []string,
 ) error {
 	// Build the response payload
 	response := TrustMarkStatusResponse{
@@ -200,8 +208,10 @@ func (fed *LightHouse) sendTrustMarkStatusResponse(
 		Status:    string(status),
 	}
 
-	// Sign the response using our general JWT signer with the correct type header
-	signedJWT, err := fed.GeneralJWTSigner.JWT(response, oidfedconst.JWTTypeTrustMarkStatusResponse)
+	// Sign the response using the trust mark keys with the correct type header
+	signedJWT, err := fed.PurposeSigner(model.SigningPurposeTrustMarks).JWT(
+		response, oidfedconst.JWTTypeTrustMarkStatusResponse, algs...,
+	)
 	if err != nil {
 		ctx.Status(fiber.StatusInternalServerError)
 		return ctx.JSON(oidfed.ErrorServerError("failed to sign response: " + err.Error()))